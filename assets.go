@@ -0,0 +1,33 @@
+package stripego
+
+import (
+	"embed"
+	"io/fs"
+
+	"stripe_go/internal/service"
+)
+
+// The HTML client and the email templates are compiled into the binary, so
+// it runs from any working directory. STATIC_DIR and EMAIL_TEMPLATE_DIR
+// serve them from disk instead, which picks up edits without a rebuild.
+var (
+	//go:embed html
+	embeddedStatic embed.FS
+	//go:embed templates/email
+	embeddedEmailTemplates embed.FS
+)
+
+// embeddedAssets returns the assets compiled into the binary.
+func embeddedAssets() service.Assets {
+	return service.Assets{Static: embeddedSub(embeddedStatic, "html"), EmailTemplates: embeddedSub(embeddedEmailTemplates, "templates/email")}
+}
+
+// embeddedSub returns the sub tree dir of embedded.
+func embeddedSub(embedded embed.FS, dir string) fs.FS {
+	fsys, err := fs.Sub(embedded, dir)
+	if err != nil {
+		// dir is a constant directory embedded above.
+		panic(err)
+	}
+	return fsys
+}
@@ -0,0 +1,288 @@
+package stripego
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/httpapi"
+	"stripe_go/internal/service"
+	"stripe_go/internal/store"
+)
+
+// cliCommand is an admin subcommand of the binary. Apart from check-config,
+// which checks the local configuration, they call the admin API of a running
+// instance, so they go through the same validation, confirmations and audit
+// log as any other admin request.
+type cliCommand struct {
+	usage string
+	run   func(c *adminClient, flags *flag.FlagSet, args []string) error
+}
+
+var cliCommands = map[string]cliCommand{
+	"payments":           {"payments [-status paid] [-limit 20]: list recent payments", runPaymentsCommand},
+	"refund":             {"refund [-amount 500] [-reason requested_by_customer] <payment_intent>: refund a payment", runRefundCommand},
+	"resend-receipt":     {"resend-receipt <session_or_payment_intent>: send the confirmation email again", runResendReceiptCommand},
+	"replay-event":       {"replay-event <event>: run a Stripe event through the webhook handlers again", runReplayEventCommand},
+	"rebuild-projection": {"rebuild-projection [-dry-run] <projection>: refold a projection, such as orders, from the event log", runRebuildProjectionCommand},
+	"check-config":       {"check-config: check the configuration, catalog and database without serving", runCheckConfigCommand},
+}
+
+func cliCommandNames() []string {
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runCommand runs the subcommand name, writing its output to out. Every
+// command but check-config takes -url, the instance's base URL (ADMIN_URL,
+// or the local PORT by default), -token, an admin credential (ADMIN_TOKEN
+// by default), and -confirm, a confirmation token for live mode operations
+// that need one.
+func runCommand(name string, args []string, out io.Writer) error {
+	cmd := cliCommands[name]
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() {
+		fmt.Fprintln(out, "usage: "+cmd.usage)
+		flags.PrintDefaults()
+	}
+	c := &adminClient{out: out, http: &http.Client{Timeout: time.Minute}}
+	if name != "check-config" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "4242"
+		}
+		defaultURL := os.Getenv("ADMIN_URL")
+		if defaultURL == "" {
+			defaultURL = "http://" + net.JoinHostPort("localhost", port)
+		}
+		flags.StringVar(&c.baseURL, "url", defaultURL, "base URL of the running service")
+		flags.StringVar(&c.token, "token", os.Getenv("ADMIN_TOKEN"), "admin bearer token")
+		flags.StringVar(&c.confirm, "confirm", "", "confirmation token, for operations that need one in live mode")
+	}
+	return cmd.run(c, flags, args)
+}
+
+// adminClient calls the admin API of a running instance.
+type adminClient struct {
+	baseURL string
+	token   string
+	confirm string
+	http    *http.Client
+	out     io.Writer
+}
+
+// do sends body as JSON and decodes the response into v. Error responses
+// are returned as errors with the service's message; a 428 also names the
+// confirmation token to repeat the command with.
+func (c *adminClient) do(method, path string, body, v interface{}) error {
+	if c.token == "" {
+		return errors.New("no admin token: set ADMIN_TOKEN or pass -token")
+	}
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.baseURL, "/")+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.confirm != "" {
+		req.Header.Set(config.ConfirmationHeader, c.confirm)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error        *service.ErrorResponseMessage `json:"error"`
+			Confirmation *service.Confirmation         `json:"confirmation"`
+		}
+		if json.Unmarshal(data, &e) != nil || e.Error == nil {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		if e.Confirmation != nil {
+			return fmt.Errorf("%s; repeat the command with -confirm %s before %s", e.Error.Message,
+				e.Confirmation.Token, e.Confirmation.ExpiresAt.Local().Format(time.Kitchen))
+		}
+		return fmt.Errorf("%s (%s)", e.Error.Message, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// oneArg parses flags and returns the single positional argument.
+func oneArg(flags *flag.FlagSet, args []string, what string) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return "", fmt.Errorf("want one %s", what)
+	}
+	return flags.Arg(0), nil
+}
+
+func runPaymentsCommand(c *adminClient, flags *flag.FlagSet, args []string) error {
+	status := flags.String("status", "", "only payments with this status")
+	limit := flags.Int("limit", 20, "number of payments to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	q := url.Values{"limit": {strconv.Itoa(*limit)}}
+	if *status != "" {
+		q.Set("status", *status)
+	}
+	var list struct {
+		Payments []*service.Payment `json:"payments"`
+		HasMore  bool               `json:"hasMore"`
+	}
+	if err := c.do("GET", "/admin/payments?"+q.Encode(), nil, &list); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CREATED\tSESSION\tPAYMENT INTENT\tAMOUNT\tSTATUS")
+	for _, p := range list.Payments {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.CreatedAt.Local().Format("2006-01-02 15:04"),
+			p.SessionID, p.PaymentIntentID, service.FormatAmount(p.Amount, p.Currency), p.Status)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if list.HasMore {
+		fmt.Fprintln(c.out, "(more with a higher -limit)")
+	}
+	return nil
+}
+
+func runRefundCommand(c *adminClient, flags *flag.FlagSet, args []string) error {
+	req := &service.RefundRequest{}
+	flags.Int64Var(&req.Amount, "amount", 0, "amount to refund in the currency's minor unit (default the whole payment)")
+	flags.StringVar(&req.Reason, "reason", "", "duplicate, fraudulent or requested_by_customer")
+	var err error
+	if req.PaymentIntentID, err = oneArg(flags, args, "payment intent"); err != nil {
+		return err
+	}
+	var rec service.Refund
+	if err := c.do("POST", "/refunds", req, &rec); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "refund %s of %s: %s\n", rec.ID, service.FormatAmount(rec.Amount, rec.Currency), rec.Status)
+	return nil
+}
+
+func runResendReceiptCommand(c *adminClient, flags *flag.FlagSet, args []string) error {
+	id, err := oneArg(flags, args, "checkout session or payment intent")
+	if err != nil {
+		return err
+	}
+	var resp httpapi.ResendReceiptResponse
+	if err := c.do("POST", "/admin/payments/"+url.PathEscape(id)+"/resend-receipt", nil, &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "receipt for %s queued to %s\n", resp.SessionID, resp.Email)
+	return nil
+}
+
+func runReplayEventCommand(c *adminClient, flags *flag.FlagSet, args []string) error {
+	id, err := oneArg(flags, args, "event")
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Replayed string `json:"replayed"`
+	}
+	if err := c.do("POST", "/admin/events/"+url.PathEscape(id)+"/replay", nil, &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "replayed %s (%s)\n", id, resp.Replayed)
+	return nil
+}
+
+func runRebuildProjectionCommand(c *adminClient, flags *flag.FlagSet, args []string) error {
+	dryRun := flags.Bool("dry-run", false, "only report what would change")
+	name, err := oneArg(flags, args, "projection")
+	if err != nil {
+		return err
+	}
+	path := "/admin/projections/" + url.PathEscape(name) + "/rebuild"
+	if *dryRun {
+		path += "?dryRun=true"
+	}
+	var rebuild service.ProjectionRebuild
+	if err := c.do("POST", path, nil, &rebuild); err != nil {
+		return err
+	}
+	for _, ch := range rebuild.Changes {
+		fmt.Fprintf(c.out, "%s: %s\n", ch.Key, strings.Join(ch.Fields, ", "))
+	}
+	verb := "changed"
+	if rebuild.DryRun {
+		verb = "would change"
+	}
+	fmt.Fprintf(c.out, "folded %d %s, %s %d\n", rebuild.Streams, name, verb, len(rebuild.Changes))
+	return nil
+}
+
+// runCheckConfigCommand loads the configuration and catalogs as the server
+// would on start, and opens the payment store.
+func runCheckConfigCommand(c *adminClient, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	svc := service.New(cfg, service.Stores{}, embeddedAssets())
+	if err := svc.UseSettings(ctx, cfg); err != nil {
+		return err
+	}
+	store, err := store.OpenPaymentStore(svc.Config)
+	if err != nil {
+		return fmt.Errorf("Error opening payment store: %w", err)
+	}
+	defer store.Close()
+	if err := store.Ping(ctx); err != nil {
+		return fmt.Errorf("Error reaching payment store: %w", err)
+	}
+	fmt.Fprintf(c.out, "configuration OK: %s mode, PRICE %s, %d products, %d tenants\n",
+		svc.Config.Mode, svc.Config.Price, len(svc.Catalog.Products()), len(svc.Config.Tenants))
+	return nil
+}
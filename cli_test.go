@@ -0,0 +1,143 @@
+package stripego
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+)
+
+// cli runs an admin command against the test server and returns its output.
+func (e *testEnv) cli(args ...string) (string, error) {
+	e.t.Helper()
+	srv := httptest.NewServer(e.handler)
+	defer srv.Close()
+	var out bytes.Buffer
+	args = append([]string{args[0], "-url", srv.URL, "-token", servicetest.AdminToken}, args[1:]...)
+	err := runCommand(args[0], args[1:], &out)
+	return out.String(), err
+}
+
+func TestCLIPaymentsAndRefund(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedPayment()
+	e.stripe.PaymentIntents["pi_test_seed"] = &stripe.PaymentIntent{ID: "pi_test_seed", Amount: 3000, Currency: "usd"}
+
+	out, err := e.cli("payments", "-status", "paid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "cs_test_seed") || !strings.Contains(out, "pi_test_seed") {
+		t.Errorf("payments output:\n%s", out)
+	}
+
+	out, err = e.cli("refund", "-amount", "500", "pi_test_seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "refund re_") || len(e.stripe.RefundParams) != 1 || *e.stripe.RefundParams[0].Amount != 500 {
+		t.Errorf("refund output %q, params %+v", out, e.stripe.RefundParams)
+	}
+
+	if _, err := e.cli("refund"); err == nil || !strings.Contains(err.Error(), "want one payment intent") {
+		t.Errorf("refund without a payment intent: %v", err)
+	}
+	srv := httptest.NewServer(e.handler)
+	defer srv.Close()
+	if err := runCommand("payments", []string{"-url", srv.URL, "-token", "wrong"}, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("payments with a bad token: %v", err)
+	}
+}
+
+func TestCLIResendReceipt(t *testing.T) {
+	e := newTestEnv(t)
+	resp := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 1})
+	e.runJobs()
+	sent := len(e.emails.Sent)
+	e.stripe.Sessions[resp.ID].PaymentStatus = stripe.CheckoutSessionPaymentStatusPaid
+	e.stripe.Sessions[resp.ID].CustomerDetails = &stripe.CheckoutSessionCustomerDetails{Email: "jenny@example.com"}
+
+	out, err := e.cli("resend-receipt", resp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "receipt for "+resp.ID+" queued to jenny@example.com\n" {
+		t.Errorf("output = %q", out)
+	}
+	e.runJobs()
+	if len(e.emails.Sent) != sent+1 || e.emails.Sent[sent].To != "jenny@example.com" {
+		t.Errorf("emails = %+v, want the receipt again", e.emails.Sent[sent:])
+	}
+
+	e.stripe.Sessions[resp.ID].PaymentStatus = stripe.CheckoutSessionPaymentStatusUnpaid
+	if _, err := e.cli("resend-receipt", resp.ID); err == nil || !strings.Contains(err.Error(), "isn't paid") {
+		t.Errorf("unpaid session: %v", err)
+	}
+	if _, err := e.cli("resend-receipt", "cs_test_missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("unknown payment: %v", err)
+	}
+}
+
+func TestCLIReplayEvent(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.Mode = "live"
+	var replayed []string
+	e.svc.WebhookRouter.On("test.replayed", func(ctx context.Context, event stripe.Event) error {
+		replayed = append(replayed, event.ID)
+		return nil
+	})
+	e.stripe.Events = append(e.stripe.Events, &stripe.Event{ID: "evt_replay", Type: "test.replayed", Data: &stripe.EventData{Object: map[string]interface{}{"id": "obj_1"}}})
+
+	_, err := e.cli("replay-event", "evt_replay")
+	token := regexp.MustCompile(`-confirm (\S+)`).FindStringSubmatch(err.Error())
+	if token == nil {
+		t.Fatalf("live replay without confirming: %v", err)
+	}
+	out, err := e.cli("replay-event", "-confirm", token[1], "evt_replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "replayed evt_replay (test.replayed)\n" || len(replayed) != 1 {
+		t.Errorf("output %q, replayed %v", out, replayed)
+	}
+	if _, err := e.cli("replay-event", "evt_missing"); err == nil {
+		t.Error("replaying an unknown event succeeded")
+	}
+}
+
+func TestCLIRebuildProjection(t *testing.T) {
+	e := newTestEnv(t)
+	paid := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 1})
+	fulfilled := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 1})
+	checkStatus(t, e.admin("POST", "/admin/orders/"+fulfilled.OrderID+"/fulfill", nil), http.StatusOK)
+
+	// Lose the paid order's state, as a handler bug would.
+	ctx := context.Background()
+	o, err := e.svc.Payments.GetOrder(ctx, paid.OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Status, o.Email = service.OrderPending, ""
+	if err := e.svc.Payments.SaveOrder(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := e.cli("rebuild-projection", "-dry-run", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != paid.OrderID+": status, email\nfolded 2 orders, would change 1\n" {
+		t.Errorf("dry run output:\n%s", out)
+	}
+	if e.order(paid.OrderID).Status != service.OrderPending {
+		t.Error("dry run saved the order")
+	}
+}
@@ -0,0 +1,16 @@
+// Command server runs the payments service. See the README for its
+// settings and subcommands.
+package main
+
+import (
+	"log"
+	"os"
+
+	stripego "stripe_go"
+)
+
+func main() {
+	if err := stripego.Run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,277 @@
+package stripego
+
+//go:generate protoc --go_out=. --go_opt=module=stripe_go --go-grpc_out=. --go-grpc_opt=module=stripe_go paymentspb/payments.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+	"stripe_go/paymentspb"
+)
+
+// grpcPaymentsServer serves paymentspb.Payments with the same service
+// operations as the HTTP handlers.
+type grpcPaymentsServer struct {
+	paymentspb.UnimplementedPaymentsServer
+	svc *service.Service
+}
+
+// newGRPCServer returns the gRPC server for GRPC_PORT. It uses
+// TLS_CERT_FILE and TLS_KEY_FILE when they are set and is plaintext
+// otherwise.
+func (s *Server) newGRPCServer() (*grpc.Server, error) {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(s.grpcInterceptor)}
+	if s.svc.Config.TLSCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(s.svc.Config.TLSCertFile, s.svc.Config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading gRPC TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	gs := grpc.NewServer(opts...)
+	paymentspb.RegisterPaymentsServer(gs, &grpcPaymentsServer{svc: s.svc})
+	return gs, nil
+}
+
+// startGRPC serves gRPC on addr until the returned function is called,
+// which stops it, letting in-flight calls finish for up to drainTimeout.
+func (s *Server) startGRPC(addr string, drainTimeout time.Duration) (func(), error) {
+	gs, err := s.newGRPCServer()
+	if err != nil {
+		return nil, err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Error listening for gRPC: %w", err)
+	}
+	go func() {
+		slog.Info("grpc server running", "addr", addr)
+		if err := gs.Serve(lis); err != nil {
+			slog.Error("grpc server stopped", "error", err)
+		}
+	}()
+	return func() {
+		done := make(chan struct{})
+		go func() {
+			gs.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(drainTimeout):
+			gs.Stop()
+		}
+	}, nil
+}
+
+// grpcInterceptor is requireAuth and withRequestLogging for gRPC: every call
+// needs "authorization: Bearer <credential>" metadata, CreateRefund needs
+// the admin role, and each call gets a request ID and a log line.
+func (s *Server) grpcInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := first(md.Get("x-request-id"))
+	if id == "" || len(id) > 64 {
+		id = service.NewRequestID()
+	}
+	ctx = service.WithRequestID(ctx, id)
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+
+	start := time.Now()
+	var resp interface{}
+	ctx, err := s.authorizeGRPC(ctx, md, info.FullMethod)
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	slog.Info("grpc request",
+		"request_id", id,
+		"method", info.FullMethod,
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	)
+	return resp, err
+}
+
+// authorizeGRPC authenticates the caller of method and returns ctx carrying
+// the principal.
+func (s *Server) authorizeGRPC(ctx context.Context, md metadata.MD, method string) (context.Context, error) {
+	if !s.svc.AuthEnabled() {
+		return nil, status.Error(codes.Unauthenticated, "no credentials are configured")
+	}
+	p, err := s.svc.Authenticate(strings.TrimPrefix(first(md.Get("authorization")), "Bearer "), time.Now())
+	if err != nil {
+		service.LogCtx(ctx).Info("rejected credentials", "method", method, "error", err)
+		return nil, status.Error(codes.Unauthenticated, http.StatusText(http.StatusUnauthorized))
+	}
+	if method == paymentspb.Payments_CreateRefund_FullMethodName && p.Role != config.RoleAdmin {
+		service.LogCtx(ctx).Info("forbidden", "method", method, "principal", p.Name, "role", p.Role)
+		return nil, status.Errorf(codes.PermissionDenied, "%s role can't call %s", p.Role, method)
+	}
+	return service.WithPrincipal(ctx, p), nil
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// grpcError turns the error of a service operation into a gRPC status,
+// choosing the code from the HTTP status the HTTP API would answer with.
+func grpcError(err error) error {
+	var cr *service.ConfirmationRequired
+	if errors.As(err, &cr) {
+		msg := fmt.Sprintf("%s; repeat the call with %s metadata %s", cr.Message, config.ConfirmationMetadata, cr.Confirmation.Token)
+		return status.Error(grpcCode(http.StatusPreconditionRequired), msg)
+	}
+	code, msg := service.ErrorStatus(err)
+	if code >= http.StatusInternalServerError {
+		slog.Error("gRPC call failed", "status", code, "error", err)
+	}
+	return status.Error(grpcCode(code), msg.Message)
+}
+
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict, http.StatusPaymentRequired, http.StatusPreconditionRequired:
+		return codes.FailedPrecondition
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.Internal
+}
+
+func (g *grpcPaymentsServer) CreateCheckout(ctx context.Context, in *paymentspb.CreateCheckoutRequest) (*paymentspb.CreateCheckoutResponse, error) {
+	req := &service.CreateCheckoutRequest{
+		Customer:      in.Customer,
+		Coupon:        in.Coupon,
+		PromotionCode: in.PromotionCode,
+		Seller:        in.Seller,
+		Metadata:      in.Metadata,
+		SuccessURL:    in.SuccessUrl,
+		CancelURL:     in.CancelUrl,
+		Currency:      in.Currency,
+		CaptureMethod: in.CaptureMethod,
+
+		PaymentMethodTypes: in.PaymentMethodTypes,
+	}
+	for _, item := range in.Items {
+		req.Items = append(req.Items, service.CheckoutItem{Price: item.Price, Quantity: item.Quantity})
+	}
+	if err := req.Validate(ctx, g.svc); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resp, err := g.svc.CreateCheckout(ctx, req, "")
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &paymentspb.CreateCheckoutResponse{Id: resp.ID, Url: resp.URL, OrderId: resp.OrderID}, nil
+}
+
+func (g *grpcPaymentsServer) GetPayment(ctx context.Context, in *paymentspb.GetPaymentRequest) (*paymentspb.Payment, error) {
+	if in.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	p, err := g.svc.GetPayment(ctx, in.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return paymentToProto(p), nil
+}
+
+func (g *grpcPaymentsServer) CreateRefund(ctx context.Context, in *paymentspb.CreateRefundRequest) (*paymentspb.Refund, error) {
+	req := &service.RefundRequest{PaymentIntentID: in.PaymentIntentId, Amount: in.Amount, Reason: in.Reason}
+	if err := req.Validate(ctx, g.svc); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(config.ConfirmationMetadata)) > 0 {
+		ctx = service.WithConfirmation(ctx, md.Get(config.ConfirmationMetadata)[0])
+	}
+	rec, err := g.svc.IssueRefund(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &paymentspb.Refund{
+		Id:              rec.ID,
+		PaymentIntentId: rec.PaymentIntentID,
+		Amount:          rec.Amount,
+		Currency:        rec.Currency,
+		Status:          rec.Status,
+		Reason:          rec.Reason,
+		CreatedAt:       timestamppb.New(rec.CreatedAt),
+	}, nil
+}
+
+func (g *grpcPaymentsServer) ListPayments(ctx context.Context, in *paymentspb.ListPaymentsRequest) (*paymentspb.ListPaymentsResponse, error) {
+	f := service.PaymentFilter{
+		Status:   in.Status,
+		Currency: strings.ToLower(in.Currency),
+		Limit:    int(in.Limit),
+		Offset:   int(in.Offset),
+	}
+	if f.Currency != "" && !config.CurrencyPattern.MatchString(f.Currency) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid currency %q", f.Currency)
+	}
+	if f.Limit == 0 {
+		f.Limit = service.DefaultPageSize
+	}
+	if f.Limit < 1 || f.Limit > service.MaxPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", service.MaxPageSize)
+	}
+	if f.Offset < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid offset %d", f.Offset)
+	}
+	if in.From != nil {
+		f.From = in.From.AsTime()
+	}
+	if in.To != nil {
+		f.To = in.To.AsTime()
+	}
+	list, hasMore, err := g.svc.ListPayments(ctx, f)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &paymentspb.ListPaymentsResponse{HasMore: hasMore}
+	for _, p := range list {
+		resp.Payments = append(resp.Payments, paymentToProto(p))
+	}
+	return resp, nil
+}
+
+func paymentToProto(p *service.Payment) *paymentspb.Payment {
+	return &paymentspb.Payment{
+		SessionId:       p.SessionID,
+		PaymentIntentId: p.PaymentIntentID,
+		Amount:          p.Amount,
+		Currency:        p.Currency,
+		Status:          p.Status,
+		Metadata:        p.Metadata,
+		CreatedAt:       timestamppb.New(p.CreatedAt),
+		UpdatedAt:       timestamppb.New(p.UpdatedAt),
+	}
+}
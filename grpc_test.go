@@ -0,0 +1,174 @@
+package stripego
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+	"stripe_go/paymentspb"
+)
+
+// grpcClient serves gRPC over an in-memory connection for the test.
+func (e *testEnv) grpcClient() paymentspb.PaymentsClient {
+	e.t.Helper()
+	s, err := (&Server{svc: e.svc}).newGRPCServer()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	e.t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.t.Cleanup(func() { conn.Close() })
+	return paymentspb.NewPaymentsClient(conn)
+}
+
+func bearerContext(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func checkCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Errorf("code = %v (%v), want %v", got, err, want)
+	}
+}
+
+func TestGRPCPayments(t *testing.T) {
+	e := newTestEnv(t)
+	client := e.grpcClient()
+	ctx := bearerContext(servicetest.AdminToken)
+
+	created, err := client.CreateCheckout(ctx, &paymentspb.CreateCheckoutRequest{
+		Items: []*paymentspb.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Id == "" || created.Url == "" || created.OrderId == "" {
+		t.Fatalf("CreateCheckout = %+v", created)
+	}
+	if _, err := e.svc.Payments.GetOrder(context.Background(), created.OrderId); err != nil {
+		t.Errorf("order wasn't stored: %v", err)
+	}
+
+	e.seedPayment()
+	p, err := client.GetPayment(ctx, &paymentspb.GetPaymentRequest{Id: "pi_test_seed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SessionId != "cs_test_seed" || p.Amount != 3000 || p.Status != "paid" {
+		t.Errorf("GetPayment = %+v", p)
+	}
+	_, err = client.GetPayment(ctx, &paymentspb.GetPaymentRequest{Id: "cs_test_missing"})
+	checkCode(t, err, codes.NotFound)
+
+	list, err := client.ListPayments(ctx, &paymentspb.ListPaymentsRequest{Status: "paid", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Payments) != 1 || list.Payments[0].PaymentIntentId != "pi_test_seed" || list.HasMore {
+		t.Errorf("ListPayments = %+v", list)
+	}
+	_, err = client.ListPayments(ctx, &paymentspb.ListPaymentsRequest{Limit: service.MaxPageSize + 1})
+	checkCode(t, err, codes.InvalidArgument)
+
+	e.stripe.PaymentIntents["pi_test_seed"] = &stripe.PaymentIntent{ID: "pi_test_seed", Amount: 3000, Currency: "usd"}
+	re, err := client.CreateRefund(ctx, &paymentspb.CreateRefundRequest{PaymentIntentId: "pi_test_seed", Amount: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if re.Id == "" || re.Amount != 1000 || re.CreatedAt == nil {
+		t.Errorf("CreateRefund = %+v", re)
+	}
+	if refunds, _ := e.svc.Payments.ListRefunds(context.Background(), "pi_test_seed"); len(refunds) != 1 {
+		t.Errorf("stored %d refunds, want 1", len(refunds))
+	}
+	_, err = client.CreateRefund(ctx, &paymentspb.CreateRefundRequest{PaymentIntentId: "pi_test_seed", Amount: 5000})
+	checkCode(t, err, codes.InvalidArgument)
+	_, err = client.CreateRefund(ctx, &paymentspb.CreateRefundRequest{PaymentIntentId: "pi_test_unknown"})
+	checkCode(t, err, codes.NotFound)
+}
+
+func TestGRPCErrors(t *testing.T) {
+	e := newTestEnv(t)
+	client := e.grpcClient()
+	ctx := bearerContext(servicetest.AdminToken)
+
+	_, err := client.CreateCheckout(ctx, &paymentspb.CreateCheckoutRequest{})
+	checkCode(t, err, codes.InvalidArgument)
+
+	e.stripe.Err = &stripe.Error{HTTPStatusCode: 500, Msg: "api down"}
+	_, err = client.CreateCheckout(ctx, &paymentspb.CreateCheckoutRequest{
+		Items: []*paymentspb.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+	})
+	checkCode(t, err, codes.Unavailable)
+}
+
+func TestGRPCAuth(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.APIKeys = []config.APIKey{{Name: "reports", Role: config.RoleReadonly, Key: "readonly-key-0123456789"}}
+	client := e.grpcClient()
+	e.seedPayment()
+	get := &paymentspb.GetPaymentRequest{Id: "cs_test_seed"}
+
+	_, err := client.GetPayment(context.Background(), get)
+	checkCode(t, err, codes.Unauthenticated)
+	_, err = client.GetPayment(bearerContext("wrong-token"), get)
+	checkCode(t, err, codes.Unauthenticated)
+
+	readonly := bearerContext("readonly-key-0123456789")
+	if _, err := client.GetPayment(readonly, get); err != nil {
+		t.Errorf("readonly GetPayment: %v", err)
+	}
+	_, err = client.CreateRefund(readonly, &paymentspb.CreateRefundRequest{PaymentIntentId: "pi_test_seed"})
+	checkCode(t, err, codes.PermissionDenied)
+
+	e.svc.Config.AdminToken, e.svc.Config.APIKeys = "", nil
+	_, err = client.GetPayment(bearerContext(servicetest.AdminToken), get)
+	checkCode(t, err, codes.Unauthenticated)
+}
+
+func TestLiveGRPCRefundConfirmation(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.Mode, e.svc.Config.PublishableKey, e.svc.Config.SecretKey = "live", "pk_live_123", "sk_live_123"
+	e.svc.Config.SafetyRefundThreshold = 2000
+	e.svc.Config.SafetySigningKey = "safety-signing-key-0123456789abcdef"
+	e.stripe.PaymentIntents["pi_test_seed"] = &stripe.PaymentIntent{ID: "pi_test_seed", Amount: 3000, Currency: "usd"}
+	e.seedPayment()
+	client := e.grpcClient()
+
+	ctx := bearerContext(servicetest.AdminToken)
+	req := &paymentspb.CreateRefundRequest{PaymentIntentId: "pi_test_seed", Amount: 2000}
+	_, err := client.CreateRefund(ctx, req)
+	checkCode(t, err, codes.FailedPrecondition)
+	if len(e.stripe.RefundParams) != 0 {
+		t.Fatal("refunded before confirming")
+	}
+	_, token, ok := strings.Cut(err.Error(), config.ConfirmationMetadata+" metadata ")
+	if !ok {
+		t.Fatalf("no token in %v", err)
+	}
+	if _, err := client.CreateRefund(metadata.AppendToOutgoingContext(ctx, config.ConfirmationMetadata, token), req); err != nil {
+		t.Fatal(err)
+	}
+}
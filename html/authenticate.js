@@ -0,0 +1,41 @@
+// The link in the authentication email carries the payment intent, its
+// client secret and the saved payment method in the fragment, so they never
+// reach a server log.
+var params = new URLSearchParams(window.location.hash.slice(1));
+var clientSecret = params.get('client_secret');
+var paymentMethod = params.get('payment_method');
+var statusText = document.querySelector('.authenticate-status');
+var button = document.getElementById('authenticate');
+
+function showStatus(text) {
+  statusText.textContent = text;
+}
+
+if (!clientSecret || !paymentMethod) {
+  showStatus('This link is incomplete. Open it again from the email.');
+  button.disabled = true;
+}
+
+button.addEventListener('click', function () {
+  button.disabled = true;
+  fetch('/config')
+    .then(function (result) {
+      return result.json();
+    })
+    .then(function (config) {
+      var stripe = Stripe(config.publishableKey);
+      return stripe.confirmCardPayment(clientSecret, { payment_method: paymentMethod });
+    })
+    .then(function (result) {
+      if (result.error) {
+        showStatus(result.error.message);
+        button.disabled = false;
+        return;
+      }
+      showStatus('Thank you, your payment is confirmed.');
+    })
+    .catch(function (err) {
+      console.log('Error when confirming the payment', err);
+      button.disabled = false;
+    });
+});
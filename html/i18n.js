@@ -0,0 +1,74 @@
+// The strings of the success and cancel pages. The page is shown in the
+// locale Checkout used, which the return URL carries as ?locale=, or else
+// the browser's language; languages without strings fall back to English.
+var messages = {
+  en: {
+    paymentSucceeded: 'Your payment succeeded',
+    paymentCanceled: 'Your payment was canceled',
+    paymentPending: 'Your payment is processing',
+    paymentPendingDetail: "We'll email you as soon as your bank confirms the payment.",
+    sessionNotFound: "We couldn't show your order",
+    subtotal: 'Subtotal',
+    total: 'Total',
+    receiptEmail: 'Receipt email:',
+    manageSubscription: 'Manage your subscription',
+    restart: 'Restart demo',
+    orderStatus: 'Order {order}: {status} ({amount})',
+    pending: 'pending',
+    paid: 'paid',
+    fulfilled: 'fulfilled',
+    refunded: 'refunded',
+    canceled: 'canceled',
+  },
+  de: {
+    paymentSucceeded: 'Ihre Zahlung war erfolgreich',
+    paymentCanceled: 'Ihre Zahlung wurde abgebrochen',
+    paymentPending: 'Ihre Zahlung wird bearbeitet',
+    paymentPendingDetail: 'Wir schicken Ihnen eine E-Mail, sobald Ihre Bank die Zahlung bestätigt.',
+    sessionNotFound: 'Ihre Bestellung kann nicht angezeigt werden',
+    subtotal: 'Zwischensumme',
+    total: 'Gesamt',
+    receiptEmail: 'Beleg an:',
+    manageSubscription: 'Abonnement verwalten',
+    restart: 'Demo neu starten',
+    orderStatus: 'Bestellung {order}: {status} ({amount})',
+    pending: 'ausstehend',
+    paid: 'bezahlt',
+    fulfilled: 'geliefert',
+    refunded: 'erstattet',
+    canceled: 'storniert',
+  },
+  fr: {
+    paymentSucceeded: 'Votre paiement a réussi',
+    paymentCanceled: 'Votre paiement a été annulé',
+    paymentPending: 'Votre paiement est en cours de traitement',
+    paymentPendingDetail: 'Nous vous enverrons un e-mail dès que votre banque aura confirmé le paiement.',
+    sessionNotFound: 'Impossible d’afficher votre commande',
+    subtotal: 'Sous-total',
+    total: 'Total',
+    receiptEmail: 'Reçu envoyé à :',
+    manageSubscription: 'Gérer votre abonnement',
+    restart: 'Relancer la démo',
+    orderStatus: 'Commande {order} : {status} ({amount})',
+    pending: 'en attente',
+    paid: 'payée',
+    fulfilled: 'livrée',
+    refunded: 'remboursée',
+    canceled: 'annulée',
+  },
+};
+var pageLocale = new URLSearchParams(window.location.search).get('locale');
+if (!pageLocale || pageLocale === 'auto') {
+  pageLocale = navigator.language || 'en';
+}
+
+// t returns the string for key in the page's language.
+function t(key) {
+  var strings = messages[pageLocale] || messages[pageLocale.split('-')[0]] || messages.en;
+  return strings[key] || messages.en[key] || key;
+}
+
+document.documentElement.lang = pageLocale;
+document.querySelectorAll('[data-i18n]').forEach(function (el) {
+  el.textContent = t(el.getAttribute('data-i18n'));
+});
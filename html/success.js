@@ -1,16 +1,40 @@
+// The server renders the session's items and totals into the page.
 var urlParams = new URLSearchParams(window.location.search);
-var sessionId = urlParams.get('session_id');
 
-if (sessionId) {
-  fetch('http://localhost:4242/checkout-session?sessionId=' + sessionId)
+// With order status links enabled, the success URL also carries the order
+// and its token: show the order's status until the payment is settled.
+var orderId = urlParams.get('order_id');
+var orderToken = urlParams.get('order_token');
+
+// formatAmount formats an amount in the smallest currency unit, which is
+// the whole unit for zero-decimal currencies such as JPY.
+function formatAmount(amount, currency) {
+  var format = new Intl.NumberFormat(pageLocale, { style: 'currency', currency: currency.toUpperCase() });
+  var digits = format.resolvedOptions().maximumFractionDigits;
+  return format.format(amount / Math.pow(10, digits));
+}
+
+function pollOrderStatus(attempt) {
+  fetch('/orders/' + encodeURIComponent(orderId) + '/status?token=' + encodeURIComponent(orderToken))
     .then(function (result) {
       return result.json();
     })
-    .then(function (session) {
-      var sessionJSON = JSON.stringify(session, null, 2);
-      document.querySelector('pre').textContent = sessionJSON;
+    .then(function (order) {
+      document.querySelector('.order-status').textContent = t('orderStatus')
+        .replace('{order}', order.orderId)
+        .replace('{status}', t(order.status))
+        .replace('{amount}', formatAmount(order.amount, order.currency));
+      if (order.status === 'pending' && attempt < 10) {
+        setTimeout(function () {
+          pollOrderStatus(attempt + 1);
+        }, 2000);
+      }
     })
     .catch(function (err) {
-      console.log('Error when fetching Checkout session', err);
+      console.log('Error when fetching order status', err);
     });
 }
+
+if (orderId && orderToken) {
+  pollOrderStatus(0);
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// Role is what an authenticated caller may do. Readonly callers can use the
+// GET endpoints, e.g. reports and payment lookups; everything that changes
+// state, such as refunds, replays and stock updates, needs admin.
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleReadonly Role = "readonly"
+)
+
+// Roles are the roles a credential can be given.
+var Roles = map[Role]bool{RoleAdmin: true, RoleReadonly: true}
+
+// APIKey is a named static credential from API_KEYS.
+type APIKey struct {
+	Name string
+	Role Role
+	Key  string
+}
+
+// parseAPIKeys parses API_KEYS, a comma separated list of name:role:key
+// entries such as "ci:readonly:k3y...,ops:admin:s3cret...".
+func parseAPIKeys(s string) ([]APIKey, error) {
+	var keys []APIKey
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, errors.New("invalid API_KEYS entry: want name:role:key")
+		}
+		keys = append(keys, APIKey{Name: parts[0], Role: Role(parts[1]), Key: parts[2]})
+	}
+	return keys, nil
+}
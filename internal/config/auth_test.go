@@ -0,0 +1,16 @@
+package config
+
+import "testing"
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys("ci:readonly:abc:def, ops:admin:xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != (APIKey{"ci", RoleReadonly, "abc:def"}) || keys[1].Name != "ops" {
+		t.Errorf("keys = %+v", keys)
+	}
+	if _, err := parseAPIKeys("admin:nokey"); err == nil {
+		t.Error("entry without a key accepted")
+	}
+}
@@ -0,0 +1,1251 @@
+// Package config reads the service's settings from the environment, an
+// optional YAML file and the secret managers they name, and validates them.
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting, loaded once at startup.
+type Config struct {
+	// Mode is "test" or "live". The keys must belong to that mode, so a
+	// deploy can't accidentally take real payments or fake ones. "mock"
+	// runs against the in-memory stripeclient.Mock instead of Stripe, with
+	// test mode keys, if any.
+	Mode string
+	// PublishableKey (pk_...) is safe to hand to browsers.
+	PublishableKey string
+	// SecretKey (sk_... or restricted rk_...) must never leave the server.
+	SecretKey string
+	// WebhookSecrets are the endpoint's signing secrets (whsec_...). A
+	// delivery signed with any of them is accepted, so a new secret can be
+	// added before the old one is removed.
+	WebhookSecrets []string
+	// Secrets, when SECRET_PROVIDER is set, resolves SecretKey and
+	// WebhookSecrets settings written as secret:NAME[#FIELD] from a secret
+	// manager, again every SecretRefreshInterval.
+	Secrets               SecretProvider
+	SecretRefreshInterval time.Duration
+	// With WebhookAutoRegister the webhook endpoint for DOMAIN is created or
+	// updated on startup. The signing secret Stripe returns when creating it
+	// is kept in WebhookSecretFile, whose secret is added to WebhookSecrets.
+	WebhookAutoRegister bool
+	WebhookSecretFile   string
+	// StripeTimeout bounds each Stripe API request; zero waits forever.
+	// Idempotent requests that fail with 429, 5xx or a network error are
+	// retried up to StripeMaxRetries times, starting StripeRetryBackoff apart.
+	StripeTimeout      time.Duration
+	StripeMaxRetries   int
+	StripeRetryBackoff time.Duration
+	// CacheBackend is "memory" (default), "redis" or "none". Prices are
+	// cached for CachePriceTTL and checkout sessions for CacheSessionTTL;
+	// the memory cache holds up to CacheSize entries.
+	CacheBackend    string
+	CacheSize       int
+	CachePriceTTL   time.Duration
+	CacheSessionTTL time.Duration
+	// RedisURL is the Redis server of the redis cache, e.g.
+	// redis://localhost:6379/0.
+	RedisURL string
+	// Price is the default Price ID sold by the storefront. SIGHUP and POST
+	// /admin/reload swap it; read it with Service.ConfiguredPrice.
+	Price string
+	// Branding is returned by GET /config for the storefront to show.
+	Branding Branding
+	// Tenants are further brands hosted on their own Stripe accounts, each
+	// with its own keys, webhook secrets, catalog, price and branding.
+	Tenants []*Tenant
+	// CatalogRefreshInterval reloads products and prices from Stripe
+	// periodically; zero only loads them at startup.
+	CatalogRefreshInterval time.Duration
+	// ReconcileInterval compares recent Stripe checkout sessions and payment
+	// intents against the payment store and repairs what missed webhooks
+	// left behind; zero disables it. ReconcileWindow is how far back each
+	// run looks.
+	ReconcileInterval time.Duration
+	ReconcileWindow   time.Duration
+	// ApplicationFeePercent is the platform's cut of marketplace sales made
+	// on behalf of connected accounts.
+	ApplicationFeePercent float64
+	// ReturnURLHosts are the hosts, besides DOMAIN's, that clients may pass
+	// as success and cancel URLs. A leading dot allows all subdomains.
+	ReturnURLHosts []string
+	// CORSAllowedOrigins may call the API from browsers on other origins;
+	// "*" allows any. CORSAllowCredentials lets them send cookies and
+	// Authorization headers, and CORSMaxAge is how long browsers cache
+	// preflight results.
+	CORSAllowedOrigins   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
+	// MaxQuantity is the most units of a price one session can buy, unless
+	// PriceLimits or the database set other bounds for the price.
+	// MaxCartQuantity caps the units of all prices together; 0 doesn't.
+	MaxQuantity     int64
+	MaxCartQuantity int64
+	PriceLimits     map[string]PriceLimit
+	// CheckoutAmountLimits bound the total of a checkout session, before
+	// discounts, by currency. Currencies not listed aren't limited.
+	CheckoutAmountLimits map[string]AmountLimit
+	// AdjustableQuantity lets customers change the quantity of each line
+	// on the Checkout page, between AdjustableQuantityMin and
+	// AdjustableQuantityMax units.
+	AdjustableQuantity    bool
+	AdjustableQuantityMin int64
+	AdjustableQuantityMax int64
+	// Donations are charged in DonationCurrency, between DonationMinAmount
+	// and DonationMaxAmount of its smallest unit, as a line item named
+	// DonationName.
+	DonationCurrency  string
+	DonationMinAmount int64
+	DonationMaxAmount int64
+	DonationName      string
+	// Inventory seeds the stock of prices that aren't tracked yet; the
+	// database holds the current counts. Checkouts hold their units for
+	// InventoryReservationTTL, which also ends the session early if it is
+	// shorter than CheckoutSessionTTL.
+	Inventory               map[string]int64
+	InventoryReservationTTL time.Duration
+	// CheckoutSessionTTL is how long a payment session stays open.
+	CheckoutSessionTTL time.Duration
+	// CheckoutRecovery has Stripe make a recovery URL for sessions that
+	// expire unpaid, which is emailed to customers who agreed to
+	// promotional emails on the Checkout page.
+	CheckoutRecovery bool
+	// RecoveryEmailDelay is how long after a session expires its recovery
+	// email is sent. RecoveryCoupon, if set, is applied to the sessions the
+	// email's link creates.
+	RecoveryEmailDelay time.Duration
+	RecoveryCoupon     string
+	// AuthenticationWindow is how long customers emailed to authenticate a
+	// payment have to do so before it is canceled as abandoned.
+	AuthenticationWindow time.Duration
+	// SubscriptionProrationBehavior is how price changes made through
+	// /subscriptions/{id}/change are prorated when the request doesn't say:
+	// create_prorations, always_invoice or none.
+	SubscriptionProrationBehavior string
+	// ShippingCountries are the two-letter country codes Checkout collects
+	// shipping addresses for; empty doesn't collect one.
+	ShippingCountries []string
+	// ShippingRates are the shipping options offered when collecting an
+	// address.
+	ShippingRates []ShippingRate
+	// PaymentMethodTypes are offered on the Checkout page, e.g. card,ideal.
+	// Empty leaves the choice to the dashboard's payment method settings.
+	PaymentMethodTypes []string
+	// AllowPromotionCodes shows the promotion code field on the Checkout page
+	// when the cart doesn't already carry a discount.
+	AllowPromotionCodes bool
+	// TaxIDCollection lets business customers enter tax IDs on the Checkout
+	// page. With VIESValidation, EU VAT numbers are then checked against the
+	// European Commission's VIES service at VIESURL.
+	TaxIDCollection bool
+	VIESValidation  bool
+	VIESURL         string
+	// Overcapture asks for manually captured card payments to be authorized
+	// so they can be captured for more than the amount, where the card
+	// allows it.
+	Overcapture bool
+
+	// Domain is the public base URL, without a trailing slash, that return
+	// and onboarding URLs are built from.
+	Domain string
+	// StaticDir serves the HTML client at / from disk instead of the copy
+	// embedded in the binary.
+	StaticDir string
+	// Host and Port make up the listen address.
+	Host string
+	Port string
+	// GRPCPort, when set, also serves the gRPC interface on Host.
+	GRPCPort string
+	// ShutdownTimeout is how long in-flight requests get to finish after
+	// SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration
+	// RequestTimeout bounds each request; AdminRequestTimeout bounds the
+	// endpoints behind requireAuth instead, which include reconcile runs.
+	// Zero leaves requests unbounded.
+	RequestTimeout      time.Duration
+	AdminRequestTimeout time.Duration
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout bound
+	// how long a client has to send a request's headers and whole request,
+	// to read the response, and to send its next request on a kept-alive
+	// connection. Zero disables a timeout.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// MaxRequestBody caps request bodies in bytes; webhooks and dispute
+	// evidence uploads have their own limits.
+	MaxRequestBody int64
+	// TLS is served from TLSCertFile and TLSKeyFile, or from Let's Encrypt
+	// certificates for TLSAutocertDomains cached in TLSAutocertCacheDir.
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSAutocertDomains  []string
+	TLSAutocertCacheDir string
+	// HTTPRedirectPort, when set with TLS, serves plain HTTP redirects to
+	// HTTPS.
+	HTTPRedirectPort string
+	// HSTSMaxAge is sent in Strict-Transport-Security on HTTPS responses;
+	// zero disables the header.
+	HSTSMaxAge time.Duration
+	// TrustProxy takes the client IP from the last X-Forwarded-For entry.
+	TrustProxy bool
+	// DevReplayEnabled serves /dev/replay-event, which runs unsigned events
+	// through the webhook handlers. Only allowed with test mode keys.
+	DevReplayEnabled bool
+	// SwaggerUIEnabled serves an API explorer for /openapi.json at /docs.
+	// Only allowed with test mode keys.
+	SwaggerUIEnabled bool
+	// AdminToken is a bearer token with the admin role. APIKeys are further
+	// named keys with a role, and JWTs signed with any of JWTSecrets carry
+	// their role as a claim. With none of them set the admin endpoints are
+	// disabled. SIGHUP reloads all three.
+	AdminToken string
+	APIKeys    []APIKey
+	JWTSecrets []string
+	// ReceiptSigningKey signs the PDF receipt links in confirmation emails,
+	// which stay valid for ReceiptLinkTTL; empty disables receipts.
+	ReceiptSigningKey string
+	ReceiptLinkTTL    time.Duration
+	// OrderStatusSigningKey signs the links to GET /orders/{id}/status
+	// given to customers; empty disables the endpoint.
+	OrderStatusSigningKey string
+	// SessionSummarySigningKey signs the success URL tokens that unlock GET
+	// /checkout-session/summary; empty disables the endpoint.
+	SessionSummarySigningKey string
+	// PortalSigningKey signs the Billing Portal tokens the success page gives
+	// subscribers, which stay valid for PortalLinkTTL; empty leaves
+	// /create-portal-session to admin callers.
+	PortalSigningKey string
+	PortalLinkTTL    time.Duration
+	// FulfillmentRoutes pick what happens once a price or product is paid
+	// for: a download, a license key or a shipment. Downloads are served
+	// from DownloadsDir with links signed by DownloadSigningKey that last
+	// DownloadLinkTTL.
+	FulfillmentRoutes  []FulfillmentRoute
+	DownloadsDir       string
+	DownloadSigningKey string
+	DownloadLinkTTL    time.Duration
+	// SuccessPages are success pages, as paths under DOMAIN, for prices
+	// and products; a cart uses one when all of its items share it.
+	SuccessPages map[string]string
+	// LicenseSigningKey signs the license keys issued by license routes;
+	// empty disables GET /licenses/{key}/verify.
+	LicenseSigningKey string
+	// In live mode refunds of at least SafetyRefundThreshold, customer
+	// deletions and event replays need a confirmation token signed with
+	// SafetySigningKey that lasts SafetyConfirmationTTL. SafetyConfirmation
+	// is "token", where the caller repeats the request with it, or
+	// "approval", where another principal has to.
+	SafetyConfirmation    string
+	SafetyRefundThreshold int64
+	SafetySigningKey      string
+	SafetyConfirmationTTL time.Duration
+
+	// Rate limits per client IP. The session limit applies on top of the
+	// general one to endpoints that create Stripe objects.
+	RateLimitEnabled          bool
+	RateLimitPerMinute        int
+	RateLimitBurst            int
+	SessionRateLimitPerMinute int
+	SessionRateLimitBurst     int
+
+	// DatabaseDriver is "sqlite" or "postgres"; DatabaseURL is its data
+	// source.
+	DatabaseDriver string
+	DatabaseURL    string
+	// Standalone runs everything on the SQLite database: it is the default
+	// home of the job queue and event store, and nothing may need Postgres
+	// or Redis.
+	Standalone bool
+
+	// EmailBackend is "smtp", "sendgrid" or "" to log emails instead.
+	EmailBackend   string
+	EmailFrom      string
+	SMTPHost       string
+	SMTPPort       string
+	SMTPUsername   string
+	SMTPPassword   string
+	SendGridAPIKey string
+	// Receipts is who emails customers their receipt: "custom" for our
+	// confirmation email, "stripe" for Stripe's receipt email, or "both".
+	Receipts string
+	// EmailTemplateDir loads the customer email templates, one
+	// subdirectory per locale, from disk instead of the embedded copy.
+	EmailTemplateDir string
+	// EmailPreviewEnabled serves /dev/email-preview to render the templates
+	// with sample data. Only allowed with test mode keys.
+	EmailPreviewEnabled bool
+	// NotifyEmail receives alerts about failed payments and disputes; empty
+	// only logs them.
+	NotifyEmail string
+	// ReportRecipients are emailed the previous UTC day's revenue report
+	// every day at ReportTime past midnight UTC; empty sends no reports.
+	ReportRecipients []string
+	ReportTime       time.Duration
+	// SlackWebhookURL and DiscordWebhookURL are incoming webhooks that the
+	// same alerts are posted to; empty leaves that backend off.
+	SlackWebhookURL   string
+	DiscordWebhookURL string
+	// NotifyEmailEvents, SlackNotifyEvents and DiscordNotifyEvents pick the
+	// notification events each backend gets, e.g. payment.failed; empty
+	// sends all of them.
+	NotifyEmailEvents   []string
+	SlackNotifyEvents   []string
+	DiscordNotifyEvents []string
+	// NotifyPaymentThreshold is the smallest checkout total, in the
+	// currency's minor unit, that raises a payment.succeeded notification;
+	// 0 never does.
+	NotifyPaymentThreshold int64
+	// NotifyAbandonedThreshold is the smallest total of an expired checkout
+	// that raises a checkout.abandoned notification; 0 never does.
+	NotifyAbandonedThreshold int64
+	// OutboundWebhooks are downstream systems, such as fulfillment or a
+	// CRM, that are posted an event signed with OutboundWebhookSecret
+	// whenever a payment changes status. OutboundWebhookEvents picks the
+	// event types, e.g. payment.paid; empty sends all of them.
+	OutboundWebhooks      []OutboundWebhook
+	OutboundWebhookSecret string
+	OutboundWebhookEvents []string
+	// EventForwards are internal consumers that are passed the verified
+	// Stripe events they subscribed to, signed with OutboundWebhookSecret.
+	EventForwards []EventForward
+	// EventBus is the message bus, kafka, nats or redis, that order and
+	// subscription events are published on for the rest of the platform,
+	// to EventBusTopic; empty publishes none.
+	EventBus      string
+	EventBusTopic string
+	// KafkaBrokers are the host:port addresses of the Kafka cluster used by
+	// EVENT_BUS and EVENT_FORWARDS. NATSURL is the NATS server, e.g.
+	// nats://localhost:4222, whose JetStream streams store what's
+	// published there.
+	KafkaBrokers []string
+	NATSURL      string
+
+	// WebhookTolerance is how old a webhook signature's timestamp may be
+	// before the delivery is rejected as a possible replay.
+	WebhookTolerance time.Duration
+	// EventDedupeTTL is how long processed webhook event IDs are remembered,
+	// in memory or, with EventStore "database", in the database.
+	EventDedupeTTL time.Duration
+	EventStore     string
+	// EventPollInterval is how often the poll-events subcommand lists new
+	// events.
+	EventPollInterval time.Duration
+	// IdempotencyKeyTTL is how long the response to a request made with an
+	// Idempotency-Key is replayed to retries.
+	IdempotencyKeyTTL time.Duration
+	// Webhook follow-up jobs are persisted to JobQueueFile, or to the
+	// database with JobQueueBackend "database", and retried JobMaxAttempts
+	// times, starting JobRetryBackoff apart.
+	JobQueueBackend string
+	JobQueueFile    string
+	JobMaxAttempts  int
+	JobRetryBackoff time.Duration
+
+	// LogFormat is "json" or "text"; LogLevel one of debug, info, warn,
+	// error.
+	LogFormat string
+	LogLevel  string
+
+	// Traces are exported over OTLP/HTTP to OTLPTracesEndpoint, sending
+	// OTLPHeaders with every export; without an endpoint nothing is
+	// recorded. TraceSampleRatio of the traces started here are kept, while
+	// traces continued from a caller follow its sampling decision.
+	OTLPTracesEndpoint string
+	OTLPHeaders        map[string]string
+	TraceSampleRatio   float64
+	ServiceName        string
+}
+
+// Source looks settings up by their environment variable name. A
+// non-empty environment variable wins over the optional YAML file named by
+// CONFIG_FILE.
+type Source map[string]string
+
+// loadConfigFile reads a flat YAML mapping whose keys are the environment
+// variable names, in any case. Lists become comma separated values.
+func loadConfigFile(path string) (Source, error) {
+	src := Source{}
+	if path == "" {
+		return src, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CONFIG_FILE: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing CONFIG_FILE %s: %w", path, err)
+	}
+	for k, v := range raw {
+		key := strings.ToUpper(k)
+		switch v := v.(type) {
+		case nil:
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			src[key] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("CONFIG_FILE %s: %s must be a value or a list", path, k)
+		default:
+			src[key] = fmt.Sprint(v)
+		}
+	}
+	return src, nil
+}
+
+func (s Source) get(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return s[key]
+}
+
+func (s Source) getOr(key, def string) string {
+	if v := s.get(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Load reads the configuration from the environment and CONFIG_FILE and
+// validates it.
+func Load() (*Config, error) {
+	src, err := loadConfigFile(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	// MODE picks the STRIPE_TEST_* or STRIPE_LIVE_* key pair, falling back
+	// to the unprefixed STRIPE_* settings.
+	mode := strings.ToLower(src.getOr("MODE", "test"))
+	stripeSetting := func(name string) string {
+		return src.getOr("STRIPE_"+strings.ToUpper(mode)+"_"+name, src.get("STRIPE_"+name))
+	}
+	c := &Config{
+		Mode:           mode,
+		PublishableKey: stripeSetting("PUBLISHABLE_KEY"),
+		SecretKey:      stripeSetting("SECRET_KEY"),
+		Price:          src.get("PRICE"),
+		Host:           src.getOr("HOST", "0.0.0.0"),
+		Port:           src.getOr("PORT", "4242"),
+		GRPCPort:       src.get("GRPC_PORT"),
+		StaticDir:      src.get("STATIC_DIR"),
+		AdminToken:     src.get("ADMIN_TOKEN"),
+
+		ReceiptSigningKey:     src.get("RECEIPT_SIGNING_KEY"),
+		OrderStatusSigningKey: src.get("ORDER_STATUS_SIGNING_KEY"),
+
+		SessionSummarySigningKey: src.get("SESSION_SUMMARY_SIGNING_KEY"),
+		PortalSigningKey:         src.get("PORTAL_SIGNING_KEY"),
+
+		DownloadsDir:       src.get("DOWNLOADS_DIR"),
+		DownloadSigningKey: src.get("DOWNLOAD_SIGNING_KEY"),
+		LicenseSigningKey:  src.get("LICENSE_SIGNING_KEY"),
+
+		SafetyConfirmation: strings.ToLower(src.getOr("SAFETY_CONFIRMATION", confirmationToken)),
+		SafetySigningKey:   src.get("SAFETY_SIGNING_KEY"),
+
+		TLSCertFile:         src.get("TLS_CERT_FILE"),
+		TLSKeyFile:          src.get("TLS_KEY_FILE"),
+		TLSAutocertCacheDir: src.getOr("TLS_AUTOCERT_CACHE_DIR", "certs"),
+		HTTPRedirectPort:    src.get("HTTP_REDIRECT_PORT"),
+
+		AllowPromotionCodes: src.get("ALLOW_PROMOTION_CODES") == "true",
+		CheckoutRecovery:    src.get("CHECKOUT_RECOVERY") == "true",
+		RecoveryCoupon:      src.get("RECOVERY_COUPON"),
+		AdjustableQuantity:  src.get("ADJUSTABLE_QUANTITY") == "true",
+		TaxIDCollection:     src.get("TAX_ID_COLLECTION") == "true",
+		VIESValidation:      src.get("VIES_VALIDATION") == "true",
+		VIESURL:             src.getOr("VIES_URL", "https://ec.europa.eu/taxation_customs/vies/rest-api/check-vat-number"),
+		Overcapture:         src.get("OVERCAPTURE") == "true",
+		TrustProxy:          src.get("TRUST_PROXY") == "true",
+		DevReplayEnabled:    src.get("DEV_REPLAY_ENABLED") == "true",
+		SwaggerUIEnabled:    src.get("SWAGGER_UI_ENABLED") == "true",
+		RateLimitEnabled:    src.get("RATE_LIMIT_ENABLED") != "false",
+
+		CORSAllowCredentials: src.get("CORS_ALLOW_CREDENTIALS") == "true",
+
+		SubscriptionProrationBehavior: src.getOr("SUBSCRIPTION_PRORATION_BEHAVIOR", "create_prorations"),
+
+		DonationCurrency: strings.ToLower(src.getOr("DONATION_CURRENCY", "usd")),
+		DonationName:     src.getOr("DONATION_NAME", "Donation"),
+
+		CacheBackend: src.getOr("CACHE_BACKEND", "memory"),
+		RedisURL:     src.get("REDIS_URL"),
+
+		DatabaseDriver: src.getOr("DATABASE_DRIVER", "sqlite"),
+		DatabaseURL:    src.get("DATABASE_URL"),
+		Standalone:     src.get("STANDALONE") == "true",
+
+		EmailBackend:   src.get("EMAIL_BACKEND"),
+		EmailFrom:      src.get("EMAIL_FROM"),
+		SMTPHost:       src.get("SMTP_HOST"),
+		SMTPPort:       src.getOr("SMTP_PORT", "587"),
+		SMTPUsername:   src.get("SMTP_USERNAME"),
+		SMTPPassword:   src.get("SMTP_PASSWORD"),
+		SendGridAPIKey: src.get("SENDGRID_API_KEY"),
+		Receipts:       src.getOr("RECEIPTS", ReceiptsCustom),
+		NotifyEmail:    src.get("NOTIFY_EMAIL"),
+
+		SlackWebhookURL:   src.get("SLACK_WEBHOOK_URL"),
+		DiscordWebhookURL: src.get("DISCORD_WEBHOOK_URL"),
+
+		OutboundWebhookSecret: src.get("OUTBOUND_WEBHOOK_SECRET"),
+
+		EventBus:      src.get("EVENT_BUS"),
+		EventBusTopic: src.getOr("EVENT_BUS_TOPIC", "payments"),
+		NATSURL:       src.get("NATS_URL"),
+
+		EmailTemplateDir:    src.get("EMAIL_TEMPLATE_DIR"),
+		EmailPreviewEnabled: src.get("EMAIL_PREVIEW_ENABLED") == "true",
+
+		WebhookAutoRegister: src.get("WEBHOOK_AUTO_REGISTER") == "true",
+		WebhookSecretFile:   src.getOr("WEBHOOK_SECRET_FILE", "webhook_secret"),
+
+		JobQueueFile: src.getOr("JOB_QUEUE_FILE", "jobs.json"),
+		LogFormat:    src.getOr("LOG_FORMAT", "json"),
+		LogLevel:     src.getOr("LOG_LEVEL", "info"),
+		ServiceName:  src.getOr("OTEL_SERVICE_NAME", "stripe_go"),
+	}
+	c.Branding = parseBranding(src, "")
+	c.Tenants = parseTenants(src)
+	c.Domain = strings.TrimSuffix(src.getOr("DOMAIN", "http://localhost:"+c.Port), "/")
+	if c.DatabaseDriver == "sqlite" && c.DatabaseURL == "" {
+		c.DatabaseURL = "payments.db"
+	}
+	c.JobQueueBackend, c.EventStore = src.getOr("JOB_QUEUE_BACKEND", "file"), src.getOr("EVENT_STORE", "memory")
+	if c.Standalone {
+		c.JobQueueBackend, c.EventStore = src.getOr("JOB_QUEUE_BACKEND", "database"), src.getOr("EVENT_STORE", "database")
+	}
+	for _, v := range []struct {
+		key  string
+		def  string
+		dest *time.Duration
+	}{
+		{"STRIPE_TIMEOUT", "20s", &c.StripeTimeout},
+		{"STRIPE_RETRY_BACKOFF", "500ms", &c.StripeRetryBackoff},
+		{"CACHE_PRICE_TTL", "5m", &c.CachePriceTTL},
+		{"CACHE_SESSION_TTL", "30s", &c.CacheSessionTTL},
+		{"SHUTDOWN_TIMEOUT", "15s", &c.ShutdownTimeout},
+		{"REQUEST_TIMEOUT", "30s", &c.RequestTimeout},
+		{"ADMIN_REQUEST_TIMEOUT", "5m", &c.AdminRequestTimeout},
+		{"READ_HEADER_TIMEOUT", "10s", &c.ReadHeaderTimeout},
+		{"READ_TIMEOUT", "1m", &c.ReadTimeout},
+		{"WRITE_TIMEOUT", "1m", &c.WriteTimeout},
+		{"IDLE_TIMEOUT", "2m", &c.IdleTimeout},
+		{"HSTS_MAX_AGE", "8760h", &c.HSTSMaxAge},
+		{"CATALOG_REFRESH_INTERVAL", "0", &c.CatalogRefreshInterval},
+		{"RECONCILE_INTERVAL", "0", &c.ReconcileInterval},
+		{"RECONCILE_WINDOW", "72h", &c.ReconcileWindow},
+		{"WEBHOOK_TOLERANCE", "5m", &c.WebhookTolerance},
+		{"EVENT_DEDUPE_TTL", "72h", &c.EventDedupeTTL},
+		{"EVENT_POLL_INTERVAL", "2s", &c.EventPollInterval},
+		{"IDEMPOTENCY_KEY_TTL", "24h", &c.IdempotencyKeyTTL},
+		{"JOB_RETRY_BACKOFF", "5s", &c.JobRetryBackoff},
+		{"INVENTORY_RESERVATION_TTL", "1h", &c.InventoryReservationTTL},
+		{"CHECKOUT_SESSION_TTL", "24h", &c.CheckoutSessionTTL},
+		{"SECRET_REFRESH_INTERVAL", "5m", &c.SecretRefreshInterval},
+		{"RECOVERY_EMAIL_DELAY", "1h", &c.RecoveryEmailDelay},
+		{"AUTHENTICATION_WINDOW", "72h", &c.AuthenticationWindow},
+		{"RECEIPT_LINK_TTL", "720h", &c.ReceiptLinkTTL},
+		{"PORTAL_LINK_TTL", "1h", &c.PortalLinkTTL},
+		{"DOWNLOAD_LINK_TTL", "24h", &c.DownloadLinkTTL},
+		{"SAFETY_CONFIRMATION_TTL", "10m", &c.SafetyConfirmationTTL},
+		{"CORS_MAX_AGE", "10m", &c.CORSMaxAge},
+	} {
+		d, err := time.ParseDuration(src.getOr(v.key, v.def))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", v.key, err)
+		}
+		*v.dest = d
+	}
+	for _, d := range strings.Split(src.get("TLS_AUTOCERT_DOMAINS"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			c.TLSAutocertDomains = append(c.TLSAutocertDomains, d)
+		}
+	}
+	for _, o := range strings.Split(src.get("CORS_ALLOWED_ORIGINS"), ",") {
+		if o = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(o)), "/"); o != "" {
+			c.CORSAllowedOrigins = append(c.CORSAllowedOrigins, o)
+		}
+	}
+	for _, t := range strings.Split(src.get("PAYMENT_METHOD_TYPES"), ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			c.PaymentMethodTypes = append(c.PaymentMethodTypes, t)
+		}
+	}
+	for _, country := range strings.Split(src.get("SHIPPING_COUNTRIES"), ",") {
+		if country = strings.ToUpper(strings.TrimSpace(country)); country != "" {
+			c.ShippingCountries = append(c.ShippingCountries, country)
+		}
+	}
+	if c.ShippingRates, err = parseShippingRates(src.get("SHIPPING_RATES")); err != nil {
+		return nil, err
+	}
+	if c.APIKeys, err = parseAPIKeys(src.get("API_KEYS")); err != nil {
+		return nil, err
+	}
+	if c.Secrets, err = newSecretProvider(src); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+	defer cancel()
+	if c.SecretKey, err = resolveSecret(ctx, c.Secrets, "STRIPE_SECRET_KEY", c.SecretKey); err != nil {
+		return nil, err
+	}
+	for _, secret := range strings.Split(stripeSetting("WEBHOOK_SECRET"), ",") {
+		if secret, err = resolveSecret(ctx, c.Secrets, "STRIPE_WEBHOOK_SECRET", strings.TrimSpace(secret)); err != nil {
+			return nil, err
+		}
+		if secret != "" {
+			c.WebhookSecrets = append(c.WebhookSecrets, secret)
+		}
+	}
+	if secret, err := LoadWebhookSecret(c.WebhookSecretFile); err != nil {
+		return nil, fmt.Errorf("reading WEBHOOK_SECRET_FILE: %w", err)
+	} else if secret != "" {
+		c.WebhookSecrets = append(c.WebhookSecrets, secret)
+	}
+	if c.Mode == "mock" {
+		// The mock needs no account, so everything it checks gets a
+		// placeholder.
+		if c.PublishableKey == "" {
+			c.PublishableKey = "pk_test_mock"
+		}
+		if c.SecretKey == "" {
+			c.SecretKey = "sk_test_mock"
+		}
+		if c.Price == "" {
+			c.Price = MockPrice
+		}
+		if len(c.WebhookSecrets) == 0 {
+			c.WebhookSecrets = []string{mockWebhookKey}
+		}
+	}
+	for _, secret := range strings.Split(src.get("JWT_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			c.JWTSecrets = append(c.JWTSecrets, secret)
+		}
+	}
+	for _, v := range []struct {
+		key  string
+		dest *[]string
+	}{
+		{"NOTIFY_EMAIL_EVENTS", &c.NotifyEmailEvents},
+		{"SLACK_NOTIFY_EVENTS", &c.SlackNotifyEvents},
+		{"DISCORD_NOTIFY_EVENTS", &c.DiscordNotifyEvents},
+	} {
+		for _, event := range strings.Split(src.get(v.key), ",") {
+			if event = strings.ToLower(strings.TrimSpace(event)); event == "" {
+				continue
+			}
+			if !knownNotificationEvent(event) {
+				return nil, fmt.Errorf("unknown %s entry %q", v.key, event)
+			}
+			*v.dest = append(*v.dest, event)
+		}
+	}
+	if c.OutboundWebhooks, err = parseOutboundWebhooks(src.get("OUTBOUND_WEBHOOKS")); err != nil {
+		return nil, err
+	}
+	if c.EventForwards, err = parseEventForwards(src.get("EVENT_FORWARDS"), src.get("EVENT_FORWARD_EVENTS")); err != nil {
+		return nil, err
+	}
+	if c.FulfillmentRoutes, err = ParseFulfillmentRoutes(src.get("FULFILLMENT_ROUTES")); err != nil {
+		return nil, err
+	}
+	if c.SuccessPages, err = ParseSuccessPages(src.get("SUCCESS_PAGES")); err != nil {
+		return nil, err
+	}
+	for _, event := range strings.Split(src.get("OUTBOUND_WEBHOOK_EVENTS"), ",") {
+		if event = strings.ToLower(strings.TrimSpace(event)); event == "" {
+			continue
+		}
+		if !strings.HasPrefix(event, PaymentEventPrefix) || event == PaymentEventPrefix {
+			return nil, fmt.Errorf("invalid OUTBOUND_WEBHOOK_EVENTS entry %q: want %s<status>", event, PaymentEventPrefix)
+		}
+		c.OutboundWebhookEvents = append(c.OutboundWebhookEvents, event)
+	}
+	for _, broker := range strings.Split(src.get("KAFKA_BROKERS"), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			c.KafkaBrokers = append(c.KafkaBrokers, broker)
+		}
+	}
+	for _, to := range strings.Split(src.get("REPORT_RECIPIENTS"), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			c.ReportRecipients = append(c.ReportRecipients, to)
+		}
+	}
+	reportTime, err := time.Parse("15:04", src.getOr("REPORT_TIME", "01:00"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REPORT_TIME %q: want HH:MM", src.get("REPORT_TIME"))
+	}
+	c.ReportTime = time.Duration(reportTime.Hour())*time.Hour + time.Duration(reportTime.Minute())*time.Minute
+	c.NotifyPaymentThreshold, err = strconv.ParseInt(src.getOr("NOTIFY_PAYMENT_THRESHOLD", "0"), 10, 64)
+	if err != nil || c.NotifyPaymentThreshold < 0 {
+		return nil, fmt.Errorf("invalid NOTIFY_PAYMENT_THRESHOLD %q", src.get("NOTIFY_PAYMENT_THRESHOLD"))
+	}
+	c.NotifyAbandonedThreshold, err = strconv.ParseInt(src.getOr("NOTIFY_ABANDONED_THRESHOLD", "0"), 10, 64)
+	if err != nil || c.NotifyAbandonedThreshold < 0 {
+		return nil, fmt.Errorf("invalid NOTIFY_ABANDONED_THRESHOLD %q", src.get("NOTIFY_ABANDONED_THRESHOLD"))
+	}
+	for _, h := range strings.Split(src.get("RETURN_URL_HOSTS"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h == "" {
+			continue
+		}
+		if strings.ContainsAny(h, "/:") {
+			return nil, fmt.Errorf("invalid RETURN_URL_HOSTS entry %q: use host names only", h)
+		}
+		c.ReturnURLHosts = append(c.ReturnURLHosts, h)
+	}
+	for _, v := range []struct {
+		key  string
+		def  string
+		dest *int
+	}{
+		{"RATE_LIMIT_PER_MINUTE", "120", &c.RateLimitPerMinute},
+		{"RATE_LIMIT_BURST", "30", &c.RateLimitBurst},
+		{"SESSION_RATE_LIMIT_PER_MINUTE", "10", &c.SessionRateLimitPerMinute},
+		{"SESSION_RATE_LIMIT_BURST", "5", &c.SessionRateLimitBurst},
+		{"JOB_MAX_ATTEMPTS", "8", &c.JobMaxAttempts},
+		{"CACHE_SIZE", "1000", &c.CacheSize},
+	} {
+		n, err := strconv.Atoi(src.getOr(v.key, v.def))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s %q", v.key, src.get(v.key))
+		}
+		*v.dest = n
+	}
+	c.Inventory, err = parseInventory(src.get("INVENTORY"))
+	if err != nil {
+		return nil, err
+	}
+	c.PriceLimits, err = parsePriceLimits(src.get("PRICE_LIMITS"))
+	if err != nil {
+		return nil, err
+	}
+	c.CheckoutAmountLimits, err = parseAmountLimits(src.get("CHECKOUT_AMOUNT_LIMITS"))
+	if err != nil {
+		return nil, err
+	}
+	c.MaxCartQuantity, err = strconv.ParseInt(src.getOr("MAX_CART_QUANTITY", "0"), 10, 64)
+	if err != nil || c.MaxCartQuantity < 0 {
+		return nil, fmt.Errorf("invalid MAX_CART_QUANTITY %q", src.get("MAX_CART_QUANTITY"))
+	}
+	c.ApplicationFeePercent, err = strconv.ParseFloat(src.getOr("APPLICATION_FEE_PERCENT", "0"), 64)
+	if err != nil || c.ApplicationFeePercent < 0 || c.ApplicationFeePercent > 100 {
+		return nil, fmt.Errorf("invalid APPLICATION_FEE_PERCENT %q", src.get("APPLICATION_FEE_PERCENT"))
+	}
+	c.TraceSampleRatio, err = strconv.ParseFloat(src.getOr("TRACE_SAMPLE_RATIO", "1"), 64)
+	if err != nil || c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return nil, fmt.Errorf("invalid TRACE_SAMPLE_RATIO %q: want a number from 0 to 1", src.get("TRACE_SAMPLE_RATIO"))
+	}
+	// As in other OpenTelemetry SDKs, the generic endpoint is a base URL
+	// and the traces one is used as is.
+	c.OTLPTracesEndpoint = src.get("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if base := src.get("OTEL_EXPORTER_OTLP_ENDPOINT"); c.OTLPTracesEndpoint == "" && base != "" {
+		c.OTLPTracesEndpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if c.OTLPHeaders, err = parseOTLPHeaders(src.get("OTEL_EXPORTER_OTLP_HEADERS")); err != nil {
+		return nil, err
+	}
+	c.StripeMaxRetries, err = strconv.Atoi(src.getOr("STRIPE_MAX_RETRIES", "2"))
+	if err != nil || c.StripeMaxRetries < 0 {
+		return nil, fmt.Errorf("invalid STRIPE_MAX_RETRIES %q", src.get("STRIPE_MAX_RETRIES"))
+	}
+	for _, v := range []struct {
+		key  string
+		def  string
+		dest *int64
+	}{
+		{"MAX_QUANTITY", "10", &c.MaxQuantity},
+		{"ADJUSTABLE_QUANTITY_MIN", "1", &c.AdjustableQuantityMin},
+		{"ADJUSTABLE_QUANTITY_MAX", "10", &c.AdjustableQuantityMax},
+		{"DONATION_MIN_AMOUNT", "100", &c.DonationMinAmount},
+		{"DONATION_MAX_AMOUNT", "1000000", &c.DonationMaxAmount},
+		{"SAFETY_REFUND_THRESHOLD", "50000", &c.SafetyRefundThreshold},
+		{"MAX_REQUEST_BODY", "1048576", &c.MaxRequestBody},
+	} {
+		n, err := strconv.ParseInt(src.getOr(v.key, v.def), 10, 64)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s %q", v.key, src.get(v.key))
+		}
+		*v.dest = n
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks the loaded settings and reports every problem at once.
+func (c *Config) Validate() error {
+	var errs []error
+	pubMode, err := keyMode(c.PublishableKey, "pk_")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("STRIPE_PUBLISHABLE_KEY: %w", err))
+	}
+	secretMode, err := keyMode(c.SecretKey, "sk_", "rk_")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("STRIPE_SECRET_KEY: %w", err))
+	}
+	if pubMode != "" && secretMode != "" && pubMode != secretMode {
+		errs = append(errs, fmt.Errorf("STRIPE_PUBLISHABLE_KEY is a %s key but STRIPE_SECRET_KEY is a %s key", pubMode, secretMode))
+	}
+	switch c.Mode {
+	case "test", "live", "mock":
+		for _, k := range []struct{ name, mode string }{{"STRIPE_PUBLISHABLE_KEY", pubMode}, {"STRIPE_SECRET_KEY", secretMode}} {
+			if k.mode != "" && k.mode != c.stripeKeyMode() {
+				errs = append(errs, fmt.Errorf("MODE is %s but %s is a %s mode key", c.Mode, k.name, k.mode))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("MODE must be test, live or mock, not %q", c.Mode))
+	}
+	if c.DevReplayEnabled && secretMode == "live" {
+		errs = append(errs, errors.New("DEV_REPLAY_ENABLED can't be used with live mode keys"))
+	}
+	if c.SwaggerUIEnabled && secretMode == "live" {
+		errs = append(errs, errors.New("SWAGGER_UI_ENABLED can't be used with live mode keys"))
+	}
+	if c.EmailPreviewEnabled && secretMode == "live" {
+		errs = append(errs, errors.New("EMAIL_PREVIEW_ENABLED can't be used with live mode keys"))
+	}
+	names := map[string]bool{}
+	for _, k := range c.APIKeys {
+		if !Roles[k.Role] {
+			errs = append(errs, fmt.Errorf("API_KEYS entry %s: unknown role %q, want admin or readonly", k.Name, k.Role))
+		}
+		if len(k.Key) < 16 {
+			errs = append(errs, fmt.Errorf("API_KEYS entry %s: key must be at least 16 characters", k.Name))
+		}
+		if names[k.Name] {
+			errs = append(errs, fmt.Errorf("API_KEYS has two keys named %s", k.Name))
+		}
+		names[k.Name] = true
+	}
+	for _, secret := range c.JWTSecrets {
+		if len(secret) < 32 {
+			errs = append(errs, errors.New("JWT_SECRETS entries must be at least 32 characters"))
+			break
+		}
+	}
+	for _, secret := range c.WebhookSecrets {
+		if !strings.HasPrefix(secret, "whsec_") {
+			errs = append(errs, errors.New("STRIPE_WEBHOOK_SECRET entries must start with whsec_"))
+			break
+		}
+	}
+	if c.Price == "price_12345" || c.Price == "" {
+		errs = append(errs, errors.New("You must set a Price ID from your Stripe account. See the README for instructions."))
+	} else if !strings.HasPrefix(c.Price, "price_") {
+		errs = append(errs, fmt.Errorf("PRICE %q must be a Price ID starting with price_", c.Price))
+	}
+	if c.Branding.Color != "" && !colorPattern.MatchString(c.Branding.Color) {
+		errs = append(errs, errors.New("BRAND_COLOR must look like #1a2b3c"))
+	}
+	errs = append(errs, validateTenants(c)...)
+	for _, t := range c.PaymentMethodTypes {
+		if !PaymentMethodTypes[t] {
+			errs = append(errs, fmt.Errorf("unknown PAYMENT_METHOD_TYPES entry %q", t))
+		}
+	}
+	for _, country := range c.ShippingCountries {
+		if len(country) != 2 || strings.Trim(country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			errs = append(errs, fmt.Errorf("invalid SHIPPING_COUNTRIES entry %q: use two-letter country codes", country))
+		}
+	}
+	if len(c.ShippingRates) > 0 && len(c.ShippingCountries) == 0 {
+		errs = append(errs, errors.New("SHIPPING_RATES needs SHIPPING_COUNTRIES"))
+	}
+	if c.VIESValidation && !c.TaxIDCollection {
+		errs = append(errs, errors.New("VIES_VALIDATION needs TAX_ID_COLLECTION"))
+	}
+	if len(c.ShippingRates) > maxShippingRates {
+		errs = append(errs, fmt.Errorf("SHIPPING_RATES can have at most %d entries", maxShippingRates))
+	}
+	if c.WebhookAutoRegister && c.Mode == "mock" {
+		errs = append(errs, errors.New("WEBHOOK_AUTO_REGISTER can't be used with MODE=mock, which delivers its own events"))
+	} else if c.WebhookAutoRegister {
+		if u, err := url.Parse(c.Domain); err != nil || u.Scheme != "https" || IsLocalHost(u.Hostname()) {
+			errs = append(errs, errors.New("WEBHOOK_AUTO_REGISTER needs a public https DOMAIN for Stripe to deliver events to"))
+		}
+	}
+	if err := validateOrigin("DOMAIN", c.Domain); err != nil {
+		errs = append(errs, err)
+	}
+	for _, o := range c.CORSAllowedOrigins {
+		if o == "*" {
+			if c.CORSAllowCredentials {
+				errs = append(errs, errors.New("CORS_ALLOW_CREDENTIALS needs explicit CORS_ALLOWED_ORIGINS, not *"))
+			}
+		} else if err := validateOrigin("CORS_ALLOWED_ORIGINS entry", o); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	if c.TLSCertFile != "" && len(c.TLSAutocertDomains) > 0 {
+		errs = append(errs, errors.New("set either TLS_CERT_FILE or TLS_AUTOCERT_DOMAINS, not both"))
+	}
+	switch c.DatabaseDriver {
+	case "", "sqlite", "postgres":
+	default:
+		errs = append(errs, fmt.Errorf("unknown DATABASE_DRIVER %q", c.DatabaseDriver))
+	}
+	switch c.JobQueueBackend {
+	case "", "file":
+	case "database":
+		// The queue is saved whole into one row, so instances sharing a
+		// Postgres database would overwrite each other's jobs.
+		if c.DatabaseDriver == "postgres" {
+			errs = append(errs, errors.New("JOB_QUEUE_BACKEND=database isn't shared between instances; use it with SQLite"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown JOB_QUEUE_BACKEND %q", c.JobQueueBackend))
+	}
+	switch c.EventStore {
+	case "", "memory", "database":
+	default:
+		errs = append(errs, fmt.Errorf("unknown EVENT_STORE %q", c.EventStore))
+	}
+	if c.Standalone {
+		if c.DatabaseDriver == "postgres" {
+			errs = append(errs, errors.New("STANDALONE runs on SQLite; unset DATABASE_DRIVER=postgres"))
+		}
+		if c.CacheBackend == "redis" {
+			errs = append(errs, errors.New("STANDALONE doesn't use Redis; set CACHE_BACKEND to memory or none"))
+		}
+	}
+	switch c.EmailBackend {
+	case "":
+	case "smtp":
+		if c.SMTPHost == "" || c.EmailFrom == "" {
+			errs = append(errs, errors.New("SMTP_HOST and EMAIL_FROM must be set for the smtp email backend"))
+		}
+	case "sendgrid":
+		if c.SendGridAPIKey == "" || c.EmailFrom == "" {
+			errs = append(errs, errors.New("SENDGRID_API_KEY and EMAIL_FROM must be set for the sendgrid email backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown EMAIL_BACKEND %q", c.EmailBackend))
+	}
+	switch c.Receipts {
+	case "", ReceiptsCustom, ReceiptsStripe, ReceiptsBoth:
+	default:
+		errs = append(errs, fmt.Errorf("RECEIPTS must be custom, stripe or both, not %q", c.Receipts))
+	}
+	if c.NotifyEmail != "" {
+		if err := ValidateEmail(c.NotifyEmail); err != nil {
+			errs = append(errs, fmt.Errorf("NOTIFY_EMAIL: %w", err))
+		}
+	}
+	for _, to := range c.ReportRecipients {
+		if err := ValidateEmail(to); err != nil {
+			errs = append(errs, fmt.Errorf("REPORT_RECIPIENTS entry %s: %w", to, err))
+		}
+	}
+	webhookURLs := []struct{ setting, url string }{
+		{"SLACK_WEBHOOK_URL", c.SlackWebhookURL},
+		{"DISCORD_WEBHOOK_URL", c.DiscordWebhookURL},
+	}
+	endpoints := map[string]bool{}
+	for _, e := range c.OutboundWebhooks {
+		if endpoints[e.Name] {
+			errs = append(errs, fmt.Errorf("OUTBOUND_WEBHOOKS has two endpoints named %s", e.Name))
+		}
+		endpoints[e.Name] = true
+		webhookURLs = append(webhookURLs, struct{ setting, url string }{"OUTBOUND_WEBHOOKS entry " + e.Name, e.URL})
+	}
+	for _, f := range c.EventForwards {
+		if endpoints[f.Name] {
+			errs = append(errs, fmt.Errorf("EVENT_FORWARDS entry %s has the name of another forward or outbound webhook", f.Name))
+		}
+		endpoints[f.Name] = true
+		if err := f.validate(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.EventBus != "" {
+		if err := checkMessageBus(c, c.EventBus); err != nil {
+			errs = append(errs, fmt.Errorf("EVENT_BUS=%s %w", c.EventBus, err))
+		}
+		if c.EventBusTopic == "" {
+			errs = append(errs, errors.New("EVENT_BUS_TOPIC must not be empty"))
+		}
+	}
+	if c.VIESValidation {
+		webhookURLs = append(webhookURLs, struct{ setting, url string }{"VIES_URL", c.VIESURL})
+	}
+	if (len(c.OutboundWebhooks) > 0 || len(c.EventForwards) > 0) && len(c.OutboundWebhookSecret) < 32 {
+		errs = append(errs, errors.New("OUTBOUND_WEBHOOK_SECRET must be at least 32 characters"))
+	}
+	for _, v := range webhookURLs {
+		if v.url == "" {
+			continue
+		}
+		if u, err := url.Parse(v.url); err != nil || u.Host == "" || (u.Scheme != "https" && !(u.Scheme == "http" && IsLocalHost(u.Hostname()))) {
+			errs = append(errs, fmt.Errorf("%s must be an absolute https URL", v.setting))
+		}
+	}
+	// Checkout sessions must live between 30 minutes and 24 hours.
+	if c.InventoryReservationTTL <= 30*time.Minute || c.InventoryReservationTTL > 24*time.Hour {
+		errs = append(errs, errors.New("INVENTORY_RESERVATION_TTL must be more than 30m and at most 24h"))
+	}
+	if c.CheckoutSessionTTL <= 30*time.Minute || c.CheckoutSessionTTL > 24*time.Hour {
+		errs = append(errs, errors.New("CHECKOUT_SESSION_TTL must be more than 30m and at most 24h"))
+	}
+	if c.RecoveryEmailDelay < 0 {
+		errs = append(errs, errors.New("RECOVERY_EMAIL_DELAY must not be negative"))
+	}
+	if c.AuthenticationWindow <= 0 {
+		errs = append(errs, errors.New("AUTHENTICATION_WINDOW must be positive"))
+	}
+	if c.ReceiptSigningKey != "" {
+		if len(c.ReceiptSigningKey) < 32 {
+			errs = append(errs, errors.New("RECEIPT_SIGNING_KEY must be at least 32 characters"))
+		}
+		if c.ReceiptLinkTTL <= 0 {
+			errs = append(errs, errors.New("RECEIPT_LINK_TTL must be positive"))
+		}
+	}
+	if c.OrderStatusSigningKey != "" && len(c.OrderStatusSigningKey) < 32 {
+		errs = append(errs, errors.New("ORDER_STATUS_SIGNING_KEY must be at least 32 characters"))
+	}
+	if c.SessionSummarySigningKey != "" && len(c.SessionSummarySigningKey) < 32 {
+		errs = append(errs, errors.New("SESSION_SUMMARY_SIGNING_KEY must be at least 32 characters"))
+	}
+	if c.PortalSigningKey != "" {
+		if len(c.PortalSigningKey) < 32 {
+			errs = append(errs, errors.New("PORTAL_SIGNING_KEY must be at least 32 characters"))
+		}
+		if c.PortalLinkTTL <= 0 {
+			errs = append(errs, errors.New("PORTAL_LINK_TTL must be positive"))
+		}
+		if c.SessionSummarySigningKey == "" {
+			errs = append(errs, errors.New("PORTAL_SIGNING_KEY needs SESSION_SUMMARY_SIGNING_KEY, which proves who the success page is shown to"))
+		}
+	}
+	routes := map[string]bool{}
+	for _, r := range c.FulfillmentRoutes {
+		if routes[r.Match] {
+			errs = append(errs, fmt.Errorf("FULFILLMENT_ROUTES has two routes for %s", r.Match))
+		}
+		routes[r.Match] = true
+		if err := validateFulfillmentRoute(c, r); err != nil {
+			errs = append(errs, fmt.Errorf("FULFILLMENT_ROUTES entry %s: %w", r.Match, err))
+		}
+	}
+	if c.LicenseSigningKey != "" && len(c.LicenseSigningKey) < 32 {
+		errs = append(errs, errors.New("LICENSE_SIGNING_KEY must be at least 32 characters"))
+	}
+	if c.DownloadSigningKey != "" && c.DownloadLinkTTL <= 0 {
+		errs = append(errs, errors.New("DOWNLOAD_LINK_TTL must be positive"))
+	}
+	if !ValidProrationBehavior(c.SubscriptionProrationBehavior) {
+		errs = append(errs, fmt.Errorf("SUBSCRIPTION_PRORATION_BEHAVIOR must be create_prorations, always_invoice or none, not %q", c.SubscriptionProrationBehavior))
+	}
+	switch c.SafetyConfirmation {
+	case "", confirmationToken, ConfirmationApproval:
+	default:
+		errs = append(errs, fmt.Errorf("SAFETY_CONFIRMATION must be token or approval, not %q", c.SafetyConfirmation))
+	}
+	if c.SafetySigningKey != "" && len(c.SafetySigningKey) < 32 {
+		errs = append(errs, errors.New("SAFETY_SIGNING_KEY must be at least 32 characters"))
+	}
+	if c.Mode == "live" && (c.SafetyConfirmationTTL <= 0 || c.SafetyRefundThreshold <= 0) {
+		errs = append(errs, errors.New("SAFETY_CONFIRMATION_TTL and SAFETY_REFUND_THRESHOLD must be positive in live mode"))
+	}
+	if c.OTLPTracesEndpoint != "" {
+		if u, err := url.Parse(c.OTLPTracesEndpoint); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			errs = append(errs, errors.New("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT (or OTEL_EXPORTER_OTLP_ENDPOINT) must be an absolute http or https URL"))
+		}
+	}
+	if c.WebhookTolerance <= 0 {
+		errs = append(errs, errors.New("WEBHOOK_TOLERANCE must be positive"))
+	}
+	if c.EventDedupeTTL <= 0 {
+		errs = append(errs, errors.New("EVENT_DEDUPE_TTL must be positive"))
+	}
+	if c.EventPollInterval <= 0 {
+		errs = append(errs, errors.New("EVENT_POLL_INTERVAL must be positive"))
+	}
+	if c.IdempotencyKeyTTL <= 0 {
+		errs = append(errs, errors.New("IDEMPOTENCY_KEY_TTL must be positive"))
+	}
+	if c.StripeTimeout < 0 || c.StripeRetryBackoff < 0 {
+		errs = append(errs, errors.New("STRIPE_TIMEOUT and STRIPE_RETRY_BACKOFF can't be negative"))
+	}
+	if c.RequestTimeout < 0 || c.AdminRequestTimeout < 0 {
+		errs = append(errs, errors.New("REQUEST_TIMEOUT and ADMIN_REQUEST_TIMEOUT can't be negative"))
+	}
+	if c.ReadHeaderTimeout < 0 || c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 {
+		errs = append(errs, errors.New("READ_HEADER_TIMEOUT, READ_TIMEOUT, WRITE_TIMEOUT and IDLE_TIMEOUT can't be negative"))
+	}
+	// A write timeout that ends first cuts off the 504 a timed out request
+	// would get.
+	if c.WriteTimeout > 0 && (c.RequestTimeout == 0 || c.WriteTimeout <= c.RequestTimeout) {
+		errs = append(errs, errors.New("WRITE_TIMEOUT must be longer than REQUEST_TIMEOUT, or 0"))
+	}
+	switch c.CacheBackend {
+	case "", "memory", "none":
+	case "redis":
+		if c.RedisURL == "" {
+			errs = append(errs, errors.New("CACHE_BACKEND=redis requires REDIS_URL"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown CACHE_BACKEND %q", c.CacheBackend))
+	}
+	if c.CachePriceTTL <= 0 || c.CacheSessionTTL <= 0 {
+		errs = append(errs, errors.New("CACHE_PRICE_TTL and CACHE_SESSION_TTL must be positive"))
+	}
+	if c.CatalogRefreshInterval < 0 {
+		errs = append(errs, errors.New("CATALOG_REFRESH_INTERVAL can't be negative"))
+	}
+	if !CurrencyPattern.MatchString(c.DonationCurrency) {
+		errs = append(errs, fmt.Errorf("invalid DONATION_CURRENCY %q", c.DonationCurrency))
+	}
+	if c.DonationMaxAmount < c.DonationMinAmount {
+		errs = append(errs, errors.New("DONATION_MAX_AMOUNT can't be less than DONATION_MIN_AMOUNT"))
+	}
+	if c.AdjustableQuantity && (c.AdjustableQuantityMin > c.AdjustableQuantityMax || c.AdjustableQuantityMax > c.MaxQuantity) {
+		errs = append(errs, errors.New("ADJUSTABLE_QUANTITY_MIN and ADJUSTABLE_QUANTITY_MAX must satisfy MIN <= MAX <= MAX_QUANTITY"))
+	}
+	if c.ReconcileInterval < 0 {
+		errs = append(errs, errors.New("RECONCILE_INTERVAL can't be negative"))
+	}
+	if c.ReconcileWindow <= 0 {
+		errs = append(errs, errors.New("RECONCILE_WINDOW must be positive"))
+	}
+	switch c.LogFormat {
+	case "", "json", "text":
+	default:
+		errs = append(errs, fmt.Errorf("unknown LOG_FORMAT %q", c.LogFormat))
+	}
+	return errors.Join(errs...)
+}
+
+// parseInventory reads INVENTORY entries of the form price_id=stock,
+// separated by commas.
+func parseInventory(v string) (map[string]int64, error) {
+	inventory := map[string]int64{}
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		price, stock, ok := strings.Cut(entry, "=")
+		n, err := strconv.ParseInt(strings.TrimSpace(stock), 10, 64)
+		if !ok || err != nil || n < 0 || !strings.HasPrefix(strings.TrimSpace(price), "price_") {
+			return nil, fmt.Errorf("invalid INVENTORY entry %q: use price_id=stock", entry)
+		}
+		inventory[strings.TrimSpace(price)] = n
+	}
+	return inventory, nil
+}
+
+// parsePriceLimits reads PRICE_LIMITS entries of the form
+// price_id=min-max, separated by commas.
+func parsePriceLimits(v string) (map[string]PriceLimit, error) {
+	limits := map[string]PriceLimit{}
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		price, bounds, ok := strings.Cut(entry, "=")
+		price = strings.TrimSpace(price)
+		lo, hi, ok2 := strings.Cut(bounds, "-")
+		l := PriceLimit{Price: price}
+		var err, err2 error
+		l.MinQuantity, err = strconv.ParseInt(strings.TrimSpace(lo), 10, 64)
+		l.MaxQuantity, err2 = strconv.ParseInt(strings.TrimSpace(hi), 10, 64)
+		if !ok || !ok2 || err != nil || err2 != nil || !strings.HasPrefix(price, "price_") || l.MinQuantity < 1 || l.MaxQuantity < l.MinQuantity {
+			return nil, fmt.Errorf("invalid PRICE_LIMITS entry %q: use price_id=min-max with 1 <= min <= max", entry)
+		}
+		limits[price] = l
+	}
+	return limits, nil
+}
+
+// parseAmountLimits reads CHECKOUT_AMOUNT_LIMITS entries of the form
+// currency=min-max, in the currency's smallest unit and separated by
+// commas. Either bound may be left out.
+func parseAmountLimits(v string) (map[string]AmountLimit, error) {
+	limits := map[string]AmountLimit{}
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		currency, bounds, ok := strings.Cut(entry, "=")
+		currency = strings.ToLower(strings.TrimSpace(currency))
+		lo, hi, ok2 := strings.Cut(bounds, "-")
+		var l AmountLimit
+		var err error
+		if lo = strings.TrimSpace(lo); lo != "" {
+			l.Min, err = strconv.ParseInt(lo, 10, 64)
+		}
+		if hi = strings.TrimSpace(hi); hi != "" && err == nil {
+			l.Max, err = strconv.ParseInt(hi, 10, 64)
+		}
+		if !ok || !ok2 || err != nil || !CurrencyPattern.MatchString(currency) || l.Min < 0 || l.Max < 0 || (l.Max > 0 && l.Max < l.Min) {
+			return nil, fmt.Errorf("invalid CHECKOUT_AMOUNT_LIMITS entry %q: use currency=min-max, e.g. usd=50-500000", entry)
+		}
+		limits[currency] = l
+	}
+	return limits, nil
+}
+
+// validateOrigin checks that the setting is a bare http(s) origin such as
+// https://shop.example.com.
+func validateOrigin(setting, origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s %q must be an absolute http or https URL", setting, origin)
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("%s %q must not have a path, query or fragment", setting, origin)
+	}
+	return nil
+}
+
+// stripeKeyMode is the mode the Stripe keys must belong to: MODE, or test for
+// the mock.
+func (c *Config) stripeKeyMode() string {
+	if c.Mode == "mock" {
+		return "test"
+	}
+	return c.Mode
+}
+
+// keyMode checks that key starts with one of the given prefixes followed by
+// "test_" or "live_" and returns "test" or "live".
+func keyMode(key string, prefixes ...string) (string, error) {
+	if key == "" {
+		return "", errors.New("not set")
+	}
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		switch {
+		case strings.HasPrefix(rest, "test_"):
+			return "test", nil
+		case strings.HasPrefix(rest, "live_"):
+			return "live", nil
+		}
+	}
+	return "", fmt.Errorf("must start with %s followed by test_ or live_", strings.Join(prefixes, " or "))
+}
@@ -0,0 +1,389 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			Mode:                    "test",
+			PublishableKey:          "pk_test_123",
+			SecretKey:               "sk_test_123",
+			WebhookSecrets:          []string{"whsec_123"},
+			Price:                   "price_basic",
+			Domain:                  "https://shop.example.com",
+			WebhookTolerance:        5 * time.Minute,
+			CachePriceTTL:           time.Minute,
+			CacheSessionTTL:         time.Minute,
+			ReconcileWindow:         72 * time.Hour,
+			DonationCurrency:        "usd",
+			EventDedupeTTL:          time.Hour,
+			EventPollInterval:       time.Second,
+			IdempotencyKeyTTL:       time.Hour,
+			InventoryReservationTTL: time.Hour,
+			CheckoutSessionTTL:      24 * time.Hour,
+			AuthenticationWindow:    72 * time.Hour,
+			PortalLinkTTL:           time.Hour,
+			SafetyRefundThreshold:   50000,
+			SafetyConfirmationTTL:   10 * time.Minute,
+
+			SubscriptionProrationBehavior: "create_prorations",
+		}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid config: %v", err)
+	}
+	for _, tt := range []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"missing publishable key", func(c *Config) { c.PublishableKey = "" }, "STRIPE_PUBLISHABLE_KEY"},
+		{"secret key as publishable key", func(c *Config) { c.PublishableKey = "sk_test_123" }, "STRIPE_PUBLISHABLE_KEY"},
+		{"mixed modes", func(c *Config) { c.SecretKey = "sk_live_123" }, "is a test key but"},
+		{"restricted key", func(c *Config) { c.SecretKey = "rk_test_123" }, ""},
+		{"live mode", func(c *Config) { c.Mode, c.PublishableKey, c.SecretKey = "live", "pk_live_123", "sk_live_123" }, ""},
+		{"live mode with test keys", func(c *Config) { c.Mode = "live" }, "MODE is live but STRIPE_SECRET_KEY is a test mode key"},
+		{"test mode with live keys", func(c *Config) { c.PublishableKey, c.SecretKey = "pk_live_123", "sk_live_123" }, "MODE is test but STRIPE_PUBLISHABLE_KEY"},
+		{"unknown mode", func(c *Config) { c.Mode = "staging" }, "MODE must be test, live or mock"},
+		{"mock mode", func(c *Config) { c.Mode = "mock" }, ""},
+		{"mock mode with live keys", func(c *Config) { c.Mode, c.PublishableKey, c.SecretKey = "mock", "pk_live_123", "sk_live_123" }, "MODE is mock but STRIPE_PUBLISHABLE_KEY is a live mode key"},
+		{"replay in live mode", func(c *Config) {
+			c.Mode, c.PublishableKey, c.SecretKey = "live", "pk_live_123", "sk_live_123"
+			c.DevReplayEnabled = true
+		}, "DEV_REPLAY_ENABLED"},
+		{"replay in test mode", func(c *Config) { c.DevReplayEnabled = true }, ""},
+		{"short session TTL", func(c *Config) { c.CheckoutSessionTTL = 30 * time.Minute }, "CHECKOUT_SESSION_TTL must be more than 30m"},
+		{"long session TTL", func(c *Config) { c.CheckoutSessionTTL = 25 * time.Hour }, "CHECKOUT_SESSION_TTL must be more than 30m"},
+		{"no authentication window", func(c *Config) { c.AuthenticationWindow = 0 }, "AUTHENTICATION_WINDOW must be positive"},
+		{"unknown receipts", func(c *Config) { c.Receipts = "dashboard" }, "RECEIPTS must be custom, stripe or both"},
+		{"unknown proration behavior", func(c *Config) { c.SubscriptionProrationBehavior = "prorate" }, "SUBSCRIPTION_PRORATION_BEHAVIOR must be"},
+		{"swagger in live mode", func(c *Config) {
+			c.Mode, c.PublishableKey, c.SecretKey = "live", "pk_live_123", "sk_live_123"
+			c.SwaggerUIEnabled = true
+		}, "SWAGGER_UI_ENABLED"},
+		{"email preview in live mode", func(c *Config) {
+			c.Mode, c.PublishableKey, c.SecretKey = "live", "pk_live_123", "sk_live_123"
+			c.EmailPreviewEnabled = true
+		}, "EMAIL_PREVIEW_ENABLED"},
+		{"live mode without safety threshold", func(c *Config) {
+			c.Mode, c.PublishableKey, c.SecretKey = "live", "pk_live_123", "sk_live_123"
+			c.SafetyRefundThreshold = 0
+		}, "SAFETY_REFUND_THRESHOLD"},
+		{"unknown safety confirmation", func(c *Config) { c.SafetyConfirmation = "quorum" }, "SAFETY_CONFIRMATION"},
+		{"short safety signing key", func(c *Config) { c.SafetySigningKey = "secret" }, "SAFETY_SIGNING_KEY"},
+		{"bad shipping country", func(c *Config) { c.ShippingCountries = []string{"USA"} }, "two-letter country codes"},
+		{"shipping rates without countries", func(c *Config) { c.ShippingRates = []ShippingRate{{ID: "shr_123"}} }, "SHIPPING_RATES needs SHIPPING_COUNTRIES"},
+		{"redis cache without url", func(c *Config) { c.CacheBackend = "redis" }, "REDIS_URL"},
+		{"unknown cache", func(c *Config) { c.CacheBackend = "memcached" }, "unknown CACHE_BACKEND"},
+		{"api key role", func(c *Config) { c.APIKeys = []APIKey{{Name: "ci", Role: "owner", Key: "0123456789abcdef"}} }, "unknown role"},
+		{"short api key", func(c *Config) { c.APIKeys = []APIKey{{Name: "ci", Role: RoleAdmin, Key: "abc"}} }, "at least 16 characters"},
+		{"short jwt secret", func(c *Config) { c.JWTSecrets = []string{"secret"} }, "JWT_SECRETS"},
+		{"unknown payment method", func(c *Config) { c.PaymentMethodTypes = []string{"card", "cheque"} }, "PAYMENT_METHOD_TYPES"},
+		{"bad webhook secret", func(c *Config) { c.WebhookSecrets = []string{"whsec_new", "secret"} }, "whsec_"},
+		{"product instead of price", func(c *Config) { c.Price = "prod_123" }, "starting with price_"},
+		{"domain without scheme", func(c *Config) { c.Domain = "shop.example.com" }, "absolute http or https URL"},
+		{"domain with path", func(c *Config) { c.Domain = "https://shop.example.com/store" }, "must not have a path"},
+		{"localhost domain", func(c *Config) { c.Domain = "http://localhost:4242" }, ""},
+		{"cors origin with path", func(c *Config) { c.CORSAllowedOrigins = []string{"https://app.example.com/shop"} }, "CORS_ALLOWED_ORIGINS"},
+		{"cors credentials for any origin", func(c *Config) {
+			c.CORSAllowedOrigins, c.CORSAllowCredentials = []string{"*"}, true
+		}, "CORS_ALLOW_CREDENTIALS"},
+		{"smtp without host", func(c *Config) { c.EmailBackend = "smtp" }, "SMTP_HOST"},
+		{"unknown database", func(c *Config) { c.DatabaseDriver = "mysql" }, "DATABASE_DRIVER"},
+		{"short receipt key", func(c *Config) { c.ReceiptSigningKey, c.ReceiptLinkTTL = "secret", time.Hour }, "RECEIPT_SIGNING_KEY"},
+		{"short order status key", func(c *Config) { c.OrderStatusSigningKey = "secret" }, "ORDER_STATUS_SIGNING_KEY"},
+		{"short session summary key", func(c *Config) { c.SessionSummarySigningKey = "secret" }, "SESSION_SUMMARY_SIGNING_KEY"},
+		{"short portal key", func(c *Config) {
+			c.PortalSigningKey, c.SessionSummarySigningKey = "secret", strings.Repeat("s", 32)
+		}, "PORTAL_SIGNING_KEY must be at least 32 characters"},
+		{"portal key without a summary key", func(c *Config) { c.PortalSigningKey = strings.Repeat("p", 32) }, "PORTAL_SIGNING_KEY needs SESSION_SUMMARY_SIGNING_KEY"},
+		{"unknown fulfillment type", func(c *Config) {
+			c.FulfillmentRoutes = []FulfillmentRoute{{Match: "prod_1", Type: "fax"}}
+		}, `unknown type "fax"`},
+		{"license without a signing key", func(c *Config) {
+			c.FulfillmentRoutes = []FulfillmentRoute{{Match: "prod_1", Type: "license"}}
+		}, "LICENSE_SIGNING_KEY"},
+		{"download without a signing key", func(c *Config) {
+			c.FulfillmentRoutes = []FulfillmentRoute{{Match: "price_1", Type: "download", Target: "ebook.pdf"}}
+			c.DownloadsDir = "downloads"
+		}, "DOWNLOAD_SIGNING_KEY"},
+		{"download outside DOWNLOADS_DIR", func(c *Config) {
+			c.FulfillmentRoutes = []FulfillmentRoute{{Match: "price_1", Type: "download", Target: "../secrets.txt"}}
+		}, "file name in DOWNLOADS_DIR"},
+		{"duplicate fulfillment route", func(c *Config) {
+			c.FulfillmentRoutes = []FulfillmentRoute{{Match: "prod_1", Type: "license"}, {Match: "prod_1", Type: "shipment"}}
+		}, "two routes for prod_1"},
+		{"plain http slack webhook", func(c *Config) { c.SlackWebhookURL = "http://hooks.slack.com/services/x" }, "SLACK_WEBHOOK_URL"},
+		{"outbound webhook without secret", func(c *Config) {
+			c.OutboundWebhooks = []OutboundWebhook{{Name: "crm", URL: "https://crm.example.com/hooks"}}
+		}, "OUTBOUND_WEBHOOK_SECRET"},
+		{"plain http outbound webhook", func(c *Config) {
+			c.OutboundWebhooks = []OutboundWebhook{{Name: "crm", URL: "http://crm.example.com/hooks"}}
+			c.OutboundWebhookSecret = strings.Repeat("s", 32)
+		}, "OUTBOUND_WEBHOOKS entry crm"},
+		{"duplicate outbound webhook", func(c *Config) {
+			c.OutboundWebhooks = []OutboundWebhook{{Name: "crm", URL: "https://a.example.com"}, {Name: "crm", URL: "https://b.example.com"}}
+			c.OutboundWebhookSecret = strings.Repeat("s", 32)
+		}, "two endpoints named crm"},
+		{"event forward without secret", func(c *Config) {
+			c.EventForwards = []EventForward{{Name: "billing", URL: "http://billing.internal/stripe"}}
+		}, "OUTBOUND_WEBHOOK_SECRET"},
+		{"event forward named like an outbound webhook", func(c *Config) {
+			c.OutboundWebhooks = []OutboundWebhook{{Name: "crm", URL: "https://crm.example.com/hooks"}}
+			c.EventForwards = []EventForward{{Name: "crm", URL: "http://crm.internal/stripe"}}
+			c.OutboundWebhookSecret = strings.Repeat("s", 32)
+		}, "EVENT_FORWARDS entry crm has the name"},
+		{"event forward to redis without REDIS_URL", func(c *Config) {
+			c.EventForwards = []EventForward{{Name: "ledger", Bus: "redis", Topic: "stripe-events"}}
+			c.OutboundWebhookSecret = strings.Repeat("s", 32)
+		}, "EVENT_FORWARDS entry ledger needs REDIS_URL"},
+		{"event forward to unknown bus", func(c *Config) {
+			c.EventForwards = []EventForward{{Name: "ledger", Bus: "amqp", Topic: "stripe-events"}}
+			c.OutboundWebhookSecret = strings.Repeat("s", 32)
+		}, `unknown message bus "amqp"`},
+		{"kafka event bus without brokers", func(c *Config) {
+			c.EventBus, c.EventBusTopic = "kafka", "payments"
+		}, "EVENT_BUS=kafka needs KAFKA_BROKERS"},
+		{"event bus without topic", func(c *Config) {
+			c.EventBus, c.NATSURL = "nats", "nats://localhost:4222"
+		}, "EVENT_BUS_TOPIC"},
+		{"bad report recipient", func(c *Config) { c.ReportRecipients = []string{"finance"} }, "REPORT_RECIPIENTS entry finance"},
+		{"bad brand color", func(c *Config) { c.Branding.Color = "orange" }, "BRAND_COLOR"},
+		{"tenant in the other mode", func(c *Config) {
+			c.Tenants = []*Tenant{{ID: "acme", PublishableKey: "pk_live_1", SecretKey: "sk_test_1", Price: "price_1"}}
+		}, "TENANTS entry acme: MODE is test but the publishable key is a live mode key"},
+		{"tenant without price", func(c *Config) {
+			c.Tenants = []*Tenant{{ID: "acme", PublishableKey: "pk_test_1", SecretKey: "sk_test_1"}}
+		}, "TENANTS entry acme: a Price ID"},
+		{"zero dedupe TTL", func(c *Config) { c.EventDedupeTTL = 0 }, "EVENT_DEDUPE_TTL"},
+		{"unknown event store", func(c *Config) { c.EventStore = "redis" }, "EVENT_STORE"},
+		{"unknown job queue backend", func(c *Config) { c.JobQueueBackend = "kafka" }, "JOB_QUEUE_BACKEND"},
+		{"job queue on postgres", func(c *Config) { c.JobQueueBackend, c.DatabaseDriver = "database", "postgres" }, "JOB_QUEUE_BACKEND=database"},
+		{"standalone on postgres", func(c *Config) { c.Standalone, c.DatabaseDriver = true, "postgres" }, "STANDALONE runs on SQLite"},
+		{"standalone with redis", func(c *Config) {
+			c.Standalone, c.CacheBackend, c.RedisURL = true, "redis", "redis://localhost:6379/0"
+		}, "STANDALONE doesn't use Redis"},
+		{"standalone", func(c *Config) { c.Standalone, c.JobQueueBackend, c.EventStore = true, "database", "database" }, ""},
+		{"zero idempotency key TTL", func(c *Config) { c.IdempotencyKeyTTL = 0 }, "IDEMPOTENCY_KEY_TTL"},
+		{"negative request timeout", func(c *Config) { c.AdminRequestTimeout = -time.Second }, "ADMIN_REQUEST_TIMEOUT"},
+		{"negative idle timeout", func(c *Config) { c.IdleTimeout = -time.Second }, "IDLE_TIMEOUT"},
+		{"write timeout before request timeout", func(c *Config) { c.WriteTimeout, c.RequestTimeout = 10*time.Second, 30*time.Second }, "WRITE_TIMEOUT must be longer"},
+		{"write timeout without request timeout", func(c *Config) { c.WriteTimeout, c.RequestTimeout = time.Minute, 0 }, "WRITE_TIMEOUT must be longer"},
+		{"write timeout", func(c *Config) { c.WriteTimeout, c.RequestTimeout = time.Minute, 30*time.Second }, ""},
+		{"placeholder price", func(c *Config) { c.Price = "price_12345" }, "Price ID"},
+		{"adjustable quantity above max", func(c *Config) {
+			c.AdjustableQuantity, c.MaxQuantity, c.AdjustableQuantityMin, c.AdjustableQuantityMax = true, 10, 1, 20
+		}, "ADJUSTABLE_QUANTITY_MAX"},
+		{"adjustable quantity", func(c *Config) {
+			c.AdjustableQuantity, c.MaxQuantity, c.AdjustableQuantityMin, c.AdjustableQuantityMax = true, 10, 2, 5
+		}, ""},
+		{"webhook auto registration", func(c *Config) { c.WebhookAutoRegister = true }, ""},
+		{"webhook auto registration on localhost", func(c *Config) {
+			c.WebhookAutoRegister, c.Domain = true, "http://localhost:4242"
+		}, "WEBHOOK_AUTO_REGISTER needs a public https DOMAIN"},
+		{"cert without key", func(c *Config) { c.TLSCertFile = "cert.pem" }, "set together"},
+		{"cert and autocert", func(c *Config) {
+			c.TLSCertFile, c.TLSKeyFile = "cert.pem", "key.pem"
+			c.TLSAutocertDomains = []string{"shop.example.com"}
+		}, "not both"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(c)
+			err := c.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidateReportsEveryProblem(t *testing.T) {
+	err := (&Config{EventDedupeTTL: time.Hour, InventoryReservationTTL: time.Hour, CheckoutSessionTTL: time.Hour}).Validate()
+	if err == nil {
+		t.Fatal("empty config is valid")
+	}
+	for _, want := range []string{"STRIPE_PUBLISHABLE_KEY", "STRIPE_SECRET_KEY", "Price ID", "DOMAIN"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+stripe_publishable_key: pk_test_file
+stripe_secret_key: sk_test_file
+price: price_basic
+domain: https://shop.example.com/
+max_quantity: 5
+return_url_hosts:
+  - a.example.com
+  - .b.example.com
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_env")
+	for _, key := range []string{"STRIPE_PUBLISHABLE_KEY", "PRICE", "DOMAIN", "MAX_QUANTITY", "RETURN_URL_HOSTS", "PORT", "EVENT_DEDUPE_TTL"} {
+		t.Setenv(key, "")
+	}
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.PublishableKey != "pk_test_file" || c.SecretKey != "sk_test_env" {
+		t.Errorf("keys = %s, %s; want the file's publishable key and the environment's secret key", c.PublishableKey, c.SecretKey)
+	}
+	if c.Domain != "https://shop.example.com" || c.MaxQuantity != 5 {
+		t.Errorf("domain %q, max quantity %d", c.Domain, c.MaxQuantity)
+	}
+	if strings.Join(c.ReturnURLHosts, ",") != "a.example.com,.b.example.com" {
+		t.Errorf("return URL hosts = %v", c.ReturnURLHosts)
+	}
+	if c.Port != "4242" || c.EventDedupeTTL != 72*time.Hour || c.DatabaseURL != "payments.db" {
+		t.Errorf("defaults not applied: port %s, TTL %s, database %q", c.Port, c.EventDedupeTTL, c.DatabaseURL)
+	}
+
+	if err := os.WriteFile(path, []byte("email:\n  backend: smtp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "must be a value or a list") {
+		t.Errorf("nested YAML: %v", err)
+	}
+}
+
+func TestLoadConfigStandalone(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("PRICE", "price_basic")
+	t.Setenv("DOMAIN", "https://shop.example.com")
+	t.Setenv("STRIPE_PUBLISHABLE_KEY", "pk_test_default")
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_default")
+	t.Setenv("JOB_QUEUE_BACKEND", "")
+	t.Setenv("EVENT_STORE", "")
+
+	t.Setenv("STANDALONE", "")
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.JobQueueBackend != "file" || c.EventStore != "memory" {
+		t.Errorf("default backends = %s, %s", c.JobQueueBackend, c.EventStore)
+	}
+
+	t.Setenv("STANDALONE", "true")
+	if c, err = Load(); err != nil {
+		t.Fatal(err)
+	}
+	if c.JobQueueBackend != "database" || c.EventStore != "database" || c.DatabaseDriver != "sqlite" {
+		t.Errorf("standalone backends = %s, %s on %s", c.JobQueueBackend, c.EventStore, c.DatabaseDriver)
+	}
+
+	// Each can still be picked on its own.
+	t.Setenv("EVENT_STORE", "memory")
+	if c, err = Load(); err != nil {
+		t.Fatal(err)
+	}
+	if c.JobQueueBackend != "database" || c.EventStore != "memory" {
+		t.Errorf("standalone backends = %s, %s", c.JobQueueBackend, c.EventStore)
+	}
+}
+
+func TestLoadConfigModeKeys(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("PRICE", "price_basic")
+	t.Setenv("DOMAIN", "https://shop.example.com")
+	t.Setenv("STRIPE_PUBLISHABLE_KEY", "pk_test_default")
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_default")
+	t.Setenv("STRIPE_LIVE_PUBLISHABLE_KEY", "pk_live_123")
+	t.Setenv("STRIPE_LIVE_SECRET_KEY", "sk_live_123")
+	t.Setenv("STRIPE_LIVE_WEBHOOK_SECRET", "whsec_live, whsec_previous")
+
+	t.Setenv("MODE", "")
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Mode != "test" || c.SecretKey != "sk_test_default" {
+		t.Errorf("default mode %s uses %s", c.Mode, c.SecretKey)
+	}
+
+	t.Setenv("MODE", "live")
+	if c, err = Load(); err != nil {
+		t.Fatal(err)
+	}
+	if c.PublishableKey != "pk_live_123" || c.SecretKey != "sk_live_123" || len(c.WebhookSecrets) != 2 || c.WebhookSecrets[0] != "whsec_live" || c.WebhookSecrets[1] != "whsec_previous" {
+		t.Errorf("live mode keys = %s, %s, %s", c.PublishableKey, c.SecretKey, c.WebhookSecrets)
+	}
+
+	t.Setenv("STRIPE_LIVE_SECRET_KEY", "")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "MODE is live but STRIPE_SECRET_KEY is a test mode key") {
+		t.Errorf("live mode with a test secret key: %v", err)
+	}
+
+	// The mock runs without any Stripe settings.
+	t.Setenv("MODE", "mock")
+	for _, name := range []string{"PRICE", "STRIPE_PUBLISHABLE_KEY", "STRIPE_SECRET_KEY", "STRIPE_WEBHOOK_SECRET"} {
+		t.Setenv(name, "")
+	}
+	if c, err = Load(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Price != MockPrice || c.SecretKey != "sk_test_mock" || len(c.WebhookSecrets) != 1 {
+		t.Errorf("mock mode settings = %s, %s, %s", c.Price, c.SecretKey, c.WebhookSecrets)
+	}
+}
+
+func TestParsePurchaseLimits(t *testing.T) {
+	prices, err := parsePriceLimits("price_a=1-3, price_b=5-100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices["price_a"].MaxQuantity != 3 || prices["price_b"].MinQuantity != 5 {
+		t.Errorf("PRICE_LIMITS = %+v", prices)
+	}
+	amounts, err := parseAmountLimits("usd=50-500000,JPY=-1000000,eur=100-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amounts["usd"] != (AmountLimit{50, 500000}) || amounts["jpy"] != (AmountLimit{0, 1000000}) || amounts["eur"] != (AmountLimit{100, 0}) {
+		t.Errorf("CHECKOUT_AMOUNT_LIMITS = %+v", amounts)
+	}
+	for _, v := range []string{"price_a=3", "price_a=0-3", "price_a=4-3", "prod_a=1-3"} {
+		if _, err := parsePriceLimits(v); err == nil {
+			t.Errorf("parsePriceLimits(%q) succeeded", v)
+		}
+	}
+	for _, v := range []string{"usd=50", "usd=100-50", "dollars=1-2", "usd=a-b"} {
+		if _, err := parseAmountLimits(v); err == nil {
+			t.Errorf("parseAmountLimits(%q) succeeded", v)
+		}
+	}
+}
+
+func TestParseInventory(t *testing.T) {
+	got, err := parseInventory(" price_a=10, price_b = 0 ,")
+	if err != nil || got["price_a"] != 10 || got["price_b"] != 0 || len(got) != 2 {
+		t.Errorf("parseInventory = %v, %v", got, err)
+	}
+	for _, bad := range []string{"price_a", "price_a=-1", "prod_a=1", "price_a=lots"} {
+		if _, err := parseInventory(bad); err == nil {
+			t.Errorf("parseInventory(%q) accepted it", bad)
+		}
+	}
+}
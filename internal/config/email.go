@@ -0,0 +1,9 @@
+package config
+
+// Who emails customers the receipt for their payment, set by RECEIPTS: our
+// confirmation email, Stripe's own receipt email, or both. Empty is custom.
+const (
+	ReceiptsCustom = "custom"
+	ReceiptsStripe = "stripe"
+	ReceiptsBoth   = "both"
+)
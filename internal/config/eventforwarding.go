@@ -0,0 +1,117 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// EventForward is an internal consumer from EVENT_FORWARDS that is passed
+// the verified Stripe events it subscribed to, either posted to URL or
+// published to Topic on a message bus.
+type EventForward struct {
+	Name string
+	// URL is the HTTP endpoint posted to; empty for a bus topic.
+	URL string
+	// Bus names the message bus Topic is published on: redis, kafka or
+	// nats.
+	Bus   string
+	Topic string
+	// Events are the event type patterns forwarded, e.g. invoice.*; empty
+	// forwards every event.
+	Events []string
+}
+
+// parseEventForwards parses EVENT_FORWARDS, comma separated name=target
+// entries where the target is an http(s) URL or bus:topic for a redis,
+// kafka or nats bus, e.g.
+// billing=http://billing.internal/stripe,ledger=redis:stripe-events, and
+// EVENT_FORWARD_EVENTS, comma separated name:pattern entries limiting the
+// events a forward gets, e.g. billing:invoice.*.
+func parseEventForwards(forwards, events string) ([]EventForward, error) {
+	var list []EventForward
+	for _, entry := range strings.Split(forwards, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(entry, "=")
+		if !ok || !endpointNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid EVENT_FORWARDS entry %q: want name=url or name=bus:topic with a lowercase name", entry)
+		}
+		f := EventForward{Name: name}
+		if scheme, topic, _ := strings.Cut(target, ":"); scheme == "http" || scheme == "https" {
+			f.URL = target
+		} else {
+			f.Bus, f.Topic = scheme, topic
+		}
+		list = append(list, f)
+	}
+	for _, entry := range strings.Split(events, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, pattern, _ := strings.Cut(entry, ":")
+		i := slices.IndexFunc(list, func(f EventForward) bool { return f.Name == name })
+		if _, err := path.Match(pattern, ""); i < 0 || pattern == "" || err != nil {
+			return nil, fmt.Errorf("invalid EVENT_FORWARD_EVENTS entry %q: want name:pattern for a forward in EVENT_FORWARDS", entry)
+		}
+		list[i].Events = append(list[i].Events, pattern)
+	}
+	return list, nil
+}
+
+// validate checks a forward's target; the names are checked together with
+// those of the outbound webhooks, which share the delivery log.
+func (f *EventForward) validate(c *Config) error {
+	if f.URL != "" {
+		if u, err := url.Parse(f.URL); err != nil || u.Host == "" {
+			return fmt.Errorf("EVENT_FORWARDS entry %s must be an absolute http or https URL", f.Name)
+		}
+		return nil
+	}
+	if f.Topic == "" {
+		return fmt.Errorf("EVENT_FORWARDS entry %s: want a URL or bus:topic", f.Name)
+	}
+	if err := checkMessageBus(c, f.Bus); err != nil {
+		return fmt.Errorf("EVENT_FORWARDS entry %s %w", f.Name, err)
+	}
+	return nil
+}
+
+// checkMessageBus reports what keeps c from connecting to the bus named
+// name. The error reads after the setting that picked the bus.
+func checkMessageBus(c *Config, name string) error {
+	switch name {
+	case "redis":
+		if c.RedisURL == "" {
+			return errors.New("needs REDIS_URL")
+		}
+	case "kafka":
+		if len(c.KafkaBrokers) == 0 {
+			return errors.New("needs KAFKA_BROKERS")
+		}
+	case "nats":
+		if c.NATSURL == "" {
+			return errors.New("needs NATS_URL")
+		}
+	default:
+		return fmt.Errorf("names unknown message bus %q", name)
+	}
+	return nil
+}
+
+// Forwards reports whether f subscribed to events of eventType.
+func (f *EventForward) Forwards(eventType string) bool {
+	if len(f.Events) == 0 {
+		return true
+	}
+	for _, pattern := range f.Events {
+		if ok, _ := path.Match(pattern, eventType); ok {
+			return true
+		}
+	}
+	return false
+}
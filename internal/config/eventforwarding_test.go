@@ -0,0 +1,32 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseEventForwards(t *testing.T) {
+	forwards, err := parseEventForwards("billing=http://billing.internal/stripe, ledger=redis:stripe-events",
+		"billing:invoice.*,billing:customer.subscription.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forwards) != 2 || forwards[0].URL != "http://billing.internal/stripe" || len(forwards[0].Events) != 2 ||
+		forwards[1].Bus != "redis" || forwards[1].Topic != "stripe-events" || forwards[1].Events != nil {
+		t.Errorf("forwards = %+v", forwards)
+	}
+	if !forwards[0].Forwards("customer.subscription.updated") || forwards[0].Forwards("charge.refunded") || !forwards[1].Forwards("charge.refunded") {
+		t.Error("forwarded the wrong events")
+	}
+
+	for _, tt := range []struct{ forwards, events, want string }{
+		{"Billing=http://billing.internal", "", "lowercase name"},
+		{"billing", "", "want name=url"},
+		{"billing=http://billing.internal", "ledger:charge.*", "for a forward in EVENT_FORWARDS"},
+		{"billing=http://billing.internal", "billing:[", "want name:pattern"},
+	} {
+		if _, err := parseEventForwards(tt.forwards, tt.events); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseEventForwards(%q, %q) = %v, want %q", tt.forwards, tt.events, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FulfillmentRoute is a FULFILLMENT_ROUTES entry: once Match, a price or
+// product ID, is paid for it is fulfilled by the Fulfiller registered for
+// Type. Target configures the fulfiller, e.g. the file a download serves.
+type FulfillmentRoute struct {
+	Match  string
+	Type   string
+	Target string
+}
+
+// ParseFulfillmentRoutes parses FULFILLMENT_ROUTES: comma separated
+// id=type[:target] entries, e.g.
+// price_123=download:ebook.pdf,prod_456=license,prod_789=shipment.
+func ParseFulfillmentRoutes(s string) ([]FulfillmentRoute, error) {
+	var routes []FulfillmentRoute
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		match, action, ok := strings.Cut(entry, "=")
+		if !ok || (!strings.HasPrefix(match, "price_") && !strings.HasPrefix(match, "prod_")) {
+			return nil, fmt.Errorf("invalid FULFILLMENT_ROUTES entry %q: want price_or_product_id=type[:target]", entry)
+		}
+		typ, target, _ := strings.Cut(action, ":")
+		routes = append(routes, FulfillmentRoute{Match: match, Type: typ, Target: target})
+	}
+	return routes, nil
+}
+
+// validateFulfillmentRoute checks that a FULFILLMENT_ROUTES entry names a
+// kind of fulfillment in fulfillers and that its target suits it.
+func validateFulfillmentRoute(c *Config, r FulfillmentRoute) error {
+	switch r.Type {
+	case "download":
+		if r.Target == "" || filepath.Base(r.Target) != r.Target || r.Target == "." || r.Target == ".." {
+			return fmt.Errorf("download needs a file name in DOWNLOADS_DIR, not %q", r.Target)
+		}
+		if c.DownloadsDir == "" || len(c.DownloadSigningKey) < 32 || c.OrderStatusSigningKey == "" {
+			return errors.New("downloads need DOWNLOADS_DIR, a DOWNLOAD_SIGNING_KEY of at least 32 characters and ORDER_STATUS_SIGNING_KEY")
+		}
+	case "license":
+		if r.Target != "" {
+			return fmt.Errorf("license takes no target, got %q", r.Target)
+		}
+		if len(c.LicenseSigningKey) < 32 {
+			return errors.New("licenses need a LICENSE_SIGNING_KEY of at least 32 characters")
+		}
+	case "shipment":
+		if r.Target != "" {
+			return fmt.Errorf("shipment takes no target, got %q", r.Target)
+		}
+	default:
+		return fmt.Errorf("unknown type %q", r.Type)
+	}
+	return nil
+}
+
+// ParseSuccessPages parses SUCCESS_PAGES: comma separated id=path entries,
+// e.g. prod_456=/html/success-license.html.
+func ParseSuccessPages(s string) (map[string]string, error) {
+	pages := map[string]string{}
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		match, page, ok := strings.Cut(entry, "=")
+		if !ok || (!strings.HasPrefix(match, "price_") && !strings.HasPrefix(match, "prod_")) || !strings.HasPrefix(page, "/") {
+			return nil, fmt.Errorf("invalid SUCCESS_PAGES entry %q: want price_or_product_id=/path", entry)
+		}
+		pages[match] = page
+	}
+	return pages, nil
+}
@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+func TestParseFulfillmentRoutes(t *testing.T) {
+	routes, err := ParseFulfillmentRoutes("price_1=download:ebook.pdf, prod_2=license")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[0] != (FulfillmentRoute{Match: "price_1", Type: "download", Target: "ebook.pdf"}) || routes[1].Type != "license" {
+		t.Errorf("routes = %+v", routes)
+	}
+	for _, s := range []string{"license", "sku_1=license"} {
+		if _, err := ParseFulfillmentRoutes(s); err == nil {
+			t.Errorf("parseFulfillmentRoutes(%q) accepted", s)
+		}
+	}
+	for _, s := range []string{"prod_1=thanks.html", "prod_1", "cus_1=/thanks.html"} {
+		if _, err := ParseSuccessPages(s); err == nil {
+			t.Errorf("parseSuccessPages(%q) accepted", s)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// AmountLimit bounds the total of a checkout session in the smallest unit of
+// a currency. A Max of 0 doesn't cap it.
+type AmountLimit struct {
+	Min int64
+	Max int64
+}
+
+// PriceLimit bounds the units of a price one checkout session can buy,
+// overriding MAX_QUANTITY. Source says whether it comes from PRICE_LIMITS
+// ("config"), the database ("database"), which takes precedence, or is
+// MAX_QUANTITY ("default").
+type PriceLimit struct {
+	Price       string     `json:"price"`
+	MinQuantity int64      `json:"minQuantity"`
+	MaxQuantity int64      `json:"maxQuantity"`
+	Source      string     `json:"source"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+}
+
+// Validate checks the bounds of a limit set through the admin API.
+func (l *PriceLimit) Validate() error {
+	if l.MinQuantity < 1 || l.MaxQuantity < l.MinQuantity {
+		return errors.New("quantities must satisfy 1 <= minQuantity <= maxQuantity")
+	}
+	return nil
+}
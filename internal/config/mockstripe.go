@@ -0,0 +1,10 @@
+package config
+
+// The catalog the mock starts with. PRICE defaults to MockPrice with
+// MODE=mock.
+const (
+	MockPrice        = "price_mock_basic"
+	MockPlanPrice    = "price_mock_plan"
+	mockWebhookKey   = "whsec_mock"
+	MockCheckoutPath = "/mock/checkout/"
+)
@@ -0,0 +1,47 @@
+package config
+
+// Notification alerts the shop's operators to something that needs a look,
+// such as a failed payment or a new dispute. Each backend gets its own copy,
+// with Channel set, so one that is down doesn't hold up or repeat the
+// others.
+type Notification struct {
+	Event   string `json:",omitempty"`
+	Channel string `json:",omitempty"`
+	Subject string
+	Lines   []string
+}
+
+// The events operators can be notified about, as used in
+// NOTIFY_EMAIL_EVENTS, SLACK_NOTIFY_EVENTS and DISCORD_NOTIFY_EVENTS.
+const (
+	NotifyPaymentSucceeded       = "payment.succeeded"
+	NotifyPaymentFailed          = "payment.failed"
+	NotifyDisputeOpened          = "dispute.opened"
+	NotifyDisputeClosed          = "dispute.closed"
+	NotifyReviewOpened           = "review.opened"
+	NotifySubscriptionTrialEnds  = "subscription.trial_ending"
+	NotifyInvoicePaid            = "invoice.paid"
+	NotifyInvoicePaymentFailed   = "invoice.payment_failed"
+	NotifyWebhookSignatureFailed = "webhook.signature_failed"
+	NotifyShipmentRequested      = "fulfillment.shipment"
+	NotifyTaxIDInvalid           = "tax_id.invalid"
+	NotifyCheckoutAbandoned      = "checkout.abandoned"
+)
+
+func knownNotificationEvent(event string) bool {
+	switch event {
+	case NotifyPaymentSucceeded, NotifyPaymentFailed, NotifyDisputeOpened, NotifyDisputeClosed, NotifyReviewOpened,
+		NotifySubscriptionTrialEnds, NotifyInvoicePaid, NotifyInvoicePaymentFailed, NotifyWebhookSignatureFailed,
+		NotifyShipmentRequested, NotifyTaxIDInvalid, NotifyCheckoutAbandoned:
+		return true
+	}
+	return false
+}
+
+// The notification backends. Email is always on: without NOTIFY_EMAIL it
+// logs the notification instead.
+const (
+	ChannelEmail   = "email"
+	ChannelSlack   = "slack"
+	ChannelDiscord = "discord"
+)
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// OutboundWebhook is a downstream endpoint from OUTBOUND_WEBHOOKS that is
+// told about payment status changes.
+type OutboundWebhook struct {
+	Name string
+	URL  string
+}
+
+var endpointNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// parseOutboundWebhooks parses OUTBOUND_WEBHOOKS: comma separated name=url
+// entries, e.g. fulfillment=https://fulfillment.example.com/hooks/payments.
+func parseOutboundWebhooks(s string) ([]OutboundWebhook, error) {
+	var endpoints []OutboundWebhook
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, u, ok := strings.Cut(entry, "=")
+		if !ok || !endpointNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid OUTBOUND_WEBHOOKS entry %q: want name=url with a lowercase name", entry)
+		}
+		endpoints = append(endpoints, OutboundWebhook{Name: name, URL: u})
+	}
+	return endpoints, nil
+}
+
+// PaymentEventPrefix starts the type of every outbound event; the rest is
+// the payment's new status, as in payment.paid or payment.refunded.
+const PaymentEventPrefix = "payment."
@@ -0,0 +1,18 @@
+package config
+
+import "testing"
+
+func TestParseOutboundWebhooks(t *testing.T) {
+	endpoints, err := parseOutboundWebhooks("fulfillment=https://f.example.com/hooks?a=b, crm=https://crm.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endpoints) != 2 || endpoints[0].URL != "https://f.example.com/hooks?a=b" || endpoints[1].Name != "crm" {
+		t.Errorf("endpoints = %+v", endpoints)
+	}
+	for _, s := range []string{"https://crm.example.com", "CRM=https://crm.example.com"} {
+		if _, err := parseOutboundWebhooks(s); err == nil {
+			t.Errorf("parseOutboundWebhooks(%q) accepted", s)
+		}
+	}
+}
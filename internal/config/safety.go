@@ -0,0 +1,18 @@
+package config
+
+// With live mode keys the operations that can't be undone, refunds of at
+// least SAFETY_REFUND_THRESHOLD, customer deletions and event replays
+// (reconcile runs, webhook delivery retries and /admin/events replays),
+// aren't carried out on the first request. It is answered with 428 and a
+// confirmation token bound to the operation, and only the same request
+// repeated with the token in the Confirmation-Token header goes ahead. With SAFETY_CONFIRMATION=approval
+// the repeat has to come from a different principal, so a second admin
+// approves it. Every confirmed operation is audited as safety.override.
+const (
+	confirmationToken    = "token"
+	ConfirmationApproval = "approval"
+
+	ConfirmationHeader = "Confirmation-Token"
+	// ConfirmationMetadata carries the token of gRPC calls.
+	ConfirmationMetadata = "confirmation-token"
+)
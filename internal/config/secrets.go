@@ -0,0 +1,461 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretRefPrefix marks a STRIPE_SECRET_KEY or STRIPE_WEBHOOK_SECRET value
+// that names a secret in SECRET_PROVIDER's secret manager instead of
+// holding it: secret:NAME, or secret:NAME#FIELD for one field of a secret
+// that holds a JSON object.
+const secretRefPrefix = "secret:"
+
+// secretFetchTimeout bounds fetching the secrets while the configuration
+// is loaded.
+const secretFetchTimeout = 30 * time.Second
+
+// SecretProvider reads secrets from a secret manager.
+type SecretProvider interface {
+	// GetSecret returns the current value of the secret name.
+	GetSecret(ctx context.Context, name string) (string, error)
+}
+
+// SecretProviders are the SECRET_PROVIDER choices, each built from its own
+// settings.
+var SecretProviders = map[string]func(src Source) (SecretProvider, error){
+	"aws":   newAWSSecretsManager,
+	"gcp":   newGCPSecretManager,
+	"vault": newVaultSecrets,
+}
+
+// newSecretProvider returns the provider SECRET_PROVIDER names, or nil if
+// it is unset.
+func newSecretProvider(src Source) (SecretProvider, error) {
+	name := strings.ToLower(src.get("SECRET_PROVIDER"))
+	if name == "" {
+		return nil, nil
+	}
+	newProvider, ok := SecretProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("SECRET_PROVIDER must be aws, gcp or vault, not %q", name)
+	}
+	p, err := newProvider(src)
+	if err != nil {
+		return nil, fmt.Errorf("SECRET_PROVIDER %s: %w", name, err)
+	}
+	return p, nil
+}
+
+// resolveSecret returns the value of setting, fetched from p when it is a
+// secret: reference.
+func resolveSecret(ctx context.Context, p SecretProvider, setting, value string) (string, error) {
+	ref, ok := strings.CutPrefix(value, secretRefPrefix)
+	if !ok {
+		return value, nil
+	}
+	if p == nil {
+		return "", fmt.Errorf("%s refers to a secret but SECRET_PROVIDER is not set", setting)
+	}
+	name, field, _ := strings.Cut(ref, "#")
+	secret, err := p.GetSecret(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("%s: fetching secret %s: %w", setting, name, err)
+	}
+	if field != "" {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+			return "", fmt.Errorf("%s: secret %s is not a JSON object", setting, name)
+		}
+		v, ok := fields[field].(string)
+		if !ok {
+			return "", fmt.Errorf("%s: secret %s has no string field %q", setting, name, field)
+		}
+		secret = v
+	}
+	return strings.TrimSpace(secret), nil
+}
+
+// secretHTTPClient makes the requests to the secret managers and the
+// metadata services that hand out their credentials.
+var secretHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// getJSON sends req and decodes a 200 response's JSON body into v. Other
+// statuses are errors carrying the start of the body.
+func getJSON(req *http.Request, v interface{}) error {
+	resp, err := secretHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(body) > 200 {
+			body = body[:200]
+		}
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, bytes.TrimSpace(body))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// vaultSecrets reads secrets from HashiCorp Vault's KV version 2 engine.
+// Names are MOUNT/PATH, e.g. secret/stripe, and always need a #FIELD, as
+// a KV secret is a set of fields.
+type vaultSecrets struct {
+	addr      string
+	token     string
+	namespace string
+}
+
+func newVaultSecrets(src Source) (SecretProvider, error) {
+	v := &vaultSecrets{
+		addr:      strings.TrimSuffix(src.get("VAULT_ADDR"), "/"),
+		token:     src.get("VAULT_TOKEN"),
+		namespace: src.get("VAULT_NAMESPACE"),
+	}
+	if v.addr == "" || v.token == "" {
+		return nil, errors.New("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	return v, nil
+}
+
+func (v *vaultSecrets) GetSecret(ctx context.Context, name string) (string, error) {
+	mount, path, ok := strings.Cut(name, "/")
+	if !ok || mount == "" || path == "" {
+		return "", fmt.Errorf("vault secret %q must be MOUNT/PATH", name)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", v.addr+"/v1/"+mount+"/data/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	var resp struct {
+		Data struct {
+			Data json.RawMessage `json:"data"`
+		} `json:"data"`
+	}
+	if err := getJSON(req, &resp); err != nil {
+		return "", err
+	}
+	return string(resp.Data.Data), nil
+}
+
+// gcpSecretManager reads secrets from Google Cloud Secret Manager with the
+// service account of the instance it runs on, whose access token comes
+// from the metadata server. Names are secret IDs in GCP_PROJECT, or full
+// resource names; either reads the latest version unless one is named.
+type gcpSecretManager struct {
+	project     string
+	apiURL      string
+	metadataURL string
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newGCPSecretManager(src Source) (SecretProvider, error) {
+	return &gcpSecretManager{
+		project:     src.getOr("GCP_PROJECT", src.get("GOOGLE_CLOUD_PROJECT")),
+		apiURL:      "https://secretmanager.googleapis.com",
+		metadataURL: "http://" + src.getOr("GCE_METADATA_HOST", "metadata.google.internal"),
+	}, nil
+}
+
+func (g *gcpSecretManager) GetSecret(ctx context.Context, name string) (string, error) {
+	if !strings.HasPrefix(name, "projects/") {
+		if g.project == "" {
+			return "", fmt.Errorf("secret %q needs GCP_PROJECT or a projects/... name", name)
+		}
+		name = "projects/" + g.project + "/secrets/" + name
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("fetching access token: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", g.apiURL+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := getJSON(req, &resp); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("decoding secret payload: %w", err)
+	}
+	return string(data), nil
+}
+
+// accessToken returns the instance's access token, fetching a new one a
+// minute before the last expires.
+func (g *gcpSecretManager) accessToken(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Now().Before(g.tokenExpiry) {
+		return g.token, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", g.metadataURL+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := getJSON(req, &resp); err != nil {
+		return "", err
+	}
+	g.token = resp.AccessToken
+	g.tokenExpiry = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return g.token, nil
+}
+
+// awsCredentials sign requests to AWS. Temporary ones carry a session token
+// and expire.
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// awsSecretsManager reads secrets from AWS Secrets Manager in AWS_REGION.
+// Names are secret names or ARNs. It signs with AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY when they are set, and otherwise with the role of
+// the ECS task or EC2 instance it runs on.
+type awsSecretsManager struct {
+	region   string
+	endpoint string
+	// static are the credentials from the settings, if any.
+	static *awsCredentials
+	// containerURL and containerToken locate an ECS task's credentials;
+	// instanceURL is the EC2 instance metadata service.
+	containerURL   string
+	containerToken string
+	instanceURL    string
+
+	mu    sync.Mutex
+	creds *awsCredentials
+}
+
+func newAWSSecretsManager(src Source) (SecretProvider, error) {
+	a := &awsSecretsManager{
+		region:         src.getOr("AWS_REGION", src.get("AWS_DEFAULT_REGION")),
+		containerURL:   src.get("AWS_CONTAINER_CREDENTIALS_FULL_URI"),
+		containerToken: src.get("AWS_CONTAINER_AUTHORIZATION_TOKEN"),
+		instanceURL:    "http://169.254.169.254",
+	}
+	if a.region == "" {
+		return nil, errors.New("AWS_REGION must be set")
+	}
+	a.endpoint = src.getOr("AWS_ENDPOINT_URL_SECRETS_MANAGER", "https://secretsmanager."+a.region+".amazonaws.com")
+	if uri := src.get("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" && a.containerURL == "" {
+		a.containerURL = "http://169.254.170.2" + uri
+	}
+	if id := src.get("AWS_ACCESS_KEY_ID"); id != "" {
+		a.static = &awsCredentials{AccessKeyID: id, SecretAccessKey: src.get("AWS_SECRET_ACCESS_KEY"), SessionToken: src.get("AWS_SESSION_TOKEN")}
+	}
+	return a, nil
+}
+
+func (a *awsSecretsManager) GetSecret(ctx context.Context, name string) (string, error) {
+	creds, err := a.credentials(ctx)
+	if err != nil {
+		return "", fmt.Errorf("fetching AWS credentials: %w", err)
+	}
+	body, err := json.Marshal(map[string]string{"SecretId": name})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, body, creds, a.region, "secretsmanager", time.Now())
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := getJSON(req, &resp); err != nil {
+		return "", err
+	}
+	if resp.SecretString == "" {
+		return "", errors.New("secret has no string value")
+	}
+	return resp.SecretString, nil
+}
+
+// credentials returns the static credentials, or the role's, fetching new
+// ones five minutes before the last expire.
+func (a *awsSecretsManager) credentials(ctx context.Context) (*awsCredentials, error) {
+	if a.static != nil {
+		return a.static, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.creds != nil && time.Now().Add(5*time.Minute).Before(a.creds.Expiration) {
+		return a.creds, nil
+	}
+	var creds awsCredentials
+	if a.containerURL != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", a.containerURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if a.containerToken != "" {
+			req.Header.Set("Authorization", a.containerToken)
+		}
+		if err := getJSON(req, &creds); err != nil {
+			return nil, err
+		}
+	} else if err := a.instanceCredentials(ctx, &creds); err != nil {
+		return nil, err
+	}
+	a.creds = &creds
+	return a.creds, nil
+}
+
+// instanceCredentials fetches the credentials of the EC2 instance's role
+// from the instance metadata service, version 2.
+func (a *awsSecretsManager) instanceCredentials(ctx context.Context, creds *awsCredentials) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", a.instanceURL+"/latest/api/token", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "300")
+	resp, err := secretHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	token, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("instance metadata token: %s", resp.Status)
+	}
+	get := func(path string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", a.instanceURL+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if err == nil {
+			req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+		}
+		return req, err
+	}
+	req, err = get("")
+	if err != nil {
+		return err
+	}
+	resp, err = secretHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	role, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("instance role: %s", resp.Status)
+	}
+	name, _, _ := strings.Cut(strings.TrimSpace(string(role)), "\n")
+	if req, err = get(name); err != nil {
+		return err
+	}
+	return getJSON(req, creds)
+}
+
+// signAWSRequest adds the Signature Version 4 headers to req, whose body is
+// body, signing its Content-Type, Host and X-Amz-* headers.
+func signAWSRequest(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	// AWS wants spaces as %20, which url.Values encodes as +.
+	query := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, query, canonicalHeaders.String(), signedHeaders, SHA256Hex(string(body)),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + SHA256Hex(canonicalRequest)
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// SHA256Hex returns the hex encoded SHA-256 hash of s.
+func SHA256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
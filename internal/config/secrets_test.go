@@ -0,0 +1,146 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVaultSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" || r.Header.Get("X-Vault-Namespace") != "shop" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/stripe/prod" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data": {"data": {"secret_key": "sk_test_vault"}, "metadata": {"version": 3}}}`))
+	}))
+	defer vault.Close()
+	src := Source{"VAULT_ADDR": vault.URL + "/", "VAULT_TOKEN": "s.token", "VAULT_NAMESPACE": "shop"}
+	for _, key := range []string{"VAULT_ADDR", "VAULT_TOKEN", "VAULT_NAMESPACE"} {
+		t.Setenv(key, "")
+	}
+	p, err := newVaultSecrets(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := resolveSecret(context.Background(), p, "STRIPE_SECRET_KEY", "secret:secret/stripe/prod#secret_key"); err != nil || v != "sk_test_vault" {
+		t.Errorf("secret = %q, %v", v, err)
+	}
+	if _, err := p.GetSecret(context.Background(), "secret/stripe/staging"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing secret: %v", err)
+	}
+	if _, err := newVaultSecrets(Source{"VAULT_ADDR": vault.URL}); err == nil {
+		t.Error("vault without a token")
+	}
+}
+
+func TestGCPSecretManager(t *testing.T) {
+	tokens := 0
+	gcp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+				return
+			}
+			tokens++
+			w.Write([]byte(`{"access_token": "ya29.token", "expires_in": 3599, "token_type": "Bearer"}`))
+		case r.Header.Get("Authorization") != "Bearer ya29.token":
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		case r.URL.Path == "/v1/projects/shop/secrets/stripe-webhook/versions/latest:access",
+			r.URL.Path == "/v1/projects/other/secrets/stripe-webhook/versions/2:access":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"payload": map[string]string{"data": base64.StdEncoding.EncodeToString([]byte("whsec_gcp"))},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer gcp.Close()
+	p := &gcpSecretManager{project: "shop", apiURL: gcp.URL, metadataURL: gcp.URL}
+	for _, name := range []string{"stripe-webhook", "projects/other/secrets/stripe-webhook/versions/2"} {
+		if v, err := p.GetSecret(context.Background(), name); err != nil || v != "whsec_gcp" {
+			t.Errorf("secret %s = %q, %v", name, v, err)
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("fetched %d access tokens, want one reused", tokens)
+	}
+}
+
+func TestAWSSecretsManager(t *testing.T) {
+	var target, auth string
+	aws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/credentials" {
+			if r.Header.Get("Authorization") != "task-token" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"AccessKeyId": "ASIATASK", "SecretAccessKey": "task-secret", "Token": "task-session",
+				"Expiration": time.Now().Add(time.Hour),
+			})
+			return
+		}
+		target, auth = r.Header.Get("X-Amz-Target"), r.Header.Get("Authorization")
+		var req struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.SecretId != "prod/stripe" || r.Header.Get("X-Amz-Security-Token") != "task-session" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "ResourceNotFoundException", "message": "Secrets Manager can't find the specified secret."}`))
+			return
+		}
+		w.Write([]byte(`{"Name": "prod/stripe", "SecretString": "{\"secret_key\": \"sk_test_aws\"}"}`))
+	}))
+	defer aws.Close()
+	for _, key := range []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID", "AWS_ENDPOINT_URL_SECRETS_MANAGER",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_AUTHORIZATION_TOKEN"} {
+		t.Setenv(key, "")
+	}
+	p, err := newAWSSecretsManager(Source{
+		"AWS_REGION":                         "eu-west-1",
+		"AWS_ENDPOINT_URL_SECRETS_MANAGER":   aws.URL,
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI": aws.URL + "/credentials",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN":  "task-token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := resolveSecret(context.Background(), p, "STRIPE_SECRET_KEY", "secret:prod/stripe#secret_key")
+	if err != nil || v != "sk_test_aws" {
+		t.Fatalf("secret = %q, %v", v, err)
+	}
+	if target != "secretsmanager.GetSecretValue" || !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=ASIATASK/") ||
+		!strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, Signature=") {
+		t.Errorf("target %q, authorization %q", target, auth)
+	}
+	if _, err := p.GetSecret(context.Background(), "prod/other"); err == nil || !strings.Contains(err.Error(), "ResourceNotFoundException") {
+		t.Errorf("missing secret: %v", err)
+	}
+	if _, err := newAWSSecretsManager(Source{}); err == nil {
+		t.Error("AWS without a region")
+	}
+}
+
+// TestSignAWSRequest checks the signer against the example in AWS's
+// Signature Version 4 documentation.
+func TestSignAWSRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signAWSRequest(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// maxShippingRates is the most shipping options Checkout shows.
+const maxShippingRates = 5
+
+// ShippingRate is a shipping option offered on the Checkout page: either an
+// existing Stripe shipping rate (ID) or a flat rate created with the session.
+// Amount is in the minor units of the session's currency, and MinDays and
+// MaxDays are the optional delivery estimate in business days.
+type ShippingRate struct {
+	ID      string
+	Name    string
+	Amount  int64
+	MinDays int64
+	MaxDays int64
+}
+
+// parseShippingRates parses SHIPPING_RATES, a comma separated list of
+// shipping rate IDs (shr_...) and flat rates written as name:amount or
+// name:amount:min-max, e.g. "Standard:500:5-7,Express:1500:1-2".
+func parseShippingRates(s string) ([]ShippingRate, error) {
+	var rates []ShippingRate
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if strings.HasPrefix(entry, "shr_") {
+			rates = append(rates, ShippingRate{ID: entry})
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid SHIPPING_RATES entry %q: want a shr_ ID or name:amount[:min-max]", entry)
+		}
+		rate := ShippingRate{Name: strings.TrimSpace(parts[0])}
+		amount, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || amount < 0 {
+			return nil, fmt.Errorf("invalid SHIPPING_RATES entry %q: amount must be a whole number of minor units", entry)
+		}
+		rate.Amount = amount
+		if len(parts) == 3 {
+			min, max, ok := strings.Cut(parts[2], "-")
+			rate.MinDays, err = strconv.ParseInt(min, 10, 64)
+			if err == nil && ok {
+				rate.MaxDays, err = strconv.ParseInt(max, 10, 64)
+			} else {
+				rate.MaxDays = rate.MinDays
+			}
+			if err != nil || rate.MinDays < 1 || rate.MaxDays < rate.MinDays {
+				return nil, fmt.Errorf("invalid SHIPPING_RATES entry %q: delivery estimate must be days like 3-5", entry)
+			}
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// Option returns the Checkout shipping option for the rate, charging flat
+// rates in currency.
+func (rate ShippingRate) Option(currency string) *stripe.CheckoutSessionShippingOptionParams {
+	if rate.ID != "" {
+		return &stripe.CheckoutSessionShippingOptionParams{ShippingRate: stripe.String(rate.ID)}
+	}
+	data := &stripe.CheckoutSessionShippingOptionShippingRateDataParams{
+		Type:        stripe.String("fixed_amount"),
+		DisplayName: stripe.String(rate.Name),
+		FixedAmount: &stripe.CheckoutSessionShippingOptionShippingRateDataFixedAmountParams{
+			Amount:   stripe.Int64(rate.Amount),
+			Currency: stripe.String(currency),
+		},
+	}
+	if rate.MinDays > 0 {
+		data.DeliveryEstimate = &stripe.CheckoutSessionShippingOptionShippingRateDataDeliveryEstimateParams{
+			Minimum: &stripe.CheckoutSessionShippingOptionShippingRateDataDeliveryEstimateMinimumParams{
+				Unit: stripe.String("business_day"), Value: stripe.Int64(rate.MinDays),
+			},
+			Maximum: &stripe.CheckoutSessionShippingOptionShippingRateDataDeliveryEstimateMaximumParams{
+				Unit: stripe.String("business_day"), Value: stripe.Int64(rate.MaxDays),
+			},
+		}
+	}
+	return &stripe.CheckoutSessionShippingOptionParams{ShippingRateData: data}
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseShippingRates(t *testing.T) {
+	rates, err := parseShippingRates("shr_123, Standard:500:5-7,Express:1500:2,Pickup:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ShippingRate{
+		{ID: "shr_123"},
+		{Name: "Standard", Amount: 500, MinDays: 5, MaxDays: 7},
+		{Name: "Express", Amount: 1500, MinDays: 2, MaxDays: 2},
+		{Name: "Pickup"},
+	}
+	if !reflect.DeepEqual(rates, want) {
+		t.Errorf("rates = %+v, want %+v", rates, want)
+	}
+	for _, bad := range []string{"Standard", "Standard:5.00", ":500", "Standard:-1", "Standard:500:7-5", "Standard:500:soon", "a:1:2:3"} {
+		if _, err := parseShippingRates(bad); err == nil {
+			t.Errorf("parseShippingRates(%q) succeeded", bad)
+		}
+	}
+}
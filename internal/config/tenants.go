@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Tenant is a brand whose checkout the server hosts on the brand's own
+// Stripe account. Requests pick a tenant with the X-Tenant header or a
+// /t/{id}/ path prefix; requests without one use the top-level settings.
+type Tenant struct {
+	ID             string
+	PublishableKey string
+	SecretKey      string
+	WebhookSecrets []string
+	// AccountID (acct_...) picks the tenant for Connect events delivered
+	// to the shared /webhook endpoint.
+	AccountID string
+	// Price is the tenant's default Price ID, like PRICE.
+	Price    string
+	Branding Branding
+}
+
+// Branding is how the storefront presents a tenant, returned by GET /config.
+type Branding struct {
+	Name    string `json:"name,omitempty"`
+	LogoURL string `json:"logoUrl,omitempty"`
+	Color   string `json:"color,omitempty"`
+}
+
+var (
+	tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	colorPattern    = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// parseBranding reads the BRAND_* settings under prefix.
+func parseBranding(src Source, prefix string) Branding {
+	return Branding{
+		Name:    src.get(prefix + "BRAND_NAME"),
+		LogoURL: src.get(prefix + "BRAND_LOGO_URL"),
+		Color:   src.get(prefix + "BRAND_COLOR"),
+	}
+}
+
+// parseTenants reads the tenants listed in TENANTS. Each one's settings are
+// named after its ID, e.g. TENANT_ACME_SECRET_KEY for acme, with dashes
+// becoming underscores.
+func parseTenants(src Source) []*Tenant {
+	var tenants []*Tenant
+	for _, id := range strings.Split(src.get("TENANTS"), ",") {
+		if id = strings.ToLower(strings.TrimSpace(id)); id == "" {
+			continue
+		}
+		prefix := "TENANT_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		t := &Tenant{
+			ID:             id,
+			PublishableKey: src.get(prefix + "PUBLISHABLE_KEY"),
+			SecretKey:      src.get(prefix + "SECRET_KEY"),
+			AccountID:      src.get(prefix + "ACCOUNT_ID"),
+			Price:          src.get(prefix + "PRICE"),
+			Branding:       parseBranding(src, prefix),
+		}
+		for _, secret := range strings.Split(src.get(prefix+"WEBHOOK_SECRET"), ",") {
+			if secret = strings.TrimSpace(secret); secret != "" {
+				t.WebhookSecrets = append(t.WebhookSecrets, secret)
+			}
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants
+}
+
+// validateTenants checks every tenant's settings against MODE.
+func validateTenants(c *Config) []error {
+	var errs []error
+	ids, accounts := map[string]bool{}, map[string]bool{}
+	for _, t := range c.Tenants {
+		fail := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("TENANTS entry %s: "+format, append([]interface{}{t.ID}, args...)...))
+		}
+		if !tenantIDPattern.MatchString(t.ID) {
+			fail("IDs may only contain lowercase letters, digits and dashes")
+		}
+		if ids[t.ID] {
+			fail("listed twice")
+		}
+		ids[t.ID] = true
+		for _, k := range []struct {
+			name, key string
+			prefixes  []string
+		}{
+			{"publishable key", t.PublishableKey, []string{"pk_"}},
+			{"secret key", t.SecretKey, []string{"sk_", "rk_"}},
+		} {
+			mode, err := keyMode(k.key, k.prefixes...)
+			if err != nil {
+				fail("%s: %w", k.name, err)
+			} else if mode != c.stripeKeyMode() {
+				fail("MODE is %s but the %s is a %s mode key", c.Mode, k.name, mode)
+			}
+		}
+		for _, secret := range t.WebhookSecrets {
+			if !strings.HasPrefix(secret, "whsec_") {
+				fail("webhook secrets must start with whsec_")
+				break
+			}
+		}
+		if t.AccountID != "" {
+			if !strings.HasPrefix(t.AccountID, "acct_") {
+				fail("account ID must start with acct_")
+			}
+			if accounts[t.AccountID] {
+				fail("account %s belongs to another tenant", t.AccountID)
+			}
+			accounts[t.AccountID] = true
+		}
+		if !strings.HasPrefix(t.Price, "price_") {
+			fail("a Price ID starting with price_ is required")
+		}
+		if t.Branding.Color != "" && !colorPattern.MatchString(t.Branding.Color) {
+			fail("brand color must look like #1a2b3c")
+		}
+	}
+	return errs
+}
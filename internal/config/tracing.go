@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// parseOTLPHeaders parses OTEL_EXPORTER_OTLP_HEADERS: comma separated
+// key=value entries with URL encoded values, e.g. api-key=abc%3D. Values
+// are usually credentials, so errors don't quote them.
+func parseOTLPHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for i, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		value, err := url.QueryUnescape(strings.TrimSpace(value))
+		if key = strings.TrimSpace(key); !ok || key == "" || err != nil {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %d: want key=value", i+1)
+		}
+		headers[key] = value
+	}
+	return headers, nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigTracing(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("PRICE", "price_basic")
+	t.Setenv("STRIPE_PUBLISHABLE_KEY", "pk_test_default")
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_default")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=abc%3D, x-team = payments")
+	t.Setenv("TRACE_SAMPLE_RATIO", "0.25")
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.OTLPTracesEndpoint != "http://collector:4318/v1/traces" || c.TraceSampleRatio != 0.25 {
+		t.Errorf("endpoint %q, ratio %v", c.OTLPTracesEndpoint, c.TraceSampleRatio)
+	}
+	if c.OTLPHeaders["api-key"] != "abc=" || c.OTLPHeaders["x-team"] != "payments" {
+		t.Errorf("headers = %v", c.OTLPHeaders)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "https://otlp.example.com/ingest")
+	if c, err = Load(); err != nil || c.OTLPTracesEndpoint != "https://otlp.example.com/ingest" {
+		t.Errorf("traces endpoint = %v, %v", c, err)
+	}
+	for key, value := range map[string]string{
+		"TRACE_SAMPLE_RATIO":                 "2",
+		"OTEL_EXPORTER_OTLP_HEADERS":         "api-key",
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "collector:4318",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := Load(); err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("%s=%s: %v", key, value, err)
+			}
+		})
+	}
+}
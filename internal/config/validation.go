@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// PaymentMethodTypes are the Checkout payment method types accepted in
+// PAYMENT_METHOD_TYPES and per-request overrides.
+var PaymentMethodTypes = map[string]bool{
+	"acss_debit": true, "affirm": true, "afterpay_clearpay": true, "alipay": true,
+	"au_becs_debit": true, "bacs_debit": true, "bancontact": true, "boleto": true,
+	"card": true, "customer_balance": true, "eps": true, "fpx": true,
+	"giropay": true, "grabpay": true, "ideal": true, "klarna": true,
+	"konbini": true, "link": true, "oxxo": true, "p24": true, "paynow": true,
+	"pix": true, "promptpay": true, "sepa_debit": true, "sofort": true,
+	"us_bank_account": true, "wechat_pay": true,
+}
+
+// ValidateEmail checks that email is a bare address, without a name.
+func ValidateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("invalid email %q", email)
+	}
+	return nil
+}
+
+// CurrencyPattern matches a lowercase ISO currency code.
+var CurrencyPattern = regexp.MustCompile(`^[a-z]{3}$`)
+
+// IsLocalHost reports whether host is the local machine, which may be
+// served over plain HTTP.
+func IsLocalHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// ValidProrationBehavior reports whether b is a proration_behavior Stripe
+// accepts for subscription updates.
+func ValidProrationBehavior(b string) bool {
+	switch stripe.SubscriptionProrationBehavior(b) {
+	case stripe.SubscriptionProrationBehaviorCreateProrations, stripe.SubscriptionProrationBehaviorAlwaysInvoice,
+		stripe.SubscriptionProrationBehaviorNone:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// LoadWebhookSecret reads the signing secret saved by
+// Service.RegisterWebhookEndpoint, or returns "" if there is none.
+func LoadWebhookSecret(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
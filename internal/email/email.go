@@ -0,0 +1,265 @@
+// Package email delivers email through SMTP, SendGrid or the log. It knows
+// nothing of what the service sends; templates and receipts stay with the
+// service.
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+)
+
+// Message is a single email. Text is the plain text alternative to HTML and
+// may be empty.
+type Message struct {
+	To          string
+	Subject     string
+	HTML        string
+	Text        string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to an email.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+// Sender delivers email through a specific backend.
+type Sender interface {
+	// Send gives up once ctx is cancelled.
+	Send(ctx context.Context, msg *Message) error
+}
+
+// LogSender only logs the emails it is given.
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, msg *Message) error {
+	slog.Info("sending email", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
+
+// SMTPSender sends email through an SMTP server, upgrading the connection
+// with STARTTLS when the server offers it.
+type SMTPSender struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPSender returns a sender for the server at host:port. It
+// authenticates when username is set.
+func NewSMTPSender(host, port, username, password, from string) *SMTPSender {
+	return &SMTPSender{
+		addr:     net.JoinHostPort(host, port),
+		host:     host,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+	return s.sendMail(ctx, auth, msg.To, s.message(msg))
+}
+
+// message is msg with its headers, as sent to the server.
+func (s *SMTPSender) message(msg *Message) []byte {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", s.from)
+	fmt.Fprintf(&body, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&body, "Subject: %s\r\n", msg.Subject)
+	body.WriteString("MIME-Version: 1.0\r\n")
+	if len(msg.Attachments) == 0 {
+		writeMIMEBody(&body, msg)
+		return body.Bytes()
+	}
+	mixed := "mixed-" + newBoundary()
+	fmt.Fprintf(&body, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n--%s\r\n", mixed, mixed)
+	writeMIMEBody(&body, msg)
+	for _, a := range msg.Attachments {
+		fmt.Fprintf(&body, "\r\n--%s\r\nContent-Type: %s\r\nContent-Disposition: attachment; filename=%q\r\nContent-Transfer-Encoding: base64\r\n\r\n", mixed, a.ContentType, a.Filename)
+		// Lines of base64 mustn't be longer than 76 characters.
+		encoded := base64.StdEncoding.EncodeToString(a.Content)
+		for len(encoded) > 76 {
+			body.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		body.WriteString(encoded + "\r\n")
+	}
+	fmt.Fprintf(&body, "--%s--\r\n", mixed)
+	return body.Bytes()
+}
+
+// writeMIMEBody writes the Content-Type header and body of msg's text: its
+// HTML, or both alternatives when it has Text.
+func writeMIMEBody(body *bytes.Buffer, msg *Message) {
+	if msg.Text == "" {
+		body.WriteString("Content-Type: text/html; charset=\"UTF-8\"\r\n\r\n")
+		body.WriteString(msg.HTML)
+		return
+	}
+	boundary := "alt-" + newBoundary()
+	fmt.Fprintf(body, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	for _, part := range []struct{ contentType, content string }{{"text/plain", msg.Text}, {"text/html", msg.HTML}} {
+		fmt.Fprintf(body, "--%s\r\nContent-Type: %s; charset=\"UTF-8\"\r\n\r\n%s\r\n", boundary, part.contentType, part.content)
+	}
+	fmt.Fprintf(body, "--%s--\r\n", boundary)
+}
+
+// newBoundary returns a random MIME boundary.
+func newBoundary() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sendMail is smtp.SendMail for a single recipient, with a connection that
+// is closed, aborting the conversation, when ctx is cancelled.
+func (s *SMTPSender) sendMail(ctx context.Context, auth smtp.Auth, to string, body []byte) (err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		if !stop() && err != nil {
+			err = ctx.Err()
+		}
+	}()
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// SendGridURL is SendGrid's mail send endpoint.
+const SendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGridSender sends email through SendGrid's v3 API.
+type SendGridSender struct {
+	apiKey string
+	from   string
+	client *http.Client
+	url    string
+}
+
+// NewSendGridSender returns a sender calling SendGrid with client.
+func NewSendGridSender(apiKey, from string, client *http.Client) *SendGridSender {
+	return &SendGridSender{apiKey: apiKey, from: from, client: client, url: SendGridURL}
+}
+
+func (s *SendGridSender) Send(ctx context.Context, msg *Message) error {
+	type address struct {
+		Email string `json:"email"`
+	}
+	type content struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	type personalization struct {
+		To []address `json:"to"`
+	}
+	type attachment struct {
+		Content     string `json:"content"`
+		Type        string `json:"type"`
+		Filename    string `json:"filename"`
+		Disposition string `json:"disposition"`
+	}
+	// SendGrid wants text/plain before text/html.
+	var sendGridContent []content
+	if msg.Text != "" {
+		sendGridContent = append(sendGridContent, content{Type: "text/plain", Value: msg.Text})
+	}
+	sendGridContent = append(sendGridContent, content{Type: "text/html", Value: msg.HTML})
+	var attachments []attachment
+	for _, a := range msg.Attachments {
+		attachments = append(attachments, attachment{
+			Content:     base64.StdEncoding.EncodeToString(a.Content),
+			Type:        a.ContentType,
+			Filename:    a.Filename,
+			Disposition: "attachment",
+		})
+	}
+	payload, err := json.Marshal(struct {
+		Personalizations []personalization `json:"personalizations"`
+		From             address           `json:"from"`
+		Subject          string            `json:"subject"`
+		Content          []content         `json:"content"`
+		Attachments      []attachment      `json:"attachments,omitempty"`
+	}{
+		Personalizations: []personalization{{To: []address{{Email: msg.To}}}},
+		From:             address{Email: s.from},
+		Subject:          msg.Subject,
+		Content:          sendGridContent,
+		Attachments:      attachments,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSMTPMessage(t *testing.T) {
+	s := NewSMTPSender("smtp.example.com", "587", "", "", "shop@example.com")
+	body := string(s.message(&Message{To: "jenny@example.com", Subject: "Your receipt", HTML: "<p>Thanks</p>"}))
+	for _, want := range []string{"From: shop@example.com\r\n", "To: jenny@example.com\r\n", "Content-Type: text/html", "\r\n\r\n<p>Thanks</p>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("message doesn't contain %q:\n%s", want, body)
+		}
+	}
+
+	body = string(s.message(&Message{
+		To: "jenny@example.com", Subject: "Your receipt", HTML: "<p>Thanks</p>", Text: "Thanks",
+		Attachments: []Attachment{{Filename: "receipt.pdf", ContentType: "application/pdf", Content: []byte(strings.Repeat("%PDF", 40))}},
+	}))
+	for _, want := range []string{"multipart/mixed", "multipart/alternative", "text/plain", `filename="receipt.pdf"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("message doesn't contain %q:\n%s", want, body)
+		}
+	}
+	for _, line := range strings.Split(body, "\r\n") {
+		if len(line) > 76 && !strings.HasPrefix(line, "Content-") {
+			t.Errorf("line of %d characters: %s", len(line), line)
+		}
+	}
+}
+
+func TestSendGridSender(t *testing.T) {
+	var got struct {
+		From    struct{ Email string }
+		Subject string
+		Content []struct{ Type, Value string }
+	}
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	s := NewSendGridSender("SG.key", "shop@example.com", srv.Client())
+	s.url = srv.URL
+
+	if err := s.Send(context.Background(), &Message{To: "jenny@example.com", Subject: "Hi", HTML: "<p>Hi</p>", Text: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer SG.key" || got.From.Email != "shop@example.com" || got.Subject != "Hi" ||
+		len(got.Content) != 2 || got.Content[0].Type != "text/plain" {
+		t.Errorf("sent %+v with %q", got, auth)
+	}
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	if err := s.Send(context.Background(), &Message{To: "jenny@example.com"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Send = %v, want the status", err)
+	}
+}
@@ -0,0 +1,245 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// parsePaymentFilter reads status, currency, from, to, limit and offset from
+// the query string. Dates are YYYY-MM-DD (UTC) or RFC 3339; a plain "to" date
+// includes the whole day.
+func parsePaymentFilter(r *http.Request) (service.PaymentFilter, error) {
+	q := r.URL.Query()
+	f := service.PaymentFilter{
+		Status:   q.Get("status"),
+		Currency: strings.ToLower(q.Get("currency")),
+		Limit:    service.DefaultPageSize,
+	}
+	if f.Currency != "" && !config.CurrencyPattern.MatchString(f.Currency) {
+		return f, fmt.Errorf("invalid currency %q", f.Currency)
+	}
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, _, err = parseDateParam(v); err != nil {
+			return f, fmt.Errorf("invalid from: %v", err)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		var dateOnly bool
+		if f.To, dateOnly, err = parseDateParam(v); err != nil {
+			return f, fmt.Errorf("invalid to: %v", err)
+		}
+		if dateOnly {
+			f.To = f.To.AddDate(0, 0, 1)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > service.MaxPageSize {
+			return f, fmt.Errorf("limit must be between 1 and %d", service.MaxPageSize)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			return f, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	return f, nil
+}
+
+func parseDateParam(v string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse("2006-01-02", v); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, v)
+	return t, false, err
+}
+
+// handleAdminPayments serves GET /admin/payments.
+func (srv *Server) handleAdminPayments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	f, err := parsePaymentFilter(r)
+	if err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	list, hasMore, err := srv.svc.ListPayments(r.Context(), f)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, struct {
+		Payments []*service.Payment `json:"payments"`
+		Limit    int                `json:"limit"`
+		Offset   int                `json:"offset"`
+		HasMore  bool               `json:"hasMore"`
+	}{list, f.Limit, f.Offset, hasMore})
+}
+
+// PaymentDetail is the full view of one payment for the admin API. TaxIDs
+// are the ones its checkout session collected, with their VIES checks.
+type PaymentDetail struct {
+	Payment       *service.Payment        `json:"payment"`
+	Refunds       []*service.Refund       `json:"refunds"`
+	TaxIDs        []*service.TaxID        `json:"taxIds,omitempty"`
+	Session       *stripe.CheckoutSession `json:"session,omitempty"`
+	PaymentIntent *stripe.PaymentIntent   `json:"paymentIntent,omitempty"`
+}
+
+// handleAdminPayment serves GET /admin/payments/{id}, where id is a checkout
+// session or payment intent ID, and POST /admin/payments/{id}/resend-receipt,
+// which sends the confirmation email of a paid checkout again.
+func (srv *Server) handleAdminPayment(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/admin/payments/")
+	if len(parts) == 0 || len(parts) > 2 || (len(parts) == 2 && parts[1] != "resend-receipt") {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if (len(parts) == 1 && r.Method != "GET") || (len(parts) == 2 && r.Method != "POST") {
+		writeMethodNotAllowed(w)
+		return
+	}
+	p, err := srv.svc.GetPayment(r.Context(), parts[0])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(parts) == 2 {
+		srv.resendReceipt(w, r, p)
+		return
+	}
+
+	detail := &PaymentDetail{Payment: p, Refunds: []*service.Refund{}}
+	if p.PaymentIntentID != "" {
+		refunds, err := srv.svc.Payments.ListRefunds(r.Context(), p.PaymentIntentID)
+		if err != nil {
+			writeError(w, r, service.InternalError("listing refunds", err))
+			return
+		}
+		if refunds != nil {
+			detail.Refunds = refunds
+		}
+	}
+	if strings.HasPrefix(p.SessionID, "cs_") {
+		if detail.TaxIDs, err = srv.svc.Payments.ListTaxIDs(r.Context(), p.SessionID); err != nil {
+			writeError(w, r, service.InternalError("listing tax IDs", err))
+			return
+		}
+		params := &stripe.CheckoutSessionParams{}
+		params.AddExpand("payment_intent")
+		params.AddExpand("line_items")
+		detail.Session, err = srv.svc.Stripe.GetCheckoutSession(r.Context(), p.SessionID, params)
+		if err != nil {
+			writeError(w, r, &service.StripeFailure{Action: "fetching session", Err: err})
+			return
+		}
+		detail.PaymentIntent = detail.Session.PaymentIntent
+	} else if p.PaymentIntentID != "" {
+		detail.PaymentIntent, err = srv.svc.Stripe.GetPaymentIntent(r.Context(), p.PaymentIntentID, nil)
+		if err != nil {
+			writeError(w, r, &service.StripeFailure{Action: "fetching payment intent", Err: err})
+			return
+		}
+	}
+	writeJSON(w, detail)
+}
+
+// ResendReceiptResponse is returned by POST
+// /admin/payments/{id}/resend-receipt.
+type ResendReceiptResponse struct {
+	SessionID string `json:"sessionId"`
+	Email     string `json:"email"`
+}
+
+// resendReceipt queues the confirmation email of p's checkout session again,
+// built from the session as Stripe has it now.
+func (srv *Server) resendReceipt(w http.ResponseWriter, r *http.Request, p *service.Payment) {
+	if !strings.HasPrefix(p.SessionID, "cs_") {
+		writeError(w, r, &service.Error{Status: http.StatusConflict, Message: "payment has no checkout session to send a receipt for"})
+		return
+	}
+	s, err := srv.svc.Stripe.GetCheckoutSession(r.Context(), p.SessionID, nil)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "fetching session", Err: err})
+		return
+	}
+	if s.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid {
+		writeError(w, r, &service.Error{Status: http.StatusConflict, Message: "checkout session isn't paid"})
+		return
+	}
+	receipt := srv.svc.SessionReceipt(r.Context(), s)
+	if receipt.Email == "" {
+		writeError(w, r, &service.Error{Status: http.StatusConflict, Message: "checkout session has no customer email"})
+		return
+	}
+	if err := srv.svc.Jobs.Enqueue(r.Context(), service.JobSendConfirmationEmail, receipt); err != nil {
+		writeError(w, r, service.InternalError("queueing receipt", err))
+		return
+	}
+	logFor(r).Info("receipt resent", "session", s.ID)
+	srv.svc.RecordAudit(r.Context(), service.AuditActor(r.Context()), "receipt.resent", s.ID, nil, map[string]string{"email": receipt.Email})
+	writeJSON(w, &ResendReceiptResponse{SessionID: s.ID, Email: receipt.Email})
+}
+
+// DailyRevenue is the paid total for one day and currency.
+type DailyRevenue struct {
+	Date     string `json:"date"`
+	Currency string `json:"currency"`
+	Amount   int64  `json:"amount"`
+	Count    int    `json:"count"`
+}
+
+// handleAdminRevenue serves GET /admin/revenue, the paid revenue per UTC day
+// and currency. It accepts the same from, to and currency filters as
+// /admin/payments.
+func (srv *Server) handleAdminRevenue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	f, err := parsePaymentFilter(r)
+	if err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	f.Status = "paid"
+	f.Limit, f.Offset = 0, 0
+	list, err := srv.svc.Payments.ListPayments(r.Context(), f)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing payments", err))
+		return
+	}
+
+	byKey := map[string]*DailyRevenue{}
+	days := []*DailyRevenue{}
+	for _, p := range list {
+		date := p.CreatedAt.UTC().Format("2006-01-02")
+		key := date + "/" + p.Currency
+		d, ok := byKey[key]
+		if !ok {
+			d = &DailyRevenue{Date: date, Currency: p.Currency}
+			byKey[key] = d
+			days = append(days, d)
+		}
+		d.Amount += p.Amount
+		d.Count++
+	}
+	sort.Slice(days, func(i, j int) bool {
+		if days[i].Date != days[j].Date {
+			return days[i].Date < days[j].Date
+		}
+		return days[i].Currency < days[j].Currency
+	})
+	writeJSON(w, days)
+}
@@ -0,0 +1,118 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// seedPayments stores payments on consecutive days starting 2024-03-01.
+func (e *testEnv) seedPayments(list ...*service.Payment) {
+	e.t.Helper()
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, p := range list {
+		p.CreatedAt = day.AddDate(0, 0, i)
+		if err := e.svc.Payments.SavePayment(context.Background(), p); err != nil {
+			e.t.Fatal(err)
+		}
+	}
+}
+
+type paymentsPage struct {
+	Payments []*service.Payment `json:"payments"`
+	HasMore  bool               `json:"hasMore"`
+}
+
+func TestAdminPayments(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedPayments(
+		&service.Payment{SessionID: "cs_test_1", PaymentIntentID: "pi_1", Amount: 1000, Currency: "usd", Status: "paid"},
+		&service.Payment{SessionID: "cs_test_2", PaymentIntentID: "pi_2", Amount: 2000, Currency: "eur", Status: "paid"},
+		&service.Payment{SessionID: "cs_test_3", PaymentIntentID: "pi_3", Amount: 3000, Currency: "usd", Status: "unpaid"},
+	)
+
+	for _, tt := range []struct {
+		query   string
+		want    []string
+		hasMore bool
+	}{
+		{"", []string{"cs_test_3", "cs_test_2", "cs_test_1"}, false},
+		{"?status=paid", []string{"cs_test_2", "cs_test_1"}, false},
+		{"?currency=USD", []string{"cs_test_3", "cs_test_1"}, false},
+		{"?from=2024-03-02&to=2024-03-02", []string{"cs_test_2"}, false},
+		{"?limit=2", []string{"cs_test_3", "cs_test_2"}, true},
+		{"?limit=2&offset=2", []string{"cs_test_1"}, false},
+	} {
+		w := e.admin("GET", "/admin/payments"+tt.query, nil)
+		checkStatus(t, w, http.StatusOK)
+		var page paymentsPage
+		decodeBody(t, w, &page)
+		var got []string
+		for _, p := range page.Payments {
+			got = append(got, p.SessionID)
+		}
+		if len(got) != len(tt.want) || page.HasMore != tt.hasMore {
+			t.Errorf("%s: got %v (hasMore %v), want %v (hasMore %v)", tt.query, got, page.HasMore, tt.want, tt.hasMore)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+	}
+
+	for _, q := range []string{"?currency=dollars", "?from=yesterday", "?limit=0", "?limit=1000", "?offset=-1"} {
+		checkStatus(t, e.admin("GET", "/admin/payments"+q, nil), http.StatusBadRequest)
+	}
+}
+
+func TestAdminPaymentDetail(t *testing.T) {
+	e := newTestEnv(t)
+	pi := &stripe.PaymentIntent{ID: "pi_elements", Amount: 500, Currency: "usd"}
+	e.stripe.PaymentIntents[pi.ID] = pi
+	e.seedPayments(&service.Payment{SessionID: "pi_elements", PaymentIntentID: "pi_elements", Amount: 500, Currency: "usd", Status: "paid"})
+	if err := e.svc.Payments.SaveRefund(context.Background(), &service.Refund{ID: "re_1", PaymentIntentID: "pi_elements", Amount: 100, Currency: "usd", Status: "succeeded"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := e.admin("GET", "/admin/payments/pi_elements", nil)
+	checkStatus(t, w, http.StatusOK)
+	var detail PaymentDetail
+	decodeBody(t, w, &detail)
+	if detail.Payment.Amount != 500 || len(detail.Refunds) != 1 || detail.PaymentIntent == nil || detail.Session != nil {
+		t.Errorf("detail = %+v", detail)
+	}
+	checkStatus(t, e.admin("GET", "/admin/payments/cs_test_missing", nil), http.StatusNotFound)
+}
+
+func TestAdminRevenue(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedPayments(
+		&service.Payment{SessionID: "cs_test_1", Amount: 1000, Currency: "usd", Status: "paid"},
+		&service.Payment{SessionID: "cs_test_2", Amount: 2000, Currency: "eur", Status: "paid"},
+		&service.Payment{SessionID: "cs_test_3", Amount: 3000, Currency: "usd", Status: "unpaid"},
+	)
+	w := e.admin("GET", "/admin/revenue", nil)
+	checkStatus(t, w, http.StatusOK)
+	var days []*DailyRevenue
+	decodeBody(t, w, &days)
+	want := []DailyRevenue{
+		{Date: "2024-03-01", Currency: "usd", Amount: 1000, Count: 1},
+		{Date: "2024-03-02", Currency: "eur", Amount: 2000, Count: 1},
+	}
+	if len(days) != len(want) {
+		t.Fatalf("revenue = %+v, want %+v", days, want)
+	}
+	for i := range want {
+		if *days[i] != want[i] {
+			t.Errorf("day %d = %+v, want %+v", i, *days[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+)
+
+func (e *testEnv) serveAssets(path string) *httptest.ResponseRecorder {
+	e.t.Helper()
+	mux := http.NewServeMux()
+	e.srv.registerRoutes(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestEmbeddedStaticAssets(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.StaticDir = ""
+	// The success page is a template; without a session it renders its
+	// error state.
+	for _, path := range []string{"/success.html", "/html/success.html"} {
+		w := e.serveAssets(path)
+		checkStatus(t, w, http.StatusBadRequest)
+		if body := w.Body.String(); !strings.Contains(body, `data-i18n="sessionNotFound"`) || strings.Contains(body, "{{") {
+			t.Errorf("%s = %.100q, want the rendered success page", path, body)
+		}
+	}
+	w := e.serveAssets("/")
+	checkStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "<html") {
+		t.Errorf("/ = %.100q", w.Body.String())
+	}
+}
+
+func TestStaticDirOverride(t *testing.T) {
+	e := newTestEnv(t)
+	if err := os.WriteFile(filepath.Join(e.svc.Config.StaticDir, "success.html"), []byte("{{if .Error}}dev copy{{end}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if w := e.serveAssets("/html/success.html"); w.Body.String() != "dev copy" {
+		t.Errorf("success page = %.100q, want the file from STATIC_DIR", w.Body.String())
+	}
+	checkStatus(t, e.serveAssets("/index.js"), http.StatusNotFound)
+}
+
+func TestEmailTemplateDirOverride(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.EmailTemplateDir = t.TempDir()
+	if _, err := service.LoadEmailTemplates(e.svc.EmailTemplateFS()); err == nil || !strings.Contains(err.Error(), "no en directory") {
+		t.Errorf("err = %v", err)
+	}
+	e.svc.Config.EmailTemplateDir = servicetest.RepoPath("templates", "email")
+	if _, err := service.LoadEmailTemplates(e.svc.EmailTemplateFS()); err != nil {
+		t.Error(err)
+	}
+}
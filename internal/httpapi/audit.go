@@ -0,0 +1,117 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"stripe_go/internal/service"
+)
+
+// maxAuditResponseBytes caps how much of an admin response is kept as its
+// after state.
+const maxAuditResponseBytes = 16 << 10
+
+// auditRecorder keeps the status and the start of the body of a response.
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (ar *auditRecorder) WriteHeader(code int) {
+	ar.status = code
+	ar.ResponseWriter.WriteHeader(code)
+}
+
+func (ar *auditRecorder) Write(b []byte) (int, error) {
+	if n := maxAuditResponseBytes - ar.body.Len(); n > 0 {
+		ar.body.Write(b[:min(n, len(b))])
+	}
+	return ar.ResponseWriter.Write(b)
+}
+
+func (ar *auditRecorder) Unwrap() http.ResponseWriter { return ar.ResponseWriter }
+
+// auditRequest runs an admin request that changes state and, unless the
+// handler audited it itself, records it as an "admin.request" entry whose
+// after state is the response: the payment, order or stock the handler
+// returned.
+func (srv *Server) auditRequest(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	audited := false
+	r = r.WithContext(context.WithValue(r.Context(), service.AuditedKey{}, &audited))
+	rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+	next(rec, r)
+	if audited {
+		return
+	}
+	after := map[string]interface{}{"method": r.Method, "status": rec.status}
+	if body := rec.body.Bytes(); json.Valid(body) {
+		after["response"] = json.RawMessage(body)
+	}
+	srv.svc.RecordAudit(r.Context(), service.AuditActor(r.Context()), "admin.request", r.URL.Path, nil, after)
+}
+
+// handleAdminAudit serves GET /admin/audit, filtered by actor, action,
+// object, from and to, newest first.
+func (srv *Server) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	q := r.URL.Query()
+	f := service.AuditFilter{Actor: q.Get("actor"), Action: q.Get("action"), Object: q.Get("object"), Limit: service.DefaultPageSize}
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, _, err = parseDateParam(v); err != nil {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid from: %v", err)})
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		var dateOnly bool
+		if f.To, dateOnly, err = parseDateParam(v); err != nil {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid to: %v", err)})
+			return
+		}
+		if dateOnly {
+			f.To = f.To.AddDate(0, 0, 1)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > service.MaxPageSize {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", service.MaxPageSize)})
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid offset %q", v)})
+			return
+		}
+	}
+	// Fetch one extra row to know whether there is another page.
+	limit := f.Limit
+	f.Limit++
+	list, err := srv.svc.Payments.ListAudit(r.Context(), f)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing audit log", err))
+		return
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []*service.AuditEntry{}
+	}
+	writeJSON(w, struct {
+		Entries []*service.AuditEntry `json:"entries"`
+		Limit   int                   `json:"limit"`
+		Offset  int                   `json:"offset"`
+		HasMore bool                  `json:"hasMore"`
+	}{list, limit, f.Offset, hasMore})
+}
@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+type auditPage struct {
+	Entries []*service.AuditEntry `json:"entries"`
+	HasMore bool                  `json:"hasMore"`
+}
+
+func (e *testEnv) audit(query string) []*service.AuditEntry {
+	e.t.Helper()
+	w := e.admin("GET", "/admin/audit"+query, nil)
+	checkStatus(e.t, w, http.StatusOK)
+	var page auditPage
+	decodeBody(e.t, w, &page)
+	return page.Entries
+}
+
+func TestAuditRecordsOperations(t *testing.T) {
+	e := newTestEnv(t)
+	resp, pi := e.authorizedSession()
+	checkStatus(t, e.admin("POST", "/payments/"+resp.ID+"/capture", nil), http.StatusOK)
+	e.stripe.PaymentIntents[pi.ID] = &stripe.PaymentIntent{ID: pi.ID, Amount: 3000, Currency: "usd"}
+	checkStatus(t, e.admin("POST", "/refunds", service.RefundRequest{PaymentIntentID: pi.ID, Amount: 1000}), http.StatusOK)
+
+	checkouts := e.audit("?action=checkout.created")
+	if len(checkouts) != 1 || checkouts[0].Actor != service.ActorCustomer || checkouts[0].Object != resp.ID {
+		t.Fatalf("checkout entries = %+v", checkouts)
+	}
+	var order service.Order
+	if err := json.Unmarshal(checkouts[0].After, &order); err != nil || order.ID != resp.OrderID {
+		t.Errorf("checkout after = %s", checkouts[0].After)
+	}
+
+	if got := e.audit("?actor=stripe&action=webhook.checkout.session.completed"); len(got) != 1 || got[0].Object != resp.ID {
+		t.Errorf("webhook entries = %+v", got)
+	}
+
+	captures := e.audit("?action=admin.request&object=/payments/" + resp.ID + "/capture")
+	if len(captures) != 1 || captures[0].Actor != "admin_token" {
+		t.Fatalf("capture entries = %+v", captures)
+	}
+	var after struct {
+		Status   int              `json:"status"`
+		Response *service.Payment `json:"response"`
+	}
+	if err := json.Unmarshal(captures[0].After, &after); err != nil || after.Status != http.StatusOK || after.Response == nil {
+		t.Errorf("capture after = %s", captures[0].After)
+	}
+
+	// The refund handler records its own entry rather than a generic one.
+	refunds := e.audit("?object=" + pi.ID + "&action=refund.created")
+	if len(refunds) != 1 {
+		t.Fatalf("refund entries = %+v", refunds)
+	}
+	var before service.Payment
+	if err := json.Unmarshal(refunds[0].Before, &before); err != nil || before.Status != "paid" {
+		t.Errorf("refund before = %s", refunds[0].Before)
+	}
+	var refund service.Refund
+	if err := json.Unmarshal(refunds[0].After, &refund); err != nil || refund.Amount != 1000 {
+		t.Errorf("refund after = %s", refunds[0].After)
+	}
+	if refunds[0].RequestID == "" {
+		t.Error("refund entry has no request ID")
+	}
+	if got := e.audit("?action=admin.request&object=/refunds"); len(got) != 0 {
+		t.Errorf("refund also audited generically: %+v", got)
+	}
+
+	// Reading the log isn't itself audited.
+	for _, entry := range e.audit("?action=admin.request") {
+		if entry.Object == "/admin/audit" {
+			t.Errorf("audited a read: %+v", entry)
+		}
+	}
+}
+
+func TestAuditFilters(t *testing.T) {
+	e := newTestEnv(t)
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, action := range []string{"refund.created", "checkout.created", "refund.created"} {
+		err := e.svc.Payments.AppendAudit(context.Background(), &service.AuditEntry{ID: "aud_" + action + string(rune('a'+i)), Actor: "ops", Action: action, CreatedAt: day.AddDate(0, 0, i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := e.audit("?action=refund.created"); len(got) != 2 || !got[0].CreatedAt.After(got[1].CreatedAt) {
+		t.Errorf("refund entries = %+v, want 2 newest first", got)
+	}
+	if got := e.audit("?from=2024-03-02&to=2024-03-02"); len(got) != 1 || got[0].Action != "checkout.created" {
+		t.Errorf("entries on 2024-03-02 = %+v", got)
+	}
+	w := e.admin("GET", "/admin/audit?actor=ops&limit=2", nil)
+	checkStatus(t, w, http.StatusOK)
+	var page auditPage
+	decodeBody(t, w, &page)
+	if len(page.Entries) != 2 || !page.HasMore {
+		t.Errorf("page = %d entries, hasMore %v", len(page.Entries), page.HasMore)
+	}
+
+	for query, want := range map[string]string{
+		"?from=yesterday": "invalid from",
+		"?to=03/01/2024":  "invalid to",
+		"?limit=0":        "limit must be between",
+		"?offset=-1":      "invalid offset",
+	} {
+		checkErrorMessage(t, e.admin("GET", "/admin/audit"+query, nil), http.StatusBadRequest, want)
+	}
+	checkStatus(t, e.admin("DELETE", "/admin/audit", nil), http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,43 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// requireAuth only lets requests through that carry
+// "Authorization: Bearer <credential>": ADMIN_TOKEN, a key from API_KEYS, or
+// a JWT signed with one of JWT_SECRETS. GET and HEAD requests need the
+// readonly or admin role, other methods admin. When no credentials are
+// configured the wrapped endpoint is disabled. Requests that change state
+// are recorded in the audit log.
+func (srv *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !srv.svc.AuthEnabled() {
+			writeError(w, r, service.StatusError(http.StatusNotFound))
+			return
+		}
+		p, err := srv.svc.Authenticate(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), time.Now())
+		if err != nil {
+			logFor(r).Info("rejected credentials", "path", r.URL.Path, "error", err)
+			writeError(w, r, service.StatusError(http.StatusUnauthorized))
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" && p.Role != config.RoleAdmin {
+			logFor(r).Info("forbidden", "path", r.URL.Path, "principal", p.Name, "role", p.Role)
+			writeError(w, r, &service.Error{Status: http.StatusForbidden, Message: fmt.Sprintf("%s role can't %s %s", p.Role, r.Method, r.URL.Path)})
+			return
+		}
+		r = r.WithContext(service.WithPrincipal(r.Context(), p))
+		if r.Method == "GET" || r.Method == "HEAD" {
+			next(w, r)
+			return
+		}
+		srv.auditRequest(w, r, next)
+	}
+}
@@ -0,0 +1,209 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+	"stripe_go/internal/webhookhandlers"
+)
+
+// chargeNeedingAuthentication makes an off-session charge that the bank
+// wants the customer to authenticate, and returns its payment intent.
+func (e *testEnv) chargeNeedingAuthentication() string {
+	e.t.Helper()
+	e.stripe.Customers["cus_usage"] = &stripe.Customer{ID: "cus_usage", Email: "jenny@example.com"}
+	w := e.admin("POST", "/charges/off-session", service.OffSessionChargeRequest{
+		Customer: "cus_usage", PaymentMethod: "pm_card_authenticationRequired", Amount: 4200, Currency: "eur",
+	})
+	checkStatus(e.t, w, http.StatusPaymentRequired)
+	var resp service.OffSessionChargeResponse
+	decodeBody(e.t, w, &resp)
+	return resp.PaymentIntentID
+}
+
+func (e *testEnv) authentication(paymentIntentID string) *service.Authentication {
+	e.t.Helper()
+	a, err := e.svc.Payments.GetAuthentication(context.Background(), paymentIntentID)
+	if err != nil {
+		e.t.Fatalf("GetAuthentication(%s): %v", paymentIntentID, err)
+	}
+	return a
+}
+
+// paymentIntentEvent is an event about an off-session payment intent.
+func paymentIntentEvent(id, eventType, paymentIntentID, extra string) []byte {
+	return []byte(fmt.Sprintf(`{"id": %q, "object": "event", "type": %q, "data": {"object": {
+		"id": %q, "object": "payment_intent", "amount": 4200, "currency": "eur", "customer": "cus_usage",
+		"client_secret": "%s_secret_fake", "payment_method": "pm_card_authenticationRequired",
+		"metadata": {"off_session": "true"}%s}}}`, id, eventType, paymentIntentID, paymentIntentID, extra))
+}
+
+func TestAuthenticationRetried(t *testing.T) {
+	e := newTestEnv(t)
+	id := e.chargeNeedingAuthentication()
+	if a := e.authentication(id); a.Status != service.AuthenticationPending || a.Email != "jenny@example.com" || a.ExpiresAt.IsZero() {
+		t.Fatalf("authentication = %+v", a)
+	}
+
+	// Stripe's own events about the charge don't email the customer again
+	// or mark the payment failed.
+	e.deliverOK(paymentIntentEvent("evt_action", "payment_intent.requires_action", id, `, "status": "requires_action"`))
+	e.deliverOK(paymentIntentEvent("evt_required", "payment_intent.payment_failed", id,
+		`, "status": "requires_payment_method", "last_payment_error": {"code": "authentication_required", "type": "card_error"}`))
+	if len(e.emails.Sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(e.emails.Sent))
+	}
+	if !strings.Contains(e.emails.Sent[0].Text, "The link works until") {
+		t.Errorf("email doesn't say when the link expires:\n%s", e.emails.Sent[0].Text)
+	}
+	if p := e.payment(id); p.Status != "requires_action" {
+		t.Errorf("payment status = %s, want requires_action", p.Status)
+	}
+
+	// A turned down authentication is emailed again until the cap.
+	for i := 1; i <= webhookhandlers.MaxAuthenticationEmails; i++ {
+		e.deliverOK(paymentIntentEvent(fmt.Sprintf("evt_failed_%d", i), "payment_intent.payment_failed", id,
+			`, "status": "requires_payment_method", "last_payment_error": {"code": "payment_intent_authentication_failure", "type": "invalid_request_error", "payment_method": {"id": "pm_card_authenticationRequired"}}`))
+	}
+	if a := e.authentication(id); a.Failures != webhookhandlers.MaxAuthenticationEmails || a.Status != service.AuthenticationPending {
+		t.Errorf("authentication = %+v", a)
+	}
+	if len(e.emails.Sent) != webhookhandlers.MaxAuthenticationEmails {
+		t.Fatalf("sent %d emails, want %d", len(e.emails.Sent), webhookhandlers.MaxAuthenticationEmails)
+	}
+	if msg := e.emails.Sent[1]; !strings.Contains(msg.Text, "didn't go through") || !strings.Contains(msg.Text, "client_secret="+id+"_secret_fake") {
+		t.Errorf("retry email:\n%s", msg.Text)
+	}
+
+	e.stripe.PaymentIntents[id].Status = stripe.PaymentIntentStatusSucceeded
+	e.deliverOK(paymentIntentEvent("evt_succeeded", "payment_intent.succeeded", id, `, "status": "succeeded"`))
+	if a := e.authentication(id); a.Status != service.AuthenticationSucceeded {
+		t.Errorf("status = %s, want succeeded", a.Status)
+	}
+	// The scheduled expiry finds nothing left to do.
+	if err := e.svc.ExpireAuthentication(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if pi := e.stripe.PaymentIntents[id]; pi.Status != stripe.PaymentIntentStatusSucceeded {
+		t.Errorf("payment intent %s", pi.Status)
+	}
+}
+
+func TestAuthenticationExpired(t *testing.T) {
+	e := newTestEnv(t)
+	id := e.chargeNeedingAuthentication()
+	pending, _ := e.svc.Jobs.Snapshot()
+	var scheduled bool
+	for _, job := range pending {
+		scheduled = scheduled || job.Type == service.JobExpireAuthentication
+	}
+	if !scheduled {
+		t.Fatal("expiry not scheduled")
+	}
+
+	if err := e.svc.ExpireAuthentication(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if pi := e.stripe.PaymentIntents[id]; pi.Status != stripe.PaymentIntentStatusCanceled || pi.CancellationReason != stripe.PaymentIntentCancellationReasonAbandoned {
+		t.Errorf("payment intent %s, reason %q", pi.Status, pi.CancellationReason)
+	}
+	if a := e.authentication(id); a.Status != service.AuthenticationExpired {
+		t.Errorf("status = %s, want expired", a.Status)
+	}
+	// An email still queued isn't sent for an expired authentication.
+	e.runJobs()
+	if len(e.emails.Sent) != 0 {
+		t.Errorf("sent %d emails", len(e.emails.Sent))
+	}
+}
+
+func TestAuthenticationJobsQueuedAgain(t *testing.T) {
+	e := newTestEnv(t)
+	// The queue can't be saved: its directory doesn't exist.
+	var err error
+	if e.svc.Jobs, err = service.NewJobQueue(filepath.Join(t.TempDir(), "missing", "jobs.json"), 1, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	e.svc.RegisterJobHandlers()
+	id := e.chargeNeedingAuthentication()
+	if a := e.authentication(id); a.Status != service.AuthenticationPending {
+		t.Fatalf("authentication = %+v", a)
+	}
+	// The queue is lost with the restart that follows.
+	if e.svc.Jobs, err = service.NewJobQueue("", 1, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	e.svc.RegisterJobHandlers()
+
+	e.deliverOK(paymentIntentEvent("evt_action", "payment_intent.requires_action", id, `, "status": "requires_action"`))
+	if len(e.emails.Sent) != 1 {
+		t.Fatalf("sent %d emails, want the lost one", len(e.emails.Sent))
+	}
+	pending, _ := e.svc.Jobs.Snapshot()
+	if len(pending) != 1 || pending[0].Type != service.JobExpireAuthentication || !pending[0].NextRunAt.Equal(e.authentication(id).ExpiresAt) {
+		t.Fatalf("pending = %+v, want the expiry at %s", pending, e.authentication(id).ExpiresAt)
+	}
+	// Once both are queued, nothing is queued again.
+	e.deliverOK(paymentIntentEvent("evt_action_again", "payment_intent.requires_action", id, `, "status": "requires_action"`))
+	if pending, _ := e.svc.Jobs.Snapshot(); len(pending) != 1 || len(e.emails.Sent) != 1 {
+		t.Errorf("pending %d jobs, sent %d emails", len(pending), len(e.emails.Sent))
+	}
+}
+
+func TestAuthenticationRequiresActionEvent(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Customers["cus_usage"] = &stripe.Customer{ID: "cus_usage", Email: "jenny@example.com", PreferredLocales: []string{"de"}}
+
+	// Payment intents confirmed with the customer present are theirs to
+	// authenticate.
+	e.deliverOK([]byte(`{"id": "evt_present", "object": "event", "type": "payment_intent.requires_action", "data": {"object": {
+		"id": "pi_present", "object": "payment_intent", "amount": 4200, "currency": "eur", "customer": "cus_usage", "status": "requires_action"}}}`))
+	if _, err := e.svc.Payments.GetAuthentication(context.Background(), "pi_present"); err != service.ErrAuthenticationNotFound {
+		t.Errorf("GetAuthentication = %v", err)
+	}
+
+	e.deliverOK(paymentIntentEvent("evt_action", "payment_intent.requires_action", "pi_async", `, "status": "requires_action"`))
+	if a := e.authentication("pi_async"); a.Status != service.AuthenticationPending || a.Locale != "de" {
+		t.Errorf("authentication = %+v", a)
+	}
+	if p := e.payment("pi_async"); p.Status != "requires_action" {
+		t.Errorf("payment status = %s", p.Status)
+	}
+	if len(e.emails.Sent) != 1 || e.emails.Sent[0].Subject != "Bitte bestätigen Sie Ihre Zahlung" {
+		t.Fatalf("sent %+v", e.emails.Sent)
+	}
+	// Redelivery doesn't email again.
+	e.deliverOK(paymentIntentEvent("evt_action_again", "payment_intent.requires_action", "pi_async", `, "status": "requires_action"`))
+	if len(e.emails.Sent) != 1 {
+		t.Errorf("sent %d emails", len(e.emails.Sent))
+	}
+}
+
+func TestInvoicePaymentActionRequired(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.PaymentIntents["pi_invoice"] = &stripe.PaymentIntent{ID: "pi_invoice", Status: stripe.PaymentIntentStatusRequiresAction}
+	e.deliverOK([]byte(`{"id": "evt_invoice_action", "object": "event", "type": "invoice.payment_action_required", "data": {"object": {
+		"id": "in_123", "object": "invoice", "customer": "cus_usage", "customer_email": "jenny@example.com", "payment_intent": "pi_invoice",
+		"amount_due": 1500, "currency": "usd", "hosted_invoice_url": "https://invoice.stripe.com/i/in_123"}}}`))
+	if len(e.emails.Sent) != 1 || !strings.Contains(e.emails.Sent[0].HTML, "https://invoice.stripe.com/i/in_123") {
+		t.Fatalf("sent %+v", e.emails.Sent)
+	}
+	// Invoices stay open for Stripe's retries.
+	if err := e.svc.ExpireAuthentication(context.Background(), "pi_invoice"); err != nil {
+		t.Fatal(err)
+	}
+	if a := e.authentication("pi_invoice"); a.Status != service.AuthenticationExpired || a.InvoiceID != "in_123" {
+		t.Errorf("authentication = %+v", a)
+	}
+	if pi := e.stripe.PaymentIntents["pi_invoice"]; pi.Status != stripe.PaymentIntentStatusRequiresAction {
+		t.Errorf("payment intent %s", pi.Status)
+	}
+}
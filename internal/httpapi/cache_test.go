@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/store"
+)
+
+func TestConfigUsesCachedPrice(t *testing.T) {
+	e := newTestEnv(t)
+	checkStatus(t, e.do("GET", "/config", nil), http.StatusOK)
+	e.stripe.Err = &stripe.Error{HTTPStatusCode: http.StatusTooManyRequests, Msg: "rate limited"}
+	checkStatus(t, e.do("GET", "/config", nil), http.StatusOK)
+
+	e.svc.Cache = store.NoCache{}
+	checkStatus(t, e.do("GET", "/config", nil), http.StatusServiceUnavailable)
+}
+
+// failingCache is a cache whose backend is down.
+type failingCache struct{}
+
+func (failingCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+func (failingCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+func (failingCache) Delete(ctx context.Context, key string) error {
+	return errors.New("connection refused")
+}
+
+func TestCacheFailureFallsBackToStripe(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Cache = failingCache{}
+	checkStatus(t, e.do("GET", "/config", nil), http.StatusOK)
+	s := e.paidSession()
+	if _, err := e.svc.CachedCheckoutSession(context.Background(), s.ID); err != nil {
+		t.Fatal(err)
+	}
+	e.deliverOK(sessionEvent("checkout.session.expired", s.ID))
+}
+
+func TestCheckoutSessionCacheInvalidatedByWebhook(t *testing.T) {
+	e := newTestEnv(t)
+	s := e.paidSession()
+	s.PaymentStatus = stripe.CheckoutSessionPaymentStatusUnpaid
+	status := func() stripe.CheckoutSessionPaymentStatus {
+		t.Helper()
+		got, err := e.svc.CachedCheckoutSession(context.Background(), s.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.PaymentStatus
+	}
+	if got := status(); got != stripe.CheckoutSessionPaymentStatusUnpaid {
+		t.Fatalf("payment status = %s", got)
+	}
+	s.PaymentStatus = stripe.CheckoutSessionPaymentStatusPaid
+	if got := status(); got != stripe.CheckoutSessionPaymentStatusUnpaid {
+		t.Errorf("payment status = %s, want the cached unpaid", got)
+	}
+	e.deliverOK(sessionEvent("checkout.session.async_payment_succeeded", s.ID))
+	if got := status(); got != stripe.CheckoutSessionPaymentStatusPaid {
+		t.Errorf("payment status = %s after the webhook, want paid", got)
+	}
+}
@@ -0,0 +1,159 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+var cancellationReasons = map[string]bool{
+	"":                      true,
+	"abandoned":             true,
+	"duplicate":             true,
+	"fraudulent":            true,
+	"requested_by_customer": true,
+}
+
+// checkCaptureAmount checks amount against p's authorization: it may be up
+// to the authorized amount, or up to the maximum Stripe reported for cards
+// that allow overcapture.
+func checkCaptureAmount(p *service.Payment, amount int64) error {
+	switch {
+	case p.Status != "authorized" || p.AmountAuthorized == 0:
+		return &service.Error{Status: http.StatusConflict, Message: "payment has no authorization to capture"}
+	case amount <= p.AmountAuthorized || amount <= p.MaxCapturable:
+		return nil
+	case p.MaxCapturable > 0:
+		return service.BadRequest(fmt.Errorf("amount exceeds the overcapture maximum of %d", p.MaxCapturable))
+	}
+	return service.BadRequest(fmt.Errorf("amount exceeds the authorized %d and the card doesn't allow overcapture", p.AmountAuthorized))
+}
+
+// paymentIntentStatus maps a payment intent onto the local payment status.
+func paymentIntentStatus(pi *stripe.PaymentIntent) string {
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		return "paid"
+	case stripe.PaymentIntentStatusRequiresCapture:
+		return "authorized"
+	default:
+		return string(pi.Status)
+	}
+}
+
+// handlePaymentAction serves POST /payments/{id}/capture and
+// POST /payments/{id}/cancel for payments authorized with manual capture.
+// id is a checkout session or payment intent ID.
+func (srv *Server) handlePaymentAction(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/payments/")
+	if len(parts) != 2 || (parts[1] != "capture" && parts[1] != "cancel") {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	id, action := parts[0], parts[1]
+	var req service.CancelPaymentRequest
+	var capture service.CapturePaymentRequest
+	if isJSONRequest(r) {
+		var err error
+		if action == "cancel" {
+			err = srv.decodeJSON(w, r, &req)
+		} else {
+			err = srv.decodeJSON(w, r, &capture)
+		}
+		if err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	} else {
+		r.ParseForm()
+		if action == "cancel" {
+			req.Reason = r.PostFormValue("reason")
+		} else if amount := r.PostFormValue("amount"); amount != "" {
+			var err error
+			capture.Amount, err = strconv.ParseInt(amount, 10, 64)
+			if err != nil {
+				writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("error parsing amount %v", err.Error())})
+				return
+			}
+			if err := capture.Validate(r.Context(), srv.svc); err != nil {
+				writeError(w, r, service.BadRequest(err))
+				return
+			}
+		}
+	}
+	if !cancellationReasons[req.Reason] {
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid reason %q", req.Reason)})
+		return
+	}
+
+	// The local payment is only needed to find the payment intent of a
+	// session, and to check a capture amount against the authorization.
+	paymentIntentID := id
+	if !strings.HasPrefix(id, "pi_") || capture.Amount > 0 {
+		p, err := srv.svc.FindPayment(r.Context(), id)
+		if err == service.ErrPaymentNotFound {
+			writeError(w, r, err)
+			return
+		}
+		if err != nil {
+			writeError(w, r, service.InternalError("fetching payment", err))
+			return
+		}
+		if p.PaymentIntentID == "" {
+			writeError(w, r, &service.Error{Status: http.StatusConflict, Message: "payment has no payment intent yet"})
+			return
+		}
+		if capture.Amount > 0 {
+			if err := checkCaptureAmount(p, capture.Amount); err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
+		paymentIntentID = p.PaymentIntentID
+	}
+
+	var pi *stripe.PaymentIntent
+	var err error
+	if action == "capture" {
+		params := &stripe.PaymentIntentCaptureParams{}
+		if capture.Amount > 0 {
+			params.AmountToCapture = stripe.Int64(capture.Amount)
+		}
+		params.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "capture_payment_intent"))
+		pi, err = srv.svc.Stripe.CapturePaymentIntent(r.Context(), paymentIntentID, params)
+		if err != nil {
+			writeError(w, r, &service.StripeFailure{Action: "capturing payment", Err: err})
+			return
+		}
+		logFor(r).Info("payment captured", "payment_intent", pi.ID, "amount", pi.AmountReceived)
+	} else {
+		params := &stripe.PaymentIntentCancelParams{}
+		if req.Reason != "" {
+			params.CancellationReason = stripe.String(req.Reason)
+		}
+		params.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "cancel_payment_intent"))
+		pi, err = srv.svc.Stripe.CancelPaymentIntent(r.Context(), paymentIntentID, params)
+		if err != nil {
+			writeError(w, r, &service.StripeFailure{Action: "canceling payment", Err: err})
+			return
+		}
+		logFor(r).Info("payment canceled", "payment_intent", pi.ID, "reason", req.Reason)
+	}
+	// Stripe has made the change; record it even if the caller has gone.
+	p, err := srv.svc.RecordPaymentIntent(context.WithoutCancel(r.Context()), pi, paymentIntentStatus(pi))
+	if err != nil {
+		writeError(w, r, service.InternalError("saving payment", err))
+		return
+	}
+	writeJSON(w, p)
+}
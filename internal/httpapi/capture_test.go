@@ -0,0 +1,170 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// authorizedSession checks out with manual capture and delivers the events
+// of an authorized card: the session completes unpaid and the payment intent
+// waits for capture.
+func (e *testEnv) authorizedSession() (service.CreateCheckoutResponse, *stripe.PaymentIntent) {
+	e.t.Helper()
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":         []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+		"captureMethod": "manual",
+	})
+	checkStatus(e.t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(e.t, w, &resp)
+
+	pi := &stripe.PaymentIntent{
+		ID: "pi_test_manual", Amount: 3000, AmountCapturable: 3000, Currency: "usd",
+		Status:   stripe.PaymentIntentStatusRequiresCapture,
+		Metadata: map[string]string{service.OrderMetadataKey: resp.OrderID},
+	}
+	e.stripe.PaymentIntents[pi.ID] = pi
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_manual_completed", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": "pi_test_manual", "payment_status": "unpaid",
+		"amount_total": 3000, "currency": "usd", "metadata": {"order": %q}}}}`, resp.ID, resp.OrderID)))
+	e.deliverOK(e.intentEvent("payment_intent.amount_capturable_updated", pi))
+	return resp, pi
+}
+
+func (e *testEnv) intentEvent(eventType string, pi *stripe.PaymentIntent) []byte {
+	return []byte(fmt.Sprintf(`{"id": "evt_%s_%s", "object": "event", "type": %q, "data": {"object": {
+		"id": %q, "object": "payment_intent", "amount": %d, "amount_capturable": %d, "currency": "usd", "status": %q,
+		"metadata": {"order": %q}}}}`, pi.ID, pi.Status, eventType, pi.ID, pi.Amount, pi.AmountCapturable, pi.Status, pi.Metadata[service.OrderMetadataKey]))
+}
+
+func TestManualCaptureCheckout(t *testing.T) {
+	e := newTestEnv(t)
+	resp, pi := e.authorizedSession()
+	if got := stripe.StringValue(e.stripe.SessionParams[0].PaymentIntentData.CaptureMethod); got != "manual" {
+		t.Errorf("capture method = %q, want manual", got)
+	}
+	if p := e.payment(resp.ID); p.Status != "authorized" {
+		t.Errorf("status = %q after authorization, want authorized", p.Status)
+	}
+	if o := e.order(resp.OrderID); o.Status != service.OrderPending {
+		t.Errorf("order status = %s before capture, want pending", o.Status)
+	}
+	if len(e.emails.Sent) != 0 {
+		t.Errorf("%d emails sent before capture", len(e.emails.Sent))
+	}
+
+	w := e.admin("POST", "/payments/"+resp.ID+"/capture", nil)
+	checkStatus(t, w, http.StatusOK)
+	var p service.Payment
+	decodeBody(t, w, &p)
+	if p.Status != "paid" || p.SessionID != resp.ID {
+		t.Errorf("captured payment = %+v", p)
+	}
+	e.deliverOK(e.intentEvent("payment_intent.succeeded", pi))
+	if o := e.order(resp.OrderID); o.Status != service.OrderPaid {
+		t.Errorf("order status = %s after capture, want paid", o.Status)
+	}
+
+	checkErrorMessage(t, e.admin("POST", "/payments/"+resp.ID+"/capture", nil), http.StatusBadRequest, "could not be captured")
+	checkErrorMessage(t, e.admin("POST", "/payments/"+resp.ID+"/cancel", nil), http.StatusBadRequest, "could not be canceled")
+}
+
+func TestCancelAuthorizedPayment(t *testing.T) {
+	e := newTestEnv(t)
+	resp, pi := e.authorizedSession()
+
+	checkErrorMessage(t, e.admin("POST", "/payments/pi_test_manual/cancel", map[string]string{"reason": "changed_mind"}), http.StatusBadRequest, "invalid reason")
+
+	w := e.admin("POST", "/payments/pi_test_manual/cancel", map[string]string{"reason": "requested_by_customer"})
+	checkStatus(t, w, http.StatusOK)
+	if p := e.payment(resp.ID); p.Status != "canceled" {
+		t.Errorf("status = %q, want canceled", p.Status)
+	}
+	if pi.CancellationReason != "requested_by_customer" {
+		t.Errorf("cancellation reason = %q", pi.CancellationReason)
+	}
+	e.deliverOK(e.intentEvent("payment_intent.canceled", pi))
+	if o := e.order(resp.OrderID); o.Status != service.OrderCanceled {
+		t.Errorf("order status = %s, want canceled", o.Status)
+	}
+	// The authorization arriving late doesn't revive the payment.
+	pi.Status = stripe.PaymentIntentStatusRequiresCapture
+	e.deliverOK(e.intentEvent("payment_intent.amount_capturable_updated", pi))
+	if p := e.payment(resp.ID); p.Status != "canceled" {
+		t.Errorf("status = %q after a late authorization, want canceled", p.Status)
+	}
+}
+
+func TestPaymentActionErrors(t *testing.T) {
+	e := newTestEnv(t)
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":         []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"captureMethod": "later",
+	}), http.StatusBadRequest, "invalid captureMethod")
+	checkErrorMessage(t, e.admin("POST", "/payments/cs_test_missing/capture", nil), http.StatusNotFound, "payment not found")
+	checkErrorMessage(t, e.admin("POST", "/payments/pi_test_missing/capture", nil), http.StatusNotFound, "No such payment_intent")
+	checkErrorMessage(t, e.admin("POST", "/payments/pi_test_1/refund", nil), http.StatusNotFound, "")
+	checkStatus(t, e.admin("GET", "/payments/pi_test_1/capture", nil), http.StatusMethodNotAllowed)
+	checkStatus(t, e.do("POST", "/payments/pi_test_1/capture", nil), http.StatusUnauthorized)
+}
+
+func TestPartialCapture(t *testing.T) {
+	e := newTestEnv(t)
+	resp, pi := e.authorizedSession()
+	if p := e.payment(resp.ID); p.AmountAuthorized != 3000 {
+		t.Errorf("authorized amount = %d, want 3000", p.AmountAuthorized)
+	}
+
+	checkErrorMessage(t, e.admin("POST", "/payments/"+resp.ID+"/capture", map[string]int64{"amount": -1}), http.StatusBadRequest, "amount must be positive")
+	checkErrorMessage(t, e.admin("POST", "/payments/"+resp.ID+"/capture", map[string]int64{"amount": 3500}), http.StatusBadRequest, "doesn't allow overcapture")
+
+	w := e.admin("POST", "/payments/"+resp.ID+"/capture", url.Values{"amount": {"1200"}})
+	checkStatus(t, w, http.StatusOK)
+	var p service.Payment
+	decodeBody(t, w, &p)
+	if p.Status != "paid" || p.AmountAuthorized != 3000 || p.AmountCaptured != 1200 {
+		t.Errorf("captured payment = %+v, want 1200 of 3000 captured", p)
+	}
+	if amount := e.stripe.CaptureParams[0].AmountToCapture; amount == nil || *amount != 1200 {
+		t.Errorf("amount to capture = %v, want 1200", amount)
+	}
+	// The payment succeeding doesn't lose the captured amount.
+	e.deliverOK(e.intentEvent("payment_intent.succeeded", pi))
+	if p := e.payment(resp.ID); p.AmountCaptured != 1200 {
+		t.Errorf("captured amount = %d after payment_intent.succeeded", p.AmountCaptured)
+	}
+
+	checkErrorMessage(t, e.admin("POST", "/refunds", service.RefundRequest{PaymentIntentID: pi.ID, Amount: 2000}), http.StatusBadRequest, "payment total of 1200")
+	checkErrorMessage(t, e.admin("POST", "/payments/"+resp.ID+"/capture", map[string]int64{"amount": 100}), http.StatusConflict, "no authorization to capture")
+}
+
+func TestOvercapture(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.Overcapture = true
+	resp, _ := e.authorizedSession()
+	if got := e.stripe.SessionParams[0].Extra.Get("payment_method_options[card][request_overcapture]"); got != "if_available" {
+		t.Errorf("request_overcapture = %q, want if_available", got)
+	}
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_overcapture", "object": "event", "type": "payment_intent.amount_capturable_updated", "data": {"object": {
+		"id": "pi_test_manual", "object": "payment_intent", "amount": 3000, "amount_capturable": 3000, "currency": "usd", "status": "requires_capture",
+		"metadata": {"order": %q}, "charges": {"object": "list", "data": [{"id": "ch_overcapture", "object": "charge",
+			"payment_method_details": {"type": "card", "card": {"overcapture": {"maximum_amount_capturable": 4000, "status": "available"}}}}]}}}}`, resp.OrderID)))
+	if p := e.payment(resp.ID); p.MaxCapturable != 4000 {
+		t.Errorf("max capturable = %d, want 4000", p.MaxCapturable)
+	}
+
+	checkErrorMessage(t, e.admin("POST", "/payments/"+resp.ID+"/capture", map[string]int64{"amount": 4500}), http.StatusBadRequest, "overcapture maximum of 4000")
+	w := e.admin("POST", "/payments/"+resp.ID+"/capture", map[string]int64{"amount": 3900})
+	checkStatus(t, w, http.StatusOK)
+	var p service.Payment
+	decodeBody(t, w, &p)
+	if p.AmountCaptured != 3900 {
+		t.Errorf("captured amount = %d, want 3900", p.AmountCaptured)
+	}
+}
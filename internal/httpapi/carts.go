@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"stripe_go/internal/service"
+)
+
+// handleCarts serves POST /carts, which saves a cart in the body format of
+// /create-checkout-session.
+func (srv *Server) handleCarts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req service.CreateCheckoutRequest
+	if err := srv.decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	if req.Customer != "" {
+		if err := srv.authorizeCustomer(r, req.Customer, req.CustomerToken, "create sessions for a customer"); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	cart, err := srv.svc.SaveCart(r.Context(), &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, cart)
+}
+
+// handleCart serves GET /carts/{id} and POST /carts/{id}/checkout.
+func (srv *Server) handleCart(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/carts/")
+	if len(parts) == 0 || len(parts) > 2 || (len(parts) == 2 && parts[1] != "checkout") {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if len(parts) == 2 {
+		if r.Method != "POST" {
+			writeMethodNotAllowed(w)
+			return
+		}
+		// The body is optional and only carries a store credit code.
+		var req service.CartCheckoutRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, r, service.BadRequest(fmt.Errorf("error parsing request %v", err)))
+			return
+		}
+		resp, err := srv.svc.CheckoutCart(r.Context(), parts[0], req.StoreCredit, r.Header.Get("Accept-Language"))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, resp)
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	cart, err := srv.svc.GetCart(r.Context(), parts[0])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, cart)
+}
@@ -0,0 +1,154 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// cartSessionEvent is an event about the fake's session id, carrying the
+// session's metadata as Stripe would.
+func (e *testEnv) cartSessionEvent(eventType, id string) []byte {
+	e.t.Helper()
+	metadata, err := json.Marshal(e.stripe.Sessions[id].Metadata)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return []byte(fmt.Sprintf(`{"id": "evt_%s_%s", "object": "event", "type": %q, "data": {"object": {
+		"id": %q, "object": "checkout.session", "metadata": %s}}}`, eventType, id, eventType, id, metadata))
+}
+
+func (e *testEnv) cart(id string) *service.Cart {
+	e.t.Helper()
+	var cart service.Cart
+	w := e.do("GET", "/carts/"+id, nil)
+	checkStatus(e.t, w, http.StatusOK)
+	decodeBody(e.t, w, &cart)
+	return &cart
+}
+
+func TestSavedCartCheckout(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/carts", map[string]interface{}{
+		"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+		"metadata": map[string]string{"ref": "newsletter"},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var cart service.Cart
+	decodeBody(t, w, &cart)
+	if cart.Status != service.CartOpen || cart.SessionID != "" || len(cart.Checkout.Items) != 1 {
+		t.Fatalf("saved cart = %+v", cart)
+	}
+	if len(e.stripe.SessionParams) != 0 {
+		t.Error("saving a cart created a session")
+	}
+
+	var first service.CreateCheckoutResponse
+	w = e.do("POST", "/carts/"+cart.ID+"/checkout", nil)
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &first)
+	s := e.stripe.Sessions[first.ID]
+	if s.Metadata[service.CartMetadataKey] != cart.ID || s.Metadata["ref"] != "newsletter" || s.AmountTotal != 3000 {
+		t.Errorf("session = %+v", s)
+	}
+	if c := e.cart(cart.ID); c.SessionID != first.ID || c.SessionStatus != "open" || c.OrderID != first.OrderID {
+		t.Errorf("cart after checkout = %+v", c)
+	}
+
+	// Checking out again, e.g. from another tab, resumes the open session.
+	s.Status = stripe.CheckoutSessionStatusOpen
+	var resumed service.CreateCheckoutResponse
+	w = e.do("POST", "/carts/"+cart.ID+"/checkout", nil)
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &resumed)
+	if resumed.ID != first.ID || resumed.URL != first.URL || len(e.stripe.SessionParams) != 1 {
+		t.Errorf("resumed checkout = %+v, want session %s", resumed, first.ID)
+	}
+
+	// Once it expires the cart gets a fresh session and order.
+	s.Status = stripe.CheckoutSessionStatusExpired
+	e.deliverOK(e.cartSessionEvent("checkout.session.expired", first.ID))
+	if c := e.cart(cart.ID); c.Status != service.CartOpen || c.SessionStatus != "expired" {
+		t.Errorf("cart after expiry = %+v", c)
+	}
+	var second service.CreateCheckoutResponse
+	w = e.do("POST", "/carts/"+cart.ID+"/checkout", nil)
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &second)
+	if second.ID == first.ID || second.OrderID == first.OrderID {
+		t.Errorf("checkout after expiry = %+v, want a new session and order", second)
+	}
+	// The old session's events no longer concern the cart.
+	e.deliverOK(e.cartSessionEvent("checkout.session.async_payment_failed", first.ID))
+	if c := e.cart(cart.ID); c.SessionID != second.ID || c.SessionStatus != "open" {
+		t.Errorf("cart after a late event = %+v", c)
+	}
+
+	e.deliverOK(e.cartSessionEvent("checkout.session.completed", second.ID))
+	if c := e.cart(cart.ID); c.Status != service.CartCompleted || c.SessionStatus != "complete" {
+		t.Errorf("cart after payment = %+v", c)
+	}
+	checkErrorMessage(t, e.do("POST", "/carts/"+cart.ID+"/checkout", nil), http.StatusConflict, "cart "+cart.ID+" has been checked out")
+}
+
+func TestSavedCartErrors(t *testing.T) {
+	e := newTestEnv(t)
+	checkErrorMessage(t, e.do("POST", "/carts", map[string]interface{}{"items": []service.CheckoutItem{}}), http.StatusBadRequest, "cart is empty")
+	checkErrorMessage(t, e.do("POST", "/carts", map[string]interface{}{
+		"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"metadata": map[string]string{service.CartMetadataKey: "cart_mine"},
+	}), http.StatusBadRequest, `metadata key "cart" is reserved`)
+	checkErrorMessage(t, e.do("GET", "/carts/cart_nope", nil), http.StatusNotFound, "cart not found")
+	checkErrorMessage(t, e.do("POST", "/carts/cart_nope/checkout", nil), http.StatusNotFound, "cart not found")
+	checkStatus(t, e.do("GET", "/carts/cart_nope/checkout", nil), http.StatusMethodNotAllowed)
+	checkStatus(t, e.do("GET", "/carts/cart_nope/items", nil), http.StatusNotFound)
+}
+
+func TestSavedCartStoreCredit(t *testing.T) {
+	e := newTestEnv(t)
+	issued := e.issueStoreCredit(1000, "usd")
+	items := []service.CheckoutItem{{Price: "price_basic", Quantity: 2}}
+	// Codes are only stored hashed, so a cart can't keep one.
+	w := e.do("POST", "/carts", map[string]interface{}{"items": items, "storeCredit": issued.Code})
+	checkErrorMessage(t, w, http.StatusBadRequest, "storeCredit can't be saved with a cart; pass it to checkout")
+
+	var cart service.Cart
+	decodeBody(t, e.do("POST", "/carts", map[string]interface{}{"items": items}), &cart)
+	checkErrorMessage(t, e.do("POST", "/carts/"+cart.ID+"/checkout", map[string]string{"storeCredit": "nope"}),
+		http.StatusBadRequest, "invalid store credit code")
+	var resp service.CreateCheckoutResponse
+	w = e.do("POST", "/carts/"+cart.ID+"/checkout", map[string]string{"storeCredit": issued.Code})
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &resp)
+	if resp.StoreCreditApplied != 1000 {
+		t.Errorf("applied %d", resp.StoreCreditApplied)
+	}
+	if w := e.do("GET", "/carts/"+cart.ID, nil); strings.Contains(w.Body.String(), issued.Code) {
+		t.Error("the cart shows the code")
+	}
+}
+
+func TestSavedCartAsyncPaymentFailed(t *testing.T) {
+	e := newTestEnv(t)
+	var cart service.Cart
+	decodeBody(t, e.do("POST", "/carts", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}}), &cart)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, e.do("POST", "/carts/"+cart.ID+"/checkout", nil), &resp)
+
+	e.deliverOK(e.cartSessionEvent("checkout.session.completed", resp.ID))
+	e.deliverOK(e.cartSessionEvent("checkout.session.async_payment_failed", resp.ID))
+	if c := e.cart(cart.ID); c.Status != service.CartOpen || c.SessionStatus != "payment_failed" {
+		t.Errorf("cart after a failed bank debit = %+v", c)
+	}
+	e.stripe.Sessions[resp.ID].Status = stripe.CheckoutSessionStatusComplete
+	checkStatus(t, e.do("POST", "/carts/"+cart.ID+"/checkout", nil), http.StatusOK)
+	if len(e.stripe.SessionParams) != 2 {
+		t.Errorf("sessions created = %d, want a second one after the failed payment", len(e.stripe.SessionParams))
+	}
+}
@@ -0,0 +1,11 @@
+package httpapi
+
+import "net/http"
+
+func (srv *Server) handleProducts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	writeJSON(w, srv.svc.CatalogFor(r.Context()).Products())
+}
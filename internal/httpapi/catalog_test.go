@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestHandleProducts(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("GET", "/products", nil)
+	checkStatus(t, w, http.StatusOK)
+	var got []*service.CatalogProduct
+	decodeBody(t, w, &got)
+	if len(got) != 2 || len(got[0].Prices) != 2 || len(got[1].Prices) != 2 {
+		t.Fatalf("products = %+v", got)
+	}
+	if r := got[1].Prices[0].Recurring; r == nil || r.Interval != "month" {
+		t.Errorf("recurring = %+v, want monthly", r)
+	}
+	checkStatus(t, e.do("POST", "/products", nil), http.StatusMethodNotAllowed)
+}
+
+func TestCatalogSkipsPricesOfInactiveProducts(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Prices = append(e.stripe.Prices, &stripe.Price{
+		ID: "price_orphan", Product: &stripe.Product{ID: "prod_archived"}, UnitAmount: 100, Currency: "usd",
+	})
+	if err := e.svc.Catalog.Load(context.Background(), e.svc.Stripe); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.svc.Catalog.Price("price_orphan"); ok {
+		t.Error("price of an inactive product is in the catalog")
+	}
+	if _, ok := e.svc.Catalog.Price("price_basic"); !ok {
+		t.Error("price_basic missing after reload")
+	}
+}
+
+func TestCatalogTiers(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("GET", "/products", nil)
+	checkStatus(t, w, http.StatusOK)
+	var got []*service.CatalogProduct
+	decodeBody(t, w, &got)
+	seats := got[1].Prices[1]
+	if seats.ID != "price_seats" || seats.TiersMode != "graduated" || len(seats.Tiers) != 3 {
+		t.Fatalf("price = %+v, want the graduated seats price", seats)
+	}
+	if tier := seats.Tiers[2]; tier.UpTo != 0 || tier.UnitAmount != 500 {
+		t.Errorf("last tier = %+v", tier)
+	}
+	if seats.Tiers[0].UpTo != 5 || seats.Tiers[0].FlatAmount != 500 {
+		t.Errorf("first tier = %+v", seats.Tiers[0])
+	}
+	if got[0].Prices[0].Tiers != nil {
+		t.Error("per-unit price has tiers")
+	}
+}
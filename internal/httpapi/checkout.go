@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"stripe_go/internal/service"
+)
+
+// parseCreateCheckoutRequest reads and validates the request body. JSON
+// bodies are decoded as a CreateCheckoutRequest; plain form posts buy the
+// given quantity of the default PRICE.
+func (srv *Server) parseCreateCheckoutRequest(w http.ResponseWriter, r *http.Request) (*service.CreateCheckoutRequest, error) {
+	if isJSONRequest(r) {
+		var req service.CreateCheckoutRequest
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			return nil, err
+		}
+		return &req, nil
+	}
+
+	r.ParseForm()
+	quantity, err := strconv.ParseInt(r.PostFormValue("quantity"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing quantity %v", err.Error())
+	}
+	req := &service.CreateCheckoutRequest{
+		Items:         []service.CheckoutItem{{Price: srv.svc.DefaultPrice(r.Context()), Quantity: quantity}},
+		Customer:      r.PostFormValue("customer"),
+		Email:         r.PostFormValue("email"),
+		Coupon:        r.PostFormValue("coupon"),
+		PromotionCode: r.PostFormValue("promotionCode"),
+		Seller:        r.PostFormValue("seller"),
+		Metadata:      formMetadata(r),
+		SuccessURL:    r.PostFormValue("successUrl"),
+		CancelURL:     r.PostFormValue("cancelUrl"),
+		Currency:      r.PostFormValue("currency"),
+		Locale:        r.PostFormValue("locale"),
+		CaptureMethod: r.PostFormValue("captureMethod"),
+		StoreCredit:   r.PostFormValue("storeCredit"),
+		CustomerToken: r.PostFormValue("customerToken"),
+
+		PaymentMethodTypes: formList(r, "paymentMethodTypes"),
+	}
+	return req, req.Validate(r.Context(), srv.svc)
+}
+
+// formMetadata collects form fields named metadata[key].
+func formMetadata(r *http.Request) map[string]string {
+	m := map[string]string{}
+	for name, values := range r.PostForm {
+		if strings.HasPrefix(name, "metadata[") && strings.HasSuffix(name, "]") && len(values) > 0 {
+			m[name[len("metadata["):len(name)-1]] = values[0]
+		}
+	}
+	return m
+}
+
+// formList collects the values of a repeatable form field; each value may
+// also be a comma separated list.
+func formList(r *http.Request, name string) []string {
+	var list []string
+	for _, v := range r.PostForm[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+func (srv *Server) handleCreateCheckoutSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	req, err := srv.parseCreateCheckoutRequest(w, r)
+	if err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	if req.Customer != "" {
+		if err := srv.authorizeCustomer(r, req.Customer, req.CustomerToken, "create sessions for a customer"); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	resp, err := srv.svc.CreateCheckout(r.Context(), req, r.Header.Get("Accept-Language"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if isJSONRequest(r) {
+		writeJSON(w, resp)
+		return
+	}
+	http.Redirect(w, r, resp.URL, http.StatusSeeOther)
+}
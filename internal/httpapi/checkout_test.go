@@ -0,0 +1,328 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+)
+
+func TestCreateCheckoutSessionJSON(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{
+			{Price: "price_basic", Quantity: 2},
+			{Price: "price_basic", Quantity: 1},
+		},
+		"metadata": map[string]string{"order_id": "1234"},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	if resp.ID == "" || resp.URL == "" {
+		t.Fatalf("response = %+v, want an ID and URL", resp)
+	}
+
+	params := e.stripe.SessionParams[0]
+	if len(params.LineItems) != 1 || *params.LineItems[0].Quantity != 3 {
+		t.Errorf("line items not merged: %+v", params.LineItems)
+	}
+	if got := stripe.StringValue(params.Mode); got != "payment" {
+		t.Errorf("mode = %q, want payment", got)
+	}
+	if got := stripe.StringValue(params.SuccessURL); got != "http://localhost:4242/html/success.html?session_id={CHECKOUT_SESSION_ID}" {
+		t.Errorf("success URL = %q", got)
+	}
+	if params.Metadata["order_id"] != "1234" || params.PaymentIntentData.Metadata["order_id"] != "1234" {
+		t.Errorf("metadata not passed to the session and payment intent: %v / %v", params.Metadata, params.PaymentIntentData.Metadata)
+	}
+
+	p := e.payment(resp.ID)
+	if p.Amount != 4500 || p.Currency != "usd" || p.Status != "unpaid" || p.Metadata["order_id"] != "1234" {
+		t.Errorf("stored payment = %+v", p)
+	}
+}
+
+func TestCreateCheckoutSessionForm(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-checkout-session", url.Values{
+		"quantity":           {"2"},
+		"metadata[order_id]": {"99"},
+	})
+	checkStatus(t, w, http.StatusSeeOther)
+	if loc := w.Header().Get("Location"); loc == "" {
+		t.Fatal("no redirect to Checkout")
+	}
+	params := e.stripe.SessionParams[0]
+	if stripe.StringValue(params.LineItems[0].Price) != "price_basic" || *params.LineItems[0].Quantity != 2 {
+		t.Errorf("line items = %+v, want 2 of the default price", params.LineItems[0])
+	}
+	if params.Metadata["order_id"] != "99" {
+		t.Errorf("metadata = %v", params.Metadata)
+	}
+}
+
+func TestCreateCheckoutSessionEmail(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"email": "jenny@example.com",
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	if got := stripe.StringValue(e.stripe.SessionParams[0].CustomerEmail); got != "jenny@example.com" {
+		t.Errorf("customer email = %q, want the Checkout page prefilled", got)
+	}
+	if o := e.order(resp.OrderID); o.Email != "jenny@example.com" {
+		t.Errorf("order email = %q, want it stored before payment", o.Email)
+	}
+
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_email_completed", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_status": "paid", "amount_total": 1500, "currency": "usd",
+		"customer_details": {"email": "billing@example.com"}, "metadata": {"order": %q}}}}`, resp.ID, resp.OrderID)))
+	e.runJobs()
+	if len(e.emails.Sent) != 1 || e.emails.Sent[0].To != "jenny@example.com" {
+		t.Fatalf("emails = %+v, want the receipt sent to the captured email", e.emails.Sent)
+	}
+
+	w = e.do("POST", "/create-checkout-session", url.Values{"quantity": {"1"}, "email": {"sam@example.com"}})
+	checkStatus(t, w, http.StatusSeeOther)
+	if got := stripe.StringValue(e.stripe.SessionParams[1].CustomerEmail); got != "sam@example.com" {
+		t.Errorf("form customer email = %q", got)
+	}
+}
+
+func TestCreateCheckoutSessionValidation(t *testing.T) {
+	e := newTestEnv(t)
+	for _, tt := range []struct {
+		name string
+		body interface{}
+		want string
+	}{
+		{"empty cart", map[string]interface{}{}, "cart is empty"},
+		{"unknown price", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_nope", Quantity: 1}}}, "unknown price"},
+		{"zero quantity", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic"}}}, "at least 1"},
+		{"merged quantity too high", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 6}, {Price: "price_basic", Quantity: 5}}}, "between 1 and 10"},
+		{"bad customer", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}, "customer": "bob"}, "invalid customer"},
+		{"bad email", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}, "email": "jenny"}, "invalid email"},
+		{"email with customer", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}, "email": "jenny@example.com", "customer": "cus_123"}, "can't be combined with customer"},
+		{"bad metadata key", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}, "metadata": map[string]string{"a[b]": "c"}}, "invalid metadata key"},
+		{"receipt metadata key", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}, "metadata": map[string]string{"stripe_receipt": "true"}}, `metadata key "stripe_receipt" is reserved`},
+		{"foreign success URL", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}, "successUrl": "https://evil.example.net/"}, "not allowed"},
+		{"plain http cancel URL", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}, "cancelUrl": "http://shop.example.com/"}, "must use https"},
+		{"malformed JSON", []byte(`{"items": [`), "error parsing request"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErrorMessage(t, e.do("POST", "/create-checkout-session", tt.body), http.StatusBadRequest, tt.want)
+		})
+	}
+	if len(e.stripe.SessionParams) != 0 {
+		t.Errorf("invalid requests reached Stripe: %d sessions", len(e.stripe.SessionParams))
+	}
+	checkStatus(t, e.do("GET", "/create-checkout-session", nil), http.StatusMethodNotAllowed)
+}
+
+func TestCreateCheckoutSessionReturnURLs(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":      []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"successUrl": "https://shop.example.com/thanks?ref=ad",
+		"cancelUrl":  "https://shop.example.com/products/basic",
+	})
+	checkStatus(t, w, http.StatusOK)
+	params := e.stripe.SessionParams[0]
+	if got := stripe.StringValue(params.SuccessURL); got != "https://shop.example.com/thanks?ref=ad&session_id={CHECKOUT_SESSION_ID}" {
+		t.Errorf("success URL = %q", got)
+	}
+	if got := stripe.StringValue(params.CancelURL); got != "https://shop.example.com/products/basic" {
+		t.Errorf("cancel URL = %q", got)
+	}
+}
+
+func TestCreateCheckoutSessionCurrency(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+		"currency": "EUR",
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	if got := stripe.StringValue(e.stripe.SessionParams[0].Currency); got != "eur" {
+		t.Errorf("currency = %q, want eur", got)
+	}
+	if p := e.payment(resp.ID); p.Amount != 2800 || p.Currency != "eur" {
+		t.Errorf("stored payment = %d %s, want 2800 eur", p.Amount, p.Currency)
+	}
+
+	// Inferred from the browser's language.
+	w = e.do("POST", "/create-checkout-session", url.Values{"quantity": {"1"}}, "Accept-Language", "en-GB")
+	checkStatus(t, w, http.StatusSeeOther)
+	if got := stripe.StringValue(e.stripe.SessionParams[1].Currency); got != "gbp" {
+		t.Errorf("inferred currency = %q, want gbp", got)
+	}
+
+	// price_yen has no euro option, so the cart falls back to the default.
+	w = e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 1}, {Price: "price_yen", Quantity: 1}},
+		"currency": "eur",
+	})
+	checkStatus(t, w, http.StatusOK)
+	if c := e.stripe.SessionParams[2].Currency; c != nil {
+		t.Errorf("currency = %q, want the prices' default", *c)
+	}
+
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"currency": "euros",
+	}), http.StatusBadRequest, "invalid currency")
+}
+
+func TestCreateCheckoutSessionPaymentMethodTypes(t *testing.T) {
+	e := newTestEnv(t)
+	item := []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": item}), http.StatusOK)
+	if got := e.stripe.SessionParams[0].PaymentMethodTypes; got != nil {
+		t.Errorf("payment method types = %v, want the dashboard default", servicetest.StringValues(got))
+	}
+
+	e.svc.Config.PaymentMethodTypes = []string{"card", "ideal", "sepa_debit"}
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": item}), http.StatusOK)
+	if got := servicetest.StringValues(e.stripe.SessionParams[1].PaymentMethodTypes); strings.Join(got, ",") != "card,ideal,sepa_debit" {
+		t.Errorf("payment method types = %v, want PAYMENT_METHOD_TYPES", got)
+	}
+	checkStatus(t, e.do("POST", "/create-checkout-session", url.Values{"quantity": {"1"}, "paymentMethodTypes": {"ideal,sepa_debit"}}), http.StatusSeeOther)
+	if got := servicetest.StringValues(e.stripe.SessionParams[2].PaymentMethodTypes); strings.Join(got, ",") != "ideal,sepa_debit" {
+		t.Errorf("payment method types = %v, want the form override", got)
+	}
+
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": item, "paymentMethodTypes": []string{"alipay"}}), http.StatusBadRequest, "not enabled")
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": item, "paymentMethodTypes": []string{"cash"}}), http.StatusBadRequest, "unknown payment method type")
+}
+
+func TestCreateCheckoutSessionDiscounts(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Coupons["SPRING"] = &stripe.Coupon{ID: "SPRING", Valid: true}
+	e.stripe.Coupons["OLD"] = &stripe.Coupon{ID: "OLD", Valid: false}
+	e.stripe.PromotionCodes = []*stripe.PromotionCode{{ID: "promo_1", Code: "WELCOME", Active: true}}
+	item := []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}
+
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": item, "coupon": "SPRING"}), http.StatusOK)
+	if d := e.stripe.SessionParams[0].Discounts; len(d) != 1 || stripe.StringValue(d[0].Coupon) != "SPRING" {
+		t.Errorf("coupon discount = %+v", d)
+	}
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": item, "promotionCode": "WELCOME"}), http.StatusOK)
+	if d := e.stripe.SessionParams[1].Discounts; len(d) != 1 || stripe.StringValue(d[0].PromotionCode) != "promo_1" {
+		t.Errorf("promotion code discount = %+v", d)
+	}
+
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": item, "coupon": "OLD"}), http.StatusBadRequest, "invalid coupon")
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": item, "promotionCode": "NOPE"}), http.StatusBadRequest, "invalid promotion code")
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": item, "coupon": "SPRING", "promotionCode": "WELCOME"}), http.StatusBadRequest, "only one of")
+
+	e.svc.Config.AllowPromotionCodes = true
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": item}), http.StatusOK)
+	if p := e.stripe.SessionParams[2]; !stripe.BoolValue(p.AllowPromotionCodes) {
+		t.Error("promotion code field not enabled")
+	}
+}
+
+func TestCreateCheckoutSessionSeller(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Accounts["acct_ready"] = &stripe.Account{ID: "acct_ready", ChargesEnabled: true}
+	e.stripe.Accounts["acct_new"] = &stripe.Account{ID: "acct_new"}
+
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":  []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+		"seller": "acct_ready",
+	})
+	checkStatus(t, w, http.StatusOK)
+	pid := e.stripe.SessionParams[0].PaymentIntentData
+	if got := stripe.Int64Value(pid.ApplicationFeeAmount); got != 300 {
+		t.Errorf("application fee = %d, want 10%% of 3000", got)
+	}
+	if got := stripe.StringValue(pid.TransferData.Destination); got != "acct_ready" {
+		t.Errorf("transfer destination = %q", got)
+	}
+
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":  []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"seller": "acct_new",
+	}), http.StatusBadRequest, "onboarding is complete")
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":  []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"seller": "acct_missing",
+	}), http.StatusBadRequest, "unknown seller")
+}
+
+func TestCreateCheckoutSessionStripeError(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Err = &stripe.Error{HTTPStatusCode: http.StatusBadRequest, Msg: "Invalid currency"}
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+	})
+	checkErrorMessage(t, w, http.StatusBadRequest, "error while creating session: Invalid currency")
+}
+
+func TestAdjustableQuantity(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.AdjustableQuantity = true
+	e.svc.Config.AdjustableQuantityMin, e.svc.Config.AdjustableQuantityMax = 2, 5
+	checkout := func(quantity int64) (service.CreateCheckoutResponse, *stripe.CheckoutSessionLineItemAdjustableQuantityParams) {
+		t.Helper()
+		w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+			"items": []service.CheckoutItem{{Price: "price_basic", Quantity: quantity}},
+		})
+		checkStatus(t, w, http.StatusOK)
+		var resp service.CreateCheckoutResponse
+		decodeBody(t, w, &resp)
+		params := e.stripe.SessionParams[len(e.stripe.SessionParams)-1]
+		return resp, params.LineItems[0].AdjustableQuantity
+	}
+	complete := func(resp service.CreateCheckoutResponse, quantity int64) {
+		t.Helper()
+		// The customer settles on a different quantity on the Checkout page.
+		e.stripe.LineItems[resp.ID][0].Quantity = quantity
+		e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_%s", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+			"id": %q, "object": "checkout.session", "status": "complete", "payment_status": "paid",
+			"amount_total": %d, "currency": "usd", "metadata": {"order": %q}}}}`, resp.ID, resp.ID, 1500*quantity, resp.OrderID)))
+	}
+
+	resp, aq := checkout(1)
+	if !stripe.BoolValue(aq.Enabled) || stripe.Int64Value(aq.Minimum) != 1 || stripe.Int64Value(aq.Maximum) != 5 {
+		t.Errorf("adjustable quantity = %+v, want 1 to 5", aq)
+	}
+	complete(resp, 4)
+	if o := e.order(resp.OrderID); len(o.Items) != 1 || o.Items[0].Quantity != 4 || o.Amount != 6000 {
+		t.Errorf("order = %+v, want the 4 units bought", o)
+	}
+
+	// Tracked stock can only be lowered, and only what was bought leaves it.
+	if err := e.svc.Payments.SetStock(context.Background(), "price_basic", 10); err != nil {
+		t.Fatal(err)
+	}
+	resp, aq = checkout(3)
+	if stripe.Int64Value(aq.Minimum) != 2 || stripe.Int64Value(aq.Maximum) != 3 {
+		t.Errorf("adjustable quantity = %+v, want 2 to 3", aq)
+	}
+	complete(resp, 2)
+	if item := e.inventory("price_basic"); item.Stock != 8 || item.Reserved != 0 {
+		t.Errorf("inventory = %+v, want 2 taken out of stock", item)
+	}
+	if o := e.order(resp.OrderID); o.Items[0].Quantity != 2 {
+		t.Errorf("order quantity = %d, want 2", o.Items[0].Quantity)
+	}
+
+	e.svc.Config.AdjustableQuantity = false
+	if _, aq := checkout(1); aq != nil {
+		t.Errorf("adjustable quantity set when disabled: %+v", aq)
+	}
+}
@@ -0,0 +1,125 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// CreateAccountRequest is the body accepted by POST /connect/accounts.
+type CreateAccountRequest struct {
+	Email   string `json:"email"`
+	Country string `json:"country"`
+}
+
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+func (c *CreateAccountRequest) Validate(ctx context.Context, svc *service.Service) error {
+	if err := config.ValidateEmail(c.Email); err != nil {
+		return err
+	}
+	c.Country = strings.ToUpper(c.Country)
+	if c.Country != "" && !countryPattern.MatchString(c.Country) {
+		return fmt.Errorf("invalid country %q", c.Country)
+	}
+	return nil
+}
+
+// handleConnectAccounts serves POST /connect/accounts, which creates an
+// Express account for a new seller.
+func (srv *Server) handleConnectAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req CreateAccountRequest
+	if err := srv.decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	params := &stripe.AccountParams{
+		Type:  stripe.String(string(stripe.AccountTypeExpress)),
+		Email: stripe.String(req.Email),
+		Capabilities: &stripe.AccountCapabilitiesParams{
+			CardPayments: &stripe.AccountCapabilitiesCardPaymentsParams{Requested: stripe.Bool(true)},
+			Transfers:    &stripe.AccountCapabilitiesTransfersParams{Requested: stripe.Bool(true)},
+		},
+	}
+	if req.Country != "" {
+		params.Country = stripe.String(req.Country)
+	}
+	params.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "account"))
+	a, err := srv.svc.Stripe.NewAccount(r.Context(), params)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "creating account", Err: err})
+		return
+	}
+	rec := service.ConnectedAccountFromStripe(a)
+	// The account exists; record it even if the caller has gone away.
+	if err := srv.svc.Payments.SaveConnectedAccount(context.WithoutCancel(r.Context()), rec); err != nil {
+		writeError(w, r, service.InternalError("saving account", err))
+		return
+	}
+	writeJSON(w, rec)
+}
+
+// handleConnectAccount serves GET /connect/accounts/{id}, which refreshes the
+// account from Stripe, and POST /connect/accounts/{id}/onboarding, which
+// returns a Stripe-hosted onboarding link for the seller.
+func (srv *Server) handleConnectAccount(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/connect/accounts/")
+	if len(parts) == 0 || service.ValidateStripeID(parts[0], "acct_", "account") != nil {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	id := parts[0]
+	switch {
+	case len(parts) == 1:
+		if r.Method != "GET" {
+			writeMethodNotAllowed(w)
+			return
+		}
+		a, err := srv.svc.Stripe.GetAccount(r.Context(), id, nil)
+		if err != nil {
+			writeError(w, r, &service.StripeFailure{Action: "fetching account", Err: err})
+			return
+		}
+		rec := service.ConnectedAccountFromStripe(a)
+		if err := srv.svc.Payments.SaveConnectedAccount(r.Context(), rec); err != nil {
+			writeError(w, r, service.InternalError("saving account", err))
+			return
+		}
+		writeJSON(w, rec)
+	case len(parts) == 2 && parts[1] == "onboarding":
+		if r.Method != "POST" {
+			writeMethodNotAllowed(w)
+			return
+		}
+		domainURL := srv.svc.Config.Domain
+		params := &stripe.AccountLinkParams{
+			Account:    stripe.String(id),
+			RefreshURL: stripe.String(domainURL + "/connect/accounts/" + id + "/onboarding"),
+			ReturnURL:  stripe.String(domainURL + "/"),
+			Type:       stripe.String("account_onboarding"),
+		}
+		params.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "account_link"))
+		link, err := srv.svc.Stripe.NewAccountLink(r.Context(), params)
+		if err != nil {
+			writeError(w, r, &service.StripeFailure{Action: "creating account link", Err: err})
+			return
+		}
+		writeJSON(w, struct {
+			URL       string `json:"url"`
+			ExpiresAt int64  `json:"expiresAt"`
+		}{link.URL, link.ExpiresAt})
+	default:
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+	}
+}
@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestConnectOnboarding(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.admin("POST", "/connect/accounts", CreateAccountRequest{Email: "seller@example.com", Country: "us"})
+	checkStatus(t, w, http.StatusOK)
+	var a service.ConnectedAccount
+	decodeBody(t, w, &a)
+	if a.ID == "" || a.ChargesEnabled {
+		t.Fatalf("account = %+v", a)
+	}
+	params := e.stripe.AccountParams[0]
+	if *params.Type != "express" || *params.Country != "US" {
+		t.Errorf("type %q, country %q", *params.Type, *params.Country)
+	}
+
+	w = e.admin("POST", "/connect/accounts/"+a.ID+"/onboarding", nil)
+	checkStatus(t, w, http.StatusOK)
+	var link struct {
+		URL string `json:"url"`
+	}
+	decodeBody(t, w, &link)
+	if link.URL == "" {
+		t.Error("no onboarding URL")
+	}
+
+	// Onboarding finished on Stripe's side.
+	e.stripe.Accounts[a.ID].ChargesEnabled = true
+	checkStatus(t, e.admin("GET", "/connect/accounts/"+a.ID, nil), http.StatusOK)
+	stored, err := e.svc.Payments.GetConnectedAccount(context.Background(), a.ID)
+	if err != nil || !stored.ChargesEnabled {
+		t.Errorf("stored account = %+v, %v", stored, err)
+	}
+}
+
+func TestConnectValidation(t *testing.T) {
+	e := newTestEnv(t)
+	checkErrorMessage(t, e.admin("POST", "/connect/accounts", CreateAccountRequest{Email: "nope"}), http.StatusBadRequest, "invalid email")
+	checkErrorMessage(t, e.admin("POST", "/connect/accounts", CreateAccountRequest{Email: "a@example.com", Country: "USA"}), http.StatusBadRequest, "invalid country")
+	checkStatus(t, e.admin("GET", "/connect/accounts/cus_1", nil), http.StatusNotFound)
+	checkStatus(t, e.admin("GET", "/connect/accounts/acct_1/other", nil), http.StatusNotFound)
+	checkStatus(t, e.admin("GET", "/connect/accounts/acct_1/onboarding", nil), http.StatusMethodNotAllowed)
+	checkErrorMessage(t, e.admin("GET", "/connect/accounts/acct_missing", nil), http.StatusNotFound, "No such account")
+}
+
+func TestApplicationFee(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.ApplicationFeePercent = 2.5
+	items := []*stripe.CheckoutSessionLineItemParams{
+		{Price: stripe.String("price_basic"), Quantity: stripe.Int64(3)},
+	}
+	if got := e.svc.ApplicationFee(context.Background(), items, ""); got != 113 {
+		t.Errorf("fee = %d, want 2.5%% of 4500 rounded to 113", got)
+	}
+	if got := e.svc.ApplicationFee(context.Background(), items, "eur"); got != 105 {
+		t.Errorf("fee = %d, want 2.5%% of €42 rounded to 105", got)
+	}
+}
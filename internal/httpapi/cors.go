@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"stripe_go/internal/service"
+)
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowedHeaders = "Content-Type, Authorization, X-Request-ID, Idempotency-Key"
+	corsExposedHeaders = "X-Request-ID, Retry-After, Idempotent-Replayed"
+)
+
+// withCORS lets browsers on CORS_ALLOWED_ORIGINS call the API. Preflight
+// requests are answered here, before rate limiting, and never reach the
+// handlers. Requests from other origins get no CORS headers, so browsers
+// keep blocking them.
+func (srv *Server) withCORS(next http.Handler) http.Handler {
+	if len(srv.svc.Config.CORSAllowedOrigins) == 0 {
+		return next
+	}
+	anyOrigin := slices.Contains(srv.svc.Config.CORSAllowedOrigins, "*")
+	maxAge := strconv.Itoa(int(srv.svc.Config.CORSMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		allowed := anyOrigin || slices.Contains(srv.svc.Config.CORSAllowedOrigins, strings.ToLower(origin))
+		if !allowed {
+			if preflight {
+				writeError(w, r, &service.Error{Status: http.StatusForbidden, Message: "origin not allowed"})
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if anyOrigin && !srv.svc.Config.CORSAllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if srv.svc.Config.CORSAllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+		h.Set("Access-Control-Max-Age", maxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
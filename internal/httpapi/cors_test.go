@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithCORS(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.CORSAllowedOrigins = []string{"https://app.example.com"}
+	e.svc.Config.CORSAllowCredentials = true
+	e.svc.Config.CORSMaxAge = 10 * time.Minute
+	h := e.srv.withCORS(e.handler)
+
+	send := func(method, origin string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/products", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := send("OPTIONS", "https://app.example.com", "Access-Control-Request-Method", "POST", "Access-Control-Request-Headers", "content-type")
+	checkStatus(t, w, http.StatusNoContent)
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     corsAllowedMethods,
+		"Access-Control-Allow-Headers":     corsAllowedHeaders,
+		"Access-Control-Max-Age":           "600",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("preflight %s = %q, want %q", name, got, want)
+		}
+	}
+
+	w = send("GET", "https://app.example.com")
+	checkStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Vary = %q", got)
+	}
+
+	checkErrorMessage(t, send("OPTIONS", "https://evil.example.com", "Access-Control-Request-Method", "POST"), http.StatusForbidden, "origin not allowed")
+	w = send("GET", "https://evil.example.com")
+	checkStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed origin got Access-Control-Allow-Origin %q", got)
+	}
+
+	// Same-origin requests and plain OPTIONS aren't CORS.
+	if w := send("GET", ""); w.Header().Get("Vary") != "" {
+		t.Error("same-origin request varied on Origin")
+	}
+	checkStatus(t, send("OPTIONS", "https://app.example.com"), http.StatusMethodNotAllowed)
+}
+
+func TestWithCORSAnyOrigin(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.CORSAllowedOrigins = []string{"*"}
+	h := e.srv.withCORS(e.handler)
+	r := httptest.NewRequest("GET", "/products", nil)
+	r.Header.Set("Origin", "https://anywhere.example")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("credentials allowed for any origin: %q", got)
+	}
+}
+
+func TestWithCORSDisabled(t *testing.T) {
+	e := newTestEnv(t)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest("OPTIONS", "/config", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	e.srv.withCORS(next).ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("CORS headers sent without CORS_ALLOWED_ORIGINS: %q", got)
+	}
+}
@@ -0,0 +1,136 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"stripe_go/internal/service"
+)
+
+func (srv *Server) parseCustomerRequest(w http.ResponseWriter, r *http.Request) (*service.CustomerRequest, error) {
+	var req service.CustomerRequest
+	if isJSONRequest(r) {
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			return nil, err
+		}
+		return &req, nil
+	}
+	r.ParseForm()
+	req.Email = r.PostFormValue("email")
+	req.Name = r.PostFormValue("name")
+	return &req, req.Validate(r.Context(), srv.svc)
+}
+
+// handleCustomers serves /customers (list, create).
+func (srv *Server) handleCustomers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		list, err := srv.svc.ListCustomers(r.Context(), r.URL.Query().Get("email"))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, list)
+	case "POST":
+		req, err := srv.parseCustomerRequest(w, r)
+		if err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+		c, err := srv.svc.CreateCustomer(r.Context(), req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, c)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+// handleCustomer serves /customers/{id}, /customers/{id}/sessions and
+// /customers/{id}/payment-methods.
+func (srv *Server) handleCustomer(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/customers/")
+	if len(parts) == 0 || service.ValidateStripeID(parts[0], "cus_", "customer") != nil {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	id := parts[0]
+	if len(parts) == 2 && parts[1] == "sessions" {
+		srv.handleCustomerSessions(w, r, id)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "payment-methods" {
+		srv.handleCustomerPaymentMethods(w, r, id)
+		return
+	}
+	if len(parts) != 1 {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		c, err := srv.svc.GetCustomer(r.Context(), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, c)
+	case "POST", "PUT":
+		req, err := srv.parseCustomerRequest(w, r)
+		if err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+		c, err := srv.svc.UpdateCustomer(r.Context(), id, req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, c)
+	case "DELETE":
+		if err := srv.confirmRequest(r, "customer.delete", id, 0); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		c, err := srv.svc.DeleteCustomer(r.Context(), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, c)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+// handleCustomerSessions lists the checkout sessions created for a customer,
+// most recent first.
+func (srv *Server) handleCustomerSessions(w http.ResponseWriter, r *http.Request, customerID string) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	list, err := srv.svc.CustomerSessions(r.Context(), customerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, list)
+}
+
+// handleCustomerPaymentMethods lists the payment methods saved to a
+// customer, of the type given by ?type= (card by default).
+func (srv *Server) handleCustomerPaymentMethods(w http.ResponseWriter, r *http.Request, customerID string) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	list, err := srv.svc.CustomerPaymentMethods(r.Context(), customerID, r.URL.Query().Get("type"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, list)
+}
@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestCustomersCRUD(t *testing.T) {
+	e := newTestEnv(t)
+
+	w := e.admin("POST", "/customers", service.CustomerRequest{Email: "jenny@example.com", Name: "Jenny", Metadata: map[string]string{"user_id": "7"}})
+	checkStatus(t, w, http.StatusOK)
+	var c stripe.Customer
+	decodeBody(t, w, &c)
+	if c.ID == "" || c.Email != "jenny@example.com" || c.Metadata["user_id"] != "7" {
+		t.Fatalf("created customer = %+v", c)
+	}
+
+	w = e.admin("GET", "/customers?email=jenny@example.com", nil)
+	checkStatus(t, w, http.StatusOK)
+	var list []*stripe.Customer
+	decodeBody(t, w, &list)
+	if len(list) != 1 || list[0].ID != c.ID {
+		t.Errorf("list = %+v, want just %s", list, c.ID)
+	}
+
+	w = e.admin("POST", "/customers/"+c.ID, url.Values{"name": {"Jenny R"}})
+	checkStatus(t, w, http.StatusOK)
+	if got := e.stripe.Customers[c.ID].Name; got != "Jenny R" {
+		t.Errorf("name after update = %q", got)
+	}
+
+	checkStatus(t, e.admin("GET", "/customers/"+c.ID, nil), http.StatusOK)
+	checkStatus(t, e.admin("DELETE", "/customers/"+c.ID, nil), http.StatusOK)
+	checkErrorMessage(t, e.admin("GET", "/customers/"+c.ID, nil), http.StatusNotFound, "No such customer")
+}
+
+func TestCustomersValidation(t *testing.T) {
+	e := newTestEnv(t)
+	checkErrorMessage(t, e.admin("POST", "/customers", service.CustomerRequest{Name: "No Email"}), http.StatusBadRequest, "email is required")
+	checkErrorMessage(t, e.admin("POST", "/customers", service.CustomerRequest{Email: "not an email"}), http.StatusBadRequest, "invalid email")
+	checkErrorMessage(t, e.admin("GET", "/customers?email=nope", nil), http.StatusBadRequest, "invalid email")
+	checkStatus(t, e.admin("GET", "/customers/bob", nil), http.StatusNotFound)
+	checkStatus(t, e.admin("GET", "/customers/cus_1/other", nil), http.StatusNotFound)
+	checkStatus(t, e.admin("PATCH", "/customers/cus_1", nil), http.StatusMethodNotAllowed)
+}
+
+func TestCustomerSessions(t *testing.T) {
+	e := newTestEnv(t)
+	for _, customer := range []string{"cus_a", "cus_a", "cus_b"} {
+		w := e.admin("POST", "/create-checkout-session", map[string]interface{}{
+			"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+			"customer": customer,
+		})
+		checkStatus(t, w, http.StatusOK)
+	}
+	w := e.admin("GET", "/customers/cus_a/sessions", nil)
+	checkStatus(t, w, http.StatusOK)
+	var list []*stripe.CheckoutSession
+	decodeBody(t, w, &list)
+	if len(list) != 2 {
+		t.Errorf("got %d sessions for cus_a, want 2", len(list))
+	}
+}
+
+func TestCustomerPaymentMethods(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.PaymentMethods = []*stripe.PaymentMethod{
+		{ID: "pm_card_a", Type: "card", Customer: &stripe.Customer{ID: "cus_a"}},
+		{ID: "pm_sepa_a", Type: "sepa_debit", Customer: &stripe.Customer{ID: "cus_a"}},
+		{ID: "pm_card_b", Type: "card", Customer: &stripe.Customer{ID: "cus_b"}},
+	}
+	w := e.admin("GET", "/customers/cus_a/payment-methods", nil)
+	checkStatus(t, w, http.StatusOK)
+	var list []*stripe.PaymentMethod
+	decodeBody(t, w, &list)
+	if len(list) != 1 || list[0].ID != "pm_card_a" {
+		t.Errorf("got %v, want only pm_card_a", list)
+	}
+
+	w = e.admin("GET", "/customers/cus_a/payment-methods?type=sepa_debit", nil)
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &list)
+	if len(list) != 1 || list[0].ID != "pm_sepa_a" {
+		t.Errorf("got %v, want only pm_sepa_a", list)
+	}
+	checkErrorMessage(t, e.admin("GET", "/customers/cus_a/payment-methods?type=cash", nil), http.StatusBadRequest, "unknown payment method type")
+	checkStatus(t, e.admin("POST", "/customers/cus_a/payment-methods", nil), http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleReplayEvent runs a raw Stripe event through the webhook handlers
+// without checking its signature, so handlers such as
+// checkout.session.completed can be re-tested locally without the Stripe
+// CLI. Replays skip duplicate detection and don't mark the event as
+// processed. The endpoint 404s unless DEV_REPLAY_ENABLED is true, and needs
+// the admin role.
+func (srv *Server) handleReplayEvent(w http.ResponseWriter, r *http.Request) {
+	if !srv.svc.Config.DevReplayEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	srv.requireAuth(srv.replayEvent)(w, r)
+}
+
+func (srv *Server) replayEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: "error reading event: " + err.Error()})
+		return
+	}
+	var event stripe.Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: "error parsing event: " + err.Error()})
+		return
+	}
+	if event.Type == "" || event.Data == nil {
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: "event needs a type and data.object"})
+		return
+	}
+
+	logFor(r).Warn("replaying unsigned webhook event", "event", event.ID, "type", event.Type)
+	if err := srv.svc.WebhookRouter.Dispatch(r.Context(), event); err != nil {
+		// Unlike other internal errors the cause is shown: this is a
+		// test mode tool for debugging the handlers.
+		writeError(w, r, &service.Error{Status: http.StatusInternalServerError, Message: err.Error()})
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"replayed": event.Type,
+	})
+}
+
+// handleAdminEvent serves POST /admin/events/{id}/replay, which fetches an
+// event from Stripe and runs it through the webhook handlers again, for
+// events whose handling went wrong after they were acknowledged. Unlike
+// /dev/replay-event the event comes from Stripe, so it is trusted; like
+// it, the replay skips duplicate detection. In live mode it needs
+// confirming.
+func (srv *Server) handleAdminEvent(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/admin/events/")
+	if len(parts) != 2 || parts[1] != "replay" {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if err := srv.confirmRequest(r, "event.replay", parts[0], 0); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	event, err := srv.svc.Stripe.GetEvent(r.Context(), parts[0])
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "fetching event", Err: err})
+		return
+	}
+	logFor(r).Warn("replaying webhook event", "event", event.ID, "type", event.Type)
+	if err := srv.svc.WebhookRouter.Dispatch(r.Context(), *event); err != nil {
+		writeError(w, r, service.InternalError("handling "+event.Type, err))
+		return
+	}
+	srv.svc.RecordAudit(r.Context(), service.AuditActor(r.Context()), "event.replayed", event.ID, nil, map[string]string{"type": event.Type})
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"replayed": event.Type,
+	})
+}
@@ -0,0 +1,56 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func TestReplayEventDisabled(t *testing.T) {
+	e := newTestEnv(t)
+	payload, err := os.ReadFile("testdata/webhooks/checkout_session_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, e.do("POST", "/dev/replay-event", payload), http.StatusNotFound)
+}
+
+func TestReplayEvent(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.DevReplayEnabled = true
+	payload, err := os.ReadFile("testdata/webhooks/checkout_session_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkStatus(t, e.do("POST", "/dev/replay-event", payload), http.StatusUnauthorized)
+
+	// Unsigned, and not deduplicated, so the same event can be replayed.
+	for i := 0; i < 2; i++ {
+		w := e.admin("POST", "/dev/replay-event", payload)
+		checkStatus(t, w, http.StatusOK)
+		var resp map[string]interface{}
+		decodeBody(t, w, &resp)
+		if resp["replayed"] != "checkout.session.completed" {
+			t.Errorf("response = %v", resp)
+		}
+	}
+	e.runJobs()
+	if p := e.payment("cs_test_completed"); p.Status != "paid" {
+		t.Errorf("payment status = %q, want paid", p.Status)
+	}
+	if len(e.emails.Sent) != 2 {
+		t.Errorf("sent %d emails, want one per replay", len(e.emails.Sent))
+	}
+
+	checkErrorMessage(t, e.admin("POST", "/dev/replay-event", []byte(`{"id": `)), http.StatusBadRequest, "error parsing event")
+	checkErrorMessage(t, e.admin("POST", "/dev/replay-event", []byte(`{"id": "evt_1"}`)), http.StatusBadRequest, "needs a type")
+	checkStatus(t, e.admin("GET", "/dev/replay-event", nil), http.StatusMethodNotAllowed)
+
+	e.svc.WebhookRouter.On("test.broken", func(context.Context, stripe.Event) error { return errors.New("boom") })
+	checkErrorMessage(t, e.admin("POST", "/dev/replay-event", []byte(`{"id": "evt_2", "type": "test.broken", "data": {"object": {}}}`)), http.StatusInternalServerError, "boom")
+}
@@ -0,0 +1,106 @@
+package httpapi
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"stripe_go/internal/service"
+)
+
+// maxEvidenceUpload caps an evidence file upload; Stripe accepts at most
+// 5 MB of evidence files per dispute.
+const maxEvidenceUpload = 5 << 20
+
+// handleAdminDisputes serves GET /admin/disputes, soonest evidence due date
+// first. It takes status, limit and offset.
+func (srv *Server) handleAdminDisputes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	q := r.URL.Query()
+	f := service.DisputeFilter{Status: q.Get("status"), Limit: service.DefaultPageSize}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > service.MaxPageSize {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", service.MaxPageSize)})
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid offset %q", v)})
+			return
+		}
+	}
+	list, hasMore, err := srv.svc.ListDisputes(r.Context(), f)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, struct {
+		Disputes []*service.Dispute `json:"disputes"`
+		Limit    int                `json:"limit"`
+		Offset   int                `json:"offset"`
+		HasMore  bool               `json:"hasMore"`
+	}{list, f.Limit, f.Offset, hasMore})
+}
+
+// handleAdminDispute serves GET /admin/disputes/{id},
+// POST /admin/disputes/{id}/evidence, which attaches one piece of evidence,
+// and POST /admin/disputes/{id}/submit, which sends everything attached to
+// Stripe.
+func (srv *Server) handleAdminDispute(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/admin/disputes/")
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	if len(parts) == 0 || len(parts) > 2 || (len(parts) == 2 && action != "evidence" && action != "submit") {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if (action == "" && r.Method != "GET") || (action != "" && r.Method != "POST") {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var d *service.Dispute
+	var err error
+	switch action {
+	case "evidence":
+		d, err = srv.attachEvidence(w, r, parts[0])
+	case "submit":
+		d, err = srv.svc.SubmitEvidence(r.Context(), parts[0])
+	default:
+		d, err = srv.svc.GetDispute(r.Context(), parts[0])
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, d)
+}
+
+// attachEvidence adds the evidence in r to dispute id: text as an
+// EvidenceRequest, files as multipart/form-data with kind and file fields.
+func (srv *Server) attachEvidence(w http.ResponseWriter, r *http.Request, id string) (*service.Dispute, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		var req service.EvidenceRequest
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			return nil, service.BadRequest(err)
+		}
+		return srv.svc.AttachEvidence(r.Context(), id, &req)
+	}
+	if err := r.ParseMultipartForm(maxEvidenceUpload); err != nil {
+		return nil, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("error parsing upload %v", err.Error())}
+	}
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, &service.Error{Status: http.StatusBadRequest, Message: "file is required"}
+	}
+	defer f.Close()
+	return srv.svc.UploadEvidence(r.Context(), id, r.FormValue("kind"), header.Filename, f)
+}
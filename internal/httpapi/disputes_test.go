@@ -0,0 +1,130 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+)
+
+// openDispute delivers the charge_dispute_created fixture and makes
+// dp_test_seed known to the fake.
+func (e *testEnv) openDispute() {
+	e.t.Helper()
+	e.seedPayment()
+	payload, err := os.ReadFile("testdata/webhooks/charge_dispute_created.json")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if status, body := e.deliver(payload); status != http.StatusOK {
+		e.t.Fatalf("dispute event: %d %s", status, body)
+	}
+	e.stripe.Disputes["dp_test_seed"] = &stripe.Dispute{
+		ID: "dp_test_seed", Amount: 3000, Currency: "usd", Status: stripe.DisputeStatusNeedsResponse,
+		Charge: &stripe.Charge{ID: "ch_test_seed"}, PaymentIntent: &stripe.PaymentIntent{ID: "pi_test_seed"},
+	}
+}
+
+func (e *testEnv) uploadEvidence(id, kind, filename string, content []byte) *http.Response {
+	e.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("kind", kind)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	fw.Write(content)
+	mw.Close()
+	return e.do("POST", "/admin/disputes/"+id+"/evidence", body.Bytes(),
+		"Content-Type", mw.FormDataContentType(), "Authorization", "Bearer "+servicetest.AdminToken).Result()
+}
+
+func TestDisputeEvidence(t *testing.T) {
+	e := newTestEnv(t)
+	e.openDispute()
+
+	var list struct {
+		Disputes []*service.Dispute `json:"disputes"`
+	}
+	w := e.admin("GET", "/admin/disputes?status=needs_response", nil)
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &list)
+	if len(list.Disputes) != 1 || list.Disputes[0].EvidenceDueBy == nil {
+		t.Fatalf("disputes = %+v", list.Disputes)
+	}
+
+	checkStatus(t, e.admin("POST", "/admin/disputes/dp_test_seed/evidence",
+		service.EvidenceRequest{Kind: "product_description", Text: "A basic plan"}), http.StatusOK)
+	checkErrorMessage(t, e.admin("POST", "/admin/disputes/dp_test_seed/evidence",
+		service.EvidenceRequest{Kind: "receipt", Text: "file_1"}), http.StatusBadRequest, "multipart")
+	checkErrorMessage(t, e.admin("POST", "/admin/disputes/dp_test_seed/evidence",
+		service.EvidenceRequest{Kind: "signature", Text: "x"}), http.StatusBadRequest, "unknown evidence kind")
+	if resp := e.uploadEvidence("dp_test_seed", "customer_name", "name.txt", []byte("x")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("text kind upload: status %d", resp.StatusCode)
+	}
+	if resp := e.uploadEvidence("dp_test_seed", "receipt", "receipt.pdf", []byte("%PDF-1.4")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("receipt upload: status %d", resp.StatusCode)
+	}
+
+	d, err := e.svc.Payments.GetDispute(context.Background(), "dp_test_seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileID := d.Evidence["receipt"]
+	if string(e.stripe.Files[fileID]) != "%PDF-1.4" || d.Evidence["product_description"] != "A basic plan" {
+		t.Fatalf("evidence = %v", d.Evidence)
+	}
+
+	w = e.admin("POST", "/admin/disputes/dp_test_seed/submit", nil)
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &d)
+	if d.Status != "under_review" || d.SubmittedAt == nil {
+		t.Errorf("submitted dispute = %+v", d)
+	}
+	params := e.stripe.DisputeParams[0]
+	if !*params.Submit || stripe.StringValue(params.Evidence.Receipt) != fileID ||
+		stripe.StringValue(params.Evidence.ProductDescription) != "A basic plan" {
+		t.Errorf("submitted evidence = %+v", params.Evidence)
+	}
+	if got := e.audit("?action=dispute.submitted"); len(got) != 1 || got[0].Object != "dp_test_seed" {
+		t.Errorf("audit entries = %+v", got)
+	}
+	checkErrorMessage(t, e.admin("POST", "/admin/disputes/dp_test_seed/submit", nil), http.StatusConflict, "under_review")
+}
+
+func TestDisputeRequests(t *testing.T) {
+	e := newTestEnv(t)
+	e.openDispute()
+	checkErrorMessage(t, e.admin("POST", "/admin/disputes/dp_test_seed/submit", nil), http.StatusBadRequest, "no evidence")
+	checkStatus(t, e.admin("GET", "/admin/disputes/dp_test_missing", nil), http.StatusNotFound)
+	checkStatus(t, e.admin("GET", "/admin/disputes/dp_test_seed/notes", nil), http.StatusNotFound)
+	checkStatus(t, e.admin("POST", "/admin/disputes/dp_test_seed", nil), http.StatusMethodNotAllowed)
+	checkStatus(t, e.admin("GET", "/admin/disputes/dp_test_seed/submit", nil), http.StatusMethodNotAllowed)
+	checkStatus(t, e.admin("GET", "/admin/disputes?limit=0", nil), http.StatusBadRequest)
+}
+
+func TestDisputeWonRestoresPayment(t *testing.T) {
+	e := newTestEnv(t)
+	e.openDispute()
+	if p := e.payment("cs_test_seed"); p.Status != "disputed" {
+		t.Fatalf("status after dispute = %s", p.Status)
+	}
+	payload, err := os.ReadFile("testdata/webhooks/charge_dispute_closed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := e.deliver(payload); status != http.StatusOK {
+		t.Fatalf("closed event: %d %s", status, body)
+	}
+	if p := e.payment("cs_test_seed"); p.Status != "paid" {
+		t.Errorf("status after won dispute = %s, want paid", p.Status)
+	}
+}
@@ -0,0 +1,119 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleCreateDonationSession starts a "pay what you want" checkout. The
+// amount is the donor's, so the line item is built with price_data rather
+// than a Price from the catalog, and the session's submit button reads
+// "Donate".
+func (srv *Server) handleCreateDonationSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req service.DonationRequest
+	if isJSONRequest(r) {
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	} else {
+		r.ParseForm()
+		amount, err := strconv.ParseInt(r.PostFormValue("amount"), 10, 64)
+		if err != nil {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("error parsing amount %v", err.Error())})
+			return
+		}
+		req = service.DonationRequest{
+			Amount:        amount,
+			Customer:      r.PostFormValue("customer"),
+			CustomerToken: r.PostFormValue("customerToken"),
+			Metadata:      formMetadata(r),
+			SuccessURL:    r.PostFormValue("successUrl"),
+			CancelURL:     r.PostFormValue("cancelUrl"),
+			Locale:        r.PostFormValue("locale"),
+		}
+		if err := req.Validate(r.Context(), srv.svc); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	}
+	successURL, cancelURL, err := srv.svc.CheckoutReturnURLs(req.SuccessURL, req.CancelURL)
+	if err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	if req.Customer != "" {
+		if err := srv.authorizeCustomer(r, req.Customer, req.CustomerToken, "create sessions for a customer"); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		SuccessURL: stripe.String(successURL),
+		CancelURL:  stripe.String(cancelURL),
+		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
+		SubmitType: stripe.String(string(stripe.CheckoutSessionSubmitTypeDonate)),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Quantity: stripe.Int64(1),
+				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+					Currency:   stripe.String(srv.svc.Config.DonationCurrency),
+					UnitAmount: stripe.Int64(req.Amount),
+					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+						Name: stripe.String(srv.svc.Config.DonationName),
+					},
+				},
+			},
+		},
+	}
+	if req.Customer != "" {
+		params.Customer = stripe.String(req.Customer)
+	}
+	metadata := map[string]string{service.DonationMetadataKey: "true"}
+	for k, v := range req.Metadata {
+		metadata[k] = v
+	}
+	for k, v := range metadata {
+		params.AddMetadata(k, v)
+	}
+	params.PaymentIntentData = &stripe.CheckoutSessionPaymentIntentDataParams{Metadata: metadata}
+	service.WithCheckoutLocale(params, service.CheckoutLocale(r.Header.Get("Accept-Language"), req.Locale))
+	params.ExpiresAt = stripe.Int64(time.Now().Add(srv.svc.Config.CheckoutSessionTTL).Unix())
+	srv.svc.WithCheckoutRecovery(params)
+	srv.svc.WithSessionSummary(params)
+	service.WithTraceContext(r.Context(), params)
+	params.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "donation_session"))
+	s, err := srv.svc.Stripe.NewCheckoutSession(r.Context(), params)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "creating session", Err: err})
+		return
+	}
+	// The session exists; record it even if the client has gone away.
+	ctx := context.WithoutCancel(r.Context())
+	if err := srv.svc.UpdatePaymentStatus(ctx, s); err != nil {
+		logFor(r).Error("updating payment status", "session", s.ID, "error", err)
+	}
+	srv.svc.RecordAudit(ctx, service.AuditActor(ctx), "checkout.created", s.ID, nil, map[string]interface{}{
+		"mode":     "donation",
+		"amount":   req.Amount,
+		"currency": srv.svc.Config.DonationCurrency,
+	})
+
+	if isJSONRequest(r) {
+		writeJSON(w, &service.CreateCheckoutResponse{ID: s.ID, URL: s.URL, ExpiresAt: service.SessionExpiry(s)})
+		return
+	}
+	http.Redirect(w, r, s.URL, http.StatusSeeOther)
+}
@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestCreateDonationSession(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-donation-session", map[string]interface{}{
+		"amount":   2500,
+		"metadata": map[string]string{"campaign": "spring"},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	if resp.ID == "" || resp.OrderID != "" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	p := e.stripe.SessionParams[0]
+	if stripe.StringValue(p.SubmitType) != "donate" || len(p.LineItems) != 1 || p.LineItems[0].Price != nil {
+		t.Fatalf("params = %+v", p)
+	}
+	data := p.LineItems[0].PriceData
+	if stripe.Int64Value(data.UnitAmount) != 2500 || stripe.StringValue(data.Currency) != "usd" || stripe.StringValue(data.ProductData.Name) != "Donation" {
+		t.Errorf("price data = %+v", data)
+	}
+	if p.Metadata[service.DonationMetadataKey] != "true" || p.PaymentIntentData.Metadata[service.DonationMetadataKey] != "true" || p.Metadata["campaign"] != "spring" {
+		t.Errorf("metadata = %v / %v", p.Metadata, p.PaymentIntentData.Metadata)
+	}
+	if pay := e.payment(resp.ID); pay.Amount != 2500 || pay.Metadata[service.DonationMetadataKey] != "true" {
+		t.Errorf("payment = %+v", pay)
+	}
+}
+
+func TestCreateDonationSessionForm(t *testing.T) {
+	e := newTestEnv(t)
+	checkStatus(t, e.do("POST", "/create-donation-session", url.Values{"amount": {"1000"}}), http.StatusSeeOther)
+	if got := stripe.Int64Value(e.stripe.SessionParams[0].LineItems[0].PriceData.UnitAmount); got != 1000 {
+		t.Errorf("amount = %d", got)
+	}
+}
+
+func TestCreateDonationSessionValidation(t *testing.T) {
+	e := newTestEnv(t)
+	for _, tt := range []struct {
+		body interface{}
+		want string
+	}{
+		{map[string]interface{}{"amount": 99}, "between 1.00 USD and 1000.00 USD"},
+		{map[string]interface{}{"amount": 100001}, "between"},
+		{map[string]interface{}{"amount": 500, "metadata": map[string]string{"donation": "false"}}, `"donation" is reserved`},
+		{map[string]interface{}{"amount": 500, "customer": "nope"}, "customer"},
+	} {
+		checkErrorMessage(t, e.do("POST", "/create-donation-session", tt.body), http.StatusBadRequest, tt.want)
+	}
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"metadata": map[string]string{"donation": "true"},
+	}), http.StatusBadRequest, "reserved")
+	if len(e.stripe.SessionParams) != 0 {
+		t.Errorf("created %d sessions for invalid requests", len(e.stripe.SessionParams))
+	}
+}
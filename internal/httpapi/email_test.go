@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+func TestSendConfirmationEmailWithoutAddress(t *testing.T) {
+	e := newTestEnv(t)
+	if err := e.svc.SendConfirmationEmail(context.Background(), &service.Receipt{Amount: 100, Currency: "usd"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.emails.Sent) != 0 {
+		t.Error("sent an email without a recipient")
+	}
+}
+
+func TestReceiptsSetting(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		receipts     string
+		email        string
+		stripeEmail  string
+		customEmails int
+		routes       string
+	}{
+		{"custom", config.ReceiptsCustom, "jenny@example.com", "", 1, ""},
+		{"stripe", config.ReceiptsStripe, "jenny@example.com", "jenny@example.com", 0, ""},
+		{"both", config.ReceiptsBoth, "jenny@example.com", "jenny@example.com", 1, ""},
+		// Without an address Stripe may not email a receipt, so we do.
+		{"stripe without an email", config.ReceiptsStripe, "", "", 1, ""},
+		// Stripe's receipt doesn't carry license keys.
+		{"stripe with licenses", config.ReceiptsStripe, "jenny@example.com", "jenny@example.com", 1, "price_basic=license"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			e.svc.Config.Receipts = tt.receipts
+			if tt.routes != "" {
+				e.fulfillmentRoutes(tt.routes)
+			}
+			w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+				"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+				"email": tt.email,
+			})
+			checkStatus(t, w, http.StatusOK)
+			var resp service.CreateCheckoutResponse
+			decodeBody(t, w, &resp)
+			if got := stripe.StringValue(e.stripe.SessionParams[0].PaymentIntentData.ReceiptEmail); got != tt.stripeEmail {
+				t.Errorf("receipt_email = %q, want %q", got, tt.stripeEmail)
+			}
+
+			metadata, err := json.Marshal(e.stripe.Sessions[resp.ID].Metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_receipts", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+				"id": %q, "object": "checkout.session", "payment_status": "paid", "amount_total": 1500, "currency": "usd",
+				"customer_details": {"email": "jenny@example.com"}, "metadata": %s}}}`, resp.ID, metadata)))
+			if len(e.emails.Sent) != tt.customEmails {
+				t.Errorf("sent %d confirmation emails, want %d", len(e.emails.Sent), tt.customEmails)
+			}
+		})
+	}
+}
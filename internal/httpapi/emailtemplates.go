@@ -0,0 +1,103 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"stripe_go/internal/service"
+)
+
+// previewReceipt is the sample data /dev/email-preview renders.
+var previewReceipt = service.Receipt{
+	Email:           "jenny.rosen@example.com",
+	PaymentIntentID: "pi_preview",
+	PaymentStatus:   "paid",
+	Amount:          123450,
+	Currency:        "eur",
+	Items: []service.ReceiptItem{
+		{Name: "Stubborn Attachments", Quantity: 2, Amount: 4000},
+		{Name: "Pasha photo", Quantity: 1, Amount: 119450},
+	},
+	Metadata:    map[string]string{service.OrderMetadataKey: "ord_preview"},
+	DownloadURL: "http://localhost:4242/receipts/cs_preview",
+	StatusURL:   "http://localhost:4242/orders/ord_preview/status?token=preview",
+	Licenses: []service.ReceiptLicense{
+		{Name: "Stubborn Attachments", Quantity: 2, Key: "QJ7ZK2WA-M4XHT6RB-C5PNE3DV-Y7FLG2SU"},
+	},
+	TaxIDs: []string{"DE123456789"},
+}
+
+// previewAuthentication is the sample authentication_required email.
+var previewAuthentication = service.AuthenticationRequest{
+	Email:       "jenny.rosen@example.com",
+	Amount:      4200,
+	Currency:    "eur",
+	Description: "Usage for March",
+	URL:         "http://localhost:4242/authenticate.html#payment_intent=pi_preview",
+	ExpiresAt:   time.Date(2024, 4, 3, 12, 0, 0, 0, time.UTC),
+}
+
+// previewRecovery is the sample checkout_recovery email.
+var previewRecovery = service.RecoveryEmail{
+	Email:     "jenny.rosen@example.com",
+	SessionID: "cs_preview",
+	Amount:    4200,
+	Currency:  "eur",
+	URL:       "https://example.com/recover/abn_preview",
+	Discount:  true,
+	ExpiresAt: time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC),
+}
+
+// handleEmailPreview serves
+// GET /dev/email-preview?template=receipt&locale=de[&format=text] so the
+// templates (receipt, authentication_required, checkout_recovery) can be checked in a browser. The endpoint 404s unless
+// EMAIL_PREVIEW_ENABLED is true.
+func (srv *Server) handleEmailPreview(w http.ResponseWriter, r *http.Request) {
+	if !srv.svc.Config.EmailPreviewEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	q := r.URL.Query()
+	name := q.Get("template")
+	if name == "" {
+		name = "receipt"
+	}
+	locale := q.Get("locale")
+	var data interface{}
+	switch name {
+	case "receipt":
+		receipt := previewReceipt
+		receipt.Locale = locale
+		data = &receipt
+	case "authentication_required":
+		a := previewAuthentication
+		a.Locale = locale
+		data = &a
+	case "checkout_recovery":
+		e := previewRecovery
+		e.Locale = locale
+		data = &e
+	default:
+		writeError(w, r, &service.Error{Status: http.StatusNotFound, Message: fmt.Sprintf("unknown template %q", name)})
+		return
+	}
+	msg, err := srv.svc.EmailTemplates.Render(name, locale, data)
+	if err != nil {
+		writeError(w, r, service.InternalError("rendering template", err))
+		return
+	}
+	w.Header().Set("X-Email-Subject", msg.Subject)
+	w.Header().Set("Content-Language", srv.svc.EmailTemplates.Locale(locale))
+	if q.Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(msg.Text))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(msg.HTML))
+}
@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"stripe_go/internal/service"
+)
+
+func TestEmailTemplateLocale(t *testing.T) {
+	e := newTestEnv(t)
+	if got := e.svc.EmailTemplates.Locales(); len(got) != 3 || got[0] != service.DefaultEmailLocale {
+		t.Errorf("Locales() = %v, want en first", got)
+	}
+	for locale, want := range map[string]string{
+		"":      "en",
+		"auto":  "en",
+		"de":    "de",
+		"de-AT": "de",
+		"fr-CA": "fr",
+		"ja":    "en",
+		"xx-?":  "en",
+	} {
+		if got := e.svc.EmailTemplates.Locale(locale); got != want {
+			t.Errorf("locale(%q) = %q, want %q", locale, got, want)
+		}
+	}
+}
+
+func TestConfirmationEmailLocalized(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_de_completed", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": "pi_test_de", "payment_status": "paid", "locale": "de",
+		"amount_total": 3000, "currency": "usd", "customer_details": {"email": "jenny@example.com"}, "metadata": {"order": %q}}}}`,
+		resp.ID, resp.OrderID)))
+
+	if len(e.emails.Sent) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(e.emails.Sent))
+	}
+	msg := e.emails.Sent[0]
+	if msg.Subject != "Ihr Zahlungsbeleg" || msg.To != "jenny@example.com" {
+		t.Errorf("email = %q to %q", msg.Subject, msg.To)
+	}
+	for _, want := range []string{"2 × Basic", "30,00 USD", resp.OrderID} {
+		if !strings.Contains(msg.HTML, want) {
+			t.Errorf("HTML is missing %q:\n%s", want, msg.HTML)
+		}
+	}
+	if !strings.Contains(msg.Text, "2 x Basic: 30,00 USD") {
+		t.Errorf("text is missing the item:\n%s", msg.Text)
+	}
+}
+
+func TestEmailPreview(t *testing.T) {
+	e := newTestEnv(t)
+	checkStatus(t, e.do("GET", "/dev/email-preview", nil), http.StatusNotFound)
+
+	e.svc.Config.EmailPreviewEnabled = true
+	w := e.do("GET", "/dev/email-preview?locale=fr-CA", nil)
+	checkStatus(t, w, http.StatusOK)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if got := w.Header().Get("Content-Language"); got != "fr" {
+		t.Errorf("Content-Language = %q, want fr", got)
+	}
+	if !strings.Contains(w.Body.String(), "234,50 EUR") {
+		t.Errorf("preview doesn't format the amount for fr:\n%s", w.Body)
+	}
+
+	w = e.do("GET", "/dev/email-preview?template=receipt&format=text", nil)
+	checkStatus(t, w, http.StatusOK)
+	if w.Header().Get("X-Email-Subject") != "Your payment receipt" || !strings.Contains(w.Body.String(), "2 x Stubborn Attachments: 40.00 EUR") {
+		t.Errorf("text preview = %q\n%s", w.Header().Get("X-Email-Subject"), w.Body)
+	}
+
+	w = e.do("GET", "/dev/email-preview?template=authentication_required&locale=de", nil)
+	checkStatus(t, w, http.StatusOK)
+	if w.Header().Get("X-Email-Subject") != "Bitte bestätigen Sie Ihre Zahlung" || !strings.Contains(w.Body.String(), "42,00 EUR") {
+		t.Errorf("authentication preview = %q\n%s", w.Header().Get("X-Email-Subject"), w.Body)
+	}
+
+	checkErrorMessage(t, e.do("GET", "/dev/email-preview?template=invoice", nil), http.StatusNotFound, "unknown template")
+	checkStatus(t, e.do("POST", "/dev/email-preview", nil), http.StatusMethodNotAllowed)
+}
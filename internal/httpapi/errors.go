@@ -0,0 +1,24 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"stripe_go/internal/service"
+)
+
+// writeError answers r with err as a service.ErrorResponse, or with a
+// confirmation token when err is a *ConfirmationRequired. Server errors are
+// logged with their cause, which the response leaves out.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var cr *service.ConfirmationRequired
+	if errors.As(err, &cr) {
+		writeConfirmationRequired(w, cr)
+		return
+	}
+	status, msg := service.ErrorStatus(err)
+	if status >= http.StatusInternalServerError {
+		logFor(r).Error("request failed", "status", status, "error", err)
+	}
+	writeJSONError(w, &service.ErrorResponse{Error: msg}, status)
+}
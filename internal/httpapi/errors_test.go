@@ -0,0 +1,18 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestInternalErrorsDontLeak(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Payments.Close()
+
+	w := e.admin("GET", "/admin/orders", nil)
+	checkErrorMessage(t, w, http.StatusInternalServerError, "error while listing orders")
+	if strings.Contains(w.Body.String(), "sql") {
+		t.Errorf("response leaks the cause: %s", w.Body)
+	}
+}
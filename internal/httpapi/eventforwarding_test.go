@@ -0,0 +1,191 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// recordingBus is a message bus that keeps what it was given.
+type recordingBus struct {
+	mu       sync.Mutex
+	err      error
+	topics   []string
+	messages []*service.BusMessage
+}
+
+func (b *recordingBus) Publish(ctx context.Context, topic string, msg *service.BusMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.topics = append(b.topics, topic)
+	b.messages = append(b.messages, msg)
+	return nil
+}
+
+// forwardTo adds an HTTP event forward for patterns.
+func (e *testEnv) forwardTo(name string, patterns ...string) *webhookReceiver {
+	e.t.Helper()
+	rcv, url := e.webhookReceiver()
+	e.svc.Config.EventForwards = append(e.svc.Config.EventForwards, config.EventForward{Name: name, URL: url, Events: patterns})
+	e.svc.Config.OutboundWebhookSecret = testOutboundSecret
+	return rcv
+}
+
+// forwardToBus adds an event forward to a topic on a recording bus.
+func (e *testEnv) forwardToBus(name, topic string, patterns ...string) *recordingBus {
+	bus := &recordingBus{}
+	e.svc.MessageBuses["test"] = bus
+	e.t.Cleanup(func() { delete(e.svc.MessageBuses, "test") })
+	e.svc.Config.EventForwards = append(e.svc.Config.EventForwards, config.EventForward{Name: name, Bus: "test", Topic: topic, Events: patterns})
+	e.svc.Config.OutboundWebhookSecret = testOutboundSecret
+	return bus
+}
+
+func TestEventForwarding(t *testing.T) {
+	e := newTestEnv(t)
+	billing := e.forwardTo("billing", "invoice.*")
+	audit := e.forwardTo("audit")
+	ledger := e.forwardToBus("ledger", "stripe-events", "product.created", "charge.*")
+
+	paid, err := os.ReadFile("testdata/webhooks/invoice_paid.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	product, err := os.ReadFile("testdata/webhooks/unhandled_event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.deliverOK(paid)
+	e.deliverOK(product)
+	// A redelivered event isn't forwarded again.
+	e.deliverOK(product)
+	e.runJobs()
+
+	if len(billing.bodies) != 1 || len(audit.bodies) != 2 || len(ledger.messages) != 1 {
+		t.Fatalf("billing got %d events, audit %d, ledger %d", len(billing.bodies), len(audit.bodies), len(ledger.messages))
+	}
+	// The event is passed on byte for byte as Stripe signed it.
+	if !bytes.Equal(billing.bodies[0], paid) {
+		t.Errorf("forwarded %s, want %s unchanged", billing.bodies[0], paid)
+	}
+	var original struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(paid, &original); err != nil {
+		t.Fatal(err)
+	}
+	r := billing.requests[0]
+	if r.Header.Get("Webhook-Id") != original.ID {
+		t.Errorf("Webhook-Id = %q", r.Header.Get("Webhook-Id"))
+	}
+	checkWebhookSignature(t, r.Header.Get("Webhook-Signature"), billing.bodies[0])
+
+	msg := ledger.messages[0]
+	if ledger.topics[0] != "stripe-events" || msg.ID != "evt_test_unhandled" || msg.Type != "product.created" {
+		t.Errorf("bus message = %+v on %s", msg, ledger.topics[0])
+	}
+	checkWebhookSignature(t, msg.Signature, msg.Payload)
+	if !bytes.Equal(msg.Payload, product) {
+		t.Errorf("published %s, want %s unchanged", msg.Payload, product)
+	}
+
+	deliveries, err := e.svc.Payments.ListWebhookDeliveries(context.Background(), service.WebhookDeliveryFilter{Endpoint: "ledger"})
+	if err != nil || len(deliveries) != 1 || deliveries[0].Status != service.DeliverySucceeded || deliveries[0].Attempts != 1 {
+		t.Errorf("ledger deliveries = %+v, %v", deliveries, err)
+	}
+}
+
+func TestEventForwardingRetries(t *testing.T) {
+	e := newTestEnv(t)
+	ledger := e.forwardToBus("ledger", "stripe-events")
+	ledger.err = errors.New("bus unavailable")
+	product, err := os.ReadFile("testdata/webhooks/unhandled_event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.deliverOK(product)
+	e.runJobs()
+
+	deliveries, err := e.svc.Payments.ListWebhookDeliveries(context.Background(), service.WebhookDeliveryFilter{Endpoint: "ledger"})
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("deliveries = %v, %v", deliveries, err)
+	}
+	if d := deliveries[0]; d.Status != service.DeliveryFailed || !strings.Contains(d.LastError, "unavailable") {
+		t.Fatalf("failed delivery = %+v", d)
+	}
+	ledger.err = nil
+	checkStatus(t, e.admin("POST", "/admin/webhook-deliveries/"+deliveries[0].ID+"/retry", nil), http.StatusOK)
+	e.runJobs()
+	if len(ledger.messages) != 1 {
+		t.Errorf("published %d messages after the retry", len(ledger.messages))
+	}
+}
+
+// deliveryLogDown is a payment store that can't log webhook deliveries.
+type deliveryLogDown struct{ service.PaymentStore }
+
+func (deliveryLogDown) SaveWebhookDelivery(context.Context, *service.WebhookDelivery) error {
+	return errors.New("database unavailable")
+}
+
+func TestEventForwardFailureKeepsTheEvent(t *testing.T) {
+	e := newTestEnv(t)
+	ledger := e.forwardToBus("ledger", "stripe-events")
+	calls := 0
+	e.svc.WebhookRouter.On("product.created", func(context.Context, stripe.Event) error {
+		calls++
+		return nil
+	})
+	product, err := os.ReadFile("testdata/webhooks/unhandled_event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := e.svc.Payments
+	e.svc.Payments = deliveryLogDown{store}
+	e.deliverOK(product)
+	e.runJobs()
+	e.svc.Payments = store
+
+	// Stripe's redelivery is a duplicate: the forward is the job queue's
+	// to retry.
+	if status, body := e.deliver(product); status != http.StatusOK || !strings.Contains(body, "duplicate") {
+		t.Fatalf("redelivery: %d %s", status, body)
+	}
+	_, dead := e.svc.Jobs.Snapshot()
+	if calls != 1 || len(dead) != 1 || dead[0].Type != service.JobForwardEvent {
+		t.Fatalf("handler ran %d times, dead jobs %+v", calls, dead)
+	}
+	e.svc.Jobs.Retry(dead[0].ID)
+	e.runJobs()
+	if len(ledger.messages) != 1 || ledger.messages[0].ID != "evt_test_unhandled" {
+		t.Errorf("published %+v", ledger.messages)
+	}
+}
+
+func TestWebhookEventTypes(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.EventForwards = []config.EventForward{{Name: "ledger", URL: "http://ledger.internal", Events: []string{"product.created", "checkout.session.completed"}}}
+	types := e.svc.WebhookEventTypes()
+	if !slices.Contains(types, "product.created") || len(types) != len(e.svc.WebhookRouter.EventTypes())+1 || !slices.IsSorted(types) {
+		t.Errorf("types = %v", types)
+	}
+	e.svc.Config.EventForwards[0].Events = append(e.svc.Config.EventForwards[0].Events, "charge.*")
+	if types := e.svc.WebhookEventTypes(); !slices.Equal(types, []string{"*"}) {
+		t.Errorf("types with a pattern = %v", types)
+	}
+}
@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"stripe_go/internal/service"
+)
+
+// projections are the states derived from the event log, by name. Each
+// refolds every stream it keeps from its events and, unless dryRun is set,
+// saves the states that differ from the stored ones. A new projection only
+// needs an entry here to be rebuilt from the events logged so far.
+var projections = map[string]func(ctx context.Context, svc *service.Service, dryRun bool) (*service.ProjectionRebuild, error){
+	"orders": func(ctx context.Context, svc *service.Service, dryRun bool) (*service.ProjectionRebuild, error) {
+		return svc.RebuildOrders(ctx, dryRun)
+	},
+}
+
+// OrderHistory is how an order got into its state: its logged events with
+// the status after each, and the order they fold into. Drift lists the
+// fields where the stored order differs from the folded one.
+type OrderHistory struct {
+	Order     *service.Order      `json:"order"`
+	Projected *service.Order      `json:"projected"`
+	Drift     []string            `json:"drift,omitempty"`
+	Steps     []service.OrderStep `json:"steps"`
+}
+
+// handleAdminOrderEvents serves GET /admin/orders/{id}/events.
+func (srv *Server) handleAdminOrderEvents(w http.ResponseWriter, r *http.Request, orderID string) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	o, err := srv.svc.Payments.GetOrder(r.Context(), orderID)
+	if err == service.ErrOrderNotFound {
+		writeError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, r, service.InternalError("fetching order", err))
+		return
+	}
+	events, err := srv.svc.OrderEvents(r.Context(), o)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing events", err))
+		return
+	}
+	folded, steps, err := service.FoldOrder(o, events)
+	if err != nil {
+		writeError(w, r, service.InternalError("folding events", err))
+		return
+	}
+	writeJSON(w, &OrderHistory{Order: o, Projected: folded, Drift: service.OrderChanges(o, folded), Steps: steps})
+}
+
+// handleAdminProjection serves POST /admin/projections/{name}/rebuild,
+// which refolds a projection from the event log. With ?dryRun=true it only
+// reports what would change.
+func (srv *Server) handleAdminProjection(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/admin/projections/")
+	if len(parts) != 2 || parts[1] != "rebuild" {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	rebuild, ok := projections[parts[0]]
+	if !ok {
+		writeError(w, r, &service.Error{Status: http.StatusNotFound, Message: fmt.Sprintf("unknown projection %q", parts[0])})
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	result, err := rebuild(r.Context(), srv.svc, dryRun)
+	if err != nil {
+		writeError(w, r, service.InternalError("rebuilding "+parts[0], err))
+		return
+	}
+	logFor(r).Info("projection rebuilt", "projection", parts[0], "dry_run", dryRun, "streams", result.Streams, "changes", len(result.Changes))
+	writeJSON(w, result)
+}
@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"stripe_go/internal/service"
+)
+
+func TestOrderEventHistory(t *testing.T) {
+	e := newTestEnv(t)
+	resp := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 2})
+	pi := "pi_" + resp.ID
+	e.deliverOK([]byte(`{"id": "evt_refunded", "object": "event", "type": "charge.refunded", "data": {"object": {
+		"id": "ch_history", "object": "charge", "payment_intent": "` + pi + `", "refunded": true, "amount_refunded": 3000}}}`))
+	// A redelivery isn't logged twice.
+	e.deliver([]byte(`{"id": "evt_refunded", "object": "event", "type": "charge.refunded", "data": {"object": {
+		"id": "ch_history", "object": "charge", "payment_intent": "` + pi + `", "refunded": true, "amount_refunded": 3000}}}`))
+	// Events of other payments aren't part of the history.
+	e.deliverOK(sessionEvent("checkout.session.expired", "cs_test_other"))
+
+	var h OrderHistory
+	decodeBody(t, e.admin("GET", "/admin/orders/"+resp.OrderID+"/events", nil), &h)
+	if len(h.Steps) != 2 {
+		t.Fatalf("steps = %+v, want the completed session and the refund", h.Steps)
+	}
+	if s := h.Steps[0]; s.Event.Type != "checkout.session.completed" || s.Status != service.OrderPaid || s.Event.OrderID != resp.OrderID || s.Event.SessionID != resp.ID {
+		t.Errorf("first step = %+v", s)
+	}
+	if s := h.Steps[1]; s.Event.Type != "charge.refunded" || s.Status != service.OrderRefunded || s.Event.PaymentIntentID != pi {
+		t.Errorf("second step = %+v", s)
+	}
+	if !strings.Contains(string(h.Steps[1].Event.Payload), `"amount_refunded":3000`) {
+		t.Errorf("payload = %s, want the event as delivered", h.Steps[1].Event.Payload)
+	}
+	if h.Order.Status != service.OrderRefunded || h.Projected.Status != service.OrderRefunded || len(h.Drift) != 0 {
+		t.Errorf("history = %+v %+v drift %v, want both refunded", h.Order, h.Projected, h.Drift)
+	}
+
+	checkStatus(t, e.admin("GET", "/admin/orders/ord_nope/events", nil), http.StatusNotFound)
+}
+
+func TestRebuildOrderProjection(t *testing.T) {
+	e := newTestEnv(t)
+	paid := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 1})
+	fulfilled := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 1})
+	checkStatus(t, e.admin("POST", "/admin/orders/"+fulfilled.OrderID+"/fulfill", nil), http.StatusOK)
+
+	// Lose the paid order's state, as a handler bug would.
+	ctx := context.Background()
+	o, err := e.svc.Payments.GetOrder(ctx, paid.OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Status, o.Email = service.OrderPending, ""
+	if err := e.svc.Payments.SaveOrder(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+
+	var h OrderHistory
+	decodeBody(t, e.admin("GET", "/admin/orders/"+paid.OrderID+"/events", nil), &h)
+	if strings.Join(h.Drift, ",") != "status,email" {
+		t.Errorf("drift = %v, want status and email", h.Drift)
+	}
+
+	var rebuild service.ProjectionRebuild
+	decodeBody(t, e.admin("POST", "/admin/projections/orders/rebuild", nil), &rebuild)
+	if rebuild.DryRun || rebuild.Streams != 2 || len(rebuild.Changes) != 1 {
+		t.Errorf("rebuild = %+v", rebuild)
+	}
+	if o := e.order(paid.OrderID); o.Status != service.OrderPaid || o.Email != "jenny@example.com" {
+		t.Errorf("rebuilt order = %+v", o)
+	}
+	// Fulfillment happens here, not at Stripe, so it survives the rebuild.
+	if o := e.order(fulfilled.OrderID); o.Status != service.OrderFulfilled {
+		t.Errorf("fulfilled order = %s after rebuild", o.Status)
+	}
+
+	checkErrorMessage(t, e.admin("POST", "/admin/projections/nope/rebuild", nil), http.StatusNotFound, `unknown projection "nope"`)
+}
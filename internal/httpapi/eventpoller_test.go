@@ -0,0 +1,97 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// addEvent makes a webhook fixture show up in the fake's Events API.
+func (e *testEnv) addEvent(fixture string) *stripe.Event {
+	e.t.Helper()
+	payload, err := os.ReadFile("testdata/webhooks/" + fixture)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	var event stripe.Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		e.t.Fatal(err)
+	}
+	event.Created = time.Now().Unix()
+	e.stripe.Events = append(e.stripe.Events, &event)
+	return &event
+}
+
+func TestEventPoller(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedPayment()
+	e.stripe.Events = append(e.stripe.Events, &stripe.Event{ID: "evt_before_start", Type: "test.old", Data: &stripe.EventData{Raw: []byte(`{}`)}})
+	ctx := context.Background()
+	p, err := e.svc.NewEventPoller(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := p.Poll(ctx); n != 0 || err != nil {
+		t.Fatalf("poll before new events = %d, %v", n, err)
+	}
+
+	event := e.addEvent("checkout_session_completed.json")
+	if n, err := p.Poll(ctx); n != 1 || err != nil {
+		t.Fatalf("poll = %d, %v, want the new event handled", n, err)
+	}
+	if p.Cursor != event.ID {
+		t.Errorf("cursor = %q, want %q", p.Cursor, event.ID)
+	}
+	e.runJobs()
+	if got, err := e.svc.Payments.GetPayment(context.Background(), "cs_test_completed"); err != nil || got.Status != "paid" {
+		t.Errorf("payment = %+v, %v, want it paid", got, err)
+	}
+	if n, err := p.Poll(ctx); n != 0 || err != nil {
+		t.Errorf("second poll = %d, %v, want nothing new", n, err)
+	}
+	// Events that also arrive as webhooks are only handled once.
+	if duplicate, err := e.svc.ProcessEvent(ctx, *event, nil); !duplicate || err != nil {
+		t.Errorf("processEvent of a polled event = %v, %v, want a duplicate", duplicate, err)
+	}
+}
+
+func TestEventPollerRetriesFailedEvents(t *testing.T) {
+	e := newTestEnv(t)
+	calls := 0
+	e.svc.WebhookRouter.On("test.flaky", func(context.Context, stripe.Event) error {
+		calls++
+		if calls == 1 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+	ctx := context.Background()
+	// An account without events is polled by creation time.
+	p, err := e.svc.NewEventPoller(ctx)
+	if err != nil || p.Cursor != "" {
+		t.Fatalf("newEventPoller = %+v, %v", p, err)
+	}
+	for _, id := range []string{"evt_flaky", "evt_after"} {
+		e.stripe.Events = append(e.stripe.Events, &stripe.Event{ID: id, Type: "test.flaky", Created: time.Now().Unix(), Data: &stripe.EventData{Raw: []byte(`{}`)}})
+	}
+	if n, err := p.Poll(ctx); n != 0 || err == nil || !strings.Contains(err.Error(), "evt_flaky") {
+		t.Fatalf("poll = %d, %v, want it stopped at the failed event", n, err)
+	}
+	if n, err := p.Poll(ctx); n != 2 || err != nil || p.Cursor != "evt_after" {
+		t.Fatalf("poll = %d, %v (cursor %s), want both events handled", n, err, p.Cursor)
+	}
+}
+
+func TestEventPollingNeedsTestMode(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.Mode = "live"
+	if err := e.svc.StartEventPolling(context.Background()); err == nil || !strings.Contains(err.Error(), "test mode") {
+		t.Errorf("startEventPolling in live mode = %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"stripe_go/internal/service"
+)
+
+// handleDownload serves GET /downloads/{fulfillmentId}?token=... with a
+// link from the order status. Refunded orders lose their downloads.
+func (srv *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if srv.svc.Config.DownloadSigningKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	parts := pathParams(r.URL.Path, "/downloads/")
+	if len(parts) != 1 {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	id := parts[0]
+	if err := srv.svc.VerifyDownloadToken(id, r.URL.Query().Get("token"), time.Now()); err != nil {
+		writeError(w, r, &service.Error{Status: http.StatusForbidden, Message: err.Error()})
+		return
+	}
+	f, err := srv.svc.Payments.GetFulfillment(r.Context(), id)
+	if err == service.ErrFulfillmentNotFound || (err == nil && (f.Type != "download" || f.Status != service.FulfillmentDone)) {
+		writeError(w, r, service.ErrFulfillmentNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, service.InternalError("fetching fulfillment", err))
+		return
+	}
+	o, err := srv.svc.Payments.GetOrder(r.Context(), f.OrderID)
+	if err != nil {
+		writeError(w, r, service.InternalError("fetching order", err))
+		return
+	}
+	if o.Status != service.OrderPaid && o.Status != service.OrderFulfilled {
+		writeError(w, r, &service.Error{Status: http.StatusGone, Message: fmt.Sprintf("order is %s", o.Status)})
+		return
+	}
+	file, err := os.Open(filepath.Join(srv.svc.Config.DownloadsDir, f.Details["file"]))
+	if err != nil {
+		writeError(w, r, service.InternalError("opening download", err))
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, r, service.InternalError("reading download", err))
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", f.Details["file"]))
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(w, r, f.Details["file"], info.ModTime(), file)
+}
@@ -0,0 +1,253 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+const testDownloadKey = "download-key-0123456789abcdef0123"
+
+// fulfillmentRoutes routes purchases with routes, a FULFILLMENT_ROUTES
+// value, signs licenses, and serves downloads from a temporary directory
+// holding ebook.pdf.
+func (e *testEnv) fulfillmentRoutes(routes string) {
+	e.t.Helper()
+	var err error
+	if e.svc.Config.FulfillmentRoutes, err = config.ParseFulfillmentRoutes(routes); err != nil {
+		e.t.Fatal(err)
+	}
+	e.svc.Config.DownloadsDir = e.t.TempDir()
+	e.svc.Config.DownloadSigningKey = testDownloadKey
+	e.svc.Config.DownloadLinkTTL = time.Hour
+	e.svc.Config.OrderStatusSigningKey = testOrderStatusKey
+	e.svc.Config.LicenseSigningKey = testLicenseKey
+	if err := os.WriteFile(filepath.Join(e.svc.Config.DownloadsDir, "ebook.pdf"), []byte("%PDF ebook"), 0o644); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// paidCheckout checks out items and delivers the session's
+// checkout.session.completed event.
+func (e *testEnv) paidCheckout(items ...service.CheckoutItem) service.CreateCheckoutResponse {
+	e.t.Helper()
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": items})
+	checkStatus(e.t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(e.t, w, &resp)
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_%s", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": "pi_%s", "payment_status": "paid",
+		"amount_total": 3000, "currency": "usd", "customer_details": {"email": "jenny@example.com"},
+		"metadata": {"order": %q}}}}`, resp.ID, resp.ID, resp.ID, resp.OrderID)))
+	return resp
+}
+
+func (e *testEnv) fulfillments(orderID string) []*service.Fulfillment {
+	e.t.Helper()
+	w := e.admin("GET", "/admin/orders/"+orderID+"/fulfillments", nil)
+	checkStatus(e.t, w, http.StatusOK)
+	var list struct {
+		Fulfillments []*service.Fulfillment `json:"fulfillments"`
+	}
+	decodeBody(e.t, w, &list)
+	return list.Fulfillments
+}
+
+func TestDigitalFulfillment(t *testing.T) {
+	e := newTestEnv(t)
+	e.fulfillmentRoutes("price_basic=download:ebook.pdf,price_yen=license")
+	resp := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 1}, service.CheckoutItem{Price: "price_yen", Quantity: 3})
+
+	list := e.fulfillments(resp.OrderID)
+	if len(list) != 2 {
+		t.Fatalf("fulfillments = %+v", list)
+	}
+	for _, f := range list {
+		if f.Status != service.FulfillmentDone || f.Product != "prod_basic" {
+			t.Errorf("fulfillment = %+v", f)
+		}
+	}
+	if o := e.order(resp.OrderID); o.Status != service.OrderFulfilled {
+		t.Errorf("order status = %s, want fulfilled", o.Status)
+	}
+	if entries := e.audit("?action=order.fulfilled"); len(entries) != 1 || entries[0].Actor != service.ActorFulfillment || entries[0].Object != resp.OrderID {
+		t.Errorf("audit = %+v", entries)
+	}
+
+	v := e.orderStatus(resp.StatusURL)
+	if len(v.Fulfillments) != 2 {
+		t.Fatalf("status fulfillments = %+v", v.Fulfillments)
+	}
+	download, license := v.Fulfillments[0], v.Fulfillments[1]
+	if download.Type != "download" {
+		download, license = license, download
+	}
+	if license.LicenseKey != e.svc.LicenseKey(resp.OrderID, "price_yen") || license.Quantity != 3 {
+		t.Errorf("license = %+v", license)
+	}
+	if !strings.HasPrefix(download.DownloadURL, e.svc.Config.Domain+"/downloads/ful_") {
+		t.Fatalf("download = %+v", download)
+	}
+	w := e.do("GET", strings.TrimPrefix(download.DownloadURL, e.svc.Config.Domain), nil)
+	checkStatus(t, w, http.StatusOK)
+	if w.Body.String() != "%PDF ebook" || !strings.Contains(w.Header().Get("Content-Disposition"), `filename="ebook.pdf"`) {
+		t.Errorf("download = %q, headers %v", w.Body, w.Header())
+	}
+
+	// Running the job again doesn't issue a second key.
+	e.refulfill(resp.OrderID)
+	if again := e.fulfillments(resp.OrderID); len(again) != 2 {
+		t.Errorf("fulfillments after a repeated event = %+v", again)
+	}
+
+	// Refunded orders lose their downloads.
+	o := e.order(resp.OrderID)
+	if err := o.Transition(service.OrderRefunded); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.svc.Payments.SaveOrder(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, e.do("GET", strings.TrimPrefix(download.DownloadURL, e.svc.Config.Domain), nil), http.StatusGone)
+}
+
+// refulfill runs an order's fulfillment again, as a retried job would.
+func (e *testEnv) refulfill(orderID string) {
+	e.t.Helper()
+	if err := e.svc.Jobs.Enqueue(context.Background(), service.JobFulfillOrder, orderID); err != nil {
+		e.t.Fatal(err)
+	}
+	e.runJobs()
+}
+
+func (e *testEnv) shipmentNotifications(orderID string) []*service.EmailMessage {
+	var list []*service.EmailMessage
+	for _, msg := range e.emails.Sent {
+		if strings.Contains(msg.Subject, "Shipment requested for order "+orderID) {
+			list = append(list, msg)
+		}
+	}
+	return list
+}
+
+func TestShipmentFulfillment(t *testing.T) {
+	e := newTestEnv(t)
+	// The price route wins over the product's.
+	e.fulfillmentRoutes("prod_basic=shipment,price_yen=license")
+	resp := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 2}, service.CheckoutItem{Price: "price_yen", Quantity: 1})
+
+	list := e.fulfillments(resp.OrderID)
+	if len(list) != 2 {
+		t.Fatalf("fulfillments = %+v", list)
+	}
+	for _, f := range list {
+		switch f.Price {
+		case "price_basic":
+			if f.Type != "shipment" || f.Status != service.FulfillmentOpen || !strings.HasPrefix(f.Details["ticket"], "shp_") {
+				t.Errorf("shipment = %+v", f)
+			}
+		case "price_yen":
+			if f.Type != "license" || f.Status != service.FulfillmentDone {
+				t.Errorf("license = %+v", f)
+			}
+		}
+	}
+	// The parcel still has to go out.
+	if o := e.order(resp.OrderID); o.Status != service.OrderPaid {
+		t.Errorf("order status = %s, want paid", o.Status)
+	}
+	shipments := e.shipmentNotifications(resp.OrderID)
+	if len(shipments) != 1 || !strings.Contains(shipments[0].HTML, "2 x Basic") {
+		t.Errorf("shipment notifications = %+v", shipments)
+	}
+
+	e.refulfill(resp.OrderID)
+	if n := len(e.shipmentNotifications(resp.OrderID)); n != 1 {
+		t.Errorf("sent %d shipment notifications, want 1", n)
+	}
+}
+
+func TestFulfillmentSkipsUnroutedOrders(t *testing.T) {
+	e := newTestEnv(t)
+	e.fulfillmentRoutes("price_yen=license")
+	resp := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 1}, service.CheckoutItem{Price: "price_yen", Quantity: 1})
+	if list := e.fulfillments(resp.OrderID); len(list) != 1 || list[0].Price != "price_yen" {
+		t.Errorf("fulfillments = %+v", list)
+	}
+	// price_basic has no route, so someone fulfills the order by hand.
+	if o := e.order(resp.OrderID); o.Status != service.OrderPaid {
+		t.Errorf("order status = %s, want paid", o.Status)
+	}
+
+	// A missing file fails the line, and the job retries it.
+	e.fulfillmentRoutes("price_basic=download:missing.pdf")
+	other := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 1})
+	list := e.fulfillments(other.OrderID)
+	if len(list) != 1 || list[0].Status != service.FulfillmentPending || !strings.Contains(list[0].LastError, "missing.pdf") {
+		t.Errorf("failed download = %+v", list)
+	}
+	if _, dead := e.svc.Jobs.Snapshot(); len(dead) != 1 || dead[0].Type != service.JobFulfillOrder {
+		t.Errorf("dead jobs = %+v, want the fulfillment", dead)
+	}
+	checkStatus(t, e.admin("GET", "/admin/orders/ord_missing/fulfillments", nil), http.StatusNotFound)
+}
+
+func TestDownloadLinks(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.DownloadSigningKey = testDownloadKey
+	now := time.Unix(1700000000, 0)
+	token := e.svc.DownloadToken("ful_1", now.Add(time.Hour))
+	if err := e.svc.VerifyDownloadToken("ful_1", token, now); err != nil {
+		t.Error(err)
+	}
+	if err := e.svc.VerifyDownloadToken("ful_2", token, now); err == nil {
+		t.Error("token accepted for another fulfillment")
+	}
+	if err := e.svc.VerifyDownloadToken("ful_1", token, now.Add(2*time.Hour)); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired token: %v", err)
+	}
+	checkErrorMessage(t, e.do("GET", "/downloads/ful_1?token=x", nil), http.StatusForbidden, "invalid download link")
+	checkStatus(t, e.do("GET", "/downloads/ful_1?token="+e.svc.DownloadToken("ful_1", time.Now().Add(time.Hour)), nil), http.StatusNotFound)
+
+	e.svc.Config.DownloadSigningKey = ""
+	checkStatus(t, e.do("GET", "/downloads/ful_1?token="+token, nil), http.StatusNotFound)
+}
+
+func TestProductSuccessPages(t *testing.T) {
+	e := newTestEnv(t)
+	var err error
+	if e.svc.Config.SuccessPages, err = config.ParseSuccessPages("prod_basic=/html/thanks-basic.html"); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}, {Price: "price_yen", Quantity: 1}},
+	}), http.StatusOK)
+	if got := *e.stripe.SessionParams[0].SuccessURL; got != e.svc.Config.Domain+"/html/thanks-basic.html?session_id={CHECKOUT_SESSION_ID}" {
+		t.Errorf("success URL = %s", got)
+	}
+
+	// Carts that don't agree on a page, and clients that pick one, keep
+	// theirs.
+	e.svc.Config.SuccessPages["price_yen"] = "/html/thanks-yen.html"
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}, {Price: "price_yen", Quantity: 1}},
+	}), http.StatusOK)
+	if got := *e.stripe.SessionParams[1].SuccessURL; !strings.Contains(got, "/html/success.html") {
+		t.Errorf("success URL = %s, want the default page", got)
+	}
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":      []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"successUrl": "https://shop.example.com/done",
+	}), http.StatusOK)
+	if got := *e.stripe.SessionParams[2].SuccessURL; !strings.HasPrefix(got, "https://shop.example.com/done") {
+		t.Errorf("success URL = %s, want the client's", got)
+	}
+}
@@ -0,0 +1,40 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"stripe_go/internal/service"
+)
+
+// handleHealthz is the liveness probe: it answers as long as the process
+// can serve requests, without looking at dependencies, so an outage at
+// Stripe or the database doesn't get every instance restarted.
+func (srv *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	writeJSON(w, &service.HealthResponse{Status: "ok", Mode: srv.svc.Config.Mode})
+}
+
+// handleReadyz is the readiness probe: it returns 503 until the
+// configuration is valid and the database and Stripe answer, so load
+// balancers only send traffic to instances that can take payments.
+func (srv *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	checks, problems := srv.svc.Readiness(r.Context())
+	resp := &service.HealthResponse{Status: "ok", Checks: checks, Problems: problems}
+	if srv.svc.Config != nil {
+		resp.Mode = srv.svc.Config.Mode
+	}
+	if len(problems) > 0 {
+		logFor(r).Warn("not ready", "problems", problems)
+		resp.Status = "unavailable"
+		writeJSONError(w, resp, http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, resp)
+}
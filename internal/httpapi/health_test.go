@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestHealthz(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("GET", "/healthz", nil)
+	checkStatus(t, w, http.StatusOK)
+	var resp service.HealthResponse
+	decodeBody(t, w, &resp)
+	if resp.Status != "ok" || resp.Mode != "test" || len(resp.Problems) != 0 {
+		t.Errorf("response = %+v", resp)
+	}
+	checkStatus(t, e.do("POST", "/healthz", nil), http.StatusMethodNotAllowed)
+
+	// Liveness doesn't depend on Stripe.
+	e.stripe.Err = &stripe.Error{HTTPStatusCode: http.StatusInternalServerError, Msg: "api down"}
+	checkStatus(t, e.do("GET", "/healthz", nil), http.StatusOK)
+}
+
+func TestReadyz(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("GET", "/readyz", nil)
+	checkStatus(t, w, http.StatusOK)
+	var resp service.HealthResponse
+	decodeBody(t, w, &resp)
+	if resp.Status != "ok" || resp.Mode != "test" || len(resp.Problems) != 0 {
+		t.Errorf("response = %+v", resp)
+	}
+	for _, name := range []string{"config", "webhook_secret", "database", "stripe"} {
+		if resp.Checks[name] != "ok" {
+			t.Errorf("check %s = %q", name, resp.Checks[name])
+		}
+	}
+	checkStatus(t, e.do("POST", "/readyz", nil), http.StatusMethodNotAllowed)
+}
+
+func TestReadyzReportsProblems(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.WebhookSecrets = nil
+	e.svc.Config.Domain = "shop.example.com"
+	e.svc.Config.Price = "price_archived"
+
+	w := e.do("GET", "/readyz", nil)
+	checkStatus(t, w, http.StatusServiceUnavailable)
+	var resp service.HealthResponse
+	decodeBody(t, w, &resp)
+	problems := strings.Join(resp.Problems, "\n")
+	for _, want := range []string{"DOMAIN", "STRIPE_WEBHOOK_SECRET", "PRICE price_archived"} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("problems don't mention %s:\n%s", want, problems)
+		}
+	}
+	if resp.Status != "unavailable" || resp.Checks["database"] != "ok" || resp.Checks["webhook_secret"] == "ok" {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestReadyzChecksDependencies(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Err = &stripe.Error{HTTPStatusCode: http.StatusUnauthorized, Msg: "Invalid API Key provided"}
+	e.svc.Payments.Close()
+
+	w := e.do("GET", "/readyz", nil)
+	checkStatus(t, w, http.StatusServiceUnavailable)
+	var resp service.HealthResponse
+	decodeBody(t, w, &resp)
+	if !strings.Contains(resp.Checks["stripe"], "Invalid API Key") {
+		t.Errorf("stripe check = %q", resp.Checks["stripe"])
+	}
+	if !strings.Contains(resp.Checks["database"], "unreachable") {
+		t.Errorf("database check = %q", resp.Checks["database"])
+	}
+	if resp.Checks["config"] != "ok" {
+		t.Errorf("config check = %q", resp.Checks["config"])
+	}
+}
@@ -0,0 +1,142 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+	"stripe_go/internal/stripeclient"
+)
+
+// idempotencyRecorder passes a response through and keeps a copy of it.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (ir *idempotencyRecorder) WriteHeader(code int) {
+	if ir.status == 0 {
+		ir.status = code
+	}
+	ir.ResponseWriter.WriteHeader(code)
+}
+
+func (ir *idempotencyRecorder) Write(b []byte) (int, error) {
+	if ir.status == 0 {
+		ir.status = http.StatusOK
+	}
+	ir.body.Write(b)
+	return ir.ResponseWriter.Write(b)
+}
+
+func (ir *idempotencyRecorder) Unwrap() http.ResponseWriter { return ir.ResponseWriter }
+
+// withIdempotency lets clients retry a POST safely by sending an
+// Idempotency-Key header: the first response for a key is stored for
+// IDEMPOTENCY_KEY_TTL and replayed, with "Idempotent-Replayed: true", to
+// every retry with the same body. A retry with a different body is rejected
+// with 422, and one made while the first request is still running with 409.
+// Responses that are worth retrying (429 and 5xx) aren't stored. Keys are
+// scoped to the endpoint, the tenant and, behind requireAuth, to the caller
+// and the connected account it acts on.
+func (srv *Server) withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientKey := r.Header.Get("Idempotency-Key")
+		if r.Method != "POST" || clientKey == "" {
+			next(w, r)
+			return
+		}
+		if len(clientKey) > service.MaxIdempotencyKeyLength {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: "Idempotency-Key is too long"})
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: "error reading request " + err.Error()})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		p, _ := service.PrincipalFrom(r.Context())
+		scope := p.Name
+		if id := stripeclient.AccountFrom(r.Context()); id != "" {
+			scope += "@" + id
+		}
+		// withTenantRouting has stripped /t/{id} from the path already.
+		if t := service.TenantFrom(r.Context()); t != nil {
+			scope += "/t/" + t.ID
+		}
+		key := config.SHA256Hex(scope + "\x00" + r.URL.Path + "\x00" + clientKey)
+		requestHash := config.SHA256Hex(r.Header.Get("Content-Type") + "\x00" + string(body))
+		stored, err := srv.svc.Payments.BeginIdempotentRequest(r.Context(), key, requestHash, time.Now().Add(-srv.svc.Config.IdempotencyKeyTTL))
+		if err != nil {
+			writeError(w, r, service.InternalError("checking idempotency key", err))
+			return
+		}
+		if stored != nil {
+			replayIdempotentResponse(w, r, stored, requestHash)
+			return
+		}
+
+		// The key is settled even when the client has gone away.
+		cleanupCtx := context.WithoutCancel(r.Context())
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			// A handler that panicked or wasn't worth remembering leaves the
+			// key free for the client's retry.
+			if completed {
+				return
+			}
+			if err := srv.svc.Payments.ReleaseIdempotentRequest(cleanupCtx, key); err != nil {
+				logFor(r).Error("releasing idempotency key", "error", err)
+			}
+		}()
+		next(rec, r.WithContext(service.WithIdempotencyKey(r.Context(), key)))
+		// A 428 is repeated with a confirmation token under the same key.
+		if rec.status == 0 || rec.status == http.StatusTooManyRequests || rec.status == http.StatusPreconditionRequired || rec.status >= 500 {
+			return
+		}
+		err = srv.svc.Payments.CompleteIdempotentRequest(cleanupCtx, &service.IdempotentResponse{
+			Key:         key,
+			RequestHash: requestHash,
+			Status:      rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Location:    rec.Header().Get("Location"),
+			Body:        rec.body.Bytes(),
+		})
+		if err != nil {
+			logFor(r).Error("storing idempotent response", "error", err)
+			return
+		}
+		completed = true
+	}
+}
+
+// replayIdempotentResponse answers a retry with the response stored for its
+// Idempotency-Key.
+func replayIdempotentResponse(w http.ResponseWriter, r *http.Request, stored *service.IdempotentResponse, requestHash string) {
+	switch {
+	case stored.RequestHash != requestHash:
+		writeError(w, r, &service.Error{Status: http.StatusUnprocessableEntity, Message: "Idempotency-Key was already used with a different request"})
+		return
+	case stored.Status == 0:
+		writeError(w, r, &service.Error{Status: http.StatusConflict, Message: "a request with this Idempotency-Key is still being processed"})
+		return
+	}
+	logFor(r).Info("replaying idempotent response", "path", r.URL.Path, "status", stored.Status)
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	if stored.Location != "" {
+		w.Header().Set("Location", stored.Location)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
@@ -0,0 +1,156 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+)
+
+func TestIdempotentCheckout(t *testing.T) {
+	e := newTestEnv(t)
+	body := map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}}
+	first := e.do("POST", "/create-checkout-session", body, "Idempotency-Key", "click-1")
+	checkStatus(t, first, http.StatusOK)
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("first response marked as replayed")
+	}
+
+	again := e.do("POST", "/create-checkout-session", body, "Idempotency-Key", "click-1")
+	checkStatus(t, again, http.StatusOK)
+	if again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry not marked as replayed")
+	}
+	if again.Body.String() != first.Body.String() {
+		t.Errorf("replayed %s, want %s", again.Body, first.Body)
+	}
+	if len(e.stripe.SessionParams) != 1 {
+		t.Fatalf("created %d sessions, want 1", len(e.stripe.SessionParams))
+	}
+	if key := stripe.StringValue(e.stripe.SessionParams[0].IdempotencyKey); !strings.HasPrefix(key, "checkout_session:") {
+		t.Errorf("Stripe idempotency key = %q, want one derived from the order", key)
+	}
+
+	other := map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 2}}}
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", other, "Idempotency-Key", "click-1"), http.StatusUnprocessableEntity, "different request")
+
+	// Keys are per endpoint, and requests without one aren't remembered.
+	checkStatus(t, e.do("POST", "/create-checkout-session", other, "Idempotency-Key", "click-2"), http.StatusOK)
+	checkStatus(t, e.do("POST", "/create-checkout-session", body), http.StatusOK)
+	checkStatus(t, e.do("POST", "/create-checkout-session", body), http.StatusOK)
+	if len(e.stripe.SessionParams) != 4 {
+		t.Errorf("created %d sessions, want 4", len(e.stripe.SessionParams))
+	}
+
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", body, "Idempotency-Key", strings.Repeat("k", 256)), http.StatusBadRequest, "too long")
+}
+
+func TestIdempotencyKeysPerTenant(t *testing.T) {
+	e := newTestEnv(t)
+	e.addTestTenant()
+	body := map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}}
+	first := e.do("POST", "/create-checkout-session", body, "Idempotency-Key", "click-1")
+	checkStatus(t, first, http.StatusOK)
+	tenant := e.do("POST", "/t/acme/create-checkout-session", body, "Idempotency-Key", "click-1")
+	checkStatus(t, tenant, http.StatusOK)
+	if tenant.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("tenant request replayed the default account's response")
+	}
+	if len(e.stripe.SessionParams) != 2 {
+		t.Errorf("created %d sessions, want 2", len(e.stripe.SessionParams))
+	}
+	again := e.do("POST", "/t/acme/create-checkout-session", body, "Idempotency-Key", "click-1")
+	if again.Header().Get("Idempotent-Replayed") != "true" || again.Body.String() != tenant.Body.String() {
+		t.Errorf("tenant retry = %s, want the tenant's first response replayed", again.Body)
+	}
+}
+
+func TestIdempotentFormRedirect(t *testing.T) {
+	e := newTestEnv(t)
+	form := url.Values{"quantity": {"1"}}
+	first := e.do("POST", "/create-checkout-session", form, "Idempotency-Key", "form-1")
+	checkStatus(t, first, http.StatusSeeOther)
+	again := e.do("POST", "/create-checkout-session", form, "Idempotency-Key", "form-1")
+	checkStatus(t, again, http.StatusSeeOther)
+	if loc := again.Header().Get("Location"); loc == "" || loc != first.Header().Get("Location") {
+		t.Errorf("replayed Location = %q, want %q", loc, first.Header().Get("Location"))
+	}
+}
+
+func TestIdempotencyKeyReleasedOnFailure(t *testing.T) {
+	e := newTestEnv(t)
+	body := map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}}
+	e.stripe.Err = &stripe.Error{HTTPStatusCode: http.StatusInternalServerError, Msg: "api down"}
+	w := e.do("POST", "/create-checkout-session", body, "Idempotency-Key", "retry-me")
+	if w.Code < 500 {
+		t.Fatalf("status = %d, want a 5xx", w.Code)
+	}
+
+	e.stripe.Err = nil
+	w = e.do("POST", "/create-checkout-session", body, "Idempotency-Key", "retry-me")
+	checkStatus(t, w, http.StatusOK)
+	if w.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("failed response was replayed")
+	}
+}
+
+func TestIdempotentRefunds(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedPayment()
+	e.stripe.PaymentIntents["pi_test_seed"] = &stripe.PaymentIntent{ID: "pi_test_seed", Amount: 3000, Currency: "usd"}
+	req := service.RefundRequest{PaymentIntentID: "pi_test_seed", Amount: 1000}
+	for i := 0; i < 2; i++ {
+		checkStatus(t, e.do("POST", "/refunds", req, "Authorization", "Bearer "+servicetest.AdminToken, "Idempotency-Key", "refund-1"), http.StatusOK)
+	}
+	if len(e.stripe.RefundParams) != 1 {
+		t.Fatalf("created %d refunds, want 1", len(e.stripe.RefundParams))
+	}
+	if key := stripe.StringValue(e.stripe.RefundParams[0].IdempotencyKey); !strings.HasPrefix(key, "refund:") {
+		t.Errorf("Stripe idempotency key = %q", key)
+	}
+
+	// Without a client key every refund still carries its own Stripe key.
+	checkStatus(t, e.admin("POST", "/refunds", req), http.StatusOK)
+	checkStatus(t, e.admin("POST", "/refunds", req), http.StatusOK)
+	if a, b := e.stripe.RefundParams[1].IdempotencyKey, e.stripe.RefundParams[2].IdempotencyKey; a == nil || b == nil || *a == *b {
+		t.Errorf("Stripe idempotency keys = %v, %v, want two different ones", stripe.StringValue(a), stripe.StringValue(b))
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	e := newTestEnv(t)
+	stored, err := e.svc.Payments.BeginIdempotentRequest(context.Background(), "k1", "hash", time.Now().Add(-time.Hour))
+	if err != nil || stored != nil {
+		t.Fatalf("BeginIdempotentRequest = %+v, %v, want the key claimed", stored, err)
+	}
+	stored, err = e.svc.Payments.BeginIdempotentRequest(context.Background(), "k1", "hash", time.Now().Add(-time.Hour))
+	if err != nil || stored == nil || stored.Status != 0 {
+		t.Fatalf("BeginIdempotentRequest = %+v, %v, want the key in progress", stored, err)
+	}
+	if err := e.svc.Payments.CompleteIdempotentRequest(context.Background(), &service.IdempotentResponse{Key: "k1", Status: 201, ContentType: "application/json", Body: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err = e.svc.Payments.BeginIdempotentRequest(context.Background(), "k1", "hash", time.Now().Add(-time.Hour))
+	if err != nil || stored == nil || stored.Status != 201 || string(stored.Body) != "{}" || stored.RequestHash != "hash" {
+		t.Fatalf("BeginIdempotentRequest = %+v, %v, want the stored response", stored, err)
+	}
+
+	// Expired keys can be claimed again.
+	stored, err = e.svc.Payments.BeginIdempotentRequest(context.Background(), "k1", "other", time.Now().Add(time.Minute))
+	if err != nil || stored != nil {
+		t.Fatalf("BeginIdempotentRequest = %+v, %v, want the expired key claimed", stored, err)
+	}
+	if err := e.svc.Payments.ReleaseIdempotentRequest(context.Background(), "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := e.svc.Payments.BeginIdempotentRequest(context.Background(), "k1", "hash", time.Now().Add(-time.Hour)); err != nil || stored != nil {
+		t.Fatalf("BeginIdempotentRequest = %+v, %v, want the released key claimed", stored, err)
+	}
+}
@@ -0,0 +1,73 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"stripe_go/internal/service"
+)
+
+func (srv *Server) handleAdminInventory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	list, err := srv.svc.Payments.ListInventory(r.Context())
+	if err != nil {
+		writeError(w, r, service.InternalError("listing inventory", err))
+		return
+	}
+	writeJSON(w, list)
+}
+
+// StockRequest is the body accepted by PUT /admin/inventory/{price}.
+type StockRequest struct {
+	Stock int64 `json:"stock"`
+}
+
+func (s *StockRequest) Validate(ctx context.Context, svc *service.Service) error {
+	if s.Stock < 0 {
+		return errors.New("stock can't be negative")
+	}
+	return nil
+}
+
+func (srv *Server) handleAdminInventoryItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	parts := pathParams(r.URL.Path, "/admin/inventory/")
+	if len(parts) != 1 {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	price := parts[0]
+	if _, ok := srv.svc.CatalogFor(r.Context()).Price(price); !ok {
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown price %q", price)})
+		return
+	}
+	var req StockRequest
+	if err := srv.decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	if err := srv.svc.Payments.SetStock(r.Context(), price, req.Stock); err != nil {
+		writeError(w, r, service.InternalError("saving stock", err))
+		return
+	}
+	logFor(r).Info("stock updated", "price", price, "stock", req.Stock)
+	list, err := srv.svc.Payments.ListInventory(r.Context())
+	if err != nil {
+		writeError(w, r, service.InternalError("listing inventory", err))
+		return
+	}
+	for _, item := range list {
+		if item.Price == price {
+			writeJSON(w, item)
+			return
+		}
+	}
+}
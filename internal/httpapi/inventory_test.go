@@ -0,0 +1,198 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func (e *testEnv) inventory(price string) *service.InventoryItem {
+	e.t.Helper()
+	list, err := e.svc.Payments.ListInventory(context.Background())
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	for _, item := range list {
+		if item.Price == price {
+			return item
+		}
+	}
+	e.t.Fatalf("%s isn't tracked", price)
+	return nil
+}
+
+func sessionEvent(eventType, sessionID string) []byte {
+	return []byte(fmt.Sprintf(`{"id": "evt_%s", "object": "event", "type": %q, "data": {"object": {"id": %q, "object": "checkout.session"}}}`,
+		sessionID, eventType, sessionID))
+}
+
+func TestCheckoutReservesStock(t *testing.T) {
+	e := newTestEnv(t)
+	if err := e.svc.Payments.SetStock(context.Background(), "price_basic", 5); err != nil {
+		t.Fatal(err)
+	}
+	cart := func(quantity int64) map[string]interface{} {
+		return map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: quantity}}}
+	}
+
+	w := e.do("POST", "/create-checkout-session", cart(3))
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	expires := time.Unix(stripe.Int64Value(e.stripe.SessionParams[0].ExpiresAt), 0)
+	if d := time.Until(expires); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("session expires in %s, want the reservation TTL", d)
+	}
+	if item := e.inventory("price_basic"); item.Reserved != 3 || item.Available != 2 {
+		t.Errorf("after checkout: %+v", item)
+	}
+
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", cart(3)), http.StatusConflict, "only 2 left")
+	if len(e.stripe.SessionParams) != 1 {
+		t.Error("out of stock cart reached Stripe")
+	}
+
+	if status, body := e.deliver(sessionEvent("checkout.session.completed", resp.ID)); status != http.StatusOK {
+		t.Fatalf("completed webhook: %d %s", status, body)
+	}
+	if item := e.inventory("price_basic"); item.Stock != 2 || item.Reserved != 0 {
+		t.Errorf("after completion: %+v", item)
+	}
+}
+
+func TestExpiredCheckoutReleasesStock(t *testing.T) {
+	e := newTestEnv(t)
+	if err := e.svc.Payments.SetStock(context.Background(), "price_basic", 2); err != nil {
+		t.Fatal(err)
+	}
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 2}}})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+
+	if status, body := e.deliver(sessionEvent("checkout.session.expired", resp.ID)); status != http.StatusOK {
+		t.Fatalf("expired webhook: %d %s", status, body)
+	}
+	if item := e.inventory("price_basic"); item.Stock != 2 || item.Available != 2 {
+		t.Errorf("after expiry: %+v", item)
+	}
+}
+
+func TestAsyncPaymentKeepsReservation(t *testing.T) {
+	e := newTestEnv(t)
+	if err := e.svc.Payments.SetStock(context.Background(), "price_basic", 4); err != nil {
+		t.Fatal(err)
+	}
+	checkout := func() string {
+		w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 2}}})
+		checkStatus(t, w, http.StatusOK)
+		var resp service.CreateCheckoutResponse
+		decodeBody(t, w, &resp)
+		return resp.ID
+	}
+	event := func(eventType, id, paymentStatus string) []byte {
+		return []byte(fmt.Sprintf(`{"id": "evt_%s_%s", "object": "event", "type": %q, "data": {"object": {"id": %q, "object": "checkout.session", "payment_status": %q}}}`,
+			eventType, id, eventType, id, paymentStatus))
+	}
+
+	paid, failed := checkout(), checkout()
+	for _, id := range []string{paid, failed} {
+		if status, body := e.deliver(event("checkout.session.completed", id, "unpaid")); status != http.StatusOK {
+			t.Fatalf("completed webhook: %d %s", status, body)
+		}
+	}
+	if item := e.inventory("price_basic"); item.Stock != 4 || item.Reserved != 4 {
+		t.Errorf("awaiting payment: %+v, want the units still reserved", item)
+	}
+	e.deliver(event("checkout.session.async_payment_succeeded", paid, "paid"))
+	e.deliver(event("checkout.session.async_payment_failed", failed, "unpaid"))
+	if item := e.inventory("price_basic"); item.Stock != 2 || item.Reserved != 0 {
+		t.Errorf("after payments settled: %+v, want 2 sold and 2 returned", item)
+	}
+}
+
+func TestReservationsLapse(t *testing.T) {
+	e := newTestEnv(t)
+	if err := e.svc.Payments.SetStock(context.Background(), "price_basic", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.svc.Payments.Reserve(context.Background(), "cs_old", map[string]int64{"price_basic": 1}, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	held, err := e.svc.Payments.Reserve(context.Background(), "cs_new", map[string]int64{"price_basic": 1, "price_yen": 50}, time.Now().Add(time.Hour))
+	if err != nil || !held {
+		t.Fatalf("Reserve = %v, %v; want the lapsed units available again", held, err)
+	}
+	if held, err := e.svc.Payments.Reserve(context.Background(), "cs_yen", map[string]int64{"price_yen": 50}, time.Now().Add(time.Hour)); err != nil || held {
+		t.Errorf("untracked price: Reserve = %v, %v", held, err)
+	}
+}
+
+func TestCheckoutStripeErrorReleasesStock(t *testing.T) {
+	e := newTestEnv(t)
+	if err := e.svc.Payments.SetStock(context.Background(), "price_basic", 1); err != nil {
+		t.Fatal(err)
+	}
+	e.stripe.Err = &stripe.Error{HTTPStatusCode: http.StatusBadRequest, Msg: "Invalid request"}
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}}), http.StatusBadRequest)
+	if item := e.inventory("price_basic"); item.Reserved != 0 {
+		t.Errorf("failed session still holds stock: %+v", item)
+	}
+}
+
+func TestUntrackedPriceHasNoReservation(t *testing.T) {
+	e := newTestEnv(t)
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 10}}}), http.StatusOK)
+	expires := time.Unix(stripe.Int64Value(e.stripe.SessionParams[0].ExpiresAt), 0)
+	if d := time.Until(expires); d < 23*time.Hour {
+		t.Errorf("session without tracked stock expires in %s, want CHECKOUT_SESSION_TTL", d)
+	}
+}
+
+func TestAdminInventory(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.admin("PUT", "/admin/inventory/price_basic", StockRequest{Stock: 7})
+	checkStatus(t, w, http.StatusOK)
+	var item service.InventoryItem
+	decodeBody(t, w, &item)
+	if item.Price != "price_basic" || item.Available != 7 {
+		t.Errorf("PUT response = %+v", item)
+	}
+
+	w = e.admin("GET", "/admin/inventory", nil)
+	checkStatus(t, w, http.StatusOK)
+	var list []*service.InventoryItem
+	decodeBody(t, w, &list)
+	if len(list) != 1 || list[0].Stock != 7 {
+		t.Errorf("inventory = %+v", list)
+	}
+
+	checkErrorMessage(t, e.admin("PUT", "/admin/inventory/price_nope", StockRequest{Stock: 1}), http.StatusBadRequest, "unknown price")
+	checkErrorMessage(t, e.admin("PUT", "/admin/inventory/price_basic", StockRequest{Stock: -1}), http.StatusBadRequest, "negative")
+	checkStatus(t, e.admin("POST", "/admin/inventory", nil), http.StatusMethodNotAllowed)
+	checkStatus(t, e.do("GET", "/admin/inventory", nil), http.StatusUnauthorized)
+}
+
+func TestSeedInventory(t *testing.T) {
+	e := newTestEnv(t)
+	if err := e.svc.Payments.SetStock(context.Background(), "price_basic", 3); err != nil {
+		t.Fatal(err)
+	}
+	e.svc.Config.Inventory = map[string]int64{"price_basic": 100, "price_yen": 20}
+	if err := e.svc.SeedInventory(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	list, err := e.svc.Payments.ListInventory(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Stock != 3 || list[1].Stock != 20 {
+		t.Errorf("inventory = %+v, %+v; want the tracked stock kept and the new price seeded", list[0], list[1])
+	}
+}
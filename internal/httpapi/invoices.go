@@ -0,0 +1,117 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleAdminInvoices serves GET /admin/invoices, soonest due date first,
+// and POST /admin/invoices to create a draft invoice. The list holds the
+// outstanding (open) invoices unless status is given, or status=all; it
+// also takes customer, overdue=true, limit and offset.
+func (srv *Server) handleAdminInvoices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		srv.listInvoices(w, r)
+	case "POST":
+		var req service.InvoiceRequest
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+		inv, err := srv.svc.CreateInvoice(r.Context(), &req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, inv)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (srv *Server) listInvoices(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := service.InvoiceFilter{Status: q.Get("status"), CustomerID: q.Get("customer"), Limit: service.DefaultPageSize}
+	switch f.Status {
+	case "":
+		f.Status = string(stripe.InvoiceStatusOpen)
+	case "all":
+		f.Status = ""
+	}
+	var err error
+	if v := q.Get("overdue"); v != "" {
+		overdue, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid overdue %q", v)})
+			return
+		}
+		if overdue {
+			f.DueBefore = time.Now()
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > service.MaxPageSize {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", service.MaxPageSize)})
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid offset %q", v)})
+			return
+		}
+	}
+	list, hasMore, err := srv.svc.ListInvoices(r.Context(), f)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, struct {
+		Invoices []*service.Invoice `json:"invoices"`
+		Limit    int                `json:"limit"`
+		Offset   int                `json:"offset"`
+		HasMore  bool               `json:"hasMore"`
+	}{list, f.Limit, f.Offset, hasMore})
+}
+
+// handleAdminInvoice serves GET /admin/invoices/{id},
+// POST /admin/invoices/{id}/finalize, which turns a draft into an open
+// invoice that can no longer be edited, and POST /admin/invoices/{id}/send,
+// which finalizes a draft if needed and emails the invoice to the customer.
+func (srv *Server) handleAdminInvoice(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/admin/invoices/")
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	if len(parts) == 0 || len(parts) > 2 || (len(parts) == 2 && action != "finalize" && action != "send") {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if (action == "" && r.Method != "GET") || (action != "" && r.Method != "POST") {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var inv *service.Invoice
+	var err error
+	switch action {
+	case "":
+		inv, err = srv.svc.GetInvoice(r.Context(), parts[0])
+	case "finalize":
+		inv, err = srv.svc.FinalizeInvoice(r.Context(), parts[0])
+	case "send":
+		inv, err = srv.svc.SendInvoice(r.Context(), parts[0])
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, inv)
+}
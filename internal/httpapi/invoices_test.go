@@ -0,0 +1,153 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// invoiceEvent is an eventType event for invoice id, now in status.
+func invoiceEvent(eventID, eventType, id, status string, amountPaid int64) []byte {
+	return []byte(fmt.Sprintf(`{"id": %q, "object": "event", "type": %q, "created": 1700000000, "data": {"object": {
+		"id": %q, "object": "invoice", "customer": "cus_b2b", "number": "INV-0001", "status": %q,
+		"currency": "usd", "total": 110000, "amount_due": 110000, "amount_paid": %d, "attempt_count": 1}}}`,
+		eventID, eventType, id, status, amountPaid))
+}
+
+func (e *testEnv) outstandingInvoices(query string) []*service.Invoice {
+	e.t.Helper()
+	w := e.admin("GET", "/admin/invoices"+query, nil)
+	checkStatus(e.t, w, http.StatusOK)
+	var resp struct {
+		Invoices []*service.Invoice `json:"invoices"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		e.t.Fatal(err)
+	}
+	return resp.Invoices
+}
+
+func TestInvoiceRequestValidation(t *testing.T) {
+	e := newTestEnv(t)
+	items := []service.InvoiceLine{{Description: "Consulting", UnitAmount: 50000}}
+	for _, tt := range []struct {
+		name string
+		req  service.InvoiceRequest
+		want string
+	}{
+		{"no customer", service.InvoiceRequest{Currency: "usd", Items: items}, "invalid customer"},
+		{"bad currency", service.InvoiceRequest{Customer: "cus_b2b", Currency: "dollars", Items: items}, "invalid currency"},
+		{"no items", service.InvoiceRequest{Customer: "cus_b2b", Currency: "usd"}, "between 1 and 250 items"},
+		{"no description", service.InvoiceRequest{Customer: "cus_b2b", Currency: "usd", Items: []service.InvoiceLine{{UnitAmount: 100}}}, "items[0]: description"},
+		{"free item", service.InvoiceRequest{Customer: "cus_b2b", Currency: "usd", Items: []service.InvoiceLine{{Description: "Setup"}}}, "unitAmount must be positive"},
+		{"past due date", service.InvoiceRequest{Customer: "cus_b2b", Currency: "usd", Items: items, DueDate: time.Now().Add(-time.Hour)}, "dueDate must be in the future"},
+		{"both due dates", service.InvoiceRequest{Customer: "cus_b2b", Currency: "usd", Items: items, DueDate: time.Now().Add(time.Hour), DaysUntilDue: 14}, "not both"},
+		{"due too late", service.InvoiceRequest{Customer: "cus_b2b", Currency: "usd", Items: items, DaysUntilDue: 400}, "daysUntilDue must be between 1 and 365"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErrorMessage(t, e.admin("POST", "/admin/invoices", tt.req), http.StatusBadRequest, tt.want)
+		})
+	}
+	if len(e.stripe.InvoiceParams) != 0 {
+		t.Errorf("created %d invoices from invalid requests", len(e.stripe.InvoiceParams))
+	}
+}
+
+func TestInvoiceLifecycle(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Customers["cus_b2b"] = &stripe.Customer{ID: "cus_b2b", Email: "ap@acme.example"}
+
+	w := e.admin("POST", "/admin/invoices", service.InvoiceRequest{
+		Customer: "cus_b2b",
+		Currency: "USD",
+		Items: []service.InvoiceLine{
+			{Description: "Implementation, days", UnitAmount: 50000, Quantity: 2},
+			{Description: "Support plan", UnitAmount: 10000},
+		},
+		Memo: "PO 4711",
+	})
+	checkStatus(t, w, http.StatusOK)
+	var inv service.Invoice
+	if err := json.NewDecoder(w.Body).Decode(&inv); err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != "draft" || inv.Total != 110000 || inv.CustomerEmail != "ap@acme.example" || inv.Memo != "PO 4711" {
+		t.Errorf("created invoice = %+v", inv)
+	}
+	params := e.stripe.InvoiceParams[0]
+	if *params.CollectionMethod != "send_invoice" || *params.PendingInvoiceItemsBehavior != "exclude" ||
+		*params.DaysUntilDue != service.DefaultInvoiceDaysUntilDue || *params.Currency != "usd" {
+		t.Errorf("invoice params = %+v", params)
+	}
+	for _, item := range e.stripe.InvoiceItemParams {
+		if stripe.StringValue(item.Invoice) != inv.ID {
+			t.Errorf("item %q added to %q, not the new invoice", *item.Description, stripe.StringValue(item.Invoice))
+		}
+	}
+	if got := e.outstandingInvoices(""); len(got) != 0 {
+		t.Errorf("a draft is outstanding: %+v", got)
+	}
+	if got := e.outstandingInvoices("?status=draft"); len(got) != 1 || got[0].ID != inv.ID {
+		t.Errorf("drafts = %+v", got)
+	}
+
+	// Sending a draft finalizes it first.
+	w = e.admin("POST", "/admin/invoices/"+inv.ID+"/send", nil)
+	checkStatus(t, w, http.StatusOK)
+	if err := json.NewDecoder(w.Body).Decode(&inv); err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != "open" || inv.Number == "" || inv.SentAt == nil || inv.DueDate == nil || inv.DueDate.Before(time.Now().AddDate(0, 0, 29)) {
+		t.Errorf("sent invoice = %+v", inv)
+	}
+	checkErrorMessage(t, e.admin("POST", "/admin/invoices/"+inv.ID+"/finalize", nil), http.StatusConflict, "only drafts can be finalized")
+	checkStatus(t, e.admin("POST", "/admin/invoices/"+inv.ID+"/void", nil), http.StatusNotFound)
+	checkStatus(t, e.admin("GET", "/admin/invoices/in_missing", nil), http.StatusNotFound)
+	if got := e.outstandingInvoices(""); len(got) != 1 || got[0].ID != inv.ID {
+		t.Errorf("outstanding = %+v", got)
+	}
+	if got := e.outstandingInvoices("?overdue=true"); len(got) != 0 {
+		t.Errorf("overdue = %+v", got)
+	}
+	if audit := e.audit("?action=invoice.sent"); len(audit) != 1 {
+		t.Errorf("audit = %+v", audit)
+	}
+
+	e.deliverOK(invoiceEvent("evt_inv_1", "invoice.payment_failed", inv.ID, "open", 0))
+	e.deliverOK(invoiceEvent("evt_inv_2", "invoice.paid", inv.ID, "paid", 110000))
+	// A finalized event delivered late doesn't reopen the invoice.
+	e.deliverOK(invoiceEvent("evt_inv_3", "invoice.finalized", inv.ID, "open", 0))
+	w = e.admin("GET", "/admin/invoices/"+inv.ID, nil)
+	checkStatus(t, w, http.StatusOK)
+	var paid service.Invoice
+	if err := json.NewDecoder(w.Body).Decode(&paid); err != nil {
+		t.Fatal(err)
+	}
+	if paid.Status != "paid" || paid.AmountPaid != 110000 || paid.SentAt == nil {
+		t.Errorf("paid invoice = %+v", paid)
+	}
+	if got := e.outstandingInvoices(""); len(got) != 0 {
+		t.Errorf("outstanding after payment = %+v", got)
+	}
+
+	e.runJobs()
+	var subjects []string
+	for _, msg := range e.emails.Sent {
+		subjects = append(subjects, msg.Subject)
+	}
+	if len(subjects) != 2 || subjects[0] != "Invoice payment failed: 1100.00 USD" || subjects[1] != "Invoice paid: 1100.00 USD" {
+		t.Errorf("notifications = %q", subjects)
+	}
+
+	// Events of invoices created elsewhere aren't stored.
+	e.deliverOK(invoiceEvent("evt_inv_4", "invoice.paid", "in_dashboard", "paid", 110000))
+	if got := e.outstandingInvoices("?status=all"); len(got) != 1 {
+		t.Errorf("stored %d invoices, want only the one created here", len(got))
+	}
+}
@@ -0,0 +1,30 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"stripe_go/internal/service"
+)
+
+// handleAdminJobs serves GET /admin/jobs, listing pending and dead-lettered
+// jobs, and POST /admin/jobs?retry={id} to requeue a dead job.
+func (srv *Server) handleAdminJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		pending, dead := srv.svc.Jobs.Snapshot()
+		writeJSON(w, struct {
+			Pending []service.Job `json:"pending"`
+			Dead    []service.Job `json:"dead"`
+		}{pending, dead})
+	case "POST":
+		id := r.URL.Query().Get("retry")
+		if !srv.svc.Jobs.Retry(id) {
+			writeError(w, r, &service.Error{Status: http.StatusNotFound, Message: fmt.Sprintf("no dead job %q", id)})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"success": true})
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+func TestJobQueuePersistsInDatabase(t *testing.T) {
+	e := newTestEnv(t)
+	c := &config.Config{JobQueueBackend: "database", JobMaxAttempts: 3, JobRetryBackoff: time.Second}
+	q, err := service.NewJobQueueFromConfig(c, e.svc.Payments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := q.Snapshot(); len(pending) != 0 {
+		t.Fatalf("new queue has %d jobs", len(pending))
+	}
+	if err := q.Enqueue(context.Background(), "email", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := service.NewJobQueueFromConfig(c, e.svc.Payments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := reloaded.Snapshot()
+	if len(pending) != 1 || pending[0].Type != "email" || string(pending[0].Payload) != `"hello"` {
+		t.Errorf("reloaded pending = %+v", pending)
+	}
+}
+
+func TestAdminJobs(t *testing.T) {
+	e := newTestEnv(t)
+	e.emails.Err = errors.New("smtp down")
+	if err := e.svc.Jobs.Enqueue(context.Background(), service.JobSendConfirmationEmail, &service.Receipt{Email: "a@example.com", Amount: 100, Currency: "usd"}); err != nil {
+		t.Fatal(err)
+	}
+	e.runJobs()
+
+	w := e.admin("GET", "/admin/jobs", nil)
+	checkStatus(t, w, http.StatusOK)
+	var got struct {
+		Pending []service.Job `json:"pending"`
+		Dead    []service.Job `json:"dead"`
+	}
+	decodeBody(t, w, &got)
+	if len(got.Dead) != 1 {
+		t.Fatalf("dead jobs = %+v", got.Dead)
+	}
+
+	e.emails.Err = nil
+	checkStatus(t, e.admin("POST", "/admin/jobs?retry="+got.Dead[0].ID, nil), http.StatusOK)
+	e.runJobs()
+	if len(e.emails.Sent) != 1 {
+		t.Errorf("sent %d emails after retry, want 1", len(e.emails.Sent))
+	}
+	checkStatus(t, e.admin("POST", "/admin/jobs?retry=nope", nil), http.StatusNotFound)
+}
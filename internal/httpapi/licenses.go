@@ -0,0 +1,65 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"stripe_go/internal/service"
+)
+
+// The statuses GET /licenses/{key}/verify reports. A license is revoked
+// when its order is refunded.
+const (
+	licenseActive  = "active"
+	licenseRevoked = "revoked"
+)
+
+// handleLicenseVerify serves GET /licenses/{key}/verify for desktop apps
+// checking a purchase. Keys that weren't issued answer 404.
+func (srv *Server) handleLicenseVerify(w http.ResponseWriter, r *http.Request) {
+	if srv.svc.Config.LicenseSigningKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	parts := pathParams(r.URL.Path, "/licenses/")
+	if len(parts) != 2 || parts[1] != "verify" {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	key, err := srv.svc.VerifyLicenseKey(parts[0])
+	if err != nil {
+		writeError(w, r, service.ErrLicenseNotFound)
+		return
+	}
+	l, err := srv.svc.Payments.GetLicense(r.Context(), key)
+	if err == service.ErrLicenseNotFound {
+		writeError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, r, service.InternalError("fetching license", err))
+		return
+	}
+	o, err := srv.svc.Payments.GetOrder(r.Context(), l.OrderID)
+	if err != nil {
+		writeError(w, r, service.InternalError("fetching order", err))
+		return
+	}
+	v := &service.LicenseVerification{
+		Key:         l.Key,
+		Valid:       o.Status == service.OrderPaid || o.Status == service.OrderFulfilled,
+		Status:      licenseActive,
+		Product:     l.Product,
+		ProductName: srv.svc.CatalogFor(r.Context()).ProductName(l.Product),
+		Seats:       l.Quantity,
+		IssuedAt:    l.CreatedAt,
+	}
+	if !v.Valid {
+		v.Status = licenseRevoked
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, v)
+}
@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"stripe_go/internal/service"
+)
+
+const testLicenseKey = "license-key-0123456789abcdef012345"
+
+func (e *testEnv) verifyLicense(key string) *service.LicenseVerification {
+	e.t.Helper()
+	w := e.do("GET", "/licenses/"+key+"/verify", nil)
+	checkStatus(e.t, w, http.StatusOK)
+	var v service.LicenseVerification
+	decodeBody(e.t, w, &v)
+	return &v
+}
+
+func TestLicenseKeys(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.LicenseSigningKey = testLicenseKey
+	key := e.svc.LicenseKey("ord_1", "price_basic")
+	if len(key) != 35 || strings.Count(key, "-") != 3 {
+		t.Fatalf("key = %q", key)
+	}
+	if key != e.svc.LicenseKey("ord_1", "price_basic") || key == e.svc.LicenseKey("ord_2", "price_basic") || key == e.svc.LicenseKey("ord_1", "price_yen") {
+		t.Error("keys aren't one per order line")
+	}
+	for _, k := range []string{key, strings.ToLower(key), strings.ReplaceAll(key, "-", "")} {
+		if got, err := e.svc.VerifyLicenseKey(k); err != nil || got != key {
+			t.Errorf("verifyLicenseKey(%q) = %q, %v", k, got, err)
+		}
+	}
+	forged := []byte(key)
+	forged[0] ^= 'A' ^ 'B'
+	for _, k := range []string{string(forged), key[:30], "not-a-key", ""} {
+		if _, err := e.svc.VerifyLicenseKey(k); err == nil {
+			t.Errorf("verifyLicenseKey(%q) accepted", k)
+		}
+	}
+	e.svc.Config.LicenseSigningKey = strings.Repeat("k", 32)
+	if _, err := e.svc.VerifyLicenseKey(key); err == nil {
+		t.Error("key verified under another signing key")
+	}
+}
+
+func TestLicenseDelivery(t *testing.T) {
+	e := newTestEnv(t)
+	e.fulfillmentRoutes("price_yen=license")
+	resp := e.paidCheckout(service.CheckoutItem{Price: "price_basic", Quantity: 1}, service.CheckoutItem{Price: "price_yen", Quantity: 3})
+	key := e.svc.LicenseKey(resp.OrderID, "price_yen")
+
+	var receipt *service.EmailMessage
+	for _, msg := range e.emails.Sent {
+		if msg.To == "jenny@example.com" {
+			receipt = msg
+		}
+	}
+	if receipt == nil || !strings.Contains(receipt.Text, key) || !strings.Contains(receipt.HTML, key) || !strings.Contains(receipt.Text, "(3 seats)") {
+		t.Fatalf("confirmation email = %+v", receipt)
+	}
+	if strings.Contains(receipt.Text, e.svc.LicenseKey(resp.OrderID, "price_basic")) {
+		t.Error("email has a key for a line without a license route")
+	}
+
+	v := e.verifyLicense(strings.ToLower(key))
+	if v.Key != key || !v.Valid || v.Status != licenseActive || v.Product != "prod_basic" || v.ProductName != "Basic" || v.Seats != 3 || v.IssuedAt.IsZero() {
+		t.Errorf("verification = %+v", v)
+	}
+	checkErrorMessage(t, e.do("GET", "/licenses/"+e.svc.LicenseKey(resp.OrderID, "price_basic")+"/verify", nil), http.StatusNotFound, "license not found")
+	checkErrorMessage(t, e.do("GET", "/licenses/AAAAAAAA-AAAAAAAA-AAAAAAAA-AAAAAAAA/verify", nil), http.StatusNotFound, "license not found")
+	checkStatus(t, e.do("POST", "/licenses/"+key+"/verify", nil), http.StatusMethodNotAllowed)
+
+	// Refunds revoke the license.
+	o := e.order(resp.OrderID)
+	if err := o.Transition(service.OrderRefunded); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.svc.Payments.SaveOrder(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	if v := e.verifyLicense(key); v.Valid || v.Status != licenseRevoked {
+		t.Errorf("verification after refund = %+v", v)
+	}
+
+	e.svc.Config.LicenseSigningKey = ""
+	checkStatus(t, e.do("GET", "/licenses/"+key+"/verify", nil), http.StatusNotFound)
+}
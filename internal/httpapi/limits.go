@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// handleAdminPriceLimits serves GET /admin/price-limits, the quantity bounds
+// in effect for prices that don't use 1 to MAX_QUANTITY.
+func (srv *Server) handleAdminPriceLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	limits, err := srv.svc.PriceLimits(r.Context())
+	if err != nil {
+		writeError(w, r, service.InternalError("listing price limits", err))
+		return
+	}
+	list := []config.PriceLimit{}
+	for _, l := range limits {
+		list = append(list, l)
+	}
+	slices.SortFunc(list, func(a, b config.PriceLimit) int { return strings.Compare(a.Price, b.Price) })
+	writeJSON(w, list)
+}
+
+// handleAdminPriceLimit serves PUT /admin/price-limits/{price}, which sets
+// the price's bounds in the database, and DELETE, which removes them so
+// PRICE_LIMITS or MAX_QUANTITY apply again. Both answer with the bounds
+// now in effect.
+func (srv *Server) handleAdminPriceLimit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" && r.Method != "DELETE" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	parts := pathParams(r.URL.Path, "/admin/price-limits/")
+	if len(parts) != 1 {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	price := parts[0]
+	if r.Method == "DELETE" {
+		err := srv.svc.Payments.DeletePriceLimit(r.Context(), price)
+		if err == service.ErrPriceLimitNotFound {
+			writeError(w, r, err)
+			return
+		}
+		if err != nil {
+			writeError(w, r, service.InternalError("deleting price limit", err))
+			return
+		}
+		logFor(r).Info("price limit removed", "price", price)
+		limits, err := srv.svc.PriceLimits(r.Context())
+		if err != nil {
+			writeError(w, r, service.InternalError("listing price limits", err))
+			return
+		}
+		writeJSON(w, srv.svc.PriceLimit(limits, price))
+		return
+	}
+	if _, ok := srv.svc.CatalogFor(r.Context()).Price(price); !ok {
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown price %q", price)})
+		return
+	}
+	var l config.PriceLimit
+	if err := srv.decodeJSON(w, r, &l); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	if err := l.Validate(); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	l.Price, l.Source = price, "database"
+	if err := srv.svc.Payments.SetPriceLimit(r.Context(), &l); err != nil {
+		writeError(w, r, service.InternalError("saving price limit", err))
+		return
+	}
+	logFor(r).Info("price limit updated", "price", price, "min", l.MinQuantity, "max", l.MaxQuantity)
+	writeJSON(w, l)
+}
@@ -0,0 +1,65 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+func TestCheckoutPurchaseLimits(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.PriceLimits = map[string]config.PriceLimit{"price_basic": {Price: "price_basic", MinQuantity: 2, MaxQuantity: 20}}
+	e.svc.Config.CheckoutAmountLimits = map[string]config.AmountLimit{"usd": {Min: 2000, Max: 25000}}
+	checkout := func(items ...service.CheckoutItem) *httptest.ResponseRecorder {
+		return e.do("POST", "/create-checkout-session", map[string]interface{}{"items": items})
+	}
+
+	checkErrorMessage(t, checkout(service.CheckoutItem{Price: "price_basic", Quantity: 1}), http.StatusBadRequest, `price "price_basic": quantity must be between 2 and 20`)
+	// PRICE_LIMITS replace MAX_QUANTITY for the price.
+	checkStatus(t, checkout(service.CheckoutItem{Price: "price_basic", Quantity: 15}), http.StatusOK)
+	checkErrorMessage(t, checkout(service.CheckoutItem{Price: "price_basic", Quantity: 20}), http.StatusBadRequest, "above the maximum")
+	e.svc.Config.MaxCartQuantity = 16
+	checkErrorMessage(t, checkout(service.CheckoutItem{Price: "price_basic", Quantity: 9}, service.CheckoutItem{Price: "price_basic", Quantity: 8}), http.StatusBadRequest, "cart has 17 units; at most 16")
+
+	e.svc.Config.CheckoutAmountLimits = map[string]config.AmountLimit{"usd": {Min: 5000}}
+	checkErrorMessage(t, checkout(service.CheckoutItem{Price: "price_basic", Quantity: 2}), http.StatusBadRequest, "below the minimum")
+
+	// Limits set through the admin API take precedence over PRICE_LIMITS.
+	e.svc.Config.CheckoutAmountLimits = nil
+	checkErrorMessage(t, e.admin("PUT", "/admin/price-limits/price_basic", config.PriceLimit{MinQuantity: 3, MaxQuantity: 1}), http.StatusBadRequest, "minQuantity <= maxQuantity")
+	checkErrorMessage(t, e.admin("PUT", "/admin/price-limits/price_nope", config.PriceLimit{MinQuantity: 1, MaxQuantity: 1}), http.StatusBadRequest, "unknown price")
+	w := e.admin("PUT", "/admin/price-limits/price_basic", config.PriceLimit{MinQuantity: 1, MaxQuantity: 3})
+	checkStatus(t, w, http.StatusOK)
+	checkErrorMessage(t, checkout(service.CheckoutItem{Price: "price_basic", Quantity: 4}), http.StatusBadRequest, "between 1 and 3")
+	checkStatus(t, checkout(service.CheckoutItem{Price: "price_basic", Quantity: 1}), http.StatusOK)
+
+	var list []config.PriceLimit
+	decodeBody(t, e.admin("GET", "/admin/price-limits", nil), &list)
+	if len(list) != 1 || list[0].Source != "database" || list[0].MaxQuantity != 3 || list[0].UpdatedAt == nil {
+		t.Errorf("limits = %+v, want price_basic's from the database", list)
+	}
+
+	var l config.PriceLimit
+	decodeBody(t, e.admin("DELETE", "/admin/price-limits/price_basic", nil), &l)
+	if l.Source != "config" || l.MinQuantity != 2 || l.MaxQuantity != 20 {
+		t.Errorf("limit after delete = %+v, want PRICE_LIMITS'", l)
+	}
+	checkStatus(t, e.admin("DELETE", "/admin/price-limits/price_basic", nil), http.StatusNotFound)
+}
+
+func TestAdjustableQuantityWithinPriceLimits(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.AdjustableQuantity, e.svc.Config.AdjustableQuantityMin, e.svc.Config.AdjustableQuantityMax = true, 1, 10
+	e.svc.Config.PriceLimits = map[string]config.PriceLimit{"price_basic": {Price: "price_basic", MinQuantity: 2, MaxQuantity: 4}}
+
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 3}},
+	}), http.StatusOK)
+	aq := e.stripe.SessionParams[0].LineItems[0].AdjustableQuantity
+	if *aq.Minimum != 2 || *aq.Maximum != 4 {
+		t.Errorf("adjustable quantity = %d to %d, want 2 to 4", *aq.Minimum, *aq.Maximum)
+	}
+}
@@ -0,0 +1,50 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"stripe_go/internal/service"
+)
+
+func TestCheckoutSessionLocale(t *testing.T) {
+	e := newTestEnv(t)
+	items := []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}
+
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": items}, "Accept-Language", "de-AT,de;q=0.9")
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	params := e.stripe.SessionParams[0]
+	if *params.Locale != "de" || !strings.Contains(*params.SuccessURL, "&locale=de") || !strings.HasSuffix(*params.CancelURL, "?locale=de") {
+		t.Errorf("locale %q, success URL %q, cancel URL %q", *params.Locale, *params.SuccessURL, *params.CancelURL)
+	}
+
+	// The receipt is written in the session's locale.
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_de", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": "pi_de", "payment_status": "paid", "locale": "de",
+		"amount_total": 1500, "currency": "eur", "customer_details": {"email": "kunde@example.com"},
+		"metadata": {"order": %q}}}}`, resp.ID, resp.OrderID)))
+	if i := slices.IndexFunc(e.emails.Sent, func(m *service.EmailMessage) bool { return m.To == "kunde@example.com" }); i < 0 || e.emails.Sent[i].Subject != "Ihr Zahlungsbeleg" {
+		t.Errorf("emails = %+v, want a German receipt", e.emails.Sent)
+	}
+
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": items, "locale": "FR"}, "Accept-Language", "de"), http.StatusOK)
+	if l := e.stripe.SessionParams[1].Locale; *l != "fr" {
+		t.Errorf("explicit locale = %q, want fr", *l)
+	}
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": items}), http.StatusOK)
+	if l := e.stripe.SessionParams[2].Locale; l != nil {
+		t.Errorf("locale without Accept-Language = %q, want Checkout's default", *l)
+	}
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": items, "locale": "tlh"}), http.StatusBadRequest, `unsupported locale "tlh"`)
+
+	var cfg service.ConfigResponse
+	decodeBody(t, e.do("GET", "/config", nil, "Accept-Language", "fr-CA,fr;q=0.8"), &cfg)
+	if cfg.Locale != "fr-CA" || !slices.Contains(cfg.Locales, "pt-BR") {
+		t.Errorf("config locale %q, locales %v", cfg.Locale, cfg.Locales)
+	}
+}
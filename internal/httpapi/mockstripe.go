@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+	"stripe_go/internal/stripeclient"
+)
+
+var mockCheckoutTemplate = template.Must(template.New("checkout").Funcs(template.FuncMap{"money": service.FormatAmount}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Mock Checkout</title>
+<link rel="stylesheet" href="/css/normalize.css"><link rel="stylesheet" href="/css/global.css"></head>
+<body><div class="sr-root"><div class="sr-main">
+<h1>Mock Checkout</h1>
+<p>This server runs with MODE=mock; no money moves.</p>
+<table>{{range .Items}}<tr><td>{{.Quantity}} × {{.Description}}</td><td>{{money .AmountTotal (printf "%s" .Currency)}}</td></tr>{{end}}
+<tr><th>Total</th><th>{{money .Session.AmountTotal (printf "%s" .Session.Currency)}}</th></tr></table>
+<form method="post" action="{{.PayURL}}">
+<input type="email" name="email" placeholder="Email" value="{{.Email}}" required>
+<button type="submit">Pay</button>
+</form>
+{{if .Session.CancelURL}}<p><a href="{{.Session.CancelURL}}">Cancel</a></p>{{end}}
+</div></div></body>
+</html>
+`))
+
+// handleMockCheckout serves the mock's Checkout page: GET /mock/checkout/{id}
+// shows the session and POST /mock/checkout/{id}/pay pays it and redirects
+// to its success URL.
+func (srv *Server) handleMockCheckout(w http.ResponseWriter, r *http.Request) {
+	m, ok := srv.svc.Stripe.(*stripeclient.Mock)
+	parts := pathParams(r.URL.Path, config.MockCheckoutPath)
+	if !ok || len(parts) == 0 || len(parts) > 2 || (len(parts) == 2 && parts[1] != "pay") {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if (len(parts) == 1 && r.Method != "GET") || (len(parts) == 2 && r.Method != "POST") {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if len(parts) == 2 {
+		r.ParseForm()
+		s, err := m.CompleteCheckoutSession(r.Context(), parts[0], r.PostFormValue("email"))
+		if err != nil {
+			writeError(w, r, &service.StripeFailure{Action: "completing checkout session", Err: err})
+			return
+		}
+		logFor(r).Info("mock checkout session completed", "session", s.ID)
+		http.Redirect(w, r, s.SuccessURL, http.StatusSeeOther)
+		return
+	}
+	s, err := m.GetCheckoutSession(r.Context(), parts[0], nil)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "fetching checkout session", Err: err})
+		return
+	}
+	items, _ := m.ListCheckoutSessionLineItems(r.Context(), s.ID, nil)
+	email := s.CustomerEmail
+	if s.Customer != nil && email == "" {
+		email = s.Customer.Email
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = mockCheckoutTemplate.Execute(w, map[string]interface{}{
+		"Session": s,
+		"Items":   items,
+		"Email":   email,
+		"PayURL":  config.MockCheckoutPath + s.ID + "/pay",
+	})
+	if err != nil {
+		slog.Error("rendering mock checkout page", "error", err)
+	}
+}
@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+	"stripe_go/internal/stripeclient"
+)
+
+// useMockStripe switches e to MODE=mock. The server is served over HTTP at
+// DOMAIN, where the mock delivers its webhooks.
+func (e *testEnv) useMockStripe() {
+	e.t.Helper()
+	e.svc.Config.Mode, e.svc.Config.Price = "mock", config.MockPrice
+	e.svc.Stripe = stripeclient.NewMock(e.svc.Config)
+	e.svc.Catalog = service.NewCatalog()
+	if err := e.svc.Catalog.Load(context.Background(), e.svc.Stripe); err != nil {
+		e.t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	e.srv.registerRoutes(mux)
+	e.handler = withRequestLogging(e.srv.withTenantRouting(e.srv.withRequestLimits(mux)))
+	srv := httptest.NewServer(e.handler)
+	e.t.Cleanup(srv.Close)
+	e.svc.Config.Domain = srv.URL
+}
+
+func TestMockStripeCheckout(t *testing.T) {
+	e := newTestEnv(t)
+	e.useMockStripe()
+
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: config.MockPrice, Quantity: 2}}})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	if resp.URL != e.svc.Config.Domain+config.MockCheckoutPath+resp.ID {
+		t.Errorf("checkout URL = %s, want the mock's Checkout page", resp.URL)
+	}
+	page := e.do("GET", config.MockCheckoutPath+resp.ID, nil)
+	checkStatus(t, page, http.StatusOK)
+	if !strings.Contains(page.Body.String(), "2 × Basic") {
+		t.Errorf("checkout page:\n%s", page.Body)
+	}
+
+	w = e.do("POST", config.MockCheckoutPath+resp.ID+"/pay", url.Values{"email": {"demo@example.com"}})
+	checkStatus(t, w, http.StatusSeeOther)
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "session_id="+resp.ID) {
+		t.Errorf("redirect = %s, want the success URL", loc)
+	}
+	// The signed webhooks arrived before the redirect.
+	e.runJobs()
+	if o := e.order(resp.OrderID); o.Status != service.OrderPaid || o.Amount != 3000 || o.Email != "demo@example.com" {
+		t.Errorf("order = %+v, want paid", o)
+	}
+	p := e.payment(resp.ID)
+	if p.Status != "paid" {
+		t.Errorf("payment status = %s, want paid", p.Status)
+	}
+	if len(e.emails.Sent) == 0 || e.emails.Sent[0].To != "demo@example.com" {
+		t.Errorf("emails = %+v, want the receipt", e.emails.Sent)
+	}
+	checkErrorMessage(t, e.do("POST", config.MockCheckoutPath+resp.ID+"/pay", url.Values{}), http.StatusBadRequest, "Checkout Session is complete")
+
+	checkStatus(t, e.admin("POST", "/refunds", service.RefundRequest{PaymentIntentID: p.PaymentIntentID}), http.StatusOK)
+	if p := e.payment(resp.ID); p.Status != "refunded" {
+		t.Errorf("payment status = %s after the refund, want refunded", p.Status)
+	}
+
+	checkErrorMessage(t, e.admin("POST", "/payment-links", service.PaymentLinkRequest{Price: config.MockPrice, Quantity: 1}), http.StatusBadRequest, "isn't available with MODE=mock")
+	checkStatus(t, e.do("GET", config.MockCheckoutPath+"cs_test_nope", nil), http.StatusNotFound)
+}
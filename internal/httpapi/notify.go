@@ -0,0 +1,25 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"stripe_go/internal/config"
+)
+
+// notifySignatureFailure raises webhook.signature_failed for a webhook
+// delivery that didn't verify, unless Service.SignatureAlertDue holds it back.
+func (srv *Server) notifySignatureFailure(r *http.Request, verifyErr error) {
+	suppressed, due := srv.svc.SignatureAlertDue(time.Now())
+	if !due {
+		return
+	}
+	lines := []string{"Error: " + verifyErr.Error(), "Client: " + srv.clientIP(r)}
+	if suppressed > 0 {
+		lines = append(lines, fmt.Sprintf("%d more failures since the last alert", suppressed))
+	}
+	if err := srv.svc.NotifyOps(r.Context(), config.NotifyWebhookSignatureFailed, "Webhook signature verification failed", lines...); err != nil {
+		logFor(r).Error("queueing signature failure notification", "error", err)
+	}
+}
@@ -0,0 +1,115 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"stripe_go/internal/config"
+)
+
+func TestSendNotification(t *testing.T) {
+	e := newTestEnv(t)
+	if err := e.svc.SendNotification(context.Background(), &config.Notification{Subject: "Payment failed", Lines: []string{"<b>declined</b>"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.emails.Sent) != 1 || e.emails.Sent[0].To != "ops@example.com" {
+		t.Fatalf("sent %+v", e.emails.Sent)
+	}
+	if html := e.emails.Sent[0].HTML; !strings.Contains(html, "&lt;b&gt;declined&lt;/b&gt;") {
+		t.Errorf("notification isn't escaped:\n%s", html)
+	}
+
+	e.svc.Config.NotifyEmail = ""
+	if err := e.svc.SendNotification(context.Background(), &config.Notification{Subject: "Payment failed"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.emails.Sent) != 1 {
+		t.Error("emailed a notification without NOTIFY_EMAIL")
+	}
+}
+
+// chatWebhook records the messages posted to a Slack or Discord webhook.
+type chatWebhook struct {
+	mu     sync.Mutex
+	posts  map[string][]map[string]interface{}
+	status int
+}
+
+func newChatWebhook(t *testing.T) (*chatWebhook, string) {
+	hook := &chatWebhook{posts: map[string][]map[string]interface{}{}, status: http.StatusNoContent}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("decoding %s post: %v", r.URL.Path, err)
+		}
+		hook.mu.Lock()
+		defer hook.mu.Unlock()
+		hook.posts[r.URL.Path] = append(hook.posts[r.URL.Path], msg)
+		w.WriteHeader(hook.status)
+	}))
+	t.Cleanup(srv.Close)
+	return hook, srv.URL
+}
+
+func TestNotifyChatBackends(t *testing.T) {
+	e := newTestEnv(t)
+	hook, url := newChatWebhook(t)
+	e.svc.Config.SlackWebhookURL = url + "/slack"
+	e.svc.Config.DiscordWebhookURL = url + "/discord"
+	e.svc.Config.SlackNotifyEvents = []string{config.NotifyPaymentFailed}
+
+	if err := e.svc.NotifyOps(context.Background(), config.NotifyDisputeOpened, "Payment disputed: $30.00", "Reason: <fraudulent> & @everyone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.svc.NotifyOps(context.Background(), config.NotifyPaymentFailed, "Payment failed: $15.00"); err != nil {
+		t.Fatal(err)
+	}
+	e.runJobs()
+
+	if len(e.emails.Sent) != 2 {
+		t.Errorf("emailed %d notifications, want 2", len(e.emails.Sent))
+	}
+	slack := hook.posts["/slack"]
+	if len(slack) != 1 || slack[0]["text"] != "*Payment failed: $15.00*" {
+		t.Errorf("slack posts = %v, want only the payment.failed one", slack)
+	}
+	discord := hook.posts["/discord"]
+	if len(discord) != 2 {
+		t.Fatalf("discord posts = %v, want 2", discord)
+	}
+	if got := discord[0]["content"]; got != "**Payment disputed: $30.00**\nReason: <fraudulent> & @everyone" {
+		t.Errorf("discord content = %q", got)
+	}
+	if discord[0]["allowed_mentions"] == nil {
+		t.Error("discord message may ping @everyone")
+	}
+
+	e.svc.Config.SlackNotifyEvents = nil
+	if err := e.svc.SendNotification(context.Background(), &config.Notification{Channel: config.ChannelSlack, Subject: "a < b & c"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := hook.posts["/slack"][1]["text"]; got != "*a &lt; b &amp; c*" {
+		t.Errorf("slack text isn't escaped: %q", got)
+	}
+
+	hook.status = http.StatusTooManyRequests
+	if err := e.svc.SendNotification(context.Background(), &config.Notification{Channel: config.ChannelDiscord, Subject: "Payment failed"}); err == nil {
+		t.Error("a rejected post should fail so the job is retried")
+	}
+}
+
+func TestSignatureFailureNotification(t *testing.T) {
+	e := newTestEnv(t)
+	for i := 0; i < 3; i++ {
+		checkStatus(t, e.do("POST", "/webhook", []byte(`{}`), "Stripe-Signature", "t=1,v1=bad"), http.StatusBadRequest)
+	}
+	e.runJobs()
+	if len(e.emails.Sent) != 1 || e.emails.Sent[0].Subject != "Webhook signature verification failed" {
+		t.Fatalf("sent %+v, want one alert", e.emails.Sent)
+	}
+}
@@ -0,0 +1,123 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleOffSessionCharge charges a customer's saved payment method without
+// them being present, e.g. for usage billed after the fact. Declines are
+// answered with 402 and the outcome; every attempt is stored as a payment.
+func (srv *Server) handleOffSessionCharge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req service.OffSessionChargeRequest
+	if err := srv.decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	c, err := srv.svc.Stripe.GetCustomer(r.Context(), req.Customer, nil)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "fetching customer", Err: err})
+		return
+	}
+	paymentMethod := req.PaymentMethod
+	if paymentMethod == "" {
+		if c.InvoiceSettings == nil || c.InvoiceSettings.DefaultPaymentMethod == nil {
+			writeError(w, r, &service.Error{Status: http.StatusConflict, Message: "customer has no default payment method; pass paymentMethod"})
+			return
+		}
+		paymentMethod = c.InvoiceSettings.DefaultPaymentMethod.ID
+	}
+
+	params := &stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(req.Amount),
+		Currency:      stripe.String(req.Currency),
+		Customer:      stripe.String(c.ID),
+		PaymentMethod: stripe.String(paymentMethod),
+		OffSession:    stripe.Bool(true),
+		Confirm:       stripe.Bool(true),
+	}
+	if req.Description != "" {
+		params.Description = stripe.String(req.Description)
+	}
+	for k, v := range req.Metadata {
+		params.AddMetadata(k, v)
+	}
+	if srv.svc.StripeReceipts() && c.Email != "" {
+		params.ReceiptEmail = stripe.String(c.Email)
+	}
+	params.AddMetadata("off_session", "true")
+	service.AddTraceMetadata(r.Context(), &params.Params)
+	params.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "off_session_charge"))
+	resp := &service.OffSessionChargeResponse{Amount: req.Amount, Currency: req.Currency}
+
+	pi, err := srv.svc.Stripe.NewPaymentIntent(r.Context(), params)
+	var se *stripe.Error
+	if err != nil && !(errors.As(err, &se) && se.Type == stripe.ErrorTypeCard) {
+		writeError(w, r, &service.StripeFailure{Action: "charging payment method", Err: err})
+		return
+	}
+	if err != nil {
+		// Card errors carry the payment intent, left waiting for a new
+		// payment method.
+		pi = se.PaymentIntent
+		resp.Status, resp.DeclineCode, resp.Message = "failed", string(se.DeclineCode), se.Msg
+		if se.Code == stripe.ErrorCodeAuthenticationRequired {
+			resp.Status = "requires_action"
+		}
+	} else {
+		resp.Status = string(pi.Status)
+	}
+	if pi != nil {
+		resp.PaymentIntentID = pi.ID
+		// The charge was attempted; record it even if the caller has gone.
+		if _, err := srv.svc.RecordPaymentIntent(context.WithoutCancel(r.Context()), pi, offSessionPaymentStatus(resp.Status)); err != nil {
+			logFor(r).Error("recording off-session charge", "payment_intent", pi.ID, "error", err)
+		}
+	}
+	logFor(r).Info("off-session charge", "customer", c.ID, "payment_intent", resp.PaymentIntentID, "status", resp.Status, "decline_code", resp.DeclineCode)
+
+	switch resp.Status {
+	case string(stripe.PaymentIntentStatusSucceeded), string(stripe.PaymentIntentStatusProcessing):
+		writeJSON(w, resp)
+		return
+	case "requires_action":
+		if pi != nil {
+			email := srv.svc.AuthenticationRequest(c.Email, service.CustomerLocale(c), pi, paymentMethod, req.Description)
+			a := &service.Authentication{
+				PaymentIntentID: pi.ID,
+				CustomerID:      c.ID,
+				Email:           c.Email,
+				Locale:          email.Locale,
+				Amount:          pi.Amount,
+				Currency:        string(pi.Currency),
+			}
+			if _, err := srv.svc.RequestAuthentication(context.WithoutCancel(r.Context()), a, email); err != nil {
+				logFor(r).Error("requesting authentication", "payment_intent", pi.ID, "error", err)
+			} else {
+				resp.CustomerNotified = c.Email != ""
+			}
+		}
+	}
+	writeJSONError(w, resp, http.StatusPaymentRequired)
+}
+
+// offSessionPaymentStatus is the stored payment status for the outcome of
+// an off-session charge.
+func offSessionPaymentStatus(status string) string {
+	switch status {
+	case string(stripe.PaymentIntentStatusSucceeded):
+		return "paid"
+	case "requires_action":
+		return string(stripe.PaymentIntentStatusRequiresAction)
+	}
+	return status
+}
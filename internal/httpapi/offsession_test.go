@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestOffSessionCharge(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Customers["cus_usage"] = &stripe.Customer{
+		ID:              "cus_usage",
+		Email:           "jenny@example.com",
+		InvoiceSettings: &stripe.CustomerInvoiceSettings{DefaultPaymentMethod: &stripe.PaymentMethod{ID: "pm_card_visa"}},
+	}
+
+	w := e.admin("POST", "/charges/off-session", service.OffSessionChargeRequest{Customer: "cus_usage", Amount: 4200, Currency: "USD", Metadata: map[string]string{"period": "2024-03"}})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.OffSessionChargeResponse
+	decodeBody(t, w, &resp)
+	if resp.Status != "succeeded" || resp.PaymentIntentID == "" || resp.Amount != 4200 || resp.Currency != "usd" {
+		t.Errorf("response = %+v", resp)
+	}
+	params := e.stripe.PaymentIntentParams[0]
+	if *params.PaymentMethod != "pm_card_visa" || !*params.OffSession || !*params.Confirm || *params.Customer != "cus_usage" {
+		t.Errorf("params: payment method %q, off_session %v, confirm %v", *params.PaymentMethod, *params.OffSession, *params.Confirm)
+	}
+	if p := e.payment(resp.PaymentIntentID); p.Status != "paid" || p.Metadata["period"] != "2024-03" || p.Metadata["off_session"] != "true" {
+		t.Errorf("stored payment = %+v", p)
+	}
+
+	w = e.admin("POST", "/charges/off-session", service.OffSessionChargeRequest{Customer: "cus_usage", PaymentMethod: "pm_card_chargeDeclined", Amount: 4200, Currency: "usd"})
+	checkStatus(t, w, http.StatusPaymentRequired)
+	decodeBody(t, w, &resp)
+	if resp.Status != "failed" || resp.DeclineCode != "generic_decline" || resp.CustomerNotified {
+		t.Errorf("declined response = %+v", resp)
+	}
+	if p := e.payment(resp.PaymentIntentID); p.Status != "failed" {
+		t.Errorf("declined payment status = %s", p.Status)
+	}
+	e.runJobs()
+	if len(e.emails.Sent) != 0 {
+		t.Errorf("emailed the customer about a decline: %+v", e.emails.Sent)
+	}
+}
+
+func TestOffSessionChargeAuthenticationRequired(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Customers["cus_usage"] = &stripe.Customer{ID: "cus_usage", Email: "jenny@example.com", PreferredLocales: []string{"fr"}}
+
+	w := e.admin("POST", "/charges/off-session", service.OffSessionChargeRequest{
+		Customer: "cus_usage", PaymentMethod: "pm_card_authenticationRequired", Amount: 4200, Currency: "eur", Description: "Usage for March",
+	})
+	checkStatus(t, w, http.StatusPaymentRequired)
+	var resp service.OffSessionChargeResponse
+	decodeBody(t, w, &resp)
+	if resp.Status != "requires_action" || !resp.CustomerNotified || resp.PaymentIntentID == "" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if p := e.payment(resp.PaymentIntentID); p.Status != "requires_action" {
+		t.Errorf("payment status = %s, want requires_action", p.Status)
+	}
+
+	e.runJobs()
+	if len(e.emails.Sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(e.emails.Sent))
+	}
+	msg := e.emails.Sent[0]
+	if msg.To != "jenny@example.com" || msg.Subject != "Veuillez confirmer votre paiement" {
+		t.Errorf("email = %q to %q", msg.Subject, msg.To)
+	}
+	for _, want := range []string{"42,00 EUR", "Usage for March", "/authenticate.html#client_secret=" + resp.PaymentIntentID + "_secret_fake"} {
+		if !strings.Contains(msg.HTML, want) {
+			t.Errorf("email is missing %q:\n%s", want, msg.HTML)
+		}
+	}
+}
+
+func TestOffSessionChargeValidation(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Customers["cus_new"] = &stripe.Customer{ID: "cus_new"}
+	for _, tt := range []struct {
+		req    service.OffSessionChargeRequest
+		status int
+		want   string
+	}{
+		{service.OffSessionChargeRequest{Customer: "bob", Amount: 100, Currency: "usd"}, http.StatusBadRequest, "invalid customer"},
+		{service.OffSessionChargeRequest{Customer: "cus_new", PaymentMethod: "card", Amount: 100, Currency: "usd"}, http.StatusBadRequest, "invalid payment method"},
+		{service.OffSessionChargeRequest{Customer: "cus_new", Amount: 0, Currency: "usd"}, http.StatusBadRequest, "amount"},
+		{service.OffSessionChargeRequest{Customer: "cus_new", Amount: 100, Currency: "dollars"}, http.StatusBadRequest, "invalid currency"},
+		{service.OffSessionChargeRequest{Customer: "cus_new", Amount: 100, Currency: "usd"}, http.StatusConflict, "no default payment method"},
+		{service.OffSessionChargeRequest{Customer: "cus_missing", Amount: 100, Currency: "usd"}, http.StatusNotFound, "No such customer"},
+	} {
+		checkErrorMessage(t, e.admin("POST", "/charges/off-session", tt.req), tt.status, tt.want)
+	}
+	checkStatus(t, e.do("POST", "/charges/off-session", service.OffSessionChargeRequest{}), http.StatusUnauthorized)
+	checkStatus(t, e.admin("GET", "/charges/off-session", nil), http.StatusMethodNotAllowed)
+}
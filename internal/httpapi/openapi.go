@@ -0,0 +1,43 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func (srv *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	writeJSON(w, srv.svc.OpenAPIDocument())
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at /openapi.json.
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>API reference</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+  </head>
+  <body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+    <script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+  </body>
+</html>
+`
+
+// handleAPIDocs serves Swagger UI when SWAGGER_UI_ENABLED is set.
+func (srv *Server) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	if !srv.svc.Config.SwaggerUIEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, swaggerUIPage)
+}
@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"stripe_go/internal/service"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("GET", "/openapi.json", nil)
+	checkStatus(t, w, http.StatusOK)
+	var doc struct {
+		OpenAPI    string                            `json:"openapi"`
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	decodeBody(t, w, &doc)
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("openapi = %q", doc.OpenAPI)
+	}
+	for _, op := range service.APIOperations {
+		if _, ok := doc.Paths[op.Path][strings.ToLower(op.Method)]; !ok {
+			t.Errorf("%s %s missing from the document", op.Method, op.Path)
+		}
+	}
+
+	var props []string
+	for name := range doc.Components.Schemas["CreateCheckoutRequest"].Properties {
+		props = append(props, name)
+	}
+	sort.Strings(props)
+	want := "cancelUrl captureMethod coupon currency customer customerToken email items locale metadata paymentMethodTypes promotionCode seller storeCredit successUrl"
+	if got := strings.Join(props, " "); got != want {
+		t.Errorf("CreateCheckoutRequest properties = %s, want %s", got, want)
+	}
+
+	checkout := doc.Paths["/create-checkout-session"]["post"].(map[string]interface{})
+	if !strings.Contains(fmt.Sprint(checkout["parameters"]), "Idempotency-Key") {
+		t.Errorf("checkout parameters = %v, want Idempotency-Key", checkout["parameters"])
+	}
+	if _, ok := checkout["responses"].(map[string]interface{})["422"]; !ok {
+		t.Error("checkout doesn't document 422 for a reused Idempotency-Key")
+	}
+
+	// Every reference must point at a generated component.
+	for _, ref := range strings.Split(w.Body.String(), `"$ref":"#/components/schemas/`)[1:] {
+		name, _, _ := strings.Cut(ref, `"`)
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("dangling reference to %s", name)
+		}
+	}
+}
+
+func TestOpenAPIOperationsAreRouted(t *testing.T) {
+	e := newTestEnv(t)
+	mux := http.NewServeMux()
+	e.srv.registerRoutes(mux)
+	for _, op := range service.APIOperations {
+		path := service.PathParamPattern.ReplaceAllString(op.Path, "x")
+		if _, pattern := mux.Handler(httptest.NewRequest(op.Method, path, nil)); pattern == "/" {
+			t.Errorf("%s %s is documented but not routed", op.Method, op.Path)
+		}
+	}
+}
+
+func TestAPIDocs(t *testing.T) {
+	e := newTestEnv(t)
+	checkStatus(t, e.do("GET", "/docs", nil), http.StatusNotFound)
+
+	e.svc.Config.SwaggerUIEnabled = true
+	w := e.do("GET", "/docs", nil)
+	checkStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), `url: "/openapi.json"`) {
+		t.Errorf("docs page doesn't load the spec:\n%s", w.Body.String())
+	}
+}
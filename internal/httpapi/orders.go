@@ -0,0 +1,128 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"stripe_go/internal/service"
+)
+
+// handleAdminOrders serves GET /admin/orders.
+func (srv *Server) handleAdminOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	q := r.URL.Query()
+	f := service.OrderFilter{Status: service.OrderStatus(q.Get("status")), Limit: service.DefaultPageSize}
+	if _, ok := service.OrderTransitions[f.Status]; f.Status != "" && !ok {
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid status %q", f.Status)})
+		return
+	}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > service.MaxPageSize {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", service.MaxPageSize)})
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid offset %q", v)})
+			return
+		}
+	}
+	// Fetch one extra row to know whether there is another page.
+	limit := f.Limit
+	f.Limit++
+	list, err := srv.svc.Payments.ListOrders(r.Context(), f)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing orders", err))
+		return
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []*service.Order{}
+	}
+	writeJSON(w, struct {
+		Orders  []*service.Order `json:"orders"`
+		Limit   int              `json:"limit"`
+		Offset  int              `json:"offset"`
+		HasMore bool             `json:"hasMore"`
+	}{list, limit, f.Offset, hasMore})
+}
+
+// handleAdminOrder serves GET /admin/orders/{id},
+// GET /admin/orders/{id}/fulfillments and POST /admin/orders/{id}/fulfill.
+func (srv *Server) handleAdminOrder(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/admin/orders/")
+	fulfill := len(parts) == 2 && parts[1] == "fulfill"
+	if len(parts) == 2 && parts[1] == "fulfillments" {
+		srv.handleAdminOrderFulfillments(w, r, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "events" {
+		srv.handleAdminOrderEvents(w, r, parts[0])
+		return
+	}
+	if len(parts) != 1 && !fulfill {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if (fulfill && r.Method != "POST") || (!fulfill && r.Method != "GET") {
+		writeMethodNotAllowed(w)
+		return
+	}
+	o, err := srv.svc.Payments.GetOrder(r.Context(), parts[0])
+	if err == service.ErrOrderNotFound {
+		writeError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, r, service.InternalError("fetching order", err))
+		return
+	}
+	if fulfill {
+		if err := o.Transition(service.OrderFulfilled); err != nil {
+			writeError(w, r, &service.Error{Status: http.StatusConflict, Message: err.Error()})
+			return
+		}
+		if err := srv.svc.Payments.SaveOrder(r.Context(), o); err != nil {
+			writeError(w, r, service.InternalError("saving order", err))
+			return
+		}
+		logFor(r).Info("order fulfilled", "order", o.ID)
+	}
+	writeJSON(w, o)
+}
+
+// handleAdminOrderFulfillments lists an order's fulfillments, including
+// the license keys and shipment tickets they issued.
+func (srv *Server) handleAdminOrderFulfillments(w http.ResponseWriter, r *http.Request, orderID string) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if _, err := srv.svc.Payments.GetOrder(r.Context(), orderID); err == service.ErrOrderNotFound {
+		writeError(w, r, err)
+		return
+	} else if err != nil {
+		writeError(w, r, service.InternalError("fetching order", err))
+		return
+	}
+	list, err := srv.svc.Payments.ListFulfillments(r.Context(), orderID)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing fulfillments", err))
+		return
+	}
+	if list == nil {
+		list = []*service.Fulfillment{}
+	}
+	writeJSON(w, struct {
+		Fulfillments []*service.Fulfillment `json:"fulfillments"`
+	}{list})
+}
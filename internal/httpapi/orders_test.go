@@ -0,0 +1,155 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"stripe_go/internal/service"
+)
+
+func (e *testEnv) order(id string) *service.Order {
+	e.t.Helper()
+	o, err := e.svc.Payments.GetOrder(context.Background(), id)
+	if err != nil {
+		e.t.Fatalf("GetOrder(%s): %v", id, err)
+	}
+	return o
+}
+
+func (e *testEnv) deliverOK(payload []byte) {
+	e.t.Helper()
+	if status, body := e.deliver(payload); status != http.StatusOK {
+		e.t.Fatalf("webhook: %d %s", status, body)
+	}
+	e.runJobs()
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+		"metadata": map[string]string{"order_id": "A-1001"},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+
+	o := e.order(resp.OrderID)
+	if o.Status != service.OrderPending || o.SessionID != resp.ID || o.Amount != 3000 || o.Currency != "usd" {
+		t.Errorf("new order = %+v", o)
+	}
+	if len(o.Items) != 1 || o.Items[0] != (service.OrderItem{Price: "price_basic", Quantity: 2}) {
+		t.Errorf("items = %+v", o.Items)
+	}
+	params := e.stripe.SessionParams[0]
+	if params.Metadata[service.OrderMetadataKey] != o.ID || params.PaymentIntentData.Metadata[service.OrderMetadataKey] != o.ID {
+		t.Errorf("session metadata = %v, payment intent metadata = %v", params.Metadata, params.PaymentIntentData.Metadata)
+	}
+	if params.Metadata["order_id"] != "A-1001" {
+		t.Errorf("client metadata dropped: %v", params.Metadata)
+	}
+
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_completed", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": "pi_test_order", "payment_status": "paid",
+		"amount_total": 3000, "currency": "usd", "customer_details": {"email": "jenny@example.com"},
+		"metadata": {"order": %q}}}}`, resp.ID, o.ID)))
+	o = e.order(o.ID)
+	if o.Status != service.OrderPaid || o.PaymentIntentID != "pi_test_order" || o.Email != "jenny@example.com" {
+		t.Errorf("paid order = %+v", o)
+	}
+
+	w = e.admin("POST", "/admin/orders/"+o.ID+"/fulfill", nil)
+	checkStatus(t, w, http.StatusOK)
+	if o = e.order(o.ID); o.Status != service.OrderFulfilled {
+		t.Errorf("status after fulfill = %s", o.Status)
+	}
+
+	e.deliverOK([]byte(`{"id": "evt_refunded", "object": "event", "type": "charge.refunded", "data": {"object": {
+		"id": "ch_test_order", "object": "charge", "payment_intent": "pi_test_order", "refunded": true, "amount_refunded": 3000}}}`))
+	if o = e.order(o.ID); o.Status != service.OrderRefunded {
+		t.Errorf("status after refund = %s", o.Status)
+	}
+
+	w = e.admin("POST", "/admin/orders/"+o.ID+"/fulfill", nil)
+	checkErrorMessage(t, w, http.StatusConflict, "can't become fulfilled")
+}
+
+func TestOrderCanceledWhenSessionExpires(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+
+	e.deliverOK(sessionEvent("checkout.session.expired", resp.ID))
+	if o := e.order(resp.OrderID); o.Status != service.OrderCanceled {
+		t.Errorf("status = %s, want canceled", o.Status)
+	}
+	// A late completion can't revive a canceled order.
+	e.deliverOK(sessionEvent("checkout.session.completed", resp.ID))
+	if o := e.order(resp.OrderID); o.Status != service.OrderCanceled {
+		t.Errorf("status after late completion = %s, want canceled", o.Status)
+	}
+}
+
+func TestOrderCanceledWhenStripeFails(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Err = fmt.Errorf("stripe is down")
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+	})
+	if w.Code == http.StatusOK {
+		t.Fatal("checkout succeeded without Stripe")
+	}
+	list, err := e.svc.Payments.ListOrders(context.Background(), service.OrderFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Status != service.OrderCanceled {
+		t.Errorf("orders = %+v, want one canceled order", list)
+	}
+}
+
+func TestOrderMetadataKeyIsReserved(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"metadata": map[string]string{"order": "ord_forged"},
+	})
+	checkErrorMessage(t, w, http.StatusBadRequest, "reserved")
+}
+
+func TestAdminOrders(t *testing.T) {
+	e := newTestEnv(t)
+	for i := 0; i < 3; i++ {
+		checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+			"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		}), http.StatusOK)
+	}
+	var page struct {
+		Orders  []*service.Order `json:"orders"`
+		HasMore bool             `json:"hasMore"`
+	}
+	w := e.admin("GET", "/admin/orders?status=pending&limit=2", nil)
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &page)
+	if len(page.Orders) != 2 || !page.HasMore {
+		t.Fatalf("page = %d orders, hasMore %v", len(page.Orders), page.HasMore)
+	}
+	pending := page.Orders[0].ID
+
+	w = e.admin("GET", "/admin/orders?status=paid", nil)
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &page)
+	if len(page.Orders) != 0 {
+		t.Errorf("paid orders = %d, want 0", len(page.Orders))
+	}
+
+	checkErrorMessage(t, e.admin("GET", "/admin/orders?status=shipped", nil), http.StatusBadRequest, "invalid status")
+	checkErrorMessage(t, e.admin("GET", "/admin/orders/ord_missing", nil), http.StatusNotFound, "order not found")
+	checkErrorMessage(t, e.admin("POST", "/admin/orders/"+pending+"/fulfill", nil), http.StatusConflict, "can't become fulfilled")
+}
@@ -0,0 +1,84 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// orderStatusView describes o. A pending order may be waiting on a webhook
+// that hasn't arrived yet, so its checkout session is asked instead; if
+// Stripe can't be reached the stored state is reported.
+func (srv *Server) orderStatusView(r *http.Request, o *service.Order) *service.OrderStatusView {
+	v := &service.OrderStatusView{
+		OrderID:   o.ID,
+		Status:    o.Status,
+		Amount:    o.Amount,
+		Currency:  o.Currency,
+		UpdatedAt: o.UpdatedAt,
+	}
+	if o.Status == service.OrderPaid || o.Status == service.OrderFulfilled {
+		list, err := srv.svc.Payments.ListFulfillments(r.Context(), o.ID)
+		if err != nil {
+			logFor(r).Warn("listing fulfillments for order status", "order", o.ID, "error", err)
+		}
+		for _, f := range list {
+			v.Fulfillments = append(v.Fulfillments, srv.svc.FulfillmentView(f))
+		}
+	}
+	if o.Status != service.OrderPending || o.SessionID == "" {
+		return v
+	}
+	s, err := srv.svc.CachedCheckoutSession(r.Context(), o.SessionID)
+	if err != nil {
+		logFor(r).Warn("fetching session for order status", "order", o.ID, "session", o.SessionID, "error", err)
+		return v
+	}
+	switch {
+	case s.Status == stripe.CheckoutSessionStatusComplete && s.PaymentStatus != stripe.CheckoutSessionPaymentStatusUnpaid:
+		v.Status = service.OrderPaid
+	case s.Status == stripe.CheckoutSessionStatusExpired:
+		v.Status = service.OrderCanceled
+	}
+	if v.Amount == 0 {
+		v.Amount, v.Currency = s.AmountTotal, string(s.Currency)
+	}
+	return v
+}
+
+// handleOrderStatus serves GET /orders/{id}/status?token=..., which the
+// success page and confirmation emails use to follow an order. The token
+// from the status link stands in for a login.
+func (srv *Server) handleOrderStatus(w http.ResponseWriter, r *http.Request) {
+	if srv.svc.Config.OrderStatusSigningKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	parts := pathParams(r.URL.Path, "/orders/")
+	if len(parts) != 2 || parts[1] != "status" {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	id := parts[0]
+	if err := srv.svc.VerifyOrderStatusToken(id, r.URL.Query().Get("token")); err != nil {
+		writeError(w, r, &service.Error{Status: http.StatusForbidden, Message: err.Error()})
+		return
+	}
+	o, err := srv.svc.Payments.GetOrder(r.Context(), id)
+	if err == service.ErrOrderNotFound {
+		writeError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, r, service.InternalError("fetching order", err))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, srv.orderStatusView(r, o))
+}
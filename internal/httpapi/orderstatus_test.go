@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+const testOrderStatusKey = "order-status-key-0123456789abcdef"
+
+func (e *testEnv) checkoutWithStatus() service.CreateCheckoutResponse {
+	e.t.Helper()
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+	})
+	checkStatus(e.t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(e.t, w, &resp)
+	return resp
+}
+
+func (e *testEnv) orderStatus(link string) *service.OrderStatusView {
+	e.t.Helper()
+	w := e.do("GET", strings.TrimPrefix(link, e.svc.Config.Domain), nil)
+	checkStatus(e.t, w, http.StatusOK)
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		e.t.Errorf("Cache-Control = %q", cc)
+	}
+	var v service.OrderStatusView
+	decodeBody(e.t, w, &v)
+	return &v
+}
+
+func TestOrderStatus(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.OrderStatusSigningKey = testOrderStatusKey
+	resp := e.checkoutWithStatus()
+	if resp.StatusURL != e.svc.OrderStatusURL(resp.OrderID) {
+		t.Fatalf("statusUrl = %q", resp.StatusURL)
+	}
+	success, err := url.Parse(*e.stripe.SessionParams[0].SuccessURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := success.Query(); q.Get("order_id") != resp.OrderID || q.Get("order_token") != e.svc.OrderStatusToken(resp.OrderID) {
+		t.Errorf("success URL = %s", success)
+	}
+
+	// Stripe already knows the session is paid; the webhook hasn't arrived.
+	s := e.stripe.Sessions[resp.ID]
+	s.Status = stripe.CheckoutSessionStatusComplete
+	s.PaymentStatus = stripe.CheckoutSessionPaymentStatusPaid
+	v := e.orderStatus(resp.StatusURL)
+	if v.OrderID != resp.OrderID || v.Status != service.OrderPaid || v.Amount != 3000 || v.Currency != "usd" || v.UpdatedAt.IsZero() {
+		t.Errorf("status = %+v", v)
+	}
+	if e.order(resp.OrderID).Status != service.OrderPending {
+		t.Error("the status request changed the stored order")
+	}
+
+	// Without Stripe the stored state is reported.
+	other := e.checkoutWithStatus()
+	e.stripe.Err = errors.New("connection refused")
+	if v := e.orderStatus(other.StatusURL); v.Status != service.OrderPending {
+		t.Errorf("status without Stripe = %s, want pending", v.Status)
+	}
+}
+
+func TestOrderStatusRequests(t *testing.T) {
+	e := newTestEnv(t)
+	resp := e.checkoutWithStatus()
+	if resp.StatusURL != "" {
+		t.Errorf("statusUrl = %q with status links disabled", resp.StatusURL)
+	}
+	path := "/orders/" + resp.OrderID + "/status?token="
+	checkStatus(t, e.do("GET", path+"x", nil), http.StatusNotFound)
+
+	e.svc.Config.OrderStatusSigningKey = testOrderStatusKey
+	checkErrorMessage(t, e.do("GET", path+"x", nil), http.StatusForbidden, "invalid order status link")
+	checkErrorMessage(t, e.do("GET", path, nil), http.StatusForbidden, "invalid order status link")
+	checkErrorMessage(t, e.do("GET", "/orders/ord_missing/status?token="+e.svc.OrderStatusToken("ord_missing"), nil), http.StatusNotFound, "order not found")
+	checkStatus(t, e.do("POST", path+e.svc.OrderStatusToken(resp.OrderID), nil), http.StatusMethodNotAllowed)
+	checkStatus(t, e.do("GET", "/orders/"+resp.OrderID, nil), http.StatusNotFound)
+}
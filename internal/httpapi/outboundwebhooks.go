@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"stripe_go/internal/service"
+)
+
+// handleAdminWebhookDeliveries serves GET /admin/webhook-deliveries, newest
+// first. It takes endpoint, event, status, limit and offset.
+func (srv *Server) handleAdminWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	q := r.URL.Query()
+	f := service.WebhookDeliveryFilter{Endpoint: q.Get("endpoint"), EventID: q.Get("event"), Status: q.Get("status"), Limit: service.DefaultPageSize}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > service.MaxPageSize {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", service.MaxPageSize)})
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid offset %q", v)})
+			return
+		}
+	}
+	// Fetch one extra row to know whether there is another page.
+	limit := f.Limit
+	f.Limit++
+	list, err := srv.svc.Payments.ListWebhookDeliveries(r.Context(), f)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing deliveries", err))
+		return
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []*service.WebhookDelivery{}
+	}
+	writeJSON(w, struct {
+		Deliveries []*service.WebhookDelivery `json:"deliveries"`
+		Limit      int                        `json:"limit"`
+		Offset     int                        `json:"offset"`
+		HasMore    bool                       `json:"hasMore"`
+	}{list, limit, f.Offset, hasMore})
+}
+
+// handleAdminWebhookDelivery serves GET /admin/webhook-deliveries/{id} and
+// POST /admin/webhook-deliveries/{id}/retry, which queues the delivery again,
+// e.g. once the endpoint is back after the job queue gave up on it.
+func (srv *Server) handleAdminWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/admin/webhook-deliveries/")
+	retry := len(parts) == 2 && parts[1] == "retry"
+	if len(parts) == 0 || len(parts) > 2 || (len(parts) == 2 && !retry) {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if (!retry && r.Method != "GET") || (retry && r.Method != "POST") {
+		writeMethodNotAllowed(w)
+		return
+	}
+	d, err := srv.svc.Payments.GetWebhookDelivery(r.Context(), parts[0])
+	if err == service.ErrDeliveryNotFound {
+		writeError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, r, service.InternalError("fetching delivery", err))
+		return
+	}
+	if !retry {
+		writeJSON(w, d)
+		return
+	}
+	if d.Status == service.DeliverySucceeded {
+		writeError(w, r, &service.Error{Status: http.StatusConflict, Message: "delivery already succeeded"})
+		return
+	}
+	if err := srv.confirmRequest(r, "webhook_delivery.retry", d.ID, 0); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	d.Status = service.DeliveryPending
+	if err := srv.svc.Payments.SaveWebhookDelivery(r.Context(), d); err != nil {
+		writeError(w, r, service.InternalError("saving delivery", err))
+		return
+	}
+	if err := srv.svc.Jobs.Enqueue(r.Context(), service.JobDeliverWebhook, d.ID); err != nil {
+		writeError(w, r, service.InternalError("queueing delivery", err))
+		return
+	}
+	logFor(r).Info("webhook delivery requeued", "delivery", d.ID, "endpoint", d.Endpoint)
+	writeJSON(w, d)
+}
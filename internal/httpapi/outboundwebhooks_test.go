@@ -0,0 +1,227 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+const testOutboundSecret = "outbound-secret-0123456789abcdef0123"
+
+// webhookReceiver is a downstream system that records what it was posted
+// and answers with status.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (e *testEnv) outboundWebhook(name string) *webhookReceiver {
+	e.t.Helper()
+	rcv, url := e.webhookReceiver()
+	e.svc.Config.OutboundWebhooks = append(e.svc.Config.OutboundWebhooks, config.OutboundWebhook{Name: name, URL: url})
+	e.svc.Config.OutboundWebhookSecret = testOutboundSecret
+	return rcv
+}
+
+// webhookReceiver starts a receiver and returns it with its URL.
+func (e *testEnv) webhookReceiver() (*webhookReceiver, string) {
+	rcv := &webhookReceiver{status: http.StatusOK}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.requests = append(rcv.requests, r)
+		rcv.bodies = append(rcv.bodies, body)
+		w.WriteHeader(rcv.status)
+	}))
+	e.t.Cleanup(srv.Close)
+	return rcv, srv.URL
+}
+
+func (rcv *webhookReceiver) events(t *testing.T) []*service.PaymentEvent {
+	t.Helper()
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	var events []*service.PaymentEvent
+	for _, body := range rcv.bodies {
+		var ev service.PaymentEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Fatalf("decoding %s: %v", body, err)
+		}
+		events = append(events, &ev)
+	}
+	return events
+}
+
+// checkWebhookSignature checks a Webhook-Signature header the way a
+// receiver would.
+func checkWebhookSignature(t *testing.T, header string, body []byte) {
+	t.Helper()
+	ts, sig, ok := strings.Cut(strings.TrimPrefix(header, "t="), ",v1=")
+	if !ok {
+		t.Fatalf("malformed Webhook-Signature %q", header)
+	}
+	mac := hmac.New(sha256.New, []byte(testOutboundSecret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if want := hex.EncodeToString(mac.Sum(nil)); sig != want {
+		t.Errorf("signature = %s, want %s", sig, want)
+	}
+}
+
+func TestOutboundWebhooks(t *testing.T) {
+	e := newTestEnv(t)
+	fulfillment := e.outboundWebhook("fulfillment")
+	crm := e.outboundWebhook("crm")
+	e.seedPayment()
+	payload, err := os.ReadFile("testdata/webhooks/checkout_session_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.deliverOK(payload)
+	e.runJobs()
+
+	for _, rcv := range []*webhookReceiver{fulfillment, crm} {
+		events := rcv.events(t)
+		if len(events) != 1 {
+			t.Fatalf("got %d events, want 1", len(events))
+		}
+		ev := events[0]
+		if ev.Type != "payment.paid" || ev.Payment.SessionID != "cs_test_completed" || ev.Payment.Amount != 3000 || ev.PreviousStatus != "" {
+			t.Errorf("event = %+v", ev)
+		}
+		r := rcv.requests[0]
+		if r.Header.Get("Webhook-Id") != ev.ID || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		checkWebhookSignature(t, r.Header.Get("Webhook-Signature"), rcv.bodies[0])
+	}
+	if fulfillment.events(t)[0].ID != crm.events(t)[0].ID {
+		t.Error("endpoints got different event IDs for the same change")
+	}
+
+	// A webhook that doesn't change the status isn't passed on.
+	e.deliverOK(payload)
+	e.runJobs()
+	if n := len(fulfillment.events(t)); n != 1 {
+		t.Errorf("got %d events after a repeated status, want 1", n)
+	}
+
+	refund, err := os.ReadFile("testdata/webhooks/charge_refunded_full.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.deliverOK(refund)
+	e.runJobs()
+	events := fulfillment.events(t)
+	if len(events) != 2 || events[1].Type != "payment.refunded" || events[1].PreviousStatus != "paid" {
+		t.Fatalf("events = %+v, want payment.refunded from paid", events)
+	}
+
+	w := e.admin("GET", "/admin/webhook-deliveries?endpoint=crm", nil)
+	checkStatus(t, w, http.StatusOK)
+	var list struct {
+		Deliveries []*service.WebhookDelivery `json:"deliveries"`
+	}
+	decodeBody(t, w, &list)
+	if len(list.Deliveries) != 2 {
+		t.Fatalf("crm deliveries = %d, want 2", len(list.Deliveries))
+	}
+	for _, d := range list.Deliveries {
+		if d.Endpoint != "crm" || d.Status != service.DeliverySucceeded || d.Attempts != 1 || d.ResponseStatus != 200 {
+			t.Errorf("delivery = %+v", d)
+		}
+	}
+}
+
+func TestOutboundWebhookRetries(t *testing.T) {
+	e := newTestEnv(t)
+	rcv := e.outboundWebhook("fulfillment")
+	rcv.status = http.StatusServiceUnavailable
+	if err := e.svc.SavePayment(context.Background(), &service.Payment{SessionID: "cs_test_1", Amount: 500, Currency: "usd", Status: "paid"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	e.runJobs()
+
+	deliveries, err := e.svc.Payments.ListWebhookDeliveries(context.Background(), service.WebhookDeliveryFilter{})
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("deliveries = %v, %v", deliveries, err)
+	}
+	d := deliveries[0]
+	if d.Status != service.DeliveryFailed || d.Attempts != 1 || d.ResponseStatus != 503 || !strings.Contains(d.LastError, "503") {
+		t.Fatalf("failed delivery = %+v", d)
+	}
+	if _, dead := e.svc.Jobs.Snapshot(); len(dead) != 1 || dead[0].Type != service.JobDeliverWebhook {
+		t.Errorf("dead jobs = %+v, want the delivery", dead)
+	}
+
+	rcv.status = http.StatusOK
+	w := e.admin("POST", "/admin/webhook-deliveries/"+d.ID+"/retry", nil)
+	checkStatus(t, w, http.StatusOK)
+	e.runJobs()
+	w = e.admin("GET", "/admin/webhook-deliveries/"+d.ID, nil)
+	checkStatus(t, w, http.StatusOK)
+	var got service.WebhookDelivery
+	decodeBody(t, w, &got)
+	if got.Status != service.DeliverySucceeded || got.Attempts != 2 || got.LastError != "" {
+		t.Errorf("retried delivery = %+v", got)
+	}
+	if events := rcv.events(t); len(events) != 2 || events[0].ID != events[1].ID {
+		t.Errorf("events = %+v, want the same event twice", events)
+	}
+	checkErrorMessage(t, e.admin("POST", "/admin/webhook-deliveries/"+d.ID+"/retry", nil), http.StatusConflict, "already succeeded")
+	checkStatus(t, e.admin("GET", "/admin/webhook-deliveries/whd_missing", nil), http.StatusNotFound)
+}
+
+func TestOutboundWebhookEvents(t *testing.T) {
+	e := newTestEnv(t)
+	rcv := e.outboundWebhook("fulfillment")
+	e.svc.Config.OutboundWebhookEvents = []string{"payment.paid"}
+	for _, status := range []string{"unpaid", "paid", "refunded"} {
+		if err := e.svc.SavePayment(context.Background(), &service.Payment{SessionID: "cs_test_" + status, Status: status}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.runJobs()
+	if events := rcv.events(t); len(events) != 1 || events[0].Type != "payment.paid" {
+		t.Errorf("events = %+v, want only payment.paid", events)
+	}
+
+	// Deliveries to an endpoint that was removed give up.
+	d := &service.WebhookDelivery{ID: "whd_removed", Endpoint: "gone", EventID: "evt_1", EventType: "payment.paid", Payload: json.RawMessage(`{}`), Status: service.DeliveryPending}
+	if err := e.svc.Payments.SaveWebhookDelivery(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.svc.DeliverWebhook(context.Background(), d.ID); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ = e.svc.Payments.GetWebhookDelivery(context.Background(), d.ID); d.Status != service.DeliveryFailed || !strings.Contains(d.LastError, "no longer configured") {
+		t.Errorf("delivery to a removed endpoint = %+v", d)
+	}
+}
+
+func TestOutboundWebhookSignature(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.OutboundWebhookSecret = testOutboundSecret
+	body := []byte(`{"id":"evt_1"}`)
+	header := e.svc.WebhookSignature(body, time.Unix(1700000000, 0))
+	if !strings.HasPrefix(header, "t=1700000000,v1=") {
+		t.Errorf("header = %q", header)
+	}
+	checkWebhookSignature(t, header, body)
+}
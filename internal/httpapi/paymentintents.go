@@ -0,0 +1,50 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleCreatePaymentIntent creates a PaymentIntent for an on-site Elements
+// payment form and returns its client secret.
+func (srv *Server) handleCreatePaymentIntent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req service.PaymentIntentRequest
+	if err := srv.decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+
+	params := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(req.Amount),
+		Currency: stripe.String(req.Currency),
+		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
+			Enabled: stripe.Bool(true),
+		},
+	}
+	for k, v := range req.Metadata {
+		params.AddMetadata(k, v)
+	}
+	service.AddTraceMetadata(r.Context(), &params.Params)
+	params.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "payment_intent"))
+	pi, err := srv.svc.Stripe.NewPaymentIntent(r.Context(), params)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "creating payment intent", Err: err})
+		return
+	}
+	if _, err := srv.svc.RecordPaymentIntent(context.WithoutCancel(r.Context()), pi, string(pi.Status)); err != nil {
+		logFor(r).Error("recording payment intent", "payment_intent", pi.ID, "error", err)
+	}
+
+	writeJSON(w, &service.PaymentIntentResponse{
+		ID:           pi.ID,
+		ClientSecret: pi.ClientSecret,
+	})
+}
@@ -0,0 +1,41 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+
+	"stripe_go/internal/service"
+)
+
+func TestCreatePaymentIntent(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-payment-intent", service.PaymentIntentRequest{Amount: 2500, Currency: "EUR", Metadata: map[string]string{"order_id": "E-1"}})
+	checkStatus(t, w, http.StatusOK)
+	var resp struct {
+		ID           string `json:"id"`
+		ClientSecret string `json:"clientSecret"`
+	}
+	decodeBody(t, w, &resp)
+	if resp.ID == "" || resp.ClientSecret == "" {
+		t.Fatalf("response = %+v", resp)
+	}
+	params := e.stripe.PaymentIntentParams[0]
+	if *params.Currency != "eur" || params.Metadata["order_id"] != "E-1" {
+		t.Errorf("params: currency %q, metadata %v", *params.Currency, params.Metadata)
+	}
+	if !*params.AutomaticPaymentMethods.Enabled {
+		t.Error("automatic payment methods not enabled")
+	}
+
+	p := e.payment(resp.ID)
+	if p.PaymentIntentID != resp.ID || p.Amount != 2500 || p.Status != "requires_payment_method" || p.Metadata["order_id"] != "E-1" {
+		t.Errorf("stored payment = %+v", p)
+	}
+}
+
+func TestCreatePaymentIntentValidation(t *testing.T) {
+	e := newTestEnv(t)
+	checkErrorMessage(t, e.do("POST", "/create-payment-intent", service.PaymentIntentRequest{Currency: "usd"}), http.StatusBadRequest, "amount must be a positive number")
+	checkErrorMessage(t, e.do("POST", "/create-payment-intent", service.PaymentIntentRequest{Amount: 100, Currency: "dollars"}), http.StatusBadRequest, "invalid currency")
+	checkStatus(t, e.do("GET", "/create-payment-intent", nil), http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func (srv *Server) parsePaymentLinkRequest(w http.ResponseWriter, r *http.Request) (*service.PaymentLinkRequest, error) {
+	var req service.PaymentLinkRequest
+	if isJSONRequest(r) {
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			return nil, err
+		}
+		return &req, nil
+	}
+	r.ParseForm()
+	req.Price = r.PostFormValue("price")
+	if quantity := r.PostFormValue("quantity"); quantity != "" {
+		var err error
+		if req.Quantity, err = strconv.ParseInt(quantity, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid quantity %q", quantity)
+		}
+	}
+	if expiresAt := r.PostFormValue("expiresAt"); expiresAt != "" {
+		var err error
+		if req.ExpiresAt, err = time.Parse(time.RFC3339, expiresAt); err != nil {
+			return nil, fmt.Errorf("invalid expiresAt %q: want RFC 3339", expiresAt)
+		}
+	}
+	return &req, req.Validate(r.Context(), srv.svc)
+}
+
+// handlePaymentLinks serves GET /payment-links, newest first and filtered
+// by active=true or active=false, and POST /payment-links to create one.
+func (srv *Server) handlePaymentLinks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		params := &stripe.PaymentLinkListParams{}
+		if active := r.URL.Query().Get("active"); active != "" {
+			b, err := strconv.ParseBool(active)
+			if err != nil {
+				writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid active %q", active)})
+				return
+			}
+			params.Active = stripe.Bool(b)
+		}
+		params.AddExpand("data.line_items")
+		params.Filters.AddFilter("limit", "", "20")
+		list, err := srv.svc.Stripe.ListPaymentLinks(r.Context(), params)
+		if err != nil {
+			writeError(w, r, &service.StripeFailure{Action: "listing payment links", Err: err})
+			return
+		}
+		views := []*service.PaymentLinkView{}
+		for _, link := range list {
+			views = append(views, service.NewPaymentLinkView(link))
+		}
+		writeJSON(w, views)
+	case "POST":
+		req, err := srv.parsePaymentLinkRequest(w, r)
+		if err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+		v, err := srv.svc.CreatePaymentLink(r.Context(), req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, v)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
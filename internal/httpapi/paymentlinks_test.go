@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"stripe_go/internal/service"
+)
+
+func TestPaymentLinks(t *testing.T) {
+	e := newTestEnv(t)
+
+	w := e.admin("POST", "/payment-links", service.PaymentLinkRequest{Price: "price_basic", Quantity: 2, Metadata: map[string]string{"deal": "acme"}})
+	checkStatus(t, w, http.StatusOK)
+	var link service.PaymentLinkView
+	decodeBody(t, w, &link)
+	if link.URL == "" || !link.Active || link.Price != "price_basic" || link.Quantity != 2 || link.Metadata["deal"] != "acme" || link.ExpiresAt != nil {
+		t.Fatalf("link = %+v", link)
+	}
+	params := e.stripe.PaymentLinkParams[0]
+	if len(params.LineItems) != 1 || *params.LineItems[0].Price != "price_basic" || *params.LineItems[0].Quantity != 2 {
+		t.Errorf("line items = %+v", params.LineItems)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	w = e.admin("POST", "/payment-links", service.PaymentLinkRequest{Price: "price_yen", ExpiresAt: expiresAt})
+	checkStatus(t, w, http.StatusOK)
+	var expiring service.PaymentLinkView
+	decodeBody(t, w, &expiring)
+	if expiring.Quantity != 1 || expiring.ExpiresAt == nil || !expiring.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expiring link = %+v, want quantity 1 until %s", expiring, expiresAt)
+	}
+
+	e.runJobs()
+	var list []*service.PaymentLinkView
+	decodeBody(t, e.admin("GET", "/payment-links?active=true", nil), &list)
+	if len(list) != 2 || list[0].ID != expiring.ID || list[1].ID != link.ID {
+		t.Fatalf("active links = %+v, want both newest first", list)
+	}
+
+	// Bring the expiry forward rather than waiting for it.
+	pending, _ := e.svc.Jobs.Snapshot()
+	if len(pending) != 1 || pending[0].Type != service.JobDeactivatePaymentLink || !pending[0].NextRunAt.Equal(expiresAt) {
+		t.Fatalf("pending jobs = %+v, want the link's deactivation at %s", pending, expiresAt)
+	}
+	e.svc.Jobs.RunDue(context.Background(), time.Until(expiresAt))
+	decodeBody(t, e.admin("GET", "/payment-links?active=false", nil), &list)
+	if len(list) != 1 || list[0].ID != expiring.ID {
+		t.Errorf("inactive links = %+v, want %s", list, expiring.ID)
+	}
+}
+
+func TestPaymentLinkValidation(t *testing.T) {
+	e := newTestEnv(t)
+	for _, tt := range []struct {
+		req  service.PaymentLinkRequest
+		want string
+	}{
+		{service.PaymentLinkRequest{}, "price is required"},
+		{service.PaymentLinkRequest{Price: "price_missing"}, "unknown price"},
+		{service.PaymentLinkRequest{Price: "price_basic", Quantity: 11}, "quantity must be between 1 and 10"},
+		{service.PaymentLinkRequest{Price: "price_basic", ExpiresAt: time.Now().Add(-time.Minute)}, "expiresAt must be in the future"},
+		{service.PaymentLinkRequest{Price: "price_basic", Metadata: map[string]string{"expires_at": "never"}}, "reserved"},
+	} {
+		checkErrorMessage(t, e.admin("POST", "/payment-links", tt.req), http.StatusBadRequest, tt.want)
+	}
+	checkStatus(t, e.admin("GET", "/payment-links?active=maybe", nil), http.StatusBadRequest)
+	checkStatus(t, e.do("POST", "/payment-links", service.PaymentLinkRequest{Price: "price_basic"}), http.StatusUnauthorized)
+	if len(e.stripe.PaymentLinkParams) != 0 {
+		t.Errorf("created %d links from invalid requests", len(e.stripe.PaymentLinkParams))
+	}
+}
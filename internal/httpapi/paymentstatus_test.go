@@ -0,0 +1,28 @@
+package httpapi
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestLateCheckoutEventKeepsRefund(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedPayment()
+	refund, err := os.ReadFile("testdata/webhooks/charge_refunded_full.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	late := []byte(`{"id": "evt_late", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": "cs_test_seed", "object": "checkout.session", "payment_intent": "pi_test_seed",
+		"payment_status": "paid", "amount_total": 3000, "currency": "usd"}}}`)
+	for _, payload := range [][]byte{refund, late} {
+		if status, body := e.deliver(payload); status != http.StatusOK {
+			t.Fatalf("webhook: %d %s", status, body)
+		}
+		e.runJobs()
+	}
+	if p := e.payment("cs_test_seed"); p.Status != "refunded" {
+		t.Errorf("status = %q after a late checkout event, want refunded", p.Status)
+	}
+}
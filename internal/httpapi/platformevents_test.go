@@ -0,0 +1,112 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"stripe_go/internal/service"
+)
+
+// publishToBus publishes platform events to a recording bus.
+func (e *testEnv) publishToBus() *recordingBus {
+	bus := &recordingBus{}
+	e.svc.MessageBuses["test"] = bus
+	e.t.Cleanup(func() { delete(e.svc.MessageBuses, "test") })
+	e.svc.Config.EventBus, e.svc.Config.EventBusTopic = "test", "payments"
+	return bus
+}
+
+func TestPlatformEvents(t *testing.T) {
+	e := newTestEnv(t)
+	bus := e.publishToBus()
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 2}}})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+
+	completed := []byte(fmt.Sprintf(`{"id": "evt_completed", "object": "event", "type": "checkout.session.completed", "created": 1700000000, "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": "pi_test_order", "payment_status": "paid",
+		"amount_total": 3000, "currency": "usd", "customer_details": {"email": "jenny@example.com"},
+		"metadata": {"order": %q}}}}`, resp.ID, resp.OrderID))
+	e.deliverOK(completed)
+	// The payment intent's event finds the order paid already and publishes
+	// it again with the same ID.
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_pi_succeeded", "object": "event", "type": "payment_intent.succeeded", "data": {"object": {
+		"id": "pi_test_order", "object": "payment_intent", "status": "succeeded", "amount": 3000, "currency": "usd",
+		"metadata": {"order": %q}}}}`, resp.OrderID)))
+	e.deliverOK([]byte(`{"id": "evt_refunded", "object": "event", "type": "charge.refunded", "data": {"object": {
+		"id": "ch_test_order", "object": "charge", "payment_intent": "pi_test_order", "refunded": true, "amount_refunded": 3000}}}`))
+	e.deliverOK(subscriptionEvent("customer.subscription.updated", "sub_1", "past_due"))
+	e.deliverOK(subscriptionEvent("customer.subscription.deleted", "sub_1", "canceled"))
+	e.runJobs()
+
+	var got []service.PlatformEvent
+	for i, msg := range bus.messages {
+		var pe service.PlatformEvent
+		if err := json.Unmarshal(msg.Payload, &pe); err != nil {
+			t.Fatal(err)
+		}
+		if bus.topics[i] != "payments" || msg.ID != pe.ID || msg.Type != pe.Type || msg.Key != pe.Subject {
+			t.Errorf("message %+v on %s", msg, bus.topics[i])
+		}
+		got = append(got, pe)
+	}
+	if len(got) != 4 {
+		t.Fatalf("published %d events: %+v", len(got), got)
+	}
+	paid, refunded, canceled := got[0], got[2], got[3]
+	if paid.Type != "order.paid" || paid.Version != service.PlatformEventVersion || paid.Subject != resp.OrderID ||
+		paid.StripeEvent != "evt_completed" || paid.OccurredAt.Unix() != 1700000000 || paid.Source != e.svc.Config.ServiceName {
+		t.Errorf("paid event = %+v", paid)
+	}
+	if got[1].ID != paid.ID {
+		t.Errorf("order.paid published as %s and %s", paid.ID, got[1].ID)
+	}
+	var order service.OrderEventData
+	if err := json.Unmarshal(paid.Data, &order); err != nil {
+		t.Fatal(err)
+	}
+	if order.OrderID != resp.OrderID || order.Amount != 3000 || order.Email != "jenny@example.com" || len(order.Items) != 1 {
+		t.Errorf("order data = %+v", order)
+	}
+	if refunded.Type != "order.refunded" || refunded.ID == paid.ID || refunded.StripeEvent != "evt_refunded" {
+		t.Errorf("refunded event = %+v", refunded)
+	}
+	var sub service.SubscriptionEventData
+	if err := json.Unmarshal(canceled.Data, &sub); err != nil {
+		t.Fatal(err)
+	}
+	if canceled.Type != "subscription.canceled" || sub.SubscriptionID != "sub_1" || sub.CustomerID != "cus_test_subscriber" || sub.Status != "canceled" {
+		t.Errorf("canceled event = %+v, data %+v", canceled, sub)
+	}
+}
+
+func TestPlatformEventRetries(t *testing.T) {
+	e := newTestEnv(t)
+	bus := e.publishToBus()
+	bus.err = errors.New("broker unavailable")
+	e.deliverOK(subscriptionEvent("customer.subscription.deleted", "sub_1", "canceled"))
+	e.runJobs()
+
+	_, dead := e.svc.Jobs.Snapshot()
+	if len(dead) != 1 || dead[0].Type != service.JobPublishEvent {
+		t.Fatalf("dead jobs = %+v", dead)
+	}
+	bus.err = nil
+	e.svc.Jobs.Retry(dead[0].ID)
+	e.runJobs()
+	if len(bus.messages) != 1 || bus.messages[0].Type != "subscription.canceled" {
+		t.Errorf("messages after the retry = %+v", bus.messages)
+	}
+}
+
+func TestPlatformEventsDisabled(t *testing.T) {
+	e := newTestEnv(t)
+	e.deliverOK(subscriptionEvent("customer.subscription.deleted", "sub_1", "canceled"))
+	if pending, _ := e.svc.Jobs.Snapshot(); len(pending) != 0 {
+		t.Errorf("queued %+v without EVENT_BUS", pending)
+	}
+}
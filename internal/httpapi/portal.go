@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// authorizeCustomer checks that the caller may act for customer: an admin
+// credential, or a portal token for the customer. Without either, anyone
+// knowing a customer ID could see their payment methods and invoices,
+// cancel their subscriptions or attach a card that is later charged.
+// action names what is refused to other roles.
+func (srv *Server) authorizeCustomer(r *http.Request, customer, token, action string) error {
+	if bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); bearer != "" {
+		p, err := srv.svc.Authenticate(bearer, time.Now())
+		if err != nil {
+			logFor(r).Info("rejected credentials", "path", r.URL.Path, "error", err)
+			return &service.Error{Status: http.StatusUnauthorized, Message: http.StatusText(http.StatusUnauthorized)}
+		}
+		if p.Role != config.RoleAdmin {
+			return &service.Error{Status: http.StatusForbidden, Message: fmt.Sprintf("%s role can't %s", p.Role, action)}
+		}
+		return nil
+	}
+	if err := srv.svc.VerifyPortalToken(customer, token, time.Now()); err != nil {
+		return &service.Error{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	return nil
+}
+
+// handleCreatePortalSession creates a Billing Portal session where
+// subscribers manage their payment methods, invoices and cancellations.
+// Only the customer's own portal token or an admin credential opens it.
+func (srv *Server) handleCreatePortalSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req service.PortalRequest
+	if isJSONRequest(r) {
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	} else {
+		r.ParseForm()
+		req.Customer = r.PostFormValue("customer")
+		req.Token = r.PostFormValue("token")
+		req.ReturnURL = r.PostFormValue("returnUrl")
+		if err := req.Validate(r.Context(), srv.svc); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	}
+
+	if err := srv.authorizeCustomer(r, req.Customer, req.Token, "create portal sessions"); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	returnURL := req.ReturnURL
+	if returnURL == "" {
+		returnURL = srv.svc.Config.Domain + "/"
+	}
+
+	params := &stripe.BillingPortalSessionParams{
+		Customer:  stripe.String(req.Customer),
+		ReturnURL: stripe.String(returnURL),
+	}
+	params.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "portal_session"))
+	ps, err := srv.svc.Stripe.NewBillingPortalSession(r.Context(), params)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "creating portal session", Err: err})
+		return
+	}
+	if isJSONRequest(r) {
+		writeJSON(w, &service.PortalResponse{URL: ps.URL})
+		return
+	}
+	http.Redirect(w, r, ps.URL, http.StatusSeeOther)
+}
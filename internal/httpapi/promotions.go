@@ -0,0 +1,40 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func (srv *Server) handlePromotions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	params := &stripe.PromotionCodeListParams{
+		Active: stripe.Bool(true),
+	}
+	params.Filters.AddFilter("limit", "", "100")
+	codes, err := srv.svc.Stripe.ListPromotionCodes(r.Context(), params)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "listing promotion codes", Err: err})
+		return
+	}
+	list := []*service.Promotion{}
+	for _, pc := range codes {
+		p := &service.Promotion{
+			Code:      pc.Code,
+			ExpiresAt: pc.ExpiresAt,
+		}
+		if pc.Coupon != nil {
+			p.Name = pc.Coupon.Name
+			p.PercentOff = pc.Coupon.PercentOff
+			p.AmountOff = pc.Coupon.AmountOff
+			p.Currency = string(pc.Coupon.Currency)
+		}
+		list = append(list, p)
+	}
+	writeJSON(w, list)
+}
@@ -0,0 +1,26 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestPromotions(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.PromotionCodes = []*stripe.PromotionCode{
+		{Code: "WELCOME", Active: true, Coupon: &stripe.Coupon{Name: "Welcome", PercentOff: 10}},
+		{Code: "FIVER", Active: true, ExpiresAt: 1800000000, Coupon: &stripe.Coupon{AmountOff: 500, Currency: "usd"}},
+	}
+	w := e.do("GET", "/promotions", nil)
+	checkStatus(t, w, http.StatusOK)
+	var got []*service.Promotion
+	decodeBody(t, w, &got)
+	if len(got) != 2 || got[0].PercentOff != 10 || got[1].AmountOff != 500 || got[1].ExpiresAt != 1800000000 {
+		t.Errorf("promotions = %+v", got)
+	}
+	checkStatus(t, e.do("POST", "/promotions", nil), http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,151 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// handleAdminReviews serves GET /admin/reviews, newest first. It takes
+// status (open or closed), limit and offset.
+func (srv *Server) handleAdminReviews(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	q := r.URL.Query()
+	f := service.ReviewFilter{Limit: service.DefaultPageSize}
+	switch status := q.Get("status"); status {
+	case "":
+	case "open", "closed":
+		f.Open = stripe.Bool(status == "open")
+	default:
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid status %q: want open or closed", status)})
+		return
+	}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > service.MaxPageSize {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", service.MaxPageSize)})
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid offset %q", v)})
+			return
+		}
+	}
+	// Fetch one extra row to know whether there is another page.
+	limit := f.Limit
+	f.Limit++
+	list, err := srv.svc.Payments.ListReviews(r.Context(), f)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing reviews", err))
+		return
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []*service.Review{}
+	}
+	writeJSON(w, struct {
+		Reviews []*service.Review `json:"reviews"`
+		Limit   int               `json:"limit"`
+		Offset  int               `json:"offset"`
+		HasMore bool              `json:"hasMore"`
+	}{list, limit, f.Offset, hasMore})
+}
+
+// handleAdminReview serves GET /admin/reviews/{id},
+// POST /admin/reviews/{id}/approve, which approves the payment in Stripe and
+// releases its fulfillment, and POST /admin/reviews/{id}/refund, which
+// refunds it in full as fraudulent.
+func (srv *Server) handleAdminReview(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/admin/reviews/")
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	if len(parts) == 0 || len(parts) > 2 || (len(parts) == 2 && action != "approve" && action != "refund") {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if (action == "" && r.Method != "GET") || (action != "" && r.Method != "POST") {
+		writeMethodNotAllowed(w)
+		return
+	}
+	rv, err := srv.svc.Payments.GetReview(r.Context(), parts[0])
+	if err == service.ErrReviewNotFound {
+		writeError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, r, service.InternalError("fetching review", err))
+		return
+	}
+	if action != "" && !rv.Open {
+		writeError(w, r, &service.Error{Status: http.StatusConflict, Message: fmt.Sprintf("review is closed (%s)", rv.ClosedReason)})
+		return
+	}
+	switch action {
+	case "approve":
+		srv.approveReview(w, r, rv)
+	case "refund":
+		if rv.PaymentIntentID == "" {
+			writeError(w, r, &service.Error{Status: http.StatusConflict, Message: "review has no payment intent to refund"})
+			return
+		}
+		ctx := service.WithConfirmation(r.Context(), r.Header.Get(config.ConfirmationHeader))
+		rec, err := srv.svc.IssueRefund(ctx, &service.RefundRequest{PaymentIntentID: rv.PaymentIntentID, Reason: string(stripe.RefundReasonFraudulent)})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		// Stripe closes the review as refunded_as_fraud and says so with a
+		// review.closed event.
+		logFor(r).Info("reviewed payment refunded", "review", rv.ID, "refund", rec.ID)
+		writeJSON(w, rec)
+	default:
+		writeJSON(w, rv)
+	}
+}
+
+// approveReview approves rv in Stripe, stores the closed review and queues
+// the order's fulfillment.
+func (srv *Server) approveReview(w http.ResponseWriter, r *http.Request, rv *service.Review) {
+	params := &stripe.ReviewApproveParams{}
+	params.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "approve_review"))
+	sr, err := srv.svc.Stripe.ApproveReview(r.Context(), rv.ID, params)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "approving review", Err: err})
+		return
+	}
+	// The review is approved; record it even if the caller has gone away.
+	ctx := context.WithoutCancel(r.Context())
+	before := *rv
+	rec := service.ReviewFromStripe(sr)
+	rec.CreatedAt = rv.CreatedAt
+	if rec.PaymentIntentID == "" {
+		rec.PaymentIntentID = rv.PaymentIntentID
+	}
+	if err := srv.svc.Payments.SaveReview(ctx, rec); err != nil {
+		writeError(w, r, service.InternalError("saving review", err))
+		return
+	}
+	if err := srv.svc.ReleaseFulfillment(ctx, rec.PaymentIntentID); err != nil {
+		writeError(w, r, service.InternalError("queueing fulfillment", err))
+		return
+	}
+	logFor(r).Info("review approved", "review", rec.ID)
+	srv.svc.RecordAudit(ctx, service.AuditActor(ctx), "review.approved", rec.ID, &before, rec)
+	writeJSON(w, rec)
+}
@@ -0,0 +1,112 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// riskyCheckout checks out price_basic, delivers an elevated risk
+// charge.succeeded and a review.opened for it, then the session's
+// checkout.session.completed event. It returns the order ID and the payment
+// intent.
+func (e *testEnv) riskyCheckout() (orderID, paymentIntentID string) {
+	e.t.Helper()
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}})
+	checkStatus(e.t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(e.t, w, &resp)
+	paymentIntentID = "pi_" + resp.ID
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_charge", "object": "event", "type": "charge.succeeded", "data": {"object": {
+		"id": "ch_risky", "object": "charge", "payment_intent": %q, "outcome": {"risk_level": "elevated", "risk_score": 72}}}}`, paymentIntentID)))
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_review", "object": "event", "type": "review.opened", "data": {"object": {
+		"id": "prv_1", "object": "review", "charge": "ch_risky", "payment_intent": %q, "open": true, "opened_reason": "rule", "reason": "rule"}}}`, paymentIntentID)))
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_completed", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": %q, "payment_status": "paid",
+		"amount_total": 3000, "currency": "usd", "metadata": {"order": %q}}}}`, resp.ID, paymentIntentID, resp.OrderID)))
+	e.runJobs()
+	e.stripe.Reviews["prv_1"] = &stripe.Review{
+		ID: "prv_1", Open: true, OpenedReason: stripe.ReviewOpenedReasonRule,
+		Charge: &stripe.Charge{ID: "ch_risky"}, PaymentIntent: &stripe.PaymentIntent{ID: paymentIntentID},
+	}
+	return resp.OrderID, paymentIntentID
+}
+
+func TestReviewHoldsFulfillment(t *testing.T) {
+	e := newTestEnv(t)
+	e.fulfillmentRoutes("price_basic=license")
+	orderID, paymentIntentID := e.riskyCheckout()
+
+	if list := e.fulfillments(orderID); len(list) != 0 {
+		t.Fatalf("fulfillments of a payment in review = %+v", list)
+	}
+	p, err := e.svc.Payments.GetPaymentByIntent(context.Background(), paymentIntentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.RiskLevel != "elevated" || p.RiskScore != 72 {
+		t.Errorf("payment risk = %q %d, want elevated 72", p.RiskLevel, p.RiskScore)
+	}
+	if len(e.emails.Sent) == 0 || e.emails.Sent[len(e.emails.Sent)-1].Subject != "Payment in review: ch_risky" {
+		t.Errorf("emails = %+v, want the review alert", e.emails.Sent)
+	}
+
+	var list struct {
+		Reviews []*service.Review `json:"reviews"`
+	}
+	w := e.admin("GET", "/admin/reviews?status=open", nil)
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &list)
+	if len(list.Reviews) != 1 || list.Reviews[0].PaymentIntentID != paymentIntentID {
+		t.Fatalf("open reviews = %+v", list.Reviews)
+	}
+	checkStatus(t, e.admin("GET", "/admin/reviews?status=pending", nil), http.StatusBadRequest)
+
+	w = e.admin("POST", "/admin/reviews/prv_1/approve", nil)
+	checkStatus(t, w, http.StatusOK)
+	var rv service.Review
+	decodeBody(t, w, &rv)
+	if rv.Open || rv.ClosedReason != "approved" {
+		t.Errorf("approved review = %+v", rv)
+	}
+	e.runJobs()
+	if list := e.fulfillments(orderID); len(list) != 1 || list[0].Status != service.FulfillmentDone {
+		t.Errorf("fulfillments after approval = %+v", list)
+	}
+	if entries := e.audit("?action=review.approved"); len(entries) != 1 || entries[0].Object != "prv_1" {
+		t.Errorf("audit = %+v", entries)
+	}
+	checkErrorMessage(t, e.admin("POST", "/admin/reviews/prv_1/approve", nil), http.StatusConflict, "closed")
+	checkStatus(t, e.admin("GET", "/admin/reviews/prv_missing", nil), http.StatusNotFound)
+}
+
+func TestReviewRefund(t *testing.T) {
+	e := newTestEnv(t)
+	e.fulfillmentRoutes("price_basic=license")
+	orderID, paymentIntentID := e.riskyCheckout()
+	e.stripe.PaymentIntents[paymentIntentID] = &stripe.PaymentIntent{ID: paymentIntentID, Amount: 3000, Currency: "usd"}
+
+	checkStatus(t, e.admin("POST", "/admin/reviews/prv_1/refund", nil), http.StatusOK)
+	if len(e.stripe.RefundParams) != 1 || stripe.StringValue(e.stripe.RefundParams[0].Reason) != "fraudulent" {
+		t.Fatalf("refunds = %+v, want one fraudulent refund", e.stripe.RefundParams)
+	}
+
+	// Stripe closes the review; a review closed by refund doesn't release
+	// the order.
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_review_closed", "object": "event", "type": "review.closed", "data": {"object": {
+		"id": "prv_1", "object": "review", "charge": "ch_risky", "payment_intent": %q, "open": false, "opened_reason": "rule",
+		"closed_reason": "refunded_as_fraud", "reason": "refunded_as_fraud"}}}`, paymentIntentID)))
+	e.runJobs()
+	if list := e.fulfillments(orderID); len(list) != 0 {
+		t.Errorf("fulfillments after a fraud refund = %+v", list)
+	}
+	e.refulfill(orderID)
+	if list := e.fulfillments(orderID); len(list) != 0 {
+		t.Errorf("fulfillments of an elevated risk payment without an approved review = %+v", list)
+	}
+}
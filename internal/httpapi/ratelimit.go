@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"stripe_go/internal/service"
+)
+
+// rateLimiter is a token bucket per key (client IP). Each bucket holds up to
+// burst tokens and refills at rate tokens per second.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow takes a token for key. When the bucket is empty it returns false and
+// how long until the next token is available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have refilled completely, since they behave the
+// same as new ones. The caller must hold l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// strictRateLimitPaths create Stripe objects on every call, so they get the
+// tighter session limit on top of the general one.
+var strictRateLimitPaths = map[string]bool{
+	"/create-checkout-session":     true,
+	"/create-subscription-session": true,
+	"/create-donation-session":     true,
+	"/create-setup-session":        true,
+	"/create-payment-intent":       true,
+	"/create-portal-session":       true,
+}
+
+// strictRateLimit reports whether path is one of strictRateLimitPaths, the
+// checkout of a saved cart (/carts/{id}/checkout) or the recovery of an
+// abandoned checkout (/recover/{id}), which create sessions too.
+func strictRateLimit(path string) bool {
+	if strictRateLimitPaths[path] {
+		return true
+	}
+	if strings.HasPrefix(path, "/carts/") {
+		parts := pathParams(path, "/carts/")
+		return len(parts) == 2 && parts[1] == "checkout"
+	}
+	return strings.HasPrefix(path, "/recover/") && len(pathParams(path, "/recover/")) == 1
+}
+
+// withRateLimit rejects clients that exceed the configured request rates with
+// 429 Too Many Requests. Stripe's webhook deliveries and health probes are
+// never limited. It runs inside withTenantRouting, so a tenant's /t/{id}/
+// paths are limited like the paths they stand for.
+func (srv *Server) withRateLimit(next http.Handler) http.Handler {
+	if !srv.svc.Config.RateLimitEnabled {
+		return next
+	}
+	general := newRateLimiter(srv.svc.Config.RateLimitPerMinute, srv.svc.Config.RateLimitBurst)
+	strict := newRateLimiter(srv.svc.Config.SessionRateLimitPerMinute, srv.svc.Config.SessionRateLimitBurst)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/webhook" || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := srv.clientIP(r)
+		now := time.Now()
+		ok, wait := general.allow(ip, now)
+		if ok && strictRateLimit(r.URL.Path) {
+			ok, wait = strict.allow(ip, now)
+		}
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, r, service.ErrRateLimited)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the caller's IP address. X-Forwarded-For is only trusted
+// when TRUST_PROXY is set, and then only its last entry, the one our proxy
+// added: clients can send anything in the entries before it.
+func (srv *Server) clientIP(r *http.Request) string {
+	if srv.svc.Config.TrustProxy {
+		fwd := r.Header.Values("X-Forwarded-For")
+		if len(fwd) > 0 {
+			last := fwd[len(fwd)-1]
+			if ip := strings.TrimSpace(last[strings.LastIndex(last, ",")+1:]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,114 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(60, 2)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("1.2.3.4", now); !ok {
+			t.Fatalf("request %d within the burst was limited", i+1)
+		}
+	}
+	ok, wait := l.allow("1.2.3.4", now)
+	if ok || wait <= 0 {
+		t.Fatalf("third request: ok %v, wait %v; want limited", ok, wait)
+	}
+	if ok, _ := l.allow("5.6.7.8", now); !ok {
+		t.Error("other clients share the bucket")
+	}
+	if ok, _ := l.allow("1.2.3.4", now.Add(time.Second)); !ok {
+		t.Error("token not refilled after a second at 60/minute")
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.RateLimitEnabled = true
+	e.svc.Config.RateLimitPerMinute, e.svc.Config.RateLimitBurst = 100, 100
+	e.svc.Config.SessionRateLimitPerMinute, e.svc.Config.SessionRateLimitBurst = 1, 1
+	h := e.srv.withRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		return w
+	}
+	checkStatus(t, send("/create-checkout-session"), http.StatusOK)
+	w := send("/create-checkout-session")
+	checkStatus(t, w, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	checkStatus(t, send("/config"), http.StatusOK)
+	for i := 0; i < 200; i++ {
+		checkStatus(t, send("/webhook"), http.StatusOK)
+		checkStatus(t, send("/healthz"), http.StatusOK)
+	}
+}
+
+func TestStrictRateLimitPaths(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/create-checkout-session": true,
+		"/create-donation-session": true,
+		"/create-setup-session":    true,
+		"/carts/cart_1/checkout":   true,
+		"/recover/ac_1":            true,
+		"/carts":                   false,
+		"/carts/cart_1":            false,
+		"/recover/":                false,
+		"/config":                  false,
+	} {
+		if got := strictRateLimit(path); got != want {
+			t.Errorf("strictRateLimit(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestWithRateLimitTenantPaths(t *testing.T) {
+	e := newTestEnv(t)
+	e.addTestTenant()
+	e.svc.Config.RateLimitEnabled = true
+	e.svc.Config.RateLimitPerMinute, e.svc.Config.RateLimitBurst = 100, 100
+	e.svc.Config.SessionRateLimitPerMinute, e.svc.Config.SessionRateLimitBurst = 1, 1
+	h := e.srv.withTenantRouting(e.srv.withRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	send := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		return w
+	}
+	checkStatus(t, send("/t/acme/create-checkout-session"), http.StatusOK)
+	checkStatus(t, send("/t/acme/create-checkout-session"), http.StatusTooManyRequests)
+	for i := 0; i < 200; i++ {
+		checkStatus(t, send("/t/acme/webhook"), http.StatusOK)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	e := newTestEnv(t)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7, 203.0.113.9")
+	if got := e.srv.clientIP(r); got != "10.0.0.1" {
+		t.Errorf("untrusted proxy: clientIP = %q", got)
+	}
+	// The proxy appends the address it saw to whatever the client sent.
+	e.svc.Config.TrustProxy = true
+	if got := e.srv.clientIP(r); got != "203.0.113.9" {
+		t.Errorf("trusted proxy: clientIP = %q", got)
+	}
+	r.Header.Add("X-Forwarded-For", "192.0.2.4")
+	if got := e.srv.clientIP(r); got != "192.0.2.4" {
+		t.Errorf("repeated header: clientIP = %q", got)
+	}
+	r.Header.Set("X-Forwarded-For", "198.51.100.7,")
+	if got := e.srv.clientIP(r); got != "10.0.0.1" {
+		t.Errorf("empty last entry: clientIP = %q", got)
+	}
+}
@@ -0,0 +1,65 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleReceipt serves GET /receipts/{sessionID}.pdf?token=... for paid
+// sessions. The token from the confirmation email stands in for a login.
+func (srv *Server) handleReceipt(w http.ResponseWriter, r *http.Request) {
+	if srv.svc.Config.ReceiptSigningKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	parts := pathParams(r.URL.Path, "/receipts/")
+	if len(parts) != 1 || !strings.HasSuffix(parts[0], ".pdf") {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	id := strings.TrimSuffix(parts[0], ".pdf")
+	if err := validateSessionID(id); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	if err := srv.svc.VerifyReceiptToken(id, r.URL.Query().Get("token"), time.Now()); err != nil {
+		writeError(w, r, &service.Error{Status: http.StatusForbidden, Message: err.Error()})
+		return
+	}
+
+	params := &stripe.CheckoutSessionParams{}
+	params.AddExpand("payment_intent")
+	s, err := srv.svc.Stripe.GetCheckoutSession(r.Context(), id, params)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "fetching session", Err: err})
+		return
+	}
+	if s.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+		writeError(w, r, &service.Error{Status: http.StatusNotFound, Message: "the receipt is available once the payment has succeeded"})
+		return
+	}
+	items, err := srv.svc.Stripe.ListCheckoutSessionLineItems(r.Context(), id, &stripe.CheckoutSessionListLineItemsParams{})
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "listing line items", Err: err})
+		return
+	}
+	data, err := srv.svc.NewReceiptDocument(s, items, time.Now()).PDF()
+	if err != nil {
+		writeError(w, r, service.InternalError("rendering receipt", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "receipt-"+id+".pdf"))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(data)
+}
@@ -0,0 +1,150 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+const testReceiptKey = "receipt-signing-key-for-tests-0123456789"
+
+func TestReceiptToken(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.ReceiptSigningKey = testReceiptKey
+	now := time.Now()
+	token := e.svc.ReceiptToken("cs_test_1", now.Add(time.Hour))
+
+	if err := e.svc.VerifyReceiptToken("cs_test_1", token, now); err != nil {
+		t.Errorf("valid token: %v", err)
+	}
+	if err := e.svc.VerifyReceiptToken("cs_test_2", token, now); err == nil {
+		t.Error("token accepted for another session")
+	}
+	if err := e.svc.VerifyReceiptToken("cs_test_1", token, now.Add(2*time.Hour)); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired token: %v", err)
+	}
+	exp, sig, _ := strings.Cut(token, ".")
+	later := e.svc.ReceiptToken("cs_test_1", now.Add(48*time.Hour))
+	laterExp, _, _ := strings.Cut(later, ".")
+	if err := e.svc.VerifyReceiptToken("cs_test_1", laterExp+"."+sig, now); err == nil {
+		t.Error("token accepted with a forged expiry")
+	}
+	if err := e.svc.VerifyReceiptToken("cs_test_1", exp, now); err == nil {
+		t.Error("token accepted without a signature")
+	}
+}
+
+// paidSession creates a checkout session for two units and marks it paid.
+func (e *testEnv) paidSession() *stripe.CheckoutSession {
+	e.t.Helper()
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+	})
+	checkStatus(e.t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(e.t, w, &resp)
+	s := e.stripe.Sessions[resp.ID]
+	s.PaymentStatus = stripe.CheckoutSessionPaymentStatusPaid
+	s.PaymentIntent = &stripe.PaymentIntent{ID: "pi_test_receipt", Created: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).Unix()}
+	s.CustomerDetails = &stripe.CheckoutSessionCustomerDetails{Email: "jenny@example.com"}
+	return s
+}
+
+func TestReceiptDownload(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.ReceiptSigningKey = testReceiptKey
+	e.svc.Config.ReceiptLinkTTL = time.Hour
+	s := e.paidSession()
+	link := strings.TrimPrefix(e.svc.ReceiptURL(s.ID), e.svc.Config.Domain)
+
+	w := e.do("GET", link, nil)
+	checkStatus(t, w, http.StatusOK)
+	if ct := w.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="receipt-`+s.ID+`.pdf"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if !bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF-")) {
+		t.Errorf("body isn't a PDF: %q", w.Body.Bytes()[:min(20, w.Body.Len())])
+	}
+
+	checkErrorMessage(t, e.do("GET", "/receipts/"+s.ID+".pdf?token=123.abc", nil), http.StatusForbidden, "invalid receipt link")
+	checkStatus(t, e.do("GET", "/receipts/"+s.ID+".pdf", nil), http.StatusForbidden)
+	checkStatus(t, e.do("GET", strings.Replace(link, ".pdf", ".txt", 1), nil), http.StatusNotFound)
+	checkStatus(t, e.do("POST", link, nil), http.StatusMethodNotAllowed)
+
+	s.PaymentStatus = stripe.CheckoutSessionPaymentStatusUnpaid
+	checkErrorMessage(t, e.do("GET", link, nil), http.StatusNotFound, "once the payment has succeeded")
+}
+
+func TestReceiptDownloadDisabled(t *testing.T) {
+	e := newTestEnv(t)
+	s := e.paidSession()
+	e.svc.Config.ReceiptSigningKey = testReceiptKey
+	link := strings.TrimPrefix(e.svc.ReceiptURL(s.ID), e.svc.Config.Domain)
+	e.svc.Config.ReceiptSigningKey = ""
+	checkStatus(t, e.do("GET", link, nil), http.StatusNotFound)
+}
+
+func TestReceiptDocument(t *testing.T) {
+	e := newTestEnv(t)
+	s := e.paidSession()
+	s.Metadata = map[string]string{"order_id": "A-1001"}
+	s.TotalDetails = &stripe.CheckoutSessionTotalDetails{AmountDiscount: 300, AmountTax: 250}
+	s.AmountTotal = s.AmountSubtotal - 300 + 250
+	items, err := e.svc.Stripe.ListCheckoutSessionLineItems(context.Background(), s.ID, &stripe.CheckoutSessionListLineItemsParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := e.svc.NewReceiptDocument(s, items, time.Now())
+	if doc.PaymentIntentID != "pi_test_receipt" || doc.Order != "A-1001" || doc.Email != "jenny@example.com" {
+		t.Errorf("header = %+v", doc)
+	}
+	if got := doc.Date.Format(time.DateOnly); got != "2024-03-01" {
+		t.Errorf("dated %s, want the payment's date", got)
+	}
+	if len(doc.Lines) != 1 || doc.Lines[0].Description != "Basic" || doc.Lines[0].Quantity != 2 || doc.Lines[0].UnitAmount != "15.00 USD" || doc.Lines[0].Amount != "30.00 USD" {
+		t.Errorf("lines = %+v", doc.Lines)
+	}
+	var labels []string
+	for _, total := range doc.Totals {
+		labels = append(labels, total[0]+" "+total[1])
+	}
+	want := []string{"Subtotal 30.00 USD", "Discount -3.00 USD", "Tax 2.50 USD", "Total paid 29.50 USD"}
+	if strings.Join(labels, "|") != strings.Join(want, "|") {
+		t.Errorf("totals = %q, want %q", labels, want)
+	}
+	if _, err := doc.PDF(); err != nil {
+		t.Errorf("rendering: %v", err)
+	}
+}
+
+func TestConfirmationEmailLinksReceipt(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.ReceiptSigningKey = testReceiptKey
+	e.svc.Config.ReceiptLinkTTL = time.Hour
+	payload, err := os.ReadFile("testdata/webhooks/checkout_session_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := e.deliver(payload); status != http.StatusOK {
+		t.Fatalf("webhook: %d %s", status, body)
+	}
+	e.runJobs()
+	if len(e.emails.Sent) != 1 {
+		t.Fatalf("sent %d emails", len(e.emails.Sent))
+	}
+	if html := e.emails.Sent[0].HTML; !strings.Contains(html, "http://localhost:4242/receipts/cs_test_completed.pdf?token=") {
+		t.Errorf("email doesn't link the receipt:\n%s", html)
+	}
+}
@@ -0,0 +1,31 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"stripe_go/internal/service"
+)
+
+// handleAdminReconcile serves GET /admin/reconcile, the drift found so far
+// and the last run's report, and POST /admin/reconcile to run now.
+func (srv *Server) handleAdminReconcile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, srv.svc.ReconcileTotals())
+	case "POST":
+		// Repairs replay events through the webhook handlers.
+		if err := srv.confirmRequest(r, "reconcile.run", "reconcile", 0); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		report, err := srv.svc.Reconcile(r.Context(), time.Now().Add(-srv.svc.Config.ReconcileWindow))
+		if err != nil {
+			writeError(w, r, &service.StripeFailure{Action: "reconciling payments", Err: err})
+			return
+		}
+		writeJSON(w, report)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
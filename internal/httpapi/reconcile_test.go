@@ -0,0 +1,143 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func (e *testEnv) reconcile() *service.ReconcileReport {
+	e.t.Helper()
+	w := e.admin("POST", "/admin/reconcile", nil)
+	checkStatus(e.t, w, http.StatusOK)
+	var report service.ReconcileReport
+	decodeBody(e.t, w, &report)
+	e.runJobs()
+	return &report
+}
+
+func TestReconcileRepairsMissedCompletion(t *testing.T) {
+	e := newTestEnv(t)
+	s := e.paidSession()
+	s.Status = stripe.CheckoutSessionStatusComplete
+
+	report := e.reconcile()
+	if report.SessionsChecked != 1 || report.Repaired != 1 || len(report.Drift) != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	if d := report.Drift[0]; d.Object != s.ID || d.Local != "unpaid" || d.Stripe != "complete/paid" || d.Event != "checkout.session.completed" {
+		t.Errorf("drift = %+v", d)
+	}
+	if p := e.payment(s.ID); p.Status != "paid" || p.PaymentIntentID != "pi_test_receipt" {
+		t.Errorf("payment = %+v", p)
+	}
+	if o := e.order(s.Metadata[service.OrderMetadataKey]); o.Status != service.OrderPaid {
+		t.Errorf("order status = %s, want paid", o.Status)
+	}
+	if len(e.emails.Sent) != 1 {
+		t.Errorf("sent %d emails, want the missed receipt", len(e.emails.Sent))
+	}
+
+	// Once repaired there is nothing left to do.
+	if report := e.reconcile(); len(report.Drift) != 0 {
+		t.Errorf("second run found drift: %+v", report.Drift)
+	}
+	if len(e.emails.Sent) != 1 {
+		t.Errorf("sent %d emails after the second run", len(e.emails.Sent))
+	}
+	w := e.admin("GET", "/admin/reconcile", nil)
+	checkStatus(t, w, http.StatusOK)
+	var stats service.ReconcileStats
+	decodeBody(t, w, &stats)
+	if stats.Runs != 2 || stats.Drift != 1 || stats.Repaired != 1 || stats.Last == nil {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestReconcileLeavesUpToDatePayments(t *testing.T) {
+	e := newTestEnv(t)
+	s := e.paidSession()
+	s.Status = stripe.CheckoutSessionStatusComplete
+	if err := e.svc.UpdatePaymentStatus(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	// Still awaiting an async payment, so nothing is missing yet.
+	pending := e.paidSession()
+	pending.Status = stripe.CheckoutSessionStatusComplete
+	pending.PaymentStatus = stripe.CheckoutSessionPaymentStatusUnpaid
+
+	if report := e.reconcile(); report.SessionsChecked != 2 || len(report.Drift) != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if len(e.emails.Sent) != 0 {
+		t.Errorf("sent %d emails", len(e.emails.Sent))
+	}
+}
+
+func TestReconcileCancelsExpiredOrders(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.Inventory = map[string]int64{"price_basic": 5}
+	if err := e.svc.SeedInventory(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := e.paidSession()
+	s.Status = stripe.CheckoutSessionStatusExpired
+	s.PaymentStatus = stripe.CheckoutSessionPaymentStatusUnpaid
+
+	report := e.reconcile()
+	if len(report.Drift) != 1 || report.Drift[0].Event != "checkout.session.expired" {
+		t.Fatalf("drift = %+v", report.Drift)
+	}
+	if o := e.order(s.Metadata[service.OrderMetadataKey]); o.Status != service.OrderCanceled {
+		t.Errorf("order status = %s, want canceled", o.Status)
+	}
+	if item := e.inventory("price_basic"); item.Reserved != 0 {
+		t.Errorf("reserved = %d after expiry, want 0", item.Reserved)
+	}
+}
+
+func TestReconcilePaymentIntents(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-payment-intent", map[string]interface{}{"amount": 2000, "currency": "usd"})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.PaymentIntentResponse
+	decodeBody(t, w, &resp)
+	pi := e.stripe.PaymentIntents[resp.ID]
+	pi.Status = stripe.PaymentIntentStatusSucceeded
+	pi.Created = time.Now().Unix()
+	// Intents the store doesn't know belong to open checkout sessions.
+	e.stripe.PaymentIntents["pi_test_unknown"] = &stripe.PaymentIntent{ID: "pi_test_unknown", Status: stripe.PaymentIntentStatusSucceeded, Created: pi.Created}
+	// Intents from before the window aren't listed.
+	e.stripe.PaymentIntents["pi_test_old"] = &stripe.PaymentIntent{ID: "pi_test_old", Status: stripe.PaymentIntentStatusSucceeded}
+
+	report := e.reconcile()
+	if report.PaymentIntentsChecked != 2 || len(report.Drift) != 1 || report.Drift[0].Event != "payment_intent.succeeded" {
+		t.Fatalf("report = %+v", report)
+	}
+	if p := e.payment(resp.ID); p.Status != "paid" {
+		t.Errorf("status = %q, want paid", p.Status)
+	}
+
+	// A refund recorded locally is newer than the intent's status.
+	p := e.payment(resp.ID)
+	p.Status = "refunded"
+	if err := e.svc.Payments.SavePayment(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if report := e.reconcile(); len(report.Drift) != 0 {
+		t.Errorf("drift = %+v", report.Drift)
+	}
+}
+
+func TestReconcileStripeError(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Err = &stripe.Error{HTTPStatusCode: http.StatusTooManyRequests, Msg: "rate limited"}
+	checkErrorMessage(t, e.admin("POST", "/admin/reconcile", nil), http.StatusServiceUnavailable, "error while reconciling payments")
+	checkStatus(t, e.admin("DELETE", "/admin/reconcile", nil), http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"net/http"
+	"sort"
+
+	"stripe_go/internal/service"
+)
+
+// handleRecover serves GET /recover/{id}, the link in recovery emails, by
+// redirecting to a session for the abandoned cart.
+func (srv *Server) handleRecover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	parts := pathParams(r.URL.Path, "/recover/")
+	if len(parts) != 1 {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	url, err := srv.svc.RecoverCheckout(r.Context(), parts[0], r.Header.Get("Accept-Language"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// RecoveryReport sums up the abandoned checkouts of a period: how many were
+// emailed and recovered, and per currency what was left behind and won
+// back. ConversionRate is the share of emailed checkouts recovered.
+type RecoveryReport struct {
+	Abandoned      int                          `json:"abandoned"`
+	Emailed        int                          `json:"emailed"`
+	Recovered      int                          `json:"recovered"`
+	ConversionRate float64                      `json:"conversionRate"`
+	Currencies     []*RecoveryCurrency          `json:"currencies"`
+	Checkouts      []*service.AbandonedCheckout `json:"checkouts"`
+}
+
+// RecoveryCurrency is a period's abandoned and recovered totals in one
+// currency.
+type RecoveryCurrency struct {
+	Currency  string `json:"currency"`
+	Abandoned int64  `json:"abandoned"`
+	Recovered int64  `json:"recovered"`
+}
+
+// handleAdminRecovery serves GET /admin/recovery?from=2024-01-01&to=2024-01-31,
+// the report of the checkouts abandoned in that period. Checkouts lists the
+// latest limit of them.
+func (srv *Server) handleAdminRecovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	f, err := parsePaymentFilter(r)
+	if err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	list, err := srv.svc.Payments.ListAbandonedCheckouts(r.Context(), f.From, f.To)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing abandoned checkouts", err))
+		return
+	}
+	report := &RecoveryReport{Abandoned: len(list), Currencies: []*RecoveryCurrency{}, Checkouts: []*service.AbandonedCheckout{}}
+	currencies := map[string]*RecoveryCurrency{}
+	for i, a := range list {
+		c, ok := currencies[a.Currency]
+		if !ok {
+			c = &RecoveryCurrency{Currency: a.Currency}
+			currencies[a.Currency] = c
+			report.Currencies = append(report.Currencies, c)
+		}
+		c.Abandoned += a.Amount
+		if a.EmailedAt != nil {
+			report.Emailed++
+		}
+		if a.Status == service.AbandonedRecovered {
+			report.Recovered++
+			c.Recovered += a.RecoveredAmount
+		}
+		if i < f.Limit {
+			report.Checkouts = append(report.Checkouts, a)
+		}
+	}
+	if report.Emailed > 0 {
+		report.ConversionRate = float64(report.Recovered) / float64(report.Emailed)
+	}
+	sort.Slice(report.Currencies, func(i, j int) bool { return report.Currencies[i].Currency < report.Currencies[j].Currency })
+	writeJSON(w, report)
+}
@@ -0,0 +1,196 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestCheckoutSessionTTL(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.CheckoutSessionTTL = 2 * time.Hour
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	expires := time.Unix(stripe.Int64Value(e.stripe.SessionParams[0].ExpiresAt), 0)
+	if d := time.Until(expires); d < 119*time.Minute || d > 2*time.Hour {
+		t.Errorf("session expires in %s, want CHECKOUT_SESSION_TTL", d)
+	}
+	if resp.ExpiresAt == nil || !resp.ExpiresAt.Equal(expires) {
+		t.Errorf("expiresAt = %v, want %v", resp.ExpiresAt, expires)
+	}
+
+	w = e.do("POST", "/create-donation-session", map[string]interface{}{"amount": 500})
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &resp)
+	if resp.ExpiresAt == nil || e.stripe.SessionParams[1].ExpiresAt == nil {
+		t.Errorf("donation session has no expiry: %+v", resp)
+	}
+	if e.stripe.SessionParams[0].AfterExpiration != nil || e.stripe.SessionParams[0].ConsentCollection != nil {
+		t.Error("recovery enabled without CHECKOUT_RECOVERY")
+	}
+}
+
+func TestCheckoutRecoveryEmail(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.CheckoutRecovery = true
+	e.svc.Config.AllowPromotionCodes = true
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}}), http.StatusOK)
+	params := e.stripe.SessionParams[0]
+	if r := params.AfterExpiration.Recovery; !*r.Enabled || !*r.AllowPromotionCodes {
+		t.Errorf("recovery = %+v", r)
+	}
+	if *params.ConsentCollection.Promotions != "auto" {
+		t.Errorf("consent collection = %q", *params.ConsentCollection.Promotions)
+	}
+
+	e.deliverOK([]byte(`{"id": "evt_expired_consent", "object": "event", "type": "checkout.session.expired", "data": {"object": {
+		"id": "cs_test_recover", "object": "checkout.session", "mode": "payment", "amount_total": 3000, "currency": "eur", "locale": "fr",
+		"customer_details": {"email": "jenny@example.com"}, "consent": {"promotions": "opt_in"},
+		"after_expiration": {"recovery": {"enabled": true, "url": "https://buy.stripe.com/r/test_recover", "expires_at": 1714478400}}}}}`))
+	// Customers who didn't agree to be contacted aren't emailed.
+	e.deliverOK([]byte(`{"id": "evt_expired_no_consent", "object": "event", "type": "checkout.session.expired", "data": {"object": {
+		"id": "cs_test_quiet", "object": "checkout.session", "mode": "payment", "amount_total": 3000, "currency": "eur",
+		"customer_details": {"email": "quiet@example.com"}, "consent": {"promotions": "opt_out"},
+		"after_expiration": {"recovery": {"enabled": true, "url": "https://buy.stripe.com/r/test_quiet", "expires_at": 1714478400}}}}}`))
+	e.runJobs()
+	if len(e.emails.Sent) != 1 {
+		t.Fatalf("sent %+v, want one recovery email", e.emails.Sent)
+	}
+	msg := e.emails.Sent[0]
+	if msg.To != "jenny@example.com" || msg.Subject != "Votre panier vous attend" ||
+		!strings.Contains(msg.Text, e.svc.Config.Domain+"/recover/abn_") || !strings.Contains(msg.Text, "30,00 EUR") || !strings.Contains(msg.Text, "30/04/2024") {
+		t.Errorf("recovery email = %+v", msg)
+	}
+	a, err := e.svc.Payments.GetAbandonedCheckoutBySession(context.Background(), "cs_test_recover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Status != service.AbandonedEmailed || a.StripeRecoveryURL != "https://buy.stripe.com/r/test_recover" || a.EmailedAt == nil {
+		t.Errorf("abandoned checkout = %+v", a)
+	}
+	// Stripe's recovery URL lapsed on 30 April 2024.
+	checkErrorMessage(t, e.do("GET", "/recover/"+a.ID, nil), http.StatusGone, "this recovery link has expired")
+
+	// A redelivered event with a new id doesn't email the customer again.
+	e.deliverOK([]byte(`{"id": "evt_expired_consent_again", "object": "event", "type": "checkout.session.expired", "data": {"object": {
+		"id": "cs_test_recover", "object": "checkout.session", "mode": "payment", "amount_total": 3000, "currency": "eur", "locale": "fr",
+		"customer_details": {"email": "jenny@example.com"}, "consent": {"promotions": "opt_in"},
+		"after_expiration": {"recovery": {"enabled": true, "url": "https://buy.stripe.com/r/test_recover", "expires_at": 1714478400}}}}}`))
+	e.runJobs()
+	if len(e.emails.Sent) != 1 {
+		t.Errorf("sent %d emails, want one", len(e.emails.Sent))
+	}
+
+	// Sessions opened from Stripe's URL say which session they recover.
+	e.deliverOK([]byte(`{"id": "evt_recovered", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": "cs_test_recovered", "object": "checkout.session", "mode": "payment", "amount_total": 3000, "currency": "eur",
+		"recovered_from": "cs_test_recover"}}}`))
+	a, _ = e.svc.Payments.GetAbandonedCheckout(context.Background(), a.ID)
+	if a.Status != service.AbandonedRecovered || a.RecoverySessionID != "cs_test_recovered" || a.RecoveredAmount != 3000 {
+		t.Errorf("abandoned checkout after recovery = %+v", a)
+	}
+}
+
+func TestAbandonedCartRecovery(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.CheckoutRecovery = true
+	e.svc.Config.RecoveryEmailDelay = 2 * time.Hour
+	e.svc.Config.RecoveryCoupon = "COMEBACK"
+	e.stripe.Coupons["COMEBACK"] = &stripe.Coupon{ID: "COMEBACK", Valid: true}
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+		"metadata": map[string]string{"ref": "newsletter"},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var first service.CreateCheckoutResponse
+	decodeBody(t, w, &first)
+	metadata, err := json.Marshal(e.stripe.Sessions[first.ID].Metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_abandoned", "object": "event", "type": "checkout.session.expired", "data": {"object": {
+		"id": %q, "object": "checkout.session", "mode": "payment", "amount_total": 3000, "currency": "usd", "locale": "en",
+		"customer_details": {"email": "jenny@example.com"}, "consent": {"promotions": "opt_in"}, "metadata": %s}}}`, first.ID, metadata)))
+
+	// The email waits for RECOVERY_EMAIL_DELAY.
+	e.runJobs()
+	if len(e.emails.Sent) != 0 {
+		t.Fatalf("sent %+v before RECOVERY_EMAIL_DELAY", e.emails.Sent)
+	}
+	pending, _ := e.svc.Jobs.Snapshot()
+	if len(pending) != 1 || pending[0].Type != service.JobSendRecoveryEmail || time.Until(pending[0].NextRunAt) < 119*time.Minute {
+		t.Fatalf("pending jobs = %+v, want the recovery email in 2h", pending)
+	}
+	var id string
+	if err := json.Unmarshal(pending[0].Payload, &id); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.svc.SendRecoveryEmail(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	link := e.svc.Config.Domain + "/recover/" + id
+	if len(e.emails.Sent) != 1 || !strings.Contains(e.emails.Sent[0].Text, link) || !strings.Contains(e.emails.Sent[0].Text, "A discount is applied") {
+		t.Fatalf("sent %+v, want a recovery email linking to %s", e.emails.Sent, link)
+	}
+
+	// The link creates a new session for the same items, with the coupon.
+	w = e.do("GET", "/recover/"+id, nil)
+	checkStatus(t, w, http.StatusSeeOther)
+	params := e.stripe.SessionParams[1]
+	if d := params.Discounts; len(d) != 1 || stripe.StringValue(d[0].Coupon) != "COMEBACK" {
+		t.Errorf("discounts = %+v", params.Discounts)
+	}
+	if params.Metadata[service.RecoveryMetadataKey] != id || params.Metadata["ref"] != "newsletter" || params.Metadata[service.OrderMetadataKey] == first.OrderID {
+		t.Errorf("metadata = %+v", params.Metadata)
+	}
+	if len(params.LineItems) != 1 || *params.LineItems[0].Quantity != 2 || stripe.StringValue(params.CustomerEmail) != "jenny@example.com" {
+		t.Errorf("line items = %+v, email = %v", params.LineItems, params.CustomerEmail)
+	}
+	var recovered *stripe.CheckoutSession
+	for _, s := range e.stripe.Sessions {
+		if s.Metadata[service.RecoveryMetadataKey] == id {
+			recovered = s
+		}
+	}
+	if loc := w.Header().Get("Location"); loc != recovered.URL {
+		t.Errorf("Location = %q, want %q", loc, recovered.URL)
+	}
+
+	// Clicking again while it is open returns to the same session.
+	recovered.Status = stripe.CheckoutSessionStatusOpen
+	checkStatus(t, e.do("GET", "/recover/"+id, nil), http.StatusSeeOther)
+	if len(e.stripe.SessionParams) != 2 {
+		t.Errorf("sessions created = %d, want the open one reused", len(e.stripe.SessionParams))
+	}
+
+	metadata, _ = json.Marshal(recovered.Metadata)
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_recovered", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "mode": "payment", "amount_total": 2700, "currency": "usd", "metadata": %s}}}`, recovered.ID, metadata)))
+	checkErrorMessage(t, e.do("GET", "/recover/"+id, nil), http.StatusConflict, "this checkout has been completed")
+
+	var report RecoveryReport
+	w = e.admin("GET", "/admin/recovery?from=2000-01-01", nil)
+	checkStatus(t, w, http.StatusOK)
+	decodeBody(t, w, &report)
+	if report.Abandoned != 1 || report.Emailed != 1 || report.Recovered != 1 || report.ConversionRate != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.Currencies) != 1 || *report.Currencies[0] != (RecoveryCurrency{Currency: "usd", Abandoned: 3000, Recovered: 2700}) {
+		t.Errorf("currencies = %+v", report.Currencies)
+	}
+	if len(report.Checkouts) != 1 || report.Checkouts[0].RecoverySessionID != recovered.ID {
+		t.Errorf("checkouts = %+v", report.Checkouts)
+	}
+	checkStatus(t, e.do("GET", "/admin/recovery", nil), http.StatusUnauthorized)
+	checkErrorMessage(t, e.do("GET", "/recover/abn_nope", nil), http.StatusNotFound, "abandoned checkout not found")
+}
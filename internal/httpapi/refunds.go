@@ -0,0 +1,46 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+func (srv *Server) handleRefunds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req service.RefundRequest
+	if isJSONRequest(r) {
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	} else {
+		r.ParseForm()
+		req.PaymentIntentID = r.PostFormValue("paymentIntentId")
+		req.Reason = r.PostFormValue("reason")
+		if amount := r.PostFormValue("amount"); amount != "" {
+			var err error
+			req.Amount, err = strconv.ParseInt(amount, 10, 64)
+			if err != nil {
+				writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("error parsing amount %v", err.Error())})
+				return
+			}
+		}
+		if err := req.Validate(r.Context(), srv.svc); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	}
+	rec, err := srv.svc.IssueRefund(service.WithConfirmation(r.Context(), r.Header.Get(config.ConfirmationHeader)), &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, rec)
+}
@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestRefunds(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.PaymentIntents["pi_test_seed"] = &stripe.PaymentIntent{ID: "pi_test_seed", Amount: 3000, Currency: "usd"}
+	e.seedPayment()
+
+	w := e.admin("POST", "/refunds", service.RefundRequest{PaymentIntentID: "pi_test_seed", Amount: 1000, Reason: "requested_by_customer"})
+	checkStatus(t, w, http.StatusOK)
+	var got service.Refund
+	decodeBody(t, w, &got)
+	if got.Amount != 1000 || got.Reason != "requested_by_customer" || got.Status != "succeeded" {
+		t.Errorf("refund = %+v", got)
+	}
+
+	// Form posts without an amount refund the rest.
+	checkStatus(t, e.admin("POST", "/refunds", url.Values{"paymentIntentId": {"pi_test_seed"}}), http.StatusOK)
+	if p := e.stripe.RefundParams[1]; p.Amount != nil {
+		t.Errorf("full refund sent amount %d", *p.Amount)
+	}
+
+	refunds, err := e.svc.Payments.ListRefunds(context.Background(), "pi_test_seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 2 {
+		t.Errorf("stored %d refunds, want 2", len(refunds))
+	}
+}
+
+func TestRefundsValidation(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedPayment()
+	for _, tt := range []struct {
+		body interface{}
+		want string
+	}{
+		{service.RefundRequest{}, "paymentIntentId is required"},
+		{service.RefundRequest{PaymentIntentID: "ch_1"}, "invalid paymentIntentId"},
+		{service.RefundRequest{PaymentIntentID: "pi_test_seed", Amount: -5}, "amount must be positive"},
+		{service.RefundRequest{PaymentIntentID: "pi_test_seed", Reason: "changed_mind"}, "invalid reason"},
+		{service.RefundRequest{PaymentIntentID: "pi_test_seed", Amount: 5000}, "exceeds payment total"},
+		{url.Values{"paymentIntentId": {"pi_test_seed"}, "amount": {"ten"}}, "error parsing amount"},
+	} {
+		checkErrorMessage(t, e.admin("POST", "/refunds", tt.body), http.StatusBadRequest, tt.want)
+	}
+	if len(e.stripe.RefundParams) != 0 {
+		t.Errorf("invalid refunds reached Stripe")
+	}
+	checkErrorMessage(t, e.admin("POST", "/refunds", service.RefundRequest{PaymentIntentID: "pi_unknown"}), http.StatusNotFound, "No such payment_intent")
+}
@@ -0,0 +1,24 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"stripe_go/internal/service"
+)
+
+// handleAdminReload serves POST /admin/reload, which does what SIGHUP does
+// and reports the result.
+func (srv *Server) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	resp, err := srv.svc.ReloadSettings(r.Context())
+	if err != nil {
+		logFor(r).Error("reloading settings", "error", err)
+		writeError(w, r, &service.Error{Status: http.StatusUnprocessableEntity, Message: err.Error()})
+		return
+	}
+	logFor(r).Info("settings reloaded", "price", resp.Price, "previous_price", resp.PreviousPrice)
+	writeJSON(w, resp)
+}
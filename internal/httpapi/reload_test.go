@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+)
+
+// setReloadEnv makes the environment a valid configuration for a reload,
+// run from an empty directory so the repo's .env isn't loaded.
+func setReloadEnv(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("STRIPE_PUBLISHABLE_KEY", "pk_test_123")
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("PRICE", "price_basic")
+	t.Setenv("ADMIN_TOKEN", servicetest.AdminToken)
+	t.Setenv("API_KEYS", "")
+	t.Setenv("JWT_SECRETS", "")
+}
+
+func TestAdminReload(t *testing.T) {
+	e := newTestEnv(t)
+	setReloadEnv(t)
+	var before service.ConfigResponse
+	decodeBody(t, e.do("GET", "/config", nil), &before)
+
+	// A price change in the dashboard and a new PRICE both show up without
+	// waiting for the cache.
+	e.stripe.Price("price_monthly").UnitAmount = 1200
+	t.Setenv("PRICE", "price_monthly")
+	w := e.admin("POST", "/admin/reload", nil)
+	checkStatus(t, w, http.StatusOK)
+	var resp service.ReloadResponse
+	decodeBody(t, w, &resp)
+	if resp.Price != "price_monthly" || resp.PreviousPrice != "price_basic" || resp.Products == 0 || resp.Prices == 0 {
+		t.Errorf("reload = %+v", resp)
+	}
+	var after service.ConfigResponse
+	decodeBody(t, e.do("GET", "/config", nil), &after)
+	if before.Price != "price_basic" || after.Price != "price_monthly" || after.UnitAmount != 1200 {
+		t.Errorf("config before %+v, after %+v; want the new price", before, after)
+	}
+	if p, ok := e.svc.Catalog.Price("price_monthly"); !ok || p.UnitAmount != 1200 {
+		t.Errorf("catalog price = %+v, want the new amount", p)
+	}
+
+	// A PRICE that isn't for sale keeps the current one.
+	t.Setenv("PRICE", "price_archived")
+	checkErrorMessage(t, e.admin("POST", "/admin/reload", nil), http.StatusUnprocessableEntity, "not an active price")
+	if got := e.svc.ConfiguredPrice(); got != "price_monthly" {
+		t.Errorf("PRICE = %s after a failed reload, want price_monthly", got)
+	}
+
+	checkStatus(t, e.admin("GET", "/admin/reload", nil), http.StatusMethodNotAllowed)
+	checkStatus(t, e.do("POST", "/admin/reload", nil), http.StatusUnauthorized)
+}
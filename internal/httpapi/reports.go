@@ -0,0 +1,55 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"stripe_go/internal/service"
+)
+
+// handleAdminReport serves GET /admin/reports/{date}, the report of a UTC
+// day as JSON, or with format=csv or format=html as the CSV and page that
+// are emailed.
+func (srv *Server) handleAdminReport(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/admin/reports/")
+	if len(parts) != 1 {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	day, err := time.Parse("2006-01-02", parts[0])
+	if err != nil {
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid date %q: want YYYY-MM-DD", parts[0])})
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" && format != "html" {
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid format %q: want json, csv or html", format)})
+		return
+	}
+	report, err := srv.svc.BuildDailyReport(r.Context(), day)
+	if err != nil {
+		writeError(w, r, service.InternalError("building report", err))
+		return
+	}
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "report-"+report.Date+".csv"))
+		w.Write(report.CSV())
+	case "html":
+		html, err := report.HTML()
+		if err != nil {
+			writeError(w, r, service.InternalError("rendering report", err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(html)
+	default:
+		writeJSON(w, report)
+	}
+}
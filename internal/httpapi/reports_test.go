@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"stripe_go/internal/service"
+)
+
+// seedReportDay stores two paid orders and an unpaid one on 2024-03-01, a
+// refund that day and one the next day.
+func (e *testEnv) seedReportDay() {
+	e.t.Helper()
+	ctx := context.Background()
+	e.seedPayments(
+		&service.Payment{SessionID: "cs_test_1", PaymentIntentID: "pi_1", Amount: 3000, Currency: "usd", Status: "partially_refunded"},
+	)
+	for _, p := range []*service.Payment{
+		{SessionID: "cs_test_2", PaymentIntentID: "pi_2", Amount: 1500, Currency: "usd", Status: "paid"},
+		{SessionID: "cs_test_3", PaymentIntentID: "pi_3", Amount: 1500, Currency: "usd", Status: "unpaid"},
+	} {
+		p.CreatedAt = time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+		if err := e.svc.Payments.SavePayment(ctx, p); err != nil {
+			e.t.Fatal(err)
+		}
+	}
+	for _, o := range []*service.Order{
+		{ID: "ord_1", Status: service.OrderPaid, SessionID: "cs_test_1", Items: []service.OrderItem{{Price: "price_basic", Quantity: 2}}},
+		{ID: "ord_2", Status: service.OrderPaid, SessionID: "cs_test_2", Items: []service.OrderItem{{Price: "price_basic", Quantity: 1}}},
+	} {
+		if err := e.svc.Payments.SaveOrder(ctx, o); err != nil {
+			e.t.Fatal(err)
+		}
+	}
+	for _, r := range []*service.Refund{
+		{ID: "re_1", PaymentIntentID: "pi_1", Amount: 500, Currency: "usd", Status: "succeeded", CreatedAt: time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)},
+		{ID: "re_2", PaymentIntentID: "pi_1", Amount: 700, Currency: "usd", Status: "succeeded", CreatedAt: time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)},
+	} {
+		if err := e.svc.Payments.SaveRefund(ctx, r); err != nil {
+			e.t.Fatal(err)
+		}
+	}
+}
+
+func TestAdminReport(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedReportDay()
+
+	w := e.admin("GET", "/admin/reports/2024-03-01", nil)
+	checkStatus(t, w, http.StatusOK)
+	var report service.DailyReport
+	decodeBody(t, w, &report)
+	if len(report.Currencies) != 1 {
+		t.Fatalf("currencies = %+v, want usd only", report.Currencies)
+	}
+	want := service.ReportCurrency{Currency: "usd", Gross: 4500, Refunded: 500, Net: 4000, Payments: 2, Refunds: 1}
+	if got := *report.Currencies[0]; got != want {
+		t.Errorf("usd = %+v, want %+v", got, want)
+	}
+	if len(report.Products) != 1 {
+		t.Fatalf("products = %+v, want prod_basic only", report.Products)
+	}
+	wantProduct := service.ReportProduct{Product: "prod_basic", Name: "Basic", Currency: "usd", Orders: 2, Quantity: 3}
+	if got := *report.Products[0]; got != wantProduct {
+		t.Errorf("product = %+v, want %+v", got, wantProduct)
+	}
+
+	w = e.admin("GET", "/admin/reports/2024-03-02?format=csv", nil)
+	checkStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "2024-03-02,currency,usd,,,0,700,-700,0,1,,") {
+		t.Errorf("csv = %s, want the next day's refund", w.Body)
+	}
+
+	checkStatus(t, e.admin("GET", "/admin/reports/2024-03-01?format=html", nil), http.StatusOK)
+	checkStatus(t, e.admin("GET", "/admin/reports/yesterday", nil), http.StatusBadRequest)
+	checkStatus(t, e.admin("GET", "/admin/reports/2024-03-01?format=pdf", nil), http.StatusBadRequest)
+	checkStatus(t, e.admin("POST", "/admin/reports/2024-03-01", nil), http.StatusMethodNotAllowed)
+}
+
+func TestDailyReportEmail(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedReportDay()
+	e.svc.Config.ReportRecipients = []string{"finance@example.com", "ceo@example.com"}
+
+	if err := e.svc.QueueDailyReport(context.Background(), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	e.runJobs()
+	if len(e.emails.Sent) != 2 {
+		t.Fatalf("sent %d emails, want one per recipient", len(e.emails.Sent))
+	}
+	msg := e.emails.Sent[0]
+	if msg.Subject != "Revenue report for 2024-03-01" || !strings.Contains(msg.HTML, "45.00 USD") {
+		t.Errorf("email = %q %s, want the day's gross", msg.Subject, msg.HTML)
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Filename != "report-2024-03-01.csv" {
+		t.Errorf("attachments = %+v, want the CSV", msg.Attachments)
+	}
+}
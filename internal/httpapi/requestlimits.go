@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"stripe_go/internal/service"
+)
+
+// bodyLimitedRoute is a route that takes bodies of up to maxBody bytes
+// instead of MAX_REQUEST_BODY.
+type bodyLimitedRoute struct {
+	maxBody int64
+	next    http.HandlerFunc
+}
+
+func (b *bodyLimitedRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.next(w, r)
+}
+
+// withBodyLimit registers next with its own body limit, which
+// withRequestLimits applies in place of MAX_REQUEST_BODY.
+func withBodyLimit(maxBody int64, next http.HandlerFunc) http.Handler {
+	return &bodyLimitedRoute{maxBody: maxBody, next: next}
+}
+
+// withRequestLimits caps the request bodies of mux's routes at
+// MAX_REQUEST_BODY, or the limit the route was registered with by
+// withBodyLimit. Requests whose Content-Length is already over it get a 413
+// without being read; longer bodies sent without one fail when the handler
+// reads past the limit.
+func (srv *Server) withRequestLimits(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := srv.svc.Config.MaxRequestBody
+		if h, _ := mux.Handler(r); h != nil {
+			if route, ok := h.(*bodyLimitedRoute); ok {
+				limit = route.maxBody
+			}
+		}
+		if r.ContentLength > limit {
+			// The rest of the body isn't read, so the connection can't be
+			// reused.
+			w.Header().Set("Connection", "close")
+			writeError(w, r, &service.Error{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("request body is larger than %d bytes", limit)})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// withDeadlines gives next read and write of the connection until read and
+// write from now, in place of the server's READ_TIMEOUT and WRITE_TIMEOUT,
+// for routes that take longer. Zero lifts the deadline. Connections that
+// can't change their deadlines, such as test recorders, keep them.
+func withDeadlines(read, write time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	deadline := func(d time.Duration) time.Time {
+		if d <= 0 {
+			return time.Time{}
+		}
+		return time.Now().Add(d)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(deadline(read))
+		rc.SetWriteDeadline(deadline(write))
+		next(w, r)
+	}
+}
@@ -0,0 +1,84 @@
+package httpapi
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestBodyLimits(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.MaxRequestBody = 1024
+	mux := http.NewServeMux()
+	e.srv.registerRoutes(mux)
+	e.handler = e.srv.withTenantRouting(e.srv.withRequestLimits(mux))
+
+	big := []byte(`{"amount": 500, "padding": "` + strings.Repeat("x", 2048) + `"}`)
+	w := e.do("POST", "/create-donation-session", big)
+	checkErrorMessage(t, w, http.StatusRequestEntityTooLarge, "larger than 1024 bytes")
+	if w.Header().Get("Connection") != "close" {
+		t.Error("kept the connection of a rejected body open")
+	}
+
+	// Without a Content-Length the body fails once it is read past the cap.
+	req := httptest.NewRequest("POST", "/create-donation-session", io.MultiReader(bytes.NewReader(big)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e.handler.ServeHTTP(rec, req)
+	checkErrorMessage(t, rec, http.StatusBadRequest, "request body too large")
+
+	// Dispute evidence and webhooks have their own limits.
+	e.openDispute()
+	resp := e.uploadEvidence("dp_test_seed", "receipt", "receipt.pdf", bytes.Repeat([]byte("%"), 4096))
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("evidence upload over MAX_REQUEST_BODY: %d", resp.StatusCode)
+	}
+	w = e.do("POST", "/webhook", bytes.Repeat([]byte(" "), int(maxWebhookBytes)+1))
+	checkStatus(t, w, http.StatusRequestEntityTooLarge)
+}
+
+func TestWithDeadlines(t *testing.T) {
+	newTestEnv(t)
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", slow)
+	mux.HandleFunc("/extended", withDeadlines(0, time.Second, slow))
+	srv := httptest.NewUnstartedServer(withRequestLogging(mux))
+	srv.Config.WriteTimeout = 20 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	if resp, err := http.Get(srv.URL + "/slow"); err == nil {
+		resp.Body.Close()
+		t.Error("got a response after WRITE_TIMEOUT")
+	}
+	resp, err := http.Get(srv.URL + "/extended")
+	if err != nil {
+		t.Fatalf("extended deadline: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "done" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestAdminDeadlines(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.ReadTimeout, e.svc.Config.WriteTimeout = time.Minute, 40*time.Second
+	e.svc.Config.RequestTimeout, e.svc.Config.AdminRequestTimeout = 30*time.Second, 5*time.Minute
+	if read, write := e.svc.AdminDeadlines(); read != 5*time.Minute || write != 5*time.Minute+10*time.Second {
+		t.Errorf("deadlines = %v, %v", read, write)
+	}
+	e.svc.Config.AdminRequestTimeout = 0
+	if read, write := e.svc.AdminDeadlines(); read != 0 || write != 0 {
+		t.Errorf("unbounded admin requests got deadlines %v, %v", read, write)
+	}
+}
@@ -0,0 +1,23 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// confirmRequest is Service.ConfirmLive for an HTTP request, with the token from its
+// Confirmation-Token header.
+func (srv *Server) confirmRequest(r *http.Request, action, object string, amount int64) error {
+	return srv.svc.ConfirmLive(service.WithConfirmation(r.Context(), r.Header.Get(config.ConfirmationHeader)), action, object, amount)
+}
+
+// writeConfirmationRequired answers a guarded request that needs
+// confirming with 428, the error and the token to repeat it with.
+func writeConfirmationRequired(w http.ResponseWriter, e *service.ConfirmationRequired) {
+	writeJSONError(w, struct {
+		Error        *service.ErrorResponseMessage `json:"error"`
+		Confirmation *service.Confirmation         `json:"confirmation"`
+	}{&service.ErrorResponseMessage{Message: e.Message}, e.Confirmation}, http.StatusPreconditionRequired)
+}
@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+)
+
+const testSecondAdminKey = "second-admin-key-0123456789"
+
+// liveMode switches the test server to live mode, where guarded operations
+// need confirming.
+func (e *testEnv) liveMode() {
+	e.svc.Config.Mode, e.svc.Config.PublishableKey, e.svc.Config.SecretKey = "live", "pk_live_123", "sk_live_123"
+	e.svc.Config.SafetyRefundThreshold = 2000
+	e.svc.Config.SafetySigningKey = "safety-signing-key-0123456789abcdef"
+}
+
+// confirmation checks that w asks for confirmation and returns its token.
+func confirmation(t *testing.T, w *httptest.ResponseRecorder) *service.Confirmation {
+	t.Helper()
+	checkStatus(t, w, http.StatusPreconditionRequired)
+	var body struct {
+		Confirmation *service.Confirmation `json:"confirmation"`
+	}
+	decodeBody(t, w, &body)
+	if body.Confirmation == nil || body.Confirmation.Token == "" {
+		t.Fatalf("no confirmation in %s", w.Body)
+	}
+	return body.Confirmation
+}
+
+func (e *testEnv) confirmed(method, target string, body interface{}, key, token string) *httptest.ResponseRecorder {
+	e.t.Helper()
+	return e.do(method, target, body, "Authorization", "Bearer "+key, config.ConfirmationHeader, token)
+}
+
+func TestLiveRefundConfirmation(t *testing.T) {
+	e := newTestEnv(t)
+	e.liveMode()
+	e.stripe.PaymentIntents["pi_test_seed"] = &stripe.PaymentIntent{ID: "pi_test_seed", Amount: 3000, Currency: "usd"}
+	e.seedPayment()
+
+	// Refunds below the threshold go straight through.
+	checkStatus(t, e.admin("POST", "/refunds", service.RefundRequest{PaymentIntentID: "pi_test_seed", Amount: 500}), http.StatusOK)
+
+	// A full refund is over it.
+	full := service.RefundRequest{PaymentIntentID: "pi_test_seed"}
+	c := confirmation(t, e.admin("POST", "/refunds", full))
+	if c.Action != "refund.create" || c.Object != "pi_test_seed" || c.Amount != 3000 || c.RequestedBy != "admin_token" || c.ApprovalRequired {
+		t.Errorf("confirmation = %+v", c)
+	}
+	if len(e.stripe.RefundParams) != 1 {
+		t.Fatalf("made %d refunds before confirming, want 1", len(e.stripe.RefundParams))
+	}
+
+	// The token only confirms the operation it was issued for.
+	w := e.confirmed("POST", "/refunds", service.RefundRequest{PaymentIntentID: "pi_test_seed", Amount: 2500}, servicetest.AdminToken, c.Token)
+	if again := confirmation(t, w); again.Amount != 2500 {
+		t.Errorf("reissued confirmation = %+v", again)
+	}
+	checkStatus(t, e.confirmed("POST", "/refunds", full, servicetest.AdminToken, c.Token), http.StatusOK)
+	if len(e.stripe.RefundParams) != 2 {
+		t.Errorf("made %d refunds, want 2", len(e.stripe.RefundParams))
+	}
+	w = e.confirmed("POST", "/refunds", full, servicetest.AdminToken, c.Token)
+	checkStatus(t, w, http.StatusPreconditionRequired)
+	if !strings.Contains(w.Body.String(), "already used") {
+		t.Errorf("reused token: %s", w.Body)
+	}
+
+	entries := e.audit("?action=safety.override")
+	if len(entries) != 1 || entries[0].Actor != "admin_token" || entries[0].Object != "pi_test_seed" {
+		t.Fatalf("overrides = %+v", entries)
+	}
+	var after map[string]interface{}
+	json.Unmarshal(entries[0].After, &after)
+	if after["action"] != "refund.create" || after["amount"] != float64(3000) || after["approvedBy"] != "admin_token" {
+		t.Errorf("override = %v", after)
+	}
+	if len(e.audit("?action=refund.created")) != 2 {
+		t.Error("confirmed refund wasn't audited")
+	}
+}
+
+func TestLiveSecondApproval(t *testing.T) {
+	e := newTestEnv(t)
+	e.liveMode()
+	e.svc.Config.SafetyConfirmation = config.ConfirmationApproval
+	e.svc.Config.APIKeys = []config.APIKey{{Name: "ops", Role: config.RoleAdmin, Key: testSecondAdminKey}}
+	e.stripe.Customers["cus_live"] = &stripe.Customer{ID: "cus_live"}
+
+	c := confirmation(t, e.admin("DELETE", "/customers/cus_live", nil))
+	if !c.ApprovalRequired || c.Action != "customer.delete" {
+		t.Errorf("confirmation = %+v", c)
+	}
+	checkErrorMessage(t, e.confirmed("DELETE", "/customers/cus_live", nil, servicetest.AdminToken, c.Token), http.StatusForbidden, "someone other than admin_token")
+	checkStatus(t, e.confirmed("DELETE", "/customers/cus_live", nil, testSecondAdminKey, c.Token), http.StatusOK)
+
+	entries := e.audit("?action=safety.override")
+	if len(entries) != 1 || entries[0].Actor != "ops" {
+		t.Fatalf("overrides = %+v", entries)
+	}
+	var after map[string]interface{}
+	json.Unmarshal(entries[0].After, &after)
+	if after["requestedBy"] != "admin_token" || after["approvedBy"] != "ops" {
+		t.Errorf("override = %v", after)
+	}
+	// The deletion itself is still audited as an admin request.
+	requests := e.audit("?action=admin.request&object=/customers/cus_live")
+	if len(requests) == 0 || requests[0].Actor != "ops" || !strings.Contains(string(requests[0].After), `"status":200`) {
+		t.Errorf("deletion wasn't audited: %+v", requests)
+	}
+}
+
+func TestLiveReplayConfirmation(t *testing.T) {
+	e := newTestEnv(t)
+	e.liveMode()
+	c := confirmation(t, e.admin("POST", "/admin/reconcile", nil))
+	if c.Action != "reconcile.run" {
+		t.Errorf("confirmation = %+v", c)
+	}
+	checkStatus(t, e.confirmed("POST", "/admin/reconcile", nil, servicetest.AdminToken, c.Token), http.StatusOK)
+
+	// Tokens don't outlive SAFETY_CONFIRMATION_TTL, or survive tampering.
+	e.svc.Config.SafetyConfirmationTTL = -time.Minute
+	c = confirmation(t, e.admin("POST", "/admin/reconcile", nil))
+	e.svc.Config.SafetyConfirmationTTL = time.Minute
+	checkStatus(t, e.confirmed("POST", "/admin/reconcile", nil, servicetest.AdminToken, c.Token), http.StatusPreconditionRequired)
+	c = confirmation(t, e.admin("POST", "/admin/reconcile", nil))
+	checkStatus(t, e.confirmed("POST", "/admin/reconcile", nil, servicetest.AdminToken, c.Token+"x"), http.StatusPreconditionRequired)
+}
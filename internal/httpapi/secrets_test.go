@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// staticSecrets is a SecretProvider holding its secrets in memory.
+type staticSecrets map[string]string
+
+func (s staticSecrets) GetSecret(ctx context.Context, name string) (string, error) {
+	v, ok := s[name]
+	if !ok {
+		return "", service.ErrNotFound
+	}
+	return v, nil
+}
+
+// useStaticSecrets makes SECRET_PROVIDER=static load secrets.
+func useStaticSecrets(t *testing.T, secrets staticSecrets) {
+	t.Helper()
+	config.SecretProviders["static"] = func(config.Source) (config.SecretProvider, error) { return secrets, nil }
+	t.Cleanup(func() { delete(config.SecretProviders, "static") })
+	t.Setenv("SECRET_PROVIDER", "static")
+}
+
+func TestLoadConfigSecretProvider(t *testing.T) {
+	setReloadEnv(t)
+	secrets := staticSecrets{
+		"stripe":  `{"secret_key": "sk_test_from_manager"}`,
+		"webhook": "whsec_from_manager\n",
+	}
+	useStaticSecrets(t, secrets)
+	t.Setenv("STRIPE_SECRET_KEY", "secret:stripe#secret_key")
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "secret:webhook, whsec_plain")
+	c, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SecretKey != "sk_test_from_manager" || strings.Join(c.WebhookSecrets, ",") != "whsec_from_manager,whsec_plain" {
+		t.Errorf("secrets = %s, %v", c.SecretKey, c.WebhookSecrets)
+	}
+
+	for _, tt := range []struct {
+		value, want string
+	}{
+		{"secret:missing", "STRIPE_SECRET_KEY: fetching secret missing"},
+		{"secret:stripe#publishable_key", `secret stripe has no string field "publishable_key"`},
+		{"secret:webhook#key", "secret webhook is not a JSON object"},
+	} {
+		t.Setenv("STRIPE_SECRET_KEY", tt.value)
+		if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("STRIPE_SECRET_KEY=%s: %v, want %q", tt.value, err, tt.want)
+		}
+	}
+
+	t.Setenv("SECRET_PROVIDER", "")
+	t.Setenv("STRIPE_SECRET_KEY", "secret:stripe#secret_key")
+	if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), "SECRET_PROVIDER is not set") {
+		t.Errorf("reference without a provider: %v", err)
+	}
+	t.Setenv("SECRET_PROVIDER", "keepass")
+	if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), "SECRET_PROVIDER must be aws, gcp or vault") {
+		t.Errorf("unknown provider: %v", err)
+	}
+}
+
+func TestRefreshSecrets(t *testing.T) {
+	e := newTestEnv(t)
+	setReloadEnv(t)
+	secrets := staticSecrets{"stripe": "sk_test_first", "webhook": "whsec_first"}
+	useStaticSecrets(t, secrets)
+	t.Setenv("STRIPE_SECRET_KEY", "secret:stripe")
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "secret:webhook")
+	if err := e.svc.RefreshSecrets(); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets["stripe"], secrets["webhook"] = "sk_test_rotated", "whsec_rotated"
+	if err := e.svc.RefreshSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if key := e.svc.StripeKey(nil, "sk_test_startup"); key != "sk_test_rotated" {
+		t.Errorf("stripe key = %s, want the rotated one", key)
+	}
+	if got := e.svc.WebhookSecrets(context.Background()); len(got) != 1 || got[0] != "whsec_rotated" {
+		t.Errorf("webhook secrets = %v", got)
+	}
+
+	// A rotation to a key that doesn't validate keeps the current one.
+	secrets["stripe"] = "sk_live_wrong_mode"
+	if err := e.svc.RefreshSecrets(); err == nil {
+		t.Error("refreshed to a live mode key in test mode")
+	}
+	if key := e.svc.StripeKey(nil, "sk_test_startup"); key != "sk_test_rotated" {
+		t.Errorf("stripe key = %s after a failed refresh", key)
+	}
+}
@@ -0,0 +1,208 @@
+// Package httpapi serves a Service over HTTP: the checkout, payment and
+// admin endpoints, the webhook endpoint and the static pages, behind the
+// middleware every request passes through.
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// Server is the HTTP API of a Service.
+type Server struct {
+	svc *service.Service
+}
+
+// New returns the HTTP API of svc: every route behind the middleware.
+func New(svc *service.Service) http.Handler {
+	srv := &Server{svc: svc}
+	return srv.handler()
+}
+
+// handler returns the HTTP API: every route behind the middleware.
+func (srv *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	srv.registerRoutes(mux)
+	return withTracing(mux, withRequestLogging(srv.withCORS(srv.withTenantRouting(srv.withRateLimit(srv.withStripeAccountHeader(srv.withRequestLimits(mux)))))))
+}
+
+// registerRoutes adds every endpoint to mux. Admin endpoints are wrapped in
+// requireAuth. Every endpoint gets REQUEST_TIMEOUT, or ADMIN_REQUEST_TIMEOUT
+// behind requireAuth, to finish; authenticated callers get connection
+// deadlines to match. Routes registered withBodyLimit take larger or
+// smaller bodies than MAX_REQUEST_BODY.
+func (srv *Server) registerRoutes(mux *http.ServeMux) {
+	timeout := func(h http.HandlerFunc) http.HandlerFunc {
+		return withTimeout(srv.svc.Config.RequestTimeout, h)
+	}
+	adminRead, adminWrite := srv.svc.AdminDeadlines()
+	admin := func(h http.HandlerFunc) http.HandlerFunc {
+		return srv.requireAuth(withDeadlines(adminRead, adminWrite, withTimeout(srv.svc.Config.AdminRequestTimeout, h)))
+	}
+	mux.Handle("/", http.FileServer(http.FS(srv.svc.StaticFS())))
+	mux.HandleFunc("/config", timeout(srv.handleConfig))
+	mux.HandleFunc("/healthz", timeout(srv.handleHealthz))
+	mux.HandleFunc("/readyz", timeout(srv.handleReadyz))
+	mux.HandleFunc("/openapi.json", timeout(srv.handleOpenAPI))
+	mux.HandleFunc("/docs", timeout(srv.handleAPIDocs))
+	mux.HandleFunc("/products", timeout(srv.handleProducts))
+	mux.HandleFunc("/checkout-session/summary", timeout(srv.handleCheckoutSessionSummary))
+	if srv.svc.Config.Mode == "mock" {
+		mux.HandleFunc(config.MockCheckoutPath, timeout(srv.handleMockCheckout))
+	}
+	mux.HandleFunc("/create-checkout-session", timeout(srv.withIdempotency(srv.handleCreateCheckoutSession)))
+	mux.HandleFunc("/carts", timeout(srv.withIdempotency(srv.handleCarts)))
+	mux.HandleFunc("/carts/", timeout(srv.handleCart))
+	mux.HandleFunc("/recover/", timeout(srv.handleRecover))
+	mux.HandleFunc("/create-subscription-session", timeout(srv.withIdempotency(srv.handleCreateSubscriptionSession)))
+	mux.HandleFunc("/create-setup-session", timeout(srv.withIdempotency(srv.handleCreateSetupSession)))
+	mux.HandleFunc("/subscriptions/", admin(srv.withIdempotency(srv.handleCustomerSubscriptions)))
+	mux.HandleFunc("/create-donation-session", timeout(srv.withIdempotency(srv.handleCreateDonationSession)))
+	mux.HandleFunc("/create-payment-intent", timeout(srv.withIdempotency(srv.handleCreatePaymentIntent)))
+	mux.HandleFunc("/create-portal-session", timeout(srv.withIdempotency(srv.handleCreatePortalSession)))
+	mux.HandleFunc("/promotions", timeout(srv.handlePromotions))
+	mux.HandleFunc("/receipts/", timeout(srv.handleReceipt))
+	mux.HandleFunc("/orders/", timeout(srv.handleOrderStatus))
+	mux.HandleFunc("/downloads/", timeout(srv.handleDownload))
+	mux.HandleFunc("/licenses/", timeout(srv.handleLicenseVerify))
+	mux.HandleFunc("/refunds", admin(srv.withIdempotency(srv.handleRefunds)))
+	mux.HandleFunc("/charges/off-session", admin(srv.withIdempotency(srv.handleOffSessionCharge)))
+	mux.HandleFunc("/payment-links", admin(srv.withIdempotency(srv.handlePaymentLinks)))
+	mux.HandleFunc("/usage-records", admin(srv.withIdempotency(srv.handleUsageRecords)))
+	mux.HandleFunc("/usage-records/reconcile", admin(srv.handleUsageReconcile))
+	mux.HandleFunc("/payments/", admin(srv.handlePaymentAction))
+	mux.HandleFunc("/customers", admin(srv.handleCustomers))
+	mux.HandleFunc("/customers/", admin(srv.handleCustomer))
+	mux.HandleFunc("/connect/accounts", admin(srv.handleConnectAccounts))
+	mux.HandleFunc("/connect/accounts/", admin(srv.handleConnectAccount))
+	mux.HandleFunc("/admin/jobs", admin(srv.handleAdminJobs))
+	mux.HandleFunc("/admin/payments", admin(srv.handleAdminPayments))
+	mux.HandleFunc("/admin/payments/", admin(srv.handleAdminPayment))
+	mux.HandleFunc("/admin/orders", admin(srv.handleAdminOrders))
+	mux.HandleFunc("/admin/orders/", admin(srv.handleAdminOrder))
+	mux.HandleFunc("/admin/disputes", admin(srv.handleAdminDisputes))
+	mux.Handle("/admin/disputes/", withBodyLimit(maxEvidenceUpload+srv.svc.Config.MaxRequestBody, admin(srv.handleAdminDispute)))
+	mux.HandleFunc("/admin/invoices", admin(srv.withIdempotency(srv.handleAdminInvoices)))
+	mux.HandleFunc("/admin/invoices/", admin(srv.handleAdminInvoice))
+	mux.HandleFunc("/admin/store-credits", admin(srv.withIdempotency(srv.handleAdminStoreCredits)))
+	mux.HandleFunc("/admin/store-credits/", admin(srv.handleAdminStoreCredit))
+	mux.HandleFunc("/admin/reviews", admin(srv.handleAdminReviews))
+	mux.HandleFunc("/admin/reviews/", admin(srv.handleAdminReview))
+	mux.HandleFunc("/admin/revenue", admin(srv.handleAdminRevenue))
+	mux.HandleFunc("/admin/recovery", admin(srv.handleAdminRecovery))
+	mux.HandleFunc("/admin/reports/", admin(srv.handleAdminReport))
+	mux.HandleFunc("/admin/inventory", admin(srv.handleAdminInventory))
+	mux.HandleFunc("/admin/inventory/", admin(srv.handleAdminInventoryItem))
+	mux.HandleFunc("/admin/price-limits", admin(srv.handleAdminPriceLimits))
+	mux.HandleFunc("/admin/price-limits/", admin(srv.handleAdminPriceLimit))
+	mux.HandleFunc("/admin/reconcile", admin(srv.handleAdminReconcile))
+	mux.HandleFunc("/admin/reload", admin(srv.handleAdminReload))
+	mux.HandleFunc("/admin/audit", admin(srv.handleAdminAudit))
+	mux.HandleFunc("/admin/projections/", admin(srv.handleAdminProjection))
+	mux.HandleFunc("/admin/events/", admin(srv.handleAdminEvent))
+	mux.HandleFunc("/admin/webhook-deliveries", admin(srv.handleAdminWebhookDeliveries))
+	mux.HandleFunc("/admin/webhook-deliveries/", admin(srv.handleAdminWebhookDelivery))
+	mux.Handle("/webhook", withBodyLimit(maxWebhookBytes, timeout(srv.verifyWebhookSignature(srv.handleWebhook))))
+	mux.Handle("/dev/replay-event", withBodyLimit(maxWebhookBytes, timeout(srv.handleReplayEvent)))
+	mux.HandleFunc("/dev/email-preview", timeout(srv.handleEmailPreview))
+	// Checkout returns customers to /html/success.html by default.
+	mux.HandleFunc("/html/success.html", timeout(srv.handleSuccessPage))
+	mux.HandleFunc("/success.html", timeout(srv.handleSuccessPage))
+}
+
+func (srv *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	currency, err := service.NormalizeCurrency(r.URL.Query().Get("currency"))
+	if err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	locale, err := service.NormalizeLocale(r.URL.Query().Get("locale"))
+	if err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	p, err := srv.svc.CachedPrice(r.Context(), srv.svc.DefaultPrice(r.Context()))
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "fetching price", Err: err})
+		return
+	}
+	price := service.CatalogPriceFromStripe(p)
+	amount, chosen := price.AmountIn(service.ChooseCurrency([]*service.CatalogPrice{price}, service.PreferredCurrencies(r.Header.Get("Accept-Language"), currency)))
+	currencies := []string{price.Currency}
+	for c := range price.CurrencyOptions {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies[1:])
+	publishableKey, branding := srv.svc.Config.PublishableKey, srv.svc.Config.Branding
+	if t := service.TenantFrom(r.Context()); t != nil {
+		publishableKey, branding = t.PublishableKey, t.Branding
+	}
+	writeJSON(w, &service.ConfigResponse{
+		Mode:           srv.svc.Config.Mode,
+		PublishableKey: publishableKey,
+		Price:          p.ID,
+		UnitAmount:     amount,
+		Currency:       chosen,
+		Currencies:     currencies,
+		Locale:         service.CheckoutLocale(r.Header.Get("Accept-Language"), locale),
+		Locales:        service.CheckoutLocales,
+		Nickname:       p.Nickname,
+		Branding:       branding,
+	})
+}
+
+// pathParams returns the slash separated segments of path after prefix, so
+// pathParams("/customers/cus_1/sessions", "/customers/") is
+// ["cus_1", "sessions"].
+func pathParams(path, prefix string) []string {
+	rest := strings.Trim(strings.TrimPrefix(path, prefix), "/")
+	if rest == "" {
+		return nil
+	}
+	return strings.Split(rest, "/")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		slog.Error("encoding JSON response", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, `{"error":{"message":"Internal Server Error"}}`+"\n")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := io.Copy(w, &buf); err != nil {
+		slog.Error("writing JSON response", "error", err)
+		return
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, v interface{}, code int) {
+	// Headers can't change once WriteHeader has been called.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	writeJSON(w, v)
+	return
+}
+
+func writeJSONErrorMessage(w http.ResponseWriter, message string, code int) {
+	resp := &service.ErrorResponse{
+		Error: &service.ErrorResponseMessage{
+			Message: message,
+		},
+	}
+	writeJSONError(w, resp, code)
+}
@@ -0,0 +1,232 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+	"stripe_go/internal/webhookhandlers"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testEnv is a server wired to a fake Stripe, a temporary SQLite store and an
+// in-memory job queue.
+type testEnv struct {
+	t       *testing.T
+	svc     *service.Service
+	srv     *Server
+	stripe  *servicetest.Stripe
+	emails  *servicetest.EmailSender
+	handler http.Handler
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	svc, fake, emails := servicetest.New(t)
+	webhookhandlers.Register(svc)
+	srv := &Server{svc: svc}
+	mux := http.NewServeMux()
+	srv.registerRoutes(mux)
+	return &testEnv{t: t, svc: svc, srv: srv, stripe: fake, emails: emails, handler: withRequestLogging(srv.withTenantRouting(srv.withStripeAccountHeader(srv.withRequestLimits(mux))))}
+}
+
+// do sends a request to the server. Bodies other than url.Values and nil are
+// sent as JSON.
+func (e *testEnv) do(method, target string, body interface{}, header ...string) *httptest.ResponseRecorder {
+	e.t.Helper()
+	var r io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case url.Values:
+		r = strings.NewReader(b.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	case []byte:
+		r = bytes.NewReader(b)
+		contentType = "application/json"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	req := httptest.NewRequest(method, target, r)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	e.handler.ServeHTTP(w, req)
+	return w
+}
+
+// admin is do with the admin bearer token.
+func (e *testEnv) admin(method, target string, body interface{}) *httptest.ResponseRecorder {
+	e.t.Helper()
+	return e.do(method, target, body, "Authorization", "Bearer "+servicetest.AdminToken)
+}
+
+// runJobs runs every due job until the queue is empty.
+func (e *testEnv) runJobs() {
+	e.svc.Jobs.RunDue(context.Background(), 0)
+}
+
+func (e *testEnv) payment(sessionID string) *service.Payment {
+	e.t.Helper()
+	p, err := e.svc.Payments.GetPayment(context.Background(), sessionID)
+	if err != nil {
+		e.t.Fatalf("GetPayment(%s): %v", sessionID, err)
+	}
+	return p
+}
+
+func checkStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, want, w.Body)
+	}
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+}
+
+func checkErrorMessage(t *testing.T, w *httptest.ResponseRecorder, want int, substr string) {
+	t.Helper()
+	checkStatus(t, w, want)
+	var resp service.ErrorResponse
+	decodeBody(t, w, &resp)
+	if resp.Error == nil || !strings.Contains(resp.Error.Message, substr) {
+		t.Fatalf("error = %s, want it to contain %q", w.Body, substr)
+	}
+}
+
+func TestHandleConfig(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("GET", "/config", nil)
+	checkStatus(t, w, http.StatusOK)
+	var got map[string]interface{}
+	decodeBody(t, w, &got)
+	want := map[string]interface{}{
+		"mode":           "test",
+		"publishableKey": "pk_test_123",
+		"price":          "price_basic",
+		"unitAmount":     1500.0,
+		"currency":       "usd",
+		"nickname":       "Basic",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+
+	checkStatus(t, e.do("POST", "/config", nil), http.StatusMethodNotAllowed)
+}
+
+func TestHandleConfigCurrency(t *testing.T) {
+	e := newTestEnv(t)
+	for _, tt := range []struct {
+		target, acceptLanguage string
+		amount                 float64
+		currency               string
+	}{
+		{"/config?currency=eur", "", 1400, "eur"},
+		{"/config?currency=GBP", "de-DE", 1200, "gbp"},
+		{"/config", "de-DE,de;q=0.9", 1400, "eur"},
+		{"/config", "ja-JP", 1500, "usd"},
+		{"/config?currency=jpy", "", 1500, "usd"},
+	} {
+		w := e.do("GET", tt.target, nil, "Accept-Language", tt.acceptLanguage)
+		checkStatus(t, w, http.StatusOK)
+		var got map[string]interface{}
+		decodeBody(t, w, &got)
+		if got["unitAmount"] != tt.amount || got["currency"] != tt.currency {
+			t.Errorf("%s (%s): %v %v, want %v %v", tt.target, tt.acceptLanguage, got["unitAmount"], got["currency"], tt.amount, tt.currency)
+		}
+		if fmt.Sprint(got["currencies"]) != "[usd eur gbp]" {
+			t.Errorf("currencies = %v", got["currencies"])
+		}
+	}
+	checkErrorMessage(t, e.do("GET", "/config?currency=euro", nil), http.StatusBadRequest, "invalid currency")
+}
+
+func TestHandleConfigStripeDown(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Err = errors.New("connection refused")
+	w := e.do("GET", "/config", nil)
+	checkErrorMessage(t, w, http.StatusBadGateway, "error while fetching price")
+	if strings.Contains(w.Body.String(), "connection refused") {
+		t.Errorf("response leaks the cause: %s", w.Body)
+	}
+
+	e.stripe.Err = &stripe.Error{HTTPStatusCode: http.StatusTooManyRequests, Msg: "slow down"}
+	w = e.do("GET", "/config", nil)
+	checkErrorMessage(t, w, http.StatusServiceUnavailable, "error while fetching price")
+	if strings.Contains(w.Body.String(), "slow down") {
+		t.Errorf("response leaks Stripe's message: %s", w.Body)
+	}
+}
+
+func TestAdminEndpointsRequireToken(t *testing.T) {
+	e := newTestEnv(t)
+	for _, path := range []string{"/refunds", "/customers", "/customers/cus_1", "/connect/accounts", "/admin/jobs", "/admin/payments", "/admin/orders", "/admin/disputes", "/admin/revenue", "/admin/reconcile", "/admin/audit", "/admin/webhook-deliveries", "/subscriptions/cus_1"} {
+		checkStatus(t, e.do("GET", path, nil), http.StatusUnauthorized)
+		checkStatus(t, e.do("GET", path, nil, "Authorization", "Bearer wrong"), http.StatusUnauthorized)
+	}
+
+	e.svc.Config.AdminToken = ""
+	checkStatus(t, e.do("GET", "/admin/payments", nil, "Authorization", "Bearer "), http.StatusNotFound)
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("GET", "/config", nil, "X-Request-ID", "abc123")
+	if got := w.Header().Get("X-Request-ID"); got != "abc123" {
+		t.Errorf("X-Request-ID = %q, want the client's abc123", got)
+	}
+	if got := e.do("GET", "/config", nil).Header().Get("X-Request-ID"); got == "" {
+		t.Error("no X-Request-ID generated")
+	}
+}
+
+func TestPathParams(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		want []string
+	}{
+		{"/customers/", nil},
+		{"/customers/cus_1", []string{"cus_1"}},
+		{"/customers/cus_1/sessions/", []string{"cus_1", "sessions"}},
+	} {
+		got := pathParams(tt.path, "/customers/")
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("pathParams(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// maskEmail keeps the first character of the local part and the domain.
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return ""
+	}
+	return local[:1] + "***@" + domain
+}
+
+// sessionSummary describes a session fetched with its line items expanded.
+func sessionSummary(s *stripe.CheckoutSession) *service.CheckoutSessionSummary {
+	summary := &service.CheckoutSessionSummary{
+		ID:             s.ID,
+		Mode:           string(s.Mode),
+		Status:         string(s.Status),
+		PaymentStatus:  string(s.PaymentStatus),
+		AmountSubtotal: s.AmountSubtotal,
+		AmountTotal:    s.AmountTotal,
+		Currency:       string(s.Currency),
+		Items:          []service.SessionSummaryItem{},
+		OrderID:        s.Metadata[service.OrderMetadataKey],
+	}
+	if s.LineItems != nil {
+		for _, li := range s.LineItems.Data {
+			summary.Items = append(summary.Items, service.SessionSummaryItem{
+				Description: li.Description,
+				Quantity:    li.Quantity,
+				AmountTotal: li.AmountTotal,
+			})
+		}
+	}
+	email := s.CustomerEmail
+	if s.CustomerDetails != nil && s.CustomerDetails.Email != "" {
+		email = s.CustomerDetails.Email
+	}
+	summary.Email = maskEmail(email)
+	return summary
+}
+
+// handleCheckoutSessionSummary serves GET
+// /checkout-session/summary?sessionId=...&token=..., the success page's view
+// of the session it returned from. The token from the success URL proves the
+// caller came back from that session.
+func (srv *Server) handleCheckoutSessionSummary(w http.ResponseWriter, r *http.Request) {
+	if srv.svc.Config.SessionSummarySigningKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	sessionID := r.URL.Query().Get("sessionId")
+	if err := validateSessionID(sessionID); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	s, err := srv.svc.CachedSessionWithLineItems(r.Context(), sessionID)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "fetching session", Err: err})
+		return
+	}
+	if err := srv.svc.VerifySessionSummaryToken(s, r.URL.Query().Get("token")); err != nil {
+		writeError(w, r, &service.Error{Status: http.StatusForbidden, Message: err.Error()})
+		return
+	}
+	writeJSON(w, sessionSummary(s))
+}
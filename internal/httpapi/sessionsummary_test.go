@@ -0,0 +1,111 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+const testSessionSummaryKey = "session-summary-key-0123456789abcdef"
+
+// summaryToken returns the summary_token put on the success URL of the
+// n-th session created.
+func (e *testEnv) summaryToken(n int) string {
+	e.t.Helper()
+	success, err := url.Parse(stripe.StringValue(e.stripe.SessionParams[n].SuccessURL))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return success.Query().Get("summary_token")
+}
+
+func TestCheckoutSessionSummary(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.SessionSummarySigningKey = testSessionSummaryKey
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	token := e.summaryToken(0)
+	if token == "" {
+		t.Fatalf("success URL %s has no summary_token", stripe.StringValue(e.stripe.SessionParams[0].SuccessURL))
+	}
+	if !strings.Contains(stripe.StringValue(e.stripe.SessionParams[0].SuccessURL), "session_id={CHECKOUT_SESSION_ID}") {
+		t.Errorf("success URL lost the session ID placeholder")
+	}
+	s := e.stripe.Sessions[resp.ID]
+	s.CustomerDetails = &stripe.CheckoutSessionCustomerDetails{Email: "jenny@example.com"}
+	s.PaymentIntent = &stripe.PaymentIntent{ID: "pi_test_secret", ClientSecret: "pi_test_secret_abc"}
+
+	w = e.do("GET", "/checkout-session/summary?sessionId="+resp.ID+"&token="+token, nil)
+	checkStatus(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), "pi_test_secret") || strings.Contains(w.Body.String(), "jenny@") {
+		t.Errorf("summary leaks session details: %s", w.Body)
+	}
+	var summary service.CheckoutSessionSummary
+	decodeBody(t, w, &summary)
+	if summary.ID != resp.ID || summary.AmountTotal != 3000 || summary.Currency != "usd" || summary.OrderID != resp.OrderID {
+		t.Errorf("summary = %+v", summary)
+	}
+	if summary.Email != "j***@example.com" {
+		t.Errorf("email = %q, want it masked", summary.Email)
+	}
+	if len(summary.Items) != 1 || summary.Items[0].Description != "Basic" || summary.Items[0].Quantity != 2 || summary.Items[0].AmountTotal != 3000 {
+		t.Errorf("items = %+v", summary.Items)
+	}
+
+	// The token only opens the session it was made for.
+	checkStatus(t, e.do("POST", "/create-donation-session", map[string]interface{}{"amount": 500}), http.StatusOK)
+	other := e.stripe.SessionParams[1]
+	var otherID string
+	for id, s := range e.stripe.Sessions {
+		if s.Metadata[service.SummaryMetadataKey] == other.Metadata[service.SummaryMetadataKey] {
+			otherID = id
+		}
+	}
+	checkErrorMessage(t, e.do("GET", "/checkout-session/summary?sessionId="+otherID+"&token="+token, nil), http.StatusForbidden, "invalid session summary link")
+	checkStatus(t, e.do("GET", "/checkout-session/summary?sessionId="+otherID+"&token="+e.summaryToken(1), nil), http.StatusOK)
+	checkErrorMessage(t, e.do("GET", "/checkout-session/summary?sessionId="+resp.ID, nil), http.StatusForbidden, "invalid session summary link")
+	checkStatus(t, e.do("GET", "/checkout-session/summary?sessionId=nope&token="+token, nil), http.StatusBadRequest)
+	checkStatus(t, e.do("POST", "/checkout-session/summary?sessionId="+resp.ID, nil), http.StatusMethodNotAllowed)
+
+	// Clients can't plant a reference of their own.
+	checkErrorMessage(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":    []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+		"metadata": map[string]string{service.SummaryMetadataKey: "mine"},
+	}), http.StatusBadRequest, "reserved")
+}
+
+func TestCheckoutSessionSummaryDisabled(t *testing.T) {
+	e := newTestEnv(t)
+	checkStatus(t, e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+	}), http.StatusOK)
+	if token := e.summaryToken(0); token != "" {
+		t.Errorf("summary_token %q added without a signing key", token)
+	}
+	if _, ok := e.stripe.SessionParams[0].Metadata[service.SummaryMetadataKey]; ok {
+		t.Error("summary reference added without a signing key")
+	}
+	checkStatus(t, e.do("GET", "/checkout-session/summary?sessionId=cs_test_1&token=x", nil), http.StatusNotFound)
+}
+
+func TestMaskEmail(t *testing.T) {
+	for email, want := range map[string]string{
+		"jenny@example.com": "j***@example.com",
+		"j@example.com":     "j***@example.com",
+		"":                  "",
+		"not-an-email":      "",
+	} {
+		if got := maskEmail(email); got != want {
+			t.Errorf("maskEmail(%q) = %q, want %q", email, got, want)
+		}
+	}
+}
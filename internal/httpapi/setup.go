@@ -0,0 +1,89 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleCreateSetupSession creates a Checkout session in setup mode, which
+// saves a payment method to the customer without charging it so it can be
+// charged off-session later.
+func (srv *Server) handleCreateSetupSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req service.SetupSessionRequest
+	if isJSONRequest(r) {
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	} else {
+		r.ParseForm()
+		req.Customer = r.PostFormValue("customer")
+		req.CustomerToken = r.PostFormValue("customerToken")
+		req.Email = r.PostFormValue("email")
+		req.SuccessURL = r.PostFormValue("successUrl")
+		req.CancelURL = r.PostFormValue("cancelUrl")
+		if err := req.Validate(r.Context(), srv.svc); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	}
+	successURL, cancelURL, err := srv.svc.CheckoutReturnURLs(req.SuccessURL, req.CancelURL)
+	if err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	if req.Customer != "" {
+		if err := srv.authorizeCustomer(r, req.Customer, req.CustomerToken, "create sessions for a customer"); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	customerID := req.Customer
+	if customerID == "" {
+		cp := &stripe.CustomerParams{Email: stripe.String(req.Email)}
+		cp.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "customer"))
+		c, err := srv.svc.Stripe.NewCustomer(r.Context(), cp)
+		if err != nil {
+			writeError(w, r, &service.StripeFailure{Action: "creating customer", Err: err})
+			return
+		}
+		customerID = c.ID
+	}
+	methods := srv.svc.Config.PaymentMethodTypes
+	if len(methods) == 0 {
+		methods = []string{"card"}
+	}
+	params := &stripe.CheckoutSessionParams{
+		SuccessURL:         stripe.String(successURL),
+		CancelURL:          stripe.String(cancelURL),
+		Mode:               stripe.String(string(stripe.CheckoutSessionModeSetup)),
+		Customer:           stripe.String(customerID),
+		PaymentMethodTypes: stripe.StringSlice(methods),
+	}
+	srv.svc.WithSessionSummary(params)
+	service.WithTraceContext(r.Context(), params)
+	params.SetIdempotencyKey(service.StripeIdempotencyKey(r.Context(), "setup_session"))
+	s, err := srv.svc.Stripe.NewCheckoutSession(r.Context(), params)
+	if err != nil {
+		writeError(w, r, &service.StripeFailure{Action: "creating session", Err: err})
+		return
+	}
+	srv.svc.RecordAudit(r.Context(), service.AuditActor(r.Context()), "checkout.created", s.ID, nil, map[string]interface{}{
+		"mode":     string(stripe.CheckoutSessionModeSetup),
+		"customer": customerID,
+	})
+
+	if isJSONRequest(r) {
+		writeJSON(w, &service.SetupSessionResponse{ID: s.ID, URL: s.URL, Customer: customerID})
+		return
+	}
+	http.Redirect(w, r, s.URL, http.StatusSeeOther)
+}
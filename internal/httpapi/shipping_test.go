@@ -0,0 +1,69 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+func TestCheckoutCollectsShipping(t *testing.T) {
+	e := newTestEnv(t)
+	cart := map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}}
+	checkStatus(t, e.do("POST", "/create-checkout-session", cart), http.StatusOK)
+	if p := e.stripe.SessionParams[0]; p.ShippingAddressCollection != nil || p.ShippingOptions != nil {
+		t.Errorf("shipping set without SHIPPING_COUNTRIES: %+v", p.ShippingAddressCollection)
+	}
+
+	e.svc.Config.ShippingCountries = []string{"US", "CA"}
+	e.svc.Config.ShippingRates = []config.ShippingRate{{ID: "shr_123"}, {Name: "Express", Amount: 1500, MinDays: 1, MaxDays: 2}}
+	checkStatus(t, e.do("POST", "/create-checkout-session", cart), http.StatusOK)
+	p := e.stripe.SessionParams[1]
+	if got := p.ShippingAddressCollection.AllowedCountries; len(got) != 2 || *got[0] != "US" || *got[1] != "CA" {
+		t.Errorf("allowed countries = %v", got)
+	}
+	if len(p.ShippingOptions) != 2 || stripe.StringValue(p.ShippingOptions[0].ShippingRate) != "shr_123" {
+		t.Fatalf("shipping options = %+v", p.ShippingOptions)
+	}
+	data := p.ShippingOptions[1].ShippingRateData
+	if stripe.StringValue(data.DisplayName) != "Express" || stripe.Int64Value(data.FixedAmount.Amount) != 1500 ||
+		stripe.StringValue(data.FixedAmount.Currency) != "usd" || stripe.Int64Value(data.DeliveryEstimate.Maximum.Value) != 2 {
+		t.Errorf("flat rate = %+v %+v", data, data.FixedAmount)
+	}
+}
+
+func TestCompletedSessionStoresShipping(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_shipping", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": "pi_test_ship", "payment_status": "paid",
+		"amount_total": 4500, "currency": "usd", "metadata": {"order": %q},
+		"shipping": {"name": "Jenny Rosen", "address": {"line1": "1 Main St", "city": "Toronto", "state": "ON", "postal_code": "M5V 2T6", "country": "CA"}},
+		"shipping_rate": "shr_123", "total_details": {"amount_shipping": 1500}}}}`, resp.ID, resp.OrderID)))
+
+	want := &service.OrderShipping{
+		Name: "Jenny Rosen", Line1: "1 Main St", City: "Toronto", State: "ON", PostalCode: "M5V 2T6", Country: "CA",
+		ShippingRate: "shr_123", Amount: 1500,
+	}
+	if o := e.order(resp.OrderID); !reflect.DeepEqual(o.Shipping, want) {
+		t.Errorf("shipping = %+v, want %+v", o.Shipping, want)
+	}
+
+	w = e.admin("GET", "/admin/orders/"+resp.OrderID, nil)
+	checkStatus(t, w, http.StatusOK)
+	var o service.Order
+	decodeBody(t, w, &o)
+	if o.Shipping == nil || o.Shipping.Country != "CA" {
+		t.Errorf("admin order shipping = %+v", o.Shipping)
+	}
+}
@@ -0,0 +1,100 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"stripe_go/internal/service"
+)
+
+// handleAdminStoreCredits serves GET /admin/store-credits, newest first,
+// with limit and offset, and POST /admin/store-credits to issue a credit.
+func (srv *Server) handleAdminStoreCredits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		srv.listStoreCredits(w, r)
+	case "POST":
+		var req service.StoreCreditRequest
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+		issued, err := srv.svc.IssueStoreCredit(r.Context(), &req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, issued)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (srv *Server) listStoreCredits(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := service.StoreCreditFilter{Limit: service.DefaultPageSize}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > service.MaxPageSize {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", service.MaxPageSize)})
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid offset %q", v)})
+			return
+		}
+	}
+	// Fetch one extra row to know whether there is another page.
+	limit := f.Limit
+	f.Limit++
+	list, err := srv.svc.Payments.ListStoreCredits(r.Context(), f)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing store credits", err))
+		return
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []*service.StoreCredit{}
+	}
+	writeJSON(w, struct {
+		StoreCredits []*service.StoreCredit `json:"storeCredits"`
+		Limit        int                    `json:"limit"`
+		Offset       int                    `json:"offset"`
+		HasMore      bool                   `json:"hasMore"`
+	}{list, limit, f.Offset, hasMore})
+}
+
+// handleAdminStoreCredit serves GET /admin/store-credits/{id}, the credit
+// with its redemptions.
+func (srv *Server) handleAdminStoreCredit(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/admin/store-credits/")
+	if len(parts) != 1 {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	c, err := srv.svc.Payments.GetStoreCredit(r.Context(), parts[0])
+	if err == service.ErrStoreCreditNotFound {
+		writeError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, r, service.InternalError("fetching store credit", err))
+		return
+	}
+	d, err := srv.svc.StoreCreditDetail(r.Context(), c)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing redemptions", err))
+		return
+	}
+	writeJSON(w, d)
+}
@@ -0,0 +1,172 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func (e *testEnv) issueStoreCredit(amount int64, currency string) *service.IssuedStoreCredit {
+	e.t.Helper()
+	w := e.admin("POST", "/admin/store-credits", service.StoreCreditRequest{Amount: amount, Currency: currency, Note: "gift card"})
+	checkStatus(e.t, w, http.StatusOK)
+	var issued service.IssuedStoreCredit
+	decodeBody(e.t, w, &issued)
+	return &issued
+}
+
+func (e *testEnv) storeCredit(id string) *service.StoreCreditDetail {
+	e.t.Helper()
+	w := e.admin("GET", "/admin/store-credits/"+id, nil)
+	checkStatus(e.t, w, http.StatusOK)
+	var d service.StoreCreditDetail
+	decodeBody(e.t, w, &d)
+	return &d
+}
+
+// checkoutWithCredit starts a checkout of quantity units of price_basic
+// paid partly with the store credit code.
+func (e *testEnv) checkoutWithCredit(code string, quantity int64) *service.CreateCheckoutResponse {
+	e.t.Helper()
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items":       []service.CheckoutItem{{Price: "price_basic", Quantity: quantity}},
+		"storeCredit": code,
+	})
+	checkStatus(e.t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(e.t, w, &resp)
+	return &resp
+}
+
+// creditSessionEvent is a checkout.session event for a session paid with credit.
+func creditSessionEvent(id, eventType string, resp *service.CreateCheckoutResponse, credit, paymentStatus string) []byte {
+	return []byte(fmt.Sprintf(`{"id": %q, "object": "event", "type": %q, "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_status": %q, "amount_total": 2000, "currency": "usd",
+		"metadata": {"order": %q, "store_credit": %q}}}}`, id, eventType, resp.ID, paymentStatus, resp.OrderID, credit))
+}
+
+func TestStoreCredit(t *testing.T) {
+	e := newTestEnv(t)
+	issued := e.issueStoreCredit(1000, "USD")
+	if issued.Currency != "usd" || issued.Balance != 1000 || len(issued.Code) != 19 || !strings.HasSuffix(issued.Code, issued.Last4) {
+		t.Fatalf("issued credit = %+v", issued)
+	}
+
+	// Codes are typed in any case, with or without dashes.
+	code := strings.ToLower(strings.ReplaceAll(issued.Code, "-", ""))
+	resp := e.checkoutWithCredit(code, 2)
+	if resp.StoreCreditApplied != 1000 {
+		t.Errorf("applied %d", resp.StoreCreditApplied)
+	}
+	params := e.stripe.SessionParams[0]
+	li := params.LineItems[0]
+	if li.Price != nil || li.PriceData == nil || stripe.Int64Value(li.PriceData.UnitAmount) != 1000 || stripe.Int64Value(li.Quantity) != 2 ||
+		stripe.StringValue(li.PriceData.Product) != "prod_basic" || stripe.StringValue(li.PriceData.Currency) != "usd" {
+		t.Errorf("line item = %+v, price data %+v", li, li.PriceData)
+	}
+	if params.Metadata[service.StoreCreditMetadataKey] != issued.ID || params.PaymentIntentData.Metadata[service.StoreCreditMetadataKey] != issued.ID {
+		t.Errorf("metadata = %v", params.Metadata)
+	}
+	if o := e.order(resp.OrderID); o.Amount != 2000 || len(o.Items) != 1 || o.Items[0].Price != "price_basic" {
+		t.Errorf("order = %+v", o)
+	}
+	if d := e.storeCredit(issued.ID); d.Balance != 0 || d.Held != 1000 || !d.Reconciled {
+		t.Errorf("credit while held = %+v", d)
+	}
+	// The held amount can't be spent twice.
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}, "storeCredit": issued.Code,
+	})
+	checkErrorMessage(t, w, http.StatusBadRequest, "no balance left")
+
+	e.deliverOK(creditSessionEvent("evt_paid", "checkout.session.completed", resp, issued.ID, "paid"))
+	d := e.storeCredit(issued.ID)
+	if d.Balance != 0 || d.Held != 0 || d.Redeemed != 1000 || !d.Reconciled || len(d.Redemptions) != 1 || d.Redemptions[0].Status != service.CreditRedeemed {
+		t.Errorf("credit after payment = %+v", d)
+	}
+
+	w = e.admin("GET", "/admin/store-credits", nil)
+	checkStatus(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), issued.Code) {
+		t.Error("the list shows the code")
+	}
+}
+
+func TestStoreCreditReleased(t *testing.T) {
+	e := newTestEnv(t)
+	issued := e.issueStoreCredit(5000, "usd")
+
+	// The credit leaves the smallest charge to pay, and spreads over the
+	// line without changing its quantity unless it must.
+	resp := e.checkoutWithCredit(issued.Code, 3)
+	if resp.StoreCreditApplied != 4500-service.StoreCreditMinCharge {
+		t.Errorf("applied %d", resp.StoreCreditApplied)
+	}
+	li := e.stripe.SessionParams[0].LineItems[0]
+	if stripe.Int64Value(li.Quantity) != 1 || stripe.Int64Value(li.PriceData.UnitAmount) != service.StoreCreditMinCharge {
+		t.Errorf("line item quantity %d at %d", stripe.Int64Value(li.Quantity), stripe.Int64Value(li.PriceData.UnitAmount))
+	}
+	if d := e.storeCredit(issued.ID); d.Balance != 5000-resp.StoreCreditApplied {
+		t.Errorf("balance while held = %d", d.Balance)
+	}
+	e.deliverOK(creditSessionEvent("evt_expired", "checkout.session.expired", resp, issued.ID, "unpaid"))
+	if d := e.storeCredit(issued.ID); d.Balance != 5000 || d.Redemptions[0].Status != service.CreditReleased || !d.Reconciled {
+		t.Errorf("credit after expiry = %+v", d)
+	}
+	// Settling again changes nothing.
+	e.deliverOK(creditSessionEvent("evt_paid_late", "checkout.session.async_payment_succeeded", resp, issued.ID, "paid"))
+	if d := e.storeCredit(issued.ID); d.Balance != 5000 || d.Redeemed != 0 {
+		t.Errorf("credit after a late payment = %+v", d)
+	}
+
+	// A session Stripe doesn't create releases the credit with its order.
+	e.stripe.Err = errors.New("stripe down")
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}, "storeCredit": issued.Code,
+	})
+	e.stripe.Err = nil
+	if w.Code == http.StatusOK {
+		t.Fatal("checkout succeeded while Stripe was down")
+	}
+	if d := e.storeCredit(issued.ID); d.Balance != 5000 || len(d.Redemptions) != 2 || d.Redemptions[1].Status != service.CreditReleased {
+		t.Errorf("credit after a failed checkout = %+v", d)
+	}
+}
+
+func TestStoreCreditValidation(t *testing.T) {
+	e := newTestEnv(t)
+	eur := e.issueStoreCredit(1000, "eur")
+	for _, tt := range []struct {
+		name   string
+		body   map[string]interface{}
+		status int
+		want   string
+	}{
+		{"bad code", map[string]interface{}{"storeCredit": "GIFT-1234"}, http.StatusBadRequest, "invalid store credit code"},
+		{"unknown code", map[string]interface{}{"storeCredit": "AAAA-AAAA-AAAA-AAAA"}, http.StatusNotFound, "unknown store credit code"},
+		{"with coupon", map[string]interface{}{"storeCredit": eur.Code, "coupon": "SUMMER"}, http.StatusBadRequest, "can't be combined with a coupon"},
+		{"manual capture", map[string]interface{}{"storeCredit": eur.Code, "captureMethod": "manual"}, http.StatusBadRequest, "manual capture"},
+		{"other currency", map[string]interface{}{"storeCredit": eur.Code, "currency": "usd"}, http.StatusBadRequest, "store credit is in EUR but the cart is charged in USD"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.body["items"] = []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}
+			checkErrorMessage(t, e.do("POST", "/create-checkout-session", tt.body), tt.status, tt.want)
+		})
+	}
+	if d := e.storeCredit(eur.ID); d.Balance != 1000 || len(d.Redemptions) != 0 {
+		t.Errorf("credit after rejected checkouts = %+v", d)
+	}
+
+	for _, req := range []service.StoreCreditRequest{{Amount: 0, Currency: "usd"}, {Amount: 100, Currency: "dollars"}, {Amount: 100, Currency: "usd", Email: "nobody"}} {
+		if w := e.admin("POST", "/admin/store-credits", req); w.Code != http.StatusBadRequest {
+			t.Errorf("issuing %+v: status %d", req, w.Code)
+		}
+	}
+	checkErrorMessage(t, e.admin("GET", "/admin/store-credits/scr_missing", nil), http.StatusNotFound, "store credit not found")
+}
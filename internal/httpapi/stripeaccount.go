@@ -0,0 +1,58 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+	"stripe_go/internal/stripeclient"
+)
+
+// withStripeAccountHeader lets platform tooling act on a connected account
+// by sending its ID in a Stripe-Account header: the checkout sessions,
+// refunds and other Stripe objects the request creates or reads are then
+// the connected account's. Only admin callers may send it, on any endpoint,
+// and only for accounts connected to the platform. The header can't be
+// combined with a tenant, which has its own account.
+func (srv *Server) withStripeAccountHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(service.StripeAccountHeader)
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if service.TenantFrom(r.Context()) != nil {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: service.StripeAccountHeader + " can't be used with a tenant"})
+			return
+		}
+		p, err := srv.svc.Authenticate(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), time.Now())
+		if err != nil {
+			logFor(r).Info("rejected credentials", "path", r.URL.Path, "stripe_account", id, "error", err)
+			writeError(w, r, service.StatusError(http.StatusUnauthorized))
+			return
+		}
+		if p.Role != config.RoleAdmin {
+			logFor(r).Info("forbidden", "path", r.URL.Path, "principal", p.Name, "stripe_account", id)
+			writeError(w, r, &service.Error{Status: http.StatusForbidden, Message: fmt.Sprintf("%s role can't use %s", p.Role, service.StripeAccountHeader)})
+			return
+		}
+		if err := service.ValidateStripeID(id, "acct_", service.StripeAccountHeader); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+		if _, err := srv.svc.ConnectedAccount(r.Context(), id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		// Checkouts on the account sell from its own catalog.
+		if _, err := srv.svc.AccountCatalog(r.Context(), id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		logFor(r).Info("acting on connected account", "path", r.URL.Path, "principal", p.Name, "stripe_account", id)
+		next.ServeHTTP(w, r.WithContext(stripeclient.WithAccount(r.Context(), id)))
+	})
+}
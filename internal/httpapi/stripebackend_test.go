@@ -0,0 +1,30 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestWriteStripeErrorCodes(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeError(w, httptest.NewRequest("POST", "/create-payment-intent", nil), &service.StripeFailure{Action: "creating payment intent", Err: &stripe.Error{
+		HTTPStatusCode: http.StatusPaymentRequired,
+		Code:           stripe.ErrorCodeCardDeclined,
+		DeclineCode:    stripe.DeclineCodeInsufficientFunds,
+		Msg:            "Your card has insufficient funds.",
+	}})
+	var resp service.ErrorResponse
+	decodeBody(t, w, &resp)
+	if w.Code != http.StatusPaymentRequired || resp.Error.Code != "card_declined" || resp.Error.DeclineCode != "insufficient_funds" {
+		t.Errorf("%d %+v", w.Code, resp.Error)
+	}
+	if !strings.Contains(resp.Error.Message, "insufficient funds") {
+		t.Errorf("message = %q", resp.Error.Message)
+	}
+}
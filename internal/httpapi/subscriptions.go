@@ -0,0 +1,103 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"stripe_go/internal/service"
+)
+
+func (srv *Server) handleCreateSubscriptionSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req service.SubscriptionRequest
+	if isJSONRequest(r) {
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	} else {
+		r.ParseForm()
+		req.Price = r.PostFormValue("price")
+		req.Customer = r.PostFormValue("customer")
+		req.CustomerToken = r.PostFormValue("customerToken")
+		req.SuccessURL = r.PostFormValue("successUrl")
+		req.CancelURL = r.PostFormValue("cancelUrl")
+		req.Locale = r.PostFormValue("locale")
+		if v := r.PostFormValue("quantity"); v != "" {
+			quantity, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("error parsing quantity %v", err.Error())})
+				return
+			}
+			req.Quantity = quantity
+		}
+		if err := req.Validate(r.Context(), srv.svc); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+	}
+	if req.Customer != "" {
+		if err := srv.authorizeCustomer(r, req.Customer, req.CustomerToken, "create sessions for a customer"); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	resp, err := srv.svc.CreateSubscriptionCheckout(r.Context(), &req, r.Header.Get("Accept-Language"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if isJSONRequest(r) {
+		writeJSON(w, resp)
+		return
+	}
+	http.Redirect(w, r, resp.URL, http.StatusSeeOther)
+}
+
+// handleCustomerSubscriptions serves GET /subscriptions/{customerID} and
+// POST /subscriptions/{subscriptionID}/change.
+func (srv *Server) handleCustomerSubscriptions(w http.ResponseWriter, r *http.Request) {
+	parts := pathParams(r.URL.Path, "/subscriptions/")
+	if len(parts) == 2 && parts[1] == "change" && service.ValidateStripeID(parts[0], "sub_", "subscription") == nil {
+		srv.handleSubscriptionChange(w, r, parts[0])
+		return
+	}
+	if len(parts) != 1 || service.ValidateStripeID(parts[0], "cus_", "customer") != nil {
+		writeError(w, r, service.StatusError(http.StatusNotFound))
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	resp, err := srv.svc.ListCustomerSubscriptions(r.Context(), parts[0])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// handleSubscriptionChange serves POST /subscriptions/{id}/change, which
+// previews or, once confirmed, makes a change of price.
+func (srv *Server) handleSubscriptionChange(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	var req service.SubscriptionChangeRequest
+	if err := srv.decodeJSON(w, r, &req); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	change, err := srv.svc.ChangeSubscription(r.Context(), id, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, change)
+}
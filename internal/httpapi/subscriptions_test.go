@@ -0,0 +1,244 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestCreateSubscriptionSession(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.PortalSigningKey = testPortalSigningKey
+	w := e.do("POST", "/create-subscription-session", url.Values{
+		"price":         {"price_monthly"},
+		"customer":      {"cus_123"},
+		"customerToken": {e.svc.PortalToken("cus_123", time.Now().Add(time.Hour))},
+	})
+	checkStatus(t, w, http.StatusSeeOther)
+	params := e.stripe.SessionParams[0]
+	if got := stripe.StringValue(params.Mode); got != "subscription" {
+		t.Errorf("mode = %q, want subscription", got)
+	}
+	if got := stripe.StringValue(params.Customer); got != "cus_123" {
+		t.Errorf("customer = %q", got)
+	}
+}
+
+func TestCreateTieredSubscriptionSession(t *testing.T) {
+	e := newTestEnv(t)
+	w := e.do("POST", "/create-subscription-session", service.SubscriptionRequest{Price: "price_seats", Quantity: 7})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	if resp.OrderID == "" || resp.URL == "" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if li := e.stripe.SessionParams[0].LineItems[0]; stripe.Int64Value(li.Quantity) != 7 {
+		t.Errorf("quantity = %d, want 7", stripe.Int64Value(li.Quantity))
+	}
+	o, err := e.svc.Payments.GetOrder(context.Background(), resp.OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 5 seats at $10 plus a $5 flat fee, then 2 at $8.
+	if o.Amount != 7100 || o.Currency != "usd" || o.SessionID != resp.ID || len(o.Items) != 1 || o.Items[0].Quantity != 7 {
+		t.Errorf("order = %+v", o)
+	}
+	if s := e.stripe.Sessions[resp.ID]; s.Metadata[service.OrderMetadataKey] != o.ID || s.AmountTotal != o.Amount {
+		t.Errorf("session = %+v, want it linked to the order and charging %d", s, o.Amount)
+	}
+
+	// Form posts default to a single unit.
+	checkStatus(t, e.do("POST", "/create-subscription-session", url.Values{"price": {"price_seats"}}), http.StatusSeeOther)
+	if li := e.stripe.SessionParams[1].LineItems[0]; stripe.Int64Value(li.Quantity) != 1 {
+		t.Errorf("quantity = %d, want 1", stripe.Int64Value(li.Quantity))
+	}
+}
+
+func TestCreateSubscriptionSessionValidation(t *testing.T) {
+	e := newTestEnv(t)
+	checkErrorMessage(t, e.do("POST", "/create-subscription-session", service.SubscriptionRequest{Price: "price_basic"}), http.StatusBadRequest, "not recurring")
+	checkErrorMessage(t, e.do("POST", "/create-subscription-session", service.SubscriptionRequest{Price: "price_nope"}), http.StatusBadRequest, "unknown price")
+	checkErrorMessage(t, e.do("POST", "/create-subscription-session", service.SubscriptionRequest{Price: "price_monthly", CancelURL: "ftp://shop.example.com"}), http.StatusBadRequest, "must use https")
+	checkErrorMessage(t, e.do("POST", "/create-subscription-session", service.SubscriptionRequest{Price: "price_seats", Quantity: -1}), http.StatusBadRequest, "quantity must be between")
+	checkErrorMessage(t, e.do("POST", "/create-subscription-session", url.Values{"price": {"price_seats"}, "quantity": {"many"}}), http.StatusBadRequest, "error parsing quantity")
+	checkStatus(t, e.do("GET", "/create-subscription-session", nil), http.StatusMethodNotAllowed)
+}
+
+func subscriptionEvent(eventType, id, status string) []byte {
+	return []byte(fmt.Sprintf(`{"id": "evt_%s_%s", "object": "event", "type": %q, "data": {"object": {
+		"id": %q, "object": "subscription", "customer": "cus_test_subscriber", "status": %q,
+		"current_period_start": 1700000000, "current_period_end": 1702592000}}}`, id, status, eventType, id, status))
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	e := newTestEnv(t)
+	get := func() *service.CustomerSubscriptions {
+		t.Helper()
+		w := e.admin("GET", "/subscriptions/cus_test_subscriber", nil)
+		checkStatus(t, w, http.StatusOK)
+		var resp service.CustomerSubscriptions
+		decodeBody(t, w, &resp)
+		return &resp
+	}
+	if resp := get(); resp.Active || len(resp.Subscriptions) != 0 {
+		t.Errorf("before any event: %+v", resp)
+	}
+
+	e.deliverOK(subscriptionEvent("customer.subscription.created", "sub_1", "active"))
+	e.deliverOK([]byte(`{"id": "evt_in_1", "object": "event", "type": "invoice.paid", "data": {"object": {
+		"id": "in_1", "object": "invoice", "amount_paid": 900, "currency": "usd", "subscription": "sub_1"}}}`))
+	resp := get()
+	if !resp.Active || len(resp.Subscriptions) != 1 {
+		t.Fatalf("after creation: %+v", resp)
+	}
+	if sub := resp.Subscriptions[0]; sub.Status != "active" || sub.LatestInvoiceStatus != "paid" || !sub.CurrentPeriodEnd.Equal(time.Unix(1702592000, 0)) {
+		t.Errorf("subscription = %+v", sub)
+	}
+
+	// A failed renewal is recorded, and the subscription update that follows
+	// doesn't clear it.
+	e.deliverOK([]byte(`{"id": "evt_in_2", "object": "event", "type": "invoice.payment_failed", "data": {"object": {
+		"id": "in_2", "object": "invoice", "amount_due": 900, "currency": "usd", "customer": "cus_test_subscriber", "subscription": "sub_1"}}}`))
+	e.deliverOK(subscriptionEvent("customer.subscription.updated", "sub_1", "past_due"))
+	resp = get()
+	if resp.Active {
+		t.Error("past_due subscription reported active")
+	}
+	if sub := resp.Subscriptions[0]; sub.Status != "past_due" || sub.LatestInvoiceStatus != "payment_failed" {
+		t.Errorf("subscription = %+v", sub)
+	}
+
+	e.deliverOK(subscriptionEvent("customer.subscription.deleted", "sub_1", "canceled"))
+	if resp := get(); resp.Active || resp.Subscriptions[0].Status != "canceled" {
+		t.Errorf("after deletion: %+v", resp)
+	}
+}
+
+func TestCustomerSubscriptionsRequest(t *testing.T) {
+	e := newTestEnv(t)
+	checkStatus(t, e.admin("GET", "/subscriptions/acct_1", nil), http.StatusNotFound)
+	checkStatus(t, e.admin("GET", "/subscriptions/cus_1/extra", nil), http.StatusNotFound)
+	checkStatus(t, e.admin("POST", "/subscriptions/cus_1", nil), http.StatusMethodNotAllowed)
+}
+
+// seedSubscription gives the fake Stripe an active subscription to
+// price_monthly, for quantity units, halfway through its month.
+func (e *testEnv) seedSubscription(id string, quantity int64) {
+	now := time.Now().Unix()
+	e.stripe.Subscriptions[id] = &stripe.Subscription{
+		ID:                 id,
+		Customer:           &stripe.Customer{ID: "cus_test_subscriber"},
+		Status:             stripe.SubscriptionStatusActive,
+		CurrentPeriodStart: now - 15*24*3600,
+		CurrentPeriodEnd:   now + 15*24*3600,
+		Items: &stripe.SubscriptionItemList{Data: []*stripe.SubscriptionItem{
+			{ID: "si_" + id, Price: e.stripe.Price("price_monthly"), Quantity: quantity},
+		}},
+	}
+}
+
+func TestSubscriptionChange(t *testing.T) {
+	e := newTestEnv(t)
+	e.stripe.Prices = append(e.stripe.Prices, &stripe.Price{
+		ID: "price_pro", Product: e.stripe.Products[1], UnitAmount: 2900, Currency: stripe.CurrencyUSD, Active: true,
+		Recurring: &stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalMonth, IntervalCount: 1},
+	})
+	if err := e.svc.Catalog.Load(context.Background(), e.svc.Stripe); err != nil {
+		t.Fatal(err)
+	}
+	e.seedSubscription("sub_change", 2)
+	if err := e.svc.Payments.SaveSubscription(context.Background(), service.SubscriptionFromStripe(e.stripe.Subscriptions["sub_change"])); err != nil {
+		t.Fatal(err)
+	}
+	change := func(req service.SubscriptionChangeRequest) *service.SubscriptionChange {
+		t.Helper()
+		w := e.admin("POST", "/subscriptions/sub_change/change", req)
+		checkStatus(t, w, http.StatusOK)
+		var resp service.SubscriptionChange
+		decodeBody(t, w, &resp)
+		return &resp
+	}
+
+	preview := change(service.SubscriptionChangeRequest{Price: "price_pro"})
+	// Half a month of 2 seats: $9 credited, $29 charged.
+	if preview.Confirmed || preview.CurrentPrice != "price_monthly" || preview.ProrationBehavior != "create_prorations" ||
+		preview.ProratedAmount != 2000 || preview.AmountDue != 7800 || preview.Subscription != nil {
+		t.Errorf("preview = %+v", preview)
+	}
+	params := e.stripe.UpcomingParams[0]
+	if item := params.SubscriptionItems[0]; stripe.StringValue(item.ID) != "si_sub_change" || stripe.Int64Value(item.Quantity) != 2 {
+		t.Errorf("previewed item = %+v", item)
+	}
+	if len(e.stripe.SubscriptionParams) != 0 {
+		t.Fatal("a preview changed the subscription")
+	}
+
+	if none := change(service.SubscriptionChangeRequest{Price: "price_pro", ProrationBehavior: "none"}); none.ProratedAmount != 0 {
+		t.Errorf("prorated %d with proration off", none.ProratedAmount)
+	}
+
+	done := change(service.SubscriptionChangeRequest{Price: "price_pro", ProrationDate: preview.ProrationDate, Confirm: true})
+	if !done.Confirmed || done.ProratedAmount != preview.ProratedAmount || done.Subscription == nil {
+		t.Fatalf("change = %+v", done)
+	}
+	if sub := done.Subscription; sub.PriceID != "price_pro" || sub.PreviousPriceID != "price_monthly" || sub.PriceChangedAt == nil {
+		t.Errorf("stored subscription = %+v", sub)
+	}
+	update := e.stripe.SubscriptionParams[0]
+	if stripe.Int64Value(update.ProrationDate) != preview.ProrationDate || stripe.StringValue(update.ProrationBehavior) != "create_prorations" {
+		t.Errorf("update params = %+v", update)
+	}
+	if audit := e.audit("?action=subscription.price_changed"); len(audit) != 1 {
+		t.Errorf("audit = %+v", audit)
+	}
+
+	// The webhook that follows keeps the recorded change.
+	e.deliverOK([]byte(`{"id": "evt_sub_changed", "object": "event", "type": "customer.subscription.updated", "data": {"object": {
+		"id": "sub_change", "object": "subscription", "customer": "cus_test_subscriber", "status": "active",
+		"items": {"object": "list", "data": [{"id": "si_sub_change", "price": {"id": "price_pro"}, "quantity": 2}]}}}}`))
+	sub, err := e.svc.Payments.GetSubscription(context.Background(), "sub_change")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.PriceID != "price_pro" || sub.PreviousPriceID != "price_monthly" || !sub.PriceChangedAt.Equal(*done.Subscription.PriceChangedAt) {
+		t.Errorf("subscription after the webhook = %+v", sub)
+	}
+	checkErrorMessage(t, e.admin("POST", "/subscriptions/sub_change/change", service.SubscriptionChangeRequest{Price: "price_pro"}),
+		http.StatusConflict, "already on price")
+}
+
+func TestSubscriptionChangeValidation(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedSubscription("sub_valid", 1)
+	path := "/subscriptions/sub_valid/change"
+	for _, tt := range []struct {
+		name string
+		req  service.SubscriptionChangeRequest
+		code int
+		want string
+	}{
+		{"unknown price", service.SubscriptionChangeRequest{Price: "price_nope"}, http.StatusBadRequest, "unknown price"},
+		{"one-time price", service.SubscriptionChangeRequest{Price: "price_basic"}, http.StatusBadRequest, "not recurring"},
+		{"bad proration", service.SubscriptionChangeRequest{Price: "price_seats", ProrationBehavior: "sometimes"}, http.StatusBadRequest, "prorationBehavior must be"},
+		{"old proration date", service.SubscriptionChangeRequest{Price: "price_seats", ProrationDate: 1600000000}, http.StatusBadRequest, "within the current billing period"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErrorMessage(t, e.admin("POST", path, tt.req), tt.code, tt.want)
+		})
+	}
+	checkStatus(t, e.admin("POST", "/subscriptions/sub_missing/change", service.SubscriptionChangeRequest{Price: "price_seats"}), http.StatusNotFound)
+	checkStatus(t, e.admin("GET", path, nil), http.StatusMethodNotAllowed)
+	e.stripe.Subscriptions["sub_valid"].Status = stripe.SubscriptionStatusCanceled
+	checkErrorMessage(t, e.admin("POST", path, service.SubscriptionChangeRequest{Price: "price_seats"}), http.StatusConflict, "subscription is canceled")
+	if len(e.stripe.UpcomingParams) != 0 {
+		t.Errorf("previewed %d invalid changes", len(e.stripe.UpcomingParams))
+	}
+}
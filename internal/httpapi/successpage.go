@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// SuccessPage is what html/success.html is rendered with. Error is set
+// instead of Summary when the page can't show the session. Summary is nil
+// without SESSION_SUMMARY_SIGNING_KEY, as nothing then proves the visitor
+// came back from the session.
+type SuccessPage struct {
+	Summary *service.CheckoutSessionSummary
+	// Pending is set while an asynchronous payment method, such as a bank
+	// debit, hasn't settled yet.
+	Pending bool
+	// Portal lets a subscriber open the Billing Portal.
+	Portal *SuccessPortal
+	Error  string
+}
+
+// SuccessPortal is the form on the success page that opens the customer's
+// Billing Portal.
+type SuccessPortal struct {
+	Customer string
+	Token    string
+}
+
+// successPage looks up the session the customer returned from and the
+// status to answer with.
+func (srv *Server) successPage(r *http.Request) (*SuccessPage, int) {
+	q := r.URL.Query()
+	if err := validateSessionID(q.Get("session_id")); err != nil {
+		return &SuccessPage{Error: "This link doesn't point to a checkout session."}, http.StatusBadRequest
+	}
+	s, err := srv.svc.CachedSessionWithLineItems(r.Context(), q.Get("session_id"))
+	if err != nil {
+		if _, code := service.StripeErrorResponse(err, "fetching session"); code == http.StatusNotFound || code == http.StatusBadRequest {
+			return &SuccessPage{Error: "We couldn't find this checkout session."}, http.StatusNotFound
+		}
+		logFor(r).Error("fetching session for the success page", "session", q.Get("session_id"), "error", err)
+		return &SuccessPage{Error: "Your order can't be shown right now. Please reload the page in a moment."}, http.StatusBadGateway
+	}
+	verified := srv.svc.Config.SessionSummarySigningKey != ""
+	if verified {
+		if err := srv.svc.VerifySessionSummaryToken(s, q.Get("summary_token")); err != nil {
+			return &SuccessPage{Error: "This link doesn't belong to the checkout session."}, http.StatusForbidden
+		}
+	}
+	if s.Status != stripe.CheckoutSessionStatusComplete {
+		return &SuccessPage{Error: "This checkout session hasn't been completed."}, http.StatusConflict
+	}
+	page := &SuccessPage{Pending: s.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid}
+	// The order's details and portal tokens are only shown once the summary
+	// token proved the visitor came back from the session.
+	if !verified {
+		return page, http.StatusOK
+	}
+	page.Summary = sessionSummary(s)
+	if token := srv.svc.SubscriberPortalToken(s); token != "" {
+		page.Portal = &SuccessPortal{Customer: s.Customer.ID, Token: token}
+	}
+	return page, http.StatusOK
+}
+
+// handleSuccessPage serves the page Checkout returns customers to,
+// /html/success.html?session_id=..., rendered with the session's items,
+// totals and receipt address. The template is read from Service.StaticFS on every
+// request so STATIC_DIR edits show up without a restart.
+func (srv *Server) handleSuccessPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	tmpl, err := template.New("success.html").Funcs(template.FuncMap{"money": service.FormatAmount}).ParseFS(srv.svc.StaticFS(), "success.html")
+	if err != nil {
+		writeError(w, r, service.InternalError("loading success page", err))
+		return
+	}
+	page, status := srv.successPage(r)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, page); err != nil {
+		writeError(w, r, service.InternalError("rendering success page", err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
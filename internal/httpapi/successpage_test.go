@@ -0,0 +1,118 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func TestSuccessPage(t *testing.T) {
+	e := newTestEnv(t)
+	// Render the embedded template rather than the test's empty STATIC_DIR.
+	e.svc.Config.StaticDir = ""
+	e.svc.Config.SessionSummarySigningKey = testSessionSummaryKey
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 2}},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	s := e.stripe.Sessions[resp.ID]
+	s.Status = stripe.CheckoutSessionStatusComplete
+	s.PaymentStatus = stripe.CheckoutSessionPaymentStatusPaid
+	s.CustomerDetails = &stripe.CheckoutSessionCustomerDetails{Email: "jenny@example.com"}
+
+	// Without a signing key nothing proves the visitor paid, so the order
+	// isn't shown.
+	e.svc.Config.SessionSummarySigningKey = ""
+	w = e.do("GET", "/html/success.html?session_id="+resp.ID, nil)
+	checkStatus(t, w, http.StatusOK)
+	body := w.Body.String()
+	if !strings.Contains(body, `data-i18n="paymentSucceeded"`) || strings.Contains(body, "Basic") || strings.Contains(body, "@example.com") {
+		t.Errorf("success page without a signing key:\n%s", body)
+	}
+
+	e.svc.Config.SessionSummarySigningKey = testSessionSummaryKey
+	w = e.do("GET", "/html/success.html?session_id="+resp.ID+"&summary_token="+e.summaryToken(0), nil)
+	checkStatus(t, w, http.StatusOK)
+	body = w.Body.String()
+	for _, want := range []string{`data-i18n="paymentSucceeded"`, "2 × Basic", "30.00 USD", "j***@example.com"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("success page lacks %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "jenny@") {
+		t.Error("success page shows the full email address")
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	checkStatus(t, e.do("POST", "/html/success.html?session_id="+resp.ID, nil), http.StatusMethodNotAllowed)
+}
+
+func TestSuccessPagePendingPayment(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.StaticDir = ""
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	// A bank debit completes the session before the money arrives.
+	e.stripe.Sessions[resp.ID].Status = stripe.CheckoutSessionStatusComplete
+
+	w = e.do("GET", "/success.html?session_id="+resp.ID, nil)
+	checkStatus(t, w, http.StatusOK)
+	if body := w.Body.String(); !strings.Contains(body, `data-i18n="paymentPending"`) || strings.Contains(body, `data-i18n="paymentSucceeded"`) {
+		t.Errorf("success page for an unpaid session:\n%s", body)
+	}
+}
+
+func TestSuccessPageErrors(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.StaticDir = ""
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var open service.CreateCheckoutResponse
+	decodeBody(t, w, &open)
+
+	for _, tt := range []struct {
+		query   string
+		status  int
+		message string
+	}{
+		{"", http.StatusBadRequest, "point to a checkout session"},
+		{"?session_id=nope", http.StatusBadRequest, "point to a checkout session"},
+		{"?session_id=cs_test_missing", http.StatusNotFound, "find this checkout session"},
+		{"?session_id=" + open.ID, http.StatusConflict, "been completed"},
+	} {
+		w := e.do("GET", "/html/success.html"+tt.query, nil)
+		checkStatus(t, w, tt.status)
+		if body := w.Body.String(); !strings.Contains(body, `data-i18n="sessionNotFound"`) || !strings.Contains(body, tt.message) {
+			t.Errorf("success page%s lacks %q:\n%s", tt.query, tt.message, body)
+		}
+	}
+}
+
+func TestSuccessPageSummaryToken(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.StaticDir = ""
+	e.svc.Config.SessionSummarySigningKey = testSessionSummaryKey
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+	})
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	e.stripe.Sessions[resp.ID].Status = stripe.CheckoutSessionStatusComplete
+
+	checkStatus(t, e.do("GET", "/html/success.html?session_id="+resp.ID, nil), http.StatusForbidden)
+	checkStatus(t, e.do("GET", "/html/success.html?session_id="+resp.ID+"&summary_token="+e.summaryToken(0), nil), http.StatusOK)
+}
@@ -0,0 +1,129 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"stripe_go/internal/service"
+)
+
+// vies serves the VIES VAT number check, answering from known: numbers
+// without the country code mapped to the registered name. unavailable makes
+// it report the member state's service as down.
+func (e *testEnv) vies(known map[string]string) (checked *[]string, unavailable *bool) {
+	e.t.Helper()
+	checked, unavailable = new([]string), new(bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ CountryCode, VatNumber string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		*checked = append(*checked, req.CountryCode+req.VatNumber)
+		if *unavailable {
+			fmt.Fprint(w, `{"valid": false, "userError": "MS_UNAVAILABLE"}`)
+			return
+		}
+		name, ok := known[req.VatNumber]
+		json.NewEncoder(w).Encode(map[string]interface{}{"valid": ok, "name": name, "userError": map[bool]string{true: "VALID", false: "INVALID"}[ok]})
+	}))
+	e.t.Cleanup(srv.Close)
+	e.svc.Config.TaxIDCollection, e.svc.Config.VIESValidation, e.svc.Config.VIESURL = true, true, srv.URL
+	return checked, unavailable
+}
+
+// businessCheckout completes a checkout whose customer entered taxIDs, a
+// JSON array of Stripe tax ID objects, and returns its session ID.
+func (e *testEnv) businessCheckout(taxIDs string) string {
+	e.t.Helper()
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}}})
+	checkStatus(e.t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(e.t, w, &resp)
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_%s", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": "pi_%s", "payment_status": "paid",
+		"amount_total": 1500, "currency": "eur", "customer_details": {"email": "ap@example.com", "name": "Example GmbH", "tax_ids": %s},
+		"metadata": {"order": %q}}}}`, resp.ID, resp.ID, resp.ID, taxIDs, resp.OrderID)))
+	return resp.ID
+}
+
+func TestTaxIDCollection(t *testing.T) {
+	e := newTestEnv(t)
+	checked, _ := e.vies(map[string]string{"123456789": "Example GmbH"})
+
+	sessionID := e.businessCheckout(`[{"type": "eu_vat", "value": "DE123456789"}, {"type": "us_ein", "value": "12-3456789"}]`)
+	if tc := e.stripe.SessionParams[0].TaxIDCollection; tc == nil || !*tc.Enabled {
+		t.Errorf("tax ID collection = %+v, want enabled", tc)
+	}
+	if len(*checked) != 1 || (*checked)[0] != "DE123456789" {
+		t.Errorf("VIES checked %v, want only the VAT number", *checked)
+	}
+	ids, err := e.svc.Payments.ListTaxIDs(context.Background(), sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*service.TaxID{}
+	for _, id := range ids {
+		got[id.Type] = id
+	}
+	if vat := got["eu_vat"]; vat == nil || vat.Verification != service.TaxIDValid || vat.VerifiedName != "Example GmbH" || vat.CheckedAt == nil {
+		t.Errorf("VAT number = %+v, want valid for Example GmbH", vat)
+	}
+	if ein := got["us_ein"]; ein == nil || ein.Verification != service.TaxIDUnverified {
+		t.Errorf("EIN = %+v, want unverified", ein)
+	}
+
+	var receipt *service.EmailMessage
+	for _, m := range e.emails.Sent {
+		if m.To == "ap@example.com" {
+			receipt = m
+		}
+	}
+	if receipt == nil || !strings.Contains(receipt.Text, "Tax ID: DE123456789\nTax ID: 12-3456789\n") {
+		t.Errorf("receipt = %+v, want both tax IDs", receipt)
+	}
+
+	var detail PaymentDetail
+	decodeBody(t, e.admin("GET", "/admin/payments/"+sessionID, nil), &detail)
+	if len(detail.TaxIDs) != 2 {
+		t.Errorf("payment detail tax IDs = %+v", detail.TaxIDs)
+	}
+}
+
+func TestTaxIDInvalidVATNumber(t *testing.T) {
+	e := newTestEnv(t)
+	checked, unavailable := e.vies(nil)
+	*unavailable = true
+
+	sessionID := e.businessCheckout(`[{"type": "eu_vat", "value": "FR00999999999"}]`)
+	ids, err := e.svc.Payments.ListTaxIDs(context.Background(), sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0].Verification != service.TaxIDPending {
+		t.Fatalf("tax IDs while VIES is down = %+v, want pending", ids)
+	}
+	_, dead := e.svc.Jobs.Snapshot()
+	if len(dead) != 1 || dead[0].Type != service.JobVerifyTaxIDs || !strings.Contains(dead[0].LastError, "MS_UNAVAILABLE") {
+		t.Fatalf("dead jobs = %+v, want the failed VIES check", dead)
+	}
+
+	*unavailable = false
+	checkStatus(t, e.admin("POST", "/admin/jobs?retry="+dead[0].ID, nil), http.StatusOK)
+	e.runJobs()
+	if len(*checked) != 2 {
+		t.Errorf("VIES checked %v, want the retry", *checked)
+	}
+	ids, _ = e.svc.Payments.ListTaxIDs(context.Background(), sessionID)
+	if ids[0].Verification != service.TaxIDInvalid {
+		t.Errorf("tax ID = %+v, want invalid", ids[0])
+	}
+	if last := e.emails.Sent[len(e.emails.Sent)-1]; last.Subject != "Invalid VAT number: FR00999999999" {
+		t.Errorf("last email = %q, want the alert", last.Subject)
+	}
+}
@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"stripe_go/internal/service"
+)
+
+// tenantHeader names the tenant of an API request.
+const tenantHeader = "X-Tenant"
+
+// withTenantRouting resolves the tenant of a request from its /t/{id}/
+// path prefix, which is stripped, or its X-Tenant header. Unknown tenants
+// are 404s.
+func (srv *Server) withTenantRouting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(tenantHeader)
+		path := r.URL.Path
+		if rest, ok := strings.CutPrefix(path, "/t/"); ok {
+			var pathID string
+			pathID, path, _ = strings.Cut(rest, "/")
+			if pathID == "" {
+				writeError(w, r, service.StatusError(http.StatusNotFound))
+				return
+			}
+			if id != "" && id != pathID {
+				writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: tenantHeader + " doesn't match the tenant in the path"})
+				return
+			}
+			id, path = pathID, "/"+path
+		}
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t := srv.svc.TenantByID(id)
+		if t == nil {
+			writeError(w, r, &service.Error{Status: http.StatusNotFound, Message: fmt.Sprintf("unknown tenant %q", id)})
+			return
+		}
+		r = r.WithContext(service.WithTenant(r.Context(), t))
+		if path != r.URL.Path {
+			u := *r.URL
+			u.Path, u.RawPath = path, ""
+			r.URL = &u
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,135 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+)
+
+// addTestTenant configures the acme tenant, whose catalog only sells
+// price_basic.
+func (e *testEnv) addTestTenant() *config.Tenant {
+	e.t.Helper()
+	acme := &config.Tenant{
+		ID:             "acme",
+		PublishableKey: "pk_test_acme",
+		SecretKey:      "sk_test_acme",
+		WebhookSecrets: []string{"whsec_acme"},
+		AccountID:      "acct_acme",
+		Price:          "price_basic",
+		Branding:       config.Branding{Name: "Acme", Color: "#ff6600"},
+	}
+	e.svc.Config.Tenants = []*config.Tenant{acme}
+	if err := e.svc.LoadTenantCatalogs(context.Background()); err != nil {
+		e.t.Fatal(err)
+	}
+	catalog := e.svc.TenantCatalogs[acme.ID]
+	basic, _ := catalog.Price("price_basic")
+	for _, p := range catalog.Products() {
+		if p.ID == basic.Product {
+			p.Prices = []*service.CatalogPrice{basic}
+			e.svc.TenantCatalogs[acme.ID] = service.NewCatalog(p)
+			break
+		}
+	}
+	return acme
+}
+
+func TestTenantConfig(t *testing.T) {
+	e := newTestEnv(t)
+	e.addTestTenant()
+
+	for _, header := range [][]string{nil, {tenantHeader, "acme"}} {
+		target := "/t/acme/config"
+		if header != nil {
+			target = "/config"
+		}
+		w := e.do("GET", target, nil, header...)
+		checkStatus(t, w, http.StatusOK)
+		var resp service.ConfigResponse
+		decodeBody(t, w, &resp)
+		if resp.PublishableKey != "pk_test_acme" || resp.Branding.Name != "Acme" || resp.Price != "price_basic" {
+			t.Errorf("%s config = %+v, want acme's key and branding", target, resp)
+		}
+	}
+
+	var resp service.ConfigResponse
+	decodeBody(t, e.do("GET", "/config", nil), &resp)
+	if resp.PublishableKey != "pk_test_123" || resp.Branding.Name != "" {
+		t.Errorf("default config = %+v, want the top-level key", resp)
+	}
+
+	checkErrorMessage(t, e.do("GET", "/t/globex/config", nil), http.StatusNotFound, "unknown tenant")
+	checkErrorMessage(t, e.do("GET", "/config", nil, tenantHeader, "globex"), http.StatusNotFound, "unknown tenant")
+	checkStatus(t, e.do("GET", "/t//config", nil), http.StatusNotFound)
+	checkErrorMessage(t, e.do("GET", "/t/acme/config", nil, tenantHeader, "other"), http.StatusBadRequest, "doesn't match")
+}
+
+func TestTenantCatalog(t *testing.T) {
+	e := newTestEnv(t)
+	e.addTestTenant()
+
+	var products []*service.CatalogProduct
+	decodeBody(t, e.do("GET", "/t/acme/products", nil), &products)
+	if len(products) != 1 || len(products[0].Prices) != 1 || products[0].Prices[0].ID != "price_basic" {
+		t.Errorf("acme products = %+v, want price_basic only", products)
+	}
+
+	cart := map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_yen", Quantity: 1}}}
+	checkErrorMessage(t, e.do("POST", "/t/acme/create-checkout-session", cart), http.StatusBadRequest, "unknown price")
+	checkStatus(t, e.do("POST", "/create-checkout-session", cart), http.StatusOK)
+}
+
+func TestTenantWebhook(t *testing.T) {
+	e := newTestEnv(t)
+	e.addTestTenant()
+
+	payload := sessionEvent("checkout.session.expired", "cs_test_acme")
+	w := e.do("POST", "/t/acme/webhook", payload, "Stripe-Signature", signPayload(payload, "whsec_acme", time.Now()))
+	checkStatus(t, w, http.StatusOK)
+	payload = sessionEvent("checkout.session.expired", "cs_test_acme_default")
+	w = e.do("POST", "/t/acme/webhook", payload, "Stripe-Signature", signPayload(payload, servicetest.WebhookSecret, time.Now()))
+	checkErrorMessage(t, w, http.StatusBadRequest, "signature")
+	e.runJobs()
+
+	// Connect events on the shared endpoint are routed by their account,
+	// and the work they queue runs against it.
+	payload = []byte(`{"id": "evt_acme_completed", "object": "event", "type": "checkout.session.completed", "account": "acct_acme",
+		"data": {"object": {"id": "cs_test_acme_completed", "object": "checkout.session", "payment_status": "unpaid"}}}`)
+	if status, body := e.deliver(payload); status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	pending, _ := e.svc.Jobs.Snapshot()
+	if len(pending) != 1 || pending[0].Tenant != "acme" {
+		t.Fatalf("pending jobs = %+v, want one for acme", pending)
+	}
+}
+
+func TestTenantJobs(t *testing.T) {
+	e := newTestEnv(t)
+	acme := e.addTestTenant()
+
+	var keys []string
+	e.svc.Jobs.Handle("probe", func(ctx context.Context, _ json.RawMessage) error {
+		keys = append(keys, e.svc.StripeKey(&stripe.Params{Context: ctx}, e.svc.Config.SecretKey))
+		return nil
+	})
+	for _, ctx := range []context.Context{context.Background(), service.WithTenant(context.Background(), acme)} {
+		if err := e.svc.Jobs.Enqueue(ctx, "probe", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.runJobs()
+	if fmt.Sprint(keys) != "[sk_test_123 sk_test_acme]" {
+		t.Errorf("keys = %v, want the default then acme's", keys)
+	}
+}
@@ -0,0 +1,39 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// withTimeout cancels the request's context after timeout, which aborts
+// the Stripe, database and email calls the handler is waiting on. A handler
+// that then fails with a 500 or 502 answers 504 instead, so clients can tell
+// a slow dependency from a broken one. A zero timeout leaves the request
+// unbounded.
+func withTimeout(timeout time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if timeout <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next(&timeoutRecorder{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+	}
+}
+
+type timeoutRecorder struct {
+	http.ResponseWriter
+	ctx context.Context
+}
+
+func (tr *timeoutRecorder) WriteHeader(code int) {
+	if (code == http.StatusInternalServerError || code == http.StatusBadGateway) &&
+		errors.Is(tr.ctx.Err(), context.DeadlineExceeded) {
+		code = http.StatusGatewayTimeout
+	}
+	tr.ResponseWriter.WriteHeader(code)
+}
+
+func (tr *timeoutRecorder) Unwrap() http.ResponseWriter { return tr.ResponseWriter }
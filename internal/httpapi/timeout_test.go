@@ -0,0 +1,79 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"stripe_go/internal/service"
+)
+
+func TestWithTimeout(t *testing.T) {
+	newTestEnv(t)
+	slow := withTimeout(time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		writeJSONErrorMessage(w, r.Context().Err().Error(), http.StatusInternalServerError)
+	})
+	w := httptest.NewRecorder()
+	slow(w, httptest.NewRequest("GET", "/", nil))
+	checkErrorMessage(t, w, http.StatusGatewayTimeout, "deadline exceeded")
+
+	// Errors that aren't down to the deadline keep their status.
+	failing := withTimeout(time.Minute, func(w http.ResponseWriter, r *http.Request) {
+		writeJSONErrorMessage(w, "broken", http.StatusInternalServerError)
+	})
+	w = httptest.NewRecorder()
+	failing(w, httptest.NewRequest("GET", "/", nil))
+	checkStatus(t, w, http.StatusInternalServerError)
+
+	var deadline bool
+	unbounded := withTimeout(0, func(w http.ResponseWriter, r *http.Request) {
+		_, deadline = r.Context().Deadline()
+	})
+	unbounded(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if deadline {
+		t.Error("a zero timeout set a deadline")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.RequestTimeout = time.Nanosecond
+	mux := http.NewServeMux()
+	e.srv.registerRoutes(mux)
+	e.handler = mux
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{
+		"items": []service.CheckoutItem{{Price: "price_basic", Quantity: 1}},
+	})
+	checkStatus(t, w, http.StatusGatewayTimeout)
+	if len(e.stripe.SessionParams) != 0 {
+		t.Error("created a session after the request timed out")
+	}
+}
+
+func TestCanceledRequest(t *testing.T) {
+	e := newTestEnv(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("POST", "/create-donation-session", bytes.NewReader([]byte(`{"amount":500}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	e.handler.ServeHTTP(w, req.WithContext(ctx))
+	if w.Code < 500 {
+		t.Errorf("status = %d, want a 5xx for a cancelled request", w.Code)
+	}
+	if len(e.stripe.SessionParams) != 0 {
+		t.Error("called Stripe for a cancelled request")
+	}
+
+	// Emails stop once their job's context is cancelled.
+	if err := e.svc.SendConfirmationEmail(ctx, &service.Receipt{Email: "jenny@example.com"}); err != context.Canceled {
+		t.Errorf("sendConfirmationEmail = %v, want context.Canceled", err)
+	}
+	if len(e.emails.Sent) != 0 {
+		t.Errorf("sent %d emails", len(e.emails.Sent))
+	}
+}
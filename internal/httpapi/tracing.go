@@ -0,0 +1,37 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"stripe_go/internal/service"
+)
+
+// withTracing continues the trace of an incoming request, or starts one,
+// and names its span after the route that serves it. It is the outermost
+// handler, so the span covers the whole request.
+func withTracing(mux *http.ServeMux, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.request",
+		otelhttp.WithPropagators(service.TracePropagator),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + routePattern(mux, r)
+		}))
+}
+
+// routePattern is the mux pattern r is routed to, ignoring a tenant
+// prefix, so that spans of the same endpoint share a name.
+func routePattern(mux *http.ServeMux, r *http.Request) string {
+	path := r.URL.Path
+	if rest, ok := strings.CutPrefix(path, "/t/"); ok {
+		_, path, _ = strings.Cut(rest, "/")
+		path = "/" + path
+	}
+	_, pattern := mux.Handler(&http.Request{Method: r.Method, Host: r.Host, URL: &url.URL{Path: path}})
+	if pattern == "" {
+		return "unmatched"
+	}
+	return pattern
+}
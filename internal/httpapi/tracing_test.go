@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"stripe_go/internal/service"
+)
+
+// recordSpans installs a tracer provider that keeps every span.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// recordSpans is recordSpans for a server that traces requests and emails
+// as run does.
+func (e *testEnv) recordSpans() *tracetest.SpanRecorder {
+	recorder := recordSpans(e.t)
+	mux := http.NewServeMux()
+	e.srv.registerRoutes(mux)
+	e.handler = withTracing(mux, withRequestLogging(e.srv.withTenantRouting(e.srv.withRequestLimits(mux))))
+	e.svc.EmailSender = service.TracedEmailSender{EmailSender: e.emails, Backend: "log"}
+	return recorder
+}
+
+func TestCheckoutTrace(t *testing.T) {
+	e := newTestEnv(t)
+	recorder := e.recordSpans()
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": []service.CheckoutItem{{Price: "price_monthly", Quantity: 1}}},
+		"traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	checkStatus(t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(t, w, &resp)
+	params := e.stripe.SessionParams[0]
+	tp := params.Metadata[service.TraceMetadataKey]
+	if !strings.HasPrefix(tp, "00-"+traceID+"-") || params.PaymentIntentData.Metadata[service.TraceMetadataKey] != tp {
+		t.Fatalf("session metadata = %v, payment intent metadata = %v", params.Metadata, params.PaymentIntentData.Metadata)
+	}
+
+	// Stripe's webhook arrives outside the trace; the event's metadata
+	// brings it back in.
+	e.deliverOK([]byte(fmt.Sprintf(`{"id": "evt_traced", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": "pi_traced", "payment_status": "paid",
+		"amount_total": 3000, "currency": "usd", "customer_details": {"email": "jenny@example.com"},
+		"metadata": {"order": %q, "traceparent": %q}}}}`, resp.ID, resp.OrderID, tp)))
+	e.runJobs()
+	if len(e.emails.Sent) != 1 {
+		t.Fatalf("sent %d emails", len(e.emails.Sent))
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	for _, name := range []string{
+		"POST /create-checkout-session",
+		"db INSERT",
+		"stripe.event checkout.session.completed",
+		"job " + service.JobUpdatePaymentStatus,
+		"job " + service.JobSendConfirmationEmail,
+		"email.send",
+	} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("no %q span", name)
+			continue
+		}
+		if got := s.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("%q span is in trace %s, want %s", name, got, traceID)
+		}
+	}
+	webhook, ok := spans["POST /webhook"]
+	if !ok {
+		t.Fatal("no webhook span")
+	}
+	if webhook.SpanContext().TraceID().String() == traceID {
+		t.Error("the webhook delivery joined the checkout trace")
+	}
+	if links := spans["stripe.event checkout.session.completed"].Links(); len(links) != 1 || links[0].SpanContext.SpanID() != webhook.SpanContext().SpanID() {
+		t.Errorf("event span links = %+v", links)
+	}
+}
+
+func TestCheckoutTraceWithIdempotencyKey(t *testing.T) {
+	e := newTestEnv(t)
+	e.recordSpans()
+	// A retry would carry another trace, and Stripe would refuse its params.
+	w := e.do("POST", "/create-donation-session", map[string]interface{}{"amount": 500},
+		"Idempotency-Key", "donation-1", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	checkStatus(t, w, http.StatusOK)
+	if tp, ok := e.stripe.SessionParams[0].Metadata[service.TraceMetadataKey]; ok {
+		t.Errorf("traceparent %q sent with an idempotency key", tp)
+	}
+}
+
+func TestRoutePattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/payments/", func(http.ResponseWriter, *http.Request) {})
+	for path, want := range map[string]string{
+		"/admin/payments/cs_123":       "/admin/payments/",
+		"/t/acme/admin/payments/cs_12": "/admin/payments/",
+		"/nowhere":                     "unmatched",
+	} {
+		r, _ := http.NewRequest("GET", path, nil)
+		if got := routePattern(mux, r); got != want {
+			t.Errorf("routePattern(%s) = %q, want %q", path, got, want)
+		}
+	}
+}
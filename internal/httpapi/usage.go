@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"stripe_go/internal/service"
+)
+
+// handleUsageRecords serves POST /usage-records, which stores a batch of
+// usage and queues it to be reported to Stripe, and GET /usage-records to
+// list what was recorded.
+func (srv *Server) handleUsageRecords(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		srv.listUsageRecords(w, r)
+	case "POST":
+		var req service.UsageRecordRequest
+		if err := srv.decodeJSON(w, r, &req); err != nil {
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+		records, err := srv.svc.AddUsageRecords(r.Context(), &req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, struct {
+			Records []*service.UsageRecord `json:"records"`
+		}{records})
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (srv *Server) listUsageRecords(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := service.UsageRecordFilter{SubscriptionItem: q.Get("subscriptionItem"), Status: q.Get("status"), Limit: service.DefaultPageSize}
+	switch f.Status {
+	case "", service.UsagePending, service.UsageReported, service.UsageFailed:
+	default:
+		writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid status %q", f.Status)})
+		return
+	}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > service.MaxPageSize {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("limit must be between 1 and %d", service.MaxPageSize)})
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			writeError(w, r, &service.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid offset %q", v)})
+			return
+		}
+	}
+	// Fetch one extra row to know whether there is another page.
+	limit := f.Limit
+	f.Limit++
+	list, err := srv.svc.Payments.ListUsageRecords(r.Context(), f)
+	if err != nil {
+		writeError(w, r, service.InternalError("listing usage records", err))
+		return
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []*service.UsageRecord{}
+	}
+	writeJSON(w, struct {
+		Records []*service.UsageRecord `json:"records"`
+		Limit   int                    `json:"limit"`
+		Offset  int                    `json:"offset"`
+		HasMore bool                   `json:"hasMore"`
+	}{list, limit, f.Offset, hasMore})
+}
+
+// handleUsageReconcile serves GET /usage-records/reconcile?subscriptionItem=.
+func (srv *Server) handleUsageReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w)
+		return
+	}
+	item := r.URL.Query().Get("subscriptionItem")
+	if err := service.ValidateStripeID(item, "si_", "subscription item"); err != nil {
+		writeError(w, r, service.BadRequest(err))
+		return
+	}
+	rec, err := srv.svc.ReconcileUsage(r.Context(), item)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !rec.Matched {
+		logFor(r).Warn("usage differs from Stripe", "subscription_item", item)
+	}
+	writeJSON(w, rec)
+}
@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// seedMeteredSubscription gives the fake Stripe a subscription whose item
+// si_{id} is billed per API call.
+func (e *testEnv) seedMeteredSubscription(id string) {
+	e.seedSubscription(id, 0)
+	e.stripe.Subscriptions[id].Items.Data[0].Price = &stripe.Price{
+		ID: "price_api_calls", Currency: stripe.CurrencyUSD, UnitAmount: 2,
+		Recurring: &stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalMonth, UsageType: stripe.PriceRecurringUsageTypeMetered},
+	}
+}
+
+func (e *testEnv) reportUsage(records ...*service.UsageRecordInput) []*service.UsageRecord {
+	e.t.Helper()
+	w := e.admin("POST", "/usage-records", service.UsageRecordRequest{Records: records})
+	checkStatus(e.t, w, http.StatusOK)
+	var resp struct {
+		Records []*service.UsageRecord `json:"records"`
+	}
+	decodeBody(e.t, w, &resp)
+	return resp.Records
+}
+
+func (e *testEnv) reconcileUsage(item string) *service.UsageReconciliation {
+	e.t.Helper()
+	w := e.admin("GET", "/usage-records/reconcile?subscriptionItem="+item, nil)
+	checkStatus(e.t, w, http.StatusOK)
+	var resp service.UsageReconciliation
+	decodeBody(e.t, w, &resp)
+	return &resp
+}
+
+func TestUsageRecordValidation(t *testing.T) {
+	e := newTestEnv(t)
+	many := make([]*service.UsageRecordInput, service.MaxUsageBatch+1)
+	for i := range many {
+		many[i] = &service.UsageRecordInput{SubscriptionItem: "si_1", Quantity: 1}
+	}
+	for _, tt := range []struct {
+		name    string
+		records []*service.UsageRecordInput
+		want    string
+	}{
+		{"empty batch", nil, "between 1 and 100 entries"},
+		{"batch too big", many, "between 1 and 100 entries"},
+		{"no item", []*service.UsageRecordInput{{Quantity: 1}}, "records[0]: invalid subscription item"},
+		{"negative", []*service.UsageRecordInput{{SubscriptionItem: "si_1", Quantity: -1}}, "must not be negative"},
+		{"bad action", []*service.UsageRecordInput{{SubscriptionItem: "si_1", Quantity: 1, Action: "add"}}, "action must be increment or set"},
+		{"future", []*service.UsageRecordInput{{SubscriptionItem: "si_1", Timestamp: time.Now().Add(time.Hour).Unix()}}, "isn't in the future"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErrorMessage(t, e.admin("POST", "/usage-records", service.UsageRecordRequest{Records: tt.records}), http.StatusBadRequest, tt.want)
+		})
+	}
+	checkErrorMessage(t, e.admin("GET", "/usage-records/reconcile?subscriptionItem=sub_1", nil), http.StatusBadRequest, "invalid subscription item")
+	checkErrorMessage(t, e.admin("GET", "/usage-records?status=sent", nil), http.StatusBadRequest, "invalid status")
+}
+
+func TestUsageRecords(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedMeteredSubscription("sub_metered")
+	e.seedSubscription("sub_licensed", 1)
+	now := time.Now().Unix()
+
+	records := e.reportUsage(
+		&service.UsageRecordInput{SubscriptionItem: "si_sub_metered", Quantity: 120, Timestamp: now - 60, IdempotencyKey: "batch-1"},
+		&service.UsageRecordInput{SubscriptionItem: "si_sub_metered", Quantity: 30, Timestamp: now - 60, IdempotencyKey: "batch-2"},
+		&service.UsageRecordInput{SubscriptionItem: "si_sub_licensed", Quantity: 5},
+	)
+	if len(records) != 3 || records[0].Status != service.UsagePending || records[0].Action != "increment" {
+		t.Fatalf("records = %+v", records)
+	}
+
+	// Stripe is down for the first attempt: the records stay pending, and
+	// the job can be retried once it has run out of attempts.
+	e.stripe.Err = errors.New("connection reset")
+	e.runJobs()
+	e.stripe.Err = nil
+	_, dead := e.svc.Jobs.Snapshot()
+	if len(dead) != 1 || dead[0].Type != service.JobReportUsage {
+		t.Fatalf("dead jobs = %+v", dead)
+	}
+	if list := e.usageRecords("?status=pending"); len(list) != 3 || list[0].LastError == "" {
+		t.Fatalf("pending records = %+v", list)
+	}
+	e.svc.Jobs.Retry(dead[0].ID)
+	e.runJobs()
+
+	list := e.usageRecords("")
+	if len(list) != 3 || list[0].Status != service.UsageReported || list[0].StripeID == "" || list[0].Attempts != 2 || list[0].ReportedAt == nil {
+		t.Errorf("reported record = %+v", list[0])
+	}
+	if list[2].Status != service.UsageFailed || !strings.Contains(list[2].LastError, "metered prices") {
+		t.Errorf("licensed record = %+v", list[2])
+	}
+	// The records are reported with keys that survive retries.
+	if key := stripe.StringValue(e.stripe.UsageParams[len(e.stripe.UsageParams)-3].IdempotencyKey); key != "usage_record:"+list[0].ID {
+		t.Errorf("idempotency key = %q", key)
+	}
+
+	// A batch sent again isn't counted twice.
+	again := e.reportUsage(&service.UsageRecordInput{SubscriptionItem: "si_sub_metered", Quantity: 120, Timestamp: now - 60, IdempotencyKey: "batch-1"})
+	if again[0].ID != list[0].ID || again[0].Status != service.UsageReported {
+		t.Errorf("resent record = %+v", again[0])
+	}
+	e.runJobs()
+	if got := e.stripe.Usage["si_sub_metered"][now-60]; got != 150 {
+		t.Errorf("usage at Stripe = %d, want 150", got)
+	}
+
+	rec := e.reconcileUsage("si_sub_metered")
+	if !rec.Matched || len(rec.Periods) != 1 || rec.Periods[0].StripeTotal != 150 || rec.Periods[0].LocalTotal != 150 {
+		t.Errorf("reconciliation = %+v", rec)
+	}
+
+	// Usage reported to Stripe from elsewhere shows up as a difference.
+	e.stripe.Usage["si_sub_metered"][now-30] = 7
+	rec = e.reconcileUsage("si_sub_metered")
+	if rec.Matched || rec.Periods[0].Difference != 7 {
+		t.Errorf("reconciliation with outside usage = %+v", rec.Periods[0])
+	}
+	if rec := e.reconcileUsage("si_sub_licensed"); rec.Failed != 1 || !rec.Matched {
+		t.Errorf("licensed reconciliation = %+v", rec)
+	}
+}
+
+func (e *testEnv) usageRecords(query string) []*service.UsageRecord {
+	e.t.Helper()
+	w := e.admin("GET", "/usage-records"+query, nil)
+	checkStatus(e.t, w, http.StatusOK)
+	var resp struct {
+		Records []*service.UsageRecord `json:"records"`
+	}
+	decodeBody(e.t, w, &resp)
+	return resp.Records
+}
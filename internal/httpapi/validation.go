@@ -0,0 +1,39 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"stripe_go/internal/service"
+)
+
+// Validator is implemented by request structs that can check themselves
+// against svc's configuration and catalog.
+type Validator interface {
+	Validate(ctx context.Context, svc *service.Service) error
+}
+
+// decodeJSON decodes a JSON request body into v and validates it if v
+// implements Validator.
+func (srv *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("error parsing request %v", err.Error())
+	}
+	if val, ok := v.(Validator); ok {
+		return val.Validate(r.Context(), srv.svc)
+	}
+	return nil
+}
+
+func validateSessionID(id string) error {
+	if !service.SessionIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid session ID %q", id)
+	}
+	return nil
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeJSONErrorMessage(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,123 @@
+package httpapi
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+	"stripe_go/internal/stripeclient"
+)
+
+// VerifiedWebhookHandler handles a webhook delivery whose signature has been
+// checked. event is parsed from payload, the body Stripe signed.
+type VerifiedWebhookHandler func(w http.ResponseWriter, r *http.Request, event stripe.Event, payload []byte)
+
+// maxWebhookBytes caps the size of a webhook payload; /webhook and
+// /dev/replay-event are registered with it as their body limit.
+const maxWebhookBytes = int64(65536)
+
+// verifyWebhookSignature reads a Stripe webhook delivery, checks its
+// Stripe-Signature header against STRIPE_WEBHOOK_SECRET and rejects it with a 400 if
+// the signature is missing, wrong, or older than WEBHOOK_TOLERANCE. The
+// payload is only parsed once it is known to come from Stripe. Deliveries
+// to a tenant's /t/{id}/webhook are checked against that tenant's secrets,
+// and events on the shared endpoint from a tenant's Stripe account are
+// handled for that tenant.
+func (srv *Server) verifyWebhookSignature(next VerifiedWebhookHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeMethodNotAllowed(w)
+			return
+		}
+		payload, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			logFor(r).Error("reading webhook body", "error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		event, err := srv.constructEvent(r, payload)
+		if err != nil {
+			logFor(r).Warn("webhook error while validating signature", "error", err)
+			srv.notifySignatureFailure(r, err)
+			writeError(w, r, service.BadRequest(err))
+			return
+		}
+		if t := srv.svc.TenantByAccount(event.Account); t != nil && service.TenantFrom(r.Context()) == nil {
+			r = r.WithContext(service.WithTenant(r.Context(), t))
+		} else if event.Account != "" && service.TenantFrom(r.Context()) == nil {
+			// Other connected accounts' events are about their own objects,
+			// which the handlers fetch and update on the account. Only
+			// events pricing items need its catalog, so an account whose
+			// catalog can't be read still gets the others, such as
+			// account.updated.
+			if pricesItems(event.Type) {
+				if _, err := srv.svc.AccountCatalog(r.Context(), event.Account); err != nil {
+					logFor(r).Error("loading connected account catalog", "account", event.Account, "event", event.ID, "error", err)
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+			}
+			r = r.WithContext(stripeclient.WithAccount(r.Context(), event.Account))
+		}
+		next(w, r, event, payload)
+	}
+}
+
+// catalogEventPrefixes are the events whose handlers, and the fulfillment
+// and receipt jobs they queue, look items up in the catalog.
+var catalogEventPrefixes = []string{"checkout.session.", "payment_intent."}
+
+// pricesItems reports whether events of eventType need the catalog of the
+// account they come from.
+func pricesItems(eventType string) bool {
+	for _, prefix := range catalogEventPrefixes {
+		if strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// constructEvent verifies payload against each STRIPE_WEBHOOK_SECRET, or
+// each of the request's tenant's webhook secrets, in turn, so deliveries
+// signed with the old and the new secret are both accepted while the
+// endpoint secret is rotated. The first secret's error is returned when none
+// match.
+func (srv *Server) constructEvent(r *http.Request, payload []byte) (stripe.Event, error) {
+	err := errors.New("no webhook secret configured")
+	for i, secret := range srv.svc.WebhookSecrets(r.Context()) {
+		event, e := srv.svc.Stripe.ConstructEvent(payload, r.Header.Get("Stripe-Signature"), secret, srv.svc.Config.WebhookTolerance)
+		if e == nil {
+			if i > 0 {
+				// Once the first secret stops showing up here, the old
+				// ones can be removed.
+				logFor(r).Info("webhook signed with an older secret", "event", event.ID, "secret", i+1)
+			}
+			return event, nil
+		}
+		if i == 0 {
+			err = e
+		}
+	}
+	return stripe.Event{}, err
+}
+
+// handleWebhook processes a verified event: duplicates are acknowledged
+// without running the handlers again.
+func (srv *Server) handleWebhook(w http.ResponseWriter, r *http.Request, event stripe.Event, payload []byte) {
+	duplicate, err := srv.svc.ProcessEvent(r.Context(), event, payload)
+	switch {
+	case errors.Is(err, service.ErrClaimEvent):
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+	case duplicate:
+		writeJSON(w, &service.WebhookResponse{Success: true, Duplicate: true})
+	default:
+		writeJSON(w, &service.WebhookResponse{Success: true, Received: event.Type})
+	}
+}
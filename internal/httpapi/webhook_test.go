@@ -0,0 +1,325 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+	"stripe_go/internal/store"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// signPayload builds a Stripe-Signature header for payload signed at t.
+func signPayload(payload []byte, secret string, t time.Time) string {
+	sig := webhook.ComputeSignature(t, payload, secret)
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(sig))
+}
+
+func (e *testEnv) deliver(payload []byte) (int, string) {
+	e.t.Helper()
+	w := e.do("POST", "/webhook", payload, "Stripe-Signature", signPayload(payload, servicetest.WebhookSecret, time.Now()))
+	return w.Code, w.Body.String()
+}
+
+// seedPayment stores the paid checkout that the refund and failure fixtures
+// refer to.
+func (e *testEnv) seedPayment() {
+	e.t.Helper()
+	err := e.svc.Payments.SavePayment(context.Background(), &service.Payment{
+		SessionID:       "cs_test_seed",
+		PaymentIntentID: "pi_test_seed",
+		Amount:          3000,
+		Currency:        "usd",
+		Status:          "paid",
+	})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// webhookResult is what a delivered event did: the response, the stored
+// records and the emails sent once queued jobs have run. Timestamps are
+// cleared so the result is stable.
+type webhookResult struct {
+	Status   int                       `json:"status"`
+	Response json.RawMessage           `json:"response"`
+	Payments []*service.Payment        `json:"payments"`
+	Refunds  []*service.Refund         `json:"refunds"`
+	Account  *service.ConnectedAccount `json:"account,omitempty"`
+	// Subscription is sub_test_1, which the subscription fixtures use.
+	Subscription *service.Subscription `json:"subscription,omitempty"`
+	// Dispute is dp_test_seed, which the dispute fixtures use.
+	Dispute *service.Dispute        `json:"dispute,omitempty"`
+	Emails  []*service.EmailMessage `json:"emails"`
+}
+
+func (e *testEnv) result(status int, body string) *webhookResult {
+	e.t.Helper()
+	res := &webhookResult{Status: status, Response: json.RawMessage(strings.TrimSpace(body))}
+	list, err := e.svc.Payments.ListPayments(context.Background(), service.PaymentFilter{})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	for _, p := range list {
+		p.CreatedAt, p.UpdatedAt = time.Time{}, time.Time{}
+		refunds, err := e.svc.Payments.ListRefunds(context.Background(), p.PaymentIntentID)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		for _, r := range refunds {
+			r.CreatedAt = time.Time{}
+			res.Refunds = append(res.Refunds, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SessionID < list[j].SessionID })
+	sort.Slice(res.Refunds, func(i, j int) bool { return res.Refunds[i].ID < res.Refunds[j].ID })
+	res.Payments = list
+	if a, err := e.svc.Payments.GetConnectedAccount(context.Background(), "acct_test_seller"); err == nil {
+		a.UpdatedAt = time.Time{}
+		res.Account = a
+	}
+	if sub, err := e.svc.Payments.GetSubscription(context.Background(), "sub_test_1"); err == nil {
+		sub.UpdatedAt = time.Time{}
+		res.Subscription = sub
+	}
+	if d, err := e.svc.Payments.GetDispute(context.Background(), "dp_test_seed"); err == nil {
+		d.CreatedAt, d.UpdatedAt = time.Time{}, time.Time{}
+		res.Dispute = d
+	}
+	res.Emails = e.emails.Sent
+	return res
+}
+
+func TestWebhookGolden(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/webhooks/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no webhook fixtures")
+	}
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			payload, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := newTestEnv(t)
+			e.seedPayment()
+			status, body := e.deliver(payload)
+			e.runJobs()
+
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(e.result(status, body)); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.Bytes()
+			golden := filepath.Join("testdata/webhooks", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("result differs from %s (run go test -update if the change is intended)\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	e := newTestEnv(t)
+	payload, err := os.ReadFile("testdata/webhooks/checkout_session_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		header string
+	}{
+		{"missing", ""},
+		{"wrong secret", signPayload(payload, "whsec_other", time.Now())},
+		{"expired", signPayload(payload, servicetest.WebhookSecret, time.Now().Add(-time.Hour))},
+		{"tampered", signPayload(append([]byte(" "), payload...), servicetest.WebhookSecret, time.Now())},
+		{"malformed header", "t=yesterday,v1=abc"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := e.do("POST", "/webhook", payload, "Stripe-Signature", tt.header)
+			checkStatus(t, w, http.StatusBadRequest)
+		})
+	}
+	if _, err := e.svc.Payments.GetPayment(context.Background(), "cs_test_completed"); err != service.ErrPaymentNotFound {
+		t.Errorf("unsigned event was processed: %v", err)
+	}
+	checkStatus(t, e.do("GET", "/webhook", nil), http.StatusMethodNotAllowed)
+}
+
+func TestWebhookSecretRotation(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.Config.WebhookSecrets = []string{"whsec_new", servicetest.WebhookSecret}
+	for i, secret := range e.svc.Config.WebhookSecrets {
+		payload := sessionEvent("checkout.session.expired", fmt.Sprintf("cs_test_rotation_%d", i))
+		w := e.do("POST", "/webhook", payload, "Stripe-Signature", signPayload(payload, secret, time.Now()))
+		checkStatus(t, w, http.StatusOK)
+	}
+	payload := sessionEvent("checkout.session.expired", "cs_test_rotation_other")
+	w := e.do("POST", "/webhook", payload, "Stripe-Signature", signPayload(payload, "whsec_other", time.Now()))
+	checkErrorMessage(t, w, http.StatusBadRequest, "signature")
+
+	e.svc.Config.WebhookSecrets = nil
+	w = e.do("POST", "/webhook", payload, "Stripe-Signature", signPayload(payload, servicetest.WebhookSecret, time.Now()))
+	checkErrorMessage(t, w, http.StatusBadRequest, "no webhook secret configured")
+}
+
+func TestWebhookSignatureTolerance(t *testing.T) {
+	e := newTestEnv(t)
+	payload, err := os.ReadFile("testdata/webhooks/checkout_session_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := signPayload(payload, servicetest.WebhookSecret, time.Now().Add(-10*time.Minute))
+	checkErrorMessage(t, e.do("POST", "/webhook", payload, "Stripe-Signature", old), http.StatusBadRequest, "timestamp wasn't within tolerance")
+
+	e.svc.Config.WebhookTolerance = 15 * time.Minute
+	checkStatus(t, e.do("POST", "/webhook", payload, "Stripe-Signature", old), http.StatusOK)
+
+	// A signed payload that isn't an event is rejected before any handler runs.
+	garbage := []byte(`{"id": `)
+	checkStatus(t, e.do("POST", "/webhook", garbage, "Stripe-Signature", signPayload(garbage, servicetest.WebhookSecret, time.Now())), http.StatusBadRequest)
+}
+
+func TestWebhookDuplicateDelivery(t *testing.T) {
+	e := newTestEnv(t)
+	payload, err := os.ReadFile("testdata/webhooks/checkout_session_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := e.deliver(payload); status != http.StatusOK {
+		t.Fatalf("first delivery: %d %s", status, body)
+	}
+	status, body := e.deliver(payload)
+	if status != http.StatusOK || !strings.Contains(body, `"duplicate":true`) {
+		t.Fatalf("second delivery: %d %s", status, body)
+	}
+	e.runJobs()
+	if len(e.emails.Sent) != 1 {
+		t.Errorf("sent %d emails, want 1", len(e.emails.Sent))
+	}
+}
+
+func TestDatabaseEventStore(t *testing.T) {
+	e := newTestEnv(t)
+	events, err := store.NewEventStore(&config.Config{EventStore: "database", EventDedupeTTL: time.Hour}, e.svc.Payments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.svc.Events = events
+	payload, err := os.ReadFile("testdata/webhooks/checkout_session_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.deliverOK(payload)
+	if status, body := e.deliver(payload); status != http.StatusOK || !strings.Contains(body, `"duplicate":true`) {
+		t.Fatalf("second delivery: %d %s", status, body)
+	}
+
+	if ok, err := e.svc.Events.Claim(context.Background(), "evt_1"); !ok || err != nil {
+		t.Fatalf("Claim = %v, %v", ok, err)
+	}
+	if err := e.svc.Events.Release(context.Background(), "evt_1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := e.svc.Events.Claim(context.Background(), "evt_1"); !ok || err != nil {
+		t.Fatalf("Claim after Release = %v, %v", ok, err)
+	}
+	// Expired claims don't count.
+	if ok, err := e.svc.Payments.ClaimEvent(context.Background(), "evt_2", time.Now().Add(-time.Second)); !ok || err != nil {
+		t.Fatalf("ClaimEvent = %v, %v", ok, err)
+	}
+	if ok, err := e.svc.Events.Claim(context.Background(), "evt_2"); !ok || err != nil {
+		t.Errorf("Claim of an expired event = %v, %v", ok, err)
+	}
+	// A completed event is kept past its lease.
+	if err := e.svc.Payments.CompleteEvent(context.Background(), "evt_2", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := e.svc.Events.Claim(context.Background(), "evt_2"); ok || err != nil {
+		t.Errorf("Claim of a processed event = %v, %v", ok, err)
+	}
+}
+
+func TestWebhookHandlerFailureIsRetried(t *testing.T) {
+	e := newTestEnv(t)
+	calls := 0
+	e.svc.WebhookRouter.On("test.flaky", func(context.Context, stripe.Event) error {
+		calls++
+		if calls == 1 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+	payload := []byte(`{"id": "evt_test_flaky", "object": "event", "type": "test.flaky", "data": {"object": {}}}`)
+	if status, _ := e.deliver(payload); status != http.StatusInternalServerError {
+		t.Fatalf("failed handler: status %d, want 500 so Stripe retries", status)
+	}
+	if status, body := e.deliver(payload); status != http.StatusOK || strings.Contains(body, "duplicate") {
+		t.Fatalf("retry: %d %s, want it processed again", status, body)
+	}
+}
+
+func TestWebhookRetryDoesNotRequeueJobs(t *testing.T) {
+	e := newTestEnv(t)
+	e.svc.WebhookRouter.On("test.partial", func(ctx context.Context, event stripe.Event) error {
+		if err := e.svc.NotifyOps(ctx, "test", "first"); err != nil {
+			return err
+		}
+		return e.svc.Jobs.Enqueue(ctx, service.JobSendNotification, &config.Notification{Channel: config.ChannelEmail, Subject: "second"})
+	})
+	calls := 0
+	e.svc.WebhookRouter.On("test.partial", func(context.Context, stripe.Event) error {
+		if calls++; calls == 1 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+	payload := []byte(`{"id": "evt_test_partial", "object": "event", "type": "test.partial", "data": {"object": {}}}`)
+	if status, _ := e.deliver(payload); status != http.StatusInternalServerError {
+		t.Fatalf("failed handler: status %d, want 500", status)
+	}
+	// The first delivery's jobs run before Stripe retries.
+	e.runJobs()
+	if status, _ := e.deliver(payload); status != http.StatusOK {
+		t.Fatalf("retry: status %d", status)
+	}
+	e.runJobs()
+	if len(e.emails.Sent) != 2 {
+		t.Errorf("sent %d emails, want each notification once", len(e.emails.Sent))
+	}
+}
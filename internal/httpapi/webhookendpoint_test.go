@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"stripe_go/internal/config"
+)
+
+func TestRegisterWebhookEndpoint(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	e.svc.Config.Domain, e.svc.Config.WebhookSecrets = "https://shop.example.com", nil
+	e.svc.Config.WebhookSecretFile = filepath.Join(t.TempDir(), "webhook_secret")
+
+	if err := e.svc.RegisterWebhookEndpoint(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.stripe.Webhooks) != 1 {
+		t.Fatalf("endpoints = %+v, want one", e.stripe.Webhooks)
+	}
+	ep := e.stripe.Webhooks[0]
+	if ep.URL != "https://shop.example.com/webhook" || !slices.Equal(ep.EnabledEvents, e.svc.WebhookRouter.EventTypes()) || ep.APIVersion == "" {
+		t.Errorf("endpoint = %+v", ep)
+	}
+	if !slices.Contains(ep.EnabledEvents, "checkout.session.completed") || slices.Contains(ep.EnabledEvents, "*") {
+		t.Errorf("events = %v, want exactly the handled ones", ep.EnabledEvents)
+	}
+	data, err := os.ReadFile(e.svc.Config.WebhookSecretFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret := strings.TrimSpace(string(data)); secret != "whsec_"+ep.ID || !slices.Equal(e.svc.Config.WebhookSecrets, []string{secret}) {
+		t.Errorf("secret file %q, secrets %v", data, e.svc.Config.WebhookSecrets)
+	}
+	if info, _ := os.Stat(e.svc.Config.WebhookSecretFile); info.Mode().Perm() != 0o600 {
+		t.Errorf("secret file mode = %v, want 0600", info.Mode())
+	}
+	// The next start reads the secret back.
+	if secret, err := config.LoadWebhookSecret(e.svc.Config.WebhookSecretFile); err != nil || secret != "whsec_"+ep.ID {
+		t.Errorf("loadWebhookSecret = %q, %v", secret, err)
+	}
+
+	// An endpoint that is up to date is left alone.
+	if err := e.svc.RegisterWebhookEndpoint(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.stripe.WebhookParams) != 1 {
+		t.Errorf("calls = %d, want no update", len(e.stripe.WebhookParams))
+	}
+
+	// One edited in the Dashboard is put back.
+	ep.EnabledEvents, ep.Status = []string{"charge.refunded"}, "disabled"
+	if err := e.svc.RegisterWebhookEndpoint(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.stripe.Webhooks) != 1 || ep.Status != "enabled" || !slices.Equal(ep.EnabledEvents, e.svc.WebhookRouter.EventTypes()) {
+		t.Errorf("endpoint after update = %+v", ep)
+	}
+
+	// Stripe doesn't reveal the secret of an existing endpoint again.
+	e.svc.Config.WebhookSecrets = nil
+	if err := e.svc.RegisterWebhookEndpoint(ctx); err == nil || !strings.Contains(err.Error(), "signing secret is unknown") {
+		t.Errorf("register without the secret: %v", err)
+	}
+}
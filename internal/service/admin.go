@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"strings"
+)
+
+// The page size of the admin list endpoints when no limit is given, and the
+// largest limit they accept.
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ListPayments returns a page of the payments matching f and whether there
+// is another page.
+func (svc *Service) ListPayments(ctx context.Context, f PaymentFilter) ([]*Payment, bool, error) {
+	// Fetch one extra row to know whether there is another page.
+	limit := f.Limit
+	f.Limit++
+	list, err := svc.Payments.ListPayments(ctx, f)
+	if err != nil {
+		return nil, false, InternalError("listing payments", err)
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []*Payment{}
+	}
+	return list, hasMore, nil
+}
+
+// FindPayment looks a payment up by checkout session or payment intent ID.
+func (svc *Service) FindPayment(ctx context.Context, id string) (*Payment, error) {
+	if strings.HasPrefix(id, "pi_") {
+		return svc.Payments.GetPaymentByIntent(ctx, id)
+	}
+	return svc.Payments.GetPayment(ctx, id)
+}
+
+// GetPayment is FindPayment for the service operations.
+func (svc *Service) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	p, err := svc.FindPayment(ctx, id)
+	if err != nil && err != ErrPaymentNotFound {
+		return nil, InternalError("fetching payment", err)
+	}
+	return p, err
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"io/fs"
+	"os"
+)
+
+// Assets are the files a Service serves and renders unless STATIC_DIR and
+// EMAIL_TEMPLATE_DIR name directories on disk: the HTML client, served at
+// /, and the email templates, one directory per locale.
+type Assets struct {
+	Static         fs.FS
+	EmailTemplates fs.FS
+}
+
+// assetFS returns dir on disk, or the asset fsys when dir is empty.
+func assetFS(dir string, fsys fs.FS) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	return fsys
+}
+
+// StaticFS holds the HTML client served at /.
+func (svc *Service) StaticFS() fs.FS {
+	return assetFS(svc.Config.StaticDir, svc.assets.Static)
+}
+
+// EmailTemplateFS holds the email templates, one directory per locale.
+func (svc *Service) EmailTemplateFS() fs.FS {
+	return assetFS(svc.Config.EmailTemplateDir, svc.assets.EmailTemplates)
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// AuditEntry records one operation that moved money or changed what the
+// shop sells: a checkout created, a refund issued, a webhook processed, an
+// admin action. Actor is the principal that made the request, "customer"
+// for storefront requests, "stripe" for webhooks or "reconciler". Before and
+// After are the affected object's state around the operation, when known.
+type AuditEntry struct {
+	ID        string          `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Object    string          `json:"object"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+const (
+	ActorCustomer   = "customer"
+	actorStripe     = "stripe"
+	actorReconciler = "reconciler"
+)
+
+// AuditedKey marks a request whose handler recorded its own, more specific
+// audit entry, so auditRequest doesn't add a generic one.
+type AuditedKey struct{}
+
+// AuditActor is who made the request of ctx: the authenticated principal,
+// or the customer.
+func AuditActor(ctx context.Context) string {
+	if p, ok := PrincipalFrom(ctx); ok {
+		return p.Name
+	}
+	return ActorCustomer
+}
+
+// RecordAudit appends an entry to the audit log. before and after are
+// stored as JSON; nil leaves them empty. The operation has already happened
+// by the time it is audited, so a failure is logged rather than returned.
+func (svc *Service) RecordAudit(ctx context.Context, actor, action, object string, before, after interface{}) {
+	e := &AuditEntry{
+		ID:        "aud_" + NewRequestID(),
+		Actor:     actor,
+		Action:    action,
+		Object:    object,
+		RequestID: requestID(ctx),
+	}
+	var err error
+	if before != nil {
+		if e.Before, err = json.Marshal(before); err != nil {
+			slog.Error("encoding audit state", "action", action, "object", object, "error", err)
+		}
+	}
+	if after != nil {
+		if e.After, err = json.Marshal(after); err != nil {
+			slog.Error("encoding audit state", "action", action, "object", object, "error", err)
+		}
+	}
+	if audited, ok := ctx.Value(AuditedKey{}).(*bool); ok {
+		*audited = true
+	}
+	if err := svc.Payments.AppendAudit(ctx, e); err != nil {
+		slog.Error("writing audit log", "action", action, "object", object, "error", err)
+	}
+}
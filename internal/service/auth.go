@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"stripe_go/internal/config"
+)
+
+// Principal is the caller a request was authenticated as.
+type Principal struct {
+	Name string      `json:"name"`
+	Role config.Role `json:"role"`
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns ctx carrying who its request was authenticated as.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns who the request was authenticated as by requireAuth.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// AuthEnabled reports whether any credential is configured.
+func (svc *Service) AuthEnabled() bool {
+	svc.authMu.RLock()
+	defer svc.authMu.RUnlock()
+	return svc.Config.AdminToken != "" || len(svc.Config.APIKeys) > 0 || len(svc.Config.JWTSecrets) > 0
+}
+
+// Authenticate checks a bearer credential against the configured ones.
+// Every static key is compared so the time taken doesn't reveal which one
+// matched.
+func (svc *Service) Authenticate(token string, now time.Time) (Principal, error) {
+	if token == "" {
+		return Principal{}, errors.New("no credentials")
+	}
+	svc.authMu.RLock()
+	defer svc.authMu.RUnlock()
+	var found *Principal
+	if svc.Config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(svc.Config.AdminToken)) == 1 {
+		found = &Principal{Name: "admin_token", Role: config.RoleAdmin}
+	}
+	for _, k := range svc.Config.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(k.Key)) == 1 {
+			found = &Principal{Name: k.Name, Role: k.Role}
+		}
+	}
+	if found != nil {
+		return *found, nil
+	}
+	if strings.Count(token, ".") == 2 && len(svc.Config.JWTSecrets) > 0 {
+		return verifyJWT(token, svc.Config.JWTSecrets, now)
+	}
+	return Principal{}, errors.New("unknown credentials")
+}
+
+// jwtClaims are the claims requireAuth reads. exp is required.
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Role      config.Role `json:"role"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf"`
+}
+
+// verifyJWT checks an HS256 JWT against each secret, so a new secret can be
+// added before the old one is retired, and returns its subject and role.
+func verifyJWT(token string, secrets []string, now time.Time) (Principal, error) {
+	parts := strings.Split(token, ".")
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Principal{}, errors.New("malformed JWT header")
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil || h.Alg != "HS256" {
+		return Principal{}, fmt.Errorf("unsupported JWT algorithm %q", h.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, errors.New("malformed JWT signature")
+	}
+	valid := false
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		valid = hmac.Equal(sig, mac.Sum(nil)) || valid
+	}
+	if !valid {
+		return Principal{}, errors.New("invalid JWT signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Principal{}, errors.New("malformed JWT claims")
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Principal{}, errors.New("malformed JWT claims")
+	}
+	switch {
+	case claims.ExpiresAt == 0:
+		return Principal{}, errors.New("JWT has no exp")
+	case !now.Before(time.Unix(claims.ExpiresAt, 0)):
+		return Principal{}, errors.New("JWT expired")
+	case claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0)):
+		return Principal{}, errors.New("JWT not valid yet")
+	case !config.Roles[claims.Role]:
+		return Principal{}, fmt.Errorf("unknown JWT role %q", claims.Role)
+	}
+	return Principal{Name: "jwt:" + claims.Subject, Role: claims.Role}, nil
+}
+
+// ReloadCredentials re-reads .env and the configuration and swaps in its
+// ADMIN_TOKEN, API_KEYS and JWT_SECRETS, so keys can be rotated without a
+// restart. An invalid configuration is rejected and the current credentials
+// stay in place.
+func (svc *Service) ReloadCredentials() error {
+	c, err := rereadConfig()
+	if err != nil {
+		return err
+	}
+	svc.swapCredentials(c)
+	return nil
+}
+
+// swapCredentials makes the credentials of c the ones requests are
+// authenticated with.
+func (svc *Service) swapCredentials(c *config.Config) {
+	svc.authMu.Lock()
+	defer svc.authMu.Unlock()
+	svc.Config.AdminToken, svc.Config.APIKeys, svc.Config.JWTSecrets = c.AdminToken, c.APIKeys, c.JWTSecrets
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// Authentication statuses. An authentication is pending while the customer
+// can still approve the payment, and expired once AUTHENTICATION_WINDOW
+// passed without them doing so.
+const (
+	AuthenticationPending   = "pending"
+	AuthenticationSucceeded = "succeeded"
+	AuthenticationCanceled  = "canceled"
+	AuthenticationExpired   = "expired"
+)
+
+// Authentication is a payment made without the customer present that their
+// bank wants them to authenticate (3D Secure). They are emailed a link to do
+// so, and the payment is canceled if they haven't by ExpiresAt. Payments of
+// InvoiceID are authenticated on the hosted invoice page instead, and left to
+// Stripe's retries when they expire. Failures counts the authentications the
+// bank turned down.
+type Authentication struct {
+	PaymentIntentID string    `json:"paymentIntentId"`
+	InvoiceID       string    `json:"invoiceId,omitempty"`
+	CustomerID      string    `json:"customerId,omitempty"`
+	Email           string    `json:"email,omitempty"`
+	Locale          string    `json:"locale,omitempty"`
+	Amount          int64     `json:"amount"`
+	Currency        string    `json:"currency"`
+	Status          string    `json:"status"`
+	Failures        int       `json:"failures"`
+	ExpiresAt       time.Time `json:"expiresAt"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// RequestAuthentication records that a's payment waits for the customer to
+// authenticate, emails them email and schedules the payment's expiry. A
+// payment already waiting is left alone, so the response to an off-session
+// charge and the webhooks about it email the customer once, unless its
+// expiry was never scheduled: recording it and queueing its jobs don't
+// happen together, so the jobs of a payment whose queueing failed are
+// queued when it is next requested. It reports whether a was recorded.
+func (svc *Service) RequestAuthentication(ctx context.Context, a *Authentication, email *AuthenticationRequest) (bool, error) {
+	a.Status = AuthenticationPending
+	a.ExpiresAt = time.Now().Add(svc.Config.AuthenticationWindow).UTC()
+	created, err := svc.Payments.CreateAuthentication(ctx, a)
+	if err != nil {
+		return false, err
+	}
+	if !created {
+		existing, err := svc.PendingAuthentication(ctx, a.PaymentIntentID)
+		if err != nil || existing == nil || svc.Jobs.Queued(expireAuthenticationJob(a.PaymentIntentID)) {
+			return false, err
+		}
+		LogCtx(ctx).Warn("queueing the jobs of an authentication again", "payment_intent", a.PaymentIntentID)
+		a = existing
+	}
+	// The email goes first: once the expiry is queued, both are.
+	if email != nil && email.Email != "" {
+		email.PaymentIntentID, email.ExpiresAt = a.PaymentIntentID, a.ExpiresAt
+		if err := svc.Jobs.EnqueueNamed(ctx, "authentication_email:"+a.PaymentIntentID, JobSendAuthenticationEmail, email, time.Now()); err != nil {
+			return created, err
+		}
+	}
+	return created, svc.Jobs.EnqueueNamed(ctx, expireAuthenticationJob(a.PaymentIntentID), JobExpireAuthentication, a.PaymentIntentID, a.ExpiresAt)
+}
+
+// expireAuthenticationJob names the job expiring the authentication of
+// paymentIntentID.
+func expireAuthenticationJob(paymentIntentID string) string {
+	return "expire_authentication:" + paymentIntentID
+}
+
+// PendingAuthentication returns the authentication the payment intent waits
+// for, or nil.
+func (svc *Service) PendingAuthentication(ctx context.Context, paymentIntentID string) (*Authentication, error) {
+	a, err := svc.Payments.GetAuthentication(ctx, paymentIntentID)
+	if errors.Is(err, ErrAuthenticationNotFound) {
+		return nil, nil
+	}
+	if err != nil || a.Status != AuthenticationPending {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ExpireAuthentication gives up on an authentication the customer didn't
+// complete within AUTHENTICATION_WINDOW. Off-session charges are canceled
+// as abandoned, which payment_intent.canceled then records on the payment.
+// A payment that succeeded or was canceled meanwhile is left as it is.
+func (svc *Service) ExpireAuthentication(ctx context.Context, paymentIntentID string) error {
+	a, err := svc.PendingAuthentication(ctx, paymentIntentID)
+	if err != nil || a == nil {
+		return err
+	}
+	if a.InvoiceID == "" {
+		params := &stripe.PaymentIntentCancelParams{CancellationReason: stripe.String(string(stripe.PaymentIntentCancellationReasonAbandoned))}
+		params.SetIdempotencyKey("expire_authentication:" + paymentIntentID)
+		_, err := svc.Stripe.CancelPaymentIntent(ctx, paymentIntentID, params)
+		var se *stripe.Error
+		if errors.As(err, &se) && se.HTTPStatusCode == http.StatusBadRequest {
+			slog.Info("authentication expired after the payment moved on", "payment_intent", paymentIntentID, "error", se.Msg)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("canceling payment intent: %w", err)
+		}
+	}
+	a.Status = AuthenticationExpired
+	slog.Info("authentication expired", "payment_intent", paymentIntentID, "invoice", a.InvoiceID)
+	return svc.Payments.UpdateAuthentication(ctx, a)
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// Cache keeps Stripe objects that are read far more often than they change,
+// so /config and the success page don't call Stripe on every request.
+// Values are JSON so they can live outside the process.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// cached returns the value cached in cache under key, or calls fetch and
+// caches its result for ttl. The cache is only an optimization: when it
+// fails, the value is fetched from Stripe as if nothing was cached.
+func cached[T any](ctx context.Context, cache Cache, key string, ttl time.Duration, fetch func() (*T, error)) (*T, error) {
+	if data, ok, err := cache.Get(ctx, key); err != nil {
+		slog.Warn("reading cache", "key", key, "error", err)
+	} else if ok {
+		var v T
+		if err := json.Unmarshal(data, &v); err == nil {
+			return &v, nil
+		}
+		slog.Warn("decoding cached value", "key", key, "error", err)
+	}
+	v, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(v); err != nil {
+		slog.Warn("encoding cached value", "key", key, "error", err)
+	} else if err := cache.Set(ctx, key, data, ttl); err != nil {
+		slog.Warn("writing cache", "key", key, "error", err)
+	}
+	return v, nil
+}
+
+// CachedPrice fetches a price with its currency options for up to
+// CACHE_PRICE_TTL.
+func (svc *Service) CachedPrice(ctx context.Context, id string) (*stripe.Price, error) {
+	return cached(ctx, svc.Cache, "price:"+id, svc.Config.CachePriceTTL, func() (*stripe.Price, error) {
+		params := &stripe.PriceParams{}
+		params.AddExpand("currency_options")
+		return svc.Stripe.GetPrice(ctx, id, params)
+	})
+}
+
+// CachedCheckoutSession fetches a checkout session for up to
+// CACHE_SESSION_TTL. Webhooks about the session drop it from the cache so a
+// completed payment shows up right away.
+func (svc *Service) CachedCheckoutSession(ctx context.Context, id string) (*stripe.CheckoutSession, error) {
+	return cached(ctx, svc.Cache, "session:"+id, svc.Config.CacheSessionTTL, func() (*stripe.CheckoutSession, error) {
+		params := &stripe.CheckoutSessionParams{}
+		return svc.Stripe.GetCheckoutSession(ctx, id, params)
+	})
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"errors"
+)
+
+// captureMethods are the capture_method values a checkout may ask for.
+// Manual capture only authorizes the card at checkout; the money moves when
+// the payment is captured, e.g. on shipment.
+var captureMethods = map[string]bool{
+	"":          true,
+	"automatic": true,
+	"manual":    true,
+}
+
+// CancelPaymentRequest is the optional body of POST /payments/{id}/cancel.
+type CancelPaymentRequest struct {
+	Reason string `json:"reason"`
+}
+
+// CapturePaymentRequest is the optional body of POST /payments/{id}/capture.
+// Amount captures less than was authorized, releasing the rest, or more
+// where the card allows overcapture. Zero captures the whole authorization.
+type CapturePaymentRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+func (req *CapturePaymentRequest) Validate(ctx context.Context, svc *Service) error {
+	if req.Amount < 0 {
+		return errors.New("amount must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// CartMetadataKey is the session metadata key that links a session back to
+// the saved cart it was created from. Clients can't set it.
+const CartMetadataKey = "cart"
+
+// Cart statuses. A cart stays open through any number of expired sessions
+// and is completed by the first one that is.
+const (
+	CartOpen      = "open"
+	CartCompleted = "completed"
+)
+
+// Cart is a checkout request saved with POST /carts so the customer can pay
+// for it later, or again after the session they were sent to expired.
+// SessionID is the latest session created for it and SessionStatus that
+// session's status as the webhooks report it: open, expired, complete or
+// payment_failed.
+type Cart struct {
+	ID            string                `json:"id"`
+	Status        string                `json:"status"`
+	Checkout      CreateCheckoutRequest `json:"checkout"`
+	SessionID     string                `json:"sessionId,omitempty"`
+	SessionStatus string                `json:"sessionStatus,omitempty"`
+	SessionURL    string                `json:"sessionUrl,omitempty"`
+	OrderID       string                `json:"orderId,omitempty"`
+	CreatedAt     time.Time             `json:"createdAt"`
+	UpdatedAt     time.Time             `json:"updatedAt"`
+}
+
+// CartCheckoutRequest is the optional body of POST /carts/{id}/checkout.
+// StoreCredit is the code of a store credit that pays for part of a new
+// session; carts can't keep one.
+type CartCheckoutRequest struct {
+	StoreCredit string `json:"storeCredit"`
+}
+
+// SaveCart saves req, which has been validated, as a new open cart. Store
+// credit codes are only stored hashed, so a cart can't carry one; the code
+// is given when the cart is checked out.
+func (svc *Service) SaveCart(ctx context.Context, req *CreateCheckoutRequest) (*Cart, error) {
+	if req.StoreCredit != "" {
+		return nil, BadRequest(errors.New("storeCredit can't be saved with a cart; pass it to checkout"))
+	}
+	cart := &Cart{ID: "cart_" + NewRequestID(), Status: CartOpen, Checkout: *req}
+	// The customer was authorized when the cart was saved.
+	cart.Checkout.CustomerToken = ""
+	if err := svc.Payments.SaveCart(ctx, cart); err != nil {
+		return nil, InternalError("saving cart", err)
+	}
+	return cart, nil
+}
+
+// GetCart returns the saved cart id.
+func (svc *Service) GetCart(ctx context.Context, id string) (*Cart, error) {
+	cart, err := svc.Payments.GetCart(ctx, id)
+	if err == ErrCartNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, InternalError("fetching cart", err)
+	}
+	// Carts saved before codes were refused may still hold one.
+	cart.Checkout.StoreCredit = ""
+	return cart, nil
+}
+
+// CheckoutCart sends the customer to the cart's open session, or creates a
+// new one when it has none or the last one expired. The prices and stock
+// are checked again for the new session, as they may have changed since
+// the cart was saved. storeCredit is the code of a store credit that pays
+// for part of a new session.
+func (svc *Service) CheckoutCart(ctx context.Context, id, storeCredit, acceptLanguage string) (*CreateCheckoutResponse, error) {
+	defer svc.cartLocks.Lock(id)()
+	cart, err := svc.Payments.GetCart(ctx, id)
+	if err == ErrCartNotFound {
+		return nil, &Error{Status: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return nil, InternalError("fetching cart", err)
+	}
+	if cart.Status == CartCompleted {
+		return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("cart %s has been checked out", cart.ID)}
+	}
+	if cart.SessionStatus == string(stripe.CheckoutSessionStatusOpen) {
+		// The expired webhook may not have arrived yet.
+		s, err := svc.CachedCheckoutSession(ctx, cart.SessionID)
+		if err != nil {
+			return nil, &StripeFailure{"fetching session", err}
+		}
+		switch s.Status {
+		case stripe.CheckoutSessionStatusOpen:
+			return &CreateCheckoutResponse{ID: cart.SessionID, URL: cart.SessionURL, OrderID: cart.OrderID, StatusURL: svc.OrderStatusURL(cart.OrderID), ExpiresAt: SessionExpiry(s)}, nil
+		case stripe.CheckoutSessionStatusComplete:
+			return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("cart %s has been checked out", cart.ID)}
+		}
+	}
+
+	req := cart.Checkout
+	req.StoreCredit = storeCredit
+	if err := req.Validate(ctx, svc); err != nil {
+		return nil, BadRequest(err)
+	}
+	req.Metadata = maps.Clone(req.Metadata)
+	if req.Metadata == nil {
+		req.Metadata = map[string]string{}
+	}
+	req.Metadata[CartMetadataKey] = cart.ID
+	resp, err := svc.CreateCheckout(ctx, &req, acceptLanguage)
+	if err != nil {
+		return nil, err
+	}
+	// The session exists; record it even if the client has gone away.
+	ctx = context.WithoutCancel(ctx)
+	cart.SessionID, cart.SessionURL, cart.OrderID = resp.ID, resp.URL, resp.OrderID
+	cart.SessionStatus = string(stripe.CheckoutSessionStatusOpen)
+	if err := svc.Payments.SaveCart(ctx, cart); err != nil {
+		LogCtx(ctx).Error("linking cart", "cart", cart.ID, "session", resp.ID, "error", err)
+	}
+	return resp, nil
+}
+
+// LockSessionCart decodes the session of event and locks and loads the
+// saved cart it was created from. The cart is nil when it has none.
+func (svc *Service) LockSessionCart(ctx context.Context, event stripe.Event) (cart *Cart, s *stripe.CheckoutSession, unlock func(), err error) {
+	s = &stripe.CheckoutSession{}
+	if err := json.Unmarshal(event.Data.Raw, s); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse session object: %w", err)
+	}
+	id := s.Metadata[CartMetadataKey]
+	if id == "" {
+		return nil, s, func() {}, nil
+	}
+	unlock = svc.cartLocks.Lock(id)
+	cart, err = svc.Payments.GetCart(ctx, id)
+	if err == ErrCartNotFound {
+		return nil, s, unlock, nil
+	}
+	if err != nil {
+		unlock()
+		return nil, nil, nil, err
+	}
+	return cart, s, unlock, nil
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/stripeclient"
+)
+
+// CatalogPrice is the storefront view of an active Stripe Price.
+type CatalogPrice struct {
+	ID         string           `json:"id"`
+	Product    string           `json:"product"`
+	Nickname   string           `json:"nickname,omitempty"`
+	UnitAmount int64            `json:"unitAmount"`
+	Currency   string           `json:"currency"`
+	Recurring  *CatalogInterval `json:"recurring,omitempty"`
+	// CurrencyOptions holds the unit amounts in the price's other currencies.
+	CurrencyOptions map[string]int64 `json:"currencyOptions,omitempty"`
+	// TiersMode is graduated or volume for prices billed in tiers, which
+	// have no UnitAmount of their own.
+	TiersMode string         `json:"tiersMode,omitempty"`
+	Tiers     []*CatalogTier `json:"tiers,omitempty"`
+
+	price *stripe.Price
+}
+
+// CatalogTier is one tier of a tiered price. UpTo is the last quantity in
+// the tier; it is 0 for the last tier, which has no upper bound.
+type CatalogTier struct {
+	UpTo       int64 `json:"upTo,omitempty"`
+	UnitAmount int64 `json:"unitAmount"`
+	FlatAmount int64 `json:"flatAmount,omitempty"`
+}
+
+// CatalogInterval describes the billing period of a recurring price.
+type CatalogInterval struct {
+	Interval      string `json:"interval"`
+	IntervalCount int64  `json:"intervalCount"`
+}
+
+// CatalogProduct is an active Stripe Product with its active prices.
+type CatalogProduct struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Images      []string        `json:"images,omitempty"`
+	Prices      []*CatalogPrice `json:"prices"`
+}
+
+// Catalog caches the active products and prices of the Stripe account.
+// Only prices in the catalog can be bought, so callers can't check out with
+// arbitrary prices.
+type Catalog struct {
+	mu       sync.RWMutex
+	products []*CatalogProduct
+	prices   map[string]*CatalogPrice
+	loadedAt time.Time
+}
+
+// NewCatalog returns a catalog of products and their prices. Load replaces
+// them with the account's.
+func NewCatalog(products ...*CatalogProduct) *Catalog {
+	c := &Catalog{products: products, prices: map[string]*CatalogPrice{}}
+	for _, p := range products {
+		for _, price := range p.Prices {
+			c.prices[price.ID] = price
+		}
+	}
+	return c
+}
+
+// Load fetches the active products and prices from Stripe through client
+// and replaces the cached catalog.
+func (c *Catalog) Load(ctx context.Context, client stripeclient.Client) error {
+	products := []*CatalogProduct{}
+	byID := map[string]*CatalogProduct{}
+	productParams := &stripe.ProductListParams{Active: stripe.Bool(true)}
+	productParams.Filters.AddFilter("limit", "", "100")
+	productList, err := client.ListProducts(ctx, productParams)
+	if err != nil {
+		return fmt.Errorf("listing products: %w", err)
+	}
+	for _, p := range productList {
+		cp := &CatalogProduct{
+			ID:          p.ID,
+			Name:        p.Name,
+			Description: p.Description,
+			Images:      p.Images,
+			Prices:      []*CatalogPrice{},
+		}
+		products = append(products, cp)
+		byID[p.ID] = cp
+	}
+
+	prices := map[string]*CatalogPrice{}
+	priceParams := &stripe.PriceListParams{Active: stripe.Bool(true)}
+	priceParams.Filters.AddFilter("limit", "", "100")
+	priceParams.AddExpand("data.currency_options")
+	priceParams.AddExpand("data.tiers")
+	priceList, err := client.ListPrices(ctx, priceParams)
+	if err != nil {
+		return fmt.Errorf("listing prices: %w", err)
+	}
+	for _, p := range priceList {
+		if p.Product == nil || byID[p.Product.ID] == nil {
+			continue
+		}
+		cp := CatalogPriceFromStripe(p)
+		prices[p.ID] = cp
+		byID[p.Product.ID].Prices = append(byID[p.Product.ID].Prices, cp)
+	}
+
+	c.mu.Lock()
+	c.products = products
+	c.prices = prices
+	c.loadedAt = time.Now()
+	c.mu.Unlock()
+	slog.Info("catalog loaded", "products", len(products), "prices", len(prices))
+	return nil
+}
+
+// CatalogPriceFromStripe converts a Stripe Price fetched with currency_options and
+// tiers expanded.
+func CatalogPriceFromStripe(p *stripe.Price) *CatalogPrice {
+	cp := &CatalogPrice{
+		ID:         p.ID,
+		Nickname:   p.Nickname,
+		UnitAmount: p.UnitAmount,
+		Currency:   string(p.Currency),
+		price:      p,
+	}
+	if p.Product != nil {
+		cp.Product = p.Product.ID
+	}
+	if p.Recurring != nil {
+		cp.Recurring = &CatalogInterval{
+			Interval:      string(p.Recurring.Interval),
+			IntervalCount: p.Recurring.IntervalCount,
+		}
+	}
+	if p.BillingScheme == stripe.PriceBillingSchemeTiered {
+		cp.TiersMode = string(p.TiersMode)
+		for _, t := range p.Tiers {
+			cp.Tiers = append(cp.Tiers, &CatalogTier{UpTo: t.UpTo, UnitAmount: t.UnitAmount, FlatAmount: t.FlatAmount})
+		}
+	}
+	for currency, o := range p.CurrencyOptions {
+		if currency == cp.Currency || o == nil {
+			continue
+		}
+		if cp.CurrencyOptions == nil {
+			cp.CurrencyOptions = map[string]int64{}
+		}
+		cp.CurrencyOptions[currency] = o.UnitAmount
+	}
+	return cp
+}
+
+// supports reports whether the price can be charged in currency.
+func (p *CatalogPrice) supports(currency string) bool {
+	_, ok := p.CurrencyOptions[currency]
+	return currency == p.Currency || ok
+}
+
+// AmountIn returns the unit amount in currency, falling back to the
+// price's default currency when it has no option for it.
+func (p *CatalogPrice) AmountIn(currency string) (int64, string) {
+	if amount, ok := p.CurrencyOptions[currency]; ok {
+		return amount, currency
+	}
+	return p.UnitAmount, p.Currency
+}
+
+// lineAmount returns what a line of quantity units costs when the cart is
+// charged in currency, and the currency it is charged in. As on receipts,
+// tiered prices are only tiered in their default currency.
+func (p *CatalogPrice) lineAmount(quantity int64, currency string) (int64, string) {
+	if len(p.Tiers) > 0 {
+		return p.AmountFor(quantity), p.Currency
+	}
+	unit, c := p.AmountIn(currency)
+	return unit * quantity, c
+}
+
+// AmountFor returns what quantity units of the price cost in its default
+// currency. Graduated tiers charge each unit at the rate of the tier it
+// falls in; volume tiers charge every unit at the rate of the tier the
+// whole quantity falls in. A tier's flat amount is added once when any
+// units are in it.
+func (p *CatalogPrice) AmountFor(quantity int64) int64 {
+	if len(p.Tiers) == 0 {
+		return p.UnitAmount * quantity
+	}
+	var amount, below int64
+	for _, t := range p.Tiers {
+		last := t.UpTo == 0 || quantity <= t.UpTo
+		if p.TiersMode == string(stripe.PriceTiersModeVolume) {
+			if last {
+				return t.UnitAmount*quantity + t.FlatAmount
+			}
+			continue
+		}
+		units := quantity - below
+		if !last {
+			units = t.UpTo - below
+		}
+		if units > 0 {
+			amount += t.UnitAmount*units + t.FlatAmount
+		}
+		if last {
+			break
+		}
+		below = t.UpTo
+	}
+	return amount
+}
+
+// replace swaps in the products and prices of next in one step, so no
+// request sees a mix of both.
+func (c *Catalog) replace(next *Catalog) {
+	next.mu.RLock()
+	defer next.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.products, c.prices, c.loadedAt = next.products, next.prices, next.loadedAt
+}
+
+// Price returns the catalog entry for a Price ID.
+func (c *Catalog) Price(id string) (*CatalogPrice, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.prices[id]
+	return p, ok
+}
+
+// ProductName returns the name of a cached product, or "" if it isn't in
+// the catalog.
+func (c *Catalog) ProductName(id string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, p := range c.products {
+		if p.ID == id {
+			return p.Name
+		}
+	}
+	return ""
+}
+
+// Products returns the cached products.
+func (c *Catalog) Products() []*CatalogProduct {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.products
+}
+
+// refreshEvery reloads the catalog on the given interval until ctx is done.
+// age is how long ago the catalog was loaded.
+func (c *Catalog) age() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return time.Since(c.loadedAt)
+}
+
+func (c *Catalog) refreshEvery(ctx context.Context, client stripeclient.Client, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := c.Load(ctx, client); err != nil {
+				slog.Error("refreshing catalog", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import "testing"
+
+func TestCatalogPriceAmountFor(t *testing.T) {
+	tiers := []*CatalogTier{{UpTo: 5, UnitAmount: 1000, FlatAmount: 500}, {UpTo: 10, UnitAmount: 800}, {UnitAmount: 500}}
+	graduated := &CatalogPrice{TiersMode: "graduated", Tiers: tiers}
+	volume := &CatalogPrice{TiersMode: "volume", Tiers: tiers}
+	perUnit := &CatalogPrice{UnitAmount: 1500}
+	for _, tt := range []struct {
+		price    *CatalogPrice
+		quantity int64
+		want     int64
+	}{
+		{perUnit, 3, 4500},
+		{graduated, 1, 1500},
+		{graduated, 5, 5500},
+		{graduated, 7, 5500 + 2*800},
+		{graduated, 12, 5500 + 5*800 + 2*500},
+		{volume, 3, 3500},
+		{volume, 5, 5500},
+		{volume, 7, 5600},
+		{volume, 12, 6000},
+	} {
+		if got := tt.price.AmountFor(tt.quantity); got != tt.want {
+			t.Errorf("%s amountFor(%d) = %d, want %d", tt.price.TiersMode, tt.quantity, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/stripeclient"
+)
+
+// CheckoutItem is a single line of a checkout request.
+type CheckoutItem struct {
+	Price    string `json:"price"`
+	Quantity int64  `json:"quantity"`
+}
+
+// CreateCheckoutRequest is the body accepted by /create-checkout-session.
+// Customer is an optional Stripe Customer ID to attach the session to; Email
+// instead prefills the Checkout page for a guest and is where the
+// confirmation email goes. Coupon
+// (an ID) or PromotionCode (the code customers type) apply a discount.
+// Seller is the connected account that receives the funds in a marketplace
+// sale, minus the platform's application fee. Metadata (e.g. an order ID) is
+// copied to the session and its payment intent and stored with the payment.
+// SuccessURL and CancelURL override the default return pages; without a
+// SuccessURL, a SUCCESS_PAGES page shared by every item is used. Currency picks
+// one of the prices' currency options; when it is empty it is inferred from
+// Accept-Language, and an unsupported currency falls back to the prices'
+// default. Locale is the language of the Checkout page, the return pages and
+// the receipt, one of CheckoutLocales or "auto"; it too is inferred from
+// Accept-Language when empty. PaymentMethodTypes overrides PAYMENT_METHOD_TYPES for the session.
+// CaptureMethod "manual" only authorizes the payment; it is captured later
+// with POST /payments/{id}/capture. StoreCredit is the code of a store credit
+// that pays for part of the cart. CustomerToken is the portal token of
+// Customer, which callers other than admins need to check out as them.
+type CreateCheckoutRequest struct {
+	Items         []CheckoutItem    `json:"items"`
+	Customer      string            `json:"customer"`
+	Email         string            `json:"email"`
+	Coupon        string            `json:"coupon"`
+	PromotionCode string            `json:"promotionCode"`
+	Seller        string            `json:"seller"`
+	Metadata      map[string]string `json:"metadata"`
+	SuccessURL    string            `json:"successUrl"`
+	CancelURL     string            `json:"cancelUrl"`
+	Currency      string            `json:"currency"`
+	Locale        string            `json:"locale"`
+	CaptureMethod string            `json:"captureMethod"`
+	StoreCredit   string            `json:"storeCredit"`
+	CustomerToken string            `json:"customerToken"`
+
+	PaymentMethodTypes []string `json:"paymentMethodTypes"`
+}
+
+// Validate checks the request against the catalog.
+func (c *CreateCheckoutRequest) Validate(ctx context.Context, svc *Service) error {
+	if len(c.Items) == 0 {
+		return errors.New("cart is empty")
+	}
+	if c.Customer != "" {
+		if err := ValidateStripeID(c.Customer, "cus_", "customer"); err != nil {
+			return err
+		}
+	}
+	if c.Email != "" {
+		// Stripe takes the email of an existing customer from the customer.
+		if c.Customer != "" {
+			return errors.New("email can't be combined with customer")
+		}
+		if err := config.ValidateEmail(c.Email); err != nil {
+			return err
+		}
+	}
+	currency, err := NormalizeCurrency(c.Currency)
+	if err != nil {
+		return err
+	}
+	c.Currency = currency
+	if c.Locale, err = NormalizeLocale(c.Locale); err != nil {
+		return err
+	}
+	if err := svc.validatePaymentMethodTypes(c.PaymentMethodTypes); err != nil {
+		return err
+	}
+	if !captureMethods[c.CaptureMethod] {
+		return fmt.Errorf("invalid captureMethod %q", c.CaptureMethod)
+	}
+	if stripeclient.AccountFrom(ctx) != "" {
+		switch {
+		case c.Seller != "":
+			return errors.New("seller can't be used with " + StripeAccountHeader)
+		case c.StoreCredit != "":
+			return errors.New("storeCredit can't be used with " + StripeAccountHeader)
+		}
+	}
+	if c.StoreCredit != "" {
+		// The credit is spread over the lines, which coupons, application
+		// fees and later captures would all count differently.
+		switch {
+		case c.Coupon != "" || c.PromotionCode != "":
+			return errors.New("storeCredit can't be combined with a coupon or promotion code")
+		case c.Seller != "":
+			return errors.New("storeCredit can't be used for marketplace sales")
+		case c.CaptureMethod == "manual":
+			return errors.New("storeCredit can't be combined with manual capture")
+		}
+		if _, err := storeCreditCodeHash(c.StoreCredit); err != nil {
+			return err
+		}
+	}
+	// The bounds of each price and the cart apply to the merged line items
+	// and are checked by CreateCheckout.
+	for _, item := range c.Items {
+		if _, ok := svc.CatalogFor(ctx).Price(item.Price); !ok {
+			return fmt.Errorf("unknown price %q", item.Price)
+		}
+		if item.Quantity < 1 {
+			return fmt.Errorf("price %q: quantity must be at least 1", item.Price)
+		}
+	}
+	for _, key := range []string{OrderMetadataKey, DonationMetadataKey, SummaryMetadataKey, CartMetadataKey, RecoveryMetadataKey, TraceMetadataKey, StoreCreditMetadataKey, stripeReceiptMetadataKey} {
+		if _, ok := c.Metadata[key]; ok {
+			return fmt.Errorf("metadata key %q is reserved", key)
+		}
+	}
+	return validateMetadata(c.Metadata)
+}
+
+// lineItems converts the items into Checkout line items. Repeated prices are
+// merged into a single line.
+func (c *CreateCheckoutRequest) lineItems() []*stripe.CheckoutSessionLineItemParams {
+	var items []*stripe.CheckoutSessionLineItemParams
+	byPrice := map[string]*stripe.CheckoutSessionLineItemParams{}
+	for _, item := range c.Items {
+		if li, ok := byPrice[item.Price]; ok {
+			*li.Quantity += item.Quantity
+			continue
+		}
+		li := &stripe.CheckoutSessionLineItemParams{
+			Price:    stripe.String(item.Price),
+			Quantity: stripe.Int64(item.Quantity),
+		}
+		byPrice[item.Price] = li
+		items = append(items, li)
+	}
+	return items
+}
+
+// allowQuantityChanges lets customers change the quantity of each line on
+// the Checkout page when ADJUSTABLE_QUANTITY is set, within the price's
+// limits. Lines of prices with tracked stock can't go above the quantity
+// reserved for them, so those can only be lowered.
+func (svc *Service) allowQuantityChanges(ctx context.Context, items []*stripe.CheckoutSessionLineItemParams, limits map[string]config.PriceLimit, reserved bool) error {
+	if !svc.Config.AdjustableQuantity {
+		return nil
+	}
+	tracked := map[string]bool{}
+	if reserved {
+		list, err := svc.Payments.ListInventory(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range list {
+			tracked[item.Price] = true
+		}
+	}
+	for _, li := range items {
+		quantity := stripe.Int64Value(li.Quantity)
+		l := svc.PriceLimit(limits, stripe.StringValue(li.Price))
+		lo, hi := max(svc.Config.AdjustableQuantityMin, l.MinQuantity), min(svc.Config.AdjustableQuantityMax, l.MaxQuantity)
+		// Stripe rejects bounds that exclude the starting quantity.
+		if quantity < lo {
+			lo = quantity
+		}
+		if quantity > hi || tracked[stripe.StringValue(li.Price)] {
+			hi = quantity
+		}
+		li.AdjustableQuantity = &stripe.CheckoutSessionLineItemAdjustableQuantityParams{
+			Enabled: stripe.Bool(true),
+			Minimum: stripe.Int64(lo),
+			Maximum: stripe.Int64(hi),
+		}
+	}
+	return nil
+}
+
+// ExpandLineItems fills in s.LineItems, which webhook payloads leave out,
+// so handlers can read the quantities the customer settled on rather than
+// the ones the session was created with. It does nothing unless
+// ADJUSTABLE_QUANTITY is set, as the quantities can't change otherwise; nor
+// can they in sessions paid partly with store credit, whose lines are
+// charged as price_data.
+func (svc *Service) ExpandLineItems(ctx context.Context, s *stripe.CheckoutSession) error {
+	if !svc.Config.AdjustableQuantity || s.Metadata[StoreCreditMetadataKey] != "" || (s.LineItems != nil && len(s.LineItems.Data) > 0) {
+		return nil
+	}
+	items, err := svc.Stripe.ListCheckoutSessionLineItems(ctx, s.ID, &stripe.CheckoutSessionListLineItemsParams{})
+	if err != nil {
+		return fmt.Errorf("listing line items of %s: %w", s.ID, err)
+	}
+	s.LineItems = &stripe.LineItemList{Data: items}
+	return nil
+}
+
+// prices returns the catalog entries of the items.
+func (c *CreateCheckoutRequest) prices(ctx context.Context, svc *Service) []*CatalogPrice {
+	var prices []*CatalogPrice
+	for _, item := range c.Items {
+		if p, ok := svc.CatalogFor(ctx).Price(item.Price); ok {
+			prices = append(prices, p)
+		}
+	}
+	return prices
+}
+
+// CreateCheckoutResponse is returned to JSON clients of
+// /create-checkout-session instead of a redirect. OrderID is the order the
+// session pays for; donations have none. StatusURL follows the order when
+// order status links are enabled. ExpiresAt is when the session stops
+// accepting payment. StoreCreditApplied is how much store credit the
+// session is charged less.
+type CreateCheckoutResponse struct {
+	ID                 string     `json:"id"`
+	URL                string     `json:"url"`
+	OrderID            string     `json:"orderId,omitempty"`
+	StatusURL          string     `json:"statusUrl,omitempty"`
+	ExpiresAt          *time.Time `json:"expiresAt,omitempty"`
+	StoreCreditApplied int64      `json:"storeCreditApplied,omitempty"`
+}
+
+// CreateCheckout creates the order and the checkout session for a validated
+// cart. acceptLanguage picks the currency and locale when the request
+// doesn't.
+func (svc *Service) CreateCheckout(ctx context.Context, req *CreateCheckoutRequest, acceptLanguage string) (*CreateCheckoutResponse, error) {
+	discounts, err := svc.discountParams(ctx, req.Coupon, req.PromotionCode)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+	successURL := req.SuccessURL
+	if successURL == "" {
+		successURL = svc.productSuccessPage(req.prices(ctx, svc))
+	}
+	successURL, cancelURL, err := svc.CheckoutReturnURLs(successURL, req.CancelURL)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		SuccessURL: stripe.String(successURL),
+		CancelURL:  stripe.String(cancelURL),
+		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
+		LineItems:  req.lineItems(),
+	}
+	if req.Customer != "" {
+		params.Customer = stripe.String(req.Customer)
+	}
+	if req.Email != "" {
+		params.CustomerEmail = stripe.String(req.Email)
+	}
+	methods := req.PaymentMethodTypes
+	if len(methods) == 0 {
+		methods = svc.Config.PaymentMethodTypes
+	}
+	if len(methods) > 0 {
+		params.PaymentMethodTypes = stripe.StringSlice(methods)
+	}
+	currency := ChooseCurrency(req.prices(ctx, svc), PreferredCurrencies(acceptLanguage, req.Currency))
+	if currency != "" {
+		params.Currency = stripe.String(currency)
+	}
+	limits, err := svc.PriceLimits(ctx)
+	if err != nil {
+		return nil, InternalError("reading price limits", err)
+	}
+	if err := svc.checkPurchaseLimits(ctx, limits, params.LineItems, currency); err != nil {
+		return nil, BadRequest(err)
+	}
+	var credit *StoreCredit
+	if req.StoreCredit != "" {
+		if credit, err = svc.findStoreCredit(ctx, req.StoreCredit); err != nil {
+			return nil, err
+		}
+	}
+	svc.addShipping(params, req.prices(ctx, svc))
+	order := NewOrder(params.LineItems, req.Metadata)
+	order.Email = req.Email
+	params.SuccessURL = stripe.String(svc.WithOrderStatus(successURL, order.ID))
+	WithCheckoutLocale(params, CheckoutLocale(acceptLanguage, req.Locale))
+	metadata := map[string]string{OrderMetadataKey: order.ID}
+	for k, v := range req.Metadata {
+		metadata[k] = v
+	}
+	for k, v := range metadata {
+		params.AddMetadata(k, v)
+	}
+	params.PaymentIntentData = &stripe.CheckoutSessionPaymentIntentDataParams{Metadata: metadata}
+	if req.CaptureMethod != "" {
+		params.PaymentIntentData.CaptureMethod = stripe.String(req.CaptureMethod)
+	}
+	svc.withReceiptEmail(params, req.Email)
+	if req.CaptureMethod == "manual" && svc.Config.Overcapture {
+		// stripe-go has no field for it yet.
+		params.AddExtra("payment_method_options[card][request_overcapture]", "if_available")
+	}
+	if req.Seller != "" {
+		seller, err := svc.sellerAccount(ctx, req.Seller)
+		if err != nil {
+			return nil, BadRequest(err)
+		}
+		params.PaymentIntentData.ApplicationFeeAmount = stripe.Int64(svc.ApplicationFee(ctx, params.LineItems, currency))
+		params.PaymentIntentData.TransferData = &stripe.CheckoutSessionPaymentIntentDataTransferDataParams{
+			Destination: stripe.String(seller.ID),
+		}
+	}
+	// Stripe rejects sessions that set both.
+	if len(discounts) > 0 {
+		params.Discounts = discounts
+	} else if svc.Config.AllowPromotionCodes {
+		params.AllowPromotionCodes = stripe.Bool(true)
+	}
+	if svc.Config.TaxIDCollection {
+		params.TaxIDCollection = &stripe.CheckoutSessionTaxIDCollectionParams{Enabled: stripe.Bool(true)}
+	}
+	if err := svc.Payments.SaveOrder(ctx, order); err != nil {
+		return nil, InternalError("saving order", err)
+	}
+	reservation, expiresAt, err := svc.reserveStock(ctx, params.LineItems)
+	var outOfStock *OutOfStockError
+	if errors.As(err, &outOfStock) {
+		svc.CancelOrder(ctx, order)
+		return nil, &Error{Status: http.StatusConflict, Message: err.Error()}
+	}
+	if err != nil {
+		svc.CancelOrder(ctx, order)
+		return nil, InternalError("reserving stock", err)
+	}
+	// The application fee of a marketplace sale is fixed when the session is
+	// created, so its quantities are too, as are those of lines reduced by
+	// store credit.
+	if req.Seller == "" && credit == nil {
+		if err := svc.allowQuantityChanges(ctx, params.LineItems, limits, reservation != ""); err != nil {
+			if err := svc.Payments.ReleaseReservation(context.WithoutCancel(ctx), reservation); err != nil {
+				LogCtx(ctx).Error("releasing reservation", "reservation", reservation, "error", err)
+			}
+			svc.CancelOrder(ctx, order)
+			return nil, InternalError("reading inventory", err)
+		}
+	}
+	var creditApplied int64
+	if credit != nil {
+		if creditApplied, err = svc.applyStoreCredit(ctx, params, order, credit, currency); err != nil {
+			if err := svc.Payments.ReleaseReservation(context.WithoutCancel(ctx), reservation); err != nil {
+				LogCtx(ctx).Error("releasing reservation", "reservation", reservation, "error", err)
+			}
+			svc.CancelOrder(ctx, order)
+			return nil, err
+		}
+	}
+	// Stock is only held for INVENTORY_RESERVATION_TTL, so a session holding
+	// some can't outlive it.
+	if sessionExpiresAt := time.Now().Add(svc.Config.CheckoutSessionTTL); reservation == "" || sessionExpiresAt.Before(expiresAt) {
+		expiresAt = sessionExpiresAt
+	}
+	params.ExpiresAt = stripe.Int64(expiresAt.Unix())
+	// A recovered session would repeat the reduced lines after the credit
+	// was released.
+	if creditApplied == 0 {
+		svc.WithCheckoutRecovery(params)
+	}
+	svc.WithSessionSummary(params)
+	WithTraceContext(ctx, params)
+	// A retry by the Stripe client can't create a second session for the
+	// order.
+	params.SetIdempotencyKey("checkout_session:" + order.ID)
+	s, err := svc.Stripe.NewCheckoutSession(ctx, params)
+	if err != nil {
+		if reservation != "" {
+			if err := svc.Payments.ReleaseReservation(context.WithoutCancel(ctx), reservation); err != nil {
+				LogCtx(ctx).Error("releasing reservation", "reservation", reservation, "error", err)
+			}
+		}
+		svc.CancelOrder(ctx, order)
+		return nil, &StripeFailure{"creating session", err}
+	}
+	// The session exists; record it even if the client has gone away.
+	ctx = context.WithoutCancel(ctx)
+	order.SessionID = s.ID
+	order.Amount = s.AmountTotal
+	order.Currency = string(s.Currency)
+	if err := svc.Payments.SaveOrder(ctx, order); err != nil {
+		LogCtx(ctx).Error("linking order", "order", order.ID, "session", s.ID, "error", err)
+	}
+	if reservation != "" {
+		if err := svc.Payments.RenameReservation(ctx, reservation, s.ID); err != nil {
+			LogCtx(ctx).Error("assigning reservation", "reservation", reservation, "session", s.ID, "error", err)
+		}
+	}
+	if err := svc.UpdatePaymentStatus(ctx, s); err != nil {
+		LogCtx(ctx).Error("updating payment status", "session", s.ID, "error", err)
+	}
+	svc.RecordAudit(ctx, AuditActor(ctx), "checkout.created", s.ID, nil, order)
+	return &CreateCheckoutResponse{
+		ID:                 s.ID,
+		URL:                s.URL,
+		OrderID:            order.ID,
+		StatusURL:          svc.OrderStatusURL(order.ID),
+		ExpiresAt:          SessionExpiry(s),
+		StoreCreditApplied: creditApplied,
+	}, nil
+}
+
+// CancelOrder cancels an order whose checkout session couldn't be created
+// and releases the store credit it held. It does so even when the failure
+// was ctx being cancelled.
+func (svc *Service) CancelOrder(ctx context.Context, o *Order) {
+	o.Status = OrderCanceled
+	if err := svc.Payments.SaveOrder(context.WithoutCancel(ctx), o); err != nil {
+		LogCtx(ctx).Error("canceling order", "order", o.ID, "error", err)
+	}
+	svc.releaseStoreCredit(ctx, o.ID)
+}
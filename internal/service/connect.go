@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// ConnectedAccountFromStripe converts a Stripe account to the local record.
+func ConnectedAccountFromStripe(a *stripe.Account) *ConnectedAccount {
+	return &ConnectedAccount{
+		ID:               a.ID,
+		Email:            a.Email,
+		ChargesEnabled:   a.ChargesEnabled,
+		PayoutsEnabled:   a.PayoutsEnabled,
+		DetailsSubmitted: a.DetailsSubmitted,
+	}
+}
+
+// sellerAccount returns the connected account for a checkout, fetching it
+// from Stripe the first time it is seen. Sellers that can't accept charges yet
+// are rejected.
+func (svc *Service) sellerAccount(ctx context.Context, id string) (*ConnectedAccount, error) {
+	if err := ValidateStripeID(id, "acct_", "seller"); err != nil {
+		return nil, err
+	}
+	a, err := svc.Payments.GetConnectedAccount(ctx, id)
+	if err == ErrAccountNotFound {
+		sa, err := svc.Stripe.GetAccount(ctx, id, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unknown seller %q", id)
+		}
+		a = ConnectedAccountFromStripe(sa)
+		if err := svc.Payments.SaveConnectedAccount(ctx, a); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if !a.ChargesEnabled {
+		return nil, errors.New("seller can't accept payments until onboarding is complete")
+	}
+	return a, nil
+}
+
+// ApplicationFee is the platform's cut, ApplicationFeePercent of the line
+// item total in the session's currency, rounded to the nearest unit.
+func (svc *Service) ApplicationFee(ctx context.Context, items []*stripe.CheckoutSessionLineItemParams, currency string) int64 {
+	var total int64
+	for _, li := range items {
+		if p, ok := svc.CatalogFor(ctx).Price(*li.Price); ok {
+			amount, _ := p.AmountIn(currency)
+			total += amount * *li.Quantity
+		}
+	}
+	return int64(math.Round(float64(total) * svc.Config.ApplicationFeePercent / 100))
+}
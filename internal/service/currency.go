@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/language"
+
+	"stripe_go/internal/config"
+)
+
+// regionCurrencies maps the region of an Accept-Language tag to the currency
+// shoppers there expect to pay in.
+var regionCurrencies = map[string]string{
+	"US": "usd", "CA": "cad", "MX": "mxn", "BR": "brl",
+	"GB": "gbp", "IE": "eur", "CH": "chf", "NO": "nok", "SE": "sek", "DK": "dkk", "PL": "pln",
+	"AT": "eur", "BE": "eur", "DE": "eur", "ES": "eur", "FI": "eur", "FR": "eur",
+	"GR": "eur", "IT": "eur", "LU": "eur", "NL": "eur", "PT": "eur",
+	"AU": "aud", "NZ": "nzd", "JP": "jpy", "SG": "sgd", "HK": "hkd", "IN": "inr", "LK": "lkr",
+}
+
+// NormalizeCurrency lowercases an ISO 4217 code and checks its shape.
+func NormalizeCurrency(currency string) (string, error) {
+	currency = strings.ToLower(strings.TrimSpace(currency))
+	if currency != "" && !config.CurrencyPattern.MatchString(currency) {
+		return "", fmt.Errorf("invalid currency %q", currency)
+	}
+	return currency, nil
+}
+
+// PreferredCurrencies lists the currencies a shopper asked for, best first:
+// the explicit choice if there is one, otherwise those inferred from the
+// regions in Accept-Language.
+func PreferredCurrencies(acceptLanguage, explicit string) []string {
+	if explicit != "" {
+		return []string{explicit}
+	}
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil {
+		return nil
+	}
+	var currencies []string
+	for _, tag := range tags {
+		region, confidence := tag.Region()
+		if confidence == language.No {
+			continue
+		}
+		if c, ok := regionCurrencies[region.String()]; ok {
+			currencies = append(currencies, c)
+		}
+	}
+	return currencies
+}
+
+// ChooseCurrency returns the first preferred currency every price can be
+// charged in, or "" to charge in the prices' default currency.
+func ChooseCurrency(prices []*CatalogPrice, preferred []string) string {
+	for _, c := range preferred {
+		supported := true
+		for _, p := range prices {
+			supported = supported && p.supports(c)
+		}
+		if supported {
+			return c
+		}
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPreferredCurrencies(t *testing.T) {
+	for _, tt := range []struct {
+		explicit, acceptLanguage string
+		want                     string
+	}{
+		{"", "", ""},
+		{"", "en-GB,en;q=0.8", "gbp,usd"},
+		{"", "de-CH, de;q=0.9, fr;q=0.5", "chf,eur,eur"},
+		{"", "ja", "jpy"},
+		{"eur", "en-GB", "eur"},
+		{"", "not a header;;", ""},
+	} {
+		if got := strings.Join(PreferredCurrencies(tt.acceptLanguage, tt.explicit), ","); got != tt.want {
+			t.Errorf("preferredCurrencies(%q, %q) = %q, want %q", tt.explicit, tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestChooseCurrency(t *testing.T) {
+	basic := &CatalogPrice{Currency: "usd", CurrencyOptions: map[string]int64{"eur": 1400, "gbp": 1200}}
+	other := &CatalogPrice{Currency: "usd", CurrencyOptions: map[string]int64{"eur": 900}}
+	for _, tt := range []struct {
+		prices    []*CatalogPrice
+		preferred []string
+		want      string
+	}{
+		{[]*CatalogPrice{basic}, nil, ""},
+		{[]*CatalogPrice{basic}, []string{"gbp"}, "gbp"},
+		{[]*CatalogPrice{basic, other}, []string{"gbp", "eur"}, "eur"},
+		{[]*CatalogPrice{basic, other}, []string{"jpy"}, ""},
+		{[]*CatalogPrice{basic}, []string{"usd"}, "usd"},
+	} {
+		if got := ChooseCurrency(tt.prices, tt.preferred); got != tt.want {
+			t.Errorf("chooseCurrency(%v) = %q, want %q", tt.preferred, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeCurrency(t *testing.T) {
+	if got, err := NormalizeCurrency(" EUR "); err != nil || got != "eur" {
+		t.Errorf("normalizeCurrency(EUR) = %q, %v", got, err)
+	}
+	if _, err := NormalizeCurrency("euro"); err == nil {
+		t.Error("accepted a four-letter currency")
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// CustomerRequest is the body accepted when creating or updating a customer.
+// Empty fields are left unchanged on update.
+type CustomerRequest struct {
+	Email    string            `json:"email"`
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+func (c *CustomerRequest) Validate(ctx context.Context, svc *Service) error {
+	if c.Email != "" {
+		if err := config.ValidateEmail(c.Email); err != nil {
+			return err
+		}
+	}
+	if len(c.Name) > 256 {
+		return errors.New("name is too long")
+	}
+	return nil
+}
+
+func (c *CustomerRequest) params() *stripe.CustomerParams {
+	params := &stripe.CustomerParams{}
+	if c.Email != "" {
+		params.Email = stripe.String(c.Email)
+	}
+	if c.Name != "" {
+		params.Name = stripe.String(c.Name)
+	}
+	for k, v := range c.Metadata {
+		params.AddMetadata(k, v)
+	}
+	return params
+}
+
+// ListCustomers returns up to 20 Stripe customers, only those with email
+// when it is given.
+func (svc *Service) ListCustomers(ctx context.Context, email string) ([]*stripe.Customer, error) {
+	params := &stripe.CustomerListParams{}
+	if email != "" {
+		if err := config.ValidateEmail(email); err != nil {
+			return nil, BadRequest(err)
+		}
+		params.Email = stripe.String(email)
+	}
+	params.Filters.AddFilter("limit", "", "20")
+	list, err := svc.Stripe.ListCustomers(ctx, params)
+	if err != nil {
+		return nil, &StripeFailure{"listing customers", err}
+	}
+	return list, nil
+}
+
+// CreateCustomer creates a Stripe customer for a validated req, which needs
+// an email.
+func (svc *Service) CreateCustomer(ctx context.Context, req *CustomerRequest) (*stripe.Customer, error) {
+	if req.Email == "" {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "email is required"}
+	}
+	params := req.params()
+	params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "customer"))
+	c, err := svc.Stripe.NewCustomer(ctx, params)
+	if err != nil {
+		return nil, &StripeFailure{"creating customer", err}
+	}
+	return c, nil
+}
+
+// GetCustomer fetches customer id from Stripe.
+func (svc *Service) GetCustomer(ctx context.Context, id string) (*stripe.Customer, error) {
+	c, err := svc.Stripe.GetCustomer(ctx, id, nil)
+	if err != nil {
+		return nil, &StripeFailure{"fetching customer", err}
+	}
+	return c, nil
+}
+
+// UpdateCustomer sets the fields given in req on customer id.
+func (svc *Service) UpdateCustomer(ctx context.Context, id string, req *CustomerRequest) (*stripe.Customer, error) {
+	params := req.params()
+	params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "update_customer"))
+	c, err := svc.Stripe.UpdateCustomer(ctx, id, params)
+	if err != nil {
+		return nil, &StripeFailure{"updating customer", err}
+	}
+	return c, nil
+}
+
+// DeleteCustomer deletes customer id in Stripe.
+func (svc *Service) DeleteCustomer(ctx context.Context, id string) (*stripe.Customer, error) {
+	c, err := svc.Stripe.DeleteCustomer(ctx, id, nil)
+	if err != nil {
+		return nil, &StripeFailure{"deleting customer", err}
+	}
+	return c, nil
+}
+
+// CustomerSessions returns the last 20 checkout sessions created for
+// customer, most recent first.
+func (svc *Service) CustomerSessions(ctx context.Context, customer string) ([]*stripe.CheckoutSession, error) {
+	params := &stripe.CheckoutSessionListParams{
+		Customer: stripe.String(customer),
+	}
+	params.Filters.AddFilter("limit", "", "20")
+	list, err := svc.Stripe.ListCheckoutSessions(ctx, params)
+	if err != nil {
+		return nil, &StripeFailure{"listing sessions", err}
+	}
+	return list, nil
+}
+
+// CustomerPaymentMethods returns up to 20 payment methods of pmType, card
+// when empty, saved to customer.
+func (svc *Service) CustomerPaymentMethods(ctx context.Context, customer, pmType string) ([]*stripe.PaymentMethod, error) {
+	if pmType == "" {
+		pmType = "card"
+	}
+	if !config.PaymentMethodTypes[pmType] {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown payment method type %q", pmType)}
+	}
+	params := &stripe.PaymentMethodListParams{
+		Customer: stripe.String(customer),
+		Type:     stripe.String(pmType),
+	}
+	params.Filters.AddFilter("limit", "", "20")
+	list, err := svc.Stripe.ListPaymentMethods(ctx, params)
+	if err != nil {
+		return nil, &StripeFailure{"listing payment methods", err}
+	}
+	return list, nil
+}
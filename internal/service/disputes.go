@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// evidenceFields are the Stripe evidence fields that can be attached to a
+// dispute, by name. File fields take the ID of a file uploaded with the
+// dispute_evidence purpose; the others take text.
+var evidenceFields = map[string]struct {
+	file bool
+	set  func(e *stripe.DisputeEvidenceParams, v *string)
+}{
+	"access_activity_log":            {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.AccessActivityLog = v }},
+	"billing_address":                {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.BillingAddress = v }},
+	"cancellation_policy":            {true, func(e *stripe.DisputeEvidenceParams, v *string) { e.CancellationPolicy = v }},
+	"cancellation_policy_disclosure": {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.CancellationPolicyDisclosure = v }},
+	"cancellation_rebuttal":          {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.CancellationRebuttal = v }},
+	"customer_communication":         {true, func(e *stripe.DisputeEvidenceParams, v *string) { e.CustomerCommunication = v }},
+	"customer_email_address":         {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.CustomerEmailAddress = v }},
+	"customer_name":                  {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.CustomerName = v }},
+	"customer_purchase_ip":           {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.CustomerPurchaseIP = v }},
+	"customer_signature":             {true, func(e *stripe.DisputeEvidenceParams, v *string) { e.CustomerSignature = v }},
+	"duplicate_charge_documentation": {true, func(e *stripe.DisputeEvidenceParams, v *string) { e.DuplicateChargeDocumentation = v }},
+	"duplicate_charge_explanation":   {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.DuplicateChargeExplanation = v }},
+	"duplicate_charge_id":            {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.DuplicateChargeID = v }},
+	"product_description":            {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.ProductDescription = v }},
+	"receipt":                        {true, func(e *stripe.DisputeEvidenceParams, v *string) { e.Receipt = v }},
+	"refund_policy":                  {true, func(e *stripe.DisputeEvidenceParams, v *string) { e.RefundPolicy = v }},
+	"refund_policy_disclosure":       {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.RefundPolicyDisclosure = v }},
+	"refund_refusal_explanation":     {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.RefundRefusalExplanation = v }},
+	"service_date":                   {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.ServiceDate = v }},
+	"service_documentation":          {true, func(e *stripe.DisputeEvidenceParams, v *string) { e.ServiceDocumentation = v }},
+	"shipping_address":               {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.ShippingAddress = v }},
+	"shipping_carrier":               {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.ShippingCarrier = v }},
+	"shipping_date":                  {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.ShippingDate = v }},
+	"shipping_documentation":         {true, func(e *stripe.DisputeEvidenceParams, v *string) { e.ShippingDocumentation = v }},
+	"shipping_tracking_number":       {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.ShippingTrackingNumber = v }},
+	"uncategorized_file":             {true, func(e *stripe.DisputeEvidenceParams, v *string) { e.UncategorizedFile = v }},
+	"uncategorized_text":             {false, func(e *stripe.DisputeEvidenceParams, v *string) { e.UncategorizedText = v }},
+}
+
+// maxEvidenceText is Stripe's limit for a single text evidence field.
+const maxEvidenceText = 20000
+
+// EvidenceRequest is the JSON body accepted by
+// POST /admin/disputes/{id}/evidence for text evidence. Files are uploaded
+// as multipart/form-data with kind and file fields instead.
+type EvidenceRequest struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+}
+
+func (req *EvidenceRequest) Validate(ctx context.Context, svc *Service) error {
+	field, ok := evidenceFields[req.Kind]
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown evidence kind %q", req.Kind)
+	case field.file:
+		return fmt.Errorf("%s evidence is a file; upload it as multipart/form-data", req.Kind)
+	case req.Text == "":
+		return errors.New("text is required")
+	case len(req.Text) > maxEvidenceText:
+		return fmt.Errorf("text must be at most %d characters", maxEvidenceText)
+	}
+	return nil
+}
+
+// disputeOpen reports whether evidence can still be attached and submitted.
+func disputeOpen(status string) bool {
+	return status == string(stripe.DisputeStatusNeedsResponse) || status == string(stripe.DisputeStatusWarningNeedsResponse)
+}
+
+// DisputeFromStripe converts a Stripe dispute to the local record, without
+// the evidence, which is attached through the admin API.
+func DisputeFromStripe(d *stripe.Dispute) *Dispute {
+	rec := &Dispute{
+		ID:       d.ID,
+		Amount:   d.Amount,
+		Currency: string(d.Currency),
+		Reason:   string(d.Reason),
+		Status:   string(d.Status),
+	}
+	if d.Charge != nil {
+		rec.ChargeID = d.Charge.ID
+	}
+	if d.PaymentIntent != nil {
+		rec.PaymentIntentID = d.PaymentIntent.ID
+	}
+	if d.EvidenceDetails != nil {
+		rec.EvidenceDueBy = optionalTime(d.EvidenceDetails.DueBy)
+	}
+	if d.Created != 0 {
+		rec.CreatedAt = time.Unix(d.Created, 0).UTC()
+	}
+	return rec
+}
+
+// ListDisputes returns a page of the disputes matching f, soonest evidence
+// due date first, and whether there is another page.
+func (svc *Service) ListDisputes(ctx context.Context, f DisputeFilter) ([]*Dispute, bool, error) {
+	// Fetch one extra row to know whether there is another page.
+	limit := f.Limit
+	f.Limit++
+	list, err := svc.Payments.ListDisputes(ctx, f)
+	if err != nil {
+		return nil, false, InternalError("listing disputes", err)
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []*Dispute{}
+	}
+	return list, hasMore, nil
+}
+
+// GetDispute returns the local copy of dispute id with its evidence.
+func (svc *Service) GetDispute(ctx context.Context, id string) (*Dispute, error) {
+	d, err := svc.Payments.GetDispute(ctx, id)
+	if err != nil && err != ErrDisputeNotFound {
+		return nil, InternalError("fetching dispute", err)
+	}
+	return d, err
+}
+
+// openDispute returns dispute id if it still takes evidence.
+func (svc *Service) openDispute(ctx context.Context, id string) (*Dispute, error) {
+	d, err := svc.GetDispute(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !disputeOpen(d.Status) {
+		return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("dispute is %s; it no longer takes evidence", d.Status)}
+	}
+	return d, nil
+}
+
+// AttachEvidence adds the text evidence in req to dispute id. Attaching a
+// kind again replaces it.
+func (svc *Service) AttachEvidence(ctx context.Context, id string, req *EvidenceRequest) (*Dispute, error) {
+	d, err := svc.openDispute(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return svc.setEvidence(ctx, d, req.Kind, req.Text)
+}
+
+// UploadEvidence uploads file to Stripe as the evidence of kind and
+// attaches it to dispute id. Attaching a kind again replaces it.
+func (svc *Service) UploadEvidence(ctx context.Context, id, kind, filename string, file io.Reader) (*Dispute, error) {
+	d, err := svc.openDispute(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if field, ok := evidenceFields[kind]; !ok || !field.file {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("%q isn't a file evidence kind", kind)}
+	}
+	params := &stripe.FileParams{
+		FileReader: file,
+		Filename:   stripe.String(filename),
+		Purpose:    stripe.String(string(stripe.FilePurposeDisputeEvidence)),
+	}
+	params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "evidence_file"))
+	f, err := svc.Stripe.NewFile(ctx, params)
+	if err != nil {
+		return nil, &StripeFailure{"uploading evidence", err}
+	}
+	return svc.setEvidence(ctx, d, kind, f.ID)
+}
+
+func (svc *Service) setEvidence(ctx context.Context, d *Dispute, kind, v string) (*Dispute, error) {
+	if d.Evidence == nil {
+		d.Evidence = map[string]string{}
+	}
+	d.Evidence[kind] = v
+	if err := svc.Payments.SetDisputeEvidence(ctx, d.ID, d.Evidence, nil); err != nil {
+		return nil, InternalError("saving evidence", err)
+	}
+	return d, nil
+}
+
+// SubmitEvidence sends the evidence attached to dispute id to Stripe, which
+// passes it on to the card network. It can't be changed afterwards.
+func (svc *Service) SubmitEvidence(ctx context.Context, id string) (*Dispute, error) {
+	d, err := svc.openDispute(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.Evidence) == 0 {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "no evidence attached"}
+	}
+	evidence := &stripe.DisputeEvidenceParams{}
+	kinds := make([]string, 0, len(d.Evidence))
+	for kind, v := range d.Evidence {
+		evidenceFields[kind].set(evidence, stripe.String(v))
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	params := &stripe.DisputeParams{Evidence: evidence, Submit: stripe.Bool(true)}
+	params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "submit_evidence"))
+	sd, err := svc.Stripe.UpdateDispute(ctx, d.ID, params)
+	if err != nil {
+		return nil, &StripeFailure{"submitting evidence", err}
+	}
+
+	before := *d
+	if err := svc.Payments.SaveDispute(ctx, DisputeFromStripe(sd)); err != nil {
+		return nil, InternalError("saving dispute", err)
+	}
+	now := time.Now().UTC()
+	if err := svc.Payments.SetDisputeEvidence(ctx, d.ID, d.Evidence, &now); err != nil {
+		return nil, InternalError("saving dispute", err)
+	}
+	if d, err = svc.Payments.GetDispute(ctx, d.ID); err != nil {
+		return nil, InternalError("fetching dispute", err)
+	}
+	LogCtx(ctx).Info("dispute evidence submitted", "dispute", d.ID, "evidence", kinds)
+	svc.RecordAudit(ctx, AuditActor(ctx), "dispute.submitted", d.ID, &before, d)
+	return d, nil
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// DonationMetadataKey marks donation sessions and their payment intents.
+// Clients can't set it.
+const DonationMetadataKey = "donation"
+
+// DonationRequest is the body accepted by /create-donation-session. Amount
+// is in the smallest unit of DONATION_CURRENCY, e.g. cents. CustomerToken
+// is the portal token of Customer, which callers other than admins need.
+type DonationRequest struct {
+	Amount        int64             `json:"amount"`
+	Customer      string            `json:"customer"`
+	CustomerToken string            `json:"customerToken"`
+	Metadata      map[string]string `json:"metadata"`
+	SuccessURL    string            `json:"successUrl"`
+	CancelURL     string            `json:"cancelUrl"`
+	Locale        string            `json:"locale"`
+}
+
+func (d *DonationRequest) Validate(ctx context.Context, svc *Service) error {
+	if d.Amount < svc.Config.DonationMinAmount || d.Amount > svc.Config.DonationMaxAmount {
+		return fmt.Errorf("amount must be between %s and %s",
+			FormatAmount(svc.Config.DonationMinAmount, svc.Config.DonationCurrency),
+			FormatAmount(svc.Config.DonationMaxAmount, svc.Config.DonationCurrency))
+	}
+	if d.Customer != "" {
+		if err := ValidateStripeID(d.Customer, "cus_", "customer"); err != nil {
+			return err
+		}
+	}
+	var err error
+	if d.Locale, err = NormalizeLocale(d.Locale); err != nil {
+		return err
+	}
+	for _, key := range []string{OrderMetadataKey, DonationMetadataKey, SummaryMetadataKey, TraceMetadataKey} {
+		if _, ok := d.Metadata[key]; ok {
+			return fmt.Errorf("metadata key %q is reserved", key)
+		}
+	}
+	return validateMetadata(d.Metadata)
+}
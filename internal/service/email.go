@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/email"
+)
+
+// The email types are the transport's; the service only renders messages.
+type (
+	EmailMessage    = email.Message
+	EmailAttachment = email.Attachment
+	EmailSender     = email.Sender
+)
+
+// newEmailSender returns the backend selected by EMAIL_BACKEND: "smtp",
+// "sendgrid", or "" to just log emails to stdout. config.Load has already
+// checked that the backend's settings are present.
+func newEmailSender(c *config.Config) (EmailSender, error) {
+	switch c.EmailBackend {
+	case "":
+		return email.LogSender{}, nil
+	case "smtp":
+		return email.NewSMTPSender(c.SMTPHost, c.SMTPPort, c.SMTPUsername, c.SMTPPassword, c.EmailFrom), nil
+	case "sendgrid":
+		client := &http.Client{Timeout: 10 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)}
+		return email.NewSendGridSender(c.SendGridAPIKey, c.EmailFrom, client), nil
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_BACKEND %q", c.EmailBackend)
+	}
+}
+
+// StripeReceipts reports whether payments are created with a receipt_email,
+// so Stripe emails its receipt once they succeed.
+func (svc *Service) StripeReceipts() bool {
+	return svc.Config.Receipts == config.ReceiptsStripe || svc.Config.Receipts == config.ReceiptsBoth
+}
+
+// customReceipts reports whether the paid checkout s is sent our
+// confirmation email r: always unless RECEIPTS=stripe, and then when Stripe
+// wasn't given an address to email its receipt to or the order has license
+// keys, which only our email carries.
+func (svc *Service) customReceipts(s *stripe.CheckoutSession, r *Receipt) bool {
+	return svc.Config.Receipts != config.ReceiptsStripe || s.Metadata[stripeReceiptMetadataKey] != "true" || len(r.Licenses) > 0
+}
+
+// stripeReceiptMetadataKey marks the checkout sessions whose payment Stripe
+// emails a receipt for, which don't get our confirmation email under
+// RECEIPTS=stripe.
+const stripeReceiptMetadataKey = "stripe_receipt"
+
+// withReceiptEmail has Stripe email its receipt for the session's payment to
+// address when RECEIPTS includes stripe, and marks the session so. Sessions
+// without an address get our confirmation email instead, as Stripe sends its
+// receipt to the one entered on the Checkout page only if the dashboard's
+// successful payments email setting is on.
+func (svc *Service) withReceiptEmail(params *stripe.CheckoutSessionParams, address string) {
+	if !svc.StripeReceipts() || address == "" || params.PaymentIntentData == nil {
+		return
+	}
+	params.PaymentIntentData.ReceiptEmail = stripe.String(address)
+	params.AddMetadata(stripeReceiptMetadataKey, "true")
+}
+
+// Receipt holds the details shown in a confirmation email.
+type Receipt struct {
+	Email           string
+	PaymentIntentID string
+	PaymentStatus   string
+	Amount          int64
+	Currency        string
+	// Locale is the Checkout Session's locale and picks the email's
+	// language.
+	Locale string
+	// Items are the products bought, when the session has an order.
+	Items []ReceiptItem
+	// Metadata is the checkout metadata, including the order it pays for.
+	Metadata map[string]string
+	// DownloadURL links to the PDF receipt when receipts are enabled.
+	DownloadURL string
+	// StatusURL links to the order's status when order status links are
+	// enabled.
+	StatusURL string
+	// Licenses are the license keys of the order's digital products.
+	Licenses []ReceiptLicense
+	// TaxIDs are the tax IDs a business customer entered at checkout.
+	TaxIDs []string
+}
+
+// ReceiptItem is one line of a confirmation email. Amount is the line total.
+type ReceiptItem struct {
+	Name     string
+	Quantity int64
+	Amount   int64
+}
+
+// OrderNumber is the shop's order_id metadata if the client sent one, and
+// otherwise the ID of the order created at checkout.
+func (r *Receipt) OrderNumber() string {
+	if id := r.Metadata["order_id"]; id != "" {
+		return id
+	}
+	return r.Metadata[OrderMetadataKey]
+}
+
+// zeroDecimalCurrencies are charged in whole units rather than cents.
+var zeroDecimalCurrencies = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true,
+	"kmf": true, "krw": true, "mga": true, "pyg": true, "rwf": true,
+	"ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true,
+	"xpf": true,
+}
+
+func FormatAmount(amount int64, currency string) string {
+	code := strings.ToUpper(currency)
+	if zeroDecimalCurrencies[strings.ToLower(currency)] {
+		return fmt.Sprintf("%d %s", amount, code)
+	}
+	return fmt.Sprintf("%d.%02d %s", amount/100, amount%100, code)
+}
+
+// SendConfirmationEmail renders the receipt and sends it. It runs from the
+// job queue, which retries it when the backend fails.
+func (svc *Service) SendConfirmationEmail(ctx context.Context, receipt *Receipt) error {
+	if receipt.Email == "" {
+		slog.Info("no customer email, skipping confirmation email")
+		return nil
+	}
+	msg, err := svc.EmailTemplates.Render("receipt", receipt.Locale, receipt)
+	if err != nil {
+		return err
+	}
+	msg.To = receipt.Email
+	return svc.EmailSender.Send(ctx, msg)
+}
@@ -0,0 +1,19 @@
+package service
+
+import "testing"
+
+func TestFormatAmount(t *testing.T) {
+	for _, tt := range []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{1250, "usd", "12.50 USD"},
+		{5, "eur", "0.05 EUR"},
+		{500, "jpy", "500 JPY"},
+	} {
+		if got := FormatAmount(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("formatAmount(%d, %q) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
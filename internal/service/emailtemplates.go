@@ -0,0 +1,133 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// DefaultEmailLocale is used when a customer's locale has no templates.
+const DefaultEmailLocale = "en"
+
+// EmailTemplates renders customer emails from EMAIL_TEMPLATE_DIR. The
+// directory has one subdirectory per locale (en, de, pt-BR, ...) holding
+// <name>.html and <name>.txt; the text template also defines
+// "<name>.subject".
+// Templates can call money to format an amount in minor units for the
+// locale, e.g. {{money .Amount .Currency}}.
+type EmailTemplates struct {
+	locales map[string]*emailTemplateSet
+	matcher language.Matcher
+	tags    []string
+}
+
+type emailTemplateSet struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// LoadEmailTemplates parses every locale directory in fsys. The default
+// locale must be present.
+func LoadEmailTemplates(fsys fs.FS) (*EmailTemplates, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("reading email templates: %w", err)
+	}
+	t := &EmailTemplates{locales: map[string]*emailTemplateSet{}}
+	var tags []language.Tag
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		tag, err := language.Parse(e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("email templates: %q is not a locale", e.Name())
+		}
+		funcs := map[string]interface{}{"money": localAmountFormatter(tag)}
+		set := &emailTemplateSet{}
+		if set.html, err = htmltemplate.New("").Funcs(funcs).ParseFS(fsys, path.Join(e.Name(), "*.html")); err != nil {
+			return nil, fmt.Errorf("email templates %s: %w", e.Name(), err)
+		}
+		if set.text, err = texttemplate.New("").Funcs(funcs).ParseFS(fsys, path.Join(e.Name(), "*.txt")); err != nil {
+			return nil, fmt.Errorf("email templates %s: %w", e.Name(), err)
+		}
+		t.locales[tag.String()] = set
+		// The matcher falls back to its first tag, so the default goes first.
+		if tag.String() == DefaultEmailLocale {
+			tags = append([]language.Tag{tag}, tags...)
+			t.tags = append([]string{tag.String()}, t.tags...)
+		} else {
+			tags = append(tags, tag)
+			t.tags = append(t.tags, tag.String())
+		}
+	}
+	if t.locales[DefaultEmailLocale] == nil {
+		return nil, fmt.Errorf("email templates: no %s directory", DefaultEmailLocale)
+	}
+	t.matcher = language.NewMatcher(tags)
+	return t, nil
+}
+
+// Locales lists the locales that have templates, default first.
+func (t *EmailTemplates) Locales() []string {
+	return t.tags
+}
+
+// Locale picks the closest locale with templates, e.g. de for de-AT. An
+// empty or unknown locale gets the default.
+func (t *EmailTemplates) Locale(locale string) string {
+	if locale == "" || locale == "auto" {
+		return DefaultEmailLocale
+	}
+	_, i, confidence := t.matcher.Match(language.Make(locale))
+	if confidence == language.No {
+		return DefaultEmailLocale
+	}
+	return t.tags[i]
+}
+
+// Render fills in template name for locale. The returned message has no
+// recipient yet.
+func (t *EmailTemplates) Render(name, locale string, data interface{}) (*EmailMessage, error) {
+	set := t.locales[t.Locale(locale)]
+	if set.html.Lookup(name+".html") == nil || set.text.Lookup(name+".txt") == nil {
+		set = t.locales[DefaultEmailLocale]
+	}
+	var html, text, subject bytes.Buffer
+	if err := set.html.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return nil, fmt.Errorf("rendering %s.html: %w", name, err)
+	}
+	if err := set.text.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return nil, fmt.Errorf("rendering %s.txt: %w", name, err)
+	}
+	if err := set.text.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return nil, fmt.Errorf("rendering %s subject: %w", name, err)
+	}
+	return &EmailMessage{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    text.String(),
+	}, nil
+}
+
+// localAmountFormatter returns the money template function for tag: the
+// amount in major units with the locale's separators, followed by the
+// currency code, e.g. "1,234.50 USD" or "1.234,50 EUR".
+func localAmountFormatter(tag language.Tag) func(int64, string) string {
+	p := message.NewPrinter(tag)
+	return func(amount int64, currency string) string {
+		code := strings.ToUpper(currency)
+		if zeroDecimalCurrencies[strings.ToLower(currency)] {
+			return p.Sprintf("%v %s", number.Decimal(amount), code)
+		}
+		return p.Sprintf("%v %s", number.Decimal(float64(amount)/100, number.Scale(2)), code)
+	}
+}
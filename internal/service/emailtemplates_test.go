@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestLocalAmountFormatter(t *testing.T) {
+	for _, tt := range []struct {
+		locale   string
+		amount   int64
+		currency string
+		want     string
+	}{
+		{"en", 123450, "usd", "1,234.50 USD"},
+		{"de", 123450, "eur", "1.234,50 EUR"},
+		{"en", 500, "jpy", "500 JPY"},
+		{"en", 3000, "usd", "30.00 USD"},
+	} {
+		if got := localAmountFormatter(language.Make(tt.locale))(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("%s money(%d, %s) = %q, want %q", tt.locale, tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
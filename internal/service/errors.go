@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// The kinds of failure the API reports. Handlers and service operations
+// return errors that match one of them with errors.Is: the store's
+// not-found errors wrap ErrNotFound, a *Error matches the kind of its
+// status and a *StripeFailure the kind of Stripe's answer. ErrorStatus turns
+// them into the status and message both the HTTP and gRPC servers answer
+// with.
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrValidation     = errors.New("invalid request")
+	ErrConflict       = errors.New("conflict")
+	ErrStripeDeclined = errors.New("declined")
+	ErrRateLimited    = errors.New("too many requests")
+)
+
+// errorStatuses are the HTTP statuses the kinds of failure are reported
+// with.
+var errorStatuses = []struct {
+	err    error
+	status int
+}{
+	{ErrNotFound, http.StatusNotFound},
+	{ErrValidation, http.StatusBadRequest},
+	{ErrConflict, http.StatusConflict},
+	{ErrStripeDeclined, http.StatusPaymentRequired},
+	{ErrRateLimited, http.StatusTooManyRequests},
+}
+
+// KindStatus returns the HTTP status of the kind of failure err matches, if
+// any.
+func KindStatus(err error) (int, bool) {
+	for _, k := range errorStatuses {
+		if errors.Is(err, k.err) {
+			return k.status, true
+		}
+	}
+	return 0, false
+}
+
+// ErrorStatus is the HTTP status and message err is reported with. Errors
+// of no known kind are internal; clients only learn that the request failed,
+// not why, so database and other internal errors don't leak.
+func ErrorStatus(err error) (int, *ErrorResponseMessage) {
+	var sf *StripeFailure
+	var se *Error
+	switch {
+	case errors.As(err, &sf):
+		msg, code := StripeErrorResponse(sf.Err, sf.Action)
+		return code, msg
+	case errors.As(err, &se):
+		return se.Status, &ErrorResponseMessage{Message: se.Message}
+	}
+	if status, ok := KindStatus(err); ok {
+		return status, &ErrorResponseMessage{Message: err.Error()}
+	}
+	return http.StatusInternalServerError, &ErrorResponseMessage{Message: http.StatusText(http.StatusInternalServerError)}
+}
+
+// StripeErrorResponse is the error message and HTTP status that a failed
+// Stripe call is reported with. Errors caused by the request (unknown IDs,
+// declined cards, invalid parameters) keep their status, Stripe's message
+// and its error code. Anything else only says what failed, like an
+// InternalError: timeouts are a gateway timeout and the rest a bad gateway.
+func StripeErrorResponse(err error, action string) (*ErrorResponseMessage, int) {
+	code := http.StatusBadGateway
+	msg := &ErrorResponseMessage{Message: "error while " + action}
+	var se *stripe.Error
+	var ne net.Error
+	if errors.As(err, &se) {
+		switch se.HTTPStatusCode {
+		case http.StatusBadRequest, http.StatusPaymentRequired, http.StatusNotFound:
+			code = se.HTTPStatusCode
+			msg.Message = fmt.Sprintf("error while %s: %s", action, se.Msg)
+			msg.Code = string(se.Code)
+			msg.DeclineCode = string(se.DeclineCode)
+		case http.StatusTooManyRequests:
+			code = http.StatusServiceUnavailable
+		}
+	} else if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		code = http.StatusGatewayTimeout
+	}
+	return msg, code
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func TestErrorStatus(t *testing.T) {
+	declined := &StripeFailure{Action: "confirming payment", Err: &stripe.Error{Type: stripe.ErrorTypeCard, HTTPStatusCode: http.StatusPaymentRequired, Msg: "Your card was declined."}}
+	for _, tt := range []struct {
+		err    error
+		kind   error
+		status int
+		msg    string
+	}{
+		{ErrOrderNotFound, ErrNotFound, http.StatusNotFound, "order not found"},
+		{fmt.Errorf("loading: %w", ErrPaymentNotFound), ErrNotFound, http.StatusNotFound, "loading: payment not found"},
+		{BadRequest(errors.New("price is required")), ErrValidation, http.StatusBadRequest, "price is required"},
+		{&Error{Status: http.StatusConflict, Message: "already captured"}, ErrConflict, http.StatusConflict, "already captured"},
+		{declined, ErrStripeDeclined, http.StatusPaymentRequired, "error while confirming payment: Your card was declined."},
+		{&StripeFailure{Action: "fetching price", Err: &stripe.Error{HTTPStatusCode: http.StatusUnauthorized, Msg: "Invalid API Key provided: sk_test_***"}}, nil, http.StatusBadGateway, "error while fetching price"},
+		{&StripeFailure{Action: "fetching price", Err: errors.New("dial tcp 10.0.0.1:443: connection refused")}, nil, http.StatusBadGateway, "error while fetching price"},
+		{ErrRateLimited, ErrRateLimited, http.StatusTooManyRequests, "too many requests"},
+		{InternalError("saving order", errors.New("database is locked")), nil, http.StatusInternalServerError, "error while saving order"},
+		{errors.New("sql: connection refused"), nil, http.StatusInternalServerError, "Internal Server Error"},
+	} {
+		status, msg := ErrorStatus(tt.err)
+		if status != tt.status || msg.Message != tt.msg {
+			t.Errorf("ErrorStatus(%v) = %d %q, want %d %q", tt.err, status, msg.Message, tt.status, tt.msg)
+		}
+		if tt.kind != nil && !errors.Is(tt.err, tt.kind) {
+			t.Errorf("%v isn't %v", tt.err, tt.kind)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// eventForward returns the forward named name, or nil.
+func (svc *Service) eventForward(name string) *config.EventForward {
+	for i := range svc.Config.EventForwards {
+		if svc.Config.EventForwards[i].Name == name {
+			return &svc.Config.EventForwards[i]
+		}
+	}
+	return nil
+}
+
+// forwardedEvent is the payload of JobForwardEvent. Payload is the event as
+// Stripe sent it; as []byte it survives the job queue byte for byte.
+type forwardedEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Payload []byte `json:"payload"`
+}
+
+// queueEventForward queues the forwarding of event when a forward subscribed
+// to its type, so a failure to log the deliveries is retried by the job
+// queue rather than by Stripe, which would run the handlers again. payload
+// is the body Stripe signed; events that came without one, such as polled
+// events, are forwarded re-encoded.
+func (svc *Service) queueEventForward(ctx context.Context, event stripe.Event, payload []byte) error {
+	if !slices.ContainsFunc(svc.Config.EventForwards, func(f config.EventForward) bool { return f.Forwards(event.Type) }) {
+		return nil
+	}
+	if payload == nil {
+		var err error
+		if payload, err = json.Marshal(event); err != nil {
+			return err
+		}
+	}
+	return svc.Jobs.Enqueue(ctx, JobForwardEvent, &forwardedEvent{ID: event.ID, Type: event.Type, Payload: payload})
+}
+
+// forwardEvent logs a delivery of event for every forward subscribed to its
+// type and queues them. Deliveries are named after the forward and the
+// event, so an event processed again isn't forwarded twice.
+func (svc *Service) forwardEvent(ctx context.Context, event *forwardedEvent) error {
+	for _, f := range svc.Config.EventForwards {
+		if !f.Forwards(event.Type) {
+			continue
+		}
+		id := "whd_" + config.SHA256Hex(f.Name + ":" + event.ID)[:24]
+		if _, err := svc.Payments.GetWebhookDelivery(ctx, id); err == nil {
+			continue
+		} else if err != ErrDeliveryNotFound {
+			return fmt.Errorf("checking forwarded event: %w", err)
+		}
+		d := &WebhookDelivery{
+			ID:        id,
+			Endpoint:  f.Name,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Payload:   event.Payload,
+			Status:    DeliveryPending,
+		}
+		if err := svc.Payments.SaveWebhookDelivery(ctx, d); err != nil {
+			return fmt.Errorf("logging forwarded event: %w", err)
+		}
+		if err := svc.Jobs.Enqueue(ctx, JobDeliverWebhook, d.ID); err != nil {
+			return err
+		}
+		LogCtx(ctx).Info("stripe event forwarded", "event", event.ID, "type", event.Type, "forward", f.Name)
+	}
+	return nil
+}
+
+// BusMessage is what is published on a message bus: a forwarded event with
+// the same ID and signature an HTTP forward gets as headers, or a platform
+// event.
+type BusMessage struct {
+	ID   string
+	Type string
+	// Key keeps the messages about one thing in order on buses that
+	// partition topics, such as an order's ID; empty for forwards.
+	Key       string
+	Payload   []byte
+	Signature string
+}
+
+// MessageBus publishes forwarded and platform events to a topic.
+type MessageBus interface {
+	Publish(ctx context.Context, topic string, msg *BusMessage) error
+}
+
+// messageBus returns the bus named name, connecting to it if needed.
+func (svc *Service) messageBus(name string) (MessageBus, error) {
+	svc.messageBusesMu.Lock()
+	defer svc.messageBusesMu.Unlock()
+	if bus, ok := svc.MessageBuses[name]; ok {
+		return bus, nil
+	}
+	switch name {
+	case "redis":
+		opts, err := redis.ParseURL(svc.Config.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing REDIS_URL: %w", err)
+		}
+		svc.MessageBuses[name] = &redisBus{client: redis.NewClient(opts)}
+	case "kafka":
+		svc.MessageBuses[name] = &kafkaBus{writer: &kafka.Writer{
+			Addr:         kafka.TCP(svc.Config.KafkaBrokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		}}
+	case "nats":
+		conn, err := nats.Connect(svc.Config.NATSURL, nats.Name(svc.Config.ServiceName))
+		if err != nil {
+			return nil, fmt.Errorf("connecting to NATS_URL: %w", err)
+		}
+		js, err := conn.JetStream()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		svc.MessageBuses[name] = &natsBus{js: js}
+	default:
+		return nil, fmt.Errorf("unknown message bus %q", name)
+	}
+	return svc.MessageBuses[name], nil
+}
+
+// redisBus appends messages to Redis streams, which consumer groups can
+// read and acknowledge at their own pace.
+type redisBus struct{ client *redis.Client }
+
+func (b *redisBus) Publish(ctx context.Context, topic string, msg *BusMessage) error {
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: topic,
+		Values: map[string]interface{}{
+			"id":        msg.ID,
+			"type":      msg.Type,
+			"payload":   msg.Payload,
+			"signature": msg.Signature,
+		},
+	}).Err()
+}
+
+// kafkaBus writes messages to Kafka topics, waiting for every in-sync
+// replica to have them. Messages with the same key go to the same
+// partition, so consumers see them in order.
+type kafkaBus struct{ writer *kafka.Writer }
+
+func (b *kafkaBus) Publish(ctx context.Context, topic string, msg *BusMessage) error {
+	m := kafka.Message{
+		Topic: topic,
+		Value: msg.Payload,
+		Headers: []kafka.Header{
+			{Key: "id", Value: []byte(msg.ID)},
+			{Key: "type", Value: []byte(msg.Type)},
+		},
+	}
+	if msg.Key != "" {
+		m.Key = []byte(msg.Key)
+	}
+	if msg.Signature != "" {
+		m.Headers = append(m.Headers, kafka.Header{Key: "signature", Value: []byte(msg.Signature)})
+	}
+	return b.writer.WriteMessages(ctx, m)
+}
+
+// natsBus publishes messages to JetStream on the subject topic.type, e.g.
+// payments.order.paid, and waits for the stream to store them. The message
+// ID doubles as Nats-Msg-Id, so the stream drops a message published again
+// within its duplicate window.
+type natsBus struct{ js nats.JetStreamContext }
+
+func (b *natsBus) Publish(ctx context.Context, topic string, msg *BusMessage) error {
+	m := nats.NewMsg(topic + "." + msg.Type)
+	m.Data = msg.Payload
+	m.Header.Set("Type", msg.Type)
+	if msg.Signature != "" {
+		m.Header.Set("Signature", msg.Signature)
+	}
+	_, err := b.js.PublishMsg(m, nats.MsgId(msg.ID), nats.Context(ctx))
+	return err
+}
+
+// publishForward makes one attempt at publishing a forwarded event on its
+// forward's bus topic.
+func (svc *Service) publishForward(ctx context.Context, f *config.EventForward, d *WebhookDelivery) error {
+	bus, err := svc.messageBus(f.Bus)
+	if err != nil {
+		return err
+	}
+	return bus.Publish(ctx, f.Topic, &BusMessage{
+		ID:        d.EventID,
+		Type:      d.EventType,
+		Payload:   d.Payload,
+		Signature: svc.WebhookSignature(d.Payload, time.Now()),
+	})
+}
+
+// WebhookEventTypes are the events Stripe is asked to deliver: those the
+// webhook router handles and those forwarded. A forward of every event, or
+// of a pattern, needs all of them.
+func (svc *Service) WebhookEventTypes() []string {
+	types := svc.WebhookRouter.EventTypes()
+	for _, f := range svc.Config.EventForwards {
+		if len(f.Events) == 0 {
+			return []string{"*"}
+		}
+		for _, pattern := range f.Events {
+			if strings.ContainsAny(pattern, `*?[\`) {
+				return []string{"*"}
+			}
+			if !slices.Contains(types, pattern) {
+				types = append(types, pattern)
+			}
+		}
+	}
+	slices.Sort(types)
+	return types
+}
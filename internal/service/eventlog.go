@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// OrderEvents returns the logged events of an order: those of its checkout
+// session and payment intent, and those naming it in their metadata.
+func (svc *Service) OrderEvents(ctx context.Context, o *Order) ([]*StoredEvent, error) {
+	return svc.Payments.ListStoredEvents(ctx, StoredEventFilter{OrderID: o.ID, SessionID: o.SessionID, PaymentIntentID: o.PaymentIntentID})
+}
+
+// OrderStep is an order's status after one event of its history. Note says
+// why the event didn't move the order where it points, if it didn't.
+type OrderStep struct {
+	Event  *StoredEvent `json:"event"`
+	Status OrderStatus  `json:"status"`
+	Note   string       `json:"note,omitempty"`
+}
+
+// FoldOrder replays events over o the way the order webhook handlers apply
+// them, starting from a pending order, and returns the order they lead to
+// with the steps on the way. Items, metadata and the creation time are the
+// shop's own and kept from o. Fulfillment isn't a Stripe event, so a
+// fulfilled o the events leave paid stays fulfilled.
+func FoldOrder(o *Order, events []*StoredEvent) (*Order, []OrderStep, error) {
+	folded := *o
+	folded.Status = OrderPending
+	steps := []OrderStep{}
+	for _, e := range events {
+		var event stripe.Event
+		if err := json.Unmarshal(e.Payload, &event); err != nil {
+			return nil, nil, fmt.Errorf("parsing event %s: %w", e.ID, err)
+		}
+		status, ok, err := applyOrderEvent(&folded, event)
+		if err != nil {
+			return nil, nil, fmt.Errorf("event %s: %w", e.ID, err)
+		}
+		step := OrderStep{Event: e}
+		if ok {
+			if err := folded.Transition(status); err != nil {
+				step.Note = err.Error()
+			}
+		}
+		step.Status = folded.Status
+		steps = append(steps, step)
+	}
+	if o.Status == OrderFulfilled && folded.Status == OrderPaid {
+		folded.Status = OrderFulfilled
+	}
+	return &folded, steps, nil
+}
+
+// applyOrderEvent parses event and applies its order transition to o. It
+// returns false for events that don't move orders.
+func applyOrderEvent(o *Order, event stripe.Event) (OrderStatus, bool, error) {
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded",
+		"checkout.session.expired", "checkout.session.async_payment_failed":
+		var s stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+			return "", false, fmt.Errorf("failed to parse session object: %w", err)
+		}
+		return SessionOrderTransition(o, event.Type, &s), true, nil
+	case "payment_intent.succeeded", "payment_intent.canceled":
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return "", false, fmt.Errorf("failed to parse payment intent object: %w", err)
+		}
+		status, ok := IntentOrderTransition(o, event.Type, &pi)
+		return status, ok, nil
+	case "charge.refunded":
+		var ch stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+			return "", false, fmt.Errorf("failed to parse charge object: %w", err)
+		}
+		status, ok := ChargeOrderTransition(o, &ch)
+		return status, ok, nil
+	}
+	return "", false, nil
+}
+
+// OrderChanges lists the fields a projection rebuild would change from a
+// to b.
+func OrderChanges(a, b *Order) []string {
+	var fields []string
+	for _, f := range []struct {
+		name    string
+		changed bool
+	}{
+		{"status", a.Status != b.Status},
+		{"sessionId", a.SessionID != b.SessionID},
+		{"paymentIntentId", a.PaymentIntentID != b.PaymentIntentID},
+		{"email", a.Email != b.Email},
+		{"amount", a.Amount != b.Amount || a.Currency != b.Currency},
+	} {
+		if f.changed {
+			fields = append(fields, f.name)
+		}
+	}
+	return fields
+}
+
+// ProjectionChange is a stored state a projection rebuild replaced, or
+// would replace on a dry run. Fields names what differs.
+type ProjectionChange struct {
+	Key    string      `json:"key"`
+	Fields []string    `json:"fields"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// ProjectionRebuild reports a projection rebuild. Streams counts the states
+// folded from the event log.
+type ProjectionRebuild struct {
+	Projection string             `json:"projection"`
+	DryRun     bool               `json:"dryRun"`
+	Streams    int                `json:"streams"`
+	Changes    []ProjectionChange `json:"changes"`
+}
+
+// RebuildOrders refolds every order with logged events. Orders from before
+// the event log, which have none, are left as they are.
+func (svc *Service) RebuildOrders(ctx context.Context, dryRun bool) (*ProjectionRebuild, error) {
+	list, err := svc.Payments.ListOrders(ctx, OrderFilter{})
+	if err != nil {
+		return nil, err
+	}
+	rebuild := &ProjectionRebuild{Projection: "orders", DryRun: dryRun, Changes: []ProjectionChange{}}
+	for _, o := range list {
+		change, err := svc.rebuildOrder(ctx, o, dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("order %s: %w", o.ID, err)
+		}
+		if change != nil {
+			rebuild.Streams++
+			if len(change.Fields) > 0 {
+				rebuild.Changes = append(rebuild.Changes, *change)
+			}
+		}
+	}
+	return rebuild, nil
+}
+
+// rebuildOrder refolds one order under its payment's lock, so no webhook
+// moves it in between. It returns nil if the order has no logged events.
+func (svc *Service) rebuildOrder(ctx context.Context, o *Order, dryRun bool) (*ProjectionChange, error) {
+	defer svc.WebhookRouter.paymentLocks.Lock(paymentLockKey(o.PaymentIntentID, o.SessionID))()
+	o, err := svc.Payments.GetOrder(ctx, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := svc.OrderEvents(ctx, o)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	folded, _, err := FoldOrder(o, events)
+	if err != nil {
+		return nil, err
+	}
+	change := &ProjectionChange{Key: o.ID, Fields: OrderChanges(o, folded), Before: o, After: folded}
+	if len(change.Fields) == 0 || dryRun {
+		return change, nil
+	}
+	if err := svc.Payments.SaveOrder(ctx, folded); err != nil {
+		return nil, err
+	}
+	LogCtx(ctx).Warn("order rebuilt from event log", "order", o.ID, "fields", change.Fields, "status", folded.Status)
+	return change, nil
+}
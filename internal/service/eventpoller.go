@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// PollEventsCommand is the subcommand that serves as usual and also feeds
+// the account's new events from the Events API into the webhook handlers,
+// so webhooks can be tested end to end without the Stripe CLI or a tunnel.
+const PollEventsCommand = "poll-events"
+
+// EventPoller walks the Events API forward from the moment it started,
+// handing the events to svc. Cursor is the last event handled; until there
+// is one, events created since started are listed instead.
+type EventPoller struct {
+	svc     *Service
+	Cursor  string
+	started time.Time
+}
+
+// NewEventPoller starts after the newest existing event, so the account's
+// history isn't replayed.
+func (svc *Service) NewEventPoller(ctx context.Context) (*EventPoller, error) {
+	p := &EventPoller{svc: svc, started: time.Now()}
+	params := &stripe.EventListParams{}
+	params.Limit = stripe.Int64(1)
+	params.Single = true
+	list, err := svc.Stripe.ListEvents(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("listing events: %w", err)
+	}
+	if len(list) > 0 {
+		p.Cursor = list[0].ID
+	}
+	return p, nil
+}
+
+// Poll handles the events created since the last poll, oldest first, and
+// returns how many it handled. It stops at the first event whose handlers
+// fail, which the next poll tries again, as Stripe would redeliver it.
+func (p *EventPoller) Poll(ctx context.Context) (int, error) {
+	params := &stripe.EventListParams{}
+	if p.Cursor != "" {
+		params.EndingBefore = stripe.String(p.Cursor)
+	} else {
+		params.CreatedRange = &stripe.RangeQueryParams{GreaterThanOrEqual: p.started.Unix()}
+	}
+	list, err := p.svc.Stripe.ListEvents(ctx, params)
+	if err != nil {
+		return 0, fmt.Errorf("listing events: %w", err)
+	}
+	if params.EndingBefore == nil {
+		slices.Reverse(list)
+	}
+	for i, event := range list {
+		if _, err := p.svc.ProcessEvent(ctx, *event, nil); err != nil {
+			return i, fmt.Errorf("event %s: %w", event.ID, err)
+		}
+		p.Cursor = event.ID
+	}
+	return len(list), nil
+}
+
+// pollEvery polls on the given interval until ctx is done.
+func (p *EventPoller) pollEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := p.Poll(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("polling events", "error", err)
+			}
+			if n > 0 {
+				slog.Info("polled events", "handled", n, "cursor", p.Cursor)
+			}
+		}
+	}
+}
+
+// StartEventPolling runs the poller for the poll-events subcommand. It
+// refuses live mode, where webhooks must come signed from Stripe.
+func (svc *Service) StartEventPolling(ctx context.Context) error {
+	if svc.Config.Mode == "live" {
+		return errors.New(PollEventsCommand + " only runs in test mode")
+	}
+	p, err := svc.NewEventPoller(ctx)
+	if err != nil {
+		return err
+	}
+	slog.Warn("polling the Events API instead of waiting for webhooks", "interval", svc.Config.EventPollInterval)
+	go p.pollEvery(ctx, svc.Config.EventPollInterval)
+	return nil
+}
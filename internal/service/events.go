@@ -0,0 +1,16 @@
+package service
+
+import "context"
+
+// EventStore remembers which webhook events have already been handled so
+// Stripe's retries don't repeat side effects like emails.
+type EventStore interface {
+	// Claim marks the event as being processed for a short lease. It
+	// returns false if the event is claimed or was processed within the TTL.
+	Claim(ctx context.Context, eventID string) (bool, error)
+	// Complete marks a claimed event as processed for the TTL.
+	Complete(ctx context.Context, eventID string) error
+	// Release forgets a claim so the event is processed again on the next
+	// delivery. It is used when handling the event failed.
+	Release(ctx context.Context, eventID string) error
+}
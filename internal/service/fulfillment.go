@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"stripe_go/internal/config"
+)
+
+// fulfillmentRoute returns the route for a price, preferring one for the
+// price itself over one for its product, or nil if it has none.
+func (svc *Service) fulfillmentRoute(ctx context.Context, price string) *config.FulfillmentRoute {
+	product := ""
+	if p, ok := svc.CatalogFor(ctx).Price(price); ok {
+		product = p.Product
+	}
+	var byProduct *config.FulfillmentRoute
+	for i, r := range svc.Config.FulfillmentRoutes {
+		switch r.Match {
+		case price:
+			return &svc.Config.FulfillmentRoutes[i]
+		case product:
+			byProduct = &svc.Config.FulfillmentRoutes[i]
+		}
+	}
+	return byProduct
+}
+
+// productSuccessPage is the SUCCESS_PAGES page shared by every item in the
+// cart, as an absolute URL, or "" if the items don't agree on one.
+func (svc *Service) productSuccessPage(prices []*CatalogPrice) string {
+	page := ""
+	for _, p := range prices {
+		pp := svc.Config.SuccessPages[p.ID]
+		if pp == "" {
+			pp = svc.Config.SuccessPages[p.Product]
+		}
+		if pp == "" || (page != "" && pp != page) {
+			return ""
+		}
+		page = pp
+	}
+	if page == "" {
+		return ""
+	}
+	return svc.Config.Domain + page
+}
+
+// A Fulfiller delivers one kind of purchase. New kinds of fulfillment are
+// added to fulfillers under the type used in FULFILLMENT_ROUTES, and their
+// routes checked by validateFulfillmentRoute.
+type Fulfiller interface {
+	// Fulfill delivers f, a line of paid order o, for svc, recording what
+	// the customer got in f.Details. It reports done once nothing is left to do,
+	// or false when it handed the work to someone else, as a shipment does.
+	// It may run again for the same line if saving the result fails.
+	Fulfill(ctx context.Context, svc *Service, o *Order, f *Fulfillment) (done bool, err error)
+}
+
+var fulfillers = map[string]Fulfiller{
+	"download": downloadFulfiller{},
+	"license":  licenseFulfiller{},
+	"shipment": shipmentFulfiller{},
+}
+
+// The statuses of a Fulfillment. An open fulfillment is waiting on someone,
+// such as a parcel to be shipped; the order is fulfilled by hand once it is
+// done.
+const (
+	FulfillmentPending = "pending"
+	FulfillmentOpen    = "open"
+	FulfillmentDone    = "done"
+)
+
+// ActorFulfillment is the audit actor of orders fulfilled automatically.
+const ActorFulfillment = "fulfillment"
+
+// fulfillOrder runs the fulfiller of every routed line of a paid order
+// that hasn't been fulfilled yet. Lines that fail are returned as an error
+// so the job retries them; lines already done are skipped. The order is
+// marked fulfilled once every line has a route and all of them are done.
+func (svc *Service) fulfillOrder(ctx context.Context, orderID string) error {
+	o, err := svc.Payments.GetOrder(ctx, orderID)
+	if err == ErrOrderNotFound {
+		slog.Warn("dropping fulfillment of an unknown order", "order", orderID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if o.Status != OrderPaid {
+		slog.Info("skipping fulfillment", "order", o.ID, "status", o.Status)
+		return nil
+	}
+	// Held orders are queued again when their review is approved.
+	hold, err := svc.fulfillmentHold(ctx, o)
+	if err != nil {
+		return err
+	}
+	if hold != "" {
+		slog.Warn("holding fulfillment", "order", o.ID, "reason", hold)
+		return nil
+	}
+	existing, err := svc.Payments.ListFulfillments(ctx, o.ID)
+	if err != nil {
+		return err
+	}
+	byPrice := map[string]*Fulfillment{}
+	for _, f := range existing {
+		byPrice[f.Price] = f
+	}
+	complete := len(o.Items) > 0
+	var errs []error
+	for _, item := range o.Items {
+		route := svc.fulfillmentRoute(ctx, item.Price)
+		if route == nil {
+			complete = false
+			continue
+		}
+		f := byPrice[item.Price]
+		if f == nil {
+			f = &Fulfillment{
+				ID:       "ful_" + NewRequestID(),
+				OrderID:  o.ID,
+				Price:    item.Price,
+				Type:     route.Type,
+				Target:   route.Target,
+				Quantity: item.Quantity,
+				Status:   FulfillmentPending,
+				Details:  map[string]string{},
+			}
+			if p, ok := svc.CatalogFor(ctx).Price(item.Price); ok {
+				f.Product = p.Product
+			}
+		}
+		if f.Status != FulfillmentPending {
+			complete = complete && f.Status == FulfillmentDone
+			continue
+		}
+		fulfiller, ok := fulfillers[f.Type]
+		if !ok {
+			return fmt.Errorf("fulfillment %s: unknown type %q", f.ID, f.Type)
+		}
+		done, err := fulfiller.Fulfill(ctx, svc, o, f)
+		if err != nil {
+			f.LastError = err.Error()
+			errs = append(errs, fmt.Errorf("fulfilling %s of order %s: %w", f.Price, o.ID, err))
+		} else if done {
+			f.Status, f.LastError = FulfillmentDone, ""
+		} else {
+			f.Status, f.LastError = FulfillmentOpen, ""
+		}
+		if err := svc.Payments.SaveFulfillment(ctx, f); err != nil {
+			return fmt.Errorf("saving fulfillment: %w", err)
+		}
+		complete = complete && f.Status == FulfillmentDone
+		if err == nil {
+			slog.Info("order line fulfilled", "order", o.ID, "fulfillment", f.ID, "type", f.Type, "status", f.Status)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if !complete {
+		return nil
+	}
+	before := *o
+	if err := o.Transition(OrderFulfilled); err != nil {
+		return err
+	}
+	if err := svc.Payments.SaveOrder(ctx, o); err != nil {
+		return err
+	}
+	svc.RecordAudit(ctx, ActorFulfillment, "order.fulfilled", o.ID, &before, o)
+	slog.Info("order fulfilled", "order", o.ID)
+	return nil
+}
+
+// FulfillmentView is what the order status tells a customer about one
+// fulfilled line: where to download it, or their license key.
+type FulfillmentView struct {
+	Type        string `json:"type"`
+	Price       string `json:"price"`
+	Quantity    int64  `json:"quantity"`
+	Status      string `json:"status"`
+	DownloadURL string `json:"downloadUrl,omitempty"`
+	LicenseKey  string `json:"licenseKey,omitempty"`
+}
+
+func (svc *Service) FulfillmentView(f *Fulfillment) FulfillmentView {
+	v := FulfillmentView{Type: f.Type, Price: f.Price, Quantity: f.Quantity, Status: f.Status}
+	if f.Status != FulfillmentDone {
+		return v
+	}
+	switch f.Type {
+	case "download":
+		v.DownloadURL = svc.downloadURL(f.ID)
+	case "license":
+		v.LicenseKey = f.Details["key"]
+	}
+	return v
+}
+
+// downloadFulfiller gives the customer a link to the route's file in
+// DOWNLOADS_DIR. Links are signed on demand, so each one shown on the order
+// status lasts DOWNLOAD_LINK_TTL.
+type downloadFulfiller struct{}
+
+func (downloadFulfiller) Fulfill(ctx context.Context, svc *Service, o *Order, f *Fulfillment) (bool, error) {
+	if _, err := os.Stat(filepath.Join(svc.Config.DownloadsDir, f.Target)); err != nil {
+		return false, err
+	}
+	f.Details["file"] = f.Target
+	return true, nil
+}
+
+// DownloadToken signs a download link the way ReceiptToken signs receipt
+// links: "<expiry>.<hmac>".
+func (svc *Service) DownloadToken(fulfillmentID string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + svc.downloadSignature(fulfillmentID, exp)
+}
+
+func (svc *Service) downloadSignature(fulfillmentID, exp string) string {
+	mac := hmac.New(sha256.New, []byte(svc.Config.DownloadSigningKey))
+	mac.Write([]byte("download." + fulfillmentID + "." + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (svc *Service) VerifyDownloadToken(fulfillmentID, token string, now time.Time) error {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(svc.downloadSignature(fulfillmentID, exp))) {
+		return errors.New("invalid download link")
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.After(time.Unix(unix, 0)) {
+		return errors.New("download link has expired")
+	}
+	return nil
+}
+
+func (svc *Service) downloadURL(fulfillmentID string) string {
+	token := svc.DownloadToken(fulfillmentID, time.Now().Add(svc.Config.DownloadLinkTTL))
+	return svc.Config.Domain + "/downloads/" + fulfillmentID + "?token=" + url.QueryEscape(token)
+}
+
+// shipmentFulfiller opens a shipment ticket and sends it to the operators
+// as a fulfillment.shipment notification. The order is marked fulfilled by
+// hand, with POST /admin/orders/{id}/fulfill, once the parcel is on its way.
+type shipmentFulfiller struct{}
+
+func (shipmentFulfiller) Fulfill(ctx context.Context, svc *Service, o *Order, f *Fulfillment) (bool, error) {
+	ticket := "shp_" + NewRequestID()
+	name := svc.CatalogFor(ctx).ProductName(f.Product)
+	if name == "" {
+		name = f.Price
+	}
+	lines := []string{
+		"Ticket: " + ticket,
+		"Order: " + o.ID,
+		fmt.Sprintf("Item: %d x %s", f.Quantity, name),
+	}
+	if s := o.Shipping; s != nil {
+		lines = append(lines, "Ship to: "+strings.Join(nonEmpty(s.Name, s.Line1, s.Line2, s.City, s.State, s.PostalCode, s.Country), ", "))
+	} else {
+		lines = append(lines, "Ship to: no shipping address collected")
+	}
+	if err := svc.NotifyOps(ctx, config.NotifyShipmentRequested, "Shipment requested for order "+o.ID, lines...); err != nil {
+		return false, err
+	}
+	f.Details["ticket"] = ticket
+	return false, nil
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
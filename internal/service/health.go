@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// readinessTimeout bounds each dependency check of /readyz, so a hung
+// database or Stripe doesn't hold up the probe past its own timeout.
+const readinessTimeout = 3 * time.Second
+
+// HealthResponse is returned by /healthz and /readyz. Mode is the configured
+// Stripe mode, so probes and dashboards show which environment answered.
+// Checks maps each readiness check to "ok" or what is wrong; Problems lists
+// the failures.
+type HealthResponse struct {
+	Status   string            `json:"status"`
+	Mode     string            `json:"mode,omitempty"`
+	Checks   map[string]string `json:"checks,omitempty"`
+	Problems []string          `json:"problems,omitempty"`
+}
+
+// readinessCheck is one dependency /readyz checks.
+type readinessCheck struct {
+	name  string
+	check func(svc *Service, ctx context.Context) []string
+}
+
+// readinessChecks are what stops the server from handling requests
+// correctly, so a bad deploy shows up in the probe rather than as errors in
+// the middle of checkouts and webhook deliveries.
+var readinessChecks = []readinessCheck{
+	{"config", (*Service).checkConfig},
+	{"webhook_secret", (*Service).checkWebhookSecret},
+	{"database", (*Service).checkDatabase},
+	{"stripe", (*Service).checkStripe},
+}
+
+func (svc *Service) checkConfig(ctx context.Context) []string {
+	err := svc.Config.Validate()
+	if err == nil {
+		return nil
+	}
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		return []string{err.Error()}
+	}
+	var problems []string
+	for _, e := range joined.Unwrap() {
+		problems = append(problems, e.Error())
+	}
+	return problems
+}
+
+func (svc *Service) checkWebhookSecret(ctx context.Context) []string {
+	if len(svc.WebhookSecrets(ctx)) == 0 {
+		return []string{"STRIPE_WEBHOOK_SECRET is not set, so webhook deliveries are rejected"}
+	}
+	return nil
+}
+
+func (svc *Service) checkDatabase(ctx context.Context) []string {
+	if err := svc.Payments.Ping(ctx); err != nil {
+		return []string{fmt.Sprintf("payment store unreachable: %v", err)}
+	}
+	return nil
+}
+
+// checkStripe fetches PRICE, which shows both that the API answers with our
+// key and that the price the storefront sells still exists.
+func (svc *Service) checkStripe(ctx context.Context) []string {
+	price := svc.ConfiguredPrice()
+	if _, ok := svc.Catalog.Price(price); !ok {
+		return []string{fmt.Sprintf("PRICE %s is not an active price in the catalog", price)}
+	}
+	params := &stripe.PriceParams{}
+	p, err := svc.Stripe.GetPrice(ctx, price, params)
+	if err != nil {
+		return []string{fmt.Sprintf("fetching PRICE %s from Stripe: %v", price, err)}
+	}
+	if !p.Active {
+		return []string{fmt.Sprintf("PRICE %s is archived in Stripe", price)}
+	}
+	return nil
+}
+
+// Readiness runs every check and returns their results.
+func (svc *Service) Readiness(ctx context.Context) (map[string]string, []string) {
+	if svc.Config == nil {
+		return map[string]string{"config": "not loaded"}, []string{"configuration not loaded"}
+	}
+	checks := map[string]string{}
+	var problems []string
+	for _, c := range readinessChecks {
+		checkCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		failed := c.check(svc, checkCtx)
+		cancel()
+		if len(failed) == 0 {
+			checks[c.name] = "ok"
+			continue
+		}
+		checks[c.name] = failed[0]
+		problems = append(problems, failed...)
+	}
+	return checks, problems
+}
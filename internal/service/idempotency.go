@@ -0,0 +1,31 @@
+package service
+
+import "context"
+
+// MaxIdempotencyKeyLength is the longest Idempotency-Key accepted, the same
+// limit Stripe applies to its own.
+const MaxIdempotencyKeyLength = 255
+
+type idempotencyKeyCtx struct{}
+
+// WithIdempotencyKey returns ctx carrying the client's Idempotency-Key.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+// StripeIdempotencyKey returns the idempotency key for the Stripe write op
+// made while serving ctx. It is derived from the client's Idempotency-Key
+// when the request has one, so a retry that gets past our own replay (after
+// a 5xx) can't repeat the write. Otherwise it is the request ID, which finds
+// the write in Stripe's request logs, plus a random part, as clients may
+// send the same X-Request-ID with different requests; it still makes the
+// Stripe client's own retries of the call safe.
+func StripeIdempotencyKey(ctx context.Context, op string) string {
+	if key, _ := ctx.Value(idempotencyKeyCtx{}).(string); key != "" {
+		return op + ":" + key
+	}
+	if id := requestID(ctx); id != "" {
+		return op + ":" + id + ":" + NewRequestID()
+	}
+	return op + ":" + NewRequestID()
+}
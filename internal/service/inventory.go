@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// reservationGrace keeps a reservation a little past its session's expiry so
+// a checkout completed at the last moment still finds it.
+const reservationGrace = 10 * time.Minute
+
+// SeedInventory starts tracking the stock configured in INVENTORY for prices
+// the database doesn't track yet.
+func (svc *Service) SeedInventory(ctx context.Context) error {
+	for price, stock := range svc.Config.Inventory {
+		if _, ok := svc.Catalog.Price(price); !ok {
+			slog.Warn("INVENTORY names a price that isn't in the catalog", "price", price)
+		}
+		if err := svc.Payments.SeedStock(ctx, price, stock); err != nil {
+			return fmt.Errorf("seeding stock of %s: %w", price, err)
+		}
+	}
+	return nil
+}
+
+// reserveStock holds the tracked prices of a cart for the lifetime of its
+// checkout session. It returns the reservation ID and the time the session
+// should expire, or "" when nothing in the cart is tracked.
+func (svc *Service) reserveStock(ctx context.Context, items []*stripe.CheckoutSessionLineItemParams) (string, time.Time, error) {
+	quantities := map[string]int64{}
+	for _, li := range items {
+		quantities[stripe.StringValue(li.Price)] += stripe.Int64Value(li.Quantity)
+	}
+	expiresAt := time.Now().Add(svc.Config.InventoryReservationTTL)
+	// The session ID isn't known until Stripe creates it, so the stock is
+	// held under a temporary ID first.
+	id := "pending_" + NewRequestID()
+	held, err := svc.Payments.Reserve(ctx, id, quantities, expiresAt.Add(reservationGrace))
+	if err != nil || !held {
+		return "", time.Time{}, err
+	}
+	return id, expiresAt, nil
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// Limits of an invoice created through POST /admin/invoices. Stripe takes at
+// most 250 items per invoice.
+const (
+	maxInvoiceItems            = 250
+	DefaultInvoiceDaysUntilDue = 30
+	maxInvoiceDaysUntilDue     = 365
+)
+
+// Invoice is the local copy of a Stripe invoice created through
+// /admin/invoices to bill a B2B customer outside Checkout, kept current by
+// the invoice webhooks. Status is Stripe's: draft, open, paid, void or
+// uncollectible. Open invoices are the outstanding ones.
+type Invoice struct {
+	ID            string     `json:"id"`
+	CustomerID    string     `json:"customerId"`
+	CustomerEmail string     `json:"customerEmail,omitempty"`
+	Number        string     `json:"number,omitempty"`
+	Status        string     `json:"status"`
+	Currency      string     `json:"currency"`
+	Total         int64      `json:"total"`
+	AmountDue     int64      `json:"amountDue"`
+	AmountPaid    int64      `json:"amountPaid"`
+	DueDate       *time.Time `json:"dueDate,omitempty"`
+	Memo          string     `json:"memo,omitempty"`
+	HostedURL     string     `json:"hostedUrl,omitempty"`
+	PDFURL        string     `json:"pdfUrl,omitempty"`
+	AttemptCount  int64      `json:"attemptCount,omitempty"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+	PaidAt        *time.Time `json:"paidAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// InvoiceLine is one line of an invoice: Quantity, which defaults to 1,
+// times UnitAmount in the invoice's currency.
+type InvoiceLine struct {
+	Description string `json:"description"`
+	UnitAmount  int64  `json:"unitAmount"`
+	Quantity    int64  `json:"quantity"`
+}
+
+// InvoiceRequest is the JSON body accepted by POST /admin/invoices. The
+// invoice is due on DueDate, or DaysUntilDue days after it is finalized,
+// 30 by default. Memo is shown on the invoice.
+type InvoiceRequest struct {
+	Customer     string            `json:"customer"`
+	Currency     string            `json:"currency"`
+	Items        []InvoiceLine     `json:"items"`
+	DueDate      time.Time         `json:"dueDate"`
+	DaysUntilDue int64             `json:"daysUntilDue"`
+	Memo         string            `json:"memo"`
+	Metadata     map[string]string `json:"metadata"`
+}
+
+func (req *InvoiceRequest) Validate(ctx context.Context, svc *Service) error {
+	if err := ValidateStripeID(req.Customer, "cus_", "customer"); err != nil {
+		return err
+	}
+	req.Currency = strings.ToLower(req.Currency)
+	if !config.CurrencyPattern.MatchString(req.Currency) {
+		return fmt.Errorf("invalid currency %q", req.Currency)
+	}
+	if len(req.Items) == 0 || len(req.Items) > maxInvoiceItems {
+		return fmt.Errorf("an invoice needs between 1 and %d items", maxInvoiceItems)
+	}
+	for i := range req.Items {
+		item := &req.Items[i]
+		if item.Quantity == 0 {
+			item.Quantity = 1
+		}
+		switch {
+		case strings.TrimSpace(item.Description) == "":
+			return fmt.Errorf("items[%d]: description is required", i)
+		case item.UnitAmount < 1:
+			return fmt.Errorf("items[%d]: unitAmount must be positive", i)
+		case item.Quantity < 1:
+			return fmt.Errorf("items[%d]: quantity must be positive", i)
+		}
+	}
+	switch {
+	case !req.DueDate.IsZero() && req.DaysUntilDue != 0:
+		return errors.New("set dueDate or daysUntilDue, not both")
+	case !req.DueDate.IsZero() && !req.DueDate.After(time.Now()):
+		return errors.New("dueDate must be in the future")
+	case req.DueDate.IsZero() && req.DaysUntilDue == 0:
+		req.DaysUntilDue = DefaultInvoiceDaysUntilDue
+	}
+	if req.DueDate.IsZero() && (req.DaysUntilDue < 1 || req.DaysUntilDue > maxInvoiceDaysUntilDue) {
+		return fmt.Errorf("daysUntilDue must be between 1 and %d", maxInvoiceDaysUntilDue)
+	}
+	return validateMetadata(req.Metadata)
+}
+
+// invoiceFromStripe converts a Stripe invoice to the local record, without
+// SentAt, which is only known to the service that sent it.
+func invoiceFromStripe(inv *stripe.Invoice) *Invoice {
+	rec := &Invoice{
+		ID:            inv.ID,
+		CustomerEmail: inv.CustomerEmail,
+		Number:        inv.Number,
+		Status:        string(inv.Status),
+		Currency:      string(inv.Currency),
+		Total:         inv.Total,
+		AmountDue:     inv.AmountDue,
+		AmountPaid:    inv.AmountPaid,
+		DueDate:       optionalTime(inv.DueDate),
+		Memo:          inv.Description,
+		HostedURL:     inv.HostedInvoiceURL,
+		PDFURL:        inv.InvoicePDF,
+		AttemptCount:  inv.AttemptCount,
+		PaidAt:        optionalTime(inv.StatusTransitions.PaidAt),
+	}
+	if inv.Customer != nil {
+		rec.CustomerID = inv.Customer.ID
+	}
+	if inv.Created != 0 {
+		rec.CreatedAt = time.Unix(inv.Created, 0).UTC()
+	}
+	return rec
+}
+
+// LockInvoice serializes the admin actions and webhooks of invoice id, so
+// a send racing Stripe's invoice.finalized event doesn't lose SentAt. Call
+// the returned func to unlock it.
+func (svc *Service) LockInvoice(id string) (unlock func()) {
+	return svc.invoiceLocks.Lock(id)
+}
+
+// ListInvoices returns a page of the invoices matching f and whether there
+// is another page.
+func (svc *Service) ListInvoices(ctx context.Context, f InvoiceFilter) ([]*Invoice, bool, error) {
+	// Fetch one extra row to know whether there is another page.
+	limit := f.Limit
+	f.Limit++
+	list, err := svc.Payments.ListInvoices(ctx, f)
+	if err != nil {
+		return nil, false, InternalError("listing invoices", err)
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	if list == nil {
+		list = []*Invoice{}
+	}
+	return list, hasMore, nil
+}
+
+// GetInvoice returns the local copy of invoice id.
+func (svc *Service) GetInvoice(ctx context.Context, id string) (*Invoice, error) {
+	inv, err := svc.Payments.GetInvoice(ctx, id)
+	if err != nil && err != ErrInvoiceNotFound {
+		return nil, InternalError("fetching invoice", err)
+	}
+	return inv, err
+}
+
+// FinalizeInvoice turns draft invoice id into an open invoice that can no
+// longer be edited.
+func (svc *Service) FinalizeInvoice(ctx context.Context, id string) (*Invoice, error) {
+	return svc.advanceInvoice(ctx, id, false)
+}
+
+// SendInvoice finalizes invoice id if it is still a draft and emails it to
+// the customer.
+func (svc *Service) SendInvoice(ctx context.Context, id string) (*Invoice, error) {
+	return svc.advanceInvoice(ctx, id, true)
+}
+
+func (svc *Service) advanceInvoice(ctx context.Context, id string, send bool) (*Invoice, error) {
+	defer svc.LockInvoice(id)()
+	local, err := svc.GetInvoice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	draft := local.Status == string(stripe.InvoiceStatusDraft)
+	if !send && !draft {
+		return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("invoice is %s; only drafts can be finalized", local.Status)}
+	}
+	if send && !draft && local.Status != string(stripe.InvoiceStatusOpen) {
+		return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("invoice is %s; only drafts and open invoices can be sent", local.Status)}
+	}
+	var inv *stripe.Invoice
+	if draft {
+		params := &stripe.InvoiceFinalizeParams{AutoAdvance: stripe.Bool(false)}
+		params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "finalize_invoice"))
+		if inv, err = svc.Stripe.FinalizeInvoice(ctx, local.ID, params); err != nil {
+			return nil, &StripeFailure{"finalizing invoice", err}
+		}
+	}
+	if send {
+		params := &stripe.InvoiceSendParams{}
+		params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "send_invoice"))
+		if inv, err = svc.Stripe.SendInvoice(ctx, local.ID, params); err != nil {
+			return nil, &StripeFailure{"sending invoice", err}
+		}
+	}
+	ctx = context.WithoutCancel(ctx)
+	keep, auditAction := *local, "invoice.finalized"
+	if send {
+		now := time.Now().UTC()
+		keep.SentAt, auditAction = &now, "invoice.sent"
+	}
+	rec, err := svc.SaveInvoice(ctx, inv, &keep)
+	if err != nil {
+		return nil, err
+	}
+	LogCtx(ctx).Info(strings.Replace(auditAction, ".", " ", 1), "invoice", rec.ID, "status", rec.Status)
+	svc.RecordAudit(ctx, AuditActor(ctx), auditAction, rec.ID, local, rec)
+	return rec, nil
+}
+
+// SaveInvoice stores what Stripe returned for inv, keeping the fields only
+// the local record has.
+func (svc *Service) SaveInvoice(ctx context.Context, inv *stripe.Invoice, local *Invoice) (*Invoice, error) {
+	rec := invoiceFromStripe(inv)
+	if local != nil {
+		rec.SentAt, rec.CreatedAt = local.SentAt, local.CreatedAt
+	}
+	if err := svc.Payments.SaveInvoice(ctx, rec); err != nil {
+		return nil, InternalError("saving invoice", err)
+	}
+	return rec, nil
+}
+
+// CreateInvoice creates a draft invoice for req in Stripe, adds its lines
+// and stores it. Drafts aren't shown to the customer until they are
+// finalized and sent.
+func (svc *Service) CreateInvoice(ctx context.Context, req *InvoiceRequest) (*Invoice, error) {
+	params := &stripe.InvoiceParams{
+		Customer:                    stripe.String(req.Customer),
+		Currency:                    stripe.String(req.Currency),
+		CollectionMethod:            stripe.String(string(stripe.InvoiceCollectionMethodSendInvoice)),
+		AutoAdvance:                 stripe.Bool(false),
+		PendingInvoiceItemsBehavior: stripe.String("exclude"),
+	}
+	if req.DueDate.IsZero() {
+		params.DaysUntilDue = stripe.Int64(req.DaysUntilDue)
+	} else {
+		params.DueDate = stripe.Int64(req.DueDate.Unix())
+	}
+	if req.Memo != "" {
+		params.Description = stripe.String(req.Memo)
+	}
+	for k, v := range req.Metadata {
+		params.AddMetadata(k, v)
+	}
+	params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "invoice"))
+	draft, err := svc.Stripe.NewInvoice(ctx, params)
+	if err != nil {
+		return nil, &StripeFailure{"creating invoice", err}
+	}
+	for i, line := range req.Items {
+		item := &stripe.InvoiceItemParams{
+			Customer:    stripe.String(req.Customer),
+			Invoice:     stripe.String(draft.ID),
+			Currency:    stripe.String(req.Currency),
+			Description: stripe.String(line.Description),
+			UnitAmount:  stripe.Int64(line.UnitAmount),
+			Quantity:    stripe.Int64(line.Quantity),
+		}
+		item.SetIdempotencyKey(StripeIdempotencyKey(ctx, fmt.Sprintf("invoice_item_%d", i)))
+		if _, err := svc.Stripe.NewInvoiceItem(ctx, item); err != nil {
+			return nil, &StripeFailure{"adding invoice item", err}
+		}
+	}
+	// The draft returned by NewInvoice predates its items.
+	inv, err := svc.Stripe.GetInvoice(ctx, draft.ID, nil)
+	if err != nil {
+		return nil, &StripeFailure{"fetching invoice", err}
+	}
+	ctx = context.WithoutCancel(ctx)
+	rec, err := svc.SaveInvoice(ctx, inv, nil)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "invoice created", "invoice", rec.ID, "customer", rec.CustomerID, "total", rec.Total, "currency", rec.Currency)
+	svc.RecordAudit(ctx, AuditActor(ctx), "invoice.created", rec.ID, nil, rec)
+	return rec, nil
+}
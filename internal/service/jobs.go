@@ -0,0 +1,627 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/stripeclient"
+)
+
+// Job is a unit of background work, such as sending an email, that must
+// eventually succeed even though the webhook that triggered it has already
+// been acknowledged.
+type Job struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	NextRunAt time.Time       `json:"nextRunAt"`
+	LastError string          `json:"lastError,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	// Tenant is the ID of the tenant the job was queued for, whose Stripe
+	// account it works on; empty for the default account.
+	Tenant string `json:"tenant,omitempty"`
+	// StripeAccount is the connected account the job acts on, when it was
+	// queued by a request or event acting on one.
+	StripeAccount string `json:"stripeAccount,omitempty"`
+	// TraceContext carries the trace of the request or event that queued
+	// the job, which its attempts continue.
+	TraceContext map[string]string `json:"traceContext,omitempty"`
+	// Event is the Stripe event whose handlers queued the job. Such jobs
+	// are named after the event, so handling it again doesn't queue them
+	// twice.
+	Event string `json:"event,omitempty"`
+	// Named is set when the ID was derived from what the job does rather
+	// than picked at random. A job of the same name isn't queued again
+	// while it is pending or dead, or for a while after it ran.
+	Named bool `json:"named,omitempty"`
+}
+
+// JobHandler runs a job of one type. Returning an error schedules a retry.
+// ctx is cancelled when the server shuts down.
+type JobHandler func(ctx context.Context, payload json.RawMessage) error
+
+// JobQueue runs jobs in the background, retrying failures with exponential
+// backoff and moving jobs that keep failing to a dead-letter list. The queue
+// is snapshotted to a file or the database after every change so jobs
+// survive restarts.
+type JobQueue struct {
+	mu          sync.Mutex
+	storage     jobStorage
+	handlers    map[string]JobHandler
+	pending     []*Job
+	dead        []*Job
+	wake        chan struct{}
+	maxAttempts int
+	backoff     time.Duration
+	// tenants looks up the tenant a job was queued for.
+	tenants func(id string) *config.Tenant
+	// done remembers the named jobs that succeeded until they expire, so
+	// they aren't queued again, e.g. by a redelivery of their event.
+	done    map[string]time.Time
+	doneTTL time.Duration
+}
+
+type jobQueueSnapshot struct {
+	Pending []*Job               `json:"pending"`
+	Dead    []*Job               `json:"dead"`
+	Done    map[string]time.Time `json:"done,omitempty"`
+}
+
+// jobStorage holds the snapshot of a JobQueue. load returns nil if nothing
+// was saved yet.
+type jobStorage interface {
+	load() ([]byte, error)
+	save(data []byte) error
+}
+
+// fileJobStorage keeps the snapshot in a file, replaced atomically.
+type fileJobStorage struct{ path string }
+
+func (f fileJobStorage) load() ([]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f fileJobStorage) save(data []byte) error {
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// dbJobStorage keeps the snapshot in the payment store. Snapshots are saved
+// whatever the context of the request that enqueued a job, so it uses none.
+// Only one instance can own the snapshot, which is why it isn't allowed on
+// Postgres.
+type dbJobStorage struct{ store PaymentStore }
+
+func (d dbJobStorage) load() ([]byte, error) {
+	return d.store.LoadJobQueue(context.Background())
+}
+
+func (d dbJobStorage) save(data []byte) error {
+	return d.store.SaveJobQueue(context.Background(), data)
+}
+
+// NewJobQueue loads the queue persisted at path, if any. An empty path keeps
+// the queue in memory only.
+func NewJobQueue(path string, maxAttempts int, backoff time.Duration) (*JobQueue, error) {
+	var storage jobStorage
+	if path != "" {
+		storage = fileJobStorage{path}
+	}
+	return newJobQueue(storage, maxAttempts, backoff)
+}
+
+// newJobQueue loads the queue saved in storage, which may be nil to keep it
+// in memory only.
+func newJobQueue(storage jobStorage, maxAttempts int, backoff time.Duration) (*JobQueue, error) {
+	q := &JobQueue{
+		storage:     storage,
+		handlers:    map[string]JobHandler{},
+		wake:        make(chan struct{}, 1),
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		done:        map[string]time.Time{},
+		doneTTL:     defaultJobDoneTTL,
+	}
+	if storage == nil {
+		return q, nil
+	}
+	data, err := storage.load()
+	if err != nil {
+		return nil, fmt.Errorf("reading job queue: %w", err)
+	}
+	if data == nil {
+		return q, nil
+	}
+	var snap jobQueueSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("reading job queue: %w", err)
+	}
+	q.pending, q.dead = snap.Pending, snap.Dead
+	if snap.Done != nil {
+		q.done = snap.Done
+	}
+	return q, nil
+}
+
+// NewJobQueueFromConfig configures the queue from JOB_QUEUE_BACKEND,
+// JOB_QUEUE_FILE, JOB_MAX_ATTEMPTS and JOB_RETRY_BACKOFF. The database
+// backend keeps the queue in store.
+func NewJobQueueFromConfig(c *config.Config, store PaymentStore) (*JobQueue, error) {
+	var q *JobQueue
+	var err error
+	switch c.JobQueueBackend {
+	case "", "file":
+		q, err = NewJobQueue(c.JobQueueFile, c.JobMaxAttempts, c.JobRetryBackoff)
+	case "database":
+		q, err = newJobQueue(dbJobStorage{store}, c.JobMaxAttempts, c.JobRetryBackoff)
+	default:
+		return nil, fmt.Errorf("unknown JOB_QUEUE_BACKEND %q", c.JobQueueBackend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Named jobs are remembered as long as events are.
+	q.doneTTL = c.EventDedupeTTL
+	return q, nil
+}
+
+// Handle registers fn for jobs of jobType.
+func (q *JobQueue) Handle(jobType string, fn JobHandler) {
+	q.handlers[jobType] = fn
+}
+
+// Enqueue schedules a job that runs as soon as a worker is free, for the
+// tenant of ctx. A job queued while handling a Stripe event is skipped if
+// an earlier delivery of the event already queued it.
+func (q *JobQueue) Enqueue(ctx context.Context, jobType string, payload interface{}) error {
+	return q.EnqueueAt(ctx, jobType, payload, time.Now())
+}
+
+// EnqueueAt schedules a job that runs once at has passed.
+func (q *JobQueue) EnqueueAt(ctx context.Context, jobType string, payload interface{}, at time.Time) error {
+	return q.enqueue(ctx, "", jobType, payload, at)
+}
+
+// EnqueueNamed schedules a job like EnqueueAt, named name rather than at
+// random, unless a job of that name is already pending or dead or ran
+// within the queue's dedupe window. It lets work that may be asked for
+// twice, such as from a request and a webhook, be queued once.
+func (q *JobQueue) EnqueueNamed(ctx context.Context, name, jobType string, payload interface{}, at time.Time) error {
+	return q.enqueue(ctx, jobName(name), jobType, payload, at)
+}
+
+// Queued reports whether the job EnqueueNamed named name is pending or
+// dead, or ran within the queue's dedupe window.
+func (q *JobQueue) Queued(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued(jobName(name))
+}
+
+// jobName returns the ID of the job named name.
+func jobName(name string) string {
+	return "job_" + config.SHA256Hex(name)[:24]
+}
+
+// enqueue schedules a job with the ID id, which is random when empty and
+// not set by the event being handled.
+func (q *JobQueue) enqueue(ctx context.Context, id, jobType string, payload interface{}, at time.Time) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	job := &Job{
+		ID:           id,
+		Named:        id != "",
+		Type:         jobType,
+		Payload:      raw,
+		NextRunAt:    at.UTC(),
+		CreatedAt:    time.Now().UTC(),
+		TraceContext: jobTraceContext(ctx),
+	}
+	if t := TenantFrom(ctx); t != nil {
+		job.Tenant = t.ID
+	}
+	job.StripeAccount = stripeclient.AccountFrom(ctx)
+	if ej := eventJobsFrom(ctx); ej != nil {
+		job.Event = ej.event
+		if !job.Named {
+			job.ID, job.Named = ej.jobID(jobType), true
+		}
+	}
+	if job.ID == "" {
+		job.ID = NewRequestID()
+	}
+	q.mu.Lock()
+	if job.Named && q.queued(job.ID) {
+		q.mu.Unlock()
+		slog.Info("job already queued", "job", job.ID, "type", jobType, "event", job.Event)
+		return nil
+	}
+	q.pending = append(q.pending, job)
+	err = q.persist()
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	q.notify()
+	return nil
+}
+
+// queued reports whether the job named id is pending, dead or done. The
+// caller must hold q.mu.
+func (q *JobQueue) queued(id string) bool {
+	if exp, ok := q.done[id]; ok && time.Now().Before(exp) {
+		return true
+	}
+	for _, list := range [][]*Job{q.pending, q.dead} {
+		for _, j := range list {
+			if j.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (q *JobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes jobs until ctx is canceled.
+func (q *JobQueue) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, wait := q.next(time.Now())
+		if job != nil {
+			q.run(ctx, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RunDue runs the jobs that are due within ahead, and those they queue,
+// one after another until none is left. Run does this as jobs come due;
+// RunDue lets a caller drive the queue itself, as the tests do.
+func (q *JobQueue) RunDue(ctx context.Context, ahead time.Duration) {
+	for {
+		job, _ := q.next(time.Now().Add(ahead))
+		if job == nil {
+			return
+		}
+		q.run(ctx, job)
+	}
+}
+
+// next returns the first job that is due by now, or how long until one is.
+func (q *JobQueue) next(now time.Time) (*Job, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	wait := time.Minute
+	for _, job := range q.pending {
+		if !job.NextRunAt.After(now) {
+			return job, 0
+		}
+		if d := job.NextRunAt.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return nil, wait
+}
+
+func (q *JobQueue) run(ctx context.Context, job *Job) {
+	var err error
+	fn, ok := q.handlers[job.Type]
+	var tenant *config.Tenant
+	if q.tenants != nil {
+		tenant = q.tenants(job.Tenant)
+	}
+	spanCtx, span := jobSpan(ctx, job)
+	switch {
+	case !ok:
+		err = fmt.Errorf("no handler for job type %q", job.Type)
+	case job.Tenant != "" && tenant == nil:
+		err = fmt.Errorf("unknown tenant %q", job.Tenant)
+	default:
+		err = fn(stripeclient.WithAccount(WithTenant(spanCtx, tenant), job.StripeAccount), job.Payload)
+	}
+	stripeclient.EndSpan(span, err)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil && ctx.Err() != nil {
+		// Cut short by shutdown: the job runs again, without losing an
+		// attempt, when the queue next starts.
+		slog.Info("job interrupted", "job", job.ID, "type", job.Type, "error", err)
+		return
+	}
+	job.Attempts++
+	if err == nil {
+		q.remove(job)
+		if job.Named {
+			q.markDone(job.ID)
+		}
+	} else {
+		job.LastError = err.Error()
+		if job.Attempts >= q.maxAttempts {
+			slog.Error("job failed permanently", "job", job.ID, "type", job.Type, "attempts", job.Attempts, "error", err)
+			q.remove(job)
+			q.dead = append(q.dead, job)
+		} else {
+			delay := q.backoff << (job.Attempts - 1)
+			job.NextRunAt = time.Now().UTC().Add(delay)
+			slog.Warn("job failed, retrying", "job", job.ID, "type", job.Type, "attempt", job.Attempts, "retry_in", delay, "error", err)
+		}
+	}
+	if err := q.persist(); err != nil {
+		slog.Error("persisting job queue", "error", err)
+	}
+}
+
+func (q *JobQueue) remove(job *Job) {
+	for i, j := range q.pending {
+		if j == job {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// markDone remembers that the named job id succeeded, and forgets
+// those that expired. The caller must hold q.mu.
+func (q *JobQueue) markDone(id string) {
+	now := time.Now()
+	for done, exp := range q.done {
+		if now.After(exp) {
+			delete(q.done, done)
+		}
+	}
+	q.done[id] = now.Add(q.doneTTL).UTC()
+}
+
+// persist saves the queue to its storage. The caller must hold q.mu.
+func (q *JobQueue) persist() error {
+	if q.storage == nil {
+		return nil
+	}
+	data, err := json.Marshal(jobQueueSnapshot{Pending: q.pending, Dead: q.dead, Done: q.done})
+	if err != nil {
+		return err
+	}
+	return q.storage.save(data)
+}
+
+// Snapshot returns copies of the pending and dead-lettered jobs.
+func (q *JobQueue) Snapshot() (pending, dead []Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending, dead = []Job{}, []Job{}
+	for _, j := range q.pending {
+		pending = append(pending, *j)
+	}
+	for _, j := range q.dead {
+		dead = append(dead, *j)
+	}
+	return pending, dead
+}
+
+// Retry moves a dead-lettered job back onto the queue.
+func (q *JobQueue) Retry(id string) bool {
+	q.mu.Lock()
+	found := false
+	for i, j := range q.dead {
+		if j.ID == id {
+			q.dead = append(q.dead[:i], q.dead[i+1:]...)
+			j.Attempts = 0
+			j.NextRunAt = time.Now().UTC()
+			q.pending = append(q.pending, j)
+			found = true
+			break
+		}
+	}
+	if found {
+		if err := q.persist(); err != nil {
+			slog.Error("persisting job queue", "error", err)
+		}
+	}
+	q.mu.Unlock()
+	if found {
+		q.notify()
+	}
+	return found
+}
+
+// defaultJobDoneTTL is how long a queue not configured with
+// EVENT_DEDUPE_TTL remembers the named jobs that succeeded.
+const defaultJobDoneTTL = 24 * time.Hour
+
+// eventJobs names the jobs queued while a Stripe event is handled after the
+// event, their type and how many of that type came before, so handling the
+// event again names them the same.
+type eventJobs struct {
+	event string
+	mu    sync.Mutex
+	count map[string]int
+}
+
+type eventJobsKey struct{}
+
+// withEventJobs returns ctx for handling the event eventID, in which queued
+// jobs are named after it.
+func withEventJobs(ctx context.Context, eventID string) context.Context {
+	return context.WithValue(ctx, eventJobsKey{}, &eventJobs{event: eventID, count: map[string]int{}})
+}
+
+func eventJobsFrom(ctx context.Context) *eventJobs {
+	ej, _ := ctx.Value(eventJobsKey{}).(*eventJobs)
+	return ej
+}
+
+// jobID names the next job of jobType queued for the event.
+func (ej *eventJobs) jobID(jobType string) string {
+	ej.mu.Lock()
+	n := ej.count[jobType]
+	ej.count[jobType]++
+	ej.mu.Unlock()
+	return jobName(fmt.Sprintf("%s:%s:%d", ej.event, jobType, n))
+}
+
+const (
+	JobSendConfirmationEmail   = "send_confirmation_email"
+	JobUpdatePaymentStatus     = "update_payment_status"
+	JobSendNotification        = "send_notification"
+	JobSavePaymentMethod       = "save_payment_method"
+	JobSendAuthenticationEmail = "send_authentication_email"
+	JobExpireAuthentication    = "expire_authentication"
+	JobDeliverWebhook          = "deliver_webhook"
+	JobFulfillOrder            = "fulfill_order"
+	jobSendDailyReport         = "send_daily_report"
+	JobDeactivatePaymentLink   = "deactivate_payment_link"
+	JobVerifyTaxIDs            = "verify_tax_ids"
+	JobSendRecoveryEmail       = "send_recovery_email"
+	JobReportUsage             = "report_usage"
+	JobPublishEvent            = "publish_event"
+	JobForwardEvent            = "forward_event"
+)
+
+func (svc *Service) RegisterJobHandlers() {
+	svc.Jobs.tenants = svc.TenantByID
+	svc.Jobs.Handle(JobSendConfirmationEmail, func(ctx context.Context, payload json.RawMessage) error {
+		var receipt Receipt
+		if err := json.Unmarshal(payload, &receipt); err != nil {
+			return err
+		}
+		return svc.SendConfirmationEmail(ctx, &receipt)
+	})
+	svc.Jobs.Handle(JobUpdatePaymentStatus, func(ctx context.Context, payload json.RawMessage) error {
+		var s stripe.CheckoutSession
+		if err := json.Unmarshal(payload, &s); err != nil {
+			return err
+		}
+		var paymentIntentID string
+		if s.PaymentIntent != nil {
+			paymentIntentID = s.PaymentIntent.ID
+		}
+		defer svc.WebhookRouter.paymentLocks.Lock(paymentLockKey(paymentIntentID, s.ID))()
+		return svc.UpdatePaymentStatus(ctx, &s)
+	})
+	svc.Jobs.Handle(JobSendNotification, func(ctx context.Context, payload json.RawMessage) error {
+		var n config.Notification
+		if err := json.Unmarshal(payload, &n); err != nil {
+			return err
+		}
+		return svc.SendNotification(ctx, &n)
+	})
+	svc.Jobs.Handle(JobSavePaymentMethod, func(ctx context.Context, payload json.RawMessage) error {
+		var m SavedPaymentMethod
+		if err := json.Unmarshal(payload, &m); err != nil {
+			return err
+		}
+		return svc.savePaymentMethod(ctx, &m)
+	})
+	svc.Jobs.Handle(JobSendAuthenticationEmail, func(ctx context.Context, payload json.RawMessage) error {
+		var a AuthenticationRequest
+		if err := json.Unmarshal(payload, &a); err != nil {
+			return err
+		}
+		return svc.sendAuthenticationEmail(ctx, &a)
+	})
+	svc.Jobs.Handle(JobExpireAuthentication, func(ctx context.Context, payload json.RawMessage) error {
+		var paymentIntentID string
+		if err := json.Unmarshal(payload, &paymentIntentID); err != nil {
+			return err
+		}
+		return svc.ExpireAuthentication(ctx, paymentIntentID)
+	})
+	svc.Jobs.Handle(JobSendRecoveryEmail, func(ctx context.Context, payload json.RawMessage) error {
+		var id string
+		if err := json.Unmarshal(payload, &id); err != nil {
+			return err
+		}
+		return svc.SendRecoveryEmail(ctx, id)
+	})
+	svc.Jobs.Handle(JobDeliverWebhook, func(ctx context.Context, payload json.RawMessage) error {
+		var id string
+		if err := json.Unmarshal(payload, &id); err != nil {
+			return err
+		}
+		return svc.DeliverWebhook(ctx, id)
+	})
+	svc.Jobs.Handle(JobFulfillOrder, func(ctx context.Context, payload json.RawMessage) error {
+		var id string
+		if err := json.Unmarshal(payload, &id); err != nil {
+			return err
+		}
+		return svc.fulfillOrder(ctx, id)
+	})
+	svc.Jobs.Handle(jobSendDailyReport, func(ctx context.Context, payload json.RawMessage) error {
+		var j ReportEmail
+		if err := json.Unmarshal(payload, &j); err != nil {
+			return err
+		}
+		return svc.sendDailyReport(ctx, &j)
+	})
+	svc.Jobs.Handle(JobDeactivatePaymentLink, func(ctx context.Context, payload json.RawMessage) error {
+		var id string
+		if err := json.Unmarshal(payload, &id); err != nil {
+			return err
+		}
+		return svc.deactivatePaymentLink(ctx, id)
+	})
+	svc.Jobs.Handle(JobVerifyTaxIDs, func(ctx context.Context, payload json.RawMessage) error {
+		var sessionID string
+		if err := json.Unmarshal(payload, &sessionID); err != nil {
+			return err
+		}
+		return svc.verifyTaxIDs(ctx, sessionID)
+	})
+	svc.Jobs.Handle(JobReportUsage, func(ctx context.Context, payload json.RawMessage) error {
+		var ids []string
+		if err := json.Unmarshal(payload, &ids); err != nil {
+			return err
+		}
+		return svc.reportUsage(ctx, ids)
+	})
+	svc.Jobs.Handle(JobPublishEvent, func(ctx context.Context, payload json.RawMessage) error {
+		var pe PlatformEvent
+		if err := json.Unmarshal(payload, &pe); err != nil {
+			return err
+		}
+		return svc.sendPlatformEvent(ctx, &pe)
+	})
+	svc.Jobs.Handle(JobForwardEvent, func(ctx context.Context, payload json.RawMessage) error {
+		var event forwardedEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return err
+		}
+		if event.Payload == nil {
+			// Queued as the bare event, before forwards kept the body.
+			event.Payload = payload
+		}
+		return svc.forwardEvent(ctx, &event)
+	})
+}
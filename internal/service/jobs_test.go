@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJobQueueRetriesThenDeadLetters(t *testing.T) {
+	q, err := NewJobQueue("", 3, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	q.Handle("flaky", func(context.Context, json.RawMessage) error {
+		calls++
+		return errors.New("still down")
+	})
+	if err := q.Enqueue(context.Background(), "flaky", map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		time.Sleep(time.Millisecond)
+		if job, _ := q.next(time.Now()); job != nil {
+			q.run(context.Background(), job)
+		}
+	}
+	pending, dead := q.Snapshot()
+	if calls != 3 || len(pending) != 0 || len(dead) != 1 {
+		t.Fatalf("calls %d, pending %d, dead %d; want 3, 0, 1", calls, len(pending), len(dead))
+	}
+	if dead[0].LastError != "still down" {
+		t.Errorf("last error = %q", dead[0].LastError)
+	}
+	if !q.Retry(dead[0].ID) {
+		t.Fatal("Retry didn't find the dead job")
+	}
+	if pending, _ := q.Snapshot(); len(pending) != 1 || pending[0].Attempts != 0 {
+		t.Errorf("pending after retry = %+v", pending)
+	}
+}
+
+func TestJobInterruptedByShutdown(t *testing.T) {
+	q, err := NewJobQueue("", 3, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Handle("email", func(ctx context.Context, _ json.RawMessage) error {
+		return ctx.Err()
+	})
+	if err := q.Enqueue(context.Background(), "email", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	job, _ := q.next(time.Now())
+	q.run(ctx, job)
+	pending, dead := q.Snapshot()
+	if len(pending) != 1 || pending[0].Attempts != 0 || len(dead) != 0 {
+		t.Fatalf("pending %+v, dead %+v; want the job pending with no attempts used", pending, dead)
+	}
+
+	// Run returns straight away rather than retrying it under the
+	// cancelled context.
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run kept going after its context was cancelled")
+	}
+}
+
+func TestJobQueuePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	q, err := NewJobQueue(path, 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(context.Background(), "email", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewJobQueue(path, 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := reloaded.Snapshot()
+	if len(pending) != 1 || pending[0].Type != "email" || string(pending[0].Payload) != `"hello"` {
+		t.Errorf("reloaded pending = %+v", pending)
+	}
+}
+
+func TestEventJobsQueuedOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	q, err := NewJobQueue(path, 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Handle("email", func(context.Context, json.RawMessage) error { return nil })
+	handle := func(q *JobQueue) {
+		t.Helper()
+		ctx := withEventJobs(context.Background(), "evt_1")
+		for _, to := range []string{"a", "b"} {
+			if err := q.Enqueue(ctx, "email", to); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	handle(q)
+	handle(q)
+	if pending, _ := q.Snapshot(); len(pending) != 2 || pending[0].Event != "evt_1" || pending[0].ID == pending[1].ID {
+		t.Fatalf("pending = %+v, want the event's two jobs", pending)
+	}
+	for job, _ := q.next(time.Now()); job != nil; job, _ = q.next(time.Now()) {
+		q.run(context.Background(), job)
+	}
+	// Jobs that already ran aren't queued again, even after a restart.
+	reloaded, err := NewJobQueue(path, 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle(reloaded)
+	if pending, _ := reloaded.Snapshot(); len(pending) != 0 {
+		t.Errorf("pending = %+v after the event was handled again", pending)
+	}
+	// Jobs of other events and outside events are queued as before.
+	if err := reloaded.Enqueue(withEventJobs(context.Background(), "evt_2"), "email", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Enqueue(context.Background(), "email", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := reloaded.Snapshot(); len(pending) != 2 {
+		t.Errorf("pending = %+v, want 2 jobs", pending)
+	}
+}
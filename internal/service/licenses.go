@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// License keys are 20 bytes written as four dash separated groups of eight
+// base32 characters: a 10 byte serial and the first 10 bytes of its
+// HMAC-SHA256 under LICENSE_SIGNING_KEY. The serial is itself derived from
+// the order and price, so an order line always gets the same key and the
+// confirmation email can show it before the fulfillment job stores it.
+const (
+	licenseSerialBytes    = 10
+	licenseSignatureBytes = 10
+)
+
+var licenseEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// errLicenseKey is returned for keys that weren't issued under
+// LICENSE_SIGNING_KEY, so forged or mistyped keys never reach the store.
+var errLicenseKey = errors.New("invalid license key")
+
+func (svc *Service) licenseMAC(message string) []byte {
+	mac := hmac.New(sha256.New, []byte(svc.Config.LicenseSigningKey))
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// LicenseKey is the license key of one line of an order.
+func (svc *Service) LicenseKey(orderID, price string) string {
+	serial := svc.licenseMAC("license-serial." + orderID + "." + price)[:licenseSerialBytes]
+	sig := svc.licenseMAC("license." + string(serial))[:licenseSignatureBytes]
+	raw := licenseEncoding.EncodeToString(append(serial, sig...))
+	return strings.Join([]string{raw[0:8], raw[8:16], raw[16:24], raw[24:32]}, "-")
+}
+
+// VerifyLicenseKey checks a key's signature and returns it in its canonical
+// form. Keys are accepted in any case, with or without dashes.
+func (svc *Service) VerifyLicenseKey(key string) (string, error) {
+	b, err := licenseEncoding.DecodeString(strings.ToUpper(strings.ReplaceAll(key, "-", "")))
+	if err != nil || len(b) != licenseSerialBytes+licenseSignatureBytes {
+		return "", errLicenseKey
+	}
+	serial, sig := b[:licenseSerialBytes], b[licenseSerialBytes:]
+	if !hmac.Equal(sig, svc.licenseMAC("license." + string(serial))[:licenseSignatureBytes]) {
+		return "", errLicenseKey
+	}
+	raw := licenseEncoding.EncodeToString(b)
+	return strings.Join([]string{raw[0:8], raw[8:16], raw[16:24], raw[24:32]}, "-"), nil
+}
+
+// ReceiptLicense is a license key listed in a confirmation email. Quantity
+// is the number of seats it covers.
+type ReceiptLicense struct {
+	Name     string
+	Quantity int64
+	Key      string
+}
+
+// orderLicenses lists the license keys of the order's lines that are
+// routed to the license fulfiller.
+func (svc *Service) orderLicenses(ctx context.Context, o *Order) []ReceiptLicense {
+	var licenses []ReceiptLicense
+	for _, item := range o.Items {
+		if r := svc.fulfillmentRoute(ctx, item.Price); r == nil || r.Type != "license" {
+			continue
+		}
+		name := item.Price
+		if p, ok := svc.CatalogFor(ctx).Price(item.Price); ok {
+			if n := svc.CatalogFor(ctx).ProductName(p.Product); n != "" {
+				name = n
+			}
+		}
+		licenses = append(licenses, ReceiptLicense{Name: name, Quantity: item.Quantity, Key: svc.LicenseKey(o.ID, item.Price)})
+	}
+	return licenses
+}
+
+// licenseFulfiller stores the signed license key of the line, covering its
+// quantity as seats, so GET /licenses/{key}/verify can find it.
+type licenseFulfiller struct{}
+
+func (licenseFulfiller) Fulfill(ctx context.Context, svc *Service, o *Order, f *Fulfillment) (bool, error) {
+	l := &License{
+		Key:      svc.LicenseKey(o.ID, f.Price),
+		OrderID:  o.ID,
+		Price:    f.Price,
+		Product:  f.Product,
+		Quantity: f.Quantity,
+	}
+	if err := svc.Payments.SaveLicense(ctx, l); err != nil {
+		return false, err
+	}
+	f.Details["key"] = l.Key
+	return true, nil
+}
+
+// LicenseVerification is the answer to GET /licenses/{key}/verify: whether
+// the key is good and what it unlocks.
+type LicenseVerification struct {
+	Key         string    `json:"key"`
+	Valid       bool      `json:"valid"`
+	Status      string    `json:"status"`
+	Product     string    `json:"product,omitempty"`
+	ProductName string    `json:"productName,omitempty"`
+	Seats       int64     `json:"seats"`
+	IssuedAt    time.Time `json:"issuedAt"`
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// PriceLimits returns the quantity bounds of the prices that don't use
+// 1 to MAX_QUANTITY: those of PRICE_LIMITS, replaced by the ones set in the
+// database.
+func (svc *Service) PriceLimits(ctx context.Context) (map[string]config.PriceLimit, error) {
+	limits := map[string]config.PriceLimit{}
+	for price, l := range svc.Config.PriceLimits {
+		l.Source = "config"
+		limits[price] = l
+	}
+	list, err := svc.Payments.ListPriceLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range list {
+		limits[l.Price] = *l
+	}
+	return limits, nil
+}
+
+// PriceLimit returns the bounds of price among limits, or 1 to MAX_QUANTITY
+// with the source "default".
+func (svc *Service) PriceLimit(limits map[string]config.PriceLimit, price string) config.PriceLimit {
+	if l, ok := limits[price]; ok {
+		return l
+	}
+	return config.PriceLimit{Price: price, MinQuantity: 1, MaxQuantity: svc.Config.MaxQuantity, Source: "default"}
+}
+
+// checkPurchaseLimits checks merged line items against the quantity bounds
+// of their prices, MAX_CART_QUANTITY and the CHECKOUT_AMOUNT_LIMITS of the
+// currency they are charged in, so a mistyped quantity or a scripted cart
+// can't start an outsized payment.
+func (svc *Service) checkPurchaseLimits(ctx context.Context, limits map[string]config.PriceLimit, items []*stripe.CheckoutSessionLineItemParams, currency string) error {
+	var units, total int64
+	charged := currency
+	for _, li := range items {
+		price, quantity := stripe.StringValue(li.Price), stripe.Int64Value(li.Quantity)
+		if l := svc.PriceLimit(limits, price); quantity < l.MinQuantity || quantity > l.MaxQuantity {
+			return fmt.Errorf("price %q: quantity must be between %d and %d", price, l.MinQuantity, l.MaxQuantity)
+		}
+		units += quantity
+		p, ok := svc.CatalogFor(ctx).Price(price)
+		if !ok {
+			continue
+		}
+		amount, c := p.lineAmount(quantity, currency)
+		total += amount
+		charged = c
+	}
+	if svc.Config.MaxCartQuantity > 0 && units > svc.Config.MaxCartQuantity {
+		return fmt.Errorf("cart has %d units; at most %d can be bought at once", units, svc.Config.MaxCartQuantity)
+	}
+	l, ok := svc.Config.CheckoutAmountLimits[charged]
+	switch {
+	case !ok:
+	case total < l.Min:
+		return fmt.Errorf("order total %s is below the minimum of %s", FormatAmount(total, charged), FormatAmount(l.Min, charged))
+	case l.Max > 0 && total > l.Max:
+		return fmt.Errorf("order total %s is above the maximum of %s", FormatAmount(total, charged), FormatAmount(l.Max, charged))
+	}
+	return nil
+}
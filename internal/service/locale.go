@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+	"golang.org/x/text/language"
+)
+
+// CheckoutLocales are the languages the Checkout page is available in.
+// "auto" leaves the choice to Checkout, which uses the browser's.
+var CheckoutLocales = []string{
+	"bg", "cs", "da", "de", "el", "en", "en-GB", "es", "es-419", "et", "fi",
+	"fil", "fr", "fr-CA", "hr", "hu", "id", "it", "ja", "ko", "lt", "lv", "ms",
+	"mt", "nb", "nl", "pl", "pt", "pt-BR", "ro", "ru", "sk", "sl", "sv", "th",
+	"tr", "vi", "zh", "zh-HK", "zh-TW",
+}
+
+var checkoutLocaleMatcher = func() language.Matcher {
+	tags := make([]language.Tag, len(CheckoutLocales))
+	for i, l := range CheckoutLocales {
+		tags[i] = language.MustParse(l)
+	}
+	return language.NewMatcher(tags)
+}()
+
+// NormalizeLocale returns the Checkout locale a client asked for, spelled
+// as Stripe expects, e.g. pt-BR for pt-br.
+func NormalizeLocale(locale string) (string, error) {
+	locale = strings.TrimSpace(locale)
+	if locale == "" || locale == "auto" {
+		return locale, nil
+	}
+	for _, l := range CheckoutLocales {
+		if strings.EqualFold(l, locale) {
+			return l, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported locale %q", locale)
+}
+
+// CheckoutLocale picks the locale of a session: the explicit choice if
+// there is one, otherwise the closest Checkout locale to Accept-Language,
+// e.g. de for de-AT. It returns "" when neither says anything, leaving
+// Checkout to follow the browser.
+func CheckoutLocale(acceptLanguage, explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return ""
+	}
+	_, i, confidence := checkoutLocaleMatcher.Match(tags...)
+	if confidence == language.No {
+		return ""
+	}
+	// The matcher falls back to English for languages it deems close
+	// enough, e.g. tlh; only a locale in a language asked for counts.
+	base, _ := language.MustParse(CheckoutLocales[i]).Base()
+	for _, tag := range tags {
+		if b, _ := tag.Base(); b == base {
+			return CheckoutLocales[i]
+		}
+	}
+	return ""
+}
+
+// WithCheckoutLocale sets the session's locale, which also picks the
+// language of its emails, and passes it to the return pages as a locale
+// parameter so they can match.
+func WithCheckoutLocale(params *stripe.CheckoutSessionParams, locale string) {
+	if locale == "" {
+		return
+	}
+	params.Locale = stripe.String(locale)
+	for _, u := range []**string{&params.SuccessURL, &params.CancelURL} {
+		if *u == nil {
+			continue
+		}
+		sep := "?"
+		if strings.Contains(**u, "?") {
+			sep = "&"
+		}
+		*u = stripe.String(**u + sep + "locale=" + url.QueryEscape(locale))
+	}
+}
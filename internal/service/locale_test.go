@@ -0,0 +1,28 @@
+package service
+
+import "testing"
+
+func TestCheckoutLocale(t *testing.T) {
+	for _, tt := range []struct {
+		acceptLanguage, explicit, want string
+	}{
+		{"de-AT,de;q=0.9,en;q=0.5", "", "de"},
+		{"pt-BR", "", "pt-BR"},
+		{"en-GB,en;q=0.8", "", "en-GB"},
+		{"fr-CA", "", "fr-CA"},
+		{"de-DE", "fr", "fr"},
+		{"tlh", "", ""},
+		{"", "", ""},
+		{"", "auto", "auto"},
+	} {
+		if got := CheckoutLocale(tt.acceptLanguage, tt.explicit); got != tt.want {
+			t.Errorf("checkoutLocale(%q, %q) = %q, want %q", tt.acceptLanguage, tt.explicit, got, tt.want)
+		}
+	}
+	if l, err := NormalizeLocale("PT-br"); err != nil || l != "pt-BR" {
+		t.Errorf("normalizeLocale(PT-br) = %q, %v", l, err)
+	}
+	if _, err := NormalizeLocale("tlh"); err == nil {
+		t.Error("normalizeLocale accepted tlh")
+	}
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestID returns the ID assigned to the request by withRequestLogging.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// LogCtx is logFor for code that only has the request's context, such as
+// the service operations shared with the gRPC server.
+func LogCtx(ctx context.Context) *slog.Logger {
+	l := slog.With("request_id", requestID(ctx))
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		l = l.With("trace_id", sc.TraceID().String())
+	}
+	if p, ok := PrincipalFrom(ctx); ok {
+		l = l.With("principal", p.Name)
+	}
+	return l
+}
+
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"stripe_go/internal/config"
+)
+
+var notificationTemplate = template.Must(template.New("notification").Parse(`<!DOCTYPE html>
+<html>
+  <body>
+    <h1>{{.Subject}}</h1>{{range .Lines}}
+    <p>{{.}}</p>{{end}}
+  </body>
+</html>
+`))
+
+// notifyHTTPClient posts to the Slack and Discord webhooks.
+var notifyHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// NotifyOps queues the notification for every backend that wants event, so
+// webhook handlers don't wait on email, Slack or Discord.
+func (svc *Service) NotifyOps(ctx context.Context, event, subject string, lines ...string) error {
+	for _, ch := range []struct {
+		name    string
+		enabled bool
+		events  []string
+	}{
+		{config.ChannelEmail, true, svc.Config.NotifyEmailEvents},
+		{config.ChannelSlack, svc.Config.SlackWebhookURL != "", svc.Config.SlackNotifyEvents},
+		{config.ChannelDiscord, svc.Config.DiscordWebhookURL != "", svc.Config.DiscordNotifyEvents},
+	} {
+		if !ch.enabled || (len(ch.events) > 0 && !slices.Contains(ch.events, event)) {
+			continue
+		}
+		n := &config.Notification{Event: event, Channel: ch.name, Subject: subject, Lines: lines}
+		if err := svc.Jobs.Enqueue(ctx, JobSendNotification, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendNotification delivers n to its backend. Notifications queued without a
+// channel are emailed.
+func (svc *Service) SendNotification(ctx context.Context, n *config.Notification) error {
+	switch n.Channel {
+	case config.ChannelSlack:
+		// Slack reads &, < and > as markup.
+		text := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(notificationText(n, "*"))
+		return postNotification(ctx, svc.Config.SlackWebhookURL, map[string]interface{}{"text": text})
+	case config.ChannelDiscord:
+		text := notificationText(n, "**")
+		// Discord rejects messages over 2000 characters.
+		if r := []rune(text); len(r) > 2000 {
+			text = string(r[:1999]) + "…"
+		}
+		return postNotification(ctx, svc.Config.DiscordWebhookURL, map[string]interface{}{
+			"content": text,
+			// Never ping anyone, whatever ends up in the text.
+			"allowed_mentions": map[string]interface{}{"parse": []string{}},
+		})
+	}
+	return svc.emailNotification(ctx, n)
+}
+
+// emailNotification emails n to NOTIFY_EMAIL, or logs it when that isn't set.
+func (svc *Service) emailNotification(ctx context.Context, n *config.Notification) error {
+	if svc.Config.NotifyEmail == "" {
+		slog.Warn("notification", "event", n.Event, "subject", n.Subject, "details", n.Lines)
+		return nil
+	}
+	var body bytes.Buffer
+	if err := notificationTemplate.Execute(&body, n); err != nil {
+		return fmt.Errorf("rendering notification: %w", err)
+	}
+	return svc.EmailSender.Send(ctx, &EmailMessage{
+		To:      svc.Config.NotifyEmail,
+		Subject: n.Subject,
+		HTML:    body.String(),
+	})
+}
+
+// notificationText is n as a chat message, the subject in bold.
+func notificationText(n *config.Notification, bold string) string {
+	return strings.Join(append([]string{bold + n.Subject + bold}, n.Lines...), "\n")
+}
+
+func postNotification(ctx context.Context, webhookURL string, msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := notifyHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting notification: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("posting notification: %s", resp.Status)
+	}
+	return nil
+}
+
+// SignatureAlertInterval is the least time between two
+// webhook.signature_failed notifications, so a misconfigured secret or a
+// flood of forged requests raises one alert rather than one per delivery.
+const SignatureAlertInterval = 10 * time.Minute
+
+// SignatureAlertDue reports whether a signature failure at now raises an
+// alert, at most once per SignatureAlertInterval, and how many failures
+// were suppressed since the last one.
+func (svc *Service) SignatureAlertDue(now time.Time) (suppressed int, due bool) {
+	svc.signatureAlerts.Lock()
+	defer svc.signatureAlerts.Unlock()
+	if now.Sub(svc.signatureAlerts.last) < SignatureAlertInterval {
+		svc.signatureAlerts.suppressed++
+		return 0, false
+	}
+	suppressed = svc.signatureAlerts.suppressed
+	svc.signatureAlerts.last, svc.signatureAlerts.suppressed = now, 0
+	return suppressed, true
+}
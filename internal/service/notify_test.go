@@ -0,0 +1,28 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignatureAlertDue(t *testing.T) {
+	svc := &Service{}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		at         time.Duration
+		due        bool
+		suppressed int
+	}{
+		{0, true, 0},
+		{time.Minute, false, 0},
+		{5 * time.Minute, false, 0},
+		{SignatureAlertInterval, true, 2},
+		{SignatureAlertInterval + time.Second, false, 0},
+		{3 * SignatureAlertInterval, true, 1},
+	} {
+		suppressed, due := svc.SignatureAlertDue(start.Add(tt.at))
+		if due != tt.due || suppressed != tt.suppressed {
+			t.Errorf("at +%v: due %v, %d suppressed; want %v, %d", tt.at, due, suppressed, tt.due, tt.suppressed)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// keyedMutex serializes work per key while work on different keys runs in
+// parallel. Keys with nobody holding or waiting for them take no memory.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock waits until key is free, takes it and returns the function that
+// frees it again.
+func (m *keyedMutex) Lock(key string) (unlock func()) {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = map[string]*keyedLock{}
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// paymentLockKey names the payment an object belongs to: its payment intent
+// when it has one, so the session, charge, refund and dispute of a payment
+// share a key, or else its own ID.
+func paymentLockKey(paymentIntentID, id string) string {
+	if paymentIntentID != "" {
+		return paymentIntentID
+	}
+	return id
+}
+
+// eventLockKey is the paymentLockKey of an event's object, or "" for events
+// without one.
+func eventLockKey(event stripe.Event) string {
+	if event.Data == nil || event.Data.Object == nil {
+		return ""
+	}
+	id, _ := event.Data.Object["id"].(string)
+	var paymentIntentID string
+	switch pi := event.Data.Object["payment_intent"].(type) {
+	case string:
+		paymentIntentID = pi
+	case map[string]interface{}:
+		paymentIntentID, _ = pi["id"].(string)
+	}
+	return paymentLockKey(paymentIntentID, id)
+}
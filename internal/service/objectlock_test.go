@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func testEvent(t *testing.T, eventType, object string) stripe.Event {
+	t.Helper()
+	var event stripe.Event
+	payload := fmt.Sprintf(`{"id": "evt_1", "object": "event", "type": %q, "data": {"object": %s}}`, eventType, object)
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestEventLockKey(t *testing.T) {
+	for _, tt := range []struct {
+		object, want string
+	}{
+		{`{"id": "pi_1", "object": "payment_intent"}`, "pi_1"},
+		{`{"id": "cs_1", "object": "checkout.session", "payment_intent": "pi_1"}`, "pi_1"},
+		{`{"id": "ch_1", "object": "charge", "payment_intent": {"id": "pi_1"}}`, "pi_1"},
+		{`{"id": "cs_2", "object": "checkout.session", "payment_intent": null}`, "cs_2"},
+		{`{"id": "sub_1", "object": "subscription"}`, "sub_1"},
+	} {
+		if got := eventLockKey(testEvent(t, "test.event", tt.object)); got != tt.want {
+			t.Errorf("eventLockKey(%s) = %q, want %q", tt.object, got, tt.want)
+		}
+	}
+}
+
+// TestDispatchSerializesPayments checks that events of one payment never
+// run at the same time, while another payment's event isn't held up.
+func TestDispatchSerializesPayments(t *testing.T) {
+	router := NewWebhookRouter()
+	var mu sync.Mutex
+	running := map[string]int{}
+	overlapped := false
+	release := make(chan struct{})
+	router.On("charge.refunded", func(ctx context.Context, event stripe.Event) error {
+		key := eventLockKey(event)
+		mu.Lock()
+		running[key]++
+		overlapped = overlapped || running[key] > 1
+		mu.Unlock()
+		if key == "pi_slow" {
+			<-release
+		}
+		mu.Lock()
+		running[key]--
+		mu.Unlock()
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			router.Dispatch(context.Background(), testEvent(t, "charge.refunded", `{"id": "ch_1", "payment_intent": "pi_slow"}`))
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		router.Dispatch(context.Background(), testEvent(t, "charge.refunded", `{"id": "ch_2", "payment_intent": "pi_fast"}`))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("an unrelated payment waited for pi_slow")
+	}
+	close(release)
+	wg.Wait()
+	if overlapped {
+		t.Error("events of one payment ran concurrently")
+	}
+	if len(router.paymentLocks.locks) != 0 {
+		t.Errorf("%d locks left behind", len(router.paymentLocks.locks))
+	}
+}
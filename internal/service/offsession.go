@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// OffSessionChargeRequest is the body accepted by POST /charges/off-session.
+// PaymentMethod defaults to the customer's default payment method, which a
+// setup mode session sets.
+type OffSessionChargeRequest struct {
+	Customer      string            `json:"customer"`
+	PaymentMethod string            `json:"paymentMethod"`
+	Amount        int64             `json:"amount"`
+	Currency      string            `json:"currency"`
+	Description   string            `json:"description"`
+	Metadata      map[string]string `json:"metadata"`
+}
+
+// OffSessionChargeResponse reports how an off-session charge went. Status is
+// succeeded, processing, requires_action (the bank wants the customer to
+// authenticate; they have been emailed a link when CustomerNotified is set)
+// or failed.
+type OffSessionChargeResponse struct {
+	PaymentIntentID  string `json:"paymentIntentId,omitempty"`
+	Status           string `json:"status"`
+	Amount           int64  `json:"amount"`
+	Currency         string `json:"currency"`
+	DeclineCode      string `json:"declineCode,omitempty"`
+	Message          string `json:"message,omitempty"`
+	CustomerNotified bool   `json:"customerNotified,omitempty"`
+}
+
+func (c *OffSessionChargeRequest) Validate(ctx context.Context, svc *Service) error {
+	if err := ValidateStripeID(c.Customer, "cus_", "customer"); err != nil {
+		return err
+	}
+	if c.PaymentMethod != "" {
+		if err := ValidateStripeID(c.PaymentMethod, "pm_", "payment method"); err != nil {
+			return err
+		}
+	}
+	c.Currency = strings.ToLower(c.Currency)
+	if !config.CurrencyPattern.MatchString(c.Currency) {
+		return fmt.Errorf("invalid currency %q", c.Currency)
+	}
+	if c.Amount < 1 {
+		return errors.New("amount must be a positive number of the smallest currency unit")
+	}
+	if len(c.Description) > 1000 {
+		return errors.New("description is too long")
+	}
+	return validateMetadata(c.Metadata)
+}
+
+// AuthenticationRequest is the email asking a customer to approve an
+// off-session charge that their bank wants authenticated. Retry says their
+// bank turned down an earlier attempt.
+type AuthenticationRequest struct {
+	Email       string
+	Locale      string
+	Amount      int64
+	Currency    string
+	Description string
+	// URL opens authenticate.html, which confirms the payment intent, or
+	// the hosted page of the invoice it pays. It works until ExpiresAt.
+	URL             string
+	PaymentIntentID string
+	ExpiresAt       time.Time
+	Retry           bool
+}
+
+// AuthenticationRequest builds the email to address in locale for a charge
+// on pi that needs the customer to authenticate with paymentMethod. The
+// client secret goes in the link's fragment, which browsers don't send to
+// the server.
+func (svc *Service) AuthenticationRequest(address, locale string, pi *stripe.PaymentIntent, paymentMethod, description string) *AuthenticationRequest {
+	fragment := url.Values{
+		"payment_intent": {pi.ID},
+		"client_secret":  {pi.ClientSecret},
+		"payment_method": {paymentMethod},
+	}
+	return &AuthenticationRequest{
+		Email:       address,
+		Locale:      locale,
+		Amount:      pi.Amount,
+		Currency:    string(pi.Currency),
+		Description: description,
+		URL:         svc.Config.Domain + "/authenticate.html#" + fragment.Encode(),
+	}
+}
+
+// CustomerLocale is the first of c's preferred locales, or "".
+func CustomerLocale(c *stripe.Customer) string {
+	if len(c.PreferredLocales) > 0 {
+		return c.PreferredLocales[0]
+	}
+	return ""
+}
+
+// sendAuthenticationEmail asks the customer to approve a charge, unless
+// they have or it expired since the email was queued.
+func (svc *Service) sendAuthenticationEmail(ctx context.Context, a *AuthenticationRequest) error {
+	if a.PaymentIntentID != "" {
+		pending, err := svc.PendingAuthentication(ctx, a.PaymentIntentID)
+		if err != nil || pending == nil {
+			return err
+		}
+	}
+	msg, err := svc.EmailTemplates.Render("authentication_required", a.Locale, a)
+	if err != nil {
+		return err
+	}
+	msg.To = a.Email
+	slog.Info("sending authentication email", "to", a.Email)
+	return svc.EmailSender.Send(ctx, msg)
+}
@@ -0,0 +1,461 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// apiOperation documents one endpoint in the OpenAPI document. Request and
+// Response are values of the types the handler decodes and encodes, so the
+// schemas are generated from the same structs and can't drift from them.
+type apiOperation struct {
+	Method  string
+	Path    string
+	Summary string
+	Tag     string
+	Query   []apiParam
+	Request interface{}
+	// OptionalRequest means the body may be left out.
+	OptionalRequest bool
+	// Form means the endpoint also takes HTML form posts, which are answered
+	// with a 303 redirect rather than Response.
+	Form     bool
+	Response interface{}
+	// ContentType is the response media type when it isn't JSON.
+	ContentType string
+	Admin       bool
+	// Idempotent means the endpoint honors an Idempotency-Key header.
+	Idempotent bool
+	Errors     []int
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+var APIOperations = []apiOperation{
+	{
+		Method: "GET", Path: "/config", Tag: "storefront",
+		Summary:  "Publishable key and the default price in the shopper's currency",
+		Query:    []apiParam{{Name: "currency", Description: "ISO currency code; inferred from Accept-Language when missing"}},
+		Response: ConfigResponse{},
+		Errors:   []int{400, 502},
+	},
+	{
+		Method: "GET", Path: "/products", Tag: "storefront",
+		Summary:  "Active products with their active prices",
+		Response: []*CatalogProduct{},
+	},
+	{
+		Method: "GET", Path: "/promotions", Tag: "storefront",
+		Summary:  "Active promotion codes",
+		Response: []*Promotion{},
+		Errors:   []int{502},
+	},
+	{
+		Method: "POST", Path: "/create-checkout-session", Tag: "checkout",
+		Summary:    "Create a Checkout session for a cart",
+		Request:    CreateCheckoutRequest{},
+		Form:       true,
+		Response:   CreateCheckoutResponse{},
+		Idempotent: true,
+		Errors:     []int{400, 409, 502},
+	},
+	{
+		Method: "POST", Path: "/carts", Tag: "checkout",
+		Summary:    "Save a cart to check out later",
+		Request:    CreateCheckoutRequest{},
+		Response:   Cart{},
+		Idempotent: true,
+		Errors:     []int{400},
+	},
+	{
+		Method: "GET", Path: "/carts/{cartId}", Tag: "checkout",
+		Summary:  "Fetch a saved cart and the status of its latest session",
+		Response: Cart{},
+		Errors:   []int{404},
+	},
+	{
+		Method: "POST", Path: "/carts/{cartId}/checkout", Tag: "checkout",
+		Summary:         "Resume the open Checkout session of a saved cart, or create a new one if it expired",
+		Request:         CartCheckoutRequest{},
+		OptionalRequest: true,
+		Response:        CreateCheckoutResponse{},
+		Errors:          []int{400, 404, 409, 502},
+	},
+	{
+		Method: "GET", Path: "/checkout-session/summary", Tag: "checkout",
+		Summary: "What the success page shows about its Checkout session, without customer or payment details",
+		Query: []apiParam{
+			{Name: "sessionId", Description: "Checkout session ID (cs_...)", Required: true},
+			{Name: "token", Description: "summary_token from the success URL", Required: true},
+		},
+		Response: CheckoutSessionSummary{},
+		Errors:   []int{400, 403, 404, 502},
+	},
+	{
+		Method: "POST", Path: "/create-subscription-session", Tag: "checkout",
+		Summary:    "Create a subscription mode Checkout session for a recurring price",
+		Request:    SubscriptionRequest{},
+		Form:       true,
+		Response:   CreateCheckoutResponse{},
+		Idempotent: true,
+		Errors:     []int{400, 502},
+	},
+	{
+		Method: "POST", Path: "/create-setup-session", Tag: "checkout",
+		Summary:    "Create a setup mode Checkout session that saves a card without charging it",
+		Request:    SetupSessionRequest{},
+		Form:       true,
+		Response:   SetupSessionResponse{},
+		Idempotent: true,
+		Errors:     []int{400, 502},
+	},
+	{
+		Method: "POST", Path: "/create-donation-session", Tag: "checkout",
+		Summary:    "Create a Checkout session for a donation of the donor's chosen amount",
+		Request:    DonationRequest{},
+		Form:       true,
+		Response:   CreateCheckoutResponse{},
+		Idempotent: true,
+		Errors:     []int{400, 502},
+	},
+	{
+		Method: "POST", Path: "/create-payment-intent", Tag: "checkout",
+		Summary:    "Create a PaymentIntent for an Elements payment form",
+		Request:    PaymentIntentRequest{},
+		Response:   PaymentIntentResponse{},
+		Idempotent: true,
+		Errors:     []int{400, 502},
+	},
+	{
+		Method: "POST", Path: "/create-portal-session", Tag: "checkout",
+		Summary:    "Open the Billing Portal for a customer",
+		Request:    PortalRequest{},
+		Form:       true,
+		Response:   PortalResponse{},
+		Idempotent: true,
+		Errors:     []int{400, 401, 403, 502},
+	},
+	{
+		Method: "GET", Path: "/receipts/{sessionId}.pdf", Tag: "checkout",
+		Summary:     "Download the PDF receipt of a paid session with the link from the confirmation email",
+		Query:       []apiParam{{Name: "token", Description: "Signed token from the receipt link", Required: true}},
+		ContentType: "application/pdf",
+		Errors:      []int{400, 403, 404, 502},
+	},
+	{
+		Method: "GET", Path: "/orders/{orderId}/status", Tag: "checkout",
+		Summary:  "Follow an order with the status link from the checkout response, success URL or confirmation email",
+		Query:    []apiParam{{Name: "token", Description: "Signed token from the status link", Required: true}},
+		Response: OrderStatusView{},
+		Errors:   []int{403, 404},
+	},
+	{
+		Method: "GET", Path: "/downloads/{fulfillmentId}", Tag: "checkout",
+		Summary:     "Download a digital purchase with a link from the order status",
+		Query:       []apiParam{{Name: "token", Description: "Signed token from the download link", Required: true}},
+		ContentType: "application/octet-stream",
+		Errors:      []int{403, 404, 410},
+	},
+	{
+		Method: "GET", Path: "/licenses/{key}/verify", Tag: "checkout",
+		Summary:  "Check a license key from a purchase, e.g. from a desktop app",
+		Response: LicenseVerification{},
+		Errors:   []int{404},
+	},
+	{
+		Method: "POST", Path: "/webhook", Tag: "webhooks",
+		Summary:  "Receive a Stripe event, signed with the Stripe-Signature header",
+		Request:  stripeObject("event"),
+		Response: WebhookResponse{},
+		Errors:   []int{400},
+	},
+	{
+		Method: "POST", Path: "/refunds", Tag: "admin", Admin: true,
+		Summary:    "Refund a payment in full or in part",
+		Request:    RefundRequest{},
+		Form:       true,
+		Response:   Refund{},
+		Idempotent: true,
+		Errors:     []int{400, 401, 404, 428, 502},
+	},
+	{
+		Method: "POST", Path: "/charges/off-session", Tag: "admin", Admin: true,
+		Summary:    "Charge a customer's saved payment method while they are away; declines answer 402 with the outcome",
+		Request:    OffSessionChargeRequest{},
+		Response:   OffSessionChargeResponse{},
+		Idempotent: true,
+		Errors:     []int{400, 401, 402, 404, 409, 502},
+	},
+	{
+		Method: "POST", Path: "/payment-links", Tag: "admin", Admin: true,
+		Summary:    "Create a shareable Stripe payment link for a price, deactivated at expiresAt if set",
+		Request:    PaymentLinkRequest{},
+		Response:   PaymentLinkView{},
+		Idempotent: true,
+		Errors:     []int{400, 401, 502},
+	},
+	{
+		Method: "GET", Path: "/payment-links", Tag: "admin", Admin: true,
+		Summary: "List payment links, newest first",
+		Query: []apiParam{
+			{Name: "active", Description: "true or false to list only active or deactivated links"},
+		},
+		Response: []PaymentLinkView{},
+		Errors:   []int{400, 401, 502},
+	},
+	{
+		Method: "GET", Path: "/subscriptions/{customerId}", Tag: "admin", Admin: true,
+		Summary:  "List a customer's subscriptions as kept current by the subscription webhooks",
+		Response: CustomerSubscriptions{},
+		Errors:   []int{401, 404},
+	},
+	{
+		Method: "POST", Path: "/payments/{id}/capture", Tag: "admin", Admin: true,
+		Summary:  "Capture all or part of a payment authorized with manual capture, or more where the card allows overcapture",
+		Request:  CapturePaymentRequest{},
+		Form:     true,
+		Response: Payment{},
+		Errors:   []int{400, 401, 404, 409, 502},
+	},
+	{
+		Method: "POST", Path: "/payments/{id}/cancel", Tag: "admin", Admin: true,
+		Summary:  "Cancel an uncaptured authorization",
+		Request:  CancelPaymentRequest{},
+		Form:     true,
+		Response: Payment{},
+		Errors:   []int{400, 401, 404, 409, 502},
+	},
+	{
+		Method: "GET", Path: "/healthz", Tag: "operations",
+		Summary:  "Liveness probe",
+		Response: HealthResponse{},
+	},
+	{
+		Method: "GET", Path: "/readyz", Tag: "operations",
+		Summary:  "Readiness probe: configuration, database and Stripe",
+		Response: HealthResponse{},
+		Errors:   []int{503},
+	},
+}
+
+// stripeObject stands in for a Stripe API object the endpoint passes through
+// unchanged; its schema links to Stripe's reference instead of repeating it.
+type stripeObject string
+
+var PathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// OpenAPIDocument builds the OpenAPI 3 description of APIOperations.
+func (svc *Service) OpenAPIDocument() map[string]interface{} {
+	g := &schemaGenerator{schemas: map[string]interface{}{}}
+	errorSchema := g.schema(reflect.TypeOf(ErrorResponse{}))
+	paths := map[string]interface{}{}
+	for _, op := range APIOperations {
+		var params []interface{}
+		for _, m := range PathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]interface{}{
+				"name": q.Name, "in": "query", "required": q.Required, "description": q.Description,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if op.Path == "/webhook" {
+			params = append(params, map[string]interface{}{
+				"name": "Stripe-Signature", "in": "header", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+
+		codes := op.Errors
+		if op.Idempotent {
+			params = append(params, map[string]interface{}{
+				"name": "Idempotency-Key", "in": "header",
+				"description": "Retries with the same key and body get the first response replayed",
+				"schema":      map[string]interface{}{"type": "string", "maxLength": MaxIdempotencyKeyLength},
+			})
+			for _, code := range []int{http.StatusConflict, http.StatusUnprocessableEntity} {
+				if !slices.Contains(codes, code) {
+					codes = append(codes, code)
+				}
+			}
+		}
+
+		responses := map[string]interface{}{}
+		ok := map[string]interface{}{"description": "OK"}
+		switch {
+		case op.ContentType != "":
+			ok["content"] = map[string]interface{}{op.ContentType: map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string", "format": "binary"},
+			}}
+		case op.Response != nil:
+			ok["content"] = jsonContent(g.value(op.Response))
+		}
+		responses["200"] = ok
+		if op.Form {
+			responses["303"] = map[string]interface{}{"description": "Form posts are redirected to the Stripe hosted page"}
+		}
+		for _, code := range append(codes, http.StatusMethodNotAllowed) {
+			responses[fmt.Sprint(code)] = map[string]interface{}{
+				"description": http.StatusText(code),
+				"content":     jsonContent(errorSchema),
+			}
+		}
+
+		operation := map[string]interface{}{
+			"summary":     op.Summary,
+			"operationId": operationID(op),
+			"tags":        []string{op.Tag},
+			"responses":   responses,
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{"required": !op.OptionalRequest, "content": jsonContent(g.value(op.Request))}
+		}
+		if op.Admin {
+			operation["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+		}
+
+		item, _ := paths[op.Path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Stripe Checkout storefront API",
+			"version":     "1.0.0",
+			"description": "Amounts are in the smallest currency unit. Errors are returned as {\"error\": {\"message\": ...}}.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": svc.Config.Domain}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "ADMIN_TOKEN, an API_KEYS key or a JWT with a role claim"},
+			},
+		},
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// operationID turns "POST /create-checkout-session" into
+// "postCreateCheckoutSession" for code generators.
+func operationID(op apiOperation) string {
+	id := strings.ToLower(op.Method)
+	for _, word := range strings.FieldsFunc(op.Path, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+	}) {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+// schemaGenerator derives JSON schemas from Go types the way encoding/json
+// marshals them. Named structs become shared components.
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// value is the schema of v's type, or a link to Stripe's reference for a
+// stripeObject.
+func (g *schemaGenerator) value(v interface{}) map[string]interface{} {
+	if obj, ok := v.(stripeObject); ok {
+		return map[string]interface{}{
+			"type":        "object",
+			"description": fmt.Sprintf("A Stripe %s object", obj),
+			"externalDocs": map[string]interface{}{
+				"url": "https://stripe.com/docs/api/" + strings.ReplaceAll(string(obj), ".", "/") + "s/object",
+			},
+		}
+	}
+	return g.schema(reflect.TypeOf(v))
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if t == reflect.TypeOf(json.RawMessage(nil)) {
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate.
+			g.schemas[t.Name()] = nil
+			g.schemas[t.Name()] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	g.addFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.addFields(f.Type, properties)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schema(f.Type)
+	}
+}
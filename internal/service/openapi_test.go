@@ -0,0 +1,14 @@
+package service
+
+import "testing"
+
+func TestOperationID(t *testing.T) {
+	for _, tt := range []struct{ method, path, want string }{
+		{"POST", "/create-checkout-session", "postCreateCheckoutSession"},
+		{"GET", "/receipts/{sessionId}.pdf", "getReceiptsSessionIdPdf"},
+	} {
+		if got := operationID(apiOperation{Method: tt.method, Path: tt.path}); got != tt.want {
+			t.Errorf("operationID(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// OrderStatus is where an order is in its lifecycle.
+type OrderStatus string
+
+const (
+	OrderPending   OrderStatus = "pending"
+	OrderPaid      OrderStatus = "paid"
+	OrderFulfilled OrderStatus = "fulfilled"
+	OrderRefunded  OrderStatus = "refunded"
+	OrderCanceled  OrderStatus = "canceled"
+)
+
+// OrderTransitions lists where an order can go from each status. Canceled
+// and refunded orders are final.
+var OrderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPending:   {OrderPaid, OrderCanceled},
+	OrderPaid:      {OrderFulfilled, OrderRefunded},
+	OrderFulfilled: {OrderRefunded},
+	OrderRefunded:  {},
+	OrderCanceled:  {},
+}
+
+// OrderMetadataKey is the session and payment intent metadata key that links
+// them back to their order. Clients can't set it.
+const OrderMetadataKey = "order"
+
+// OrderItem is one line of an order.
+type OrderItem struct {
+	Price    string `json:"price"`
+	Quantity int64  `json:"quantity"`
+}
+
+// Order is the shop's record of a purchase. It is created before the
+// checkout session, so it exists even if the customer never pays, and the
+// webhook handlers move it along as Stripe reports progress. Amount and
+// Currency are what the session charges.
+type Order struct {
+	ID              string            `json:"id"`
+	Status          OrderStatus       `json:"status"`
+	Items           []OrderItem       `json:"items"`
+	Amount          int64             `json:"amount"`
+	Currency        string            `json:"currency"`
+	SessionID       string            `json:"sessionId,omitempty"`
+	PaymentIntentID string            `json:"paymentIntentId,omitempty"`
+	Email           string            `json:"email,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Shipping        *OrderShipping    `json:"shipping,omitempty"`
+	CreatedAt       time.Time         `json:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt"`
+}
+
+// NewOrder returns a pending order for the line items.
+func NewOrder(items []*stripe.CheckoutSessionLineItemParams, metadata map[string]string) *Order {
+	o := &Order{
+		ID:       "ord_" + NewRequestID(),
+		Status:   OrderPending,
+		Metadata: metadata,
+	}
+	for _, li := range items {
+		o.Items = append(o.Items, OrderItem{Price: *li.Price, Quantity: *li.Quantity})
+	}
+	return o
+}
+
+// CanTransition reports whether the order may move to status.
+func (o *Order) CanTransition(status OrderStatus) bool {
+	return o.Status == status || slices.Contains(OrderTransitions[o.Status], status)
+}
+
+// Transition moves the order to status. It fails if the state machine
+// doesn't allow the move.
+func (o *Order) Transition(status OrderStatus) error {
+	if !o.CanTransition(status) {
+		return fmt.Errorf("order %s is %s and can't become %s", o.ID, o.Status, status)
+	}
+	o.Status = status
+	return nil
+}
+
+// SessionOrder finds the order a checkout session was created for. Sessions
+// from before orders existed, and donation sessions, have none.
+func (svc *Service) SessionOrder(ctx context.Context, s *stripe.CheckoutSession) (*Order, error) {
+	if id := s.Metadata[OrderMetadataKey]; id != "" {
+		return svc.Payments.GetOrder(ctx, id)
+	}
+	return svc.Payments.GetOrderBySession(ctx, s.ID)
+}
+
+// AdvanceOrder moves the session's order as event asks, saves it and
+// publishes the change. Events that arrive late or out of order are logged
+// and ignored.
+func (svc *Service) AdvanceOrder(ctx context.Context, event stripe.Event, s *stripe.CheckoutSession) error {
+	o, err := svc.SessionOrder(ctx, s)
+	if err == ErrOrderNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	status := SessionOrderTransition(o, event.Type, s)
+	if err := o.Transition(status); err != nil {
+		slog.Info("ignoring order transition", "order", o.ID, "session", s.ID, "error", err)
+	}
+	if err := svc.Payments.SaveOrder(ctx, o); err != nil {
+		return err
+	}
+	return svc.PublishOrderEvent(ctx, event, o, status)
+}
+
+// applySession copies what a checkout session reports about the purchase
+// onto its order.
+func applySession(o *Order, s *stripe.CheckoutSession) {
+	o.SessionID = s.ID
+	if s.PaymentIntent != nil {
+		o.PaymentIntentID = s.PaymentIntent.ID
+	}
+	if s.CustomerDetails != nil && s.CustomerDetails.Email != "" {
+		o.Email = s.CustomerDetails.Email
+	}
+	if s.AmountTotal != 0 {
+		o.Amount = s.AmountTotal
+		o.Currency = string(s.Currency)
+	}
+	if shipping := sessionShipping(s); shipping != nil {
+		o.Shipping = shipping
+	}
+	// Expanded line items hold the quantities actually bought, except in
+	// sessions whose lines store credit replaced with price_data.
+	if s.LineItems != nil && len(s.LineItems.Data) > 0 && s.Metadata[StoreCreditMetadataKey] == "" {
+		o.Items = nil
+		for _, li := range s.LineItems.Data {
+			if li.Price != nil {
+				o.Items = append(o.Items, OrderItem{Price: li.Price.ID, Quantity: li.Quantity})
+			}
+		}
+	}
+}
+
+// CompletedOrderStatus is the status of the order of a completed session.
+// Delayed payment methods stay pending until async_payment_succeeded.
+func CompletedOrderStatus(s *stripe.CheckoutSession) OrderStatus {
+	if s.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid {
+		return OrderPending
+	}
+	return OrderPaid
+}
+
+// The transitions below are how each event type moves an order. The order
+// webhook handlers and FoldOrder both go through them, so replaying the
+// event log moves an order exactly as the webhooks did. Each copies what
+// its event reports onto o and returns the status o moves to.
+
+// SessionOrderTransition applies checkout session event eventType:
+// completed, async_payment_succeeded, async_payment_failed or expired.
+func SessionOrderTransition(o *Order, eventType string, s *stripe.CheckoutSession) OrderStatus {
+	applySession(o, s)
+	switch eventType {
+	case "checkout.session.completed":
+		return CompletedOrderStatus(s)
+	case "checkout.session.async_payment_succeeded":
+		return OrderPaid
+	}
+	return OrderCanceled
+}
+
+// IntentOrderTransition applies payment_intent.succeeded or
+// payment_intent.canceled, which move orders paid by manual capture along.
+// Only payment intents created for an order move it; it returns false for
+// the others.
+func IntentOrderTransition(o *Order, eventType string, pi *stripe.PaymentIntent) (OrderStatus, bool) {
+	if pi.Metadata[OrderMetadataKey] == "" {
+		return "", false
+	}
+	o.PaymentIntentID = pi.ID
+	if eventType == "payment_intent.canceled" {
+		return OrderCanceled, true
+	}
+	return OrderPaid, true
+}
+
+// ChargeOrderTransition applies charge.refunded. Only a refund in full moves
+// the order; it returns false for partial ones.
+func ChargeOrderTransition(o *Order, ch *stripe.Charge) (OrderStatus, bool) {
+	return OrderRefunded, ch.Refunded
+}
+
+// IntentOrder finds the order of a checkout session's payment intent;
+// payment intents created outside Checkout have none.
+func (svc *Service) IntentOrder(ctx context.Context, pi *stripe.PaymentIntent) (*Order, error) {
+	if id := pi.Metadata[OrderMetadataKey]; id != "" {
+		return svc.Payments.GetOrder(ctx, id)
+	}
+	return nil, ErrOrderNotFound
+}
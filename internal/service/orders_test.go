@@ -0,0 +1,25 @@
+package service
+
+import "testing"
+
+func TestOrderTransitions(t *testing.T) {
+	for _, tt := range []struct {
+		from, to OrderStatus
+		want     bool
+	}{
+		{OrderPending, OrderPaid, true},
+		{OrderPending, OrderCanceled, true},
+		{OrderPending, OrderFulfilled, false},
+		{OrderPaid, OrderFulfilled, true},
+		{OrderPaid, OrderCanceled, false},
+		{OrderFulfilled, OrderRefunded, true},
+		{OrderFulfilled, OrderPaid, false},
+		{OrderRefunded, OrderPaid, false},
+		{OrderCanceled, OrderPaid, false},
+	} {
+		o := &Order{ID: "ord_test", Status: tt.from}
+		if got := o.Transition(tt.to) == nil; got != tt.want {
+			t.Errorf("%s -> %s allowed = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
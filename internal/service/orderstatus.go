@@ -0,0 +1,60 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OrderStatusView is what GET /orders/{id}/status tells a customer about
+// their order: nothing that isn't already on their receipt, apart from
+// what its fulfillment delivered.
+type OrderStatusView struct {
+	OrderID      string            `json:"orderId"`
+	Status       OrderStatus       `json:"status"`
+	Amount       int64             `json:"amount"`
+	Currency     string            `json:"currency"`
+	Fulfillments []FulfillmentView `json:"fulfillments,omitempty"`
+	UpdatedAt    time.Time         `json:"updatedAt"`
+}
+
+// OrderStatusToken signs an order ID for its status link. Order IDs are
+// random, so the token doesn't expire.
+func (svc *Service) OrderStatusToken(orderID string) string {
+	mac := hmac.New(sha256.New, []byte(svc.Config.OrderStatusSigningKey))
+	mac.Write([]byte("order-status." + orderID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (svc *Service) VerifyOrderStatusToken(orderID, token string) error {
+	if !hmac.Equal([]byte(token), []byte(svc.OrderStatusToken(orderID))) {
+		return errors.New("invalid order status link")
+	}
+	return nil
+}
+
+// OrderStatusURL is the status link put in confirmation emails, or "" when
+// ORDER_STATUS_SIGNING_KEY isn't set.
+func (svc *Service) OrderStatusURL(orderID string) string {
+	if svc.Config.OrderStatusSigningKey == "" {
+		return ""
+	}
+	return svc.Config.Domain + "/orders/" + orderID + "/status?token=" + svc.OrderStatusToken(orderID)
+}
+
+// WithOrderStatus adds order_id and order_token to a success URL so the
+// success page can poll the order's status.
+func (svc *Service) WithOrderStatus(successURL, orderID string) string {
+	if svc.Config.OrderStatusSigningKey == "" {
+		return successURL
+	}
+	sep := "?"
+	if strings.Contains(successURL, "?") {
+		sep = "&"
+	}
+	return successURL + sep + "order_id=" + url.QueryEscape(orderID) + "&order_token=" + svc.OrderStatusToken(orderID)
+}
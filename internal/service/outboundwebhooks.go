@@ -0,0 +1,159 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"stripe_go/internal/config"
+)
+
+// PaymentEvent is the body posted to outbound webhooks when a payment
+// changes status. Every endpoint, and every retry, gets the same ID, so
+// receivers can drop duplicates.
+type PaymentEvent struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type"`
+	Created        time.Time `json:"created"`
+	PreviousStatus string    `json:"previousStatus,omitempty"`
+	Payment        *Payment  `json:"payment"`
+}
+
+// The statuses of a WebhookDelivery. A failed delivery is retried by the
+// job queue until it succeeds or runs out of attempts.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// outboundHTTPClient posts to the outbound webhook endpoints.
+var outboundHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// publishPaymentEvent logs a delivery of p's move from previous to its
+// current status for every outbound endpoint and queues them.
+func (svc *Service) publishPaymentEvent(ctx context.Context, p *Payment, previous string) error {
+	eventType := config.PaymentEventPrefix + p.Status
+	if len(svc.Config.OutboundWebhooks) == 0 ||
+		(len(svc.Config.OutboundWebhookEvents) > 0 && !slices.Contains(svc.Config.OutboundWebhookEvents, eventType)) {
+		return nil
+	}
+	event := &PaymentEvent{
+		ID:             "evt_" + NewRequestID(),
+		Type:           eventType,
+		Created:        time.Now().UTC(),
+		PreviousStatus: previous,
+		Payment:        p,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for _, e := range svc.Config.OutboundWebhooks {
+		d := &WebhookDelivery{
+			ID:        "whd_" + NewRequestID(),
+			Endpoint:  e.Name,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Payload:   payload,
+			Status:    DeliveryPending,
+		}
+		if err := svc.Payments.SaveWebhookDelivery(ctx, d); err != nil {
+			return fmt.Errorf("logging webhook delivery: %w", err)
+		}
+		if err := svc.Jobs.Enqueue(ctx, JobDeliverWebhook, d.ID); err != nil {
+			return err
+		}
+	}
+	slog.Info("payment event published", "event", event.ID, "type", event.Type, "session", p.SessionID)
+	return nil
+}
+
+// DeliverWebhook makes one attempt at a logged delivery, to an outbound
+// webhook or an event forward, and records how it went. A failed attempt is returned as an error so the job is retried.
+func (svc *Service) DeliverWebhook(ctx context.Context, id string) error {
+	d, err := svc.Payments.GetWebhookDelivery(ctx, id)
+	if err == ErrDeliveryNotFound {
+		slog.Warn("dropping delivery that isn't logged", "delivery", id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if d.Status == DeliverySucceeded {
+		return nil
+	}
+	i := slices.IndexFunc(svc.Config.OutboundWebhooks, func(e config.OutboundWebhook) bool { return e.Name == d.Endpoint })
+	forward := svc.eventForward(d.Endpoint)
+	if i < 0 && forward == nil {
+		// Retrying won't bring a removed endpoint back.
+		d.Status, d.LastError = DeliveryFailed, "endpoint is no longer configured"
+		return svc.Payments.SaveWebhookDelivery(ctx, d)
+	}
+
+	d.Attempts++
+	switch {
+	case i >= 0:
+		d.ResponseStatus, err = svc.postWebhook(ctx, svc.Config.OutboundWebhooks[i].URL, d)
+	case forward.URL != "":
+		d.ResponseStatus, err = svc.postWebhook(ctx, forward.URL, d)
+	default:
+		err = svc.publishForward(ctx, forward, d)
+	}
+	if err != nil {
+		d.Status, d.LastError = DeliveryFailed, err.Error()
+	} else {
+		d.Status, d.LastError = DeliverySucceeded, ""
+	}
+	if saveErr := svc.Payments.SaveWebhookDelivery(ctx, d); saveErr != nil {
+		slog.Error("logging webhook delivery attempt", "delivery", d.ID, "error", saveErr)
+	}
+	if err != nil {
+		return fmt.Errorf("delivering %s to %s: %w", d.EventID, d.Endpoint, err)
+	}
+	slog.Info("webhook delivered", "delivery", d.ID, "endpoint", d.Endpoint, "event", d.EventID, "status", d.ResponseStatus)
+	return nil
+}
+
+// postWebhook posts a delivery's payload and returns the response status.
+// Anything but a 2xx fails the attempt.
+func (svc *Service) postWebhook(ctx context.Context, url string, d *WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", d.EventID)
+	req.Header.Set("Webhook-Signature", svc.WebhookSignature(d.Payload, time.Now()))
+	resp, err := outboundHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New(resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// WebhookSignature is the Webhook-Signature header for body sent at t:
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">" under
+// OUTBOUND_WEBHOOK_SECRET. It is laid out like Stripe-Signature, so
+// receivers can verify it the same way, including rejecting old timestamps.
+func (svc *Service) WebhookSignature(body []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(svc.Config.OutboundWebhookSecret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// PaymentIntentRequest is the body accepted by /create-payment-intent.
+type PaymentIntentRequest struct {
+	Amount   int64             `json:"amount"`
+	Currency string            `json:"currency"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// PaymentIntentResponse is returned by /create-payment-intent.
+type PaymentIntentResponse struct {
+	ID           string `json:"id"`
+	ClientSecret string `json:"clientSecret"`
+}
+
+func (p *PaymentIntentRequest) Validate(ctx context.Context, svc *Service) error {
+	if p.Amount < 1 {
+		return fmt.Errorf("amount must be a positive number of the smallest currency unit")
+	}
+	p.Currency = strings.ToLower(p.Currency)
+	if !config.CurrencyPattern.MatchString(p.Currency) {
+		return fmt.Errorf("invalid currency %q", p.Currency)
+	}
+	return validateMetadata(p.Metadata)
+}
+
+// RecordPaymentIntent stores the intent's status and returns the updated
+// payment. Intents created through Checkout update their session's record;
+// Elements payments have no session and are stored under the intent ID.
+func (svc *Service) RecordPaymentIntent(ctx context.Context, pi *stripe.PaymentIntent, status string) (*Payment, error) {
+	p, err := svc.Payments.GetPaymentByIntent(ctx, pi.ID)
+	if err == ErrPaymentNotFound {
+		p = &Payment{SessionID: pi.ID, PaymentIntentID: pi.ID}
+	} else if err != nil {
+		return nil, err
+	}
+	p.Amount = pi.Amount
+	p.Currency = string(pi.Currency)
+	if pi.AmountCapturable > 0 {
+		p.AmountAuthorized = pi.AmountCapturable
+	}
+	if pi.AmountReceived > 0 && p.AmountAuthorized > 0 {
+		p.AmountCaptured = pi.AmountReceived
+	}
+	if len(p.Metadata) == 0 {
+		p.Metadata = pi.Metadata
+	}
+	previous := p.Status
+	SetPaymentStatus(p, status)
+	return p, svc.SavePayment(ctx, p, previous)
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// paymentLinkExpiresKey is the payment link metadata key holding when the
+// link is deactivated, in RFC 3339. Stripe links don't expire on their own.
+const paymentLinkExpiresKey = "expires_at"
+
+// PaymentLinkRequest is the body accepted by POST /payment-links. Quantity
+// defaults to 1 and a zero ExpiresAt keeps the link active until it is
+// deactivated in the dashboard.
+type PaymentLinkRequest struct {
+	Price     string            `json:"price"`
+	Quantity  int64             `json:"quantity"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+func (req *PaymentLinkRequest) Validate(ctx context.Context, svc *Service) error {
+	if req.Price == "" {
+		return errors.New("price is required")
+	}
+	if _, ok := svc.CatalogFor(ctx).Price(req.Price); !ok {
+		return fmt.Errorf("unknown price %q", req.Price)
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	if err := svc.validateQuantity(req.Quantity); err != nil {
+		return err
+	}
+	if !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(time.Now()) {
+		return errors.New("expiresAt must be in the future")
+	}
+	if _, ok := req.Metadata[paymentLinkExpiresKey]; ok {
+		return fmt.Errorf("metadata key %q is reserved", paymentLinkExpiresKey)
+	}
+	return validateMetadata(req.Metadata)
+}
+
+// PaymentLinkView is a payment link as the API returns it.
+type PaymentLinkView struct {
+	ID        string            `json:"id"`
+	URL       string            `json:"url"`
+	Active    bool              `json:"active"`
+	Price     string            `json:"price,omitempty"`
+	Quantity  int64             `json:"quantity,omitempty"`
+	ExpiresAt *time.Time        `json:"expiresAt,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// NewPaymentLinkView describes link. Its line items are only known when
+// they were expanded.
+func NewPaymentLinkView(link *stripe.PaymentLink) *PaymentLinkView {
+	v := &PaymentLinkView{ID: link.ID, URL: link.URL, Active: link.Active, Metadata: map[string]string{}}
+	for k, val := range link.Metadata {
+		if k != paymentLinkExpiresKey {
+			v.Metadata[k] = val
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, link.Metadata[paymentLinkExpiresKey]); err == nil {
+		v.ExpiresAt = &t
+	}
+	if link.LineItems != nil && len(link.LineItems.Data) > 0 {
+		li := link.LineItems.Data[0]
+		if li.Price != nil {
+			v.Price = li.Price.ID
+		}
+		v.Quantity = li.Quantity
+	}
+	return v
+}
+
+// CreatePaymentLink creates a Stripe payment link for req and, when it
+// expires, queues its deactivation for then.
+func (svc *Service) CreatePaymentLink(ctx context.Context, req *PaymentLinkRequest) (*PaymentLinkView, error) {
+	params := &stripe.PaymentLinkParams{
+		LineItems: []*stripe.PaymentLinkLineItemParams{{
+			Price:    stripe.String(req.Price),
+			Quantity: stripe.Int64(req.Quantity),
+		}},
+	}
+	for k, v := range req.Metadata {
+		params.AddMetadata(k, v)
+	}
+	if !req.ExpiresAt.IsZero() {
+		params.AddMetadata(paymentLinkExpiresKey, req.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "payment_link"))
+	link, err := svc.Stripe.NewPaymentLink(ctx, params)
+	if err != nil {
+		return nil, &StripeFailure{"creating payment link", err}
+	}
+	// The link is live; schedule its expiry even if the caller has gone
+	// away.
+	ctx = context.WithoutCancel(ctx)
+	v := NewPaymentLinkView(link)
+	// Stripe doesn't return line items unless expanded.
+	v.Price, v.Quantity = req.Price, req.Quantity
+	if v.ExpiresAt != nil {
+		if err := svc.Jobs.EnqueueAt(ctx, JobDeactivatePaymentLink, link.ID, *v.ExpiresAt); err != nil {
+			return nil, InternalError("scheduling payment link expiry", err)
+		}
+	}
+	svc.RecordAudit(ctx, AuditActor(ctx), "payment_link.created", link.ID, nil, v)
+	return v, nil
+}
+
+// deactivatePaymentLink turns off an expired payment link, so visitors are
+// shown Stripe's deactivated page.
+func (svc *Service) deactivatePaymentLink(ctx context.Context, id string) error {
+	params := &stripe.PaymentLinkParams{Active: stripe.Bool(false)}
+	if _, err := svc.Stripe.UpdatePaymentLink(ctx, id, params); err != nil {
+		return fmt.Errorf("deactivating payment link: %w", err)
+	}
+	slog.Info("payment link expired", "payment_link", id)
+	return nil
+}
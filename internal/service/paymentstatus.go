@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+)
+
+// paymentTransitions lists where a payment can go from each settled status.
+// Stripe doesn't deliver events in order, so a late event must not undo a
+// later state: a refund stays a refund even if checkout.session.completed
+// arrives after charge.refunded. Statuses not listed here (unpaid, failed,
+// requires_payment_method, ...) can move to any status. Authorized payments
+// were created with manual capture and wait to be captured or canceled.
+var paymentTransitions = map[string][]string{
+	"authorized":         {"paid", "canceled"},
+	"canceled":           {},
+	"paid":               {"partially_refunded", "refunded", "disputed"},
+	"partially_refunded": {"refunded", "disputed"},
+	"disputed":           {"paid", "partially_refunded", "refunded"},
+	"refunded":           {},
+}
+
+// canTransition reports whether a payment in status from may move to to.
+func canTransition(from, to string) bool {
+	next, settled := paymentTransitions[from]
+	return from == to || !settled || slices.Contains(next, to)
+}
+
+// SetPaymentStatus moves p to status if the transition is allowed, and
+// reports whether it did. New payments take any status.
+func SetPaymentStatus(p *Payment, status string) bool {
+	if p.Status != "" && !canTransition(p.Status, status) {
+		slog.Info("ignoring out of order payment status",
+			"session", p.SessionID,
+			"status", p.Status,
+			"ignored_status", status,
+		)
+		return false
+	}
+	p.Status = status
+	return true
+}
+
+// SavePayment stores p and, if its status moved on from previous, tells the
+// outbound webhooks.
+func (svc *Service) SavePayment(ctx context.Context, p *Payment, previous string) error {
+	if err := svc.Payments.SavePayment(ctx, p); err != nil {
+		return err
+	}
+	if p.Status == "" || p.Status == previous {
+		return nil
+	}
+	return svc.publishPaymentEvent(ctx, p, previous)
+}
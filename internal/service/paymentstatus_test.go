@@ -0,0 +1,25 @@
+package service
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	for _, tt := range []struct {
+		from, to string
+		want     bool
+	}{
+		{"unpaid", "paid", true},
+		{"failed", "paid", true},
+		{"paid", "paid", true},
+		{"paid", "failed", false},
+		{"paid", "unpaid", false},
+		{"paid", "disputed", true},
+		{"partially_refunded", "refunded", true},
+		{"refunded", "partially_refunded", false},
+		{"refunded", "paid", false},
+		{"disputed", "paid", true},
+	} {
+		if got := canTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("canTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
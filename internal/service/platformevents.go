@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// PlatformEventVersion is the schema version of PlatformEvent and its data.
+// It goes up with any change consumers have to handle; new fields aren't
+// one.
+const PlatformEventVersion = 1
+
+// The types of PlatformEvent.
+const (
+	platformOrderPaid            = "order.paid"
+	platformOrderRefunded        = "order.refunded"
+	platformSubscriptionCanceled = "subscription.canceled"
+)
+
+// PlatformEvent is published on EVENT_BUS when the webhook pipeline sees an
+// order paid or refunded or a subscription canceled, so the rest of the
+// platform can react without knowing Stripe's objects. Delivery is at least
+// once: an event published again keeps its ID, which is derived from the
+// subject and type, so consumers can drop duplicates.
+type PlatformEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+	Source  string `json:"source"`
+	// Subject is the ID of the order or subscription; Kafka partitions by
+	// it.
+	Subject     string    `json:"subject"`
+	Tenant      string    `json:"tenant,omitempty"`
+	OccurredAt  time.Time `json:"occurredAt"`
+	StripeEvent string    `json:"stripeEvent"`
+	// Data is an OrderEventData or a SubscriptionEventData.
+	Data json.RawMessage `json:"data"`
+}
+
+// OrderEventData is the data of order events.
+type OrderEventData struct {
+	OrderID         string      `json:"orderId"`
+	Status          OrderStatus `json:"status"`
+	Amount          int64       `json:"amount"`
+	Currency        string      `json:"currency"`
+	Email           string      `json:"email,omitempty"`
+	PaymentIntentID string      `json:"paymentIntentId,omitempty"`
+	Items           []OrderItem `json:"items"`
+}
+
+// SubscriptionEventData is the data of subscription events.
+type SubscriptionEventData struct {
+	SubscriptionID string     `json:"subscriptionId"`
+	CustomerID     string     `json:"customerId"`
+	PriceID        string     `json:"priceId,omitempty"`
+	Status         string     `json:"status"`
+	CanceledAt     *time.Time `json:"canceledAt,omitempty"`
+}
+
+// PublishOrderEvent publishes the event for o reaching status as event
+// moved it there. It publishes whenever o is in status afterwards, not only
+// when it just got there, so a webhook that failed after the order was
+// saved still publishes on Stripe's redelivery.
+func (svc *Service) PublishOrderEvent(ctx context.Context, event stripe.Event, o *Order, status OrderStatus) error {
+	var eventType string
+	switch {
+	case o.Status != status:
+		return nil
+	case status == OrderPaid:
+		eventType = platformOrderPaid
+	case status == OrderRefunded:
+		eventType = platformOrderRefunded
+	default:
+		return nil
+	}
+	return svc.publishPlatformEvent(ctx, event, eventType, o.ID, OrderEventData{
+		OrderID:         o.ID,
+		Status:          o.Status,
+		Amount:          o.Amount,
+		Currency:        o.Currency,
+		Email:           o.Email,
+		PaymentIntentID: o.PaymentIntentID,
+		Items:           o.Items,
+	})
+}
+
+// PublishSubscriptionCanceled publishes the cancellation of sub.
+func (svc *Service) PublishSubscriptionCanceled(ctx context.Context, event stripe.Event, sub *Subscription) error {
+	return svc.publishPlatformEvent(ctx, event, platformSubscriptionCanceled, sub.ID, SubscriptionEventData{
+		SubscriptionID: sub.ID,
+		CustomerID:     sub.CustomerID,
+		PriceID:        sub.PriceID,
+		Status:         sub.Status,
+		CanceledAt:     sub.CanceledAt,
+	})
+}
+
+// publishPlatformEvent queues a platform event about subject, caused by the
+// Stripe event. The job queue retries the publish until the bus has it.
+func (svc *Service) publishPlatformEvent(ctx context.Context, event stripe.Event, eventType, subject string, data interface{}) error {
+	if svc.Config.EventBus == "" {
+		return nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	pe := &PlatformEvent{
+		ID:          "pev_" + config.SHA256Hex(subject + ":" + eventType)[:24],
+		Type:        eventType,
+		Version:     PlatformEventVersion,
+		Source:      svc.Config.ServiceName,
+		Subject:     subject,
+		OccurredAt:  time.Unix(event.Created, 0).UTC(),
+		StripeEvent: event.ID,
+		Data:        raw,
+	}
+	if t := TenantFrom(ctx); t != nil {
+		pe.Tenant = t.ID
+	}
+	if err := svc.Jobs.Enqueue(ctx, JobPublishEvent, pe); err != nil {
+		return err
+	}
+	LogCtx(ctx).Info("platform event queued", "event", pe.ID, "type", pe.Type, "subject", subject, "stripe_event", event.ID)
+	return nil
+}
+
+// sendPlatformEvent makes one attempt at publishing pe on EVENT_BUS. It is
+// signed like forwarded events when OUTBOUND_WEBHOOK_SECRET is set.
+func (svc *Service) sendPlatformEvent(ctx context.Context, pe *PlatformEvent) error {
+	bus, err := svc.messageBus(svc.Config.EventBus)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(pe)
+	if err != nil {
+		return err
+	}
+	msg := &BusMessage{ID: pe.ID, Type: pe.Type, Key: pe.Subject, Payload: payload}
+	if svc.Config.OutboundWebhookSecret != "" {
+		msg.Signature = svc.WebhookSignature(payload, time.Now())
+	}
+	if err := bus.Publish(ctx, svc.Config.EventBusTopic, msg); err != nil {
+		return err
+	}
+	LogCtx(ctx).Info("platform event published", "event", pe.ID, "type", pe.Type, "bus", svc.Config.EventBus)
+	return nil
+}
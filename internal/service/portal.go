@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// PortalRequest is the body accepted by /create-portal-session. Token is the
+// portal token the success page gives subscribers for Customer; admin
+// callers don't need one.
+type PortalRequest struct {
+	Customer  string `json:"customer"`
+	Token     string `json:"token"`
+	ReturnURL string `json:"returnUrl"`
+}
+
+// PortalResponse is returned to JSON clients of /create-portal-session
+// instead of a redirect.
+type PortalResponse struct {
+	URL string `json:"url"`
+}
+
+func (p *PortalRequest) Validate(ctx context.Context, svc *Service) error {
+	if err := ValidateStripeID(p.Customer, "cus_", "customer"); err != nil {
+		return err
+	}
+	if p.ReturnURL != "" {
+		return svc.validateReturnURL(p.ReturnURL, "returnUrl")
+	}
+	return nil
+}
+
+// PortalToken signs a customer's access to the Billing Portal. Like receipt
+// tokens it is "<expiry>.<hmac>", so it stops working after PORTAL_LINK_TTL
+// without any server-side state.
+func (svc *Service) PortalToken(customerID string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + svc.portalSignature(customerID, exp)
+}
+
+func (svc *Service) portalSignature(customerID, exp string) string {
+	mac := hmac.New(sha256.New, []byte(svc.Config.PortalSigningKey))
+	mac.Write([]byte("portal." + customerID + "." + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (svc *Service) VerifyPortalToken(customerID, token string, now time.Time) error {
+	exp, sig, ok := strings.Cut(token, ".")
+	if svc.Config.PortalSigningKey == "" || !ok || !hmac.Equal([]byte(sig), []byte(svc.portalSignature(customerID, exp))) {
+		return errors.New("invalid portal link")
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.After(time.Unix(unix, 0)) {
+		return errors.New("portal link has expired")
+	}
+	return nil
+}
+
+// SubscriberPortalToken is the portal token the success page gives the
+// customer of s, or "" when s has none or PORTAL_SIGNING_KEY isn't set.
+func (svc *Service) SubscriberPortalToken(s *stripe.CheckoutSession) string {
+	if svc.Config.PortalSigningKey == "" || s.Customer == nil || s.Mode != stripe.CheckoutSessionModeSubscription {
+		return ""
+	}
+	return svc.PortalToken(s.Customer.ID, time.Now().Add(svc.Config.PortalLinkTTL))
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// discountParams validates a coupon ID or customer-facing promotion code
+// against Stripe and returns the discount to put on the session. At most one
+// of the two may be given.
+func (svc *Service) discountParams(ctx context.Context, couponID, code string) ([]*stripe.CheckoutSessionDiscountParams, error) {
+	switch {
+	case couponID != "" && code != "":
+		return nil, errors.New("only one of coupon and promotionCode can be applied")
+	case couponID != "":
+		c, err := svc.Stripe.GetCoupon(ctx, couponID, nil)
+		if err != nil || !c.Valid {
+			return nil, fmt.Errorf("invalid coupon %q", couponID)
+		}
+		return []*stripe.CheckoutSessionDiscountParams{{Coupon: stripe.String(c.ID)}}, nil
+	case code != "":
+		pc, err := svc.findPromotionCode(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+		return []*stripe.CheckoutSessionDiscountParams{{PromotionCode: stripe.String(pc.ID)}}, nil
+	}
+	return nil, nil
+}
+
+func (svc *Service) findPromotionCode(ctx context.Context, code string) (*stripe.PromotionCode, error) {
+	params := &stripe.PromotionCodeListParams{
+		Code:   stripe.String(code),
+		Active: stripe.Bool(true),
+	}
+	params.Filters.AddFilter("limit", "", "1")
+	codes, err := svc.Stripe.ListPromotionCodes(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("error while looking up promotion code %v", err.Error())
+	}
+	if len(codes) > 0 {
+		return codes[0], nil
+	}
+	return nil, fmt.Errorf("invalid promotion code %q", code)
+}
+
+// Promotion is the storefront view of an active promotion code.
+type Promotion struct {
+	Code       string  `json:"code"`
+	Name       string  `json:"name,omitempty"`
+	PercentOff float64 `json:"percentOff,omitempty"`
+	AmountOff  int64   `json:"amountOff,omitempty"`
+	Currency   string  `json:"currency,omitempty"`
+	ExpiresAt  int64   `json:"expiresAt,omitempty"`
+}
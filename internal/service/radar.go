@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// HeldRiskLevels are the Radar risk levels whose orders aren't fulfilled
+// until their review is approved.
+var HeldRiskLevels = map[string]bool{"elevated": true, "highest": true}
+
+// ReviewFromStripe converts a Stripe review to the local record.
+func ReviewFromStripe(rv *stripe.Review) *Review {
+	rec := &Review{
+		ID:           rv.ID,
+		Open:         rv.Open,
+		OpenedReason: string(rv.OpenedReason),
+		ClosedReason: string(rv.ClosedReason),
+	}
+	if rv.Charge != nil {
+		rec.ChargeID = rv.Charge.ID
+	}
+	if rv.PaymentIntent != nil {
+		rec.PaymentIntentID = rv.PaymentIntent.ID
+	}
+	if rv.Created != 0 {
+		rec.CreatedAt = time.Unix(rv.Created, 0).UTC()
+	}
+	return rec
+}
+
+// ReleaseFulfillment queues the fulfillment of the order paid by
+// paymentIntentID, if it has one.
+func (svc *Service) ReleaseFulfillment(ctx context.Context, paymentIntentID string) error {
+	if len(svc.Config.FulfillmentRoutes) == 0 || paymentIntentID == "" {
+		return nil
+	}
+	p, err := svc.Payments.GetPaymentByIntent(ctx, paymentIntentID)
+	if err == ErrPaymentNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	o, err := svc.Payments.GetOrderBySession(ctx, p.SessionID)
+	if err == ErrOrderNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return svc.Jobs.Enqueue(ctx, JobFulfillOrder, o.ID)
+}
+
+// fulfillmentHold returns why o mustn't be fulfilled yet, or "" if it may
+// be: its payment has an open review, or Radar rated it elevated or highest
+// risk and no review of it has been approved.
+func (svc *Service) fulfillmentHold(ctx context.Context, o *Order) (string, error) {
+	if o.PaymentIntentID == "" {
+		return "", nil
+	}
+	reviews, err := svc.Payments.ListReviews(ctx, ReviewFilter{PaymentIntentID: o.PaymentIntentID})
+	if err != nil {
+		return "", err
+	}
+	for _, rv := range reviews {
+		if rv.Open {
+			return "payment in review " + rv.ID, nil
+		}
+	}
+	p, err := svc.Payments.GetPaymentByIntent(ctx, o.PaymentIntentID)
+	if err == ErrPaymentNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !HeldRiskLevels[p.RiskLevel] {
+		return "", nil
+	}
+	for _, rv := range reviews {
+		if rv.ClosedReason == string(stripe.ReviewClosedReasonApproved) {
+			return "", nil
+		}
+	}
+	return p.RiskLevel + " risk payment", nil
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/stripe/stripe-go/v72"
+)
+
+// ReceiptToken signs a download link for a session's receipt. The token is
+// "<expiry>.<hmac>" so links stop working after RECEIPT_LINK_TTL without any
+// server-side state.
+func (svc *Service) ReceiptToken(sessionID string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + svc.receiptSignature(sessionID, exp)
+}
+
+func (svc *Service) receiptSignature(sessionID, exp string) string {
+	mac := hmac.New(sha256.New, []byte(svc.Config.ReceiptSigningKey))
+	mac.Write([]byte(sessionID + "." + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (svc *Service) VerifyReceiptToken(sessionID, token string, now time.Time) error {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(svc.receiptSignature(sessionID, exp))) {
+		return errors.New("invalid receipt link")
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.After(time.Unix(unix, 0)) {
+		return errors.New("receipt link has expired")
+	}
+	return nil
+}
+
+// ReceiptURL is the download link put in confirmation emails, or "" when
+// RECEIPT_SIGNING_KEY isn't set.
+func (svc *Service) ReceiptURL(sessionID string) string {
+	if svc.Config.ReceiptSigningKey == "" {
+		return ""
+	}
+	token := svc.ReceiptToken(sessionID, time.Now().Add(svc.Config.ReceiptLinkTTL))
+	return svc.Config.Domain + "/receipts/" + sessionID + ".pdf?token=" + url.QueryEscape(token)
+}
+
+// receiptDocument is what a PDF receipt shows.
+type receiptDocument struct {
+	Number          string
+	Date            time.Time
+	Email           string
+	TaxIDs          []string
+	Order           string
+	PaymentIntentID string
+	Lines           []receiptLine
+	// Totals are label and amount pairs, ending with the total paid.
+	Totals [][2]string
+}
+
+type receiptLine struct {
+	Description string
+	Quantity    int64
+	UnitAmount  string
+	Amount      string
+}
+
+// NewReceiptDocument lays out a paid session. The receipt is dated when its
+// payment intent was created, which needs the session's payment_intent
+// expanded; otherwise it's dated now.
+func (svc *Service) NewReceiptDocument(s *stripe.CheckoutSession, items []*stripe.LineItem, now time.Time) *receiptDocument {
+	currency := string(s.Currency)
+	doc := &receiptDocument{
+		Number: s.ID,
+		Date:   now.UTC(),
+		Order:  s.Metadata["order_id"],
+	}
+	if s.CustomerDetails != nil {
+		doc.Email = s.CustomerDetails.Email
+	}
+	doc.TaxIDs = svc.receiptTaxIDs(s)
+	if s.PaymentIntent != nil {
+		doc.PaymentIntentID = s.PaymentIntent.ID
+		if s.PaymentIntent.Created != 0 {
+			doc.Date = time.Unix(s.PaymentIntent.Created, 0).UTC()
+		}
+	}
+	for _, li := range items {
+		line := receiptLine{
+			Description: li.Description,
+			Quantity:    li.Quantity,
+			Amount:      FormatAmount(li.AmountSubtotal, currency),
+		}
+		if li.Quantity > 0 {
+			line.UnitAmount = FormatAmount(li.AmountSubtotal/li.Quantity, currency)
+		}
+		doc.Lines = append(doc.Lines, line)
+	}
+	doc.Totals = append(doc.Totals, [2]string{"Subtotal", FormatAmount(s.AmountSubtotal, currency)})
+	if d := s.TotalDetails; d != nil {
+		if d.AmountDiscount > 0 {
+			doc.Totals = append(doc.Totals, [2]string{"Discount", "-" + FormatAmount(d.AmountDiscount, currency)})
+		}
+		if d.AmountShipping > 0 {
+			doc.Totals = append(doc.Totals, [2]string{"Shipping", FormatAmount(d.AmountShipping, currency)})
+		}
+		doc.Totals = append(doc.Totals, [2]string{"Tax", FormatAmount(d.AmountTax, currency)})
+	}
+	doc.Totals = append(doc.Totals, [2]string{"Total paid", FormatAmount(s.AmountTotal, currency)})
+	return doc
+}
+
+// PDF renders the receipt on a single A4 page.
+func (doc *receiptDocument) PDF() ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Receipt "+doc.Number, true)
+	pdf.SetCreationDate(doc.Date)
+	pdf.SetModificationDate(doc.Date)
+	// The core fonts are cp1252; translate product names from UTF-8.
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.Cell(0, 12, "Receipt")
+	pdf.Ln(16)
+	pdf.SetFont("Helvetica", "", 10)
+	for _, row := range [][2]string{
+		{"Receipt number", doc.Number},
+		{"Date", doc.Date.Format("2 January 2006")},
+		{"Billed to", doc.Email},
+		{"Tax ID", strings.Join(doc.TaxIDs, ", ")},
+		{"Order", doc.Order},
+		{"Payment reference", doc.PaymentIntentID},
+	} {
+		if row[1] == "" {
+			continue
+		}
+		pdf.CellFormat(45, 6, row[0], "", 0, "", false, 0, "")
+		pdf.CellFormat(0, 6, tr(row[1]), "", 1, "", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	widths := []float64{95, 20, 35, 40}
+	pdf.SetFont("Helvetica", "B", 10)
+	for i, h := range []string{"Description", "Qty", "Unit price", "Amount"} {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		pdf.CellFormat(widths[i], 8, h, "B", 0, align, false, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 10)
+	for _, line := range doc.Lines {
+		pdf.CellFormat(widths[0], 7, tr(line.Description), "", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 7, strconv.FormatInt(line.Quantity, 10), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[2], 7, line.UnitAmount, "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 7, line.Amount, "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(4)
+	for i, total := range doc.Totals {
+		if i == len(doc.Totals)-1 {
+			pdf.SetFont("Helvetica", "B", 11)
+		}
+		pdf.CellFormat(widths[0]+widths[1]+widths[2], 7, total[0], "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 7, total[1], "", 1, "R", false, 0, "")
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// Drift is a difference between Stripe and the payment store. Event is the
+// webhook the reconciler replayed to repair it; Error is set if that failed.
+type Drift struct {
+	Object string `json:"object"`
+	Local  string `json:"local"`
+	Stripe string `json:"stripe"`
+	Event  string `json:"event"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReconcileReport is the outcome of one reconciliation run.
+type ReconcileReport struct {
+	StartedAt             time.Time `json:"startedAt"`
+	Duration              string    `json:"duration"`
+	Since                 time.Time `json:"since"`
+	SessionsChecked       int       `json:"sessionsChecked"`
+	PaymentIntentsChecked int       `json:"paymentIntentsChecked"`
+	Repaired              int       `json:"repaired"`
+	Failed                int       `json:"failed"`
+	Drift                 []Drift   `json:"drift"`
+}
+
+// ReconcileStats add up every run since the process started.
+type ReconcileStats struct {
+	Runs     int64            `json:"runs"`
+	Drift    int64            `json:"drift"`
+	Repaired int64            `json:"repaired"`
+	Failed   int64            `json:"failed"`
+	Last     *ReconcileReport `json:"last"`
+}
+
+// ReconcileTotals returns the stats of every run so far. It waits for a
+// run in progress to finish.
+func (svc *Service) ReconcileTotals() ReconcileStats {
+	svc.reconcileMu.Lock()
+	defer svc.reconcileMu.Unlock()
+	return svc.reconcileStats
+}
+
+// Reconcile repairs what missed webhooks left behind. It lists the checkout
+// sessions and payment intents Stripe created since since and, where the
+// payment store is behind, replays the webhook that should have arrived
+// through the webhook handlers, so payments, orders, inventory and receipts
+// catch up exactly as if Stripe had delivered it. Only one run happens at a
+// time.
+func (svc *Service) Reconcile(ctx context.Context, since time.Time) (*ReconcileReport, error) {
+	svc.reconcileMu.Lock()
+	defer svc.reconcileMu.Unlock()
+	report := &ReconcileReport{StartedAt: time.Now(), Since: since, Drift: []Drift{}}
+
+	sessionParams := &stripe.CheckoutSessionListParams{}
+	sessionParams.Filters.AddFilter("created", "gte", fmt.Sprint(since.Unix()))
+	sessions, err := svc.Stripe.ListCheckoutSessions(ctx, sessionParams)
+	if err != nil {
+		return nil, fmt.Errorf("listing checkout sessions: %w", err)
+	}
+	for _, s := range sessions {
+		report.SessionsChecked++
+		eventType, local, err := svc.sessionDrift(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		if eventType == "" || !report.repair(ctx, svc, s.ID, local, sessionState(s), eventType, s) {
+			continue
+		}
+		// The replayed handlers update the payment from the job queue;
+		// record it now so the next run doesn't replay the event (and send
+		// the receipt) again before the job runs.
+		if eventType == "checkout.session.completed" {
+			if err := svc.UpdatePaymentStatus(ctx, s); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	intentParams := &stripe.PaymentIntentListParams{CreatedRange: &stripe.RangeQueryParams{GreaterThanOrEqual: since.Unix()}}
+	intents, err := svc.Stripe.ListPaymentIntents(ctx, intentParams)
+	if err != nil {
+		return nil, fmt.Errorf("listing payment intents: %w", err)
+	}
+	for _, pi := range intents {
+		report.PaymentIntentsChecked++
+		eventType, local, err := svc.intentDrift(ctx, pi)
+		if err != nil {
+			return nil, err
+		}
+		if eventType != "" {
+			report.repair(ctx, svc, pi.ID, local, string(pi.Status), eventType, pi)
+		}
+	}
+
+	report.Duration = time.Since(report.StartedAt).String()
+	svc.reconcileStats.Runs++
+	svc.reconcileStats.Drift += int64(len(report.Drift))
+	svc.reconcileStats.Repaired += int64(report.Repaired)
+	svc.reconcileStats.Failed += int64(report.Failed)
+	svc.reconcileStats.Last = report
+	level := slog.LevelInfo
+	if len(report.Drift) > 0 {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "reconciled payments with stripe",
+		"sessions", report.SessionsChecked,
+		"payment_intents", report.PaymentIntentsChecked,
+		"drift", len(report.Drift),
+		"repaired", report.Repaired,
+		"failed", report.Failed,
+	)
+	return report, nil
+}
+
+// sessionState describes a session the way the drift report shows it.
+func sessionState(s *stripe.CheckoutSession) string {
+	return string(s.Status) + "/" + string(s.PaymentStatus)
+}
+
+// sessionDrift returns the webhook to replay for s, if the payment store
+// hasn't caught up with it, and the session's local status. Completed
+// sessions must be recorded, and once Stripe says they are paid the record
+// must be too; expired sessions must not leave a pending order holding
+// stock.
+func (svc *Service) sessionDrift(ctx context.Context, s *stripe.CheckoutSession) (eventType, local string, err error) {
+	switch s.Status {
+	case stripe.CheckoutSessionStatusComplete:
+		p, err := svc.Payments.GetPayment(ctx, s.ID)
+		if err == ErrPaymentNotFound {
+			return "checkout.session.completed", "missing", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		// Settled payments have moved past what the session can tell us.
+		if _, settled := paymentTransitions[p.Status]; !settled && s.PaymentStatus != stripe.CheckoutSessionPaymentStatusUnpaid &&
+			p.Status != string(s.PaymentStatus) {
+			return "checkout.session.completed", p.Status, nil
+		}
+	case stripe.CheckoutSessionStatusExpired:
+		o, err := svc.SessionOrder(ctx, s)
+		if err == ErrOrderNotFound {
+			return "", "", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if o.Status == OrderPending {
+			return "checkout.session.expired", "order " + string(o.Status), nil
+		}
+	}
+	return "", "", nil
+}
+
+// intentDrift returns the webhook to replay for pi, if the payment store
+// knows the intent but hasn't caught up with it, and the local status.
+// Intents without a record belong to checkout sessions that haven't
+// completed, which sessionDrift covers.
+func (svc *Service) intentDrift(ctx context.Context, pi *stripe.PaymentIntent) (eventType, local string, err error) {
+	var status string
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		eventType, status = "payment_intent.succeeded", "paid"
+	case stripe.PaymentIntentStatusRequiresCapture:
+		eventType, status = "payment_intent.amount_capturable_updated", "authorized"
+	case stripe.PaymentIntentStatusCanceled:
+		eventType, status = "payment_intent.canceled", "canceled"
+	default:
+		return "", "", nil
+	}
+	p, err := svc.Payments.GetPaymentByIntent(ctx, pi.ID)
+	if err == ErrPaymentNotFound {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if p.Status == status || !canTransition(p.Status, status) {
+		return "", "", nil
+	}
+	return eventType, p.Status, nil
+}
+
+// repair replays eventType for object through svc, records the drift and
+// reports whether the replay succeeded.
+func (report *ReconcileReport) repair(ctx context.Context, svc *Service, id, local, remote, eventType string, object interface{}) bool {
+	d := Drift{Object: id, Local: local, Stripe: remote, Event: eventType}
+	if err := svc.replayObject(ctx, eventType, object); err != nil {
+		slog.Error("repairing drift", "object", id, "event_type", eventType, "error", err)
+		d.Error = err.Error()
+		report.Failed++
+	} else {
+		slog.Warn("repaired drift", "object", id, "local", local, "stripe", remote, "event_type", eventType)
+		report.Repaired++
+		svc.RecordAudit(ctx, actorReconciler, "reconcile.repaired", id,
+			map[string]string{"status": local}, map[string]string{"status": remote, "event": eventType})
+	}
+	report.Drift = append(report.Drift, d)
+	return d.Error == ""
+}
+
+// replayObject runs object through the webhook handlers as an eventType
+// event.
+func (svc *Service) replayObject(ctx context.Context, eventType string, object interface{}) error {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return svc.WebhookRouter.Dispatch(ctx, stripe.Event{
+		ID:   "reconcile_" + NewRequestID(),
+		Type: eventType,
+		Data: &stripe.EventData{Raw: raw},
+	})
+}
+
+// reconcileEvery runs reconcile over the last RECONCILE_WINDOW every
+// interval until ctx is done.
+func (svc *Service) reconcileEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := svc.Reconcile(ctx, time.Now().Add(-svc.Config.ReconcileWindow)); err != nil {
+				slog.Error("reconciling payments", "error", err)
+			}
+		}
+	}
+}
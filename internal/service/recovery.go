@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// SessionExpiry is when s stops accepting payment, or nil if Stripe didn't
+// say.
+func SessionExpiry(s *stripe.CheckoutSession) *time.Time {
+	if s.ExpiresAt == 0 {
+		return nil
+	}
+	t := time.Unix(s.ExpiresAt, 0).UTC()
+	return &t
+}
+
+// WithCheckoutRecovery has Stripe attach a recovery URL to the session if it
+// expires unpaid, and lets customers agree to promotional emails on the
+// Checkout page, without which the URL can't be emailed to them. Recovered
+// sessions take promotion codes when ALLOW_PROMOTION_CODES is set. It does
+// nothing unless CHECKOUT_RECOVERY is set.
+func (svc *Service) WithCheckoutRecovery(params *stripe.CheckoutSessionParams) {
+	if !svc.Config.CheckoutRecovery {
+		return
+	}
+	params.AfterExpiration = &stripe.CheckoutSessionAfterExpirationParams{
+		Recovery: &stripe.CheckoutSessionAfterExpirationRecoveryParams{
+			Enabled:             stripe.Bool(true),
+			AllowPromotionCodes: stripe.Bool(svc.Config.AllowPromotionCodes),
+		},
+	}
+	params.ConsentCollection = &stripe.CheckoutSessionConsentCollectionParams{
+		Promotions: stripe.String(string(stripe.CheckoutSessionConsentCollectionPromotionsAuto)),
+	}
+}
+
+// RecoveryMetadataKey is the session metadata key that links a session
+// created from a recovery email back to the abandoned checkout it recovers.
+// Clients can't set it.
+const RecoveryMetadataKey = "recovery"
+
+// Abandoned checkout statuses. A checkout is pending until its recovery
+// email is sent and recovered once a session created from it completes.
+const (
+	AbandonedPending   = "pending"
+	AbandonedEmailed   = "emailed"
+	AbandonedRecovered = "recovered"
+)
+
+// AbandonedCheckout is a session that expired unpaid and whose customer
+// agreed to be emailed about it. The recovery email links to
+// /recover/{ID}, which creates a new session for OrderID's items, or sends
+// the customer to StripeRecoveryURL for sessions without an order.
+// RecoverySessionID is the latest session created from it and
+// RecoveredAmount what the session that recovered it charged.
+type AbandonedCheckout struct {
+	ID                string     `json:"id"`
+	Status            string     `json:"status"`
+	SessionID         string     `json:"sessionId"`
+	OrderID           string     `json:"orderId,omitempty"`
+	Email             string     `json:"email"`
+	Locale            string     `json:"locale,omitempty"`
+	Amount            int64      `json:"amount"`
+	Currency          string     `json:"currency"`
+	StripeRecoveryURL string     `json:"stripeRecoveryUrl,omitempty"`
+	ExpiresAt         time.Time  `json:"expiresAt"`
+	RecoverySessionID string     `json:"recoverySessionId,omitempty"`
+	RecoveredAmount   int64      `json:"recoveredAmount,omitempty"`
+	EmailedAt         *time.Time `json:"emailedAt,omitempty"`
+	RecoveredAt       *time.Time `json:"recoveredAt,omitempty"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	UpdatedAt         time.Time  `json:"updatedAt"`
+}
+
+// RecoveryEmail invites a customer back to a checkout that expired before
+// they paid. URL opens a new session with the same cart until ExpiresAt;
+// Discount says RECOVERY_COUPON is applied to it.
+type RecoveryEmail struct {
+	Email     string
+	Locale    string
+	SessionID string
+	Amount    int64
+	Currency  string
+	URL       string
+	Discount  bool
+	ExpiresAt time.Time
+}
+
+// LockRecovery serializes the recovery of abandoned checkout id with the
+// webhook that marks it recovered. Call the returned func to unlock it.
+func (svc *Service) LockRecovery(id string) (unlock func()) {
+	return svc.recoveryLocks.Lock(id)
+}
+
+// SendRecoveryEmail sends the link back to the abandoned checkout id, unless
+// it has been sent or the checkout recovered already.
+func (svc *Service) SendRecoveryEmail(ctx context.Context, id string) error {
+	a, err := svc.Payments.GetAbandonedCheckout(ctx, id)
+	if err != nil {
+		return err
+	}
+	if a.Status != AbandonedPending {
+		return nil
+	}
+	msg, err := svc.EmailTemplates.Render("checkout_recovery", a.Locale, &RecoveryEmail{
+		Email:     a.Email,
+		Locale:    a.Locale,
+		SessionID: a.SessionID,
+		Amount:    a.Amount,
+		Currency:  a.Currency,
+		URL:       svc.Config.Domain + "/recover/" + a.ID,
+		Discount:  a.OrderID != "" && svc.Config.RecoveryCoupon != "",
+		ExpiresAt: a.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	msg.To = a.Email
+	slog.Info("sending checkout recovery email", "to", a.Email, "session", a.SessionID, "abandoned_checkout", a.ID)
+	if err := svc.EmailSender.Send(ctx, msg); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	a.Status = AbandonedEmailed
+	a.EmailedAt = &now
+	return svc.Payments.SaveAbandonedCheckout(ctx, a)
+}
+
+// RecoverCheckout returns the URL of a session for the abandoned checkout
+// id: the one an earlier click created while it is open, otherwise a new
+// one for the items of its order with RECOVERY_COUPON applied. Checkouts
+// without an order recover through Stripe's recovery URL.
+func (svc *Service) RecoverCheckout(ctx context.Context, id, acceptLanguage string) (string, error) {
+	defer svc.recoveryLocks.Lock(id)()
+	a, err := svc.Payments.GetAbandonedCheckout(ctx, id)
+	if err == ErrAbandonedCheckoutNotFound {
+		return "", &Error{Status: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return "", InternalError("fetching abandoned checkout", err)
+	}
+	if a.Status == AbandonedRecovered {
+		return "", &Error{Status: http.StatusConflict, Message: "this checkout has been completed"}
+	}
+	if time.Now().After(a.ExpiresAt) {
+		return "", &Error{Status: http.StatusGone, Message: "this recovery link has expired"}
+	}
+	if a.OrderID == "" {
+		return a.StripeRecoveryURL, nil
+	}
+	if a.RecoverySessionID != "" {
+		s, err := svc.CachedCheckoutSession(ctx, a.RecoverySessionID)
+		if err != nil {
+			return "", &StripeFailure{"fetching session", err}
+		}
+		switch s.Status {
+		case stripe.CheckoutSessionStatusOpen:
+			return s.URL, nil
+		case stripe.CheckoutSessionStatusComplete:
+			return "", &Error{Status: http.StatusConflict, Message: "this checkout has been completed"}
+		}
+	}
+
+	order, err := svc.Payments.GetOrder(ctx, a.OrderID)
+	if err != nil {
+		return "", InternalError("fetching order", err)
+	}
+	req := CreateCheckoutRequest{
+		Email:    a.Email,
+		Coupon:   svc.Config.RecoveryCoupon,
+		Currency: a.Currency,
+		Locale:   a.Locale,
+		Metadata: maps.Clone(order.Metadata),
+	}
+	for _, item := range order.Items {
+		req.Items = append(req.Items, CheckoutItem{Price: item.Price, Quantity: item.Quantity})
+	}
+	cart := req.Metadata[CartMetadataKey]
+	delete(req.Metadata, CartMetadataKey)
+	if err := req.Validate(ctx, svc); err != nil {
+		return "", BadRequest(err)
+	}
+	if req.Metadata == nil {
+		req.Metadata = map[string]string{}
+	}
+	// A saved cart is closed by whichever of its sessions completes.
+	if cart != "" {
+		req.Metadata[CartMetadataKey] = cart
+	}
+	req.Metadata[RecoveryMetadataKey] = a.ID
+	resp, err := svc.CreateCheckout(ctx, &req, acceptLanguage)
+	if err != nil {
+		return "", err
+	}
+	ctx = context.WithoutCancel(ctx)
+	a.RecoverySessionID = resp.ID
+	if err := svc.Payments.SaveAbandonedCheckout(ctx, a); err != nil {
+		LogCtx(ctx).Error("linking abandoned checkout", "abandoned_checkout", a.ID, "session", resp.ID, "error", err)
+	}
+	return resp.URL, nil
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// RefundRequest is the body accepted by POST /refunds. Amount is optional;
+// leaving it at zero refunds whatever is left on the payment.
+type RefundRequest struct {
+	PaymentIntentID string `json:"paymentIntentId"`
+	Amount          int64  `json:"amount"`
+	Reason          string `json:"reason"`
+}
+
+var refundReasons = map[string]bool{
+	"":                      true,
+	"duplicate":             true,
+	"fraudulent":            true,
+	"requested_by_customer": true,
+}
+
+func (req *RefundRequest) Validate(ctx context.Context, svc *Service) error {
+	if req.PaymentIntentID == "" {
+		return errors.New("paymentIntentId is required")
+	}
+	if err := ValidateStripeID(req.PaymentIntentID, "pi_", "paymentIntentId"); err != nil {
+		return err
+	}
+	if req.Amount < 0 {
+		return errors.New("amount must be positive")
+	}
+	if !refundReasons[req.Reason] {
+		return fmt.Errorf("invalid reason %q", req.Reason)
+	}
+	return nil
+}
+
+// IssueRefund refunds a payment through Stripe and stores the refund. In
+// live mode refunds of at least SAFETY_REFUND_THRESHOLD, and full refunds of
+// payments the store doesn't know, need confirming first.
+func (svc *Service) IssueRefund(ctx context.Context, req *RefundRequest) (*Refund, error) {
+	// The payment as it was before the refund is its audit before state.
+	var before interface{}
+	amount := req.Amount
+	if p, err := svc.Payments.GetPaymentByIntent(ctx, req.PaymentIntentID); err == nil {
+		// Only what was captured of a manually captured payment was charged.
+		total := p.Amount
+		if p.AmountCaptured > 0 {
+			total = p.AmountCaptured
+		}
+		if req.Amount > total {
+			return nil, BadRequest(fmt.Errorf("amount exceeds payment total of %d", total))
+		}
+		if amount == 0 {
+			amount = total
+		}
+		before = p
+	}
+	if amount == 0 || amount >= svc.Config.SafetyRefundThreshold {
+		if err := svc.ConfirmLive(ctx, "refund.create", req.PaymentIntentID, amount); err != nil {
+			return nil, err
+		}
+	}
+
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(req.PaymentIntentID),
+	}
+	params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "refund"))
+	if req.Amount > 0 {
+		params.Amount = stripe.Int64(req.Amount)
+	}
+	if req.Reason != "" {
+		params.Reason = stripe.String(req.Reason)
+	}
+	re, err := svc.Stripe.NewRefund(ctx, params)
+	if err != nil {
+		return nil, &StripeFailure{"creating refund", err}
+	}
+	// The refund is made; record it even if the caller has gone away.
+	ctx = context.WithoutCancel(ctx)
+
+	rec := &Refund{
+		ID:              re.ID,
+		PaymentIntentID: req.PaymentIntentID,
+		Amount:          re.Amount,
+		Currency:        string(re.Currency),
+		Status:          string(re.Status),
+		Reason:          string(re.Reason),
+	}
+	if err := svc.Payments.SaveRefund(ctx, rec); err != nil {
+		return nil, InternalError("saving refund", err)
+	}
+	svc.RecordAudit(ctx, AuditActor(ctx), "refund.created", req.PaymentIntentID, before, rec)
+	return rec, nil
+}
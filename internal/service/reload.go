@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+
+	"stripe_go/internal/config"
+)
+
+// ConfiguredPrice returns PRICE.
+func (svc *Service) ConfiguredPrice() string {
+	svc.priceMu.RLock()
+	defer svc.priceMu.RUnlock()
+	return svc.Config.Price
+}
+
+// rereadConfig re-reads .env and the configuration and validates it.
+func rereadConfig() (*config.Config, error) {
+	if err := godotenv.Overload(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loading .env file: %w", err)
+	}
+	c, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReloadResponse is returned by POST /admin/reload.
+type ReloadResponse struct {
+	Price         string `json:"price"`
+	PreviousPrice string `json:"previousPrice,omitempty"`
+	Products      int    `json:"products"`
+	Prices        int    `json:"prices"`
+}
+
+// ReloadSettings re-reads the configuration and swaps in the settings that
+// can change while serving: the credentials, the Stripe secret key and
+// webhook signing secrets, PRICE and the catalog, which is loaded afresh
+// from Stripe. The new catalog and price are checked before anything is
+// swapped, so a PRICE that isn't for sale keeps the current ones in place.
+// Other settings keep their startup values.
+func (svc *Service) ReloadSettings(ctx context.Context) (*ReloadResponse, error) {
+	c, err := rereadConfig()
+	if err != nil {
+		return nil, err
+	}
+	next := NewCatalog()
+	if err := next.Load(ctx, svc.Stripe); err != nil {
+		return nil, err
+	}
+	if _, ok := next.Price(c.Price); !ok {
+		return nil, fmt.Errorf("PRICE %s is not an active price of an active product", c.Price)
+	}
+	previous := svc.ConfiguredPrice()
+	// /config serves the price from the cache; drop the copies a price
+	// change in the dashboard would have left stale.
+	for _, id := range []string{previous, c.Price} {
+		if err := svc.Cache.Delete(ctx, "price:"+id); err != nil {
+			slog.Warn("clearing cached price", "price", id, "error", err)
+		}
+	}
+	if _, err := svc.CachedPrice(ctx, c.Price); err != nil {
+		return nil, fmt.Errorf("fetching PRICE %s: %w", c.Price, err)
+	}
+
+	svc.Catalog.replace(next)
+	svc.ForgetAccountCatalogs()
+	svc.priceMu.Lock()
+	svc.Config.Price = c.Price
+	svc.priceMu.Unlock()
+	svc.swapCredentials(c)
+	svc.swapStripeSecrets(c)
+	if err := svc.LoadTenantCatalogs(ctx); err != nil {
+		slog.Error("reloading tenant catalogs", "error", err)
+	}
+
+	resp := &ReloadResponse{Price: c.Price, Products: len(next.Products())}
+	if previous != c.Price {
+		resp.PreviousPrice = previous
+	}
+	for _, p := range next.Products() {
+		resp.Prices += len(p.Prices)
+	}
+	return resp, nil
+}
+
+// ReloadOnSIGHUP calls ReloadSettings whenever the process receives SIGHUP,
+// until ctx is done.
+func (svc *Service) ReloadOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			resp, err := svc.ReloadSettings(ctx)
+			if err != nil {
+				slog.Error("reloading settings", "error", err)
+				continue
+			}
+			slog.Info("settings reloaded",
+				"price", resp.Price,
+				"products", resp.Products,
+				"keys", len(svc.Config.APIKeys),
+				"jwt_secret_count", len(svc.Config.JWTSecrets),
+			)
+		}
+	}
+}
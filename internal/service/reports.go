@@ -0,0 +1,246 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DailyReport sums up one UTC day of the payment store: per currency what
+// was collected and refunded, and per product and currency how many orders
+// bought it. Refunds count on the day they were made, whichever day the
+// payment was.
+type DailyReport struct {
+	Date       string            `json:"date"`
+	Currencies []*ReportCurrency `json:"currencies"`
+	Products   []*ReportProduct  `json:"products"`
+}
+
+// ReportCurrency is a day's totals in one currency.
+type ReportCurrency struct {
+	Currency string `json:"currency"`
+	Gross    int64  `json:"gross"`
+	Refunded int64  `json:"refunded"`
+	Net      int64  `json:"net"`
+	Payments int    `json:"payments"`
+	Refunds  int    `json:"refunds"`
+}
+
+// ReportProduct is how often a product sold in one currency on a day.
+type ReportProduct struct {
+	Product  string `json:"product"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+	Orders   int    `json:"orders"`
+	Quantity int64  `json:"quantity"`
+}
+
+// collectedStatuses are the payment statuses whose money was taken, even if
+// it has since been refunded or disputed.
+var collectedStatuses = map[string]bool{
+	"paid":               true,
+	"partially_refunded": true,
+	"refunded":           true,
+	"disputed":           true,
+}
+
+// BuildDailyReport builds the report of the UTC day starting at day.
+func (svc *Service) BuildDailyReport(ctx context.Context, day time.Time) (*DailyReport, error) {
+	from, to := day.UTC(), day.UTC().AddDate(0, 0, 1)
+	report := &DailyReport{Date: from.Format("2006-01-02")}
+	currencies := map[string]*ReportCurrency{}
+	currency := func(code string) *ReportCurrency {
+		c, ok := currencies[code]
+		if !ok {
+			c = &ReportCurrency{Currency: code}
+			currencies[code] = c
+			report.Currencies = append(report.Currencies, c)
+		}
+		return c
+	}
+	products := map[string]*ReportProduct{}
+
+	list, err := svc.Payments.ListPayments(ctx, PaymentFilter{From: from, To: to})
+	if err != nil {
+		return nil, fmt.Errorf("listing payments: %w", err)
+	}
+	for _, p := range list {
+		if !collectedStatuses[p.Status] {
+			continue
+		}
+		c := currency(p.Currency)
+		c.Gross += p.Amount
+		c.Payments++
+		o, err := svc.Payments.GetOrderBySession(ctx, p.SessionID)
+		if err == ErrOrderNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fetching order: %w", err)
+		}
+		counted := map[string]bool{}
+		for _, item := range o.Items {
+			product := item.Price
+			if price, ok := svc.Catalog.Price(item.Price); ok {
+				product = price.Product
+			}
+			key := product + "/" + p.Currency
+			rp, ok := products[key]
+			if !ok {
+				rp = &ReportProduct{Product: product, Name: svc.Catalog.ProductName(product), Currency: p.Currency}
+				products[key] = rp
+				report.Products = append(report.Products, rp)
+			}
+			if !counted[key] {
+				rp.Orders++
+				counted[key] = true
+			}
+			rp.Quantity += item.Quantity
+		}
+	}
+
+	refunds, err := svc.Payments.ListRefundsBetween(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("listing refunds: %w", err)
+	}
+	for _, r := range refunds {
+		if r.Status == "failed" || r.Status == "canceled" {
+			continue
+		}
+		c := currency(r.Currency)
+		c.Refunded += r.Amount
+		c.Refunds++
+	}
+	for _, c := range report.Currencies {
+		c.Net = c.Gross - c.Refunded
+	}
+	sort.Slice(report.Currencies, func(i, j int) bool {
+		return report.Currencies[i].Currency < report.Currencies[j].Currency
+	})
+	sort.Slice(report.Products, func(i, j int) bool {
+		a, b := report.Products[i], report.Products[j]
+		if a.Product != b.Product {
+			return a.Product < b.Product
+		}
+		return a.Currency < b.Currency
+	})
+	return report, nil
+}
+
+// CSV writes the report as one row per currency and one per product.
+// Amounts are in the currency's minor unit.
+func (r *DailyReport) CSV() []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"date", "type", "currency", "product", "name", "gross", "refunded", "net", "payments", "refunds", "orders", "quantity"})
+	for _, c := range r.Currencies {
+		w.Write([]string{r.Date, "currency", c.Currency, "", "",
+			strconv.FormatInt(c.Gross, 10), strconv.FormatInt(c.Refunded, 10), strconv.FormatInt(c.Net, 10),
+			strconv.Itoa(c.Payments), strconv.Itoa(c.Refunds), "", ""})
+	}
+	for _, p := range r.Products {
+		w.Write([]string{r.Date, "product", p.Currency, p.Product, p.Name, "", "", "", "", "",
+			strconv.Itoa(p.Orders), strconv.FormatInt(p.Quantity, 10)})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"money": FormatAmount}).Parse(`<!DOCTYPE html>
+<html>
+  <body>
+    <h1>Revenue report for {{.Date}}</h1>
+    {{if .Currencies}}<table>
+      <tr><th>Currency</th><th>Gross</th><th>Refunded</th><th>Net</th><th>Payments</th><th>Refunds</th></tr>{{range .Currencies}}
+      <tr><td>{{.Currency}}</td><td>{{money .Gross .Currency}}</td><td>{{money .Refunded .Currency}}</td><td>{{money .Net .Currency}}</td><td>{{.Payments}}</td><td>{{.Refunds}}</td></tr>{{end}}
+    </table>{{else}}<p>No payments or refunds.</p>{{end}}
+    {{if .Products}}<table>
+      <tr><th>Product</th><th>Currency</th><th>Orders</th><th>Quantity</th></tr>{{range .Products}}
+      <tr><td>{{or .Name .Product}}</td><td>{{.Currency}}</td><td>{{.Orders}}</td><td>{{.Quantity}}</td></tr>{{end}}
+    </table>{{end}}
+  </body>
+</html>
+`))
+
+// HTML renders the report as a page, which is also the body of the report
+// email.
+func (r *DailyReport) HTML() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReportEmail is the job that emails one day's report to one recipient.
+type ReportEmail struct {
+	Date string `json:"date"`
+	To   string `json:"to"`
+}
+
+// sendDailyReport builds the report of j.Date and emails it with the CSV
+// attached.
+func (svc *Service) sendDailyReport(ctx context.Context, j *ReportEmail) error {
+	day, err := time.Parse("2006-01-02", j.Date)
+	if err != nil {
+		return err
+	}
+	report, err := svc.BuildDailyReport(ctx, day)
+	if err != nil {
+		return err
+	}
+	html, err := report.HTML()
+	if err != nil {
+		return err
+	}
+	return svc.EmailSender.Send(ctx, &EmailMessage{
+		To:      j.To,
+		Subject: "Revenue report for " + report.Date,
+		HTML:    string(html),
+		Attachments: []EmailAttachment{{
+			Filename:    "report-" + report.Date + ".csv",
+			ContentType: "text/csv",
+			Content:     report.CSV(),
+		}},
+	})
+}
+
+// QueueDailyReport queues the report of day for every REPORT_RECIPIENTS
+// address.
+func (svc *Service) QueueDailyReport(ctx context.Context, day time.Time) error {
+	for _, to := range svc.Config.ReportRecipients {
+		if err := svc.Jobs.Enqueue(ctx, jobSendDailyReport, &ReportEmail{Date: day.UTC().Format("2006-01-02"), To: to}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportDaily queues the previous day's report at REPORT_TIME every day
+// until ctx is done.
+func (svc *Service) reportDaily(ctx context.Context) {
+	for {
+		now := time.Now().UTC()
+		next := now.Truncate(24 * time.Hour).Add(svc.Config.ReportTime)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		t := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		day := next.Truncate(24*time.Hour).AddDate(0, 0, -1)
+		if err := svc.QueueDailyReport(ctx, day); err != nil {
+			slog.Error("queueing daily report", "date", day.Format("2006-01-02"), "error", err)
+		}
+	}
+}
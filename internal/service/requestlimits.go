@@ -0,0 +1,23 @@
+package service
+
+import "time"
+
+// AdminDeadlines returns the read and write deadlines of endpoints behind
+// requireAuth: long enough to send a body and to get a response for as long
+// as ADMIN_REQUEST_TIMEOUT allows, with the margin WRITE_TIMEOUT leaves over
+// REQUEST_TIMEOUT.
+func (svc *Service) AdminDeadlines() (read, write time.Duration) {
+	if svc.Config.AdminRequestTimeout == 0 {
+		return 0, 0
+	}
+	if svc.Config.ReadTimeout > 0 {
+		read = svc.Config.AdminRequestTimeout
+		if svc.Config.ReadTimeout > read {
+			read = svc.Config.ReadTimeout
+		}
+	}
+	if svc.Config.WriteTimeout > 0 {
+		write = svc.Config.AdminRequestTimeout + svc.Config.WriteTimeout - svc.Config.RequestTimeout
+	}
+	return read, write
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"stripe_go/internal/config"
+)
+
+// CheckoutReturnURLs returns the success and cancel URLs for a session.
+// Empty values fall back to pages under DOMAIN. Custom URLs must be absolute
+// and point at DOMAIN's host or one of RETURN_URL_HOSTS. The success URL gets
+// a session_id parameter when it doesn't already carry the placeholder.
+func (svc *Service) CheckoutReturnURLs(successURL, cancelURL string) (string, string, error) {
+	domainURL := svc.Config.Domain
+	if successURL == "" {
+		successURL = domainURL + "/html/success.html?session_id={CHECKOUT_SESSION_ID}"
+	} else {
+		if err := svc.validateReturnURL(successURL, "successUrl"); err != nil {
+			return "", "", err
+		}
+		if !strings.Contains(successURL, "{CHECKOUT_SESSION_ID}") {
+			sep := "?"
+			if strings.Contains(successURL, "?") {
+				sep = "&"
+			}
+			successURL += sep + "session_id={CHECKOUT_SESSION_ID}"
+		}
+	}
+	if cancelURL == "" {
+		cancelURL = domainURL + "/canceled.html"
+	} else if err := svc.validateReturnURL(cancelURL, "cancelUrl"); err != nil {
+		return "", "", err
+	}
+	return successURL, cancelURL, nil
+}
+
+func (svc *Service) validateReturnURL(raw, field string) error {
+	// The placeholder isn't valid in a URL until Checkout substitutes it.
+	u, err := url.Parse(strings.ReplaceAll(raw, "{CHECKOUT_SESSION_ID}", "x"))
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute URL", field)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && config.IsLocalHost(u.Hostname())) {
+		return fmt.Errorf("%s must use https", field)
+	}
+	if !svc.returnHostAllowed(u.Hostname()) {
+		return fmt.Errorf("%s host %q is not allowed", field, u.Hostname())
+	}
+	return nil
+}
+
+func (svc *Service) returnHostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	if d, err := url.Parse(svc.Config.Domain); err == nil && strings.EqualFold(d.Hostname(), host) {
+		return true
+	}
+	for _, allowed := range svc.Config.ReturnURLHosts {
+		// A leading dot allows every subdomain, e.g. ".example.com".
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"stripe_go/internal/config"
+)
+
+// safetyFallbackKey signs confirmation tokens when SAFETY_SIGNING_KEY isn't
+// set. Its tokens are only good on the instance that issued them.
+var safetyFallbackKey = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+// Confirmation describes a token issued for a guarded operation.
+type Confirmation struct {
+	Token            string    `json:"token"`
+	Action           string    `json:"action"`
+	Object           string    `json:"object"`
+	Amount           int64     `json:"amount,omitempty"`
+	RequestedBy      string    `json:"requestedBy"`
+	ApprovalRequired bool      `json:"approvalRequired"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+}
+
+// confirmationClaims are what a token is signed over.
+type confirmationClaims struct {
+	Nonce       string `json:"n"`
+	Action      string `json:"act"`
+	Object      string `json:"obj"`
+	Amount      int64  `json:"amt,omitempty"`
+	RequestedBy string `json:"by"`
+	ExpiresAt   int64  `json:"exp"`
+}
+
+// ConfirmationRequired is returned for a guarded operation that came
+// without a valid token. Confirmation is the token to repeat it with.
+type ConfirmationRequired struct {
+	Message      string
+	Confirmation *Confirmation
+}
+
+func (e *ConfirmationRequired) Error() string {
+	return e.Message
+}
+
+type confirmationKey struct{}
+
+// WithConfirmation attaches the confirmation token a caller sent to ctx.
+func WithConfirmation(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, confirmationKey{}, token)
+}
+
+// liveSafety reports whether guarded operations need confirming.
+func (svc *Service) liveSafety() bool {
+	return svc.Config.Mode == "live"
+}
+
+func (svc *Service) safetyMAC(payload string) []byte {
+	key := safetyFallbackKey
+	if svc.Config.SafetySigningKey != "" {
+		key = []byte(svc.Config.SafetySigningKey)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// ConfirmLive lets a guarded operation through in test mode, or in live
+// mode when ctx carries a token issued for this action, object and amount.
+// Otherwise it returns a *ConfirmationRequired with a new token.
+func (svc *Service) ConfirmLive(ctx context.Context, action, object string, amount int64) error {
+	if !svc.liveSafety() {
+		return nil
+	}
+	actor := AuditActor(ctx)
+	token, _ := ctx.Value(confirmationKey{}).(string)
+	if token == "" {
+		return svc.confirmationRequired(action, object, amount, actor, fmt.Sprintf("%s needs confirmation in live mode", action))
+	}
+	claims, ok := svc.verifyConfirmation(token, time.Now())
+	if !ok || claims.Action != action || claims.Object != object || claims.Amount != amount {
+		return svc.confirmationRequired(action, object, amount, actor, "confirmation token is invalid or expired")
+	}
+	if svc.Config.SafetyConfirmation == config.ConfirmationApproval && claims.RequestedBy == actor {
+		return &Error{Status: http.StatusForbidden, Message: fmt.Sprintf("%s must be approved by someone other than %s", action, actor)}
+	}
+	if !svc.useConfirmation(claims.Nonce, time.Unix(claims.ExpiresAt, 0)) {
+		return svc.confirmationRequired(action, object, amount, actor, "confirmation token was already used")
+	}
+	// The override gets its own entry; the operation is still audited as
+	// usual, so it mustn't count as the request's audit entry.
+	svc.RecordAudit(context.WithValue(ctx, AuditedKey{}, new(bool)), actor, "safety.override", object, nil, map[string]interface{}{
+		"action":      action,
+		"amount":      amount,
+		"requestedBy": claims.RequestedBy,
+		"approvedBy":  actor,
+	})
+	return nil
+}
+
+func (svc *Service) confirmationRequired(action, object string, amount int64, actor, message string) error {
+	claims := confirmationClaims{
+		Nonce:       NewRequestID(),
+		Action:      action,
+		Object:      object,
+		Amount:      amount,
+		RequestedBy: actor,
+		ExpiresAt:   time.Now().Add(svc.Config.SafetyConfirmationTTL).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return InternalError("issuing confirmation", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return &ConfirmationRequired{
+		Message: message,
+		Confirmation: &Confirmation{
+			Token:            encoded + "." + base64.RawURLEncoding.EncodeToString(svc.safetyMAC("confirm."+encoded)),
+			Action:           action,
+			Object:           object,
+			Amount:           amount,
+			RequestedBy:      actor,
+			ApprovalRequired: svc.Config.SafetyConfirmation == config.ConfirmationApproval,
+			ExpiresAt:        time.Unix(claims.ExpiresAt, 0).UTC(),
+		},
+	}
+}
+
+// verifyConfirmation checks a token's signature and expiry.
+func (svc *Service) verifyConfirmation(token string, now time.Time) (*confirmationClaims, bool) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, svc.safetyMAC("confirm."+encoded)) {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	var claims confirmationClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, false
+	}
+	return &claims, true
+}
+
+// useConfirmation marks a token's nonce as used. It reports false when it
+// already was.
+func (svc *Service) useConfirmation(nonce string, expires time.Time) bool {
+	svc.usedConfirmations.Lock()
+	defer svc.usedConfirmations.Unlock()
+	now := time.Now()
+	for n, exp := range svc.usedConfirmations.nonces {
+		if !now.Before(exp) {
+			delete(svc.usedConfirmations.nonces, n)
+		}
+	}
+	if _, used := svc.usedConfirmations.nonces[nonce]; used {
+		return false
+	}
+	svc.usedConfirmations.nonces[nonce] = expires
+	return true
+}
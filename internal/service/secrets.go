@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"stripe_go/internal/config"
+)
+
+// defaultStripeKey is the default account's secret key. key is the one its
+// client was made with at startup, which a refresh may since have rotated.
+func (svc *Service) defaultStripeKey(key string) string {
+	svc.secretsMu.RLock()
+	defer svc.secretsMu.RUnlock()
+	if svc.Config == nil || svc.Config.SecretKey == "" {
+		return key
+	}
+	return svc.Config.SecretKey
+}
+
+// swapStripeSecrets puts c's Stripe secret key and webhook signing secrets
+// in place, reporting whether either changed.
+func (svc *Service) swapStripeSecrets(c *config.Config) bool {
+	svc.secretsMu.Lock()
+	defer svc.secretsMu.Unlock()
+	changed := c.SecretKey != svc.Config.SecretKey || !slices.Equal(c.WebhookSecrets, svc.Config.WebhookSecrets)
+	svc.Config.SecretKey, svc.Config.WebhookSecrets = c.SecretKey, c.WebhookSecrets
+	return changed
+}
+
+// RefreshSecrets re-reads the configuration, fetching the secrets again, and
+// swaps in the Stripe secret key and webhook signing secrets, e.g. after
+// they were rotated in the secret manager. A configuration that doesn't
+// validate keeps the current ones.
+func (svc *Service) RefreshSecrets() error {
+	c, err := rereadConfig()
+	if err != nil {
+		return err
+	}
+	if svc.swapStripeSecrets(c) {
+		slog.Info("stripe secrets refreshed", "webhook_secrets", len(c.WebhookSecrets))
+	}
+	return nil
+}
+
+// refreshSecretsEvery calls RefreshSecrets every interval until ctx is done.
+func (svc *Service) refreshSecretsEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := svc.RefreshSecrets(); err != nil {
+				slog.Error("refreshing secrets", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/stripeclient"
+)
+
+// UseSettings makes c the configuration, with a Stripe client for its
+// secret key, and loads the catalogs it names.
+func (svc *Service) UseSettings(ctx context.Context, c *config.Config) error {
+	svc.Config = c
+	svc.Stripe = stripeclient.New(c, svc.StripeKey)
+	if c.Mode == "mock" {
+		svc.Stripe = stripeclient.NewMock(c)
+		slog.Warn("running against the mock Stripe; no payments are real")
+	}
+	if err := svc.Catalog.Load(ctx, svc.Stripe); err != nil {
+		return fmt.Errorf("Error loading catalog: %w", err)
+	}
+	if _, ok := svc.Catalog.Price(c.Price); !ok {
+		return fmt.Errorf("PRICE %s is not an active price of an active product", c.Price)
+	}
+	return svc.LoadTenantCatalogs(ctx)
+}
+
+// ErrorResponseMessage describes an error. Code and DeclineCode are set when
+// Stripe rejected the request, e.g. "card_declined" / "insufficient_funds".
+type ErrorResponseMessage struct {
+	Message     string `json:"message"`
+	Code        string `json:"code,omitempty"`
+	DeclineCode string `json:"declineCode,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error *ErrorResponseMessage `json:"error"`
+}
+
+// ConfigResponse is returned by /config. UnitAmount and Currency are the
+// default price in the chosen currency; Currencies lists the ones it can be
+// sold in, default first. Locale is the Checkout locale picked for the
+// shopper from ?locale or Accept-Language, and Locales the ones Checkout
+// supports. Mode is "test" or "live".
+type ConfigResponse struct {
+	Mode           string          `json:"mode"`
+	PublishableKey string          `json:"publishableKey"`
+	Price          string          `json:"price"`
+	UnitAmount     int64           `json:"unitAmount"`
+	Currency       string          `json:"currency"`
+	Currencies     []string        `json:"currencies"`
+	Locale         string          `json:"locale,omitempty"`
+	Locales        []string        `json:"locales"`
+	Nickname       string          `json:"nickname,omitempty"`
+	Branding       config.Branding `json:"branding"`
+}
+
+// UpdatePaymentStatus records the current state of a checkout session in the
+// payment store. A status the payment has already moved past is ignored.
+func (svc *Service) UpdatePaymentStatus(ctx context.Context, s *stripe.CheckoutSession) error {
+	p := &Payment{
+		SessionID: s.ID,
+		Amount:    s.AmountTotal,
+		Currency:  string(s.Currency),
+		Metadata:  s.Metadata,
+	}
+	if s.PaymentIntent != nil {
+		p.PaymentIntentID = s.PaymentIntent.ID
+	}
+	if existing, err := svc.Payments.GetPayment(ctx, s.ID); err == nil {
+		p.CreatedAt = existing.CreatedAt
+		p.Status = existing.Status
+	} else if err != ErrPaymentNotFound {
+		return err
+	}
+	previous := p.Status
+	SetPaymentStatus(p, string(s.PaymentStatus))
+	return svc.SavePayment(ctx, p, previous)
+}
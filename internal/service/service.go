@@ -0,0 +1,259 @@
+// Package service is the payments service: the operations the HTTP API,
+// the gRPC server and the webhook handlers share, the records they keep and
+// the job queue that carries out the work they queue.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/stripeclient"
+)
+
+// The core operations (creating a checkout, looking up a payment, refunding
+// it, listing payments) are methods of Service shared by the HTTP handlers
+// and the gRPC server. They take validated requests and report failures as a
+// *Error or, when Stripe refused, a *StripeFailure, which each
+// transport turns into its own kind of error response (see errors.go).
+
+// Service is the payments service: its configuration, the stores, job
+// queue and Stripe client it works with, and the state its requests,
+// webhooks and jobs share. Everything a server does goes through its
+// Service, so servers in one process keep their state apart.
+type Service struct {
+	Config *config.Config
+	Stripe stripeclient.Client
+	// Catalog is the default account's. TenantCatalogs are the tenants',
+	// by tenant ID; they are added when the tenants' catalogs are first
+	// loaded.
+	Catalog        *Catalog
+	TenantCatalogs map[string]*Catalog
+	Payments       PaymentStore
+	Events         EventStore
+	Cache          Cache
+	EmailSender    EmailSender
+	EmailTemplates *EmailTemplates
+	Jobs           *JobQueue
+	WebhookRouter  *WebhookRouter
+	assets         Assets
+
+	// accountCatalogs are the catalogs of the connected accounts acted on,
+	// by account ID, and the loads under way.
+	accountCatalogs struct {
+		sync.Mutex
+		byID    map[string]*Catalog
+		loading map[string]*catalogLoad
+	}
+
+	// authMu guards the credentials in config, which a SIGHUP swaps while
+	// requests are being served.
+	authMu sync.RWMutex
+	// secretsMu guards config.SecretKey and config.WebhookSecrets, which a
+	// refresh swaps while requests are being served.
+	secretsMu sync.RWMutex
+	// priceMu guards config.Price, which a reload swaps while requests are
+	// being served.
+	priceMu sync.RWMutex
+
+	// cartLocks serializes checkouts of the same cart, so a double click
+	// resumes the session the first click created instead of opening
+	// another.
+	cartLocks keyedMutex
+	// invoiceLocks serializes the admin actions and webhooks of the same
+	// invoice, so a late event can't overwrite what an action just stored.
+	invoiceLocks keyedMutex
+	// recoveryLocks serializes the recovery of the same abandoned checkout,
+	// so clicking the link twice resumes the session the first click
+	// created.
+	recoveryLocks keyedMutex
+	// subscriptionLocks serializes changes to the same subscription, so two
+	// confirmations can't both be previewed against the old price.
+	subscriptionLocks keyedMutex
+	// usageLocks serializes reports of the same record, so a job retried
+	// while still running can't send it twice.
+	usageLocks keyedMutex
+
+	// usedConfirmations holds the nonces of tokens that were accepted, until
+	// they expire, so each token confirms a single operation.
+	usedConfirmations struct {
+		sync.Mutex
+		nonces map[string]time.Time
+	}
+	// signatureAlerts holds when webhook.signature_failed was last raised
+	// and how many failures were held back since.
+	signatureAlerts struct {
+		sync.Mutex
+		last       time.Time
+		suppressed int
+	}
+
+	// MessageBuses holds the buses named by EVENT_FORWARDS and EVENT_BUS,
+	// by name. They are connected on first use.
+	messageBusesMu sync.Mutex
+	MessageBuses   map[string]MessageBus
+
+	// reconcileMu serializes reconciliation runs and guards reconcileStats.
+	reconcileMu    sync.Mutex
+	reconcileStats ReconcileStats
+}
+
+// Stores are the stores a Service keeps its state in.
+type Stores struct {
+	Payments PaymentStore
+	Events   EventStore
+	Cache    Cache
+}
+
+// New returns a Service with configuration c, the stores st and the
+// assets a, calling the real Stripe API. It has no catalog, email or job
+// queue until UseSettings and SetUp.
+func New(c *config.Config, st Stores, a Assets) *Service {
+	svc := &Service{
+		Config:         c,
+		Catalog:        NewCatalog(),
+		TenantCatalogs: map[string]*Catalog{},
+		Payments:       st.Payments,
+		Events:         st.Events,
+		Cache:          st.Cache,
+		WebhookRouter:  NewWebhookRouter(),
+		MessageBuses:   map[string]MessageBus{},
+		assets:         a,
+	}
+	svc.accountCatalogs.byID = map[string]*Catalog{}
+	svc.accountCatalogs.loading = map[string]*catalogLoad{}
+	svc.usedConfirmations.nonces = map[string]time.Time{}
+	svc.Stripe = stripeclient.New(c, svc.StripeKey)
+	return svc
+}
+
+// SetUp readies svc for its first request: it seeds the inventory and
+// sets up email and the job queue.
+func (svc *Service) SetUp(ctx context.Context) error {
+	if err := svc.SeedInventory(ctx); err != nil {
+		return err
+	}
+	sender, err := newEmailSender(svc.Config)
+	if err != nil {
+		return fmt.Errorf("Error configuring email: %w", err)
+	}
+	backend := svc.Config.EmailBackend
+	if backend == "" {
+		backend = "log"
+	}
+	svc.EmailSender = TracedEmailSender{EmailSender: sender, Backend: backend}
+	if svc.EmailTemplates, err = LoadEmailTemplates(svc.EmailTemplateFS()); err != nil {
+		return fmt.Errorf("Error loading email templates: %w", err)
+	}
+	if svc.Jobs, err = NewJobQueueFromConfig(svc.Config, svc.Payments); err != nil {
+		return fmt.Errorf("Error opening job queue: %w", err)
+	}
+	svc.RegisterJobHandlers()
+	return nil
+}
+
+// Start runs the job queue and the periodic work the configuration asks
+// for until ctx is done.
+func (svc *Service) Start(ctx context.Context) {
+	cfg := svc.Config
+	go svc.Jobs.Run(ctx)
+	if cfg.Secrets != nil && cfg.SecretRefreshInterval > 0 {
+		go svc.refreshSecretsEvery(ctx, cfg.SecretRefreshInterval)
+	}
+	if cfg.CatalogRefreshInterval > 0 {
+		go svc.Catalog.refreshEvery(ctx, svc.Stripe, cfg.CatalogRefreshInterval)
+		for _, t := range cfg.Tenants {
+			go svc.TenantCatalogs[t.ID].refreshEvery(WithTenant(ctx, t), svc.Stripe, cfg.CatalogRefreshInterval)
+		}
+	}
+	if cfg.ReconcileInterval > 0 {
+		go svc.reconcileEvery(ctx, cfg.ReconcileInterval)
+	}
+	if len(cfg.ReportRecipients) > 0 {
+		go svc.reportDaily(ctx)
+	}
+}
+
+// Error is a failure of a service operation. Status is the HTTP
+// status it is reported with; the gRPC server maps it to a gRPC code.
+// Message is what the caller is told; the cause of an internal error is
+// only logged.
+type Error struct {
+	Status  int
+	Message string
+	err     error
+}
+
+func (e *Error) Error() string {
+	if e.err != nil {
+		return e.Message + ": " + e.err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Is matches the kind of failure of e's status, so a 404 is ErrNotFound.
+func (e *Error) Is(target error) bool {
+	for _, k := range errorStatuses {
+		if k.err == target {
+			return k.status == e.Status
+		}
+	}
+	return false
+}
+
+// BadRequest reports an invalid request.
+func BadRequest(err error) error {
+	return &Error{Status: http.StatusBadRequest, Message: err.Error()}
+}
+
+// StatusError reports a failure that status's standard text describes,
+// such as a path no handler serves.
+func StatusError(status int) error {
+	return &Error{Status: status, Message: http.StatusText(status)}
+}
+
+// InternalError reports a failure of the server itself while doing action.
+// The caller is told what failed but not why.
+func InternalError(action string, err error) error {
+	return &Error{Status: http.StatusInternalServerError, Message: "error while " + action, err: err}
+}
+
+// StripeFailure is a Stripe API call that failed while doing action.
+type StripeFailure struct {
+	Action string
+	Err    error
+}
+
+func (f *StripeFailure) Error() string {
+	return fmt.Sprintf("error while %s: %v", f.Action, f.Err)
+}
+
+func (f *StripeFailure) Unwrap() error {
+	return f.Err
+}
+
+// Is matches ErrStripeDeclined for card errors and ErrNotFound for objects
+// Stripe doesn't have.
+func (f *StripeFailure) Is(target error) bool {
+	var se *stripe.Error
+	if !errors.As(f.Err, &se) {
+		return false
+	}
+	switch target {
+	case ErrStripeDeclined:
+		return se.Type == stripe.ErrorTypeCard
+	case ErrNotFound:
+		return se.HTTPStatusCode == http.StatusNotFound
+	}
+	return false
+}
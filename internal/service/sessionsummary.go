@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// SummaryMetadataKey is the session metadata key holding the random
+// reference its summary token signs. Clients can't set it.
+const SummaryMetadataKey = "summary_ref"
+
+// CheckoutSessionSummary is what GET /checkout-session/summary tells the
+// success page about a session: enough to show what was bought, without
+// the customer's details or the payment intent.
+type CheckoutSessionSummary struct {
+	ID             string               `json:"id"`
+	Mode           string               `json:"mode"`
+	Status         string               `json:"status"`
+	PaymentStatus  string               `json:"paymentStatus"`
+	AmountSubtotal int64                `json:"amountSubtotal"`
+	AmountTotal    int64                `json:"amountTotal"`
+	Currency       string               `json:"currency"`
+	Items          []SessionSummaryItem `json:"items"`
+	// Email is where the receipt goes, masked as j***@example.com.
+	Email   string `json:"email,omitempty"`
+	OrderID string `json:"orderId,omitempty"`
+}
+
+// SessionSummaryItem is one line item of a CheckoutSessionSummary.
+type SessionSummaryItem struct {
+	Description string `json:"description"`
+	Quantity    int64  `json:"quantity"`
+	AmountTotal int64  `json:"amountTotal"`
+}
+
+// sessionSummaryToken signs the reference stored on a session.
+func (svc *Service) sessionSummaryToken(ref string) string {
+	mac := hmac.New(sha256.New, []byte(svc.Config.SessionSummarySigningKey))
+	mac.Write([]byte("session-summary." + ref))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (svc *Service) VerifySessionSummaryToken(s *stripe.CheckoutSession, token string) error {
+	ref := s.Metadata[SummaryMetadataKey]
+	if ref == "" || !hmac.Equal([]byte(token), []byte(svc.sessionSummaryToken(ref))) {
+		return errors.New("invalid session summary link")
+	}
+	return nil
+}
+
+// WithSessionSummary tags the session about to be created with a random
+// reference and adds its summary_token to the success URL. The session ID
+// isn't known until Stripe fills in {CHECKOUT_SESSION_ID}, so the token
+// signs the reference instead. It does nothing unless
+// SESSION_SUMMARY_SIGNING_KEY is set.
+func (svc *Service) WithSessionSummary(params *stripe.CheckoutSessionParams) {
+	if svc.Config.SessionSummarySigningKey == "" {
+		return
+	}
+	ref := NewRequestID()
+	params.AddMetadata(SummaryMetadataKey, ref)
+	successURL := stripe.StringValue(params.SuccessURL)
+	sep := "?"
+	if strings.Contains(successURL, "?") {
+		sep = "&"
+	}
+	params.SuccessURL = stripe.String(successURL + sep + "summary_token=" + url.QueryEscape(svc.sessionSummaryToken(ref)))
+}
+
+// CachedSessionWithLineItems is CachedCheckoutSession with line_items
+// expanded. It is cached under its own key, which the session webhooks
+// invalidate too.
+func (svc *Service) CachedSessionWithLineItems(ctx context.Context, id string) (*stripe.CheckoutSession, error) {
+	return cached(ctx, svc.Cache, "session_items:"+id, svc.Config.CacheSessionTTL, func() (*stripe.CheckoutSession, error) {
+		params := &stripe.CheckoutSessionParams{}
+		params.AddExpand("line_items")
+		return svc.Stripe.GetCheckoutSession(ctx, id, params)
+	})
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// SetupSessionRequest is the body accepted by /create-setup-session. The
+// card is saved to the given customer, or to a new one created for email.
+// CustomerToken is the portal token of Customer, which callers other than
+// admins need.
+type SetupSessionRequest struct {
+	Customer      string `json:"customer"`
+	CustomerToken string `json:"customerToken"`
+	Email         string `json:"email"`
+	SuccessURL    string `json:"successUrl"`
+	CancelURL     string `json:"cancelUrl"`
+}
+
+// SetupSessionResponse is returned to JSON clients of /create-setup-session
+// instead of a redirect.
+type SetupSessionResponse struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	Customer string `json:"customer"`
+}
+
+func (s *SetupSessionRequest) Validate(ctx context.Context, svc *Service) error {
+	switch {
+	case s.Customer != "" && s.Email != "":
+		return errors.New("only one of customer and email can be given")
+	case s.Customer != "":
+		return ValidateStripeID(s.Customer, "cus_", "customer")
+	case s.Email != "":
+		return config.ValidateEmail(s.Email)
+	}
+	return errors.New("customer or email is required")
+}
+
+// SavedPaymentMethod is the payload of JobSavePaymentMethod: a setup mode
+// session that completed.
+type SavedPaymentMethod struct {
+	SessionID   string
+	Customer    string
+	SetupIntent string
+}
+
+// savePaymentMethod makes the payment method of a succeeded setup intent the
+// customer's default for off-session charges and invoices, unless they
+// already have one.
+func (svc *Service) savePaymentMethod(ctx context.Context, m *SavedPaymentMethod) error {
+	si, err := svc.Stripe.GetSetupIntent(ctx, m.SetupIntent, nil)
+	if err != nil {
+		return fmt.Errorf("fetching setup intent %s: %w", m.SetupIntent, err)
+	}
+	if si.Status != stripe.SetupIntentStatusSucceeded || si.PaymentMethod == nil {
+		slog.Warn("setup intent has no usable payment method", "setup_intent", si.ID, "status", si.Status)
+		return nil
+	}
+	c, err := svc.Stripe.GetCustomer(ctx, m.Customer, nil)
+	if err != nil {
+		return fmt.Errorf("fetching customer %s: %w", m.Customer, err)
+	}
+	if c.InvoiceSettings != nil && c.InvoiceSettings.DefaultPaymentMethod != nil {
+		slog.Info("customer already has a default payment method", "customer", c.ID, "payment_method", si.PaymentMethod.ID)
+		return nil
+	}
+	params := &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{DefaultPaymentMethod: stripe.String(si.PaymentMethod.ID)},
+	}
+	params.SetIdempotencyKey("default_payment_method:" + m.SessionID)
+	_, err = svc.Stripe.UpdateCustomer(ctx, c.ID, params)
+	if err != nil {
+		return fmt.Errorf("setting default payment method of %s: %w", c.ID, err)
+	}
+	svc.RecordAudit(ctx, actorStripe, "customer.default_payment_method", c.ID, nil, map[string]string{
+		"payment_method": si.PaymentMethod.ID,
+		"session":        m.SessionID,
+	})
+	return nil
+}
@@ -0,0 +1,64 @@
+package service
+
+import "github.com/stripe/stripe-go/v72"
+
+// addShipping makes the session collect a shipping address in
+// SHIPPING_COUNTRIES and offer SHIPPING_RATES. Flat rates are charged in the
+// session's currency, or the first price's when Checkout picks it. Sessions
+// are left alone when shipping isn't configured.
+func (svc *Service) addShipping(params *stripe.CheckoutSessionParams, prices []*CatalogPrice) {
+	if len(svc.Config.ShippingCountries) == 0 {
+		return
+	}
+	params.ShippingAddressCollection = &stripe.CheckoutSessionShippingAddressCollectionParams{
+		AllowedCountries: stripe.StringSlice(svc.Config.ShippingCountries),
+	}
+	currency := stripe.StringValue(params.Currency)
+	if currency == "" && len(prices) > 0 {
+		currency = prices[0].Currency
+	}
+	for _, rate := range svc.Config.ShippingRates {
+		params.ShippingOptions = append(params.ShippingOptions, rate.Option(currency))
+	}
+}
+
+// OrderShipping is where an order ships to and the rate the customer chose.
+// Amount is what the session charged for shipping.
+type OrderShipping struct {
+	Name         string `json:"name"`
+	Phone        string `json:"phone,omitempty"`
+	Line1        string `json:"line1"`
+	Line2        string `json:"line2,omitempty"`
+	City         string `json:"city"`
+	State        string `json:"state,omitempty"`
+	PostalCode   string `json:"postalCode"`
+	Country      string `json:"country"`
+	ShippingRate string `json:"shippingRate,omitempty"`
+	Amount       int64  `json:"amount"`
+}
+
+// sessionShipping returns the shipping details a completed session
+// collected, or nil if it didn't collect any.
+func sessionShipping(s *stripe.CheckoutSession) *OrderShipping {
+	if s.Shipping == nil || s.Shipping.Address == nil {
+		return nil
+	}
+	a := s.Shipping.Address
+	shipping := &OrderShipping{
+		Name:       s.Shipping.Name,
+		Phone:      s.Shipping.Phone,
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		State:      a.State,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+	}
+	if s.ShippingRate != nil {
+		shipping.ShippingRate = s.ShippingRate.ID
+	}
+	if s.TotalDetails != nil {
+		shipping.Amount = s.TotalDetails.AmountShipping
+	}
+	return shipping
+}
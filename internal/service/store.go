@@ -0,0 +1,534 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"stripe_go/internal/config"
+)
+
+// Payment is the local record of a checkout session and its payment.
+// Payments made through Elements have no session; SessionID holds the
+// payment intent ID for those. RiskLevel and RiskScore are Radar's
+// assessment of the charge, once the charge.succeeded webhook has reported
+// it; they are stored with SavePaymentRisk.
+type Payment struct {
+	SessionID       string            `json:"sessionId"`
+	PaymentIntentID string            `json:"paymentIntentId"`
+	Amount          int64             `json:"amount"`
+	Currency        string            `json:"currency"`
+	Status          string            `json:"status"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	RiskLevel       string            `json:"riskLevel,omitempty"`
+	RiskScore       int64             `json:"riskScore,omitempty"`
+	// AmountAuthorized and AmountCaptured are what a manually captured
+	// payment was authorized and captured for; a partial capture releases
+	// the difference. MaxCapturable is the most it may be captured for when
+	// its card allows overcapture.
+	AmountAuthorized int64     `json:"amountAuthorized,omitempty"`
+	AmountCaptured   int64     `json:"amountCaptured,omitempty"`
+	MaxCapturable    int64     `json:"maxCapturable,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// PaymentRisk is Radar's assessment of a payment's charge. It is kept apart
+// from the payment because the charge can be reported before the payment
+// is linked to its payment intent.
+type PaymentRisk struct {
+	PaymentIntentID string `json:"paymentIntentId"`
+	ChargeID        string `json:"chargeId"`
+	RiskLevel       string `json:"riskLevel"`
+	RiskScore       int64  `json:"riskScore"`
+}
+
+// Refund is the local record of a refund issued against a payment intent.
+type Refund struct {
+	ID              string    `json:"id"`
+	PaymentIntentID string    `json:"paymentIntentId"`
+	Amount          int64     `json:"amount"`
+	Currency        string    `json:"currency"`
+	Status          string    `json:"status"`
+	Reason          string    `json:"reason"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// ConnectedAccount is the local copy of a marketplace seller's Stripe
+// Connect account.
+type ConnectedAccount struct {
+	ID               string    `json:"id"`
+	Email            string    `json:"email"`
+	ChargesEnabled   bool      `json:"chargesEnabled"`
+	PayoutsEnabled   bool      `json:"payoutsEnabled"`
+	DetailsSubmitted bool      `json:"detailsSubmitted"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// Subscription is the local copy of a Stripe subscription, kept current by
+// the customer.subscription and invoice webhooks. LatestInvoiceStatus is
+// "paid" or "payment_failed" once an invoice has been attempted.
+// PreviousPriceID and PriceChangedAt record the last time the subscription
+// moved to a different price.
+type Subscription struct {
+	ID                  string     `json:"id"`
+	CustomerID          string     `json:"customerId"`
+	PriceID             string     `json:"priceId,omitempty"`
+	PreviousPriceID     string     `json:"previousPriceId,omitempty"`
+	PriceChangedAt      *time.Time `json:"priceChangedAt,omitempty"`
+	Status              string     `json:"status"`
+	CurrentPeriodStart  time.Time  `json:"currentPeriodStart"`
+	CurrentPeriodEnd    time.Time  `json:"currentPeriodEnd"`
+	TrialEnd            *time.Time `json:"trialEnd,omitempty"`
+	CancelAtPeriodEnd   bool       `json:"cancelAtPeriodEnd"`
+	CanceledAt          *time.Time `json:"canceledAt,omitempty"`
+	LatestInvoiceStatus string     `json:"latestInvoiceStatus,omitempty"`
+	UpdatedAt           time.Time  `json:"updatedAt"`
+}
+
+// Dispute is the local copy of a Stripe dispute, kept current by the
+// charge.dispute webhooks. Evidence holds what has been attached so far, by
+// Stripe evidence field: text, or the ID of an uploaded file. It is sent to
+// Stripe when the evidence is submitted, which sets SubmittedAt.
+type Dispute struct {
+	ID              string            `json:"id"`
+	ChargeID        string            `json:"chargeId"`
+	PaymentIntentID string            `json:"paymentIntentId,omitempty"`
+	Amount          int64             `json:"amount"`
+	Currency        string            `json:"currency"`
+	Reason          string            `json:"reason"`
+	Status          string            `json:"status"`
+	EvidenceDueBy   *time.Time        `json:"evidenceDueBy,omitempty"`
+	Evidence        map[string]string `json:"evidence"`
+	SubmittedAt     *time.Time        `json:"submittedAt,omitempty"`
+	CreatedAt       time.Time         `json:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt"`
+}
+
+// Review is the local copy of a Radar review, kept current by the review
+// webhooks. OpenedReason is rule or manual; ClosedReason, once the review
+// is closed, is approved, refunded, refunded_as_fraud, disputed or
+// redacted.
+type Review struct {
+	ID              string    `json:"id"`
+	ChargeID        string    `json:"chargeId"`
+	PaymentIntentID string    `json:"paymentIntentId,omitempty"`
+	Open            bool      `json:"open"`
+	OpenedReason    string    `json:"openedReason"`
+	ClosedReason    string    `json:"closedReason,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// TaxID is a tax ID a customer entered on the Checkout page of a session.
+// Verification is "pending" while an EU VAT number waits for its VIES
+// check, then "valid" or "invalid" with the name VIES has on record for it;
+// tax IDs that aren't checked are "unverified".
+type TaxID struct {
+	SessionID    string     `json:"sessionId"`
+	Type         string     `json:"type"`
+	Value        string     `json:"value"`
+	Verification string     `json:"verification"`
+	VerifiedName string     `json:"verifiedName,omitempty"`
+	CheckedAt    *time.Time `json:"checkedAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// StoredEvent is a Stripe event kept in the event log. Payload is the event
+// as it was delivered; SessionID, PaymentIntentID and OrderID link it to the
+// payment it concerns, as far as its object tells.
+type StoredEvent struct {
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	ObjectID        string          `json:"objectId"`
+	SessionID       string          `json:"sessionId,omitempty"`
+	PaymentIntentID string          `json:"paymentIntentId,omitempty"`
+	OrderID         string          `json:"orderId,omitempty"`
+	Created         time.Time       `json:"created"`
+	ReceivedAt      time.Time       `json:"receivedAt"`
+	Payload         json.RawMessage `json:"payload"`
+}
+
+// WebhookDelivery is the log of one event sent to an outbound webhook
+// endpoint. Payload is the exact body posted, so a retry sends the same
+// event. Status is pending until a delivery attempt succeeds or fails.
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	Endpoint       string          `json:"endpoint"`
+	EventID        string          `json:"eventId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"responseStatus,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+}
+
+// Fulfillment is the delivery of one line of a paid order by the
+// fulfiller its FULFILLMENT_ROUTES entry picked. Details holds what the
+// customer got, such as a license key or a shipment ticket, and LastError
+// why the latest attempt failed.
+type Fulfillment struct {
+	ID        string            `json:"id"`
+	OrderID   string            `json:"orderId"`
+	Price     string            `json:"price"`
+	Product   string            `json:"product,omitempty"`
+	Type      string            `json:"type"`
+	Target    string            `json:"target,omitempty"`
+	Quantity  int64             `json:"quantity"`
+	Status    string            `json:"status"`
+	Details   map[string]string `json:"details"`
+	LastError string            `json:"lastError,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// License is a license key issued for a line of an order. Quantity is the
+// number of seats it covers.
+type License struct {
+	Key       string    `json:"key"`
+	OrderID   string    `json:"orderId"`
+	Price     string    `json:"price"`
+	Product   string    `json:"product,omitempty"`
+	Quantity  int64     `json:"quantity"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// InventoryItem is the tracked stock of a price. Reserved counts the units
+// held by checkout sessions that haven't completed or expired yet.
+type InventoryItem struct {
+	Price     string    `json:"price"`
+	Stock     int64     `json:"stock"`
+	Reserved  int64     `json:"reserved"`
+	Available int64     `json:"available"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// OutOfStockError is returned by Reserve when a price doesn't have enough
+// units left.
+type OutOfStockError struct {
+	Price     string
+	Available int64
+}
+
+func (e *OutOfStockError) Error() string {
+	return fmt.Sprintf("price %q: only %d left in stock", e.Price, e.Available)
+}
+
+// IdempotentResponse is the stored answer to a request made with an
+// Idempotency-Key, replayed when the request is retried. Status is 0 while
+// the first request is still running.
+type IdempotentResponse struct {
+	Key         string
+	RequestHash string
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// PaymentFilter narrows ListPayments. Zero fields don't filter.
+type PaymentFilter struct {
+	Status   string
+	Currency string
+	// From and To bound CreatedAt; To is exclusive.
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// AuditFilter narrows ListAudit. Zero fields don't filter.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Object string
+	// From and To bound CreatedAt; To is exclusive.
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// OrderFilter narrows ListOrders. Zero fields don't filter.
+type OrderFilter struct {
+	Status OrderStatus
+	Limit  int
+	Offset int
+}
+
+// DisputeFilter narrows ListDisputes. Zero fields don't filter.
+type DisputeFilter struct {
+	Status string
+	Limit  int
+	Offset int
+}
+
+// InvoiceFilter narrows ListInvoices. Zero fields don't filter; DueBefore
+// matches invoices due before it.
+type InvoiceFilter struct {
+	Status     string
+	CustomerID string
+	DueBefore  time.Time
+	Limit      int
+	Offset     int
+}
+
+// UsageRecordFilter narrows ListUsageRecords. Zero fields don't filter.
+type UsageRecordFilter struct {
+	SubscriptionItem string
+	Status           string
+	// From and To bound Timestamp; To is exclusive.
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// ReviewFilter narrows ListReviews. Zero fields don't filter.
+type ReviewFilter struct {
+	Open            *bool
+	PaymentIntentID string
+	Limit           int
+	Offset          int
+}
+
+// StoredEventFilter narrows ListStoredEvents to the events of one payment:
+// those linked to any of its non-empty IDs. A zero filter matches every
+// event.
+type StoredEventFilter struct {
+	OrderID         string
+	SessionID       string
+	PaymentIntentID string
+}
+
+// WebhookDeliveryFilter narrows ListWebhookDeliveries. Zero fields don't
+// filter.
+type WebhookDeliveryFilter struct {
+	Endpoint string
+	EventID  string
+	Status   string
+	Limit    int
+	Offset   int
+}
+
+// StoreCreditFilter pages ListStoreCredits.
+type StoreCreditFilter struct {
+	Limit  int
+	Offset int
+}
+
+// The store's not-found errors; each is an ErrNotFound.
+var (
+	ErrPaymentNotFound = fmt.Errorf("payment %w", ErrNotFound)
+	ErrAccountNotFound = fmt.Errorf("connected account %w", ErrNotFound)
+	ErrOrderNotFound   = fmt.Errorf("order %w", ErrNotFound)
+
+	ErrSubscriptionNotFound = fmt.Errorf("subscription %w", ErrNotFound)
+	ErrDisputeNotFound      = fmt.Errorf("dispute %w", ErrNotFound)
+	ErrReviewNotFound       = fmt.Errorf("review %w", ErrNotFound)
+	ErrDeliveryNotFound     = fmt.Errorf("webhook delivery %w", ErrNotFound)
+	ErrFulfillmentNotFound  = fmt.Errorf("fulfillment %w", ErrNotFound)
+	ErrLicenseNotFound      = fmt.Errorf("license %w", ErrNotFound)
+	ErrPriceLimitNotFound   = fmt.Errorf("price limit %w", ErrNotFound)
+	ErrCartNotFound         = fmt.Errorf("cart %w", ErrNotFound)
+
+	ErrAbandonedCheckoutNotFound = fmt.Errorf("abandoned checkout %w", ErrNotFound)
+	ErrInvoiceNotFound           = fmt.Errorf("invoice %w", ErrNotFound)
+	ErrUsageRecordNotFound       = fmt.Errorf("usage record %w", ErrNotFound)
+	ErrStoreCreditNotFound       = fmt.Errorf("store credit %w", ErrNotFound)
+	ErrRedemptionNotFound        = fmt.Errorf("store credit redemption %w", ErrNotFound)
+	ErrAuthenticationNotFound    = fmt.Errorf("authentication %w", ErrNotFound)
+)
+
+// ErrInsufficientStoreCredit is returned by HoldStoreCredit when the
+// credit's balance is below the amount.
+var ErrInsufficientStoreCredit = errors.New("store credit balance is too low")
+
+// PaymentStore persists payments so they survive restarts.
+//
+// Methods run their queries under ctx and give up once it is cancelled.
+type PaymentStore interface {
+	// SavePayment inserts p, or updates the existing record for p.SessionID.
+	SavePayment(ctx context.Context, p *Payment) error
+	GetPayment(ctx context.Context, sessionID string) (*Payment, error)
+	GetPaymentByIntent(ctx context.Context, paymentIntentID string) (*Payment, error)
+	// ListPayments returns matching payments, newest first.
+	ListPayments(ctx context.Context, f PaymentFilter) ([]*Payment, error)
+	// SaveRefund inserts r, or updates the existing record for r.ID.
+	SaveRefund(ctx context.Context, r *Refund) error
+	ListRefunds(ctx context.Context, paymentIntentID string) ([]*Refund, error)
+	// ListRefundsBetween returns the refunds created from from until to,
+	// oldest first.
+	ListRefundsBetween(ctx context.Context, from, to time.Time) ([]*Refund, error)
+	// SaveOrder inserts o, or updates the existing record for o.ID.
+	SaveOrder(ctx context.Context, o *Order) error
+	GetOrder(ctx context.Context, id string) (*Order, error)
+	GetOrderBySession(ctx context.Context, sessionID string) (*Order, error)
+	// ListOrders returns matching orders, newest first.
+	ListOrders(ctx context.Context, f OrderFilter) ([]*Order, error)
+	// SaveConnectedAccount inserts a, or updates the existing record for a.ID.
+	SaveConnectedAccount(ctx context.Context, a *ConnectedAccount) error
+	GetConnectedAccount(ctx context.Context, id string) (*ConnectedAccount, error)
+	// SaveSubscription inserts sub, or updates the existing record for
+	// sub.ID. LatestInvoiceStatus is only set by SetSubscriptionInvoiceStatus;
+	// PreviousPriceID and PriceChangedAt are set when the price differs from
+	// the stored one.
+	SaveSubscription(ctx context.Context, sub *Subscription) error
+	GetSubscription(ctx context.Context, id string) (*Subscription, error)
+	// ListSubscriptions returns a customer's subscriptions, newest period
+	// first.
+	ListSubscriptions(ctx context.Context, customerID string) ([]*Subscription, error)
+	// SetSubscriptionInvoiceStatus records the outcome of a subscription's
+	// latest invoice.
+	SetSubscriptionInvoiceStatus(ctx context.Context, id, status string) error
+	// SaveDispute inserts d, or updates the existing record for d.ID.
+	// Evidence and SubmittedAt are only set by SetDisputeEvidence.
+	SaveDispute(ctx context.Context, d *Dispute) error
+	GetDispute(ctx context.Context, id string) (*Dispute, error)
+	// ListDisputes returns matching disputes, soonest evidence due date
+	// first.
+	ListDisputes(ctx context.Context, f DisputeFilter) ([]*Dispute, error)
+	// SetDisputeEvidence replaces the evidence attached to a dispute and
+	// records when it was submitted, if it was.
+	SetDisputeEvidence(ctx context.Context, id string, evidence map[string]string, submittedAt *time.Time) error
+	// SavePaymentRisk inserts r, or updates the existing record for
+	// r.PaymentIntentID.
+	SavePaymentRisk(ctx context.Context, r *PaymentRisk) error
+	// SaveReview inserts rv, or updates the existing record for rv.ID.
+	SaveReview(ctx context.Context, rv *Review) error
+	GetReview(ctx context.Context, id string) (*Review, error)
+	// ListReviews returns matching reviews, newest first.
+	ListReviews(ctx context.Context, f ReviewFilter) ([]*Review, error)
+	// AddTaxIDs records the tax IDs of a session. Tax IDs it already has
+	// keep their verification.
+	AddTaxIDs(ctx context.Context, ids []*TaxID) error
+	// UpdateTaxIDVerification stores the outcome of checking t.
+	UpdateTaxIDVerification(ctx context.Context, t *TaxID) error
+	// ListTaxIDs returns the tax IDs of a session.
+	ListTaxIDs(ctx context.Context, sessionID string) ([]*TaxID, error)
+	// AppendStoredEvent adds an event to the event log. Events it already
+	// has are left as they are, so redeliveries are stored once.
+	AppendStoredEvent(ctx context.Context, e *StoredEvent) error
+	// ListStoredEvents returns matching events in the order Stripe created
+	// them.
+	ListStoredEvents(ctx context.Context, f StoredEventFilter) ([]*StoredEvent, error)
+	// SaveWebhookDelivery inserts d, or updates the existing record for d.ID.
+	SaveWebhookDelivery(ctx context.Context, d *WebhookDelivery) error
+	GetWebhookDelivery(ctx context.Context, id string) (*WebhookDelivery, error)
+	// ListWebhookDeliveries returns matching deliveries, newest first.
+	ListWebhookDeliveries(ctx context.Context, f WebhookDeliveryFilter) ([]*WebhookDelivery, error)
+	// SaveFulfillment inserts f, or updates the existing record for f.ID.
+	SaveFulfillment(ctx context.Context, f *Fulfillment) error
+	GetFulfillment(ctx context.Context, id string) (*Fulfillment, error)
+	// ListFulfillments returns an order's fulfillments, oldest first.
+	ListFulfillments(ctx context.Context, orderID string) ([]*Fulfillment, error)
+	// SaveLicense stores l, keeping the issue date of a key saved before.
+	SaveLicense(ctx context.Context, l *License) error
+	GetLicense(ctx context.Context, key string) (*License, error)
+	// BeginIdempotentRequest claims key for a request with requestHash and
+	// returns nil, or returns the response stored for the key if it is
+	// taken. Keys claimed before expiredBefore are forgotten.
+	BeginIdempotentRequest(ctx context.Context, key, requestHash string, expiredBefore time.Time) (*IdempotentResponse, error)
+	// CompleteIdempotentRequest stores the response for a claimed key;
+	// ReleaseIdempotentRequest gives the key up so the request can be
+	// retried.
+	CompleteIdempotentRequest(ctx context.Context, r *IdempotentResponse) error
+	ReleaseIdempotentRequest(ctx context.Context, key string) error
+	// ClaimEvent claims a webhook event until expiresAt. It returns false
+	// if it is already claimed. CompleteEvent moves the expiry of a claim
+	// to expiresAt and ReleaseEvent drops it.
+	ClaimEvent(ctx context.Context, eventID string, expiresAt time.Time) (bool, error)
+	CompleteEvent(ctx context.Context, eventID string, expiresAt time.Time) error
+	ReleaseEvent(ctx context.Context, eventID string) error
+	// SaveJobQueue replaces the stored job queue snapshot; LoadJobQueue
+	// returns it, or nil if none was saved.
+	SaveJobQueue(ctx context.Context, snapshot []byte) error
+	LoadJobQueue(ctx context.Context) ([]byte, error)
+	// SetStock sets the stock of a price, starting to track it if needed.
+	// SeedStock does the same only for prices that aren't tracked yet.
+	SetStock(ctx context.Context, priceID string, stock int64) error
+	SeedStock(ctx context.Context, priceID string, stock int64) error
+	ListInventory(ctx context.Context) ([]*InventoryItem, error)
+	// SetPriceLimit inserts l, or updates the limit of l.Price.
+	// DeletePriceLimit removes it, failing with ErrPriceLimitNotFound if
+	// there is none.
+	SetPriceLimit(ctx context.Context, l *config.PriceLimit) error
+	DeletePriceLimit(ctx context.Context, priceID string) error
+	ListPriceLimits(ctx context.Context) ([]*config.PriceLimit, error)
+	// Reserve holds quantities of tracked prices under id until expiresAt,
+	// or fails with an *OutOfStockError without holding anything. Untracked
+	// prices are ignored; the result reports whether anything was held.
+	Reserve(ctx context.Context, id string, quantities map[string]int64, expiresAt time.Time) (bool, error)
+	// RenameReservation moves a reservation to a new id and
+	// ExtendReservation keeps it until expiresAt.
+	RenameReservation(ctx context.Context, from, to string) error
+	ExtendReservation(ctx context.Context, id string, expiresAt time.Time) error
+	// CommitReservation takes the reserved units out of stock and
+	// ReleaseReservation returns them. Both are no-ops for unknown ids.
+	// quantities, when not nil, are the units actually bought, for sessions
+	// whose customer lowered a quantity; they never exceed the reservation.
+	CommitReservation(ctx context.Context, id string, quantities map[string]int64) error
+	ReleaseReservation(ctx context.Context, id string) error
+	// SaveCart inserts c, or updates the existing record for c.ID.
+	SaveCart(ctx context.Context, c *Cart) error
+	GetCart(ctx context.Context, id string) (*Cart, error)
+	// SaveAbandonedCheckout inserts a, or updates the existing record for
+	// a.ID. Each session is recorded at most once.
+	SaveAbandonedCheckout(ctx context.Context, a *AbandonedCheckout) error
+	GetAbandonedCheckout(ctx context.Context, id string) (*AbandonedCheckout, error)
+	GetAbandonedCheckoutBySession(ctx context.Context, sessionID string) (*AbandonedCheckout, error)
+	// ListAbandonedCheckouts returns the checkouts abandoned in [from, to),
+	// newest first. Zero times leave that end open.
+	ListAbandonedCheckouts(ctx context.Context, from, to time.Time) ([]*AbandonedCheckout, error)
+	// SaveInvoice inserts inv, or updates the existing record for inv.ID.
+	SaveInvoice(ctx context.Context, inv *Invoice) error
+	GetInvoice(ctx context.Context, id string) (*Invoice, error)
+	// ListInvoices returns matching invoices, soonest due date first.
+	ListInvoices(ctx context.Context, f InvoiceFilter) ([]*Invoice, error)
+	// AddUsageRecord inserts u unless a record with its ID exists, and
+	// reports whether it did.
+	AddUsageRecord(ctx context.Context, u *UsageRecord) (bool, error)
+	GetUsageRecord(ctx context.Context, id string) (*UsageRecord, error)
+	// UpdateUsageRecord stores how reporting u to Stripe went.
+	UpdateUsageRecord(ctx context.Context, u *UsageRecord) error
+	// ListUsageRecords returns matching usage records in the order they
+	// were added.
+	ListUsageRecords(ctx context.Context, f UsageRecordFilter) ([]*UsageRecord, error)
+	// CreateStoreCredit inserts c, whose code is only stored as codeHash.
+	CreateStoreCredit(ctx context.Context, c *StoreCredit, codeHash string) error
+	GetStoreCredit(ctx context.Context, id string) (*StoreCredit, error)
+	GetStoreCreditByCode(ctx context.Context, codeHash string) (*StoreCredit, error)
+	// ListStoreCredits returns store credits, newest first.
+	ListStoreCredits(ctx context.Context, f StoreCreditFilter) ([]*StoreCredit, error)
+	// HoldStoreCredit takes r.Amount off the balance of r.CreditID and
+	// records r as held, or returns ErrInsufficientStoreCredit if the
+	// balance is too low.
+	HoldStoreCredit(ctx context.Context, r *StoreCreditRedemption) error
+	// SettleStoreCredit moves the credit held for an order to status:
+	// redeemed, or released back onto the balance. It returns
+	// ErrRedemptionNotFound if the order holds none.
+	SettleStoreCredit(ctx context.Context, orderID, status string) (*StoreCreditRedemption, error)
+	// ListStoreCreditRedemptions returns what was spent from a credit, in
+	// the order it was.
+	ListStoreCreditRedemptions(ctx context.Context, creditID string) ([]*StoreCreditRedemption, error)
+	// CreateAuthentication inserts a unless its payment intent already
+	// waits for authentication, and reports whether it did.
+	CreateAuthentication(ctx context.Context, a *Authentication) (bool, error)
+	GetAuthentication(ctx context.Context, paymentIntentID string) (*Authentication, error)
+	// UpdateAuthentication stores a's status and failures.
+	UpdateAuthentication(ctx context.Context, a *Authentication) error
+	// AppendAudit adds an entry to the audit log. Entries are never updated
+	// or deleted.
+	AppendAudit(ctx context.Context, e *AuditEntry) error
+	// ListAudit returns matching audit entries, newest first.
+	ListAudit(ctx context.Context, f AuditFilter) ([]*AuditEntry, error)
+	// Ping checks that the database is reachable.
+	Ping(ctx context.Context) error
+	Close() error
+}
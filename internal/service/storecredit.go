@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// Store credit codes are 10 random bytes written as four dash separated
+// groups of four base32 characters, such as gift cards carry. Only their
+// hash is stored, so the code is shown once, when the credit is issued.
+const storeCreditCodeBytes = 10
+
+// StoreCreditMetadataKey is the session and payment intent metadata key
+// naming the store credit a checkout spends. Clients can't set it.
+const StoreCreditMetadataKey = "store_credit"
+
+// StoreCreditMinCharge is what store credit always leaves to be paid: Stripe
+// won't start a payment for less than about 50 cents, or the same number of
+// minor units in most other currencies.
+const StoreCreditMinCharge = 50
+
+// The statuses of a StoreCreditRedemption. A held amount is off the
+// balance until its checkout is paid, which redeems it, or expires or
+// fails, which releases it back.
+const (
+	CreditHeld     = "held"
+	CreditRedeemed = "redeemed"
+	CreditReleased = "released"
+)
+
+// errStoreCreditCode is returned for codes that can't have been issued.
+var errStoreCreditCode = errors.New("invalid store credit code")
+
+// StoreCredit is a balance issued through POST /admin/store-credits, as a
+// gift card or a goodwill credit, that customers spend at checkout with
+// its code. Balance is what can still be spent; amounts held by open
+// checkouts are already taken off it. Last4 is the end of the code, to
+// tell credits apart.
+type StoreCredit struct {
+	ID        string    `json:"id"`
+	Last4     string    `json:"last4"`
+	Currency  string    `json:"currency"`
+	Amount    int64     `json:"amount"`
+	Balance   int64     `json:"balance"`
+	Email     string    `json:"email,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// StoreCreditRedemption is the store credit applied to an order's checkout.
+// An order spends at most one credit.
+type StoreCreditRedemption struct {
+	OrderID   string    `json:"orderId"`
+	CreditID  string    `json:"creditId"`
+	Amount    int64     `json:"amount"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// StoreCreditRequest is the JSON body accepted by POST /admin/store-credits.
+// Email is who the credit was issued to and Note why; both are for the
+// records only.
+type StoreCreditRequest struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Email    string `json:"email"`
+	Note     string `json:"note"`
+}
+
+func (req *StoreCreditRequest) Validate(ctx context.Context, svc *Service) error {
+	req.Currency = strings.ToLower(req.Currency)
+	if !config.CurrencyPattern.MatchString(req.Currency) {
+		return fmt.Errorf("invalid currency %q", req.Currency)
+	}
+	if req.Amount < 1 {
+		return errors.New("amount must be positive")
+	}
+	if req.Email != "" {
+		if err := config.ValidateEmail(req.Email); err != nil {
+			return err
+		}
+	}
+	if len(req.Note) > 500 {
+		return errors.New("note must be at most 500 characters")
+	}
+	return nil
+}
+
+// IssuedStoreCredit is the answer to POST /admin/store-credits: the credit
+// and the code to hand to the customer, which can't be looked up later.
+type IssuedStoreCredit struct {
+	*StoreCredit
+	Code string `json:"code"`
+}
+
+// newStoreCreditCode returns a random code.
+func newStoreCreditCode() (string, error) {
+	b := make([]byte, storeCreditCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	raw := licenseEncoding.EncodeToString(b)
+	return strings.Join([]string{raw[0:4], raw[4:8], raw[8:12], raw[12:16]}, "-"), nil
+}
+
+// storeCreditCodeHash returns what a code is stored as. Codes are accepted
+// in any case, with or without dashes and spaces.
+func storeCreditCodeHash(code string) (string, error) {
+	canonical := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if b, err := licenseEncoding.DecodeString(canonical); err != nil || len(b) != storeCreditCodeBytes {
+		return "", errStoreCreditCode
+	}
+	return config.SHA256Hex("store-credit." + canonical), nil
+}
+
+// IssueStoreCredit creates a credit for req and returns it with its code.
+func (svc *Service) IssueStoreCredit(ctx context.Context, req *StoreCreditRequest) (*IssuedStoreCredit, error) {
+	code, err := newStoreCreditCode()
+	if err != nil {
+		return nil, InternalError("generating code", err)
+	}
+	hash, _ := storeCreditCodeHash(code)
+	c := &StoreCredit{
+		ID:       "scr_" + NewRequestID(),
+		Last4:    code[len(code)-4:],
+		Currency: req.Currency,
+		Amount:   req.Amount,
+		Balance:  req.Amount,
+		Email:    req.Email,
+		Note:     req.Note,
+	}
+	if err := svc.Payments.CreateStoreCredit(ctx, c, hash); err != nil {
+		return nil, InternalError("saving store credit", err)
+	}
+	svc.RecordAudit(ctx, AuditActor(ctx), "store_credit.issued", c.ID, nil, c)
+	LogCtx(ctx).Info("store credit issued", "credit", c.ID, "amount", c.Amount, "currency", c.Currency)
+	return &IssuedStoreCredit{StoreCredit: c, Code: code}, nil
+}
+
+// findStoreCredit returns the credit with the code a customer typed.
+func (svc *Service) findStoreCredit(ctx context.Context, code string) (*StoreCredit, error) {
+	hash, err := storeCreditCodeHash(code)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+	c, err := svc.Payments.GetStoreCreditByCode(ctx, hash)
+	if err == ErrStoreCreditNotFound {
+		return nil, &Error{Status: http.StatusNotFound, Message: "unknown store credit code"}
+	}
+	if err != nil {
+		return nil, InternalError("fetching store credit", err)
+	}
+	return c, nil
+}
+
+// applyStoreCredit holds as much of the credit as the order's cart allows
+// and charges the cart less by that much. Checkout has no negative lines,
+// so each line is charged as price_data for its product, its total less
+// its share of the credit, and keeps its quantity when the reduced total
+// divides by it. The credit always leaves StoreCreditMinCharge to pay. It
+// returns the amount held, which CancelOrder or the session's webhooks
+// settle.
+func (svc *Service) applyStoreCredit(ctx context.Context, params *stripe.CheckoutSessionParams, o *Order, c *StoreCredit, currency string) (int64, error) {
+	var total int64
+	charged := currency
+	totals := make([]int64, len(params.LineItems))
+	products := make([]string, len(params.LineItems))
+	for i, li := range params.LineItems {
+		p, ok := svc.CatalogFor(ctx).Price(stripe.StringValue(li.Price))
+		if !ok {
+			return 0, BadRequest(fmt.Errorf("unknown price %q", stripe.StringValue(li.Price)))
+		}
+		totals[i], charged = p.lineAmount(stripe.Int64Value(li.Quantity), currency)
+		products[i] = p.Product
+		total += totals[i]
+	}
+	if c.Currency != charged {
+		return 0, BadRequest(fmt.Errorf("store credit is in %s but the cart is charged in %s", strings.ToUpper(c.Currency), strings.ToUpper(charged)))
+	}
+	if c.Balance <= 0 {
+		return 0, BadRequest(errors.New("store credit has no balance left"))
+	}
+	amount := min(c.Balance, total-StoreCreditMinCharge)
+	if amount <= 0 {
+		return 0, BadRequest(fmt.Errorf("store credit needs an order total above %s", FormatAmount(StoreCreditMinCharge, charged)))
+	}
+	r := &StoreCreditRedemption{OrderID: o.ID, CreditID: c.ID, Amount: amount, Status: CreditHeld}
+	if err := svc.Payments.HoldStoreCredit(ctx, r); err == ErrInsufficientStoreCredit {
+		return 0, &Error{Status: http.StatusConflict, Message: "store credit was spent in the meantime; try again"}
+	} else if err != nil {
+		return 0, InternalError("holding store credit", err)
+	}
+
+	// Spread the credit in proportion to the line totals; rounding leaves
+	// a few minor units for the largest line.
+	shares := make([]int64, len(totals))
+	largest, left := 0, amount
+	for i, t := range totals {
+		shares[i] = amount * t / total
+		left -= shares[i]
+		if t > totals[largest] {
+			largest = i
+		}
+	}
+	shares[largest] += left
+	for i, li := range params.LineItems {
+		reduced, quantity := totals[i]-shares[i], stripe.Int64Value(li.Quantity)
+		if reduced%quantity != 0 {
+			quantity = 1
+		}
+		params.LineItems[i] = &stripe.CheckoutSessionLineItemParams{
+			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+				Currency:   stripe.String(charged),
+				Product:    stripe.String(products[i]),
+				UnitAmount: stripe.Int64(reduced / quantity),
+			},
+			Quantity: stripe.Int64(quantity),
+		}
+	}
+	// The lines set the currency now.
+	params.Currency = nil
+	params.AddMetadata(StoreCreditMetadataKey, c.ID)
+	params.PaymentIntentData.Metadata[StoreCreditMetadataKey] = c.ID
+	return amount, nil
+}
+
+// releaseStoreCredit puts the credit held for an order back on its balance.
+func (svc *Service) releaseStoreCredit(ctx context.Context, orderID string) {
+	r, err := svc.Payments.SettleStoreCredit(context.WithoutCancel(ctx), orderID, CreditReleased)
+	if err == ErrRedemptionNotFound {
+		return
+	}
+	if err != nil {
+		LogCtx(ctx).Error("releasing store credit", "order", orderID, "error", err)
+		return
+	}
+	LogCtx(ctx).Info("store credit released", "order", orderID, "credit", r.CreditID, "amount", r.Amount)
+}
+
+// StoreCreditDetail is the answer to GET /admin/store-credits/{id}: the
+// credit, what was spent from it, and Held, the amount open checkouts
+// hold. Reconciled reports whether the balance is the issued amount less
+// everything held and redeemed.
+type StoreCreditDetail struct {
+	*StoreCredit
+	Held        int64                    `json:"held"`
+	Redeemed    int64                    `json:"redeemed"`
+	Reconciled  bool                     `json:"reconciled"`
+	Redemptions []*StoreCreditRedemption `json:"redemptions"`
+}
+
+// StoreCreditDetail reconciles the credit's balance against its
+// redemptions.
+func (svc *Service) StoreCreditDetail(ctx context.Context, c *StoreCredit) (*StoreCreditDetail, error) {
+	list, err := svc.Payments.ListStoreCreditRedemptions(ctx, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	d := &StoreCreditDetail{StoreCredit: c, Redemptions: list}
+	if d.Redemptions == nil {
+		d.Redemptions = []*StoreCreditRedemption{}
+	}
+	for _, r := range list {
+		switch r.Status {
+		case CreditHeld:
+			d.Held += r.Amount
+		case CreditRedeemed:
+			d.Redeemed += r.Amount
+		}
+	}
+	d.Reconciled = c.Balance == c.Amount-d.Held-d.Redeemed
+	if !d.Reconciled {
+		slog.Error("store credit balance doesn't match its redemptions", "credit", c.ID, "balance", c.Balance,
+			"amount", c.Amount, "held", d.Held, "redeemed", d.Redeemed)
+	}
+	return d, nil
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/stripeclient"
+)
+
+// StripeAccountHeader names the connected account an admin caller acts on.
+// It is passed on to Stripe as Stripe's own header of the same name.
+const StripeAccountHeader = "Stripe-Account"
+
+// ConnectedAccount returns the connected account id, fetching it from
+// Stripe the first time it is seen. Accounts Stripe doesn't know as
+// connected to the platform are a 404.
+func (svc *Service) ConnectedAccount(ctx context.Context, id string) (*ConnectedAccount, error) {
+	a, err := svc.Payments.GetConnectedAccount(ctx, id)
+	if !errors.Is(err, ErrAccountNotFound) {
+		if err != nil {
+			return nil, InternalError("looking up account", err)
+		}
+		return a, nil
+	}
+	sa, err := svc.Stripe.GetAccount(ctx, id, nil)
+	if err != nil {
+		var se *stripe.Error
+		if errors.As(err, &se) && (se.HTTPStatusCode == http.StatusNotFound || se.HTTPStatusCode == http.StatusForbidden) {
+			return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("unknown connected account %q", id)}
+		}
+		return nil, &StripeFailure{Action: "fetching account", Err: err}
+	}
+	a = ConnectedAccountFromStripe(sa)
+	if err := svc.Payments.SaveConnectedAccount(ctx, a); err != nil {
+		return nil, InternalError("saving account", err)
+	}
+	return a, nil
+}
+
+// catalogLoad is a connected account's catalog being loaded. done is closed
+// once catalog or err is set.
+type catalogLoad struct {
+	done    chan struct{}
+	catalog *Catalog
+	err     error
+}
+
+// AccountCatalog returns the catalog of connected account id, loading it
+// from the account the first time it is acted on and again once it is older
+// than CATALOG_REFRESH_INTERVAL. The catalog is loaded without holding the
+// lock, once however many requests need it meanwhile, so a slow account
+// doesn't hold up the others.
+func (svc *Service) AccountCatalog(ctx context.Context, id string) (*Catalog, error) {
+	svc.accountCatalogs.Lock()
+	c := svc.accountCatalogs.byID[id]
+	if c != nil && (svc.Config.CatalogRefreshInterval <= 0 || c.age() < svc.Config.CatalogRefreshInterval) {
+		svc.accountCatalogs.Unlock()
+		return c, nil
+	}
+	load, loading := svc.accountCatalogs.loading[id]
+	if !loading {
+		load = &catalogLoad{done: make(chan struct{})}
+		svc.accountCatalogs.loading[id] = load
+	}
+	svc.accountCatalogs.Unlock()
+
+	if !loading {
+		next := NewCatalog()
+		err := next.Load(stripeclient.WithAccount(ctx, id), svc.Stripe)
+		svc.accountCatalogs.Lock()
+		delete(svc.accountCatalogs.loading, id)
+		if err != nil {
+			load.err = &StripeFailure{Action: "loading the account's catalog", Err: err}
+		} else {
+			load.catalog = next
+			svc.accountCatalogs.byID[id] = next
+		}
+		svc.accountCatalogs.Unlock()
+		close(load.done)
+	}
+	select {
+	case <-load.done:
+		return load.catalog, load.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// LoadedAccountCatalog returns the catalog AccountCatalog last loaded for
+// id, or nil.
+func (svc *Service) LoadedAccountCatalog(id string) *Catalog {
+	svc.accountCatalogs.Lock()
+	defer svc.accountCatalogs.Unlock()
+	return svc.accountCatalogs.byID[id]
+}
+
+// ForgetAccountCatalogs drops the connected accounts' catalogs, which are
+// loaded again when next needed.
+func (svc *Service) ForgetAccountCatalogs() {
+	svc.accountCatalogs.Lock()
+	defer svc.accountCatalogs.Unlock()
+	svc.accountCatalogs.byID = map[string]*Catalog{}
+}
@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// SubscriptionRequest is the body accepted by /create-subscription-session.
+// Quantity defaults to 1; for tiered prices it picks the tiers billed.
+// CustomerToken is the portal token of Customer, which callers other than
+// admins need.
+type SubscriptionRequest struct {
+	Price         string `json:"price"`
+	Quantity      int64  `json:"quantity"`
+	Customer      string `json:"customer"`
+	CustomerToken string `json:"customerToken"`
+	SuccessURL    string `json:"successUrl"`
+	CancelURL     string `json:"cancelUrl"`
+	Locale        string `json:"locale"`
+}
+
+func (s *SubscriptionRequest) Validate(ctx context.Context, svc *Service) error {
+	if s.Customer != "" {
+		if err := ValidateStripeID(s.Customer, "cus_", "customer"); err != nil {
+			return err
+		}
+	}
+	p, ok := svc.CatalogFor(ctx).Price(s.Price)
+	if !ok {
+		return fmt.Errorf("unknown price %q", s.Price)
+	}
+	if p.Recurring == nil {
+		return fmt.Errorf("price %q is not recurring", s.Price)
+	}
+	if s.Quantity == 0 {
+		s.Quantity = 1
+	}
+	var err error
+	if s.Locale, err = NormalizeLocale(s.Locale); err != nil {
+		return err
+	}
+	return svc.validateQuantity(s.Quantity)
+}
+
+// CreateSubscriptionCheckout creates the order and the subscription-mode
+// checkout session for a validated request. The order holds the first
+// period's amount, worked out from the price's tiers so the success page can
+// show it before the webhook reports what was actually charged.
+func (svc *Service) CreateSubscriptionCheckout(ctx context.Context, req *SubscriptionRequest, acceptLanguage string) (*CreateCheckoutResponse, error) {
+	successURL, cancelURL, err := svc.CheckoutReturnURLs(req.SuccessURL, req.CancelURL)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+	params := &stripe.CheckoutSessionParams{
+		SuccessURL: stripe.String(successURL),
+		CancelURL:  stripe.String(cancelURL),
+		Mode:       stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Quantity: stripe.Int64(req.Quantity),
+				Price:    stripe.String(req.Price),
+			},
+		},
+	}
+	if req.Customer != "" {
+		params.Customer = stripe.String(req.Customer)
+	}
+
+	price, _ := svc.CatalogFor(ctx).Price(req.Price)
+	order := NewOrder(params.LineItems, nil)
+	order.Amount, order.Currency = price.AmountFor(req.Quantity), price.Currency
+	params.SuccessURL = stripe.String(svc.WithOrderStatus(successURL, order.ID))
+	params.AddMetadata(OrderMetadataKey, order.ID)
+	if err := svc.Payments.SaveOrder(ctx, order); err != nil {
+		return nil, InternalError("saving order", err)
+	}
+
+	WithCheckoutLocale(params, CheckoutLocale(acceptLanguage, req.Locale))
+	params.ExpiresAt = stripe.Int64(time.Now().Add(svc.Config.CheckoutSessionTTL).Unix())
+	svc.WithCheckoutRecovery(params)
+	svc.WithSessionSummary(params)
+	WithTraceContext(ctx, params)
+	params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "subscription_session"))
+	s, err := svc.Stripe.NewCheckoutSession(ctx, params)
+	if err != nil {
+		svc.CancelOrder(ctx, order)
+		return nil, &StripeFailure{"creating session", err}
+	}
+	// The session exists; record it even if the client has gone away.
+	ctx = context.WithoutCancel(ctx)
+	if s.AmountTotal != 0 && s.AmountTotal != order.Amount {
+		LogCtx(ctx).Warn("session amount differs from the expected amount",
+			"session", s.ID, "order", order.ID, "amount_total", s.AmountTotal, "expected", order.Amount)
+	}
+	order.SessionID = s.ID
+	if err := svc.Payments.SaveOrder(ctx, order); err != nil {
+		LogCtx(ctx).Error("linking order", "order", order.ID, "session", s.ID, "error", err)
+	}
+	if err := svc.UpdatePaymentStatus(ctx, s); err != nil {
+		LogCtx(ctx).Error("updating payment status", "session", s.ID, "error", err)
+	}
+	svc.RecordAudit(ctx, AuditActor(ctx), "checkout.created", s.ID, nil, map[string]interface{}{
+		"mode":     string(stripe.CheckoutSessionModeSubscription),
+		"price":    req.Price,
+		"quantity": req.Quantity,
+		"order":    order.ID,
+	})
+	return &CreateCheckoutResponse{ID: s.ID, URL: s.URL, OrderID: order.ID, StatusURL: svc.OrderStatusURL(order.ID), ExpiresAt: SessionExpiry(s)}, nil
+}
+
+// SubscriptionFromStripe converts a Stripe subscription to the local record.
+func SubscriptionFromStripe(sub *stripe.Subscription) *Subscription {
+	rec := &Subscription{
+		ID:                 sub.ID,
+		Status:             string(sub.Status),
+		CurrentPeriodStart: time.Unix(sub.CurrentPeriodStart, 0).UTC(),
+		CurrentPeriodEnd:   time.Unix(sub.CurrentPeriodEnd, 0).UTC(),
+		CancelAtPeriodEnd:  sub.CancelAtPeriodEnd,
+		TrialEnd:           optionalTime(sub.TrialEnd),
+		CanceledAt:         optionalTime(sub.CanceledAt),
+	}
+	if sub.Customer != nil {
+		rec.CustomerID = sub.Customer.ID
+	}
+	if sub.Items != nil && len(sub.Items.Data) > 0 && sub.Items.Data[0].Price != nil {
+		rec.PriceID = sub.Items.Data[0].Price.ID
+	}
+	return rec
+}
+
+// optionalTime converts a Unix timestamp Stripe leaves at 0 when unset.
+func optionalTime(unix int64) *time.Time {
+	if unix == 0 {
+		return nil
+	}
+	t := time.Unix(unix, 0).UTC()
+	return &t
+}
+
+// SetInvoiceStatus records an invoice outcome on its subscription. Invoices
+// of subscriptions the store hasn't seen yet, or without one, are skipped.
+func (svc *Service) SetInvoiceStatus(ctx context.Context, subID, status string) error {
+	if subID == "" {
+		return nil
+	}
+	err := svc.Payments.SetSubscriptionInvoiceStatus(ctx, subID, status)
+	if err == ErrSubscriptionNotFound {
+		slog.Info("invoice for unknown subscription", "subscription", subID, "status", status)
+		return nil
+	}
+	return err
+}
+
+// CustomerSubscriptions is returned by GET /subscriptions/{customerID}.
+// Active is set when any subscription is active or trialing, for apps that
+// only need to know whether to unlock paid features.
+type CustomerSubscriptions struct {
+	Customer      string          `json:"customer"`
+	Active        bool            `json:"active"`
+	Subscriptions []*Subscription `json:"subscriptions"`
+}
+
+// subscriptionActive reports whether status still grants access. past_due
+// subscriptions are being retried and are left to the app to decide.
+func subscriptionActive(status string) bool {
+	return status == string(stripe.SubscriptionStatusActive) || status == string(stripe.SubscriptionStatusTrialing)
+}
+
+// ListCustomerSubscriptions returns the local copies of customer's
+// subscriptions, so gating a feature doesn't cost a Stripe API call.
+func (svc *Service) ListCustomerSubscriptions(ctx context.Context, customer string) (*CustomerSubscriptions, error) {
+	list, err := svc.Payments.ListSubscriptions(ctx, customer)
+	if err != nil {
+		return nil, InternalError("listing subscriptions", err)
+	}
+	resp := &CustomerSubscriptions{Customer: customer, Subscriptions: list}
+	if resp.Subscriptions == nil {
+		resp.Subscriptions = []*Subscription{}
+	}
+	for _, sub := range list {
+		if subscriptionActive(sub.Status) {
+			resp.Active = true
+		}
+	}
+	return resp, nil
+}
+
+// SubscriptionChangeRequest is the body accepted by
+// /subscriptions/{id}/change. ProrationBehavior defaults to
+// SUBSCRIPTION_PRORATION_BEHAVIOR and ProrationDate, a Unix time, to now.
+// Without Confirm the change is only previewed; to be charged what the
+// preview showed, confirm with the prorationDate it returned.
+type SubscriptionChangeRequest struct {
+	Price             string `json:"price"`
+	ProrationBehavior string `json:"prorationBehavior"`
+	ProrationDate     int64  `json:"prorationDate"`
+	Confirm           bool   `json:"confirm"`
+}
+
+func (s *SubscriptionChangeRequest) Validate(ctx context.Context, svc *Service) error {
+	p, ok := svc.CatalogFor(ctx).Price(s.Price)
+	if !ok {
+		return fmt.Errorf("unknown price %q", s.Price)
+	}
+	if p.Recurring == nil {
+		return fmt.Errorf("price %q is not recurring", s.Price)
+	}
+	if s.ProrationBehavior == "" {
+		s.ProrationBehavior = svc.Config.SubscriptionProrationBehavior
+	}
+	if !config.ValidProrationBehavior(s.ProrationBehavior) {
+		return fmt.Errorf("prorationBehavior must be create_prorations, always_invoice or none, not %q", s.ProrationBehavior)
+	}
+	if s.ProrationDate < 0 {
+		return errors.New("prorationDate must be a Unix time")
+	}
+	return nil
+}
+
+// SubscriptionChange is the response of /subscriptions/{id}/change.
+// ProratedAmount is the net of the credit for the unused time on the current
+// price and the charge for the rest of the period on the new one; AmountDue
+// is the total of the next invoice with the change applied, which Stripe
+// charges straight away when ProrationBehavior is always_invoice.
+// Subscription is the updated local record once the change is confirmed.
+type SubscriptionChange struct {
+	SubscriptionID    string        `json:"subscriptionId"`
+	CurrentPrice      string        `json:"currentPrice"`
+	NewPrice          string        `json:"newPrice"`
+	ProrationBehavior string        `json:"prorationBehavior"`
+	ProrationDate     int64         `json:"prorationDate"`
+	ProratedAmount    int64         `json:"proratedAmount"`
+	AmountDue         int64         `json:"amountDue"`
+	Currency          string        `json:"currency"`
+	Confirmed         bool          `json:"confirmed"`
+	Subscription      *Subscription `json:"subscription,omitempty"`
+}
+
+// ChangeSubscription previews moving subscription id to another price with
+// Stripe's upcoming invoice and, once req is confirmed, makes the change and
+// stores it. Only subscriptions with a single price can be changed.
+func (svc *Service) ChangeSubscription(ctx context.Context, id string, req *SubscriptionChangeRequest) (*SubscriptionChange, error) {
+	defer svc.subscriptionLocks.Lock(id)()
+	sub, err := svc.Stripe.GetSubscription(ctx, id, nil)
+	if err != nil {
+		return nil, &StripeFailure{"fetching subscription", err}
+	}
+	switch sub.Status {
+	case stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusIncompleteExpired:
+		return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("subscription is %s", sub.Status)}
+	}
+	if sub.Items == nil || len(sub.Items.Data) != 1 || sub.Items.Data[0].Price == nil {
+		return nil, &Error{Status: http.StatusConflict, Message: "only subscriptions with a single price can be changed"}
+	}
+	item := sub.Items.Data[0]
+	if item.Price.ID == req.Price {
+		return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("subscription is already on price %q", req.Price)}
+	}
+	if p, _ := svc.CatalogFor(ctx).Price(req.Price); !strings.EqualFold(p.Currency, string(item.Price.Currency)) {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("price %q is not in the subscription's currency, %s", req.Price, item.Price.Currency)}
+	}
+	if req.ProrationDate == 0 {
+		req.ProrationDate = time.Now().Unix()
+	}
+	if req.ProrationDate < sub.CurrentPeriodStart || req.ProrationDate > sub.CurrentPeriodEnd {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "prorationDate must be within the current billing period"}
+	}
+	items := []*stripe.SubscriptionItemsParams{{
+		ID:    stripe.String(item.ID),
+		Price: stripe.String(req.Price),
+		// Stripe resets the quantity to 1 unless it is given.
+		Quantity: stripe.Int64(item.Quantity),
+	}}
+
+	preview := &stripe.InvoiceParams{
+		Customer:                      stripe.String(sub.Customer.ID),
+		Subscription:                  stripe.String(id),
+		SubscriptionItems:             items,
+		SubscriptionProrationBehavior: stripe.String(req.ProrationBehavior),
+		SubscriptionProrationDate:     stripe.Int64(req.ProrationDate),
+	}
+	upcoming, err := svc.Stripe.GetUpcomingInvoice(ctx, preview)
+	if err != nil {
+		return nil, &StripeFailure{"previewing subscription change", err}
+	}
+	change := &SubscriptionChange{
+		SubscriptionID:    id,
+		CurrentPrice:      item.Price.ID,
+		NewPrice:          req.Price,
+		ProrationBehavior: req.ProrationBehavior,
+		ProrationDate:     req.ProrationDate,
+		AmountDue:         upcoming.AmountDue,
+		Currency:          string(upcoming.Currency),
+	}
+	if upcoming.Lines != nil {
+		for _, line := range upcoming.Lines.Data {
+			if line.Proration {
+				change.ProratedAmount += line.Amount
+			}
+		}
+	}
+	if !req.Confirm {
+		return change, nil
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items:             items,
+		ProrationBehavior: stripe.String(req.ProrationBehavior),
+		ProrationDate:     stripe.Int64(req.ProrationDate),
+	}
+	params.SetIdempotencyKey(StripeIdempotencyKey(ctx, "change_subscription"))
+	updated, err := svc.Stripe.UpdateSubscription(ctx, id, params)
+	if err != nil {
+		return nil, &StripeFailure{"changing subscription price", err}
+	}
+	ctx = context.WithoutCancel(ctx)
+	before, err := svc.Payments.GetSubscription(ctx, id)
+	if err != nil && err != ErrSubscriptionNotFound {
+		return nil, InternalError("fetching subscription", err)
+	}
+	if err := svc.Payments.SaveSubscription(ctx, SubscriptionFromStripe(updated)); err != nil {
+		return nil, InternalError("saving subscription", err)
+	}
+	if change.Subscription, err = svc.Payments.GetSubscription(ctx, id); err != nil {
+		return nil, InternalError("fetching subscription", err)
+	}
+	change.Confirmed = true
+	LogCtx(ctx).Info("subscription price changed",
+		"subscription", id,
+		"from", change.CurrentPrice,
+		"to", change.NewPrice,
+		"proration_behavior", change.ProrationBehavior,
+		"prorated_amount", change.ProratedAmount,
+	)
+	svc.RecordAudit(ctx, AuditActor(ctx), "subscription.price_changed", id, before, change.Subscription)
+	return change, nil
+}
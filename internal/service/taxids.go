@@ -0,0 +1,147 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// The verification states of a TaxID.
+const (
+	TaxIDPending    = "pending"
+	TaxIDValid      = "valid"
+	TaxIDInvalid    = "invalid"
+	TaxIDUnverified = "unverified"
+)
+
+// viesHTTPClient calls the VIES VAT number check.
+var viesHTTPClient = &http.Client{Timeout: 20 * time.Second}
+
+// SessionTaxIDs returns the tax IDs the customer entered in s, pending a
+// VIES check if they are EU VAT numbers and VIES_VALIDATION is set.
+func (svc *Service) SessionTaxIDs(s *stripe.CheckoutSession) []*TaxID {
+	if s.CustomerDetails == nil {
+		return nil
+	}
+	var ids []*TaxID
+	for _, t := range s.CustomerDetails.TaxIDs {
+		if t == nil || t.Value == "" {
+			continue
+		}
+		id := &TaxID{SessionID: s.ID, Type: string(t.Type), Value: t.Value, Verification: TaxIDUnverified}
+		if svc.Config.VIESValidation && t.Type == stripe.CheckoutSessionCustomerDetailsTaxIDsTypeEUVAT {
+			id.Verification = TaxIDPending
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// verifyTaxIDs checks the pending EU VAT numbers of a session against VIES.
+// VIES being unavailable for a member state is an error, so the job is
+// retried later.
+func (svc *Service) verifyTaxIDs(ctx context.Context, sessionID string) error {
+	ids, err := svc.Payments.ListTaxIDs(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id.Verification != TaxIDPending {
+			continue
+		}
+		valid, name, err := svc.checkVATNumber(ctx, id.Value)
+		if err != nil {
+			return fmt.Errorf("checking %s: %w", id.Value, err)
+		}
+		now := time.Now().UTC()
+		id.Verification, id.VerifiedName, id.CheckedAt = TaxIDInvalid, name, &now
+		if valid {
+			id.Verification = TaxIDValid
+		}
+		if err := svc.Payments.UpdateTaxIDVerification(ctx, id); err != nil {
+			return err
+		}
+		slog.Info("VAT number checked", "session", sessionID, "vat_number", id.Value, "verification", id.Verification)
+		if !valid {
+			err := svc.NotifyOps(ctx, config.NotifyTaxIDInvalid, "Invalid VAT number: "+id.Value,
+				fmt.Sprintf("VIES doesn't know the VAT number %s entered for checkout session %s.", id.Value, sessionID),
+				"Check the order before treating it as a business sale.")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkVATNumber asks VIES whether an EU VAT number such as DE123456789 is
+// valid, and for the name registered with it when the member state shares
+// it.
+func (svc *Service) checkVATNumber(ctx context.Context, vatNumber string) (bool, string, error) {
+	number := strings.ToUpper(strings.NewReplacer(" ", "", ".", "", "-", "").Replace(vatNumber))
+	if len(number) < 3 {
+		return false, "", nil
+	}
+	body, err := json.Marshal(map[string]string{"countryCode": number[:2], "vatNumber": number[2:]})
+	if err != nil {
+		return false, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", svc.Config.VIESURL, bytes.NewReader(body))
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := viesHTTPClient.Do(req)
+	if err != nil {
+		return false, "", fmt.Errorf("calling VIES: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return false, "", fmt.Errorf("reading VIES response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("VIES answered %s: %s", resp.Status, data)
+	}
+	var result struct {
+		Valid     bool   `json:"valid"`
+		Name      string `json:"name"`
+		UserError string `json:"userError"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return false, "", fmt.Errorf("parsing VIES response: %w", err)
+	}
+	// Anything but VALID or INVALID means the member state's service
+	// couldn't answer, e.g. MS_UNAVAILABLE or TIMEOUT.
+	switch result.UserError {
+	case "", "VALID", "INVALID":
+	default:
+		return false, "", fmt.Errorf("VIES: %s", result.UserError)
+	}
+	// Member states that don't share names answer "---".
+	if strings.Trim(result.Name, "- ") == "" {
+		result.Name = ""
+	}
+	return result.Valid, result.Name, nil
+}
+
+// receiptTaxIDs formats the tax IDs a session collected for its receipts,
+// e.g. "DE123456789".
+func (svc *Service) receiptTaxIDs(s *stripe.CheckoutSession) []string {
+	var list []string
+	for _, id := range svc.SessionTaxIDs(s) {
+		list = append(list, id.Value)
+	}
+	return list
+}
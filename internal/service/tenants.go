@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/stripeclient"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns ctx carrying t; a nil t leaves ctx on the default
+// account.
+func WithTenant(ctx context.Context, t *config.Tenant) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// TenantFrom returns the tenant of ctx, or nil for the default account.
+func TenantFrom(ctx context.Context) *config.Tenant {
+	t, _ := ctx.Value(tenantKey{}).(*config.Tenant)
+	return t
+}
+
+// TenantByID returns the configured tenant with the ID, or nil.
+func (svc *Service) TenantByID(id string) *config.Tenant {
+	if id == "" || svc.Config == nil {
+		return nil
+	}
+	for _, t := range svc.Config.Tenants {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// TenantByAccount returns the tenant whose Stripe account is id, or nil.
+func (svc *Service) TenantByAccount(id string) *config.Tenant {
+	if id == "" {
+		return nil
+	}
+	for _, t := range svc.Config.Tenants {
+		if t.AccountID == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// CatalogFor returns the catalog of ctx's tenant, or of the connected
+// account it acts on once that has been loaded.
+func (svc *Service) CatalogFor(ctx context.Context) *Catalog {
+	if t := TenantFrom(ctx); t != nil {
+		return svc.TenantCatalogs[t.ID]
+	}
+	if id := stripeclient.AccountFrom(ctx); id != "" {
+		if c := svc.LoadedAccountCatalog(id); c != nil {
+			return c
+		}
+	}
+	return svc.Catalog
+}
+
+// DefaultPrice returns PRICE, or the tenant's own default price.
+func (svc *Service) DefaultPrice(ctx context.Context) string {
+	if t := TenantFrom(ctx); t != nil {
+		return t.Price
+	}
+	return svc.ConfiguredPrice()
+}
+
+// WebhookSecrets returns the signing secrets of ctx's tenant's webhook
+// endpoint.
+func (svc *Service) WebhookSecrets(ctx context.Context) []string {
+	if t := TenantFrom(ctx); t != nil {
+		return t.WebhookSecrets
+	}
+	svc.secretsMu.RLock()
+	defer svc.secretsMu.RUnlock()
+	return svc.Config.WebhookSecrets
+}
+
+// StripeKey is the secret key a Stripe call is made with: that of the tenant
+// its params' context carries, or the default account's current key.
+func (svc *Service) StripeKey(params *stripe.Params, key string) string {
+	if params != nil && params.Context != nil {
+		if t := TenantFrom(params.Context); t != nil {
+			return t.SecretKey
+		}
+	}
+	return svc.defaultStripeKey(key)
+}
+
+// LoadTenantCatalogs loads every tenant's catalog from its Stripe account
+// and checks its default price is in it.
+func (svc *Service) LoadTenantCatalogs(ctx context.Context) error {
+	for _, t := range svc.Config.Tenants {
+		c := svc.TenantCatalogs[t.ID]
+		if c == nil {
+			c = NewCatalog()
+			svc.TenantCatalogs[t.ID] = c
+		}
+		if err := c.Load(WithTenant(ctx, t), svc.Stripe); err != nil {
+			return fmt.Errorf("loading catalog of tenant %s: %w", t.ID, err)
+		}
+		if _, ok := c.Price(t.Price); !ok {
+			return fmt.Errorf("tenant %s: price %s is not an active price of an active product", t.ID, t.Price)
+		}
+	}
+	return nil
+}
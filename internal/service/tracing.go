@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+
+	"github.com/stripe/stripe-go/v72"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"stripe_go/internal/stripeclient"
+)
+
+// TraceMetadataKey is the Stripe metadata key holding the W3C traceparent
+// of the request that created a checkout session or payment intent, so the
+// webhooks Stripe sends about it continue the same trace.
+const TraceMetadataKey = "traceparent"
+
+// TracePropagator reads and writes the W3C traceparent and tracestate
+// headers, on HTTP requests as well as in job and Stripe metadata.
+var TracePropagator = propagation.TraceContext{}
+
+// traceParent is the W3C traceparent of the span in ctx, or "" if there is
+// none.
+func traceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	TracePropagator.Inject(ctx, carrier)
+	return carrier[TraceMetadataKey]
+}
+
+// AddTraceMetadata records the trace of ctx in the metadata of the Stripe
+// object params creates. A request made with an Idempotency-Key is left
+// alone: its retry would run in a new trace, and Stripe rejects a retried
+// write whose params differ from the first.
+func AddTraceMetadata(ctx context.Context, params *stripe.Params) {
+	if key, _ := ctx.Value(idempotencyKeyCtx{}).(string); key != "" {
+		return
+	}
+	if tp := traceParent(ctx); tp != "" {
+		params.AddMetadata(TraceMetadataKey, tp)
+	}
+}
+
+// WithTraceContext is AddTraceMetadata for a checkout session, also
+// recording the trace on the payment intent it creates.
+func WithTraceContext(ctx context.Context, params *stripe.CheckoutSessionParams) {
+	AddTraceMetadata(ctx, &params.Params)
+	tp, ok := params.Metadata[TraceMetadataKey]
+	if !ok || params.PaymentIntentData == nil {
+		return
+	}
+	if params.PaymentIntentData.Metadata == nil {
+		params.PaymentIntentData.Metadata = map[string]string{}
+	}
+	params.PaymentIntentData.Metadata[TraceMetadataKey] = tp
+}
+
+// eventSpan starts the span processing a Stripe event. It continues the
+// trace recorded in the metadata of the event's object, if any, so a paid
+// checkout shows up in the trace of the request that created it; the span
+// of the webhook delivery or poll that brought the event is linked.
+func eventSpan(ctx context.Context, event stripe.Event) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("stripe.event.id", event.ID), attribute.String("stripe.event.type", event.Type)),
+	}
+	if event.Data != nil {
+		// GetObjectValue panics on objects without metadata.
+		metadata, _ := event.Data.Object["metadata"].(map[string]interface{})
+		if tp, _ := metadata[TraceMetadataKey].(string); tp != "" {
+			parent := TracePropagator.Extract(context.Background(), propagation.MapCarrier{TraceMetadataKey: tp})
+			if sc := trace.SpanContextFromContext(parent); sc.IsValid() {
+				opts = append(opts, trace.WithLinks(trace.LinkFromContext(ctx)))
+				ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+			}
+		}
+	}
+	return stripeclient.StartSpan(ctx, "stripe.event "+event.Type, opts...)
+}
+
+// jobTraceContext is the trace context of ctx to store with a job, or nil.
+func jobTraceContext(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	TracePropagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// jobSpan starts the span of one attempt at job, in the trace it was queued
+// in.
+func jobSpan(ctx context.Context, job *Job) (context.Context, trace.Span) {
+	if job.TraceContext != nil {
+		ctx = TracePropagator.Extract(ctx, propagation.MapCarrier(job.TraceContext))
+	}
+	return stripeclient.StartSpan(ctx, "job "+job.Type, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		attribute.String("job.id", job.ID),
+		attribute.Int("job.attempt", job.Attempts+1),
+	))
+}
+
+// TracedEmailSender records every email sent as a span. The recipient
+// isn't recorded.
+type TracedEmailSender struct {
+	EmailSender
+	Backend string
+}
+
+func (s TracedEmailSender) Send(ctx context.Context, msg *EmailMessage) (err error) {
+	ctx, span := stripeclient.StartSpan(ctx, "email.send", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("email.backend", s.Backend),
+		attribute.Int("email.attachments", len(msg.Attachments)),
+	))
+	defer func() { stripeclient.EndSpan(span, err) }()
+	return s.EmailSender.Send(ctx, msg)
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+)
+
+// MaxUsageBatch is how many usage records one POST /usage-records takes.
+const MaxUsageBatch = 100
+
+// Usage record statuses. Records are reported to Stripe in the background:
+// pending until Stripe takes them, or failed if it refuses them.
+const (
+	UsagePending  = "pending"
+	UsageReported = "reported"
+	UsageFailed   = "failed"
+)
+
+// UsageRecord is usage of a metered subscription item, kept locally until
+// it is reported to Stripe and afterwards to reconcile against Stripe's
+// totals. Action is "increment", which adds Quantity to the usage at
+// Timestamp, or "set", which replaces it.
+type UsageRecord struct {
+	ID               string     `json:"id"`
+	SubscriptionItem string     `json:"subscriptionItem"`
+	Quantity         int64      `json:"quantity"`
+	Action           string     `json:"action"`
+	Timestamp        time.Time  `json:"timestamp"`
+	Status           string     `json:"status"`
+	StripeID         string     `json:"stripeId,omitempty"`
+	Attempts         int        `json:"attempts"`
+	LastError        string     `json:"lastError,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	ReportedAt       *time.Time `json:"reportedAt,omitempty"`
+}
+
+// UsageRecordInput is one record of a UsageRecordRequest. Timestamp is a
+// Unix time, now if left out. Records sent again with the same
+// IdempotencyKey for the same item aren't counted twice.
+type UsageRecordInput struct {
+	SubscriptionItem string `json:"subscriptionItem"`
+	Quantity         int64  `json:"quantity"`
+	Timestamp        int64  `json:"timestamp"`
+	Action           string `json:"action"`
+	IdempotencyKey   string `json:"idempotencyKey"`
+}
+
+// UsageRecordRequest is the body accepted by POST /usage-records.
+type UsageRecordRequest struct {
+	Records []*UsageRecordInput `json:"records"`
+}
+
+func (u *UsageRecordRequest) Validate(ctx context.Context, svc *Service) error {
+	if len(u.Records) == 0 || len(u.Records) > MaxUsageBatch {
+		return fmt.Errorf("records must have between 1 and %d entries", MaxUsageBatch)
+	}
+	now := time.Now().Unix()
+	for i, rec := range u.Records {
+		if rec == nil {
+			return fmt.Errorf("records[%d]: missing", i)
+		}
+		if err := ValidateStripeID(rec.SubscriptionItem, "si_", "subscription item"); err != nil {
+			return fmt.Errorf("records[%d]: %w", i, err)
+		}
+		if rec.Quantity < 0 {
+			return fmt.Errorf("records[%d]: quantity must not be negative", i)
+		}
+		switch rec.Action {
+		case "":
+			rec.Action = stripe.UsageRecordActionIncrement
+		case stripe.UsageRecordActionIncrement, stripe.UsageRecordActionSet:
+		default:
+			return fmt.Errorf("records[%d]: action must be increment or set, not %q", i, rec.Action)
+		}
+		if rec.Timestamp < 0 || rec.Timestamp > now {
+			return fmt.Errorf("records[%d]: timestamp must be a Unix time that isn't in the future", i)
+		}
+		if rec.Timestamp == 0 {
+			// Fixed now, so a retried report counts the same moment.
+			rec.Timestamp = now
+		}
+		if len(rec.IdempotencyKey) > MaxIdempotencyKeyLength {
+			return fmt.Errorf("records[%d]: idempotencyKey is too long", i)
+		}
+	}
+	return nil
+}
+
+// usageRecordID is the ID of a record, derived from its idempotency key so
+// a record sent again maps to the one already stored.
+func usageRecordID(rec *UsageRecordInput) string {
+	if rec.IdempotencyKey == "" {
+		return "ur_" + NewRequestID()
+	}
+	return "ur_" + config.SHA256Hex(rec.SubscriptionItem + "\x00" + rec.IdempotencyKey)[:32]
+}
+
+// AddUsageRecords stores the records of req and queues one job to report
+// the new ones. Records already stored are returned as they are.
+func (svc *Service) AddUsageRecords(ctx context.Context, req *UsageRecordRequest) ([]*UsageRecord, error) {
+	var records []*UsageRecord
+	var added []string
+	for _, in := range req.Records {
+		rec := &UsageRecord{
+			ID:               usageRecordID(in),
+			SubscriptionItem: in.SubscriptionItem,
+			Quantity:         in.Quantity,
+			Action:           in.Action,
+			Timestamp:        time.Unix(in.Timestamp, 0).UTC(),
+			Status:           UsagePending,
+		}
+		ok, err := svc.Payments.AddUsageRecord(ctx, rec)
+		if err != nil {
+			return nil, InternalError("saving usage record", err)
+		}
+		if !ok {
+			if rec, err = svc.Payments.GetUsageRecord(ctx, rec.ID); err != nil {
+				return nil, InternalError("fetching usage record", err)
+			}
+		} else {
+			added = append(added, rec.ID)
+		}
+		records = append(records, rec)
+	}
+	if len(added) > 0 {
+		if err := svc.Jobs.Enqueue(ctx, JobReportUsage, added); err != nil {
+			return nil, InternalError("queueing usage report", err)
+		}
+	}
+	return records, nil
+}
+
+// reportUsage sends the pending records among ids to Stripe. Records Stripe
+// refuses are marked failed and not retried; any other failure is returned,
+// so the job retries the records still pending.
+func (svc *Service) reportUsage(ctx context.Context, ids []string) error {
+	var errs []error
+	for _, id := range ids {
+		if err := svc.reportUsageRecord(ctx, id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (svc *Service) reportUsageRecord(ctx context.Context, id string) error {
+	defer svc.usageLocks.Lock(id)()
+	rec, err := svc.Payments.GetUsageRecord(ctx, id)
+	if err != nil {
+		return fmt.Errorf("fetching usage record %s: %w", id, err)
+	}
+	if rec.Status != UsagePending {
+		return nil
+	}
+	params := &stripe.UsageRecordParams{
+		SubscriptionItem: stripe.String(rec.SubscriptionItem),
+		Quantity:         stripe.Int64(rec.Quantity),
+		Timestamp:        stripe.Int64(rec.Timestamp.Unix()),
+		Action:           stripe.String(rec.Action),
+	}
+	params.SetIdempotencyKey("usage_record:" + rec.ID)
+	ur, err := svc.Stripe.NewUsageRecord(ctx, params)
+	rec.Attempts++
+	var se *stripe.Error
+	switch {
+	case err == nil:
+		now := time.Now().UTC()
+		rec.Status, rec.StripeID, rec.LastError, rec.ReportedAt = UsageReported, ur.ID, "", &now
+		slog.Info("usage reported", "usage_record", rec.ID, "subscription_item", rec.SubscriptionItem, "quantity", rec.Quantity)
+	case errors.As(err, &se) && (se.HTTPStatusCode == http.StatusBadRequest || se.HTTPStatusCode == http.StatusNotFound):
+		rec.Status, rec.LastError = UsageFailed, se.Msg
+		slog.Warn("usage rejected", "usage_record", rec.ID, "subscription_item", rec.SubscriptionItem, "error", se.Msg)
+	default:
+		rec.LastError = err.Error()
+	}
+	if uerr := svc.Payments.UpdateUsageRecord(context.WithoutCancel(ctx), rec); uerr != nil {
+		return fmt.Errorf("saving usage record %s: %w", id, uerr)
+	}
+	if rec.Status == UsagePending {
+		return fmt.Errorf("reporting usage record %s: %w", id, err)
+	}
+	return nil
+}
+
+// UsagePeriod compares one billing period of a subscription item: the
+// total Stripe summarized and the total of the records reported from here.
+type UsagePeriod struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Invoice     string    `json:"invoice,omitempty"`
+	StripeTotal int64     `json:"stripeTotal"`
+	LocalTotal  int64     `json:"localTotal"`
+	Difference  int64     `json:"difference"`
+}
+
+// UsageReconciliation is the response of /usage-records/reconcile. Pending
+// and Failed count the item's records that haven't reached Stripe. Matched
+// is set when every period agrees.
+type UsageReconciliation struct {
+	SubscriptionItem string         `json:"subscriptionItem"`
+	Periods          []*UsagePeriod `json:"periods"`
+	Pending          int            `json:"pending"`
+	Failed           int            `json:"failed"`
+	Matched          bool           `json:"matched"`
+}
+
+// usageTotal adds up records the way Stripe does for prices that sum their
+// usage: increments add to the usage at their timestamp and sets replace
+// it, in the order the records were made.
+func usageTotal(records []*UsageRecord) int64 {
+	at := map[int64]int64{}
+	for _, rec := range records {
+		if rec.Action == stripe.UsageRecordActionSet {
+			at[rec.Timestamp.Unix()] = rec.Quantity
+		} else {
+			at[rec.Timestamp.Unix()] += rec.Quantity
+		}
+	}
+	var total int64
+	for _, q := range at {
+		total += q
+	}
+	return total
+}
+
+// ReconcileUsage compares the usage Stripe summarized for each billing
+// period of item with the records reported for it.
+func (svc *Service) ReconcileUsage(ctx context.Context, item string) (*UsageReconciliation, error) {
+	params := &stripe.UsageRecordSummaryListParams{SubscriptionItem: stripe.String(item)}
+	summaries, err := svc.Stripe.ListUsageRecordSummaries(ctx, params)
+	if err != nil {
+		return nil, &StripeFailure{"listing usage summaries", err}
+	}
+	records, err := svc.Payments.ListUsageRecords(ctx, UsageRecordFilter{SubscriptionItem: item})
+	if err != nil {
+		return nil, InternalError("listing usage records", err)
+	}
+	rec := &UsageReconciliation{SubscriptionItem: item, Periods: []*UsagePeriod{}, Matched: true}
+	var reported []*UsageRecord
+	for _, u := range records {
+		switch u.Status {
+		case UsagePending:
+			rec.Pending++
+		case UsageFailed:
+			rec.Failed++
+		case UsageReported:
+			reported = append(reported, u)
+		}
+	}
+	for _, s := range summaries {
+		p := &UsagePeriod{Invoice: s.Invoice, StripeTotal: s.TotalUsage}
+		var in []*UsageRecord
+		if s.Period != nil {
+			p.Start, p.End = time.Unix(s.Period.Start, 0).UTC(), time.Unix(s.Period.End, 0).UTC()
+			for _, u := range reported {
+				if ts := u.Timestamp.Unix(); ts >= s.Period.Start && (s.Period.End == 0 || ts < s.Period.End) {
+					in = append(in, u)
+				}
+			}
+		}
+		p.LocalTotal = usageTotal(in)
+		p.Difference = p.StripeTotal - p.LocalTotal
+		if p.Difference != 0 {
+			rec.Matched = false
+		}
+		rec.Periods = append(rec.Periods, p)
+	}
+	return rec, nil
+}
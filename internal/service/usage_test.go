@@ -0,0 +1,19 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageTotal(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	records := []*UsageRecord{
+		{Quantity: 5, Action: "increment", Timestamp: at},
+		{Quantity: 3, Action: "increment", Timestamp: at},
+		{Quantity: 2, Action: "set", Timestamp: at},
+		{Quantity: 4, Action: "increment", Timestamp: at.Add(time.Minute)},
+	}
+	if got := usageTotal(records); got != 6 {
+		t.Errorf("total = %d, want 6", got)
+	}
+}
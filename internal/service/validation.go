@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"stripe_go/internal/config"
+)
+
+var (
+	SessionIDPattern = regexp.MustCompile(`^cs_(test|live)_[A-Za-z0-9]+$`)
+	stripeIDPattern  = regexp.MustCompile(`^[a-z]+_[A-Za-z0-9_]+$`)
+)
+
+// ValidateStripeID checks that id looks like a Stripe object ID with the given
+// prefix, e.g. "cus_" or "pi_".
+func ValidateStripeID(id, prefix, field string) error {
+	if !strings.HasPrefix(id, prefix) || !stripeIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid %s %q", field, id)
+	}
+	return nil
+}
+
+// validateMetadata applies Stripe's metadata limits up front so bad input is
+// reported as a 400 rather than a Stripe error.
+func validateMetadata(m map[string]string) error {
+	if len(m) > 50 {
+		return errors.New("metadata can have at most 50 keys")
+	}
+	for k, v := range m {
+		if k == "" || len(k) > 40 || strings.ContainsAny(k, "[]") {
+			return fmt.Errorf("invalid metadata key %q", k)
+		}
+		if len(v) > 500 {
+			return fmt.Errorf("metadata %q: value is longer than 500 characters", k)
+		}
+	}
+	return nil
+}
+
+// validatePaymentMethodTypes checks requested payment method types. When
+// PAYMENT_METHOD_TYPES is set, requests can only narrow it down.
+func (svc *Service) validatePaymentMethodTypes(types []string) error {
+	for _, t := range types {
+		if !config.PaymentMethodTypes[t] {
+			return fmt.Errorf("unknown payment method type %q", t)
+		}
+		if len(svc.Config.PaymentMethodTypes) > 0 && !slices.Contains(svc.Config.PaymentMethodTypes, t) {
+			return fmt.Errorf("payment method type %q is not enabled", t)
+		}
+	}
+	return nil
+}
+
+func (svc *Service) validateQuantity(quantity int64) error {
+	if quantity < 1 || quantity > svc.Config.MaxQuantity {
+		return fmt.Errorf("quantity must be between 1 and %d", svc.Config.MaxQuantity)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/stripeclient"
+)
+
+// WebhookHandlerFunc handles a single verified Stripe event. Returning an
+// error makes the webhook respond with a 500 so Stripe retries the delivery.
+type WebhookHandlerFunc func(ctx context.Context, event stripe.Event) error
+
+// WebhookRouter dispatches Stripe events to the handlers registered for their
+// type.
+type WebhookRouter struct {
+	handlers map[string][]WebhookHandlerFunc
+	// paymentLocks serializes the updates of each payment, so the webhook
+	// handlers and jobs of one payment read and save its state one at a
+	// time and each transition sees the one before it. They only cover this
+	// process; instances sharing a database still rely on the payment and
+	// order state machines to ignore transitions that arrive late.
+	paymentLocks keyedMutex
+}
+
+func NewWebhookRouter() *WebhookRouter {
+	return &WebhookRouter{handlers: map[string][]WebhookHandlerFunc{}}
+}
+
+// On registers fn for eventType. Several handlers can be registered for the
+// same type; they run in registration order and stop at the first error.
+func (wr *WebhookRouter) On(eventType string, fn WebhookHandlerFunc) {
+	wr.handlers[eventType] = append(wr.handlers[eventType], fn)
+}
+
+// Dispatch runs the handlers registered for event.Type. Events nobody handles
+// are logged and acknowledged. Events of the same payment are handled one at
+// a time, while unrelated events are handled in parallel.
+func (wr *WebhookRouter) Dispatch(ctx context.Context, event stripe.Event) error {
+	handlers, ok := wr.handlers[event.Type]
+	if !ok {
+		slog.Info("received unhandled event", "event", event.ID, "type", event.Type)
+		return nil
+	}
+	if key := eventLockKey(event); key != "" {
+		defer wr.paymentLocks.Lock(key)()
+	}
+	for _, fn := range handlers {
+		if err := fn(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EventTypes returns the event types that have handlers, sorted.
+func (wr *WebhookRouter) EventTypes() []string {
+	types := make([]string, 0, len(wr.handlers))
+	for t := range wr.handlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// WebhookResponse acknowledges an event. Received is its type; Duplicate is
+// set instead when the event was already processed.
+type WebhookResponse struct {
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Received  string `json:"received,omitempty"`
+	Success   bool   `json:"success"`
+}
+
+// ErrClaimEvent wraps failures to claim an event, which are the event
+// store's fault rather than the handlers'.
+var ErrClaimEvent = errors.New("claiming webhook event")
+
+// ProcessEvent runs the handlers of a verified event once, however often it
+// is delivered, and reports whether it was a duplicate. The event is only
+// claimed for a short lease while the handlers run and is marked processed
+// once they succeed. A failed event is released so the next delivery runs it
+// again; the jobs its handlers queued before failing are named after the
+// event and aren't queued twice. payload is the body Stripe signed, which
+// forwards pass on unchanged, or nil for events that came without one.
+func (svc *Service) ProcessEvent(ctx context.Context, event stripe.Event, payload []byte) (duplicate bool, err error) {
+	ctx, span := eventSpan(ctx, event)
+	defer func() { stripeclient.EndSpan(span, err) }()
+	claimed, err := svc.Events.Claim(ctx, event.ID)
+	if err != nil {
+		LogCtx(ctx).Error("claiming webhook event", "event", event.ID, "error", err)
+		return false, fmt.Errorf("%w: %v", ErrClaimEvent, err)
+	}
+	if !claimed {
+		LogCtx(ctx).Info("skipping duplicate event", "event", event.ID, "type", event.Type)
+		return true, nil
+	}
+
+	ctx = withEventJobs(ctx, event.ID)
+	if err := svc.WebhookRouter.Dispatch(ctx, event); err != nil {
+		LogCtx(ctx).Error("handling webhook event", "event", event.ID, "type", event.Type, "error", err)
+		if err := svc.Events.Release(context.WithoutCancel(ctx), event.ID); err != nil {
+			LogCtx(ctx).Error("releasing webhook event", "event", event.ID, "error", err)
+		}
+		return false, err
+	}
+	if err := svc.Events.Complete(context.WithoutCancel(ctx), event.ID); err != nil {
+		// The lease still holds off retries for a while.
+		LogCtx(ctx).Error("completing webhook event", "event", event.ID, "error", err)
+	}
+	// The handlers are done, so the event stays processed whatever happens
+	// to the forwards.
+	if err := svc.queueEventForward(ctx, event, payload); err != nil {
+		LogCtx(ctx).Error("queueing webhook event forward", "event", event.ID, "type", event.Type, "error", err)
+	}
+	var object string
+	if event.Data != nil {
+		object = event.GetObjectValue("id")
+	}
+	svc.RecordAudit(ctx, actorStripe, "webhook."+event.Type, object, nil, map[string]string{"event": event.ID})
+	return false, nil
+}
+
+// QueueConfirmationEmail queues the confirmation email of a paid session,
+// unless RECEIPTS leaves receipts to Stripe and Stripe emails its own.
+func (svc *Service) QueueConfirmationEmail(ctx context.Context, s *stripe.CheckoutSession) error {
+	receipt := svc.SessionReceipt(ctx, s)
+	if !svc.customReceipts(s, receipt) {
+		slog.Info("receipt left to Stripe", "session", s.ID)
+		return nil
+	}
+	return svc.Jobs.Enqueue(ctx, JobSendConfirmationEmail, receipt)
+}
+
+// SessionReceipt builds the confirmation email for a paid session.
+func (svc *Service) SessionReceipt(ctx context.Context, s *stripe.CheckoutSession) *Receipt {
+	receipt := &Receipt{
+		Metadata:      s.Metadata,
+		PaymentStatus: string(s.PaymentStatus),
+		Amount:        s.AmountTotal,
+		Currency:      string(s.Currency),
+		DownloadURL:   svc.ReceiptURL(s.ID),
+		Locale:        string(s.Locale),
+	}
+	if s.PaymentIntent != nil {
+		receipt.PaymentIntentID = s.PaymentIntent.ID
+	}
+	if s.CustomerDetails != nil {
+		receipt.Email = s.CustomerDetails.Email
+	}
+	receipt.TaxIDs = svc.receiptTaxIDs(s)
+	o, err := svc.SessionOrder(ctx, s)
+	if err != nil && err != ErrOrderNotFound {
+		slog.Warn("loading order for receipt", "session", s.ID, "error", err)
+	}
+	if o != nil {
+		// An email captured before checkout is the one the customer asked
+		// to be contacted at.
+		if o.Email != "" {
+			receipt.Email = o.Email
+		}
+		receipt.Items = svc.orderReceiptItems(ctx, o, receipt.Currency)
+		receipt.StatusURL = svc.OrderStatusURL(o.ID)
+		receipt.Licenses = svc.orderLicenses(ctx, o)
+	}
+	return receipt
+}
+
+// orderReceiptItems names the order's items from the catalog and prices
+// them in currency. Tiered prices are only tiered in their default currency.
+func (svc *Service) orderReceiptItems(ctx context.Context, o *Order, currency string) []ReceiptItem {
+	var items []ReceiptItem
+	for _, item := range o.Items {
+		name := item.Price
+		amount := int64(0)
+		if p, ok := svc.CatalogFor(ctx).Price(item.Price); ok {
+			if n := svc.CatalogFor(ctx).ProductName(p.Product); n != "" {
+				name = n
+			}
+			if len(p.Tiers) > 0 {
+				amount = p.AmountFor(item.Quantity)
+			} else {
+				unit, _ := p.AmountIn(currency)
+				amount = unit * item.Quantity
+			}
+		}
+		items = append(items, ReceiptItem{Name: name, Quantity: item.Quantity, Amount: amount})
+	}
+	return items
+}
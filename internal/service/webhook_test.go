@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func TestWebhookRouterOrder(t *testing.T) {
+	var order []string
+	wr := NewWebhookRouter()
+	wr.On("a", func(context.Context, stripe.Event) error { order = append(order, "first"); return nil })
+	wr.On("a", func(context.Context, stripe.Event) error { order = append(order, "second"); return errors.New("stop") })
+	wr.On("a", func(context.Context, stripe.Event) error { order = append(order, "third"); return nil })
+	if err := wr.Dispatch(context.Background(), stripe.Event{Type: "a"}); err == nil {
+		t.Error("Dispatch didn't return the handler error")
+	}
+	if strings.Join(order, ",") != "first,second" {
+		t.Errorf("ran %v, want first,second", order)
+	}
+	if err := wr.Dispatch(context.Background(), stripe.Event{Type: "b"}); err != nil {
+		t.Errorf("unhandled event: %v", err)
+	}
+}
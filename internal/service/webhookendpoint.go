@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// webhookEndpointURL is where Stripe delivers this instance's events.
+func (svc *Service) webhookEndpointURL() string {
+	return svc.Config.Domain + "/webhook"
+}
+
+// RegisterWebhookEndpoint makes Stripe deliver exactly the events the
+// webhook router handles or EVENT_FORWARDS forwards to DOMAIN's /webhook. It creates the endpoint if
+// the account has none for that URL, saving the signing secret to
+// WEBHOOK_SECRET_FILE, and otherwise updates its events and enables it.
+// Stripe only reveals a secret at creation, so an existing endpoint whose
+// secret isn't configured is an error.
+func (svc *Service) RegisterWebhookEndpoint(ctx context.Context) error {
+	url := svc.webhookEndpointURL()
+	types := svc.WebhookEventTypes()
+	list, err := svc.Stripe.ListWebhookEndpoints(ctx, &stripe.WebhookEndpointListParams{})
+	if err != nil {
+		return err
+	}
+	var existing *stripe.WebhookEndpoint
+	for _, ep := range list {
+		if ep.URL == url {
+			existing = ep
+			break
+		}
+	}
+	if existing == nil {
+		ep, err := svc.Stripe.NewWebhookEndpoint(ctx, &stripe.WebhookEndpointParams{
+			URL:           stripe.String(url),
+			EnabledEvents: stripe.StringSlice(types),
+			APIVersion:    stripe.String(stripe.APIVersion),
+			Description:   stripe.String("Registered on startup by WEBHOOK_AUTO_REGISTER"),
+		})
+		if err != nil {
+			return err
+		}
+		if err := saveWebhookSecret(svc.Config.WebhookSecretFile, ep.Secret); err != nil {
+			return fmt.Errorf("saving the secret of webhook endpoint %s (delete it to have it recreated): %w", ep.ID, err)
+		}
+		svc.Config.WebhookSecrets = append(svc.Config.WebhookSecrets, ep.Secret)
+		slog.Info("webhook endpoint created", "endpoint", ep.ID, "url", url, "events", len(types), "secret_file", svc.Config.WebhookSecretFile)
+		return nil
+	}
+	enabled := slices.Clone(existing.EnabledEvents)
+	slices.Sort(enabled)
+	if !slices.Equal(enabled, types) || existing.Status != "enabled" {
+		_, err := svc.Stripe.UpdateWebhookEndpoint(ctx, existing.ID, &stripe.WebhookEndpointParams{
+			EnabledEvents: stripe.StringSlice(types),
+			Disabled:      stripe.Bool(false),
+		})
+		if err != nil {
+			return err
+		}
+		slog.Info("webhook endpoint updated", "endpoint", existing.ID, "url", url, "events", len(types))
+	}
+	if len(svc.Config.WebhookSecrets) == 0 {
+		return fmt.Errorf("webhook endpoint %s for %s exists but its signing secret is unknown: set STRIPE_WEBHOOK_SECRET, or delete the endpoint to have it recreated", existing.ID, url)
+	}
+	return nil
+}
+
+// saveWebhookSecret writes secret to path, readable only by this user and
+// replaced atomically so a crash can't leave half a secret.
+func saveWebhookSecret(path, secret string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(secret+"\n"), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,134 @@
+// Package servicetest builds Services for tests: wired to a fake Stripe, a
+// recording email sender, a temporary SQLite store and a job queue kept in
+// memory.
+package servicetest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+	"stripe_go/internal/store"
+)
+
+// StringValues returns the strings p points to.
+func StringValues(p []*string) []string {
+	var list []string
+	for _, s := range p {
+		list = append(list, stripe.StringValue(s))
+	}
+	return list
+}
+
+// The admin token and webhook signing secret of the Services New returns.
+const (
+	AdminToken    = "test-admin-token"
+	WebhookSecret = "whsec_test_secret"
+)
+
+// EmailSender records the emails sent, or fails with Err when it is set.
+type EmailSender struct {
+	mu   sync.Mutex
+	Sent []*service.EmailMessage
+	Err  error
+}
+
+func (s *EmailSender) Send(ctx context.Context, msg *service.EmailMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.Err != nil {
+		return s.Err
+	}
+	s.Sent = append(s.Sent, msg)
+	return nil
+}
+
+// New returns a Service in test mode with the catalog of a fresh fake
+// Stripe, the fake itself and the sender its emails are recorded by. Its
+// job queue runs only when the test drives it with RunDue, and it handles
+// no events until webhookhandlers.Register adds the handlers.
+func New(t *testing.T) (*service.Service, *Stripe, *EmailSender) {
+	t.Helper()
+	payments, err := store.NewSQLitePaymentStore(filepath.Join(t.TempDir(), "payments.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { payments.Close() })
+	svc := service.New(&config.Config{
+		Mode:                    "test",
+		PublishableKey:          "pk_test_123",
+		SecretKey:               "sk_test_123",
+		WebhookSecrets:          []string{WebhookSecret},
+		Price:                   "price_basic",
+		Domain:                  "http://localhost:4242",
+		StaticDir:               t.TempDir(),
+		AdminToken:              AdminToken,
+		NotifyEmail:             "ops@example.com",
+		WebhookTolerance:        5 * time.Minute,
+		CachePriceTTL:           time.Minute,
+		CacheSessionTTL:         time.Minute,
+		ReconcileWindow:         72 * time.Hour,
+		EventDedupeTTL:          time.Hour,
+		EventPollInterval:       time.Second,
+		RequestTimeout:          10 * time.Second,
+		AdminRequestTimeout:     time.Minute,
+		MaxRequestBody:          1 << 20,
+		IdempotencyKeyTTL:       time.Hour,
+		InventoryReservationTTL: time.Hour,
+		CheckoutSessionTTL:      24 * time.Hour,
+		AuthenticationWindow:    72 * time.Hour,
+		PortalLinkTTL:           time.Hour,
+		SafetyRefundThreshold:   50000,
+		SafetyConfirmationTTL:   10 * time.Minute,
+		MaxQuantity:             10,
+		DonationCurrency:        "usd",
+		DonationMinAmount:       100,
+		DonationMaxAmount:       100000,
+		DonationName:            "Donation",
+		ApplicationFeePercent:   10,
+		ReturnURLHosts:          []string{"shop.example.com"},
+
+		SubscriptionProrationBehavior: "create_prorations",
+	}, service.Stores{Payments: payments, Events: store.NewMemoryEventStore(time.Hour), Cache: store.NewMemoryCache(100)}, Assets())
+
+	fake := NewStripe()
+	svc.Stripe = fake
+	if err := svc.Catalog.Load(context.Background(), fake); err != nil {
+		t.Fatal(err)
+	}
+
+	emails := &EmailSender{}
+	svc.EmailSender = emails
+	if svc.EmailTemplates, err = service.LoadEmailTemplates(svc.EmailTemplateFS()); err != nil {
+		t.Fatal(err)
+	}
+
+	if svc.Jobs, err = service.NewJobQueue("", 1, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	svc.RegisterJobHandlers()
+	return svc, fake, emails
+}
+
+// Assets returns the HTML client and email templates of the repository,
+// which the program embeds.
+func Assets() service.Assets {
+	return service.Assets{Static: os.DirFS(RepoPath("html")), EmailTemplates: os.DirFS(RepoPath("templates", "email"))}
+}
+
+// RepoPath returns the path of elem in the repository.
+func RepoPath(elem ...string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(append([]string{filepath.Dir(file), "..", ".."}, elem...)...)
+}
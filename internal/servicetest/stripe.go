@@ -0,0 +1,1108 @@
+package servicetest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+// Stripe is an in-memory stripeclient.Client. Tests seed it with objects and
+// inspect the params handlers sent.
+type Stripe struct {
+	mu sync.Mutex
+
+	Products       []*stripe.Product
+	Prices         []*stripe.Price
+	Sessions       map[string]*stripe.CheckoutSession
+	LineItems      map[string][]*stripe.LineItem
+	PaymentIntents map[string]*stripe.PaymentIntent
+	Customers      map[string]*stripe.Customer
+	Coupons        map[string]*stripe.Coupon
+	PromotionCodes []*stripe.PromotionCode
+	Accounts       map[string]*stripe.Account
+	Disputes       map[string]*stripe.Dispute
+	Reviews        map[string]*stripe.Review
+	SetupIntents   map[string]*stripe.SetupIntent
+	PaymentMethods []*stripe.PaymentMethod
+	paymentLinks   []*stripe.PaymentLink
+	invoices       map[string]*stripe.Invoice
+	// daysUntilDue holds the days_until_due of draft invoices by ID.
+	daysUntilDue  map[string]int64
+	Subscriptions map[string]*stripe.Subscription
+	// Usage holds the usage reported for each subscription item, by
+	// timestamp.
+	Usage    map[string]map[int64]int64
+	Webhooks []*stripe.WebhookEndpoint
+	// Files holds the contents of uploaded files by ID.
+	Files map[string][]byte
+	// Events are listed by ListEvents; append them oldest first.
+	Events []*stripe.Event
+
+	// Params of every create and capture call, in order.
+	SessionParams       []*stripe.CheckoutSessionParams
+	PaymentIntentParams []*stripe.PaymentIntentParams
+	RefundParams        []*stripe.RefundParams
+	AccountParams       []*stripe.AccountParams
+	PortalParams        []*stripe.BillingPortalSessionParams
+	DisputeParams       []*stripe.DisputeParams
+	PaymentLinkParams   []*stripe.PaymentLinkParams
+	InvoiceParams       []*stripe.InvoiceParams
+	InvoiceItemParams   []*stripe.InvoiceItemParams
+	UpcomingParams      []*stripe.InvoiceParams
+	SubscriptionParams  []*stripe.SubscriptionParams
+	UsageParams         []*stripe.UsageRecordParams
+	WebhookParams       []*stripe.WebhookEndpointParams
+	CaptureParams       []*stripe.PaymentIntentCaptureParams
+
+	// Err, when set, is returned by every API call.
+	Err    error
+	nextID int
+}
+
+func NewStripe() *Stripe {
+	f := &Stripe{
+		Sessions:       map[string]*stripe.CheckoutSession{},
+		LineItems:      map[string][]*stripe.LineItem{},
+		PaymentIntents: map[string]*stripe.PaymentIntent{},
+		Customers:      map[string]*stripe.Customer{},
+		Coupons:        map[string]*stripe.Coupon{},
+		Accounts:       map[string]*stripe.Account{},
+		Disputes:       map[string]*stripe.Dispute{},
+		Reviews:        map[string]*stripe.Review{},
+		SetupIntents:   map[string]*stripe.SetupIntent{},
+		Files:          map[string][]byte{},
+		invoices:       map[string]*stripe.Invoice{},
+		daysUntilDue:   map[string]int64{},
+		Subscriptions:  map[string]*stripe.Subscription{},
+		Usage:          map[string]map[int64]int64{},
+	}
+	basic := &stripe.Product{ID: "prod_basic", Name: "Basic", Active: true}
+	plan := &stripe.Product{ID: "prod_plan", Name: "Plan", Active: true}
+	f.Products = []*stripe.Product{basic, plan}
+	f.Prices = []*stripe.Price{
+		{
+			ID: "price_basic", Product: basic, UnitAmount: 1500, Currency: stripe.CurrencyUSD, Nickname: "Basic", Active: true,
+			CurrencyOptions: map[string]*stripe.PriceCurrencyOptions{
+				"usd": {UnitAmount: 1500},
+				"eur": {UnitAmount: 1400},
+				"gbp": {UnitAmount: 1200},
+			},
+		},
+		{ID: "price_yen", Product: basic, UnitAmount: 500, Currency: stripe.CurrencyJPY, Active: true},
+		{
+			ID: "price_monthly", Product: plan, UnitAmount: 900, Currency: stripe.CurrencyUSD, Active: true,
+			Recurring: &stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalMonth, IntervalCount: 1},
+		},
+		{
+			ID: "price_seats", Product: plan, Currency: stripe.CurrencyUSD, Active: true,
+			Recurring:     &stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalMonth, IntervalCount: 1},
+			BillingScheme: stripe.PriceBillingSchemeTiered, TiersMode: stripe.PriceTiersModeGraduated,
+			Tiers: []*stripe.PriceTier{
+				{UpTo: 5, UnitAmount: 1000, FlatAmount: 500},
+				{UpTo: 10, UnitAmount: 800},
+				{UnitAmount: 500},
+			},
+		},
+	}
+	return f
+}
+
+func (f *Stripe) id(prefix string) string {
+	f.nextID++
+	return fmt.Sprintf("%s_test_%d", prefix, f.nextID)
+}
+
+// fail is the error an API call made under ctx returns: the context's error
+// once it is cancelled, as the real client does, or f.Err.
+func (f *Stripe) fail(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Err
+}
+
+func notFound(kind, id string) error {
+	return &stripe.Error{
+		HTTPStatusCode: http.StatusNotFound,
+		Code:           stripe.ErrorCodeResourceMissing,
+		Msg:            fmt.Sprintf("No such %s: '%s'", kind, id),
+	}
+}
+
+func (f *Stripe) Price(id string) *stripe.Price {
+	for _, p := range f.Prices {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// tieredAmount is what Stripe bills for quantity units of a tiered price.
+func tieredAmount(p *stripe.Price, quantity int64) int64 {
+	var total int64
+	for i, t := range p.Tiers {
+		var from int64 = 1
+		if i > 0 {
+			from = p.Tiers[i-1].UpTo + 1
+		}
+		to := t.UpTo
+		if to == 0 || to > quantity {
+			to = quantity
+		}
+		inTier := t.UpTo == 0 || quantity <= t.UpTo
+		switch {
+		case p.TiersMode == stripe.PriceTiersModeVolume && inTier && quantity >= from:
+			return t.UnitAmount*quantity + t.FlatAmount
+		case p.TiersMode == stripe.PriceTiersModeGraduated && to >= from:
+			total += t.UnitAmount*(to-from+1) + t.FlatAmount
+		}
+	}
+	return total
+}
+
+func (f *Stripe) NewCheckoutSession(ctx context.Context, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	// Like stripeAPI, carry ctx to the backend, which reads the connected
+	// account from it.
+	params.Context = ctx
+	f.SessionParams = append(f.SessionParams, params)
+	s := &stripe.CheckoutSession{
+		ID:            f.id("cs"),
+		Mode:          stripe.CheckoutSessionMode(stripe.StringValue(params.Mode)),
+		PaymentStatus: stripe.CheckoutSessionPaymentStatusUnpaid,
+		Metadata:      params.Metadata,
+		ExpiresAt:     stripe.Int64Value(params.ExpiresAt),
+		Locale:        stripe.StringValue(params.Locale),
+	}
+	s.URL = "https://checkout.stripe.com/c/pay/" + s.ID
+	for _, li := range params.LineItems {
+		p := f.Price(stripe.StringValue(li.Price))
+		if d := li.PriceData; d != nil {
+			p = &stripe.Price{
+				UnitAmount: stripe.Int64Value(d.UnitAmount),
+				Currency:   stripe.Currency(stripe.StringValue(d.Currency)),
+				Product:    &stripe.Product{ID: stripe.StringValue(d.Product)},
+			}
+			if d.ProductData != nil {
+				p.Product.Name = stripe.StringValue(d.ProductData.Name)
+			}
+		}
+		if p == nil {
+			return nil, notFound("price", stripe.StringValue(li.Price))
+		}
+		amount, currency := p.UnitAmount, p.Currency
+		if params.Currency != nil {
+			o, ok := p.CurrencyOptions[*params.Currency]
+			if !ok {
+				return nil, &stripe.Error{HTTPStatusCode: http.StatusBadRequest, Msg: "price has no option for " + *params.Currency}
+			}
+			amount, currency = o.UnitAmount, stripe.Currency(*params.Currency)
+		}
+		total := amount * stripe.Int64Value(li.Quantity)
+		if p.BillingScheme == stripe.PriceBillingSchemeTiered {
+			total = tieredAmount(p, stripe.Int64Value(li.Quantity))
+		}
+		s.AmountTotal += total
+		s.AmountSubtotal = s.AmountTotal
+		s.Currency = currency
+		f.LineItems[s.ID] = append(f.LineItems[s.ID], &stripe.LineItem{
+			ID:             f.id("li"),
+			Description:    p.Product.Name,
+			Price:          p,
+			Quantity:       stripe.Int64Value(li.Quantity),
+			AmountSubtotal: total,
+			AmountTotal:    total,
+			Currency:       currency,
+		})
+	}
+	if params.Customer != nil {
+		s.Customer = &stripe.Customer{ID: *params.Customer}
+	}
+	f.Sessions[s.ID] = s
+	return s, nil
+}
+
+func (f *Stripe) GetCheckoutSession(ctx context.Context, id string, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	s, ok := f.Sessions[id]
+	if !ok {
+		return nil, notFound("checkout.session", id)
+	}
+	if params != nil {
+		for _, e := range params.Expand {
+			if *e == "line_items" {
+				expanded := *s
+				expanded.LineItems = &stripe.LineItemList{Data: f.LineItems[id]}
+				return &expanded, nil
+			}
+		}
+	}
+	return s, nil
+}
+
+func (f *Stripe) ListCheckoutSessions(ctx context.Context, params *stripe.CheckoutSessionListParams) ([]*stripe.CheckoutSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	list := []*stripe.CheckoutSession{}
+	for _, s := range f.Sessions {
+		if params.Customer == nil || (s.Customer != nil && s.Customer.ID == *params.Customer) {
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
+func (f *Stripe) ListCheckoutSessionLineItems(ctx context.Context, id string, params *stripe.CheckoutSessionListLineItemsParams) ([]*stripe.LineItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	if _, ok := f.Sessions[id]; !ok {
+		return nil, notFound("checkout.session", id)
+	}
+	return f.LineItems[id], nil
+}
+
+func (f *Stripe) NewBillingPortalSession(ctx context.Context, params *stripe.BillingPortalSessionParams) (*stripe.BillingPortalSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.PortalParams = append(f.PortalParams, params)
+	id := f.id("bps")
+	return &stripe.BillingPortalSession{
+		ID:        id,
+		Customer:  stripe.StringValue(params.Customer),
+		ReturnURL: stripe.StringValue(params.ReturnURL),
+		URL:       "https://billing.stripe.com/p/session/" + id,
+	}, nil
+}
+
+func (f *Stripe) GetPrice(ctx context.Context, id string, params *stripe.PriceParams) (*stripe.Price, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	if p := f.Price(id); p != nil {
+		return p, nil
+	}
+	return nil, notFound("price", id)
+}
+
+func (f *Stripe) ListPrices(ctx context.Context, params *stripe.PriceListParams) ([]*stripe.Price, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Prices, f.fail(ctx)
+}
+
+func (f *Stripe) ListProducts(ctx context.Context, params *stripe.ProductListParams) ([]*stripe.Product, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Products, f.fail(ctx)
+}
+
+func (f *Stripe) NewPaymentIntent(ctx context.Context, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.PaymentIntentParams = append(f.PaymentIntentParams, params)
+	pi := &stripe.PaymentIntent{
+		ID:       f.id("pi"),
+		Amount:   stripe.Int64Value(params.Amount),
+		Currency: stripe.StringValue(params.Currency),
+		Status:   stripe.PaymentIntentStatusRequiresPaymentMethod,
+		Metadata: params.Metadata,
+	}
+	pi.ClientSecret = pi.ID + "_secret_fake"
+	f.PaymentIntents[pi.ID] = pi
+	if !stripe.BoolValue(params.Confirm) {
+		return pi, nil
+	}
+	// Confirming behaves like Stripe's test payment methods.
+	switch stripe.StringValue(params.PaymentMethod) {
+	case "pm_card_authenticationRequired":
+		return nil, &stripe.Error{HTTPStatusCode: http.StatusPaymentRequired, Type: stripe.ErrorTypeCard,
+			Code: stripe.ErrorCodeAuthenticationRequired, Msg: "This payment requires authentication.", PaymentIntent: pi}
+	case "pm_card_chargeDeclined":
+		return nil, &stripe.Error{HTTPStatusCode: http.StatusPaymentRequired, Type: stripe.ErrorTypeCard,
+			Code: stripe.ErrorCodeCardDeclined, DeclineCode: "generic_decline", Msg: "Your card was declined.", PaymentIntent: pi}
+	}
+	pi.Status = stripe.PaymentIntentStatusSucceeded
+	return pi, nil
+}
+
+func (f *Stripe) GetPaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	pi, ok := f.PaymentIntents[id]
+	if !ok {
+		return nil, notFound("payment_intent", id)
+	}
+	return pi, nil
+}
+
+func (f *Stripe) ListPaymentIntents(ctx context.Context, params *stripe.PaymentIntentListParams) ([]*stripe.PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	list := []*stripe.PaymentIntent{}
+	for _, pi := range f.PaymentIntents {
+		if params.CreatedRange == nil || pi.Created >= params.CreatedRange.GreaterThanOrEqual {
+			list = append(list, pi)
+		}
+	}
+	return list, nil
+}
+
+func (f *Stripe) CapturePaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentCaptureParams) (*stripe.PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	pi, ok := f.PaymentIntents[id]
+	if !ok {
+		return nil, notFound("payment_intent", id)
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+		return nil, &stripe.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Code:           stripe.ErrorCodePaymentIntentUnexpectedState,
+			Msg:            fmt.Sprintf("This PaymentIntent could not be captured because it has a status of %s.", pi.Status),
+		}
+	}
+	f.CaptureParams = append(f.CaptureParams, params)
+	pi.Status = stripe.PaymentIntentStatusSucceeded
+	pi.AmountReceived = pi.Amount
+	if params.AmountToCapture != nil {
+		pi.AmountReceived = *params.AmountToCapture
+	}
+	pi.AmountCapturable = 0
+	return pi, nil
+}
+
+func (f *Stripe) CancelPaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentCancelParams) (*stripe.PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	pi, ok := f.PaymentIntents[id]
+	if !ok {
+		return nil, notFound("payment_intent", id)
+	}
+	if pi.Status == stripe.PaymentIntentStatusSucceeded || pi.Status == stripe.PaymentIntentStatusCanceled {
+		return nil, &stripe.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Code:           stripe.ErrorCodePaymentIntentUnexpectedState,
+			Msg:            fmt.Sprintf("This PaymentIntent could not be canceled because it has a status of %s.", pi.Status),
+		}
+	}
+	pi.Status = stripe.PaymentIntentStatusCanceled
+	if params != nil && params.CancellationReason != nil {
+		pi.CancellationReason = stripe.PaymentIntentCancellationReason(*params.CancellationReason)
+	}
+	return pi, nil
+}
+
+func (f *Stripe) NewRefund(ctx context.Context, params *stripe.RefundParams) (*stripe.Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	params.Context = ctx
+	f.RefundParams = append(f.RefundParams, params)
+	pi, ok := f.PaymentIntents[stripe.StringValue(params.PaymentIntent)]
+	if !ok {
+		return nil, notFound("payment_intent", stripe.StringValue(params.PaymentIntent))
+	}
+	amount := pi.Amount
+	if params.Amount != nil {
+		amount = *params.Amount
+	}
+	return &stripe.Refund{
+		ID:       f.id("re"),
+		Amount:   amount,
+		Currency: stripe.Currency(pi.Currency),
+		Status:   stripe.RefundStatusSucceeded,
+		Reason:   stripe.RefundReason(stripe.StringValue(params.Reason)),
+	}, nil
+}
+
+func (f *Stripe) NewPaymentLink(ctx context.Context, params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.PaymentLinkParams = append(f.PaymentLinkParams, params)
+	id := f.id("plink")
+	link := &stripe.PaymentLink{
+		ID:        id,
+		URL:       "https://buy.stripe.com/test_" + id,
+		Active:    true,
+		Metadata:  params.Metadata,
+		LineItems: &stripe.LineItemList{},
+	}
+	for _, li := range params.LineItems {
+		price := f.Price(stripe.StringValue(li.Price))
+		if price == nil {
+			return nil, notFound("price", stripe.StringValue(li.Price))
+		}
+		link.LineItems.Data = append(link.LineItems.Data, &stripe.LineItem{Price: price, Quantity: stripe.Int64Value(li.Quantity)})
+	}
+	f.paymentLinks = append(f.paymentLinks, link)
+	return link, nil
+}
+
+func (f *Stripe) NewInvoice(ctx context.Context, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.InvoiceParams = append(f.InvoiceParams, params)
+	c, ok := f.Customers[stripe.StringValue(params.Customer)]
+	if !ok {
+		return nil, notFound("customer", stripe.StringValue(params.Customer))
+	}
+	inv := &stripe.Invoice{
+		ID:            f.id("in"),
+		Customer:      &stripe.Customer{ID: c.ID},
+		CustomerEmail: c.Email,
+		Currency:      stripe.Currency(stripe.StringValue(params.Currency)),
+		Status:        stripe.InvoiceStatusDraft,
+		Description:   stripe.StringValue(params.Description),
+		DueDate:       stripe.Int64Value(params.DueDate),
+		Metadata:      params.Metadata,
+		Created:       time.Now().Unix(),
+	}
+	f.invoices[inv.ID] = inv
+	f.daysUntilDue[inv.ID] = stripe.Int64Value(params.DaysUntilDue)
+	out := *inv
+	return &out, nil
+}
+
+// NewInvoiceItem adds the item to the draft invoice it names.
+func (f *Stripe) NewInvoiceItem(ctx context.Context, params *stripe.InvoiceItemParams) (*stripe.InvoiceItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.InvoiceItemParams = append(f.InvoiceItemParams, params)
+	inv, ok := f.invoices[stripe.StringValue(params.Invoice)]
+	if !ok || inv.Status != stripe.InvoiceStatusDraft {
+		return nil, notFound("invoice", stripe.StringValue(params.Invoice))
+	}
+	amount := stripe.Int64Value(params.UnitAmount) * stripe.Int64Value(params.Quantity)
+	inv.Total += amount
+	inv.AmountDue += amount
+	return &stripe.InvoiceItem{ID: f.id("ii"), Amount: amount, Description: stripe.StringValue(params.Description)}, nil
+}
+
+func (f *Stripe) GetInvoice(ctx context.Context, id string, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	inv, ok := f.invoices[id]
+	if !ok {
+		return nil, notFound("invoice", id)
+	}
+	out := *inv
+	return &out, nil
+}
+
+// FinalizeInvoice numbers a draft and opens it, setting the due date from
+// days_until_due as Stripe does.
+func (f *Stripe) FinalizeInvoice(ctx context.Context, id string, params *stripe.InvoiceFinalizeParams) (*stripe.Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	inv, ok := f.invoices[id]
+	if !ok {
+		return nil, notFound("invoice", id)
+	}
+	if inv.Status != stripe.InvoiceStatusDraft {
+		return nil, &stripe.Error{HTTPStatusCode: http.StatusBadRequest, Msg: "This invoice is already finalized."}
+	}
+	inv.Status = stripe.InvoiceStatusOpen
+	inv.Number = fmt.Sprintf("INV-%04d", len(f.invoices))
+	inv.HostedInvoiceURL = "https://invoice.stripe.com/i/" + id
+	inv.StatusTransitions.FinalizedAt = time.Now().Unix()
+	if days := f.daysUntilDue[id]; days != 0 {
+		inv.DueDate = time.Now().AddDate(0, 0, int(days)).Unix()
+	}
+	out := *inv
+	return &out, nil
+}
+
+func (f *Stripe) SendInvoice(ctx context.Context, id string, params *stripe.InvoiceSendParams) (*stripe.Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	inv, ok := f.invoices[id]
+	if !ok {
+		return nil, notFound("invoice", id)
+	}
+	if inv.Status != stripe.InvoiceStatusOpen {
+		return nil, &stripe.Error{HTTPStatusCode: http.StatusBadRequest, Msg: "You can only send open invoices."}
+	}
+	out := *inv
+	return &out, nil
+}
+
+// GetUpcomingInvoice previews the next invoice of the subscription in
+// params with its items moved to the given prices: unless proration is off,
+// a credit for the unused time on each old price and a charge for it on the
+// new one, then the next period on the new price.
+func (f *Stripe) GetUpcomingInvoice(ctx context.Context, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.UpcomingParams = append(f.UpcomingParams, params)
+	sub, ok := f.Subscriptions[stripe.StringValue(params.Subscription)]
+	if !ok {
+		return nil, notFound("subscription", stripe.StringValue(params.Subscription))
+	}
+	inv := &stripe.Invoice{Customer: sub.Customer, Subscription: sub, Lines: &stripe.InvoiceLineList{}}
+	period := float64(sub.CurrentPeriodEnd - sub.CurrentPeriodStart)
+	unused := float64(sub.CurrentPeriodEnd-stripe.Int64Value(params.SubscriptionProrationDate)) / period
+	for _, item := range sub.Items.Data {
+		next := item.Price
+		for _, change := range params.SubscriptionItems {
+			if stripe.StringValue(change.ID) == item.ID {
+				if next = f.Price(stripe.StringValue(change.Price)); next == nil {
+					return nil, notFound("price", stripe.StringValue(change.Price))
+				}
+			}
+		}
+		inv.Currency = next.Currency
+		if next != item.Price && stripe.StringValue(params.SubscriptionProrationBehavior) != "none" {
+			inv.Lines.Data = append(inv.Lines.Data,
+				&stripe.InvoiceLine{Amount: -int64(float64(item.Price.UnitAmount*item.Quantity) * unused), Proration: true},
+				&stripe.InvoiceLine{Amount: int64(float64(next.UnitAmount*item.Quantity) * unused), Proration: true})
+		}
+		inv.Lines.Data = append(inv.Lines.Data, &stripe.InvoiceLine{Amount: next.UnitAmount * item.Quantity})
+	}
+	for _, line := range inv.Lines.Data {
+		inv.Total += line.Amount
+	}
+	inv.AmountDue = inv.Total
+	return inv, nil
+}
+
+func (f *Stripe) GetSubscription(ctx context.Context, id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	sub, ok := f.Subscriptions[id]
+	if !ok {
+		return nil, notFound("subscription", id)
+	}
+	out := *sub
+	return &out, nil
+}
+
+// UpdateSubscription moves the subscription's items to the prices in
+// params.
+func (f *Stripe) UpdateSubscription(ctx context.Context, id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.SubscriptionParams = append(f.SubscriptionParams, params)
+	sub, ok := f.Subscriptions[id]
+	if !ok {
+		return nil, notFound("subscription", id)
+	}
+	for _, change := range params.Items {
+		for _, item := range sub.Items.Data {
+			if stripe.StringValue(change.ID) != item.ID {
+				continue
+			}
+			if item.Price = f.Price(stripe.StringValue(change.Price)); item.Price == nil {
+				return nil, notFound("price", stripe.StringValue(change.Price))
+			}
+			item.Quantity = stripe.Int64Value(change.Quantity)
+		}
+	}
+	out := *sub
+	return &out, nil
+}
+
+// subscriptionItem finds a subscription item and its subscription.
+func (f *Stripe) subscriptionItem(id string) (*stripe.Subscription, *stripe.SubscriptionItem) {
+	for _, sub := range f.Subscriptions {
+		for _, item := range sub.Items.Data {
+			if item.ID == id {
+				return sub, item
+			}
+		}
+	}
+	return nil, nil
+}
+
+// NewUsageRecord adds to or sets the usage of a metered subscription item
+// at the given time, which must be in the current period.
+func (f *Stripe) NewUsageRecord(ctx context.Context, params *stripe.UsageRecordParams) (*stripe.UsageRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.UsageParams = append(f.UsageParams, params)
+	id := stripe.StringValue(params.SubscriptionItem)
+	sub, item := f.subscriptionItem(id)
+	if item == nil {
+		return nil, notFound("subscription_item", id)
+	}
+	if item.Price.Recurring == nil || item.Price.Recurring.UsageType != stripe.PriceRecurringUsageTypeMetered {
+		return nil, &stripe.Error{HTTPStatusCode: http.StatusBadRequest, Msg: "Usage records can only be created for metered prices."}
+	}
+	ts := stripe.Int64Value(params.Timestamp)
+	if ts < sub.CurrentPeriodStart || ts >= sub.CurrentPeriodEnd {
+		return nil, &stripe.Error{HTTPStatusCode: http.StatusBadRequest, Msg: "Cannot create the usage record with this timestamp."}
+	}
+	if f.Usage[id] == nil {
+		f.Usage[id] = map[int64]int64{}
+	}
+	if stripe.StringValue(params.Action) == "set" {
+		f.Usage[id][ts] = stripe.Int64Value(params.Quantity)
+	} else {
+		f.Usage[id][ts] += stripe.Int64Value(params.Quantity)
+	}
+	return &stripe.UsageRecord{ID: f.id("mbur"), SubscriptionItem: id, Quantity: stripe.Int64Value(params.Quantity), Timestamp: ts}, nil
+}
+
+// ListUsageRecordSummaries sums up the current period of the item.
+func (f *Stripe) ListUsageRecordSummaries(ctx context.Context, params *stripe.UsageRecordSummaryListParams) ([]*stripe.UsageRecordSummary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	id := stripe.StringValue(params.SubscriptionItem)
+	sub, item := f.subscriptionItem(id)
+	if item == nil {
+		return nil, notFound("subscription_item", id)
+	}
+	summary := &stripe.UsageRecordSummary{
+		ID:               f.id("sis"),
+		SubscriptionItem: id,
+		Period:           &stripe.Period{Start: sub.CurrentPeriodStart, End: sub.CurrentPeriodEnd},
+	}
+	for _, q := range f.Usage[id] {
+		summary.TotalUsage += q
+	}
+	return []*stripe.UsageRecordSummary{summary}, nil
+}
+
+func (f *Stripe) UpdatePaymentLink(ctx context.Context, id string, params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	for _, link := range f.paymentLinks {
+		if link.ID == id {
+			if params.Active != nil {
+				link.Active = *params.Active
+			}
+			return link, nil
+		}
+	}
+	return nil, notFound("payment_link", id)
+}
+
+func (f *Stripe) ListPaymentLinks(ctx context.Context, params *stripe.PaymentLinkListParams) ([]*stripe.PaymentLink, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	// Stripe lists newest first.
+	list := []*stripe.PaymentLink{}
+	for i := len(f.paymentLinks) - 1; i >= 0; i-- {
+		if link := f.paymentLinks[i]; params.Active == nil || link.Active == *params.Active {
+			list = append(list, link)
+		}
+	}
+	return list, nil
+}
+
+func (f *Stripe) NewWebhookEndpoint(ctx context.Context, params *stripe.WebhookEndpointParams) (*stripe.WebhookEndpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.WebhookParams = append(f.WebhookParams, params)
+	id := f.id("we")
+	ep := &stripe.WebhookEndpoint{
+		ID:            id,
+		URL:           stripe.StringValue(params.URL),
+		APIVersion:    stripe.StringValue(params.APIVersion),
+		Description:   stripe.StringValue(params.Description),
+		EnabledEvents: StringValues(params.EnabledEvents),
+		Status:        "enabled",
+	}
+	f.Webhooks = append(f.Webhooks, ep)
+	// The secret is only returned at creation.
+	created := *ep
+	created.Secret = "whsec_" + id
+	return &created, nil
+}
+
+func (f *Stripe) UpdateWebhookEndpoint(ctx context.Context, id string, params *stripe.WebhookEndpointParams) (*stripe.WebhookEndpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.WebhookParams = append(f.WebhookParams, params)
+	for _, ep := range f.Webhooks {
+		if ep.ID == id {
+			if params.EnabledEvents != nil {
+				ep.EnabledEvents = StringValues(params.EnabledEvents)
+			}
+			if params.Disabled != nil {
+				ep.Status = map[bool]string{true: "disabled", false: "enabled"}[*params.Disabled]
+			}
+			return ep, nil
+		}
+	}
+	return nil, notFound("webhook_endpoint", id)
+}
+
+func (f *Stripe) ListWebhookEndpoints(ctx context.Context, params *stripe.WebhookEndpointListParams) ([]*stripe.WebhookEndpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	return append([]*stripe.WebhookEndpoint{}, f.Webhooks...), nil
+}
+
+func (f *Stripe) NewCustomer(ctx context.Context, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	c := &stripe.Customer{
+		ID:       f.id("cus"),
+		Email:    stripe.StringValue(params.Email),
+		Name:     stripe.StringValue(params.Name),
+		Metadata: params.Metadata,
+	}
+	f.Customers[c.ID] = c
+	return c, nil
+}
+
+func (f *Stripe) GetCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	c, ok := f.Customers[id]
+	if !ok {
+		return nil, notFound("customer", id)
+	}
+	return c, nil
+}
+
+func (f *Stripe) UpdateCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	c, ok := f.Customers[id]
+	if !ok {
+		return nil, notFound("customer", id)
+	}
+	if params.Email != nil {
+		c.Email = *params.Email
+	}
+	if params.Name != nil {
+		c.Name = *params.Name
+	}
+	if params.InvoiceSettings != nil && params.InvoiceSettings.DefaultPaymentMethod != nil {
+		c.InvoiceSettings = &stripe.CustomerInvoiceSettings{
+			DefaultPaymentMethod: &stripe.PaymentMethod{ID: *params.InvoiceSettings.DefaultPaymentMethod},
+		}
+	}
+	return c, nil
+}
+
+func (f *Stripe) DeleteCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	if _, ok := f.Customers[id]; !ok {
+		return nil, notFound("customer", id)
+	}
+	delete(f.Customers, id)
+	return &stripe.Customer{ID: id, Deleted: true}, nil
+}
+
+func (f *Stripe) ListCustomers(ctx context.Context, params *stripe.CustomerListParams) ([]*stripe.Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	list := []*stripe.Customer{}
+	for _, c := range f.Customers {
+		if params.Email == nil || c.Email == *params.Email {
+			list = append(list, c)
+		}
+	}
+	return list, nil
+}
+
+func (f *Stripe) ListPaymentMethods(ctx context.Context, params *stripe.PaymentMethodListParams) ([]*stripe.PaymentMethod, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	list := []*stripe.PaymentMethod{}
+	for _, pm := range f.PaymentMethods {
+		if pm.Customer != nil && pm.Customer.ID == stripe.StringValue(params.Customer) && string(pm.Type) == stripe.StringValue(params.Type) {
+			list = append(list, pm)
+		}
+	}
+	return list, nil
+}
+
+func (f *Stripe) GetSetupIntent(ctx context.Context, id string, params *stripe.SetupIntentParams) (*stripe.SetupIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	si, ok := f.SetupIntents[id]
+	if !ok {
+		return nil, notFound("setup_intent", id)
+	}
+	return si, nil
+}
+
+func (f *Stripe) GetCoupon(ctx context.Context, id string, params *stripe.CouponParams) (*stripe.Coupon, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	c, ok := f.Coupons[id]
+	if !ok {
+		return nil, notFound("coupon", id)
+	}
+	return c, nil
+}
+
+func (f *Stripe) ListPromotionCodes(ctx context.Context, params *stripe.PromotionCodeListParams) ([]*stripe.PromotionCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	list := []*stripe.PromotionCode{}
+	for _, pc := range f.PromotionCodes {
+		if params.Code == nil || pc.Code == *params.Code {
+			list = append(list, pc)
+		}
+	}
+	return list, nil
+}
+
+func (f *Stripe) NewAccount(ctx context.Context, params *stripe.AccountParams) (*stripe.Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	f.AccountParams = append(f.AccountParams, params)
+	a := &stripe.Account{
+		ID:    f.id("acct"),
+		Email: stripe.StringValue(params.Email),
+		Type:  stripe.AccountType(stripe.StringValue(params.Type)),
+	}
+	f.Accounts[a.ID] = a
+	return a, nil
+}
+
+func (f *Stripe) GetAccount(ctx context.Context, id string, params *stripe.AccountParams) (*stripe.Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	a, ok := f.Accounts[id]
+	if !ok {
+		return nil, notFound("account", id)
+	}
+	return a, nil
+}
+
+func (f *Stripe) NewAccountLink(ctx context.Context, params *stripe.AccountLinkParams) (*stripe.AccountLink, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	if _, ok := f.Accounts[stripe.StringValue(params.Account)]; !ok {
+		return nil, notFound("account", stripe.StringValue(params.Account))
+	}
+	return &stripe.AccountLink{
+		URL:       "https://connect.stripe.com/setup/e/" + *params.Account,
+		ExpiresAt: 1700000000,
+	}, nil
+}
+
+// UpdateDispute moves a submitted dispute to under_review, as Stripe does.
+func (f *Stripe) UpdateDispute(ctx context.Context, id string, params *stripe.DisputeParams) (*stripe.Dispute, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	d, ok := f.Disputes[id]
+	if !ok {
+		return nil, notFound("dispute", id)
+	}
+	f.DisputeParams = append(f.DisputeParams, params)
+	if params.Submit == nil || *params.Submit {
+		d.Status = stripe.DisputeStatusUnderReview
+	}
+	return d, nil
+}
+
+func (f *Stripe) ApproveReview(ctx context.Context, id string, params *stripe.ReviewApproveParams) (*stripe.Review, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	rv, ok := f.Reviews[id]
+	if !ok {
+		return nil, notFound("review", id)
+	}
+	rv.Open = false
+	rv.ClosedReason = stripe.ReviewClosedReasonApproved
+	rv.Reason = stripe.ReviewReasonApproved
+	return rv, nil
+}
+
+func (f *Stripe) NewFile(ctx context.Context, params *stripe.FileParams) (*stripe.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(params.FileReader)
+	if err != nil {
+		return nil, err
+	}
+	file := &stripe.File{ID: f.id("file"), Filename: stripe.StringValue(params.Filename), Size: int64(len(b)),
+		Purpose: stripe.FilePurpose(stripe.StringValue(params.Purpose))}
+	f.Files[file.ID] = b
+	return file, nil
+}
+
+// ListEvents pages like Stripe: events newer than EndingBefore, oldest
+// first, or every event, newest first. Single returns up to Limit of them.
+func (f *Stripe) GetEvent(ctx context.Context, id string) (*stripe.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	for _, e := range f.Events {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return nil, notFound("event", id)
+}
+
+func (f *Stripe) ListEvents(ctx context.Context, params *stripe.EventListParams) ([]*stripe.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	list := []*stripe.Event{}
+	if params.EndingBefore != nil {
+		found := false
+		for _, e := range f.Events {
+			if found {
+				list = append(list, e)
+			}
+			found = found || e.ID == *params.EndingBefore
+		}
+		if !found {
+			return nil, notFound("event", *params.EndingBefore)
+		}
+	} else {
+		for i := len(f.Events) - 1; i >= 0; i-- {
+			if params.CreatedRange == nil || f.Events[i].Created >= params.CreatedRange.GreaterThanOrEqual {
+				list = append(list, f.Events[i])
+			}
+		}
+	}
+	if params.Single && params.Limit != nil && int64(len(list)) > *params.Limit {
+		list = list[:*params.Limit]
+	}
+	return list, nil
+}
+
+// ConstructEvent checks signatures for real so webhook tests cover them.
+func (f *Stripe) ConstructEvent(payload []byte, signature, secret string, tolerance time.Duration) (stripe.Event, error) {
+	return webhook.ConstructEventWithTolerance(payload, signature, secret, tolerance)
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// NewCache returns the cache selected by CACHE_BACKEND.
+func NewCache(c *config.Config) (service.Cache, error) {
+	switch c.CacheBackend {
+	case "", "memory":
+		return NewMemoryCache(c.CacheSize), nil
+	case "redis":
+		opts, err := redis.ParseURL(c.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing REDIS_URL: %w", err)
+		}
+		return &redisCache{client: redis.NewClient(opts), prefix: "stripe_go:"}, nil
+	case "none":
+		return NoCache{}, nil
+	default:
+		return nil, fmt.Errorf("unknown CACHE_BACKEND %q", c.CacheBackend)
+	}
+}
+
+// memoryCache is an in-process LRU cache whose entries also expire after
+// their TTL.
+type memoryCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewMemoryCache(size int) *memoryCache {
+	return &memoryCache{size: size, order: list.New(), entries: map[string]*list.Element{}, now: time.Now}
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := el.Value.(*memoryCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true, nil
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &memoryCacheEntry{key: key, value: value, expiresAt: c.now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+	return nil
+}
+
+func (c *memoryCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+// redisCache shares the cache between instances. Keys are prefixed so the
+// database can be shared with other apps.
+type redisCache struct {
+	client *redis.Client
+	prefix string
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, c.prefix+key, value, ttl).Err()
+}
+
+func (c *redisCache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, c.prefix+key).Err()
+}
+
+// NoCache turns caching off.
+type NoCache struct{}
+
+func (NoCache) Get(ctx context.Context, key string) ([]byte, bool, error) { return nil, false, nil }
+func (NoCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return nil
+}
+func (NoCache) Delete(ctx context.Context, key string) error { return nil }
@@ -0,0 +1,36 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := NewMemoryCache(2)
+	c.now = func() time.Time { return now }
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Hour)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"), time.Hour)
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Error("least recently used entry wasn't evicted")
+	}
+	if v, ok, _ := c.Get(ctx, "a"); !ok || string(v) != "1" {
+		t.Errorf("a = %q, %v", v, ok)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok, _ := c.Get(ctx, "a"); ok {
+		t.Error("expired entry returned")
+	}
+	if _, ok, _ := c.Get(ctx, "c"); !ok {
+		t.Error("unexpired entry missing")
+	}
+	c.Delete(ctx, "c")
+	if _, ok, _ := c.Get(ctx, "c"); ok || len(c.entries) != 0 || c.order.Len() != 0 {
+		t.Errorf("cache not empty after delete: %d entries", len(c.entries))
+	}
+}
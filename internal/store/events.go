@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// NewEventStore returns the store selected by EVENT_STORE: memory
+// (the default), which forgets events on restart and isn't shared between
+// instances, or database, which keeps them in the payment store.
+func NewEventStore(c *config.Config, store service.PaymentStore) (service.EventStore, error) {
+	switch c.EventStore {
+	case "", "memory":
+		return NewMemoryEventStore(c.EventDedupeTTL), nil
+	case "database":
+		return &dbEventStore{store: store, lease: eventLease, ttl: c.EventDedupeTTL}, nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_STORE %q", c.EventStore)
+	}
+}
+
+// eventLease is how long an event stays claimed while its handlers run. A
+// process that dies mid-handler holds off Stripe's retries only this long,
+// rather than for the whole EVENT_DEDUPE_TTL.
+const eventLease = 5 * time.Minute
+
+type memoryEventStore struct {
+	mu      sync.Mutex
+	lease   time.Duration
+	ttl     time.Duration
+	expires map[string]time.Time
+}
+
+func NewMemoryEventStore(ttl time.Duration) *memoryEventStore {
+	return &memoryEventStore{lease: eventLease, ttl: ttl, expires: map[string]time.Time{}}
+}
+
+func (s *memoryEventStore) Claim(ctx context.Context, eventID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.prune(now)
+	if _, ok := s.expires[eventID]; ok {
+		return false, nil
+	}
+	s.expires[eventID] = now.Add(s.lease)
+	return true, nil
+}
+
+func (s *memoryEventStore) Complete(ctx context.Context, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expires[eventID] = time.Now().Add(s.ttl)
+	return nil
+}
+
+func (s *memoryEventStore) Release(ctx context.Context, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.expires, eventID)
+	return nil
+}
+
+func (s *memoryEventStore) prune(now time.Time) {
+	for id, exp := range s.expires {
+		if now.After(exp) {
+			delete(s.expires, id)
+		}
+	}
+}
+
+// dbEventStore keeps claims in the payment store, so they survive restarts
+// and are shared by every instance using the database.
+type dbEventStore struct {
+	store service.PaymentStore
+	lease time.Duration
+	ttl   time.Duration
+}
+
+func (s *dbEventStore) Claim(ctx context.Context, eventID string) (bool, error) {
+	return s.store.ClaimEvent(ctx, eventID, time.Now().Add(s.lease))
+}
+
+func (s *dbEventStore) Complete(ctx context.Context, eventID string) error {
+	return s.store.CompleteEvent(ctx, eventID, time.Now().Add(s.ttl))
+}
+
+func (s *dbEventStore) Release(ctx context.Context, eventID string) error {
+	return s.store.ReleaseEvent(ctx, eventID)
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryEventStoreLease(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryEventStore(time.Hour)
+	s.lease = 10 * time.Millisecond
+	if ok, _ := s.Claim(ctx, "evt_1"); !ok {
+		t.Fatal("first claim failed")
+	}
+	if ok, _ := s.Claim(ctx, "evt_1"); ok {
+		t.Fatal("claimed twice within the lease")
+	}
+	// A claim that was never completed, e.g. because the process died,
+	// runs out with its lease.
+	time.Sleep(20 * time.Millisecond)
+	if ok, _ := s.Claim(ctx, "evt_1"); !ok {
+		t.Fatal("claim after the lease failed")
+	}
+	if err := s.Complete(ctx, "evt_1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if ok, _ := s.Claim(ctx, "evt_1"); ok {
+		t.Error("a processed event was claimed again")
+	}
+}
@@ -0,0 +1,2145 @@
+// Package store keeps the service's state: payments, orders and the rest in
+// SQLite or Postgres, the claims of the webhook events handled and the
+// cache of Stripe objects.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// Open opens the payment store, event store and cache cfg configures.
+func Open(cfg *config.Config) (st service.Stores, err error) {
+	if st.Payments, err = OpenPaymentStore(cfg); err != nil {
+		return st, fmt.Errorf("Error opening payment store: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			st.Payments.Close()
+		}
+	}()
+	if st.Events, err = NewEventStore(cfg, st.Payments); err != nil {
+		return st, fmt.Errorf("Error configuring event store: %w", err)
+	}
+	if st.Cache, err = NewCache(cfg); err != nil {
+		return st, fmt.Errorf("Error configuring cache: %w", err)
+	}
+	return st, nil
+}
+
+// OpenPaymentStore opens the store selected by DATABASE_DRIVER ("sqlite" or
+// "postgres") using the DATABASE_URL data source. It defaults to a local
+// SQLite file.
+func OpenPaymentStore(c *config.Config) (service.PaymentStore, error) {
+	switch c.DatabaseDriver {
+	case "", "sqlite":
+		return NewSQLitePaymentStore(c.DatabaseURL)
+	case "postgres":
+		return newPostgresPaymentStore(c.DatabaseURL)
+	default:
+		return nil, fmt.Errorf("unknown DATABASE_DRIVER %q", c.DatabaseDriver)
+	}
+}
+
+var schema = []string{`
+CREATE TABLE IF NOT EXISTS payments (
+	session_id TEXT PRIMARY KEY,
+	payment_intent_id TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	currency TEXT NOT NULL,
+	status TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS refunds (
+	id TEXT PRIMARY KEY,
+	payment_intent_id TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	currency TEXT NOT NULL,
+	status TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS refunds_created_at ON refunds (created_at)`, `
+CREATE TABLE IF NOT EXISTS connected_accounts (
+	id TEXT PRIMARY KEY,
+	email TEXT NOT NULL,
+	charges_enabled BOOLEAN NOT NULL,
+	payouts_enabled BOOLEAN NOT NULL,
+	details_submitted BOOLEAN NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS inventory (
+	price_id TEXT PRIMARY KEY,
+	stock BIGINT NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS price_limits (
+	price_id TEXT PRIMARY KEY,
+	min_quantity BIGINT NOT NULL,
+	max_quantity BIGINT NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS inventory_reservations (
+	reservation_id TEXT NOT NULL,
+	price_id TEXT NOT NULL,
+	quantity BIGINT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (reservation_id, price_id)
+)`, `
+CREATE TABLE IF NOT EXISTS orders (
+	id TEXT PRIMARY KEY,
+	status TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	payment_intent_id TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	currency TEXT NOT NULL,
+	email TEXT NOT NULL,
+	items TEXT NOT NULL,
+	metadata TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS orders_session_id ON orders (session_id)`, `
+CREATE TABLE IF NOT EXISTS audit_log (
+	id TEXT PRIMARY KEY,
+	actor TEXT NOT NULL,
+	action TEXT NOT NULL,
+	object TEXT NOT NULL,
+	before_state TEXT NOT NULL,
+	after_state TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS audit_log_created_at ON audit_log (created_at)`, `
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id TEXT PRIMARY KEY,
+	customer_id TEXT NOT NULL,
+	price_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	current_period_start TIMESTAMP NOT NULL,
+	current_period_end TIMESTAMP NOT NULL,
+	trial_end TIMESTAMP,
+	cancel_at_period_end BOOLEAN NOT NULL,
+	canceled_at TIMESTAMP,
+	latest_invoice_status TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS subscriptions_customer_id ON subscriptions (customer_id)`, `
+CREATE TABLE IF NOT EXISTS disputes (
+	id TEXT PRIMARY KEY,
+	charge_id TEXT NOT NULL,
+	payment_intent_id TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	currency TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	status TEXT NOT NULL,
+	evidence_due_by TIMESTAMP,
+	evidence TEXT NOT NULL,
+	submitted_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS payment_risk (
+	payment_intent_id TEXT PRIMARY KEY,
+	charge_id TEXT NOT NULL,
+	risk_level TEXT NOT NULL,
+	risk_score BIGINT NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS reviews (
+	id TEXT PRIMARY KEY,
+	charge_id TEXT NOT NULL,
+	payment_intent_id TEXT NOT NULL,
+	open BOOLEAN NOT NULL,
+	opened_reason TEXT NOT NULL,
+	closed_reason TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS reviews_payment_intent_id ON reviews (payment_intent_id)`, `
+CREATE TABLE IF NOT EXISTS tax_ids (
+	session_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	value TEXT NOT NULL,
+	verification TEXT NOT NULL,
+	verified_name TEXT NOT NULL,
+	checked_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (session_id, type, value)
+)`, `
+CREATE TABLE IF NOT EXISTS stripe_events (
+	id TEXT PRIMARY KEY,
+	type TEXT NOT NULL,
+	object_id TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	payment_intent_id TEXT NOT NULL,
+	order_id TEXT NOT NULL,
+	created TIMESTAMP NOT NULL,
+	received_at TIMESTAMP NOT NULL,
+	payload TEXT NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS stripe_events_session_id ON stripe_events (session_id)`, `
+CREATE INDEX IF NOT EXISTS stripe_events_payment_intent_id ON stripe_events (payment_intent_id)`, `
+CREATE INDEX IF NOT EXISTS stripe_events_order_id ON stripe_events (order_id)`, `
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id TEXT PRIMARY KEY,
+	endpoint TEXT NOT NULL,
+	event_id TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	response_status INTEGER NOT NULL,
+	last_error TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS webhook_deliveries_created_at ON webhook_deliveries (created_at)`, `
+CREATE TABLE IF NOT EXISTS fulfillments (
+	id TEXT PRIMARY KEY,
+	order_id TEXT NOT NULL,
+	price_id TEXT NOT NULL,
+	product_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	target TEXT NOT NULL,
+	quantity BIGINT NOT NULL,
+	status TEXT NOT NULL,
+	details TEXT NOT NULL,
+	last_error TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS fulfillments_order_id ON fulfillments (order_id)`, `
+CREATE TABLE IF NOT EXISTS licenses (
+	license_key TEXT PRIMARY KEY,
+	order_id TEXT NOT NULL,
+	price_id TEXT NOT NULL,
+	product_id TEXT NOT NULL,
+	quantity BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	idempotency_key TEXT PRIMARY KEY,
+	request_hash TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	content_type TEXT NOT NULL,
+	location TEXT NOT NULL,
+	body TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at ON idempotency_keys (created_at)`, `
+CREATE TABLE IF NOT EXISTS webhook_events (
+	event_id TEXT PRIMARY KEY,
+	expires_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS webhook_events_expires_at ON webhook_events (expires_at)`, `
+CREATE TABLE IF NOT EXISTS job_queue (
+	id INTEGER PRIMARY KEY,
+	snapshot TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS carts (
+	id TEXT PRIMARY KEY,
+	status TEXT NOT NULL,
+	checkout TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	session_status TEXT NOT NULL,
+	session_url TEXT NOT NULL,
+	order_id TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS abandoned_checkouts (
+	id TEXT PRIMARY KEY,
+	status TEXT NOT NULL,
+	session_id TEXT NOT NULL UNIQUE,
+	order_id TEXT NOT NULL,
+	email TEXT NOT NULL,
+	locale TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	currency TEXT NOT NULL,
+	stripe_recovery_url TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	recovery_session_id TEXT NOT NULL,
+	recovered_amount BIGINT NOT NULL,
+	emailed_at TIMESTAMP,
+	recovered_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS abandoned_checkouts_created_at ON abandoned_checkouts (created_at)`, `
+CREATE TABLE IF NOT EXISTS invoices (
+	id TEXT PRIMARY KEY,
+	customer_id TEXT NOT NULL,
+	customer_email TEXT NOT NULL,
+	number TEXT NOT NULL,
+	status TEXT NOT NULL,
+	currency TEXT NOT NULL,
+	total BIGINT NOT NULL,
+	amount_due BIGINT NOT NULL,
+	amount_paid BIGINT NOT NULL,
+	due_date TIMESTAMP,
+	memo TEXT NOT NULL,
+	hosted_url TEXT NOT NULL,
+	pdf_url TEXT NOT NULL,
+	attempt_count BIGINT NOT NULL,
+	sent_at TIMESTAMP,
+	paid_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS invoices_status ON invoices (status, due_date)`, `
+CREATE TABLE IF NOT EXISTS usage_records (
+	id TEXT PRIMARY KEY,
+	subscription_item TEXT NOT NULL,
+	quantity BIGINT NOT NULL,
+	action TEXT NOT NULL,
+	used_at TIMESTAMP NOT NULL,
+	status TEXT NOT NULL,
+	stripe_id TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_error TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	reported_at TIMESTAMP
+)`, `
+CREATE INDEX IF NOT EXISTS usage_records_item ON usage_records (subscription_item, used_at)`, `
+CREATE TABLE IF NOT EXISTS store_credits (
+	id TEXT PRIMARY KEY,
+	code_hash TEXT NOT NULL UNIQUE,
+	last4 TEXT NOT NULL,
+	currency TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	balance BIGINT NOT NULL,
+	email TEXT NOT NULL,
+	note TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS store_credit_redemptions (
+	order_id TEXT PRIMARY KEY,
+	credit_id TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	status TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE INDEX IF NOT EXISTS store_credit_redemptions_credit ON store_credit_redemptions (credit_id, created_at)`, `
+CREATE TABLE IF NOT EXISTS authentications (
+	payment_intent_id TEXT PRIMARY KEY,
+	invoice_id TEXT NOT NULL,
+	customer_id TEXT NOT NULL,
+	email TEXT NOT NULL,
+	locale TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	currency TEXT NOT NULL,
+	status TEXT NOT NULL,
+	failures INTEGER NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY
+)`,
+}
+
+// migrations change tables created by earlier versions of schema. They run
+// once each, in order, and are recorded in schema_migrations. Append new
+// ones; never edit or reorder existing entries.
+var migrations = []string{
+	`ALTER TABLE payments ADD COLUMN metadata TEXT NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE orders ADD COLUMN shipping TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE payments ADD COLUMN amount_authorized BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE payments ADD COLUMN amount_captured BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE payments ADD COLUMN max_capturable BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE subscriptions ADD COLUMN previous_price_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE subscriptions ADD COLUMN price_changed_at TIMESTAMP`,
+}
+
+// sqlPaymentStore implements PaymentStore on top of database/sql. Queries are
+// written with ? placeholders and rewritten by bind for drivers that need
+// numbered ones.
+type sqlPaymentStore struct {
+	db   *tracedDB
+	bind func(query string) string
+}
+
+// newSQLPaymentStore creates the schema in db. system names the database
+// in traces, as OpenTelemetry's db.system.
+func newSQLPaymentStore(db *sql.DB, system string, bind func(string) string) (*sqlPaymentStore, error) {
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("creating schema: %w", err)
+		}
+	}
+	s := &sqlPaymentStore{db: &tracedDB{DB: db, system: system}, bind: bind}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating schema: %w", err)
+	}
+	return s, nil
+}
+
+func (s *sqlPaymentStore) migrate() error {
+	var version int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(s.bind(`INSERT INTO schema_migrations (version) VALUES (?)`), i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlPaymentStore) SavePayment(ctx context.Context, p *service.Payment) error {
+	now := time.Now().UTC()
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = now
+	}
+	p.UpdatedAt = now
+	metadata, err := json.Marshal(p.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.bind(`
+INSERT INTO payments (session_id, payment_intent_id, amount, currency, status, metadata, amount_authorized, amount_captured, max_capturable, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (session_id) DO UPDATE SET
+	payment_intent_id = excluded.payment_intent_id,
+	amount = excluded.amount,
+	currency = excluded.currency,
+	status = excluded.status,
+	metadata = excluded.metadata,
+	amount_authorized = excluded.amount_authorized,
+	amount_captured = excluded.amount_captured,
+	max_capturable = excluded.max_capturable,
+	updated_at = excluded.updated_at`),
+		p.SessionID, p.PaymentIntentID, p.Amount, p.Currency, p.Status, string(metadata), p.AmountAuthorized, p.AmountCaptured, p.MaxCapturable, p.CreatedAt, p.UpdatedAt)
+	return err
+}
+
+const selectPayments = `SELECT session_id, payment_intent_id, amount, currency, status, metadata,
+	COALESCE((SELECT risk_level FROM payment_risk WHERE payment_risk.payment_intent_id = payments.payment_intent_id), ''),
+	COALESCE((SELECT risk_score FROM payment_risk WHERE payment_risk.payment_intent_id = payments.payment_intent_id), 0),
+	amount_authorized, amount_captured, max_capturable, created_at, updated_at FROM payments`
+
+func (s *sqlPaymentStore) GetPayment(ctx context.Context, sessionID string) (*service.Payment, error) {
+	row := s.db.QueryRowContext(ctx, s.bind(selectPayments+` WHERE session_id = ?`), sessionID)
+	p, err := scanPayment(row)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrPaymentNotFound
+	}
+	return p, err
+}
+
+func (s *sqlPaymentStore) GetPaymentByIntent(ctx context.Context, paymentIntentID string) (*service.Payment, error) {
+	row := s.db.QueryRowContext(ctx, s.bind(selectPayments+` WHERE payment_intent_id = ?`), paymentIntentID)
+	p, err := scanPayment(row)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrPaymentNotFound
+	}
+	return p, err
+}
+
+func (s *sqlPaymentStore) ListPayments(ctx context.Context, f service.PaymentFilter) ([]*service.Payment, error) {
+	var where []string
+	var args []interface{}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Currency != "" {
+		where = append(where, "currency = ?")
+		args = append(args, f.Currency)
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.To.UTC())
+	}
+	query := selectPayments
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) SaveRefund(ctx context.Context, r *service.Refund) error {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO refunds (id, payment_intent_id, amount, currency, status, reason, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	amount = excluded.amount,
+	status = excluded.status,
+	reason = excluded.reason`),
+		r.ID, r.PaymentIntentID, r.Amount, r.Currency, r.Status, r.Reason, r.CreatedAt)
+	return err
+}
+
+func (s *sqlPaymentStore) ListRefunds(ctx context.Context, paymentIntentID string) ([]*service.Refund, error) {
+	return s.listRefunds(ctx, `payment_intent_id = ?`, paymentIntentID)
+}
+
+func (s *sqlPaymentStore) ListRefundsBetween(ctx context.Context, from, to time.Time) ([]*service.Refund, error) {
+	return s.listRefunds(ctx, `created_at >= ? AND created_at < ?`, from.UTC(), to.UTC())
+}
+
+func (s *sqlPaymentStore) listRefunds(ctx context.Context, where string, args ...interface{}) ([]*service.Refund, error) {
+	rows, err := s.db.QueryContext(ctx, s.bind(`
+SELECT id, payment_intent_id, amount, currency, status, reason, created_at
+FROM refunds WHERE `+where+` ORDER BY created_at`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.Refund
+	for rows.Next() {
+		var r service.Refund
+		if err := rows.Scan(&r.ID, &r.PaymentIntentID, &r.Amount, &r.Currency, &r.Status, &r.Reason, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, &r)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) SaveOrder(ctx context.Context, o *service.Order) error {
+	now := time.Now().UTC()
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = now
+	}
+	o.UpdatedAt = now
+	items, err := json.Marshal(o.Items)
+	if err != nil {
+		return err
+	}
+	metadata, err := json.Marshal(o.Metadata)
+	if err != nil {
+		return err
+	}
+	// Orders that don't ship store an empty string.
+	var shipping []byte
+	if o.Shipping != nil {
+		if shipping, err = json.Marshal(o.Shipping); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.ExecContext(ctx, s.bind(`
+INSERT INTO orders (id, status, session_id, payment_intent_id, amount, currency, email, items, metadata, shipping, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	status = excluded.status,
+	session_id = excluded.session_id,
+	payment_intent_id = excluded.payment_intent_id,
+	amount = excluded.amount,
+	currency = excluded.currency,
+	email = excluded.email,
+	items = excluded.items,
+	metadata = excluded.metadata,
+	shipping = excluded.shipping,
+	updated_at = excluded.updated_at`),
+		o.ID, string(o.Status), o.SessionID, o.PaymentIntentID, o.Amount, o.Currency, o.Email, string(items), string(metadata), string(shipping), o.CreatedAt, o.UpdatedAt)
+	return err
+}
+
+const selectOrders = `SELECT id, status, session_id, payment_intent_id, amount, currency, email, items, metadata, shipping, created_at, updated_at FROM orders`
+
+func (s *sqlPaymentStore) GetOrder(ctx context.Context, id string) (*service.Order, error) {
+	o, err := scanOrder(s.db.QueryRowContext(ctx, s.bind(selectOrders+` WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrOrderNotFound
+	}
+	return o, err
+}
+
+func (s *sqlPaymentStore) GetOrderBySession(ctx context.Context, sessionID string) (*service.Order, error) {
+	o, err := scanOrder(s.db.QueryRowContext(ctx, s.bind(selectOrders+` WHERE session_id = ?`), sessionID))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrOrderNotFound
+	}
+	return o, err
+}
+
+func (s *sqlPaymentStore) ListOrders(ctx context.Context, f service.OrderFilter) ([]*service.Order, error) {
+	query := selectOrders
+	var args []interface{}
+	if f.Status != "" {
+		query += " WHERE status = ?"
+		args = append(args, string(f.Status))
+	}
+	query += " ORDER BY created_at DESC, id"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) SaveConnectedAccount(ctx context.Context, a *service.ConnectedAccount) error {
+	a.UpdatedAt = time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO connected_accounts (id, email, charges_enabled, payouts_enabled, details_submitted, updated_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	email = excluded.email,
+	charges_enabled = excluded.charges_enabled,
+	payouts_enabled = excluded.payouts_enabled,
+	details_submitted = excluded.details_submitted,
+	updated_at = excluded.updated_at`),
+		a.ID, a.Email, a.ChargesEnabled, a.PayoutsEnabled, a.DetailsSubmitted, a.UpdatedAt)
+	return err
+}
+
+func (s *sqlPaymentStore) GetConnectedAccount(ctx context.Context, id string) (*service.ConnectedAccount, error) {
+	var a service.ConnectedAccount
+	err := s.db.QueryRowContext(ctx, s.bind(`
+SELECT id, email, charges_enabled, payouts_enabled, details_submitted, updated_at
+FROM connected_accounts WHERE id = ?`), id).
+		Scan(&a.ID, &a.Email, &a.ChargesEnabled, &a.PayoutsEnabled, &a.DetailsSubmitted, &a.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *sqlPaymentStore) SaveSubscription(ctx context.Context, sub *service.Subscription) error {
+	sub.UpdatedAt = time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO subscriptions (id, customer_id, price_id, status, current_period_start, current_period_end,
+	trial_end, cancel_at_period_end, canceled_at, latest_invoice_status, previous_price_id, price_changed_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	customer_id = excluded.customer_id,
+	previous_price_id = CASE WHEN subscriptions.price_id <> '' AND excluded.price_id <> '' AND subscriptions.price_id <> excluded.price_id
+		THEN subscriptions.price_id ELSE subscriptions.previous_price_id END,
+	price_changed_at = CASE WHEN subscriptions.price_id <> '' AND excluded.price_id <> '' AND subscriptions.price_id <> excluded.price_id
+		THEN excluded.updated_at ELSE subscriptions.price_changed_at END,
+	price_id = excluded.price_id,
+	status = excluded.status,
+	current_period_start = excluded.current_period_start,
+	current_period_end = excluded.current_period_end,
+	trial_end = excluded.trial_end,
+	cancel_at_period_end = excluded.cancel_at_period_end,
+	canceled_at = excluded.canceled_at,
+	updated_at = excluded.updated_at`),
+		sub.ID, sub.CustomerID, sub.PriceID, sub.Status, sub.CurrentPeriodStart.UTC(), sub.CurrentPeriodEnd.UTC(),
+		nullTime(sub.TrialEnd), sub.CancelAtPeriodEnd, nullTime(sub.CanceledAt), sub.LatestInvoiceStatus,
+		sub.PreviousPriceID, nullTime(sub.PriceChangedAt), sub.UpdatedAt)
+	return err
+}
+
+const subscriptionColumns = `id, customer_id, price_id, status, current_period_start, current_period_end,
+	trial_end, cancel_at_period_end, canceled_at, latest_invoice_status, previous_price_id, price_changed_at, updated_at`
+
+func scanSubscription(row rowScanner) (*service.Subscription, error) {
+	var sub service.Subscription
+	var trialEnd, canceledAt, priceChangedAt sql.NullTime
+	err := row.Scan(&sub.ID, &sub.CustomerID, &sub.PriceID, &sub.Status, &sub.CurrentPeriodStart, &sub.CurrentPeriodEnd,
+		&trialEnd, &sub.CancelAtPeriodEnd, &canceledAt, &sub.LatestInvoiceStatus, &sub.PreviousPriceID, &priceChangedAt, &sub.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if trialEnd.Valid {
+		sub.TrialEnd = &trialEnd.Time
+	}
+	if canceledAt.Valid {
+		sub.CanceledAt = &canceledAt.Time
+	}
+	if priceChangedAt.Valid {
+		sub.PriceChangedAt = &priceChangedAt.Time
+	}
+	return &sub, nil
+}
+
+func (s *sqlPaymentStore) GetSubscription(ctx context.Context, id string) (*service.Subscription, error) {
+	sub, err := scanSubscription(s.db.QueryRowContext(ctx, s.bind(`SELECT `+subscriptionColumns+` FROM subscriptions WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrSubscriptionNotFound
+	}
+	return sub, err
+}
+
+func (s *sqlPaymentStore) ListSubscriptions(ctx context.Context, customerID string) ([]*service.Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, s.bind(`SELECT `+subscriptionColumns+` FROM subscriptions
+WHERE customer_id = ? ORDER BY current_period_end DESC, id`), customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.Subscription
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, sub)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) SetSubscriptionInvoiceStatus(ctx context.Context, id, status string) error {
+	res, err := s.db.ExecContext(ctx, s.bind(`UPDATE subscriptions SET latest_invoice_status = ?, updated_at = ? WHERE id = ?`),
+		status, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return service.ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+func (s *sqlPaymentStore) SaveDispute(ctx context.Context, d *service.Dispute) error {
+	d.UpdatedAt = time.Now().UTC()
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = d.UpdatedAt
+	}
+	if d.Evidence == nil {
+		d.Evidence = map[string]string{}
+	}
+	evidence, err := json.Marshal(d.Evidence)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.bind(`
+INSERT INTO disputes (id, charge_id, payment_intent_id, amount, currency, reason, status,
+	evidence_due_by, evidence, submitted_at, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	charge_id = excluded.charge_id,
+	payment_intent_id = excluded.payment_intent_id,
+	amount = excluded.amount,
+	currency = excluded.currency,
+	reason = excluded.reason,
+	status = excluded.status,
+	evidence_due_by = excluded.evidence_due_by,
+	updated_at = excluded.updated_at`),
+		d.ID, d.ChargeID, d.PaymentIntentID, d.Amount, d.Currency, d.Reason, d.Status,
+		nullTime(d.EvidenceDueBy), string(evidence), nullTime(d.SubmittedAt), d.CreatedAt.UTC(), d.UpdatedAt)
+	return err
+}
+
+const disputeColumns = `id, charge_id, payment_intent_id, amount, currency, reason, status,
+	evidence_due_by, evidence, submitted_at, created_at, updated_at`
+
+func scanDispute(row rowScanner) (*service.Dispute, error) {
+	var d service.Dispute
+	var dueBy, submittedAt sql.NullTime
+	var evidence string
+	err := row.Scan(&d.ID, &d.ChargeID, &d.PaymentIntentID, &d.Amount, &d.Currency, &d.Reason, &d.Status,
+		&dueBy, &evidence, &submittedAt, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(evidence), &d.Evidence); err != nil {
+		return nil, fmt.Errorf("dispute %s evidence: %w", d.ID, err)
+	}
+	if dueBy.Valid {
+		d.EvidenceDueBy = &dueBy.Time
+	}
+	if submittedAt.Valid {
+		d.SubmittedAt = &submittedAt.Time
+	}
+	return &d, nil
+}
+
+func (s *sqlPaymentStore) GetDispute(ctx context.Context, id string) (*service.Dispute, error) {
+	d, err := scanDispute(s.db.QueryRowContext(ctx, s.bind(`SELECT `+disputeColumns+` FROM disputes WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrDisputeNotFound
+	}
+	return d, err
+}
+
+func (s *sqlPaymentStore) ListDisputes(ctx context.Context, f service.DisputeFilter) ([]*service.Dispute, error) {
+	query := `SELECT ` + disputeColumns + ` FROM disputes`
+	var args []interface{}
+	if f.Status != "" {
+		query += " WHERE status = ?"
+		args = append(args, f.Status)
+	}
+	// Disputes without a due date sort last.
+	query += " ORDER BY CASE WHEN evidence_due_by IS NULL THEN 1 ELSE 0 END, evidence_due_by, id"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.Dispute
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) SetDisputeEvidence(ctx context.Context, id string, evidence map[string]string, submittedAt *time.Time) error {
+	b, err := json.Marshal(evidence)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, s.bind(`UPDATE disputes SET evidence = ?, submitted_at = ?, updated_at = ? WHERE id = ?`),
+		string(b), nullTime(submittedAt), time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return service.ErrDisputeNotFound
+	}
+	return nil
+}
+
+func (s *sqlPaymentStore) SavePaymentRisk(ctx context.Context, r *service.PaymentRisk) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO payment_risk (payment_intent_id, charge_id, risk_level, risk_score, updated_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (payment_intent_id) DO UPDATE SET
+	charge_id = excluded.charge_id,
+	risk_level = excluded.risk_level,
+	risk_score = excluded.risk_score,
+	updated_at = excluded.updated_at`),
+		r.PaymentIntentID, r.ChargeID, r.RiskLevel, r.RiskScore, time.Now().UTC())
+	return err
+}
+
+func (s *sqlPaymentStore) SaveReview(ctx context.Context, rv *service.Review) error {
+	rv.UpdatedAt = time.Now().UTC()
+	if rv.CreatedAt.IsZero() {
+		rv.CreatedAt = rv.UpdatedAt
+	}
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO reviews (id, charge_id, payment_intent_id, open, opened_reason, closed_reason, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	charge_id = excluded.charge_id,
+	payment_intent_id = excluded.payment_intent_id,
+	open = excluded.open,
+	opened_reason = excluded.opened_reason,
+	closed_reason = excluded.closed_reason,
+	updated_at = excluded.updated_at`),
+		rv.ID, rv.ChargeID, rv.PaymentIntentID, rv.Open, rv.OpenedReason, rv.ClosedReason, rv.CreatedAt.UTC(), rv.UpdatedAt)
+	return err
+}
+
+const selectReviews = `SELECT id, charge_id, payment_intent_id, open, opened_reason, closed_reason, created_at, updated_at FROM reviews`
+
+func scanReview(row rowScanner) (*service.Review, error) {
+	var rv service.Review
+	if err := row.Scan(&rv.ID, &rv.ChargeID, &rv.PaymentIntentID, &rv.Open, &rv.OpenedReason, &rv.ClosedReason, &rv.CreatedAt, &rv.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+func (s *sqlPaymentStore) GetReview(ctx context.Context, id string) (*service.Review, error) {
+	rv, err := scanReview(s.db.QueryRowContext(ctx, s.bind(selectReviews+` WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrReviewNotFound
+	}
+	return rv, err
+}
+
+func (s *sqlPaymentStore) ListReviews(ctx context.Context, f service.ReviewFilter) ([]*service.Review, error) {
+	var where []string
+	var args []interface{}
+	if f.Open != nil {
+		where = append(where, "open = ?")
+		args = append(args, *f.Open)
+	}
+	if f.PaymentIntentID != "" {
+		where = append(where, "payment_intent_id = ?")
+		args = append(args, f.PaymentIntentID)
+	}
+	query := selectReviews
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.Review
+	for rows.Next() {
+		rv, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rv)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) AddTaxIDs(ctx context.Context, ids []*service.TaxID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	for _, t := range ids {
+		if t.CreatedAt.IsZero() {
+			t.CreatedAt = now
+		}
+		if _, err := tx.ExecContext(ctx, s.bind(`
+INSERT INTO tax_ids (session_id, type, value, verification, verified_name, checked_at, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (session_id, type, value) DO NOTHING`),
+			t.SessionID, t.Type, t.Value, t.Verification, t.VerifiedName, t.CheckedAt, t.CreatedAt.UTC()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlPaymentStore) UpdateTaxIDVerification(ctx context.Context, t *service.TaxID) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`
+UPDATE tax_ids SET verification = ?, verified_name = ?, checked_at = ?
+WHERE session_id = ? AND type = ? AND value = ?`),
+		t.Verification, t.VerifiedName, t.CheckedAt, t.SessionID, t.Type, t.Value)
+	return err
+}
+
+func (s *sqlPaymentStore) ListTaxIDs(ctx context.Context, sessionID string) ([]*service.TaxID, error) {
+	rows, err := s.db.QueryContext(ctx, s.bind(`
+SELECT session_id, type, value, verification, verified_name, checked_at, created_at
+FROM tax_ids WHERE session_id = ? ORDER BY created_at, type, value`), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.TaxID
+	for rows.Next() {
+		var t service.TaxID
+		if err := rows.Scan(&t.SessionID, &t.Type, &t.Value, &t.Verification, &t.VerifiedName, &t.CheckedAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, &t)
+	}
+	return list, rows.Err()
+}
+
+// nullTime stores a missing time as NULL.
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func (s *sqlPaymentStore) AppendStoredEvent(ctx context.Context, e *service.StoredEvent) error {
+	if e.ReceivedAt.IsZero() {
+		e.ReceivedAt = time.Now().UTC()
+	}
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO stripe_events (id, type, object_id, session_id, payment_intent_id, order_id, created, received_at, payload)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO NOTHING`),
+		e.ID, e.Type, e.ObjectID, e.SessionID, e.PaymentIntentID, e.OrderID, e.Created.UTC(), e.ReceivedAt.UTC(), string(e.Payload))
+	return err
+}
+
+func (s *sqlPaymentStore) ListStoredEvents(ctx context.Context, f service.StoredEventFilter) ([]*service.StoredEvent, error) {
+	var where []string
+	var args []interface{}
+	for _, c := range []struct{ column, value string }{
+		{"order_id", f.OrderID},
+		{"session_id", f.SessionID},
+		{"payment_intent_id", f.PaymentIntentID},
+	} {
+		if c.value != "" {
+			where = append(where, c.column+" = ?")
+			args = append(args, c.value)
+		}
+	}
+	query := `SELECT id, type, object_id, session_id, payment_intent_id, order_id, created, received_at, payload FROM stripe_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " OR ")
+	}
+	// Stripe's timestamps only have seconds, so events created in the same
+	// second are kept in the order they arrived.
+	query += " ORDER BY created, received_at, id"
+	rows, err := s.db.QueryContext(ctx, s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.StoredEvent
+	for rows.Next() {
+		var e service.StoredEvent
+		var payload string
+		if err := rows.Scan(&e.ID, &e.Type, &e.ObjectID, &e.SessionID, &e.PaymentIntentID, &e.OrderID, &e.Created, &e.ReceivedAt, &payload); err != nil {
+			return nil, err
+		}
+		e.Payload = json.RawMessage(payload)
+		list = append(list, &e)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) SaveWebhookDelivery(ctx context.Context, d *service.WebhookDelivery) error {
+	d.UpdatedAt = time.Now().UTC()
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = d.UpdatedAt
+	}
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO webhook_deliveries (id, endpoint, event_id, event_type, payload, status, attempts,
+	response_status, last_error, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	status = excluded.status,
+	attempts = excluded.attempts,
+	response_status = excluded.response_status,
+	last_error = excluded.last_error,
+	updated_at = excluded.updated_at`),
+		d.ID, d.Endpoint, d.EventID, d.EventType, string(d.Payload), d.Status, d.Attempts,
+		d.ResponseStatus, d.LastError, d.CreatedAt.UTC(), d.UpdatedAt)
+	return err
+}
+
+const deliveryColumns = `id, endpoint, event_id, event_type, payload, status, attempts,
+	response_status, last_error, created_at, updated_at`
+
+func scanWebhookDelivery(row rowScanner) (*service.WebhookDelivery, error) {
+	var d service.WebhookDelivery
+	var payload string
+	err := row.Scan(&d.ID, &d.Endpoint, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.Payload = json.RawMessage(payload)
+	return &d, nil
+}
+
+func (s *sqlPaymentStore) GetWebhookDelivery(ctx context.Context, id string) (*service.WebhookDelivery, error) {
+	d, err := scanWebhookDelivery(s.db.QueryRowContext(ctx, s.bind(`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrDeliveryNotFound
+	}
+	return d, err
+}
+
+func (s *sqlPaymentStore) ListWebhookDeliveries(ctx context.Context, f service.WebhookDeliveryFilter) ([]*service.WebhookDelivery, error) {
+	var where []string
+	var args []interface{}
+	if f.Endpoint != "" {
+		where = append(where, "endpoint = ?")
+		args = append(args, f.Endpoint)
+	}
+	if f.EventID != "" {
+		where = append(where, "event_id = ?")
+		args = append(args, f.EventID)
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) SaveFulfillment(ctx context.Context, f *service.Fulfillment) error {
+	f.UpdatedAt = time.Now().UTC()
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = f.UpdatedAt
+	}
+	details, err := json.Marshal(f.Details)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.bind(`
+INSERT INTO fulfillments (id, order_id, price_id, product_id, type, target, quantity, status,
+	details, last_error, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	status = excluded.status,
+	details = excluded.details,
+	last_error = excluded.last_error,
+	updated_at = excluded.updated_at`),
+		f.ID, f.OrderID, f.Price, f.Product, f.Type, f.Target, f.Quantity, f.Status,
+		string(details), f.LastError, f.CreatedAt.UTC(), f.UpdatedAt)
+	return err
+}
+
+const fulfillmentColumns = `id, order_id, price_id, product_id, type, target, quantity, status,
+	details, last_error, created_at, updated_at`
+
+func scanFulfillment(row rowScanner) (*service.Fulfillment, error) {
+	var f service.Fulfillment
+	var details string
+	err := row.Scan(&f.ID, &f.OrderID, &f.Price, &f.Product, &f.Type, &f.Target, &f.Quantity, &f.Status,
+		&details, &f.LastError, &f.CreatedAt, &f.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(details), &f.Details); err != nil {
+		return nil, fmt.Errorf("fulfillment %s details: %w", f.ID, err)
+	}
+	return &f, nil
+}
+
+func (s *sqlPaymentStore) GetFulfillment(ctx context.Context, id string) (*service.Fulfillment, error) {
+	f, err := scanFulfillment(s.db.QueryRowContext(ctx, s.bind(`SELECT `+fulfillmentColumns+` FROM fulfillments WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrFulfillmentNotFound
+	}
+	return f, err
+}
+
+func (s *sqlPaymentStore) ListFulfillments(ctx context.Context, orderID string) ([]*service.Fulfillment, error) {
+	rows, err := s.db.QueryContext(ctx, s.bind(`SELECT `+fulfillmentColumns+` FROM fulfillments WHERE order_id = ? ORDER BY created_at, id`), orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.Fulfillment
+	for rows.Next() {
+		f, err := scanFulfillment(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) SaveLicense(ctx context.Context, l *service.License) error {
+	if l.CreatedAt.IsZero() {
+		l.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO licenses (license_key, order_id, price_id, product_id, quantity, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (license_key) DO UPDATE SET quantity = excluded.quantity`),
+		l.Key, l.OrderID, l.Price, l.Product, l.Quantity, l.CreatedAt.UTC())
+	return err
+}
+
+func (s *sqlPaymentStore) GetLicense(ctx context.Context, key string) (*service.License, error) {
+	var l service.License
+	err := s.db.QueryRowContext(ctx, s.bind(`
+SELECT license_key, order_id, price_id, product_id, quantity, created_at FROM licenses WHERE license_key = ?`), key).
+		Scan(&l.Key, &l.OrderID, &l.Price, &l.Product, &l.Quantity, &l.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrLicenseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (s *sqlPaymentStore) SaveCart(ctx context.Context, c *service.Cart) error {
+	c.UpdatedAt = time.Now().UTC()
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = c.UpdatedAt
+	}
+	checkout, err := json.Marshal(c.Checkout)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.bind(`
+INSERT INTO carts (id, status, checkout, session_id, session_status, session_url, order_id, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	status = excluded.status,
+	session_id = excluded.session_id,
+	session_status = excluded.session_status,
+	session_url = excluded.session_url,
+	order_id = excluded.order_id,
+	updated_at = excluded.updated_at`),
+		c.ID, c.Status, string(checkout), c.SessionID, c.SessionStatus, c.SessionURL, c.OrderID, c.CreatedAt.UTC(), c.UpdatedAt)
+	return err
+}
+
+func (s *sqlPaymentStore) GetCart(ctx context.Context, id string) (*service.Cart, error) {
+	var c service.Cart
+	var checkout string
+	err := s.db.QueryRowContext(ctx, s.bind(`
+SELECT id, status, checkout, session_id, session_status, session_url, order_id, created_at, updated_at
+FROM carts WHERE id = ?`), id).
+		Scan(&c.ID, &c.Status, &checkout, &c.SessionID, &c.SessionStatus, &c.SessionURL, &c.OrderID, &c.CreatedAt, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrCartNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(checkout), &c.Checkout); err != nil {
+		return nil, fmt.Errorf("cart %s checkout: %w", c.ID, err)
+	}
+	return &c, nil
+}
+
+func (s *sqlPaymentStore) SaveAbandonedCheckout(ctx context.Context, a *service.AbandonedCheckout) error {
+	a.UpdatedAt = time.Now().UTC()
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = a.UpdatedAt
+	}
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO abandoned_checkouts (id, status, session_id, order_id, email, locale, amount, currency, stripe_recovery_url,
+	expires_at, recovery_session_id, recovered_amount, emailed_at, recovered_at, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	status = excluded.status,
+	recovery_session_id = excluded.recovery_session_id,
+	recovered_amount = excluded.recovered_amount,
+	emailed_at = excluded.emailed_at,
+	recovered_at = excluded.recovered_at,
+	updated_at = excluded.updated_at`),
+		a.ID, a.Status, a.SessionID, a.OrderID, a.Email, a.Locale, a.Amount, a.Currency, a.StripeRecoveryURL,
+		a.ExpiresAt.UTC(), a.RecoverySessionID, a.RecoveredAmount, nullTime(a.EmailedAt), nullTime(a.RecoveredAt), a.CreatedAt.UTC(), a.UpdatedAt)
+	return err
+}
+
+const abandonedCheckoutColumns = `id, status, session_id, order_id, email, locale, amount, currency, stripe_recovery_url,
+	expires_at, recovery_session_id, recovered_amount, emailed_at, recovered_at, created_at, updated_at`
+
+func scanAbandonedCheckout(row rowScanner) (*service.AbandonedCheckout, error) {
+	var a service.AbandonedCheckout
+	var emailedAt, recoveredAt sql.NullTime
+	err := row.Scan(&a.ID, &a.Status, &a.SessionID, &a.OrderID, &a.Email, &a.Locale, &a.Amount, &a.Currency, &a.StripeRecoveryURL,
+		&a.ExpiresAt, &a.RecoverySessionID, &a.RecoveredAmount, &emailedAt, &recoveredAt, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if emailedAt.Valid {
+		a.EmailedAt = &emailedAt.Time
+	}
+	if recoveredAt.Valid {
+		a.RecoveredAt = &recoveredAt.Time
+	}
+	return &a, nil
+}
+
+func (s *sqlPaymentStore) GetAbandonedCheckout(ctx context.Context, id string) (*service.AbandonedCheckout, error) {
+	a, err := scanAbandonedCheckout(s.db.QueryRowContext(ctx, s.bind(`SELECT `+abandonedCheckoutColumns+` FROM abandoned_checkouts WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrAbandonedCheckoutNotFound
+	}
+	return a, err
+}
+
+func (s *sqlPaymentStore) GetAbandonedCheckoutBySession(ctx context.Context, sessionID string) (*service.AbandonedCheckout, error) {
+	a, err := scanAbandonedCheckout(s.db.QueryRowContext(ctx, s.bind(`SELECT `+abandonedCheckoutColumns+` FROM abandoned_checkouts WHERE session_id = ?`), sessionID))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrAbandonedCheckoutNotFound
+	}
+	return a, err
+}
+
+func (s *sqlPaymentStore) ListAbandonedCheckouts(ctx context.Context, from, to time.Time) ([]*service.AbandonedCheckout, error) {
+	var where []string
+	var args []interface{}
+	if !from.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, to.UTC())
+	}
+	query := `SELECT ` + abandonedCheckoutColumns + ` FROM abandoned_checkouts`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := s.db.QueryContext(ctx, s.bind(query+" ORDER BY created_at DESC, id"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.AbandonedCheckout
+	for rows.Next() {
+		a, err := scanAbandonedCheckout(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) SaveInvoice(ctx context.Context, inv *service.Invoice) error {
+	inv.UpdatedAt = time.Now().UTC()
+	if inv.CreatedAt.IsZero() {
+		inv.CreatedAt = inv.UpdatedAt
+	}
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO invoices (id, customer_id, customer_email, number, status, currency, total, amount_due, amount_paid,
+	due_date, memo, hosted_url, pdf_url, attempt_count, sent_at, paid_at, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	customer_email = excluded.customer_email,
+	number = excluded.number,
+	status = excluded.status,
+	currency = excluded.currency,
+	total = excluded.total,
+	amount_due = excluded.amount_due,
+	amount_paid = excluded.amount_paid,
+	due_date = excluded.due_date,
+	memo = excluded.memo,
+	hosted_url = excluded.hosted_url,
+	pdf_url = excluded.pdf_url,
+	attempt_count = excluded.attempt_count,
+	sent_at = excluded.sent_at,
+	paid_at = excluded.paid_at,
+	updated_at = excluded.updated_at`),
+		inv.ID, inv.CustomerID, inv.CustomerEmail, inv.Number, inv.Status, inv.Currency, inv.Total, inv.AmountDue, inv.AmountPaid,
+		nullTime(inv.DueDate), inv.Memo, inv.HostedURL, inv.PDFURL, inv.AttemptCount, nullTime(inv.SentAt), nullTime(inv.PaidAt),
+		inv.CreatedAt.UTC(), inv.UpdatedAt)
+	return err
+}
+
+const invoiceColumns = `id, customer_id, customer_email, number, status, currency, total, amount_due, amount_paid,
+	due_date, memo, hosted_url, pdf_url, attempt_count, sent_at, paid_at, created_at, updated_at`
+
+func scanInvoice(row rowScanner) (*service.Invoice, error) {
+	var inv service.Invoice
+	var dueDate, sentAt, paidAt sql.NullTime
+	err := row.Scan(&inv.ID, &inv.CustomerID, &inv.CustomerEmail, &inv.Number, &inv.Status, &inv.Currency, &inv.Total, &inv.AmountDue, &inv.AmountPaid,
+		&dueDate, &inv.Memo, &inv.HostedURL, &inv.PDFURL, &inv.AttemptCount, &sentAt, &paidAt, &inv.CreatedAt, &inv.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if dueDate.Valid {
+		inv.DueDate = &dueDate.Time
+	}
+	if sentAt.Valid {
+		inv.SentAt = &sentAt.Time
+	}
+	if paidAt.Valid {
+		inv.PaidAt = &paidAt.Time
+	}
+	return &inv, nil
+}
+
+func (s *sqlPaymentStore) GetInvoice(ctx context.Context, id string) (*service.Invoice, error) {
+	inv, err := scanInvoice(s.db.QueryRowContext(ctx, s.bind(`SELECT `+invoiceColumns+` FROM invoices WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrInvoiceNotFound
+	}
+	return inv, err
+}
+
+func (s *sqlPaymentStore) ListInvoices(ctx context.Context, f service.InvoiceFilter) ([]*service.Invoice, error) {
+	var where []string
+	var args []interface{}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.CustomerID != "" {
+		where = append(where, "customer_id = ?")
+		args = append(args, f.CustomerID)
+	}
+	if !f.DueBefore.IsZero() {
+		where = append(where, "due_date < ?")
+		args = append(args, f.DueBefore.UTC())
+	}
+	query := `SELECT ` + invoiceColumns + ` FROM invoices`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Invoices without a due date sort last.
+	query += " ORDER BY CASE WHEN due_date IS NULL THEN 1 ELSE 0 END, due_date, created_at, id"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.Invoice
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, inv)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) AddUsageRecord(ctx context.Context, u *service.UsageRecord) (bool, error) {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now().UTC()
+	}
+	res, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO usage_records (id, subscription_item, quantity, action, used_at, status, stripe_id, attempts, last_error,
+	created_at, reported_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO NOTHING`),
+		u.ID, u.SubscriptionItem, u.Quantity, u.Action, u.Timestamp.UTC(), u.Status, u.StripeID, u.Attempts, u.LastError,
+		u.CreatedAt.UTC(), nullTime(u.ReportedAt))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+const usageRecordColumns = `id, subscription_item, quantity, action, used_at, status, stripe_id, attempts, last_error,
+	created_at, reported_at`
+
+func scanUsageRecord(row rowScanner) (*service.UsageRecord, error) {
+	var u service.UsageRecord
+	var reportedAt sql.NullTime
+	err := row.Scan(&u.ID, &u.SubscriptionItem, &u.Quantity, &u.Action, &u.Timestamp, &u.Status, &u.StripeID, &u.Attempts, &u.LastError,
+		&u.CreatedAt, &reportedAt)
+	if err != nil {
+		return nil, err
+	}
+	if reportedAt.Valid {
+		u.ReportedAt = &reportedAt.Time
+	}
+	return &u, nil
+}
+
+func (s *sqlPaymentStore) GetUsageRecord(ctx context.Context, id string) (*service.UsageRecord, error) {
+	u, err := scanUsageRecord(s.db.QueryRowContext(ctx, s.bind(`SELECT `+usageRecordColumns+` FROM usage_records WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, service.ErrUsageRecordNotFound
+	}
+	return u, err
+}
+
+func (s *sqlPaymentStore) UpdateUsageRecord(ctx context.Context, u *service.UsageRecord) error {
+	res, err := s.db.ExecContext(ctx, s.bind(`
+UPDATE usage_records SET status = ?, stripe_id = ?, attempts = ?, last_error = ?, reported_at = ? WHERE id = ?`),
+		u.Status, u.StripeID, u.Attempts, u.LastError, nullTime(u.ReportedAt), u.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return service.ErrUsageRecordNotFound
+	}
+	return nil
+}
+
+func (s *sqlPaymentStore) ListUsageRecords(ctx context.Context, f service.UsageRecordFilter) ([]*service.UsageRecord, error) {
+	var where []string
+	var args []interface{}
+	if f.SubscriptionItem != "" {
+		where = append(where, "subscription_item = ?")
+		args = append(args, f.SubscriptionItem)
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if !f.From.IsZero() {
+		where = append(where, "used_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where = append(where, "used_at < ?")
+		args = append(args, f.To.UTC())
+	}
+	query := `SELECT ` + usageRecordColumns + ` FROM usage_records`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at, id"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.UsageRecord
+	for rows.Next() {
+		u, err := scanUsageRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) CreateStoreCredit(ctx context.Context, c *service.StoreCredit, codeHash string) error {
+	now := time.Now().UTC()
+	c.CreatedAt, c.UpdatedAt = now, now
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO store_credits (id, code_hash, last4, currency, amount, balance, email, note, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		c.ID, codeHash, c.Last4, c.Currency, c.Amount, c.Balance, c.Email, c.Note, c.CreatedAt, c.UpdatedAt)
+	return err
+}
+
+const storeCreditColumns = `id, last4, currency, amount, balance, email, note, created_at, updated_at`
+
+func scanStoreCredit(row rowScanner) (*service.StoreCredit, error) {
+	var c service.StoreCredit
+	err := row.Scan(&c.ID, &c.Last4, &c.Currency, &c.Amount, &c.Balance, &c.Email, &c.Note, &c.CreatedAt, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrStoreCreditNotFound
+	}
+	return &c, err
+}
+
+func (s *sqlPaymentStore) GetStoreCredit(ctx context.Context, id string) (*service.StoreCredit, error) {
+	return scanStoreCredit(s.db.QueryRowContext(ctx, s.bind(`SELECT `+storeCreditColumns+` FROM store_credits WHERE id = ?`), id))
+}
+
+func (s *sqlPaymentStore) GetStoreCreditByCode(ctx context.Context, codeHash string) (*service.StoreCredit, error) {
+	return scanStoreCredit(s.db.QueryRowContext(ctx, s.bind(`SELECT `+storeCreditColumns+` FROM store_credits WHERE code_hash = ?`), codeHash))
+}
+
+func (s *sqlPaymentStore) ListStoreCredits(ctx context.Context, f service.StoreCreditFilter) ([]*service.StoreCredit, error) {
+	query := `SELECT ` + storeCreditColumns + ` FROM store_credits ORDER BY created_at DESC, id DESC`
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.bind(query))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.StoreCredit
+	for rows.Next() {
+		c, err := scanStoreCredit(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) HoldStoreCredit(ctx context.Context, r *service.StoreCreditRedemption) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	// The balance is checked by the update itself, so two checkouts can't
+	// both spend the last of it.
+	res, err := tx.ExecContext(ctx, s.bind(`
+UPDATE store_credits SET balance = balance - ?, updated_at = ? WHERE id = ? AND balance >= ?`),
+		r.Amount, now, r.CreditID, r.Amount)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return service.ErrInsufficientStoreCredit
+	}
+	r.CreatedAt, r.UpdatedAt = now, now
+	if _, err := tx.ExecContext(ctx, s.bind(`
+INSERT INTO store_credit_redemptions (order_id, credit_id, amount, status, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?)`),
+		r.OrderID, r.CreditID, r.Amount, r.Status, r.CreatedAt, r.UpdatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const redemptionColumns = `order_id, credit_id, amount, status, created_at, updated_at`
+
+func scanRedemption(row rowScanner) (*service.StoreCreditRedemption, error) {
+	var r service.StoreCreditRedemption
+	if err := row.Scan(&r.OrderID, &r.CreditID, &r.Amount, &r.Status, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *sqlPaymentStore) SettleStoreCredit(ctx context.Context, orderID, status string) (*service.StoreCreditRedemption, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	// Only a held redemption moves, so settling twice changes nothing.
+	res, err := tx.ExecContext(ctx, s.bind(`
+UPDATE store_credit_redemptions SET status = ?, updated_at = ? WHERE order_id = ? AND status = ?`),
+		status, now, orderID, service.CreditHeld)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, service.ErrRedemptionNotFound
+	}
+	r, err := scanRedemption(tx.QueryRowContext(ctx, s.bind(`SELECT `+redemptionColumns+` FROM store_credit_redemptions WHERE order_id = ?`), orderID))
+	if err != nil {
+		return nil, err
+	}
+	if status == service.CreditReleased {
+		if _, err := tx.ExecContext(ctx, s.bind(`
+UPDATE store_credits SET balance = balance + ?, updated_at = ? WHERE id = ?`), r.Amount, now, r.CreditID); err != nil {
+			return nil, err
+		}
+	}
+	return r, tx.Commit()
+}
+
+func (s *sqlPaymentStore) ListStoreCreditRedemptions(ctx context.Context, creditID string) ([]*service.StoreCreditRedemption, error) {
+	rows, err := s.db.QueryContext(ctx, s.bind(`
+SELECT `+redemptionColumns+` FROM store_credit_redemptions WHERE credit_id = ? ORDER BY created_at, order_id`), creditID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.StoreCreditRedemption
+	for rows.Next() {
+		r, err := scanRedemption(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) CreateAuthentication(ctx context.Context, a *service.Authentication) (bool, error) {
+	now := time.Now().UTC()
+	a.CreatedAt, a.UpdatedAt = now, now
+	res, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO authentications (payment_intent_id, invoice_id, customer_id, email, locale, amount, currency, status, failures,
+	expires_at, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (payment_intent_id) DO NOTHING`),
+		a.PaymentIntentID, a.InvoiceID, a.CustomerID, a.Email, a.Locale, a.Amount, a.Currency, a.Status, a.Failures,
+		a.ExpiresAt.UTC(), a.CreatedAt, a.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqlPaymentStore) GetAuthentication(ctx context.Context, paymentIntentID string) (*service.Authentication, error) {
+	var a service.Authentication
+	err := s.db.QueryRowContext(ctx, s.bind(`
+SELECT payment_intent_id, invoice_id, customer_id, email, locale, amount, currency, status, failures,
+	expires_at, created_at, updated_at
+FROM authentications WHERE payment_intent_id = ?`), paymentIntentID).Scan(
+		&a.PaymentIntentID, &a.InvoiceID, &a.CustomerID, &a.Email, &a.Locale, &a.Amount, &a.Currency, &a.Status, &a.Failures,
+		&a.ExpiresAt, &a.CreatedAt, &a.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrAuthenticationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *sqlPaymentStore) UpdateAuthentication(ctx context.Context, a *service.Authentication) error {
+	a.UpdatedAt = time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, s.bind(`
+UPDATE authentications SET status = ?, failures = ?, updated_at = ? WHERE payment_intent_id = ?`),
+		a.Status, a.Failures, a.UpdatedAt, a.PaymentIntentID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return service.ErrAuthenticationNotFound
+	}
+	return nil
+}
+
+func (s *sqlPaymentStore) BeginIdempotentRequest(ctx context.Context, key, requestHash string, expiredBefore time.Time) (*service.IdempotentResponse, error) {
+	if _, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM idempotency_keys WHERE created_at < ?`), expiredBefore.UTC()); err != nil {
+		return nil, err
+	}
+	res, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO idempotency_keys (idempotency_key, request_hash, status, content_type, location, body, created_at)
+VALUES (?, ?, 0, '', '', '', ?)
+ON CONFLICT (idempotency_key) DO NOTHING`),
+		key, requestHash, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return nil, err
+	}
+	r := &service.IdempotentResponse{Key: key}
+	var body string
+	err = s.db.QueryRowContext(ctx, s.bind(`
+SELECT request_hash, status, content_type, location, body, created_at
+FROM idempotency_keys WHERE idempotency_key = ?`), key).
+		Scan(&r.RequestHash, &r.Status, &r.ContentType, &r.Location, &body, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = []byte(body)
+	return r, nil
+}
+
+func (s *sqlPaymentStore) CompleteIdempotentRequest(ctx context.Context, r *service.IdempotentResponse) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`
+UPDATE idempotency_keys SET status = ?, content_type = ?, location = ?, body = ?
+WHERE idempotency_key = ?`),
+		r.Status, r.ContentType, r.Location, string(r.Body), r.Key)
+	return err
+}
+
+func (s *sqlPaymentStore) ReleaseIdempotentRequest(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM idempotency_keys WHERE idempotency_key = ?`), key)
+	return err
+}
+
+func (s *sqlPaymentStore) SetStock(ctx context.Context, priceID string, stock int64) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO inventory (price_id, stock, updated_at) VALUES (?, ?, ?)
+ON CONFLICT (price_id) DO UPDATE SET stock = excluded.stock, updated_at = excluded.updated_at`),
+		priceID, stock, time.Now().UTC())
+	return err
+}
+
+func (s *sqlPaymentStore) SeedStock(ctx context.Context, priceID string, stock int64) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO inventory (price_id, stock, updated_at) VALUES (?, ?, ?)
+ON CONFLICT (price_id) DO NOTHING`),
+		priceID, stock, time.Now().UTC())
+	return err
+}
+
+func (s *sqlPaymentStore) ListInventory(ctx context.Context) ([]*service.InventoryItem, error) {
+	rows, err := s.db.QueryContext(ctx, s.bind(`
+SELECT i.price_id, i.stock, COALESCE(SUM(r.quantity), 0), i.updated_at
+FROM inventory i
+LEFT JOIN inventory_reservations r ON r.price_id = i.price_id AND r.expires_at > ?
+GROUP BY i.price_id, i.stock, i.updated_at
+ORDER BY i.price_id`), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []*service.InventoryItem{}
+	for rows.Next() {
+		var item service.InventoryItem
+		if err := rows.Scan(&item.Price, &item.Stock, &item.Reserved, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		item.Available = item.Stock - item.Reserved
+		if item.Available < 0 {
+			item.Available = 0
+		}
+		list = append(list, &item)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) SetPriceLimit(ctx context.Context, l *config.PriceLimit) error {
+	now := time.Now().UTC()
+	l.UpdatedAt = &now
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO price_limits (price_id, min_quantity, max_quantity, updated_at) VALUES (?, ?, ?, ?)
+ON CONFLICT (price_id) DO UPDATE SET
+	min_quantity = excluded.min_quantity,
+	max_quantity = excluded.max_quantity,
+	updated_at = excluded.updated_at`),
+		l.Price, l.MinQuantity, l.MaxQuantity, now)
+	return err
+}
+
+func (s *sqlPaymentStore) DeletePriceLimit(ctx context.Context, priceID string) error {
+	res, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM price_limits WHERE price_id = ?`), priceID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return service.ErrPriceLimitNotFound
+	}
+	return nil
+}
+
+func (s *sqlPaymentStore) ListPriceLimits(ctx context.Context) ([]*config.PriceLimit, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT price_id, min_quantity, max_quantity, updated_at FROM price_limits ORDER BY price_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []*config.PriceLimit{}
+	for rows.Next() {
+		l := config.PriceLimit{Source: "database"}
+		if err := rows.Scan(&l.Price, &l.MinQuantity, &l.MaxQuantity, &l.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, &l)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) Reserve(ctx context.Context, id string, quantities map[string]int64, expiresAt time.Time) (bool, error) {
+	prices := make([]string, 0, len(quantities))
+	for price := range quantities {
+		prices = append(prices, price)
+	}
+	// A fixed lock order keeps concurrent reservations from deadlocking.
+	sort.Strings(prices)
+
+	now := time.Now().UTC()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	reserved := false
+	for _, price := range prices {
+		// The no-op update locks the row until the reservation is written.
+		res, err := tx.ExecContext(ctx, s.bind(`UPDATE inventory SET stock = stock WHERE price_id = ?`), price)
+		if err != nil {
+			return false, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		var stock, held int64
+		err = tx.QueryRowContext(ctx, s.bind(`
+SELECT i.stock, COALESCE((SELECT SUM(quantity) FROM inventory_reservations WHERE price_id = i.price_id AND expires_at > ?), 0)
+FROM inventory i WHERE i.price_id = ?`), now, price).Scan(&stock, &held)
+		if err != nil {
+			return false, err
+		}
+		if available := stock - held; available < quantities[price] {
+			if available < 0 {
+				available = 0
+			}
+			return false, &service.OutOfStockError{Price: price, Available: available}
+		}
+		if _, err := tx.ExecContext(ctx, s.bind(`
+INSERT INTO inventory_reservations (reservation_id, price_id, quantity, expires_at) VALUES (?, ?, ?, ?)`),
+			id, price, quantities[price], expiresAt.UTC()); err != nil {
+			return false, err
+		}
+		reserved = true
+	}
+	return reserved, tx.Commit()
+}
+
+func (s *sqlPaymentStore) RenameReservation(ctx context.Context, from, to string) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`UPDATE inventory_reservations SET reservation_id = ? WHERE reservation_id = ?`), to, from)
+	return err
+}
+
+func (s *sqlPaymentStore) ExtendReservation(ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`UPDATE inventory_reservations SET expires_at = ? WHERE reservation_id = ?`), expiresAt.UTC(), id)
+	return err
+}
+
+func (s *sqlPaymentStore) CommitReservation(ctx context.Context, id string, bought map[string]int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, s.bind(`SELECT price_id, quantity FROM inventory_reservations WHERE reservation_id = ?`), id)
+	if err != nil {
+		return err
+	}
+	quantities := map[string]int64{}
+	for rows.Next() {
+		var price string
+		var quantity int64
+		if err := rows.Scan(&price, &quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		if n, ok := bought[price]; bought != nil && (!ok || n < quantity) {
+			quantity = n
+		}
+		quantities[price] = quantity
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for price, quantity := range quantities {
+		// Stock lowered by an admin in the meantime bottoms out at zero.
+		if _, err := tx.ExecContext(ctx, s.bind(`
+UPDATE inventory SET stock = CASE WHEN stock > ? THEN stock - ? ELSE 0 END, updated_at = ?
+WHERE price_id = ?`), quantity, quantity, now, price); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, s.bind(`DELETE FROM inventory_reservations WHERE reservation_id = ?`), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlPaymentStore) ReleaseReservation(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM inventory_reservations WHERE reservation_id = ?`), id)
+	return err
+}
+
+func (s *sqlPaymentStore) AppendAudit(ctx context.Context, e *service.AuditEntry) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO audit_log (id, actor, action, object, before_state, after_state, request_id, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		e.ID, e.Actor, e.Action, e.Object, string(e.Before), string(e.After), e.RequestID, e.CreatedAt)
+	return err
+}
+
+func (s *sqlPaymentStore) ListAudit(ctx context.Context, f service.AuditFilter) ([]*service.AuditEntry, error) {
+	var where []string
+	var args []interface{}
+	for _, c := range []struct{ column, value string }{
+		{"actor", f.Actor},
+		{"action", f.Action},
+		{"object", f.Object},
+	} {
+		if c.value != "" {
+			where = append(where, c.column+" = ?")
+			args = append(args, c.value)
+		}
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.To.UTC())
+	}
+	query := `SELECT id, actor, action, object, before_state, after_state, request_id, created_at FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*service.AuditEntry
+	for rows.Next() {
+		var e service.AuditEntry
+		var before, after string
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Object, &before, &after, &e.RequestID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		// Empty states are stored as "" and left out of the JSON.
+		if before != "" {
+			e.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			e.After = json.RawMessage(after)
+		}
+		list = append(list, &e)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPaymentStore) ClaimEvent(ctx context.Context, eventID string, expiresAt time.Time) (bool, error) {
+	if _, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM webhook_events WHERE expires_at < ?`), time.Now().UTC()); err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO webhook_events (event_id, expires_at) VALUES (?, ?)
+ON CONFLICT (event_id) DO NOTHING`), eventID, expiresAt.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqlPaymentStore) CompleteEvent(ctx context.Context, eventID string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`UPDATE webhook_events SET expires_at = ? WHERE event_id = ?`), expiresAt.UTC(), eventID)
+	return err
+}
+
+func (s *sqlPaymentStore) ReleaseEvent(ctx context.Context, eventID string) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM webhook_events WHERE event_id = ?`), eventID)
+	return err
+}
+
+// The job queue is a single row, as the queue is saved whole after every
+// change.
+func (s *sqlPaymentStore) SaveJobQueue(ctx context.Context, snapshot []byte) error {
+	_, err := s.db.ExecContext(ctx, s.bind(`
+INSERT INTO job_queue (id, snapshot, updated_at) VALUES (1, ?, ?)
+ON CONFLICT (id) DO UPDATE SET snapshot = excluded.snapshot, updated_at = excluded.updated_at`),
+		string(snapshot), time.Now().UTC())
+	return err
+}
+
+func (s *sqlPaymentStore) LoadJobQueue(ctx context.Context) ([]byte, error) {
+	var snapshot string
+	err := s.db.QueryRowContext(ctx, `SELECT snapshot FROM job_queue WHERE id = 1`).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(snapshot), nil
+}
+
+func (s *sqlPaymentStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlPaymentStore) Close() error {
+	return s.db.Close()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPayment(row rowScanner) (*service.Payment, error) {
+	var p service.Payment
+	var metadata string
+	if err := row.Scan(&p.SessionID, &p.PaymentIntentID, &p.Amount, &p.Currency, &p.Status, &metadata, &p.RiskLevel, &p.RiskScore, &p.AmountAuthorized, &p.AmountCaptured, &p.MaxCapturable, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(metadata), &p.Metadata); err != nil {
+		return nil, fmt.Errorf("payment %s: decoding metadata: %w", p.SessionID, err)
+	}
+	return &p, nil
+}
+
+func scanOrder(row rowScanner) (*service.Order, error) {
+	var o service.Order
+	var status, items, metadata, shipping string
+	if err := row.Scan(&o.ID, &status, &o.SessionID, &o.PaymentIntentID, &o.Amount, &o.Currency, &o.Email, &items, &metadata, &shipping, &o.CreatedAt, &o.UpdatedAt); err != nil {
+		return nil, err
+	}
+	o.Status = service.OrderStatus(status)
+	if err := json.Unmarshal([]byte(items), &o.Items); err != nil {
+		return nil, fmt.Errorf("order %s: decoding items: %w", o.ID, err)
+	}
+	if err := json.Unmarshal([]byte(metadata), &o.Metadata); err != nil {
+		return nil, fmt.Errorf("order %s: decoding metadata: %w", o.ID, err)
+	}
+	if shipping != "" {
+		if err := json.Unmarshal([]byte(shipping), &o.Shipping); err != nil {
+			return nil, fmt.Errorf("order %s: decoding shipping: %w", o.ID, err)
+		}
+	}
+	return &o, nil
+}
+
+// bindNumbered rewrites ? placeholders to $1, $2, ... for Postgres.
+func bindNumbered(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func bindQuestion(query string) string {
+	return query
+}
@@ -0,0 +1,21 @@
+package store
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+
+	"stripe_go/internal/service"
+)
+
+func newPostgresPaymentStore(dsn string) (service.PaymentStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return newSQLPaymentStore(db, "postgresql", bindNumbered)
+}
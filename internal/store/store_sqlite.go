@@ -0,0 +1,19 @@
+package store
+
+import (
+	"database/sql"
+
+	_ "modernc.org/sqlite"
+
+	"stripe_go/internal/service"
+)
+
+func NewSQLitePaymentStore(path string) (service.PaymentStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer at a time.
+	db.SetMaxOpenConns(1)
+	return newSQLPaymentStore(db, "sqlite", bindQuestion)
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"stripe_go/internal/stripeclient"
+)
+
+// tracedDB is a *sql.DB whose statements are recorded as spans of the
+// trace in their context. Statements run outside a trace, such as job queue
+// snapshots and migrations, aren't recorded.
+type tracedDB struct {
+	*sql.DB
+	system string
+}
+
+func (d *tracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := dbSpan(ctx, d.system, query)
+	res, err := d.DB.ExecContext(ctx, query, args...)
+	stripeclient.EndSpan(span, err)
+	return res, err
+}
+
+func (d *tracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := dbSpan(ctx, d.system, query)
+	rows, err := d.DB.QueryContext(ctx, query, args...)
+	stripeclient.EndSpan(span, err)
+	return rows, err
+}
+
+func (d *tracedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := dbSpan(ctx, d.system, query)
+	row := d.DB.QueryRowContext(ctx, query, args...)
+	stripeclient.EndSpan(span, dbSpanError(row.Err()))
+	return row
+}
+
+func (d *tracedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*tracedTx, error) {
+	tx, err := d.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, system: d.system}, nil
+}
+
+// tracedTx is a *sql.Tx whose statements are recorded like tracedDB's.
+type tracedTx struct {
+	*sql.Tx
+	system string
+}
+
+func (t *tracedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := dbSpan(ctx, t.system, query)
+	res, err := t.Tx.ExecContext(ctx, query, args...)
+	stripeclient.EndSpan(span, err)
+	return res, err
+}
+
+func (t *tracedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := dbSpan(ctx, t.system, query)
+	rows, err := t.Tx.QueryContext(ctx, query, args...)
+	stripeclient.EndSpan(span, err)
+	return rows, err
+}
+
+func (t *tracedTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := dbSpan(ctx, t.system, query)
+	row := t.Tx.QueryRowContext(ctx, query, args...)
+	stripeclient.EndSpan(span, dbSpanError(row.Err()))
+	return row
+}
+
+// dbSpan starts the span of a statement, named after its operation. The
+// statement's arguments, which may be personal data, aren't recorded.
+func dbSpan(ctx context.Context, system, query string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	op := "query"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		op = strings.ToUpper(fields[0])
+	}
+	return stripeclient.StartSpan(ctx, "db "+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", system),
+		attribute.String("db.operation", op),
+		attribute.String("db.statement", strings.Join(strings.Fields(query), " ")),
+	))
+}
+
+// dbSpanError is err unless it only means no row matched.
+func dbSpanError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
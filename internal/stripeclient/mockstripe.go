@@ -0,0 +1,766 @@
+package stripeclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+
+	"stripe_go/internal/config"
+)
+
+// mockWebhookClient delivers the mock's events to the server's own webhook
+// endpoint.
+var mockWebhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// Mock is the Client of MODE=mock: an in-memory Stripe covering
+// the calls a checkout needs, so the shop runs without a Stripe account,
+// e.g. for demos or integration tests. Its checkout sessions are paid on a
+// page the server hosts under /mock/checkout/, which sends the events Stripe
+// would to DOMAIN/webhook, signed with the first STRIPE_WEBHOOK_SECRET.
+// Calls outside that subset, such as Connect or payment links, fail with an
+// invalid request error. Nothing survives a restart.
+type Mock struct {
+	// config is the server's, for DOMAIN and the webhook secret.
+	config         *config.Config
+	mu             sync.Mutex
+	products       []*stripe.Product
+	prices         []*stripe.Price
+	sessions       map[string]*stripe.CheckoutSession
+	sessionParams  map[string]*stripe.CheckoutSessionParams
+	lineItems      map[string][]*stripe.LineItem
+	paymentIntents map[string]*stripe.PaymentIntent
+	customers      map[string]*stripe.Customer
+	// events are kept oldest first.
+	events []*stripe.Event
+}
+
+func NewMock(c *config.Config) *Mock {
+	basic := &stripe.Product{ID: "prod_mock_basic", Object: "product", Name: "Basic", Description: "A product sold by the mock Stripe", Active: true}
+	plan := &stripe.Product{ID: "prod_mock_plan", Object: "product", Name: "Plan", Description: "A subscription sold by the mock Stripe", Active: true}
+	return &Mock{
+		config:   c,
+		products: []*stripe.Product{basic, plan},
+		prices: []*stripe.Price{
+			{ID: config.MockPrice, Object: "price", Product: basic, UnitAmount: 1500, Currency: stripe.CurrencyUSD, Nickname: "Basic", Active: true, Type: stripe.PriceTypeOneTime},
+			{
+				ID: config.MockPlanPrice, Object: "price", Product: plan, UnitAmount: 900, Currency: stripe.CurrencyUSD, Nickname: "Monthly", Active: true, Type: stripe.PriceTypeRecurring,
+				Recurring: &stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalMonth, IntervalCount: 1},
+			},
+		},
+		sessions:       map[string]*stripe.CheckoutSession{},
+		sessionParams:  map[string]*stripe.CheckoutSessionParams{},
+		lineItems:      map[string][]*stripe.LineItem{},
+		paymentIntents: map[string]*stripe.PaymentIntent{},
+		customers:      map[string]*stripe.Customer{},
+	}
+}
+
+// mockID returns a new object ID in Stripe's test mode format, random so
+// IDs stored before a restart aren't handed out again.
+func mockID(prefix string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return prefix + "_test_mock" + hex.EncodeToString(b)
+}
+
+// mockUnsupported is the error of the calls the mock doesn't cover.
+func mockUnsupported(call string) error {
+	return &stripe.Error{
+		HTTPStatusCode: http.StatusBadRequest,
+		Type:           stripe.ErrorTypeInvalidRequest,
+		Msg:            call + " isn't available with MODE=mock",
+	}
+}
+
+func mockNotFound(kind, id string) error {
+	return &stripe.Error{
+		HTTPStatusCode: http.StatusNotFound,
+		Type:           stripe.ErrorTypeInvalidRequest,
+		Code:           stripe.ErrorCodeResourceMissing,
+		Msg:            fmt.Sprintf("No such %s: '%s'", kind, id),
+	}
+}
+
+func (m *Mock) price(id string) *stripe.Price {
+	for _, p := range m.prices {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// newEvent records an event about object. The caller delivers it once it
+// has released m.mu, as the webhook handlers call back into the mock.
+func (m *Mock) newEvent(eventType string, object interface{}) (*stripe.Event, error) {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	e := &stripe.Event{
+		ID:      mockID("evt"),
+		Object:  "event",
+		Type:    eventType,
+		Created: time.Now().Unix(),
+		Data:    &stripe.EventData{Raw: raw},
+	}
+	m.events = append(m.events, e)
+	return e, nil
+}
+
+// deliver posts events to DOMAIN/webhook the way Stripe signs them. A
+// failed delivery is only logged: the events stay listed for the event
+// poller and the reconciler to pick up, as with Stripe.
+func (m *Mock) deliver(ctx context.Context, events ...*stripe.Event) {
+	if len(m.config.WebhookSecrets) == 0 {
+		return
+	}
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err == nil {
+			err = postMockWebhook(ctx, m.config.Domain+"/webhook", payload, m.config.WebhookSecrets[0])
+		}
+		if err != nil {
+			slog.Warn("mock webhook delivery failed", "event", e.ID, "type", e.Type, "error", err)
+		}
+	}
+}
+
+func postMockWebhook(ctx context.Context, url string, payload []byte, secret string) error {
+	now := time.Now()
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(webhook.ComputeSignature(now, payload, secret))))
+	resp, err := mockWebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (m *Mock) NewCheckoutSession(ctx context.Context, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &stripe.CheckoutSession{
+		ID:                mockID("cs"),
+		Object:            "checkout.session",
+		Mode:              stripe.CheckoutSessionMode(stripe.StringValue(params.Mode)),
+		Status:            stripe.CheckoutSessionStatusOpen,
+		PaymentStatus:     stripe.CheckoutSessionPaymentStatusUnpaid,
+		Metadata:          params.Metadata,
+		ClientReferenceID: stripe.StringValue(params.ClientReferenceID),
+		CustomerEmail:     stripe.StringValue(params.CustomerEmail),
+		Locale:            stripe.StringValue(params.Locale),
+		CancelURL:         stripe.StringValue(params.CancelURL),
+		ExpiresAt:         stripe.Int64Value(params.ExpiresAt),
+	}
+	if s.ExpiresAt == 0 {
+		s.ExpiresAt = time.Now().Add(24 * time.Hour).Unix()
+	}
+	s.SuccessURL = strings.ReplaceAll(stripe.StringValue(params.SuccessURL), "{CHECKOUT_SESSION_ID}", s.ID)
+	s.URL = m.config.Domain + config.MockCheckoutPath + s.ID
+	if params.Customer != nil {
+		c, ok := m.customers[*params.Customer]
+		if !ok {
+			return nil, mockNotFound("customer", *params.Customer)
+		}
+		s.Customer = c
+	}
+	for _, li := range params.LineItems {
+		p := m.price(stripe.StringValue(li.Price))
+		if d := li.PriceData; d != nil {
+			p = &stripe.Price{
+				ID:         mockID("price"),
+				UnitAmount: stripe.Int64Value(d.UnitAmount),
+				Currency:   stripe.Currency(stripe.StringValue(d.Currency)),
+				Product:    &stripe.Product{Name: "Item"},
+			}
+			if d.ProductData != nil {
+				p.Product.Name = stripe.StringValue(d.ProductData.Name)
+			}
+		}
+		if p == nil {
+			return nil, mockNotFound("price", stripe.StringValue(li.Price))
+		}
+		if s.Currency != "" && p.Currency != s.Currency {
+			return nil, &stripe.Error{HTTPStatusCode: http.StatusBadRequest, Type: stripe.ErrorTypeInvalidRequest, Msg: "All prices must be in the same currency."}
+		}
+		total := p.UnitAmount * stripe.Int64Value(li.Quantity)
+		s.AmountSubtotal += total
+		s.AmountTotal += total
+		s.Currency = p.Currency
+		m.lineItems[s.ID] = append(m.lineItems[s.ID], &stripe.LineItem{
+			ID:             mockID("li"),
+			Object:         "item",
+			Description:    p.Product.Name,
+			Price:          p,
+			Quantity:       stripe.Int64Value(li.Quantity),
+			AmountSubtotal: total,
+			AmountTotal:    total,
+			Currency:       p.Currency,
+		})
+	}
+	m.sessions[s.ID] = s
+	m.sessionParams[s.ID] = params
+	return s, nil
+}
+
+func (m *Mock) GetCheckoutSession(ctx context.Context, id string, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, mockNotFound("checkout.session", id)
+	}
+	out := *s
+	if params != nil {
+		for _, e := range params.Expand {
+			if *e == "line_items" {
+				out.LineItems = &stripe.LineItemList{Data: m.lineItems[id]}
+			}
+		}
+	}
+	return &out, nil
+}
+
+func (m *Mock) ListCheckoutSessions(ctx context.Context, params *stripe.CheckoutSessionListParams) ([]*stripe.CheckoutSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := []*stripe.CheckoutSession{}
+	for _, s := range m.sessions {
+		if params.Customer != nil && (s.Customer == nil || s.Customer.ID != *params.Customer) {
+			continue
+		}
+		if params.PaymentIntent != nil && (s.PaymentIntent == nil || s.PaymentIntent.ID != *params.PaymentIntent) {
+			continue
+		}
+		out := *s
+		list = append(list, &out)
+	}
+	return list, nil
+}
+
+func (m *Mock) ListCheckoutSessionLineItems(ctx context.Context, id string, params *stripe.CheckoutSessionListLineItemsParams) ([]*stripe.LineItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		return nil, mockNotFound("checkout.session", id)
+	}
+	return m.lineItems[id], nil
+}
+
+// CompleteCheckoutSession pays an open session as the Checkout page would
+// and delivers its events: checkout.session.completed, and for payments
+// payment_intent.succeeded, or payment_intent.amount_capturable_updated
+// with manual capture.
+func (m *Mock) CompleteCheckoutSession(ctx context.Context, id, email string) (*stripe.CheckoutSession, error) {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, mockNotFound("checkout.session", id)
+	}
+	if s.Status != stripe.CheckoutSessionStatusOpen {
+		m.mu.Unlock()
+		return nil, &stripe.Error{HTTPStatusCode: http.StatusBadRequest, Type: stripe.ErrorTypeInvalidRequest, Msg: fmt.Sprintf("This Checkout Session is %s.", s.Status)}
+	}
+	if email == "" {
+		email = s.CustomerEmail
+	}
+	if s.Customer != nil && email == "" {
+		email = s.Customer.Email
+	}
+	s.Status = stripe.CheckoutSessionStatusComplete
+	s.CustomerDetails = &stripe.CheckoutSessionCustomerDetails{Email: email}
+	var pi *stripe.PaymentIntent
+	switch s.Mode {
+	case stripe.CheckoutSessionModeSubscription:
+		if s.Customer == nil {
+			s.Customer = &stripe.Customer{ID: mockID("cus"), Object: "customer", Email: email, Created: time.Now().Unix()}
+			m.customers[s.Customer.ID] = s.Customer
+		}
+		s.Subscription = &stripe.Subscription{ID: mockID("sub")}
+		s.PaymentStatus = stripe.CheckoutSessionPaymentStatusPaid
+	case stripe.CheckoutSessionModeSetup:
+		s.SetupIntent = &stripe.SetupIntent{ID: mockID("seti")}
+		s.PaymentStatus = stripe.CheckoutSessionPaymentStatusNoPaymentRequired
+	default:
+		pi = &stripe.PaymentIntent{
+			ID:       mockID("pi"),
+			Object:   "payment_intent",
+			Amount:   s.AmountTotal,
+			Currency: string(s.Currency),
+			Customer: s.Customer,
+			Created:  time.Now().Unix(),
+			Status:   stripe.PaymentIntentStatusSucceeded,
+		}
+		if data := m.sessionParams[id].PaymentIntentData; data != nil {
+			pi.Metadata = data.Metadata
+			if stripe.StringValue(data.CaptureMethod) == "manual" {
+				pi.CaptureMethod = stripe.PaymentIntentCaptureMethodManual
+				pi.Status = stripe.PaymentIntentStatusRequiresCapture
+			}
+		}
+		if pi.Status == stripe.PaymentIntentStatusSucceeded {
+			pi.AmountReceived = pi.Amount
+			s.PaymentStatus = stripe.CheckoutSessionPaymentStatusPaid
+		} else {
+			pi.AmountCapturable = pi.Amount
+		}
+		m.paymentIntents[pi.ID] = pi
+		s.PaymentIntent = pi
+	}
+	var events []*stripe.Event
+	e, err := m.newEvent("checkout.session.completed", s)
+	if err == nil {
+		events = append(events, e)
+	}
+	if err == nil && pi != nil {
+		eventType := "payment_intent.succeeded"
+		if pi.Status == stripe.PaymentIntentStatusRequiresCapture {
+			eventType = "payment_intent.amount_capturable_updated"
+		}
+		e, err = m.newEvent(eventType, pi)
+		events = append(events, e)
+	}
+	out := *s
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m.deliver(ctx, events...)
+	return &out, nil
+}
+
+func (m *Mock) NewBillingPortalSession(ctx context.Context, params *stripe.BillingPortalSessionParams) (*stripe.BillingPortalSession, error) {
+	return nil, mockUnsupported("The customer portal")
+}
+
+func (m *Mock) GetPrice(ctx context.Context, id string, params *stripe.PriceParams) (*stripe.Price, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p := m.price(id); p != nil {
+		return p, nil
+	}
+	return nil, mockNotFound("price", id)
+}
+
+func (m *Mock) ListPrices(ctx context.Context, params *stripe.PriceListParams) ([]*stripe.Price, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prices, nil
+}
+
+func (m *Mock) ListProducts(ctx context.Context, params *stripe.ProductListParams) ([]*stripe.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.products, nil
+}
+
+// NewPaymentIntent creates payment intents the client confirms itself.
+// Without Stripe.js to confirm them, only ones confirmed on creation with a
+// payment method succeed.
+func (m *Mock) NewPaymentIntent(ctx context.Context, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	m.mu.Lock()
+	pi := &stripe.PaymentIntent{
+		ID:       mockID("pi"),
+		Object:   "payment_intent",
+		Amount:   stripe.Int64Value(params.Amount),
+		Currency: stripe.StringValue(params.Currency),
+		Created:  time.Now().Unix(),
+		Status:   stripe.PaymentIntentStatusRequiresPaymentMethod,
+		Metadata: params.Metadata,
+	}
+	pi.ClientSecret = pi.ID + "_secret_mock"
+	if params.Customer != nil {
+		pi.Customer = &stripe.Customer{ID: *params.Customer}
+	}
+	m.paymentIntents[pi.ID] = pi
+	var e *stripe.Event
+	var err error
+	if stripe.BoolValue(params.Confirm) && params.PaymentMethod != nil {
+		pi.Status = stripe.PaymentIntentStatusSucceeded
+		pi.AmountReceived = pi.Amount
+		e, err = m.newEvent("payment_intent.succeeded", pi)
+	}
+	out := *pi
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if e != nil {
+		m.deliver(ctx, e)
+	}
+	return &out, nil
+}
+
+func (m *Mock) GetPaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pi, ok := m.paymentIntents[id]
+	if !ok {
+		return nil, mockNotFound("payment_intent", id)
+	}
+	out := *pi
+	return &out, nil
+}
+
+func (m *Mock) ListPaymentIntents(ctx context.Context, params *stripe.PaymentIntentListParams) ([]*stripe.PaymentIntent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := []*stripe.PaymentIntent{}
+	for _, pi := range m.paymentIntents {
+		if params.Customer != nil && (pi.Customer == nil || pi.Customer.ID != *params.Customer) {
+			continue
+		}
+		out := *pi
+		list = append(list, &out)
+	}
+	return list, nil
+}
+
+// updatePaymentIntent moves an authorized payment intent along with
+// update, which returns the event type to send, and delivers the event.
+func (m *Mock) updatePaymentIntent(ctx context.Context, id, action string, update func(pi *stripe.PaymentIntent) string) (*stripe.PaymentIntent, error) {
+	m.mu.Lock()
+	pi, ok := m.paymentIntents[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, mockNotFound("payment_intent", id)
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+		m.mu.Unlock()
+		return nil, &stripe.Error{
+			HTTPStatusCode: http.StatusBadRequest,
+			Type:           stripe.ErrorTypeInvalidRequest,
+			Code:           stripe.ErrorCodePaymentIntentUnexpectedState,
+			Msg:            fmt.Sprintf("This PaymentIntent could not be %s because it has a status of %s.", action, pi.Status),
+		}
+	}
+	e, err := m.newEvent(update(pi), pi)
+	out := *pi
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m.deliver(ctx, e)
+	return &out, nil
+}
+
+func (m *Mock) CapturePaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentCaptureParams) (*stripe.PaymentIntent, error) {
+	return m.updatePaymentIntent(ctx, id, "captured", func(pi *stripe.PaymentIntent) string {
+		pi.Status = stripe.PaymentIntentStatusSucceeded
+		pi.AmountReceived = pi.AmountCapturable
+		if params != nil && params.AmountToCapture != nil {
+			pi.AmountReceived = *params.AmountToCapture
+		}
+		pi.AmountCapturable = 0
+		return "payment_intent.succeeded"
+	})
+}
+
+func (m *Mock) CancelPaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentCancelParams) (*stripe.PaymentIntent, error) {
+	return m.updatePaymentIntent(ctx, id, "canceled", func(pi *stripe.PaymentIntent) string {
+		pi.Status = stripe.PaymentIntentStatusCanceled
+		pi.AmountCapturable = 0
+		if params != nil {
+			pi.CancellationReason = stripe.PaymentIntentCancellationReason(stripe.StringValue(params.CancellationReason))
+		}
+		return "payment_intent.canceled"
+	})
+}
+
+// NewRefund refunds a succeeded payment intent and sends charge.refunded
+// for a charge standing in for the payment's.
+func (m *Mock) NewRefund(ctx context.Context, params *stripe.RefundParams) (*stripe.Refund, error) {
+	m.mu.Lock()
+	id := stripe.StringValue(params.PaymentIntent)
+	pi, ok := m.paymentIntents[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, mockNotFound("payment_intent", id)
+	}
+	refunded := int64(0)
+	if pi.Charges != nil && len(pi.Charges.Data) > 0 {
+		refunded = pi.Charges.Data[0].AmountRefunded
+	}
+	amount := pi.AmountReceived - refunded
+	if params.Amount != nil {
+		amount = *params.Amount
+	}
+	if pi.Status != stripe.PaymentIntentStatusSucceeded || amount <= 0 || refunded+amount > pi.AmountReceived {
+		m.mu.Unlock()
+		return nil, &stripe.Error{HTTPStatusCode: http.StatusBadRequest, Type: stripe.ErrorTypeInvalidRequest, Code: stripe.ErrorCodeChargeAlreadyRefunded,
+			Msg: fmt.Sprintf("Refund amount (%d) is greater than unrefunded amount on charge (%d)", amount, pi.AmountReceived-refunded)}
+	}
+	re := &stripe.Refund{
+		ID:            mockID("re"),
+		Object:        "refund",
+		Amount:        amount,
+		Currency:      stripe.Currency(pi.Currency),
+		Status:        stripe.RefundStatusSucceeded,
+		Reason:        stripe.RefundReason(stripe.StringValue(params.Reason)),
+		PaymentIntent: &stripe.PaymentIntent{ID: pi.ID},
+		Created:       time.Now().Unix(),
+	}
+	if pi.Charges == nil || len(pi.Charges.Data) == 0 {
+		pi.Charges = &stripe.ChargeList{Data: []*stripe.Charge{{
+			ID: mockID("ch"), Object: "charge", Amount: pi.AmountReceived, Currency: re.Currency, PaymentIntent: &stripe.PaymentIntent{ID: pi.ID},
+			Paid: true, Status: stripe.ChargeStatusSucceeded, Refunds: &stripe.RefundList{},
+		}}}
+	}
+	ch := pi.Charges.Data[0]
+	ch.AmountRefunded += amount
+	ch.Refunded = ch.AmountRefunded == ch.Amount
+	ch.Refunds.Data = append(ch.Refunds.Data, re)
+	e, err := m.newEvent("charge.refunded", ch)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m.deliver(ctx, e)
+	return re, nil
+}
+
+func (m *Mock) NewPaymentLink(ctx context.Context, params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error) {
+	return nil, mockUnsupported("Payment links")
+}
+
+func (m *Mock) UpdatePaymentLink(ctx context.Context, id string, params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error) {
+	return nil, mockUnsupported("Payment links")
+}
+
+func (m *Mock) ListPaymentLinks(ctx context.Context, params *stripe.PaymentLinkListParams) ([]*stripe.PaymentLink, error) {
+	return []*stripe.PaymentLink{}, nil
+}
+
+func (m *Mock) NewInvoice(ctx context.Context, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	return nil, mockUnsupported("Invoices")
+}
+
+func (m *Mock) GetInvoice(ctx context.Context, id string, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	return nil, mockNotFound("invoice", id)
+}
+
+func (m *Mock) FinalizeInvoice(ctx context.Context, id string, params *stripe.InvoiceFinalizeParams) (*stripe.Invoice, error) {
+	return nil, mockNotFound("invoice", id)
+}
+
+func (m *Mock) SendInvoice(ctx context.Context, id string, params *stripe.InvoiceSendParams) (*stripe.Invoice, error) {
+	return nil, mockNotFound("invoice", id)
+}
+
+func (m *Mock) NewInvoiceItem(ctx context.Context, params *stripe.InvoiceItemParams) (*stripe.InvoiceItem, error) {
+	return nil, mockUnsupported("Invoices")
+}
+
+func (m *Mock) GetUpcomingInvoice(ctx context.Context, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	return nil, mockUnsupported("Subscriptions")
+}
+
+func (m *Mock) GetSubscription(ctx context.Context, id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	return nil, mockNotFound("subscription", id)
+}
+
+func (m *Mock) UpdateSubscription(ctx context.Context, id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	return nil, mockNotFound("subscription", id)
+}
+
+func (m *Mock) NewUsageRecord(ctx context.Context, params *stripe.UsageRecordParams) (*stripe.UsageRecord, error) {
+	return nil, mockNotFound("subscription item", stripe.StringValue(params.SubscriptionItem))
+}
+
+func (m *Mock) ListUsageRecordSummaries(ctx context.Context, params *stripe.UsageRecordSummaryListParams) ([]*stripe.UsageRecordSummary, error) {
+	return nil, mockNotFound("subscription item", stripe.StringValue(params.SubscriptionItem))
+}
+
+func (m *Mock) NewCustomer(ctx context.Context, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := &stripe.Customer{
+		ID:       mockID("cus"),
+		Object:   "customer",
+		Email:    stripe.StringValue(params.Email),
+		Name:     stripe.StringValue(params.Name),
+		Metadata: params.Metadata,
+		Created:  time.Now().Unix(),
+	}
+	m.customers[c.ID] = c
+	out := *c
+	return &out, nil
+}
+
+func (m *Mock) GetCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.customers[id]
+	if !ok {
+		return nil, mockNotFound("customer", id)
+	}
+	out := *c
+	return &out, nil
+}
+
+func (m *Mock) UpdateCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.customers[id]
+	if !ok {
+		return nil, mockNotFound("customer", id)
+	}
+	if params.Email != nil {
+		c.Email = *params.Email
+	}
+	if params.Name != nil {
+		c.Name = *params.Name
+	}
+	for k, v := range params.Metadata {
+		if c.Metadata == nil {
+			c.Metadata = map[string]string{}
+		}
+		c.Metadata[k] = v
+	}
+	out := *c
+	return &out, nil
+}
+
+func (m *Mock) DeleteCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.customers[id]; !ok {
+		return nil, mockNotFound("customer", id)
+	}
+	delete(m.customers, id)
+	return &stripe.Customer{ID: id, Object: "customer", Deleted: true}, nil
+}
+
+func (m *Mock) ListCustomers(ctx context.Context, params *stripe.CustomerListParams) ([]*stripe.Customer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := []*stripe.Customer{}
+	for _, c := range m.customers {
+		if params.Email == nil || c.Email == *params.Email {
+			out := *c
+			list = append(list, &out)
+		}
+	}
+	return list, nil
+}
+
+// ListPaymentMethods lists none: the mock's Checkout page doesn't save
+// cards.
+func (m *Mock) ListPaymentMethods(ctx context.Context, params *stripe.PaymentMethodListParams) ([]*stripe.PaymentMethod, error) {
+	return []*stripe.PaymentMethod{}, nil
+}
+
+func (m *Mock) GetSetupIntent(ctx context.Context, id string, params *stripe.SetupIntentParams) (*stripe.SetupIntent, error) {
+	return nil, mockNotFound("setup_intent", id)
+}
+
+func (m *Mock) GetCoupon(ctx context.Context, id string, params *stripe.CouponParams) (*stripe.Coupon, error) {
+	return nil, mockNotFound("coupon", id)
+}
+
+func (m *Mock) ListPromotionCodes(ctx context.Context, params *stripe.PromotionCodeListParams) ([]*stripe.PromotionCode, error) {
+	return []*stripe.PromotionCode{}, nil
+}
+
+func (m *Mock) NewAccount(ctx context.Context, params *stripe.AccountParams) (*stripe.Account, error) {
+	return nil, mockUnsupported("Connect")
+}
+
+func (m *Mock) GetAccount(ctx context.Context, id string, params *stripe.AccountParams) (*stripe.Account, error) {
+	return nil, mockUnsupported("Connect")
+}
+
+func (m *Mock) NewAccountLink(ctx context.Context, params *stripe.AccountLinkParams) (*stripe.AccountLink, error) {
+	return nil, mockUnsupported("Connect")
+}
+
+func (m *Mock) UpdateDispute(ctx context.Context, id string, params *stripe.DisputeParams) (*stripe.Dispute, error) {
+	return nil, mockNotFound("dispute", id)
+}
+
+func (m *Mock) NewFile(ctx context.Context, params *stripe.FileParams) (*stripe.File, error) {
+	return nil, mockUnsupported("File uploads")
+}
+
+func (m *Mock) ApproveReview(ctx context.Context, id string, params *stripe.ReviewApproveParams) (*stripe.Review, error) {
+	return nil, mockNotFound("review", id)
+}
+
+func (m *Mock) ListEvents(ctx context.Context, params *stripe.EventListParams) ([]*stripe.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := []*stripe.Event{}
+	if params.EndingBefore != nil {
+		found := false
+		for _, e := range m.events {
+			if found {
+				list = append(list, e)
+			}
+			found = found || e.ID == *params.EndingBefore
+		}
+		if !found {
+			return nil, mockNotFound("event", *params.EndingBefore)
+		}
+	} else {
+		for i := len(m.events) - 1; i >= 0; i-- {
+			if params.CreatedRange == nil || m.events[i].Created >= params.CreatedRange.GreaterThanOrEqual {
+				list = append(list, m.events[i])
+			}
+		}
+	}
+	if params.Single && params.Limit != nil && int64(len(list)) > *params.Limit {
+		list = list[:*params.Limit]
+	}
+	return list, nil
+}
+
+func (m *Mock) GetEvent(ctx context.Context, id string) (*stripe.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.events {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return nil, mockNotFound("event", id)
+}
+
+// The mock delivers its events to DOMAIN/webhook itself, so there are no
+// endpoints to register.
+func (m *Mock) NewWebhookEndpoint(ctx context.Context, params *stripe.WebhookEndpointParams) (*stripe.WebhookEndpoint, error) {
+	return nil, mockUnsupported("Webhook endpoints")
+}
+
+func (m *Mock) UpdateWebhookEndpoint(ctx context.Context, id string, params *stripe.WebhookEndpointParams) (*stripe.WebhookEndpoint, error) {
+	return nil, mockUnsupported("Webhook endpoints")
+}
+
+func (m *Mock) ListWebhookEndpoints(ctx context.Context, params *stripe.WebhookEndpointListParams) ([]*stripe.WebhookEndpoint, error) {
+	return nil, mockUnsupported("Webhook endpoints")
+}
+
+// ConstructEvent checks the signatures the mock makes like Stripe's.
+func (m *Mock) ConstructEvent(payload []byte, signature, secret string, tolerance time.Duration) (stripe.Event, error) {
+	return webhook.ConstructEventWithTolerance(payload, signature, secret, tolerance)
+}
@@ -0,0 +1,36 @@
+package stripeclient
+
+import (
+	"context"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+type stripeAccountKey struct{}
+
+// WithAccount returns ctx acting on the connected account id; an
+// empty id leaves ctx on its own account.
+func WithAccount(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, stripeAccountKey{}, id)
+}
+
+// AccountFrom returns the connected account ctx acts on, or "" for
+// the platform's (or tenant's) own account.
+func AccountFrom(ctx context.Context) string {
+	id, _ := ctx.Value(stripeAccountKey{}).(string)
+	return id
+}
+
+// SetAccount makes a Stripe call on the connected account its params'
+// context acts on, unless the call names an account itself.
+func SetAccount(params *stripe.Params) {
+	if params == nil || params.Context == nil || params.StripeAccount != nil {
+		return
+	}
+	if id := AccountFrom(params.Context); id != "" {
+		params.SetStripeAccount(id)
+	}
+}
@@ -0,0 +1,128 @@
+package stripeclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/form"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"stripe_go/internal/config"
+)
+
+// retryingBackend wraps the stripe-go API backend. Every attempt is bounded
+// by the HTTP client's timeout, and idempotent calls (GET, DELETE, and POSTs
+// that carry an idempotency key) are retried on rate limiting, Stripe server
+// errors and network failures, backing off exponentially with jitter. The
+// caller's context, passed as the params' Context, cancels the call and any
+// wait between attempts.
+type retryingBackend struct {
+	stripe.Backend
+	maxRetries int
+	backoff    time.Duration
+	// key picks the secret key of a call.
+	key func(params *stripe.Params, key string) string
+}
+
+// newStripeBackend returns the backend used for all stripe-go calls. key
+// picks the secret key of each call, letting calls made for a tenant use
+// theirs, and calls
+// acting on a connected account send its Stripe-Account header.
+// stripe-go's own retries are turned off so they don't multiply ours.
+func newStripeBackend(c *config.Config, key func(params *stripe.Params, key string) string) stripe.Backend {
+	api := stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		HTTPClient:        &http.Client{Timeout: c.StripeTimeout, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		MaxNetworkRetries: stripe.Int64(0),
+	})
+	return &retryingBackend{Backend: api, maxRetries: c.StripeMaxRetries, backoff: c.StripeRetryBackoff, key: key}
+}
+
+func (b *retryingBackend) Call(method, path, key string, params stripe.ParamsContainer, v stripe.LastResponseSetter) error {
+	var p *stripe.Params
+	if params != nil {
+		p = params.GetParams()
+	}
+	key = b.key(p, key)
+	SetAccount(p)
+	return b.retry(method, path, p, func() error {
+		return b.Backend.Call(method, path, key, params, v)
+	})
+}
+
+func (b *retryingBackend) CallRaw(method, path, key string, body *form.Values, params *stripe.Params, v stripe.LastResponseSetter) error {
+	key = b.key(params, key)
+	SetAccount(params)
+	return b.retry(method, path, params, func() error {
+		return b.Backend.CallRaw(method, path, key, body, params, v)
+	})
+}
+
+// CallMultipart is only used for file uploads, whose body can't be replayed,
+// so it isn't retried.
+func (b *retryingBackend) CallMultipart(method, path, key, boundary string, body *bytes.Buffer, params *stripe.Params, v stripe.LastResponseSetter) error {
+	SetAccount(params)
+	_, span := tracedStripeCall(method, path, params)
+	err := b.Backend.CallMultipart(method, path, b.key(params, key), boundary, body, params, v)
+	stripeSpanError(span, err)
+	return err
+}
+
+// tracedStripeCall starts the span of a call in the trace of the params'
+// context, and makes it the context the call's HTTP requests are made in.
+func tracedStripeCall(method, path string, params *stripe.Params) (context.Context, trace.Span) {
+	ctx := context.Background()
+	if params != nil && params.Context != nil {
+		ctx = params.Context
+	}
+	ctx, span := stripeSpan(ctx, method, path)
+	if params != nil {
+		params.Context = ctx
+	}
+	return ctx, span
+}
+
+func (b *retryingBackend) retry(method, path string, params *stripe.Params, call func() error) (err error) {
+	ctx, span := tracedStripeCall(method, path, params)
+	defer func() { stripeSpanError(span, err) }()
+	idempotent := method == http.MethodGet || method == http.MethodDelete ||
+		(params != nil && params.IdempotencyKey != nil)
+	for attempt := 0; ; attempt++ {
+		span.SetAttributes(attribute.Int("stripe.attempts", attempt+1))
+		err := call()
+		if err == nil || !idempotent || attempt >= b.maxRetries || ctx.Err() != nil || !retryableStripeError(err) {
+			return err
+		}
+		wait := b.backoff << attempt
+		if wait > 0 {
+			wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		}
+		slog.Warn("retrying stripe request", "method", method, "path", path, "attempt", attempt+1, "wait", wait, "error", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// retryableStripeError reports whether a failed call may succeed if sent
+// again: Stripe rate limited it, failed on its side, or the request didn't
+// get through or timed out.
+func retryableStripeError(err error) bool {
+	var se *stripe.Error
+	if errors.As(err, &se) {
+		return se.HTTPStatusCode == http.StatusTooManyRequests || se.HTTPStatusCode >= 500 ||
+			se.Code == stripe.ErrorCodeLockTimeout
+	}
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
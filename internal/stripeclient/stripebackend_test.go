@@ -0,0 +1,117 @@
+package stripeclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// testBackend returns a retrying backend talking to a server that fails the
+// first failures requests with status and then succeeds. attempts counts
+// the requests it received.
+func testBackend(t *testing.T, status, failures int) (*retryingBackend, *int32) {
+	t.Helper()
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if int(atomic.AddInt32(&attempts, 1)) <= failures {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error": {"type": "api_error", "code": "rate_limit", "message": "try again"}}`))
+			return
+		}
+		w.Write([]byte(`{"id": "price_basic", "object": "price"}`))
+	}))
+	t.Cleanup(srv.Close)
+	api := stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:               stripe.String(srv.URL),
+		MaxNetworkRetries: stripe.Int64(0),
+		LeveledLogger:     &stripe.LeveledLogger{Level: stripe.LevelNull},
+	})
+	return &retryingBackend{Backend: api, maxRetries: 2, backoff: time.Millisecond, key: sameKey}, &attempts
+}
+
+// sameKey makes each call with the key it was given.
+func sameKey(_ *stripe.Params, key string) string { return key }
+
+func TestStripeBackendRetries(t *testing.T) {
+	withKey := &stripe.PriceParams{}
+	withKey.SetIdempotencyKey("key_1")
+	for _, tt := range []struct {
+		name         string
+		method       string
+		params       *stripe.PriceParams
+		status       int
+		failures     int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{"get recovers from 500", "GET", &stripe.PriceParams{}, 500, 2, 3, false},
+		{"get recovers from 429", "GET", &stripe.PriceParams{}, 429, 1, 2, false},
+		{"get gives up", "GET", &stripe.PriceParams{}, 503, 5, 3, true},
+		{"post without idempotency key", "POST", &stripe.PriceParams{}, 500, 1, 1, true},
+		{"post with idempotency key", "POST", withKey, 500, 1, 2, false},
+		{"bad request", "GET", &stripe.PriceParams{}, 400, 1, 1, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, attempts := testBackend(t, tt.status, tt.failures)
+			err := b.Call(tt.method, "/v1/prices/price_basic", "sk_test_123", tt.params, &stripe.Price{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if *attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", *attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestStripeBackendStopsWhenCanceled(t *testing.T) {
+	b, attempts := testBackend(t, 500, 5)
+	b.backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	params := &stripe.PriceParams{}
+	params.Context = ctx
+	start := time.Now()
+	if err := b.Call("GET", "/v1/prices/price_basic", "sk_test_123", params, &stripe.Price{}); err == nil {
+		t.Fatal("call succeeded")
+	}
+	if *attempts != 1 || time.Since(start) > 10*time.Second {
+		t.Errorf("attempts = %d after %v, want the wait to be cut short", *attempts, time.Since(start))
+	}
+}
+
+func TestStripeBackendStripeAccount(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Stripe-Account"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "price_basic", "object": "price"}`))
+	}))
+	defer srv.Close()
+	b := &retryingBackend{Backend: stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:           stripe.String(srv.URL),
+		LeveledLogger: &stripe.LeveledLogger{Level: stripe.LevelNull},
+	}), key: sameKey}
+
+	onAccount := &stripe.PriceParams{}
+	onAccount.Context = WithAccount(context.Background(), "acct_1Connected")
+	// An account the call names itself wins over the context's.
+	named := &stripe.PriceParams{}
+	named.Context = onAccount.Context
+	named.SetStripeAccount("acct_1Named")
+	for _, params := range []*stripe.PriceParams{{}, onAccount, named} {
+		if err := b.Call("GET", "/v1/prices/price_basic", "sk_test_123", params, &stripe.Price{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(got, ",") != ",acct_1Connected,acct_1Named" {
+		t.Errorf("Stripe-Account headers = %q", got)
+	}
+}
@@ -0,0 +1,460 @@
+// Package stripeclient is the Stripe API as the service uses it: a Client
+// over stripe-go that retries and traces its calls, and the in-memory Mock
+// of MODE=mock.
+package stripeclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/client"
+	"github.com/stripe/stripe-go/v72/webhook"
+
+	"stripe_go/internal/config"
+)
+
+// Client is the part of the Stripe API the server uses. The Service calls
+// Stripe through a Client rather than the stripe-go packages directly so
+// tests can swap in a fake. List methods page through every result. Calls
+// are made under ctx, which the wrapper sets as the params' Context, so
+// cancelling it aborts the request.
+type Client interface {
+	NewCheckoutSession(ctx context.Context, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error)
+	GetCheckoutSession(ctx context.Context, id string, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error)
+	ListCheckoutSessions(ctx context.Context, params *stripe.CheckoutSessionListParams) ([]*stripe.CheckoutSession, error)
+	ListCheckoutSessionLineItems(ctx context.Context, id string, params *stripe.CheckoutSessionListLineItemsParams) ([]*stripe.LineItem, error)
+	NewBillingPortalSession(ctx context.Context, params *stripe.BillingPortalSessionParams) (*stripe.BillingPortalSession, error)
+
+	GetPrice(ctx context.Context, id string, params *stripe.PriceParams) (*stripe.Price, error)
+	ListPrices(ctx context.Context, params *stripe.PriceListParams) ([]*stripe.Price, error)
+	ListProducts(ctx context.Context, params *stripe.ProductListParams) ([]*stripe.Product, error)
+
+	NewPaymentIntent(ctx context.Context, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error)
+	GetPaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error)
+	ListPaymentIntents(ctx context.Context, params *stripe.PaymentIntentListParams) ([]*stripe.PaymentIntent, error)
+	CapturePaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentCaptureParams) (*stripe.PaymentIntent, error)
+	CancelPaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentCancelParams) (*stripe.PaymentIntent, error)
+	NewRefund(ctx context.Context, params *stripe.RefundParams) (*stripe.Refund, error)
+
+	NewPaymentLink(ctx context.Context, params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error)
+	UpdatePaymentLink(ctx context.Context, id string, params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error)
+	ListPaymentLinks(ctx context.Context, params *stripe.PaymentLinkListParams) ([]*stripe.PaymentLink, error)
+
+	NewInvoice(ctx context.Context, params *stripe.InvoiceParams) (*stripe.Invoice, error)
+	GetInvoice(ctx context.Context, id string, params *stripe.InvoiceParams) (*stripe.Invoice, error)
+	FinalizeInvoice(ctx context.Context, id string, params *stripe.InvoiceFinalizeParams) (*stripe.Invoice, error)
+	SendInvoice(ctx context.Context, id string, params *stripe.InvoiceSendParams) (*stripe.Invoice, error)
+	NewInvoiceItem(ctx context.Context, params *stripe.InvoiceItemParams) (*stripe.InvoiceItem, error)
+	// GetUpcomingInvoice previews a customer's next invoice, including the
+	// changes to a subscription given in params.
+	GetUpcomingInvoice(ctx context.Context, params *stripe.InvoiceParams) (*stripe.Invoice, error)
+
+	GetSubscription(ctx context.Context, id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error)
+	UpdateSubscription(ctx context.Context, id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error)
+	NewUsageRecord(ctx context.Context, params *stripe.UsageRecordParams) (*stripe.UsageRecord, error)
+	ListUsageRecordSummaries(ctx context.Context, params *stripe.UsageRecordSummaryListParams) ([]*stripe.UsageRecordSummary, error)
+
+	NewCustomer(ctx context.Context, params *stripe.CustomerParams) (*stripe.Customer, error)
+	GetCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error)
+	UpdateCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error)
+	DeleteCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error)
+	ListCustomers(ctx context.Context, params *stripe.CustomerListParams) ([]*stripe.Customer, error)
+	ListPaymentMethods(ctx context.Context, params *stripe.PaymentMethodListParams) ([]*stripe.PaymentMethod, error)
+	GetSetupIntent(ctx context.Context, id string, params *stripe.SetupIntentParams) (*stripe.SetupIntent, error)
+
+	GetCoupon(ctx context.Context, id string, params *stripe.CouponParams) (*stripe.Coupon, error)
+	ListPromotionCodes(ctx context.Context, params *stripe.PromotionCodeListParams) ([]*stripe.PromotionCode, error)
+
+	NewAccount(ctx context.Context, params *stripe.AccountParams) (*stripe.Account, error)
+	GetAccount(ctx context.Context, id string, params *stripe.AccountParams) (*stripe.Account, error)
+	NewAccountLink(ctx context.Context, params *stripe.AccountLinkParams) (*stripe.AccountLink, error)
+
+	UpdateDispute(ctx context.Context, id string, params *stripe.DisputeParams) (*stripe.Dispute, error)
+	NewFile(ctx context.Context, params *stripe.FileParams) (*stripe.File, error)
+	ApproveReview(ctx context.Context, id string, params *stripe.ReviewApproveParams) (*stripe.Review, error)
+
+	// ListEvents returns events oldest first when params.EndingBefore is
+	// set, and newest first otherwise.
+	ListEvents(ctx context.Context, params *stripe.EventListParams) ([]*stripe.Event, error)
+	GetEvent(ctx context.Context, id string) (*stripe.Event, error)
+
+	NewWebhookEndpoint(ctx context.Context, params *stripe.WebhookEndpointParams) (*stripe.WebhookEndpoint, error)
+	UpdateWebhookEndpoint(ctx context.Context, id string, params *stripe.WebhookEndpointParams) (*stripe.WebhookEndpoint, error)
+	ListWebhookEndpoints(ctx context.Context, params *stripe.WebhookEndpointListParams) ([]*stripe.WebhookEndpoint, error)
+
+	// ConstructEvent verifies a webhook's Stripe-Signature header against
+	// secret, rejecting signatures older than tolerance, and parses the
+	// event.
+	ConstructEvent(payload []byte, signature, secret string, tolerance time.Duration) (stripe.Event, error)
+}
+
+// stripeAPI calls the real Stripe API through its own stripe-go client, so
+// services in one process can use different accounts.
+type stripeAPI struct {
+	api *client.API
+}
+
+// New returns the client of the account whose secret key is c's.
+// key picks the key each call is made with, as newStripeBackend describes.
+func New(c *config.Config, key func(params *stripe.Params, key string) string) stripeAPI {
+	return stripeAPI{api: client.New(c.SecretKey, &stripe.Backends{
+		API:     newStripeBackend(c, key),
+		Connect: stripe.GetBackend(stripe.ConnectBackend),
+		Uploads: stripe.GetBackend(stripe.UploadsBackend),
+	})}
+}
+
+func (s stripeAPI) NewCheckoutSession(ctx context.Context, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	params.Context = ctx
+	return s.api.CheckoutSessions.New(params)
+}
+
+func (s stripeAPI) GetCheckoutSession(ctx context.Context, id string, params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	if params == nil {
+		params = &stripe.CheckoutSessionParams{}
+	}
+	params.Context = ctx
+	return s.api.CheckoutSessions.Get(id, params)
+}
+
+func (s stripeAPI) ListCheckoutSessions(ctx context.Context, params *stripe.CheckoutSessionListParams) ([]*stripe.CheckoutSession, error) {
+	params.Context = ctx
+	it := s.api.CheckoutSessions.List(params)
+	list := []*stripe.CheckoutSession{}
+	for it.Next() {
+		list = append(list, it.CheckoutSession())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) ListCheckoutSessionLineItems(ctx context.Context, id string, params *stripe.CheckoutSessionListLineItemsParams) ([]*stripe.LineItem, error) {
+	if params == nil {
+		params = &stripe.CheckoutSessionListLineItemsParams{}
+	}
+	params.Context = ctx
+	it := s.api.CheckoutSessions.ListLineItems(id, params)
+	list := []*stripe.LineItem{}
+	for it.Next() {
+		list = append(list, it.LineItem())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) NewBillingPortalSession(ctx context.Context, params *stripe.BillingPortalSessionParams) (*stripe.BillingPortalSession, error) {
+	params.Context = ctx
+	return s.api.BillingPortalSessions.New(params)
+}
+
+func (s stripeAPI) GetPrice(ctx context.Context, id string, params *stripe.PriceParams) (*stripe.Price, error) {
+	if params == nil {
+		params = &stripe.PriceParams{}
+	}
+	params.Context = ctx
+	return s.api.Prices.Get(id, params)
+}
+
+func (s stripeAPI) ListPrices(ctx context.Context, params *stripe.PriceListParams) ([]*stripe.Price, error) {
+	params.Context = ctx
+	it := s.api.Prices.List(params)
+	list := []*stripe.Price{}
+	for it.Next() {
+		list = append(list, it.Price())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) ListProducts(ctx context.Context, params *stripe.ProductListParams) ([]*stripe.Product, error) {
+	params.Context = ctx
+	it := s.api.Products.List(params)
+	list := []*stripe.Product{}
+	for it.Next() {
+		list = append(list, it.Product())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) NewPaymentIntent(ctx context.Context, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	params.Context = ctx
+	return s.api.PaymentIntents.New(params)
+}
+
+func (s stripeAPI) GetPaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	if params == nil {
+		params = &stripe.PaymentIntentParams{}
+	}
+	params.Context = ctx
+	return s.api.PaymentIntents.Get(id, params)
+}
+
+func (s stripeAPI) ListPaymentIntents(ctx context.Context, params *stripe.PaymentIntentListParams) ([]*stripe.PaymentIntent, error) {
+	params.Context = ctx
+	it := s.api.PaymentIntents.List(params)
+	list := []*stripe.PaymentIntent{}
+	for it.Next() {
+		list = append(list, it.PaymentIntent())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) CapturePaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentCaptureParams) (*stripe.PaymentIntent, error) {
+	if params == nil {
+		params = &stripe.PaymentIntentCaptureParams{}
+	}
+	params.Context = ctx
+	return s.api.PaymentIntents.Capture(id, params)
+}
+
+func (s stripeAPI) CancelPaymentIntent(ctx context.Context, id string, params *stripe.PaymentIntentCancelParams) (*stripe.PaymentIntent, error) {
+	if params == nil {
+		params = &stripe.PaymentIntentCancelParams{}
+	}
+	params.Context = ctx
+	return s.api.PaymentIntents.Cancel(id, params)
+}
+
+func (s stripeAPI) NewRefund(ctx context.Context, params *stripe.RefundParams) (*stripe.Refund, error) {
+	params.Context = ctx
+	return s.api.Refunds.New(params)
+}
+
+func (s stripeAPI) NewPaymentLink(ctx context.Context, params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error) {
+	params.Context = ctx
+	return s.api.PaymentLinks.New(params)
+}
+
+func (s stripeAPI) UpdatePaymentLink(ctx context.Context, id string, params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error) {
+	params.Context = ctx
+	return s.api.PaymentLinks.Update(id, params)
+}
+
+func (s stripeAPI) ListPaymentLinks(ctx context.Context, params *stripe.PaymentLinkListParams) ([]*stripe.PaymentLink, error) {
+	params.Context = ctx
+	it := s.api.PaymentLinks.List(params)
+	list := []*stripe.PaymentLink{}
+	for it.Next() {
+		list = append(list, it.PaymentLink())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) NewWebhookEndpoint(ctx context.Context, params *stripe.WebhookEndpointParams) (*stripe.WebhookEndpoint, error) {
+	params.Context = ctx
+	return s.api.WebhookEndpoints.New(params)
+}
+
+func (s stripeAPI) UpdateWebhookEndpoint(ctx context.Context, id string, params *stripe.WebhookEndpointParams) (*stripe.WebhookEndpoint, error) {
+	params.Context = ctx
+	return s.api.WebhookEndpoints.Update(id, params)
+}
+
+func (s stripeAPI) ListWebhookEndpoints(ctx context.Context, params *stripe.WebhookEndpointListParams) ([]*stripe.WebhookEndpoint, error) {
+	params.Context = ctx
+	it := s.api.WebhookEndpoints.List(params)
+	list := []*stripe.WebhookEndpoint{}
+	for it.Next() {
+		list = append(list, it.WebhookEndpoint())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) NewInvoice(ctx context.Context, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	params.Context = ctx
+	return s.api.Invoices.New(params)
+}
+
+func (s stripeAPI) GetInvoice(ctx context.Context, id string, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	if params == nil {
+		params = &stripe.InvoiceParams{}
+	}
+	params.Context = ctx
+	return s.api.Invoices.Get(id, params)
+}
+
+func (s stripeAPI) FinalizeInvoice(ctx context.Context, id string, params *stripe.InvoiceFinalizeParams) (*stripe.Invoice, error) {
+	if params == nil {
+		params = &stripe.InvoiceFinalizeParams{}
+	}
+	params.Context = ctx
+	return s.api.Invoices.FinalizeInvoice(id, params)
+}
+
+func (s stripeAPI) SendInvoice(ctx context.Context, id string, params *stripe.InvoiceSendParams) (*stripe.Invoice, error) {
+	if params == nil {
+		params = &stripe.InvoiceSendParams{}
+	}
+	params.Context = ctx
+	return s.api.Invoices.SendInvoice(id, params)
+}
+
+func (s stripeAPI) NewInvoiceItem(ctx context.Context, params *stripe.InvoiceItemParams) (*stripe.InvoiceItem, error) {
+	params.Context = ctx
+	return s.api.InvoiceItems.New(params)
+}
+
+func (s stripeAPI) GetUpcomingInvoice(ctx context.Context, params *stripe.InvoiceParams) (*stripe.Invoice, error) {
+	params.Context = ctx
+	return s.api.Invoices.GetNext(params)
+}
+
+func (s stripeAPI) GetSubscription(ctx context.Context, id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	if params == nil {
+		params = &stripe.SubscriptionParams{}
+	}
+	params.Context = ctx
+	return s.api.Subscriptions.Get(id, params)
+}
+
+func (s stripeAPI) UpdateSubscription(ctx context.Context, id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	params.Context = ctx
+	return s.api.Subscriptions.Update(id, params)
+}
+
+func (s stripeAPI) NewUsageRecord(ctx context.Context, params *stripe.UsageRecordParams) (*stripe.UsageRecord, error) {
+	params.Context = ctx
+	return s.api.UsageRecords.New(params)
+}
+
+func (s stripeAPI) ListUsageRecordSummaries(ctx context.Context, params *stripe.UsageRecordSummaryListParams) ([]*stripe.UsageRecordSummary, error) {
+	params.Context = ctx
+	it := s.api.UsageRecordSummaries.List(params)
+	list := []*stripe.UsageRecordSummary{}
+	for it.Next() {
+		list = append(list, it.UsageRecordSummary())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) NewCustomer(ctx context.Context, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	params.Context = ctx
+	return s.api.Customers.New(params)
+}
+
+func (s stripeAPI) GetCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	if params == nil {
+		params = &stripe.CustomerParams{}
+	}
+	params.Context = ctx
+	return s.api.Customers.Get(id, params)
+}
+
+func (s stripeAPI) UpdateCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	if params == nil {
+		params = &stripe.CustomerParams{}
+	}
+	params.Context = ctx
+	return s.api.Customers.Update(id, params)
+}
+
+func (s stripeAPI) DeleteCustomer(ctx context.Context, id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	if params == nil {
+		params = &stripe.CustomerParams{}
+	}
+	params.Context = ctx
+	return s.api.Customers.Del(id, params)
+}
+
+func (s stripeAPI) ListCustomers(ctx context.Context, params *stripe.CustomerListParams) ([]*stripe.Customer, error) {
+	params.Context = ctx
+	it := s.api.Customers.List(params)
+	list := []*stripe.Customer{}
+	for it.Next() {
+		list = append(list, it.Customer())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) ListPaymentMethods(ctx context.Context, params *stripe.PaymentMethodListParams) ([]*stripe.PaymentMethod, error) {
+	params.Context = ctx
+	it := s.api.PaymentMethods.List(params)
+	list := []*stripe.PaymentMethod{}
+	for it.Next() {
+		list = append(list, it.PaymentMethod())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) GetSetupIntent(ctx context.Context, id string, params *stripe.SetupIntentParams) (*stripe.SetupIntent, error) {
+	if params == nil {
+		params = &stripe.SetupIntentParams{}
+	}
+	params.Context = ctx
+	return s.api.SetupIntents.Get(id, params)
+}
+
+func (s stripeAPI) GetCoupon(ctx context.Context, id string, params *stripe.CouponParams) (*stripe.Coupon, error) {
+	if params == nil {
+		params = &stripe.CouponParams{}
+	}
+	params.Context = ctx
+	return s.api.Coupons.Get(id, params)
+}
+
+func (s stripeAPI) ListPromotionCodes(ctx context.Context, params *stripe.PromotionCodeListParams) ([]*stripe.PromotionCode, error) {
+	params.Context = ctx
+	it := s.api.PromotionCodes.List(params)
+	list := []*stripe.PromotionCode{}
+	for it.Next() {
+		list = append(list, it.PromotionCode())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) NewAccount(ctx context.Context, params *stripe.AccountParams) (*stripe.Account, error) {
+	params.Context = ctx
+	return s.api.Account.New(params)
+}
+
+func (s stripeAPI) GetAccount(ctx context.Context, id string, params *stripe.AccountParams) (*stripe.Account, error) {
+	if params == nil {
+		params = &stripe.AccountParams{}
+	}
+	params.Context = ctx
+	return s.api.Account.GetByID(id, params)
+}
+
+func (s stripeAPI) NewAccountLink(ctx context.Context, params *stripe.AccountLinkParams) (*stripe.AccountLink, error) {
+	params.Context = ctx
+	return s.api.AccountLinks.New(params)
+}
+
+func (s stripeAPI) UpdateDispute(ctx context.Context, id string, params *stripe.DisputeParams) (*stripe.Dispute, error) {
+	if params == nil {
+		params = &stripe.DisputeParams{}
+	}
+	params.Context = ctx
+	return s.api.Disputes.Update(id, params)
+}
+
+func (s stripeAPI) NewFile(ctx context.Context, params *stripe.FileParams) (*stripe.File, error) {
+	params.Context = ctx
+	return s.api.Files.New(params)
+}
+
+func (s stripeAPI) ApproveReview(ctx context.Context, id string, params *stripe.ReviewApproveParams) (*stripe.Review, error) {
+	if params == nil {
+		params = &stripe.ReviewApproveParams{}
+	}
+	params.Context = ctx
+	return s.api.Reviews.Approve(id, params)
+}
+
+func (s stripeAPI) ListEvents(ctx context.Context, params *stripe.EventListParams) ([]*stripe.Event, error) {
+	params.Context = ctx
+	it := s.api.Events.List(params)
+	list := []*stripe.Event{}
+	for it.Next() {
+		list = append(list, it.Event())
+	}
+	return list, it.Err()
+}
+
+func (s stripeAPI) GetEvent(ctx context.Context, id string) (*stripe.Event, error) {
+	params := &stripe.EventParams{}
+	params.Context = ctx
+	return s.api.Events.Get(id, params)
+}
+
+func (s stripeAPI) ConstructEvent(payload []byte, signature, secret string, tolerance time.Duration) (stripe.Event, error) {
+	return webhook.ConstructEventWithTolerance(payload, signature, secret, tolerance)
+}
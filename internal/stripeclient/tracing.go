@@ -0,0 +1,61 @@
+package stripeclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "stripe_go"
+
+// StartSpan starts a span with the global tracer provider. The tracer is
+// looked up every time rather than kept, so a provider installed later
+// (as tests do) takes effect.
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// EndSpan ends span, marking it failed if err is set.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// stripeSpan starts the span of a Stripe API call. Object IDs, which unlike
+// Stripe's resource names mix a prefix_ with digits or capitals, are left
+// out of its name, which would otherwise be unique to every call.
+func stripeSpan(ctx context.Context, method, path string) (context.Context, trace.Span) {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.Contains(s, "_") && (strings.ToLower(s) != s || strings.ContainsAny(s, "0123456789")) {
+			segments[i] = "{id}"
+		}
+	}
+	return StartSpan(ctx, "stripe "+method+" "+strings.Join(segments, "/"), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("http.request.method", method),
+		attribute.String("url.path", path),
+	))
+}
+
+// stripeSpanError records err on the span of a Stripe call, with the
+// Stripe error code and request ID when Stripe answered.
+func stripeSpanError(span trace.Span, err error) {
+	var se *stripe.Error
+	if errors.As(err, &se) {
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", se.HTTPStatusCode),
+			attribute.String("stripe.error.code", string(se.Code)),
+			attribute.String("stripe.request_id", se.RequestID),
+		)
+	}
+	EndSpan(span, err)
+}
@@ -0,0 +1,45 @@
+package stripeclient
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStripeCallSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	b, _ := testBackend(t, 500, 1)
+	ctx, parent := StartSpan(context.Background(), "parent")
+	params := &stripe.PriceParams{}
+	params.Context = ctx
+	if err := b.Call("GET", "/v1/prices/price_1NzBasic", "sk_test_123", params, &stripe.Price{}); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	var call sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "stripe GET /v1/prices/{id}" {
+			call = s
+		}
+	}
+	if call == nil {
+		t.Fatalf("no span for the call in %d spans", len(recorder.Ended()))
+	}
+	if call.Parent().SpanID() != parent.SpanContext().SpanID() || call.Status().Code != codes.Unset {
+		t.Errorf("call span parent %s, status %v", call.Parent().SpanID(), call.Status())
+	}
+	for _, a := range call.Attributes() {
+		if a.Key == "stripe.attempts" && a.Value.AsInt64() != 2 {
+			t.Errorf("attempts = %d, want 2", a.Value.AsInt64())
+		}
+	}
+}
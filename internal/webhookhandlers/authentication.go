@@ -0,0 +1,148 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// MaxAuthenticationEmails caps the emails a customer is sent about one
+// payment, the first included, when their bank keeps turning down their
+// authentication.
+const MaxAuthenticationEmails = 3
+
+// handlePaymentIntentRequiresAction asks the customer to authenticate an
+// off-session charge that Stripe moved to requires_action. Payments of
+// invoices are handled by handleInvoicePaymentActionRequired, and other
+// payment intents are confirmed by a customer who is present to
+// authenticate.
+func (h *handlers) handlePaymentIntentRequiresAction(ctx context.Context, event stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return fmt.Errorf("failed to parse payment intent object: %w", err)
+	}
+	if pi.Metadata["off_session"] != "true" || pi.Invoice != nil || pi.Customer == nil {
+		return nil
+	}
+	slog.Info("payment intent requires action", "payment_intent", pi.ID, "customer", pi.Customer.ID)
+	if _, err := h.svc.RecordPaymentIntent(ctx, &pi, string(stripe.PaymentIntentStatusRequiresAction)); err != nil {
+		return err
+	}
+	c, err := h.svc.Stripe.GetCustomer(ctx, pi.Customer.ID, nil)
+	if err != nil {
+		return fmt.Errorf("fetching customer: %w", err)
+	}
+	var paymentMethod string
+	if pi.PaymentMethod != nil {
+		paymentMethod = pi.PaymentMethod.ID
+	}
+	email := h.svc.AuthenticationRequest(c.Email, service.CustomerLocale(c), &pi, paymentMethod, pi.Description)
+	_, err = h.svc.RequestAuthentication(ctx, &service.Authentication{
+		PaymentIntentID: pi.ID,
+		CustomerID:      c.ID,
+		Email:           c.Email,
+		Locale:          email.Locale,
+		Amount:          pi.Amount,
+		Currency:        string(pi.Currency),
+	}, email)
+	return err
+}
+
+// handleInvoicePaymentActionRequired emails the customer the hosted invoice
+// page when their bank wants them to authenticate an invoice's payment.
+func (h *handlers) handleInvoicePaymentActionRequired(ctx context.Context, event stripe.Event) error {
+	var inv stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+		return fmt.Errorf("failed to parse invoice object: %w", err)
+	}
+	if inv.PaymentIntent == nil {
+		return nil
+	}
+	var customerID string
+	if inv.Customer != nil {
+		customerID = inv.Customer.ID
+	}
+	slog.Info("invoice payment requires action", "invoice", inv.ID, "payment_intent", inv.PaymentIntent.ID, "customer", customerID)
+	a := &service.Authentication{
+		PaymentIntentID: inv.PaymentIntent.ID,
+		InvoiceID:       inv.ID,
+		CustomerID:      customerID,
+		Email:           inv.CustomerEmail,
+		Amount:          inv.AmountDue,
+		Currency:        string(inv.Currency),
+	}
+	var email *service.AuthenticationRequest
+	if inv.HostedInvoiceURL != "" {
+		email = &service.AuthenticationRequest{
+			Email:       inv.CustomerEmail,
+			Amount:      inv.AmountDue,
+			Currency:    string(inv.Currency),
+			Description: inv.Description,
+			URL:         inv.HostedInvoiceURL,
+		}
+	}
+	_, err := h.svc.RequestAuthentication(ctx, a, email)
+	return err
+}
+
+// handleAuthenticationFailed emails the link again when the customer tried
+// to authenticate and their bank turned it down, until they have had
+// MaxAuthenticationEmails. Declines other than failed authentication
+// are left to handlePaymentIntentFailed.
+func (h *handlers) handleAuthenticationFailed(ctx context.Context, event stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return fmt.Errorf("failed to parse payment intent object: %w", err)
+	}
+	if pi.LastPaymentError == nil || pi.LastPaymentError.Code != stripe.ErrorCodePaymentIntentAuthenticationFailure {
+		return nil
+	}
+	a, err := h.svc.PendingAuthentication(ctx, pi.ID)
+	if err != nil || a == nil {
+		return err
+	}
+	a.Failures++
+	slog.Warn("authentication failed", "payment_intent", pi.ID, "failures", a.Failures)
+	if err := h.svc.Payments.UpdateAuthentication(ctx, a); err != nil {
+		return err
+	}
+	// Invoices are retried on their hosted page, which stays the same.
+	if a.InvoiceID != "" || a.Email == "" || a.Failures >= MaxAuthenticationEmails || time.Now().After(a.ExpiresAt) {
+		return nil
+	}
+	paymentMethod := pi.LastPaymentError.PaymentMethod
+	if paymentMethod == nil {
+		paymentMethod = pi.PaymentMethod
+	}
+	if paymentMethod == nil {
+		return nil
+	}
+	email := h.svc.AuthenticationRequest(a.Email, a.Locale, &pi, paymentMethod.ID, pi.Description)
+	email.PaymentIntentID, email.ExpiresAt, email.Retry = a.PaymentIntentID, a.ExpiresAt, true
+	return h.svc.Jobs.Enqueue(ctx, service.JobSendAuthenticationEmail, email)
+}
+
+// handleAuthenticationSettled closes the authentication of a payment intent
+// that succeeded or was canceled, so it isn't emailed about or expired.
+func (h *handlers) handleAuthenticationSettled(ctx context.Context, event stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return fmt.Errorf("failed to parse payment intent object: %w", err)
+	}
+	a, err := h.svc.PendingAuthentication(ctx, pi.ID)
+	if err != nil || a == nil {
+		return err
+	}
+	a.Status = service.AuthenticationSucceeded
+	if pi.Status == stripe.PaymentIntentStatusCanceled {
+		a.Status = service.AuthenticationCanceled
+	}
+	slog.Info("authentication settled", "payment_intent", pi.ID, "status", a.Status)
+	return h.svc.Payments.UpdateAuthentication(ctx, a)
+}
@@ -0,0 +1,25 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// handleSessionCacheInvalidation forgets the cached copy of a session that
+// Stripe reports has changed.
+func (h *handlers) handleSessionCacheInvalidation(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	for _, key := range []string{"session:" + s.ID, "session_items:" + s.ID} {
+		if err := h.svc.Cache.Delete(context.Background(), key); err != nil {
+			slog.Warn("invalidating cached session", "session", s.ID, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// overcaptureMaximum returns the most a payment intent's card payment can be
+// captured for when its card allows overcapture, or 0. stripe-go doesn't
+// decode the overcapture details of a charge, so they are read from raw, the
+// payment intent as Stripe sent it.
+func overcaptureMaximum(raw json.RawMessage) int64 {
+	var pi struct {
+		Charges struct {
+			Data []struct {
+				PaymentMethodDetails struct {
+					Card struct {
+						Overcapture struct {
+							MaximumAmountCapturable int64  `json:"maximum_amount_capturable"`
+							Status                  string `json:"status"`
+						} `json:"overcapture"`
+					} `json:"card"`
+				} `json:"payment_method_details"`
+			} `json:"data"`
+		} `json:"charges"`
+	}
+	if json.Unmarshal(raw, &pi) != nil {
+		return 0
+	}
+	for _, ch := range pi.Charges.Data {
+		if o := ch.PaymentMethodDetails.Card.Overcapture; o.Status == "available" {
+			return o.MaximumAmountCapturable
+		}
+	}
+	return 0
+}
+
+// handlePaymentIntentAuthorized records that a manually captured payment was
+// authorized and can now be captured.
+func (h *handlers) handlePaymentIntentAuthorized(ctx context.Context, event stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return fmt.Errorf("failed to parse payment intent object: %w", err)
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+		return nil
+	}
+	maxCapturable := overcaptureMaximum(event.Data.Raw)
+	slog.Info("payment intent authorized",
+		"payment_intent", pi.ID,
+		"amount_capturable", pi.AmountCapturable,
+		"max_capturable", maxCapturable,
+		"currency", pi.Currency,
+	)
+	p, err := h.svc.RecordPaymentIntent(ctx, &pi, "authorized")
+	if err != nil || p.MaxCapturable == maxCapturable {
+		return err
+	}
+	p.MaxCapturable = maxCapturable
+	return h.svc.Payments.SavePayment(ctx, p)
+}
+
+// handlePaymentIntentCanceled records a canceled authorization, whether it
+// was canceled here, from the dashboard, or expired uncaptured.
+func (h *handlers) handlePaymentIntentCanceled(ctx context.Context, event stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return fmt.Errorf("failed to parse payment intent object: %w", err)
+	}
+	slog.Info("payment intent canceled", "payment_intent", pi.ID, "reason", pi.CancellationReason)
+	_, err := h.svc.RecordPaymentIntent(ctx, &pi, "canceled")
+	return err
+}
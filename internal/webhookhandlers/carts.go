@@ -0,0 +1,49 @@
+package webhookhandlers
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleCartCheckoutExpired marks the session of a cart expired, so the
+// next checkout of the cart creates a new one. A failed asynchronous
+// payment leaves the cart to be paid again the same way.
+func (h *handlers) handleCartCheckoutExpired(ctx context.Context, event stripe.Event) error {
+	cart, s, unlock, err := h.svc.LockSessionCart(ctx, event)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// A late event for a session the cart has moved on from changes nothing.
+	if cart == nil || cart.SessionID != s.ID {
+		return nil
+	}
+	slog.Info("reopening cart", "cart", cart.ID, "session", s.ID, "event", event.Type)
+	cart.Status = service.CartOpen
+	cart.SessionStatus = string(stripe.CheckoutSessionStatusExpired)
+	if event.Type == "checkout.session.async_payment_failed" {
+		cart.SessionStatus = "payment_failed"
+	}
+	return h.svc.Payments.SaveCart(ctx, cart)
+}
+
+// handleCartCheckoutCompleted closes the cart a completed session was
+// created from.
+func (h *handlers) handleCartCheckoutCompleted(ctx context.Context, event stripe.Event) error {
+	cart, s, unlock, err := h.svc.LockSessionCart(ctx, event)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if cart == nil {
+		return nil
+	}
+	cart.Status = service.CartCompleted
+	cart.SessionID = s.ID
+	cart.SessionStatus = string(stripe.CheckoutSessionStatusComplete)
+	return h.svc.Payments.SaveCart(ctx, cart)
+}
@@ -0,0 +1,18 @@
+package webhookhandlers
+
+import "github.com/stripe/stripe-go/v72"
+
+// sessionQuantities sums the expanded line items of s by price. It is nil
+// when they weren't expanded.
+func sessionQuantities(s *stripe.CheckoutSession) map[string]int64 {
+	if s.LineItems == nil || len(s.LineItems.Data) == 0 {
+		return nil
+	}
+	quantities := map[string]int64{}
+	for _, li := range s.LineItems.Data {
+		if li.Price != nil {
+			quantities[li.Price.ID] += li.Quantity
+		}
+	}
+	return quantities
+}
@@ -0,0 +1,25 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func (h *handlers) handleAccountUpdated(ctx context.Context, event stripe.Event) error {
+	var a stripe.Account
+	if err := json.Unmarshal(event.Data.Raw, &a); err != nil {
+		return fmt.Errorf("failed to parse account object: %w", err)
+	}
+	slog.Info("connected account updated",
+		"account", a.ID,
+		"charges_enabled", a.ChargesEnabled,
+		"payouts_enabled", a.PayoutsEnabled,
+	)
+	return h.svc.Payments.SaveConnectedAccount(ctx, service.ConnectedAccountFromStripe(&a))
+}
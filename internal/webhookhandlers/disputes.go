@@ -0,0 +1,96 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// handleDisputeChanged stores the dispute carried by every charge.dispute
+// event.
+func (h *handlers) handleDisputeChanged(ctx context.Context, event stripe.Event) error {
+	var d stripe.Dispute
+	if err := json.Unmarshal(event.Data.Raw, &d); err != nil {
+		return fmt.Errorf("failed to parse dispute object: %w", err)
+	}
+	rec := service.DisputeFromStripe(&d)
+	slog.Info("dispute changed",
+		"event_type", event.Type,
+		"dispute", rec.ID,
+		"status", rec.Status,
+	)
+	return h.svc.Payments.SaveDispute(ctx, rec)
+}
+
+// handleChargeDisputeCreated marks the disputed payment and alerts the
+// operators, who have until the evidence due date to respond through
+// /admin/disputes or the dashboard.
+func (h *handlers) handleChargeDisputeCreated(ctx context.Context, event stripe.Event) error {
+	var d stripe.Dispute
+	if err := json.Unmarshal(event.Data.Raw, &d); err != nil {
+		return fmt.Errorf("failed to parse dispute object: %w", err)
+	}
+	slog.Warn("charge disputed",
+		"dispute", d.ID,
+		"amount", d.Amount,
+		"currency", d.Currency,
+		"reason", d.Reason,
+	)
+	lines := []string{
+		fmt.Sprintf("A customer disputed %s (reason: %s).", service.FormatAmount(d.Amount, string(d.Currency)), d.Reason),
+		"Dispute: " + d.ID,
+	}
+	if d.EvidenceDetails != nil && d.EvidenceDetails.DueBy != 0 {
+		lines = append(lines, "Respond by "+time.Unix(d.EvidenceDetails.DueBy, 0).UTC().Format(time.RFC1123)+".")
+	}
+	if d.PaymentIntent != nil {
+		lines = append(lines, "Payment intent: "+d.PaymentIntent.ID)
+		p, err := h.svc.Payments.GetPaymentByIntent(ctx, d.PaymentIntent.ID)
+		switch {
+		case err == service.ErrPaymentNotFound:
+		case err != nil:
+			return err
+		default:
+			previous := p.Status
+			if !service.SetPaymentStatus(p, "disputed") {
+				break
+			}
+			if err := h.svc.SavePayment(ctx, p, previous); err != nil {
+				return err
+			}
+		}
+	}
+	return h.svc.NotifyOps(ctx, config.NotifyDisputeOpened, "Payment disputed: "+service.FormatAmount(d.Amount, string(d.Currency)), lines...)
+}
+
+// handleChargeDisputeClosed tells the operators how a dispute ended. A won
+// dispute returns the payment to paid; a lost one leaves it disputed.
+func (h *handlers) handleChargeDisputeClosed(ctx context.Context, event stripe.Event) error {
+	var d stripe.Dispute
+	if err := json.Unmarshal(event.Data.Raw, &d); err != nil {
+		return fmt.Errorf("failed to parse dispute object: %w", err)
+	}
+	if d.Status == stripe.DisputeStatusWon && d.PaymentIntent != nil {
+		p, err := h.svc.Payments.GetPaymentByIntent(ctx, d.PaymentIntent.ID)
+		switch {
+		case err == service.ErrPaymentNotFound:
+		case err != nil:
+			return err
+		case p.Status == "disputed" && service.SetPaymentStatus(p, "paid"):
+			if err := h.svc.SavePayment(ctx, p, "disputed"); err != nil {
+				return err
+			}
+		}
+	}
+	return h.svc.NotifyOps(ctx, config.NotifyDisputeClosed, fmt.Sprintf("Dispute %s: %s", d.Status, service.FormatAmount(d.Amount, string(d.Currency))),
+		"Dispute: "+d.ID,
+		"Status: "+string(d.Status),
+	)
+}
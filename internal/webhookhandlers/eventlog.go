@@ -0,0 +1,84 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// eventLogTypes are the Stripe events kept in the event log: those of the
+// checkout sessions, payment intents, charges and reviews that make up a
+// payment's lifecycle.
+var eventLogTypes = []string{
+	"checkout.session.completed",
+	"checkout.session.expired",
+	"checkout.session.async_payment_succeeded",
+	"checkout.session.async_payment_failed",
+	"payment_intent.succeeded",
+	"payment_intent.payment_failed",
+	"payment_intent.requires_action",
+	"payment_intent.amount_capturable_updated",
+	"payment_intent.canceled",
+	"charge.succeeded",
+	"charge.refunded",
+	"charge.dispute.created",
+	"charge.dispute.updated",
+	"charge.dispute.closed",
+	"review.opened",
+	"review.closed",
+}
+
+// handleEventLog stores an event in the event log. It runs before the other
+// handlers of the event, so every event that changed a payment is in the
+// log even if a later handler fails and Stripe redelivers it.
+func (h *handlers) handleEventLog(ctx context.Context, event stripe.Event) error {
+	e, err := storedEvent(event)
+	if err != nil {
+		return err
+	}
+	return h.svc.Payments.AppendStoredEvent(ctx, e)
+}
+
+// storedEvent returns event as the event log keeps it, linked to the
+// checkout session, payment intent and order its object names.
+func storedEvent(event stripe.Event) (*service.StoredEvent, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	e := &service.StoredEvent{ID: event.ID, Type: event.Type, Payload: payload, ReceivedAt: time.Now().UTC()}
+	// Events the reconciler replays have no creation time.
+	e.Created = e.ReceivedAt
+	if event.Created != 0 {
+		e.Created = time.Unix(event.Created, 0).UTC()
+	}
+	var object map[string]interface{}
+	if event.Data != nil && len(event.Data.Raw) > 0 {
+		if err := json.Unmarshal(event.Data.Raw, &object); err != nil {
+			return nil, fmt.Errorf("failed to parse event object: %w", err)
+		}
+	}
+	e.ObjectID, _ = object["id"].(string)
+	switch {
+	case strings.HasPrefix(e.ObjectID, "cs_"):
+		e.SessionID = e.ObjectID
+	case strings.HasPrefix(e.ObjectID, "pi_"):
+		e.PaymentIntentID = e.ObjectID
+	}
+	switch pi := object["payment_intent"].(type) {
+	case string:
+		e.PaymentIntentID = pi
+	case map[string]interface{}:
+		e.PaymentIntentID, _ = pi["id"].(string)
+	}
+	if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+		e.OrderID, _ = metadata[service.OrderMetadataKey].(string)
+	}
+	return e, nil
+}
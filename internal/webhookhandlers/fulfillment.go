@@ -0,0 +1,55 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleFulfillmentCheckoutCompleted queues the fulfillment of a paid
+// session's order. Sessions still waiting on a delayed payment are fulfilled
+// by async_payment_succeeded instead.
+func (h *handlers) handleFulfillmentCheckoutCompleted(ctx context.Context, event stripe.Event) error {
+	if len(h.svc.Config.FulfillmentRoutes) == 0 {
+		return nil
+	}
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	if s.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid {
+		return nil
+	}
+	o, err := h.svc.SessionOrder(ctx, &s)
+	if err == service.ErrOrderNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.svc.Jobs.Enqueue(ctx, service.JobFulfillOrder, o.ID)
+}
+
+// handleFulfillmentPaymentIntent queues the fulfillment of an order paid by
+// manual capture, whose session completed unpaid.
+func (h *handlers) handleFulfillmentPaymentIntent(ctx context.Context, event stripe.Event) error {
+	if len(h.svc.Config.FulfillmentRoutes) == 0 {
+		return nil
+	}
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return fmt.Errorf("failed to parse payment intent object: %w", err)
+	}
+	o, err := h.svc.IntentOrder(ctx, &pi)
+	if err == service.ErrOrderNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.svc.Jobs.Enqueue(ctx, service.JobFulfillOrder, o.ID)
+}
@@ -0,0 +1,52 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// asyncPaymentHold is how long stock stays reserved for a session completed
+// with a delayed payment method; bank debits can take up to two weeks.
+const asyncPaymentHold = 14 * 24 * time.Hour
+
+// handleInventoryCheckoutCompleted takes the units bought in a paid session
+// out of stock. Sessions still waiting for a delayed payment keep
+// their reservation until it succeeds or fails.
+func (h *handlers) handleInventoryCheckoutCompleted(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	if s.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid {
+		if err := h.svc.Payments.ExtendReservation(ctx, s.ID, time.Now().Add(asyncPaymentHold)); err != nil {
+			return fmt.Errorf("extending reservation %s: %w", s.ID, err)
+		}
+		return nil
+	}
+	if err := h.svc.ExpandLineItems(ctx, &s); err != nil {
+		return err
+	}
+	if err := h.svc.Payments.CommitReservation(ctx, s.ID, sessionQuantities(&s)); err != nil {
+		return fmt.Errorf("committing reservation %s: %w", s.ID, err)
+	}
+	return nil
+}
+
+// handleInventoryCheckoutExpired returns the units of an abandoned session,
+// or one whose delayed payment failed, to stock.
+func (h *handlers) handleInventoryCheckoutExpired(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	slog.Info("releasing reserved stock", "session", s.ID, "event", event.Type)
+	if err := h.svc.Payments.ReleaseReservation(ctx, s.ID); err != nil {
+		return fmt.Errorf("releasing reservation %s: %w", s.ID, err)
+	}
+	return nil
+}
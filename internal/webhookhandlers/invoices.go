@@ -0,0 +1,94 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// invoiceStage orders invoice statuses, so an event delivered late can't
+// take an invoice back to an earlier one. Uncollectible invoices can still
+// be paid.
+func invoiceStage(status string) int {
+	switch stripe.InvoiceStatus(status) {
+	case stripe.InvoiceStatusDraft:
+		return 0
+	case stripe.InvoiceStatusOpen:
+		return 1
+	case stripe.InvoiceStatusUncollectible:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// handleInvoiceChanged keeps the invoices created through /admin/invoices
+// current as Stripe finalizes, sends, voids or collects them. Events of
+// other invoices, such as subscription renewals, are left to the
+// subscription handlers.
+func (h *handlers) handleInvoiceChanged(ctx context.Context, event stripe.Event) error {
+	var inv stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+		return fmt.Errorf("failed to parse invoice object: %w", err)
+	}
+	defer h.svc.LockInvoice(inv.ID)()
+	local, err := h.svc.Payments.GetInvoice(ctx, inv.ID)
+	if err == service.ErrInvoiceNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if invoiceStage(string(inv.Status)) < invoiceStage(local.Status) {
+		slog.Info("ignoring stale invoice event", "invoice", inv.ID, "event_type", event.Type, "status", inv.Status, "stored_status", local.Status)
+		return nil
+	}
+	if event.Type == "invoice.sent" && local.SentAt == nil {
+		sent := time.Unix(event.Created, 0).UTC()
+		local.SentAt = &sent
+	}
+	rec, err := h.svc.SaveInvoice(ctx, &inv, local)
+	if err != nil {
+		return err
+	}
+	slog.Info("invoice changed", "event_type", event.Type, "invoice", rec.ID, "status", rec.Status)
+	return nil
+}
+
+// handleInvoicePaymentNotification tells the operators when an invoice
+// outside a subscription is paid or a payment of it fails. Subscription
+// invoices are reported by handleInvoicePaymentFailed.
+func (h *handlers) handleInvoicePaymentNotification(ctx context.Context, event stripe.Event) error {
+	var inv stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+		return fmt.Errorf("failed to parse invoice object: %w", err)
+	}
+	if inv.Subscription != nil {
+		return nil
+	}
+	lines := []string{"Invoice: " + invoiceLabel(&inv)}
+	if inv.Customer != nil {
+		lines = append(lines, "Customer: "+inv.Customer.ID)
+	}
+	if event.Type == "invoice.paid" {
+		return h.svc.NotifyOps(ctx, config.NotifyInvoicePaid, "Invoice paid: "+service.FormatAmount(inv.AmountPaid, string(inv.Currency)), lines...)
+	}
+	slog.Warn("invoice payment failed", "invoice", inv.ID, "attempt_count", inv.AttemptCount)
+	lines = append(lines, fmt.Sprintf("Attempt: %d", inv.AttemptCount))
+	return h.svc.NotifyOps(ctx, config.NotifyInvoicePaymentFailed, "Invoice payment failed: "+service.FormatAmount(inv.AmountDue, string(inv.Currency)), lines...)
+}
+
+// invoiceLabel names inv by its number when it has one.
+func invoiceLabel(inv *stripe.Invoice) string {
+	if inv.Number == "" {
+		return inv.ID
+	}
+	return inv.Number + " (" + inv.ID + ")"
+}
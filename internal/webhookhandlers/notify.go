@@ -0,0 +1,33 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// handleCheckoutPaidNotification raises payment.succeeded for paid
+// checkouts of at least NOTIFY_PAYMENT_THRESHOLD.
+func (h *handlers) handleCheckoutPaidNotification(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	if h.svc.Config.NotifyPaymentThreshold == 0 || s.AmountTotal < h.svc.Config.NotifyPaymentThreshold ||
+		s.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid {
+		return nil
+	}
+	lines := []string{"Checkout session: " + s.ID}
+	if s.CustomerDetails != nil && s.CustomerDetails.Email != "" {
+		lines = append(lines, "Customer: "+s.CustomerDetails.Email)
+	}
+	if order := s.Metadata["order_id"]; order != "" {
+		lines = append(lines, "Order: "+order)
+	}
+	return h.svc.NotifyOps(ctx, config.NotifyPaymentSucceeded, "Payment received: "+service.FormatAmount(s.AmountTotal, string(s.Currency)), lines...)
+}
@@ -0,0 +1,43 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/servicetest"
+)
+
+func TestCheckoutPaidNotification(t *testing.T) {
+	svc, _, emails := servicetest.New(t)
+	h := &handlers{svc: svc}
+	event := stripe.Event{Type: "checkout.session.completed", Data: &stripe.EventData{Raw: json.RawMessage(`{"id":"cs_test_big","amount_total":2500,"currency":"usd","payment_status":"paid"}`)}}
+	if err := h.handleCheckoutPaidNotification(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	svc.Jobs.RunDue(context.Background(), 0)
+	if len(emails.Sent) != 0 {
+		t.Fatalf("alerted without NOTIFY_PAYMENT_THRESHOLD: %+v", emails.Sent)
+	}
+
+	svc.Config.NotifyPaymentThreshold = 1000
+	if err := h.handleCheckoutPaidNotification(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range []string{
+		`{"id":"cs_test_small","amount_total":500,"currency":"usd","payment_status":"paid"}`,
+		`{"id":"cs_test_sepa","amount_total":5000,"currency":"eur","payment_status":"unpaid"}`,
+	} {
+		event.Data.Raw = json.RawMessage(raw)
+		if err := h.handleCheckoutPaidNotification(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc.Jobs.RunDue(context.Background(), 0)
+	if len(emails.Sent) != 1 || !strings.Contains(emails.Sent[0].HTML, "cs_test_big") {
+		t.Errorf("sent %+v, want one alert for cs_test_big", emails.Sent)
+	}
+}
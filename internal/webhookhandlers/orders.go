@@ -0,0 +1,101 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+func (h *handlers) handleOrderCheckoutCompleted(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	if err := h.svc.ExpandLineItems(ctx, &s); err != nil {
+		return err
+	}
+	return h.svc.AdvanceOrder(ctx, event, &s)
+}
+
+// handleOrderCheckoutSettled pays the order of a session whose delayed
+// payment succeeded, and cancels that of an expired session or one whose
+// delayed payment failed.
+func (h *handlers) handleOrderCheckoutSettled(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	return h.svc.AdvanceOrder(ctx, event, &s)
+}
+
+// handleOrderPaymentIntent moves orders paid by manual capture along: the
+// checkout session completes unpaid, and the order is paid once the payment
+// is captured or canceled if the authorization is.
+func (h *handlers) handleOrderPaymentIntent(ctx context.Context, event stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return fmt.Errorf("failed to parse payment intent object: %w", err)
+	}
+	o, err := h.svc.IntentOrder(ctx, &pi)
+	if err == service.ErrOrderNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	status, ok := service.IntentOrderTransition(o, event.Type, &pi)
+	if !ok {
+		return nil
+	}
+	if err := o.Transition(status); err != nil {
+		slog.Info("ignoring order transition", "order", o.ID, "payment_intent", pi.ID, "error", err)
+		return nil
+	}
+	if err := h.svc.Payments.SaveOrder(ctx, o); err != nil {
+		return err
+	}
+	return h.svc.PublishOrderEvent(ctx, event, o, status)
+}
+
+// handleOrderChargeRefunded marks the order refunded once its charge is
+// refunded in full. Partial refunds leave the order as it is.
+func (h *handlers) handleOrderChargeRefunded(ctx context.Context, event stripe.Event) error {
+	var ch stripe.Charge
+	if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+		return fmt.Errorf("failed to parse charge object: %w", err)
+	}
+	if ch.PaymentIntent == nil {
+		return nil
+	}
+	p, err := h.svc.Payments.GetPaymentByIntent(ctx, ch.PaymentIntent.ID)
+	if err == service.ErrPaymentNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	o, err := h.svc.Payments.GetOrderBySession(ctx, p.SessionID)
+	if err == service.ErrOrderNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	status, ok := service.ChargeOrderTransition(o, &ch)
+	if !ok {
+		return nil
+	}
+	if err := o.Transition(status); err != nil {
+		slog.Info("ignoring order transition", "order", o.ID, "charge", ch.ID, "error", err)
+		return nil
+	}
+	if err := h.svc.Payments.SaveOrder(ctx, o); err != nil {
+		return err
+	}
+	return h.svc.PublishOrderEvent(ctx, event, o, status)
+}
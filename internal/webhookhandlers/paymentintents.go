@@ -0,0 +1,60 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+func (h *handlers) handlePaymentIntentSucceeded(ctx context.Context, event stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return fmt.Errorf("failed to parse payment intent object: %w", err)
+	}
+	slog.Info("payment intent succeeded",
+		"payment_intent", pi.ID,
+		"amount", pi.Amount,
+		"currency", pi.Currency,
+	)
+	_, err := h.svc.RecordPaymentIntent(ctx, &pi, "paid")
+	return err
+}
+
+func (h *handlers) handlePaymentIntentFailed(ctx context.Context, event stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return fmt.Errorf("failed to parse payment intent object: %w", err)
+	}
+	var reason string
+	if pi.LastPaymentError != nil {
+		reason = pi.LastPaymentError.Msg
+	}
+	// A charge still waiting for the customer to authenticate hasn't failed
+	// yet; Service.ExpireAuthentication cancels it if they never do.
+	if a, err := h.svc.PendingAuthentication(ctx, pi.ID); err != nil || a != nil {
+		return err
+	}
+	slog.Warn("payment intent failed", "payment_intent", pi.ID, "reason", reason)
+	p, err := h.svc.RecordPaymentIntent(ctx, &pi, "failed")
+	if err != nil {
+		return err
+	}
+	// A failed attempt delivered after the payment went through is old news.
+	if p.Status != "failed" {
+		return nil
+	}
+	lines := []string{"Payment intent: " + pi.ID}
+	if reason != "" {
+		lines = append(lines, "Reason: "+reason)
+	}
+	if order := pi.Metadata["order_id"]; order != "" {
+		lines = append(lines, "Order: "+order)
+	}
+	return h.svc.NotifyOps(ctx, config.NotifyPaymentFailed, "Payment failed: "+service.FormatAmount(pi.Amount, string(pi.Currency)), lines...)
+}
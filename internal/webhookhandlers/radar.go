@@ -0,0 +1,79 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// handleChargeRisk records Radar's assessment of a successful charge.
+func (h *handlers) handleChargeRisk(ctx context.Context, event stripe.Event) error {
+	var ch stripe.Charge
+	if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+		return fmt.Errorf("failed to parse charge object: %w", err)
+	}
+	if ch.Outcome == nil || ch.PaymentIntent == nil {
+		return nil
+	}
+	if service.HeldRiskLevels[ch.Outcome.RiskLevel] {
+		slog.Warn("risky charge",
+			"charge", ch.ID,
+			"payment_intent", ch.PaymentIntent.ID,
+			"risk_level", ch.Outcome.RiskLevel,
+			"risk_score", ch.Outcome.RiskScore,
+		)
+	}
+	return h.svc.Payments.SavePaymentRisk(ctx, &service.PaymentRisk{
+		PaymentIntentID: ch.PaymentIntent.ID,
+		ChargeID:        ch.ID,
+		RiskLevel:       ch.Outcome.RiskLevel,
+		RiskScore:       ch.Outcome.RiskScore,
+	})
+}
+
+// handleReviewOpened stores a new review and alerts the operators, whose
+// order stays unfulfilled until they approve it.
+func (h *handlers) handleReviewOpened(ctx context.Context, event stripe.Event) error {
+	var rv stripe.Review
+	if err := json.Unmarshal(event.Data.Raw, &rv); err != nil {
+		return fmt.Errorf("failed to parse review object: %w", err)
+	}
+	rec := service.ReviewFromStripe(&rv)
+	slog.Warn("payment in review", "review", rec.ID, "charge", rec.ChargeID, "reason", rec.OpenedReason)
+	if err := h.svc.Payments.SaveReview(ctx, rec); err != nil {
+		return err
+	}
+	lines := []string{
+		fmt.Sprintf("Radar placed charge %s in review (reason: %s).", rec.ChargeID, rec.OpenedReason),
+		"Review: " + rec.ID,
+	}
+	if rec.PaymentIntentID != "" {
+		lines = append(lines, "Payment intent: "+rec.PaymentIntentID)
+	}
+	lines = append(lines, "Its order isn't fulfilled until the review is approved.")
+	return h.svc.NotifyOps(ctx, config.NotifyReviewOpened, "Payment in review: "+rec.ChargeID, lines...)
+}
+
+// handleReviewClosed stores a closed review and, when it was approved,
+// queues the fulfillment it held back.
+func (h *handlers) handleReviewClosed(ctx context.Context, event stripe.Event) error {
+	var rv stripe.Review
+	if err := json.Unmarshal(event.Data.Raw, &rv); err != nil {
+		return fmt.Errorf("failed to parse review object: %w", err)
+	}
+	rec := service.ReviewFromStripe(&rv)
+	slog.Info("review closed", "review", rec.ID, "reason", rec.ClosedReason)
+	if err := h.svc.Payments.SaveReview(ctx, rec); err != nil {
+		return err
+	}
+	if rv.ClosedReason != stripe.ReviewClosedReasonApproved {
+		return nil
+	}
+	return h.svc.ReleaseFulfillment(ctx, rec.PaymentIntentID)
+}
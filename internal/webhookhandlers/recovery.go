@@ -0,0 +1,144 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// recoveryLinkTTL is how long the link in a recovery email works when Stripe
+// didn't say how long its own recovery URL does. Stripe's last 30 days.
+const recoveryLinkTTL = 30 * 24 * time.Hour
+
+// abandonedCheckout is the record of an expired session, or nil when the
+// customer didn't leave an email address and agree to be contacted, or the
+// session has nothing to recover: no order and no Stripe recovery URL.
+// Sessions that were themselves created to recover another aren't recorded
+// again, so customers get one recovery email per cart.
+func abandonedCheckout(s *stripe.CheckoutSession) *service.AbandonedCheckout {
+	if s.Consent == nil || s.Consent.Promotions != stripe.CheckoutSessionConsentPromotionsOptIn {
+		return nil
+	}
+	if s.Metadata[service.RecoveryMetadataKey] != "" || s.RecoveredFrom != "" {
+		return nil
+	}
+	email := s.CustomerEmail
+	if s.CustomerDetails != nil && s.CustomerDetails.Email != "" {
+		email = s.CustomerDetails.Email
+	}
+	if email == "" {
+		return nil
+	}
+	a := &service.AbandonedCheckout{
+		ID:        "abn_" + service.NewRequestID(),
+		Status:    service.AbandonedPending,
+		SessionID: s.ID,
+		Email:     email,
+		Locale:    s.Locale,
+		Amount:    s.AmountTotal,
+		Currency:  string(s.Currency),
+		ExpiresAt: time.Now().Add(recoveryLinkTTL).UTC(),
+	}
+	if s.Mode == stripe.CheckoutSessionModePayment {
+		a.OrderID = s.Metadata[service.OrderMetadataKey]
+	}
+	if r := s.AfterExpiration; r != nil && r.Recovery != nil && r.Recovery.URL != "" {
+		a.StripeRecoveryURL = r.Recovery.URL
+		if r.Recovery.ExpiresAt != 0 {
+			a.ExpiresAt = time.Unix(r.Recovery.ExpiresAt, 0).UTC()
+		}
+	}
+	if a.OrderID == "" && a.StripeRecoveryURL == "" {
+		return nil
+	}
+	return a
+}
+
+// handleCheckoutExpired reports an abandoned checkout to the operators, if
+// its total reaches NOTIFY_ABANDONED_THRESHOLD, and records it for the
+// recovery email, which is sent RECOVERY_EMAIL_DELAY later.
+func (h *handlers) handleCheckoutExpired(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	if s.Mode == stripe.CheckoutSessionModeSetup {
+		return nil
+	}
+	if h.svc.Config.NotifyAbandonedThreshold > 0 && s.AmountTotal >= h.svc.Config.NotifyAbandonedThreshold {
+		lines := []string{"Checkout session: " + s.ID}
+		if s.CustomerDetails != nil && s.CustomerDetails.Email != "" {
+			lines = append(lines, "Customer: "+s.CustomerDetails.Email)
+		}
+		if order := s.Metadata[service.OrderMetadataKey]; order != "" {
+			lines = append(lines, "Order: "+order)
+		}
+		if err := h.svc.NotifyOps(ctx, config.NotifyCheckoutAbandoned, "Checkout abandoned: "+service.FormatAmount(s.AmountTotal, string(s.Currency)), lines...); err != nil {
+			return err
+		}
+	}
+	a := abandonedCheckout(&s)
+	if a == nil {
+		return nil
+	}
+	_, err := h.svc.Payments.GetAbandonedCheckoutBySession(ctx, s.ID)
+	if err == nil {
+		return nil
+	}
+	if err != service.ErrAbandonedCheckoutNotFound {
+		return err
+	}
+	if err := h.svc.Payments.SaveAbandonedCheckout(ctx, a); err != nil {
+		return err
+	}
+	return h.svc.Jobs.EnqueueAt(ctx, service.JobSendRecoveryEmail, a.ID, time.Now().Add(h.svc.Config.RecoveryEmailDelay))
+}
+
+// handleRecoveredCheckoutCompleted marks the abandoned checkout a completed
+// session recovers, whether it was created by /recover or from Stripe's
+// recovery URL, as recovered.
+func (h *handlers) handleRecoveredCheckoutCompleted(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	var a *service.AbandonedCheckout
+	var err error
+	switch {
+	case s.Metadata[service.RecoveryMetadataKey] != "":
+		id := s.Metadata[service.RecoveryMetadataKey]
+		defer h.svc.LockRecovery(id)()
+		a, err = h.svc.Payments.GetAbandonedCheckout(ctx, id)
+	case s.RecoveredFrom != "":
+		a, err = h.svc.Payments.GetAbandonedCheckoutBySession(ctx, s.RecoveredFrom)
+		if err == nil {
+			defer h.svc.LockRecovery(a.ID)()
+			a, err = h.svc.Payments.GetAbandonedCheckout(ctx, a.ID)
+		}
+	default:
+		return nil
+	}
+	if err == service.ErrAbandonedCheckoutNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if a.Status == service.AbandonedRecovered {
+		return nil
+	}
+	now := time.Now().UTC()
+	slog.Info("abandoned checkout recovered", "abandoned_checkout", a.ID, "session", s.ID)
+	a.Status = service.AbandonedRecovered
+	a.RecoverySessionID = s.ID
+	a.RecoveredAmount = s.AmountTotal
+	a.RecoveredAt = &now
+	return h.svc.Payments.SaveAbandonedCheckout(ctx, a)
+}
@@ -0,0 +1,39 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/servicetest"
+)
+
+func TestCheckoutAbandonedNotification(t *testing.T) {
+	svc, _, emails := servicetest.New(t)
+	h := &handlers{svc: svc}
+	event := stripe.Event{Type: "checkout.session.expired", Data: &stripe.EventData{Raw: json.RawMessage(
+		`{"id":"cs_test_abandoned","mode":"payment","amount_total":2500,"currency":"usd","metadata":{"order":"ord_abandoned"}}`)}}
+	if err := h.handleCheckoutExpired(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	svc.Jobs.RunDue(context.Background(), 0)
+	if len(emails.Sent) != 0 {
+		t.Fatalf("alerted without NOTIFY_ABANDONED_THRESHOLD: %+v", emails.Sent)
+	}
+
+	svc.Config.NotifyAbandonedThreshold = 1000
+	if err := h.handleCheckoutExpired(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	event.Data.Raw = json.RawMessage(`{"id":"cs_test_small","mode":"payment","amount_total":500,"currency":"usd"}`)
+	if err := h.handleCheckoutExpired(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	svc.Jobs.RunDue(context.Background(), 0)
+	if len(emails.Sent) != 1 || emails.Sent[0].Subject != "Checkout abandoned: 25.00 USD" || !strings.Contains(emails.Sent[0].HTML, "ord_abandoned") {
+		t.Errorf("sent %+v, want one alert for cs_test_abandoned", emails.Sent)
+	}
+}
@@ -0,0 +1,62 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleChargeRefunded records refunds made from anywhere, including the
+// Stripe dashboard, and moves the payment to refunded or partially_refunded.
+func (h *handlers) handleChargeRefunded(ctx context.Context, event stripe.Event) error {
+	var ch stripe.Charge
+	if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+		return fmt.Errorf("failed to parse charge object: %w", err)
+	}
+	if ch.PaymentIntent == nil {
+		slog.Info("charge refunded without payment intent", "charge", ch.ID)
+		return nil
+	}
+	slog.Info("charge refunded",
+		"payment_intent", ch.PaymentIntent.ID,
+		"amount_refunded", ch.AmountRefunded,
+	)
+
+	if ch.Refunds != nil {
+		for _, re := range ch.Refunds.Data {
+			rec := &service.Refund{
+				ID:              re.ID,
+				PaymentIntentID: ch.PaymentIntent.ID,
+				Amount:          re.Amount,
+				Currency:        string(re.Currency),
+				Status:          string(re.Status),
+				Reason:          string(re.Reason),
+			}
+			if err := h.svc.Payments.SaveRefund(ctx, rec); err != nil {
+				return err
+			}
+		}
+	}
+
+	p, err := h.svc.Payments.GetPaymentByIntent(ctx, ch.PaymentIntent.ID)
+	if err == service.ErrPaymentNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	status := "partially_refunded"
+	if ch.Refunded {
+		status = "refunded"
+	}
+	previous := p.Status
+	if !service.SetPaymentStatus(p, status) {
+		return nil
+	}
+	return h.svc.SavePayment(ctx, p, previous)
+}
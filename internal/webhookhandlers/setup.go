@@ -0,0 +1,34 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleSetupCheckoutCompleted queues the payment method saved by a setup
+// mode session to become the customer's default.
+func (h *handlers) handleSetupCheckoutCompleted(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	if s.Mode != stripe.CheckoutSessionModeSetup {
+		return nil
+	}
+	if s.Customer == nil || s.SetupIntent == nil {
+		slog.Warn("setup session completed without a customer or setup intent", "session", s.ID)
+		return nil
+	}
+	slog.Info("setup session completed", "session", s.ID, "customer", s.Customer.ID, "setup_intent", s.SetupIntent.ID)
+	return h.svc.Jobs.Enqueue(ctx, service.JobSavePaymentMethod, &service.SavedPaymentMethod{
+		SessionID:   s.ID,
+		Customer:    s.Customer.ID,
+		SetupIntent: s.SetupIntent.ID,
+	})
+}
@@ -0,0 +1,55 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleStoreCreditCheckout settles the credit held by a checkout session:
+// it is redeemed once the session is paid, and released when it expires or
+// its delayed payment fails. Sessions that complete unpaid keep it held
+// until their payment succeeds or fails.
+func (h *handlers) handleStoreCreditCheckout(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	if s.Metadata[service.StoreCreditMetadataKey] == "" {
+		return nil
+	}
+	o, err := h.svc.SessionOrder(ctx, &s)
+	if err == service.ErrOrderNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	status := service.CreditReleased
+	switch event.Type {
+	case "checkout.session.completed":
+		if s.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid {
+			return nil
+		}
+		status = service.CreditRedeemed
+	case "checkout.session.async_payment_succeeded":
+		status = service.CreditRedeemed
+	}
+	r, err := h.svc.Payments.SettleStoreCredit(ctx, o.ID, status)
+	if err == service.ErrRedemptionNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c, err := h.svc.Payments.GetStoreCredit(ctx, r.CreditID)
+	if err != nil {
+		return err
+	}
+	service.LogCtx(ctx).Info("store credit "+status, "order", o.ID, "session", s.ID, "credit", c.ID, "amount", r.Amount, "balance", c.Balance)
+	return nil
+}
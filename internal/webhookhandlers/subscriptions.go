@@ -0,0 +1,103 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// handleSubscriptionChanged stores the subscription carried by every
+// customer.subscription event, including deleted ones, whose status is
+// canceled.
+func (h *handlers) handleSubscriptionChanged(ctx context.Context, event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return fmt.Errorf("failed to parse subscription object: %w", err)
+	}
+	rec := service.SubscriptionFromStripe(&sub)
+	slog.Info("subscription changed",
+		"event_type", event.Type,
+		"subscription", rec.ID,
+		"customer", rec.CustomerID,
+		"status", rec.Status,
+	)
+	if err := h.svc.Payments.SaveSubscription(ctx, rec); err != nil {
+		return err
+	}
+	if event.Type == "customer.subscription.deleted" {
+		return h.svc.PublishSubscriptionCanceled(ctx, event, rec)
+	}
+	return nil
+}
+
+// handleSubscriptionTrialWillEnd tells the operators that a trial ends in
+// three days, which is when Stripe sends the event.
+func (h *handlers) handleSubscriptionTrialWillEnd(ctx context.Context, event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return fmt.Errorf("failed to parse subscription object: %w", err)
+	}
+	rec := service.SubscriptionFromStripe(&sub)
+	lines := []string{"Subscription: " + rec.ID, "Customer: " + rec.CustomerID}
+	if rec.TrialEnd != nil {
+		lines = append(lines, "Trial ends: "+rec.TrialEnd.Format(time.RFC1123))
+	}
+	return h.svc.NotifyOps(ctx, config.NotifySubscriptionTrialEnds, "Subscription trial ending", lines...)
+}
+
+func (h *handlers) handleInvoicePaid(ctx context.Context, event stripe.Event) error {
+	var inv stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+		return fmt.Errorf("failed to parse invoice object: %w", err)
+	}
+	var subID string
+	if inv.Subscription != nil {
+		subID = inv.Subscription.ID
+	}
+	slog.Info("invoice paid",
+		"invoice", inv.ID,
+		"subscription", subID,
+		"amount_paid", inv.AmountPaid,
+		"currency", inv.Currency,
+	)
+	return h.svc.SetInvoiceStatus(ctx, subID, "paid")
+}
+
+// handleInvoicePaymentFailed records a failed renewal and alerts the
+// operators. Stripe retries the invoice and moves the subscription to
+// past_due in a customer.subscription.updated event of its own.
+func (h *handlers) handleInvoicePaymentFailed(ctx context.Context, event stripe.Event) error {
+	var inv stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+		return fmt.Errorf("failed to parse invoice object: %w", err)
+	}
+	var subID, customerID string
+	if inv.Subscription != nil {
+		subID = inv.Subscription.ID
+	}
+	if inv.Customer != nil {
+		customerID = inv.Customer.ID
+	}
+	slog.Warn("invoice payment failed",
+		"invoice", inv.ID,
+		"subscription", subID,
+		"customer", customerID,
+		"attempt_count", inv.AttemptCount,
+	)
+	if subID == "" {
+		// Reported by handleInvoicePaymentNotification.
+		return nil
+	}
+	if err := h.svc.SetInvoiceStatus(ctx, subID, "payment_failed"); err != nil {
+		return err
+	}
+	lines := []string{"Invoice: " + inv.ID, "Customer: " + customerID, fmt.Sprintf("Attempt: %d", inv.AttemptCount), "Subscription: " + subID}
+	return h.svc.NotifyOps(ctx, config.NotifyInvoicePaymentFailed, "Subscription payment failed: "+service.FormatAmount(inv.AmountDue, string(inv.Currency)), lines...)
+}
@@ -0,0 +1,35 @@
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handleTaxIDCheckoutCompleted records the tax IDs of a completed session
+// and queues the VIES check of its EU VAT numbers. The payment isn't held
+// for it: the check runs afterwards and an invalid number is reported to the
+// operators.
+func (h *handlers) handleTaxIDCheckoutCompleted(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	ids := h.svc.SessionTaxIDs(&s)
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := h.svc.Payments.AddTaxIDs(ctx, ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id.Verification == service.TaxIDPending {
+			return h.svc.Jobs.Enqueue(ctx, service.JobVerifyTaxIDs, s.ID)
+		}
+	}
+	return nil
+}
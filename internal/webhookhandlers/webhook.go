@@ -0,0 +1,179 @@
+// Package webhookhandlers handles the Stripe events a Service receives:
+// it records payments, orders and subscriptions as they change and queues
+// the emails and notifications they call for.
+package webhookhandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"stripe_go/internal/service"
+)
+
+// handlers are the event handlers of a Service.
+type handlers struct {
+	svc *service.Service
+}
+
+// Register adds the event handlers to svc's webhook router.
+func Register(svc *service.Service) {
+	h := &handlers{svc: svc}
+	h.register()
+}
+
+func (h *handlers) register() {
+	for _, t := range eventLogTypes {
+		h.svc.WebhookRouter.On(t, h.handleEventLog)
+	}
+	for _, t := range []string{
+		"checkout.session.completed",
+		"checkout.session.expired",
+		"checkout.session.async_payment_succeeded",
+		"checkout.session.async_payment_failed",
+	} {
+		h.svc.WebhookRouter.On(t, h.handleSessionCacheInvalidation)
+	}
+	h.svc.WebhookRouter.On("checkout.session.completed", h.handleCheckoutSessionCompleted)
+	h.svc.WebhookRouter.On("checkout.session.completed", h.handleInventoryCheckoutCompleted)
+	h.svc.WebhookRouter.On("checkout.session.completed", h.handleOrderCheckoutCompleted)
+	h.svc.WebhookRouter.On("checkout.session.completed", h.handleCheckoutPaidNotification)
+	h.svc.WebhookRouter.On("checkout.session.completed", h.handleSetupCheckoutCompleted)
+	h.svc.WebhookRouter.On("checkout.session.completed", h.handleFulfillmentCheckoutCompleted)
+	h.svc.WebhookRouter.On("checkout.session.completed", h.handleTaxIDCheckoutCompleted)
+	h.svc.WebhookRouter.On("checkout.session.completed", h.handleCartCheckoutCompleted)
+	h.svc.WebhookRouter.On("checkout.session.completed", h.handleRecoveredCheckoutCompleted)
+	h.svc.WebhookRouter.On("checkout.session.completed", h.handleStoreCreditCheckout)
+	h.svc.WebhookRouter.On("checkout.session.expired", h.handleInventoryCheckoutExpired)
+	h.svc.WebhookRouter.On("checkout.session.expired", h.handleOrderCheckoutSettled)
+	h.svc.WebhookRouter.On("checkout.session.expired", h.handleCartCheckoutExpired)
+	h.svc.WebhookRouter.On("checkout.session.expired", h.handleCheckoutExpired)
+	h.svc.WebhookRouter.On("checkout.session.expired", h.handleStoreCreditCheckout)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_succeeded", h.handleCheckoutSessionAsyncPaymentSucceeded)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_succeeded", h.handleInventoryCheckoutCompleted)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_succeeded", h.handleOrderCheckoutSettled)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_succeeded", h.handleCheckoutPaidNotification)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_succeeded", h.handleFulfillmentCheckoutCompleted)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_succeeded", h.handleStoreCreditCheckout)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_failed", h.handleCheckoutSessionAsyncPaymentFailed)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_failed", h.handleInventoryCheckoutExpired)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_failed", h.handleOrderCheckoutSettled)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_failed", h.handleCartCheckoutExpired)
+	h.svc.WebhookRouter.On("checkout.session.async_payment_failed", h.handleStoreCreditCheckout)
+	for _, t := range []string{
+		"customer.subscription.created",
+		"customer.subscription.updated",
+		"customer.subscription.deleted",
+		"customer.subscription.trial_will_end",
+	} {
+		h.svc.WebhookRouter.On(t, h.handleSubscriptionChanged)
+	}
+	h.svc.WebhookRouter.On("customer.subscription.trial_will_end", h.handleSubscriptionTrialWillEnd)
+	h.svc.WebhookRouter.On("invoice.paid", h.handleInvoicePaid)
+	h.svc.WebhookRouter.On("invoice.payment_failed", h.handleInvoicePaymentFailed)
+	for _, t := range []string{
+		"invoice.finalized",
+		"invoice.sent",
+		"invoice.paid",
+		"invoice.payment_failed",
+		"invoice.voided",
+		"invoice.marked_uncollectible",
+	} {
+		h.svc.WebhookRouter.On(t, h.handleInvoiceChanged)
+	}
+	h.svc.WebhookRouter.On("invoice.paid", h.handleInvoicePaymentNotification)
+	h.svc.WebhookRouter.On("invoice.payment_failed", h.handleInvoicePaymentNotification)
+	h.svc.WebhookRouter.On("charge.refunded", h.handleChargeRefunded)
+	h.svc.WebhookRouter.On("charge.refunded", h.handleOrderChargeRefunded)
+	for _, t := range []string{
+		"charge.dispute.created",
+		"charge.dispute.updated",
+		"charge.dispute.closed",
+	} {
+		h.svc.WebhookRouter.On(t, h.handleDisputeChanged)
+	}
+	h.svc.WebhookRouter.On("charge.dispute.created", h.handleChargeDisputeCreated)
+	h.svc.WebhookRouter.On("charge.dispute.closed", h.handleChargeDisputeClosed)
+	h.svc.WebhookRouter.On("charge.succeeded", h.handleChargeRisk)
+	h.svc.WebhookRouter.On("review.opened", h.handleReviewOpened)
+	h.svc.WebhookRouter.On("review.closed", h.handleReviewClosed)
+	h.svc.WebhookRouter.On("payment_intent.succeeded", h.handlePaymentIntentSucceeded)
+	h.svc.WebhookRouter.On("payment_intent.succeeded", h.handleOrderPaymentIntent)
+	h.svc.WebhookRouter.On("payment_intent.succeeded", h.handleFulfillmentPaymentIntent)
+	h.svc.WebhookRouter.On("payment_intent.payment_failed", h.handleAuthenticationFailed)
+	h.svc.WebhookRouter.On("payment_intent.payment_failed", h.handlePaymentIntentFailed)
+	h.svc.WebhookRouter.On("payment_intent.requires_action", h.handlePaymentIntentRequiresAction)
+	h.svc.WebhookRouter.On("payment_intent.amount_capturable_updated", h.handlePaymentIntentAuthorized)
+	h.svc.WebhookRouter.On("payment_intent.canceled", h.handlePaymentIntentCanceled)
+	h.svc.WebhookRouter.On("payment_intent.canceled", h.handleOrderPaymentIntent)
+	h.svc.WebhookRouter.On("payment_intent.succeeded", h.handleAuthenticationSettled)
+	h.svc.WebhookRouter.On("payment_intent.canceled", h.handleAuthenticationSettled)
+	h.svc.WebhookRouter.On("invoice.payment_action_required", h.handleInvoicePaymentActionRequired)
+	h.svc.WebhookRouter.On("account.updated", h.handleAccountUpdated)
+}
+
+func (h *handlers) handleCheckoutSessionCompleted(ctx context.Context, event stripe.Event) error {
+	var sessionObj stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &sessionObj); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+
+	// Subscription mode sessions have no payment intent.
+	var paymentIntentID string
+	if sessionObj.PaymentIntent != nil {
+		paymentIntentID = sessionObj.PaymentIntent.ID
+	}
+
+	slog.Info("checkout session completed",
+		"session", sessionObj.ID,
+		"payment_intent", paymentIntentID,
+		"payment_status", sessionObj.PaymentStatus,
+		"amount", sessionObj.AmountTotal,
+		"currency", sessionObj.Currency,
+		"metadata", sessionObj.Metadata,
+	)
+
+	// Setup mode sessions save a card without taking a payment; see
+	// handleSetupCheckoutCompleted.
+	if sessionObj.Mode == stripe.CheckoutSessionModeSetup {
+		return nil
+	}
+	if err := h.svc.Jobs.Enqueue(ctx, service.JobUpdatePaymentStatus, event.Data.Raw); err != nil {
+		return err
+	}
+	// Delayed payment methods such as SEPA Direct Debit complete the session
+	// before the money arrives; the receipt waits for
+	// checkout.session.async_payment_succeeded.
+	if sessionObj.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid {
+		slog.Info("checkout session awaiting async payment", "session", sessionObj.ID)
+		return nil
+	}
+	return h.svc.QueueConfirmationEmail(ctx, &sessionObj)
+}
+
+func (h *handlers) handleCheckoutSessionAsyncPaymentSucceeded(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	slog.Info("checkout session async payment succeeded", "session", s.ID, "amount", s.AmountTotal, "currency", s.Currency)
+	if err := h.svc.Jobs.Enqueue(ctx, service.JobUpdatePaymentStatus, event.Data.Raw); err != nil {
+		return err
+	}
+	return h.svc.QueueConfirmationEmail(ctx, &s)
+}
+
+func (h *handlers) handleCheckoutSessionAsyncPaymentFailed(ctx context.Context, event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("failed to parse session object: %w", err)
+	}
+	slog.Warn("checkout session async payment failed", "session", s.ID)
+	// The session stays "unpaid"; record the failure so it isn't mistaken
+	// for a payment that is still processing.
+	s.PaymentStatus = "failed"
+	return h.svc.UpdatePaymentStatus(ctx, &s)
+}
@@ -0,0 +1,45 @@
+package stripego
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"stripe_go/internal/config"
+)
+
+// redactedKeys are log attribute keys whose values are never written out.
+var redactedKeys = map[string]bool{
+	"authorization":    true,
+	"stripe_signature": true,
+	"webhook_secret":   true,
+	"secret_key":       true,
+	"password":         true,
+	"api_key":          true,
+}
+
+// setupLogging installs the default slog logger. LOG_FORMAT picks "json"
+// (default) or "text", and LOG_LEVEL one of debug, info, warn, error. Every
+// record carries the Stripe mode so test and live logs can't be confused.
+func setupLogging(c *config.Config) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if redactedKeys[strings.ToLower(a.Key)] {
+				return slog.String(a.Key, "[REDACTED]")
+			}
+			return a
+		},
+	}
+	var h slog.Handler
+	if c.LogFormat == "text" {
+		h = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		h = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(h).With("mode", c.Mode))
+}
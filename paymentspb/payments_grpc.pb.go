@@ -0,0 +1,220 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: paymentspb/payments.proto
+
+package paymentspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Payments_CreateCheckout_FullMethodName = "/payments.v1.Payments/CreateCheckout"
+	Payments_GetPayment_FullMethodName     = "/payments.v1.Payments/GetPayment"
+	Payments_CreateRefund_FullMethodName   = "/payments.v1.Payments/CreateRefund"
+	Payments_ListPayments_FullMethodName   = "/payments.v1.Payments/ListPayments"
+)
+
+// PaymentsClient is the client API for Payments service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PaymentsClient interface {
+	CreateCheckout(ctx context.Context, in *CreateCheckoutRequest, opts ...grpc.CallOption) (*CreateCheckoutResponse, error)
+	GetPayment(ctx context.Context, in *GetPaymentRequest, opts ...grpc.CallOption) (*Payment, error)
+	CreateRefund(ctx context.Context, in *CreateRefundRequest, opts ...grpc.CallOption) (*Refund, error)
+	ListPayments(ctx context.Context, in *ListPaymentsRequest, opts ...grpc.CallOption) (*ListPaymentsResponse, error)
+}
+
+type paymentsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPaymentsClient(cc grpc.ClientConnInterface) PaymentsClient {
+	return &paymentsClient{cc}
+}
+
+func (c *paymentsClient) CreateCheckout(ctx context.Context, in *CreateCheckoutRequest, opts ...grpc.CallOption) (*CreateCheckoutResponse, error) {
+	out := new(CreateCheckoutResponse)
+	err := c.cc.Invoke(ctx, Payments_CreateCheckout_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentsClient) GetPayment(ctx context.Context, in *GetPaymentRequest, opts ...grpc.CallOption) (*Payment, error) {
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, Payments_GetPayment_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentsClient) CreateRefund(ctx context.Context, in *CreateRefundRequest, opts ...grpc.CallOption) (*Refund, error) {
+	out := new(Refund)
+	err := c.cc.Invoke(ctx, Payments_CreateRefund_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentsClient) ListPayments(ctx context.Context, in *ListPaymentsRequest, opts ...grpc.CallOption) (*ListPaymentsResponse, error) {
+	out := new(ListPaymentsResponse)
+	err := c.cc.Invoke(ctx, Payments_ListPayments_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PaymentsServer is the server API for Payments service.
+// All implementations must embed UnimplementedPaymentsServer
+// for forward compatibility
+type PaymentsServer interface {
+	CreateCheckout(context.Context, *CreateCheckoutRequest) (*CreateCheckoutResponse, error)
+	GetPayment(context.Context, *GetPaymentRequest) (*Payment, error)
+	CreateRefund(context.Context, *CreateRefundRequest) (*Refund, error)
+	ListPayments(context.Context, *ListPaymentsRequest) (*ListPaymentsResponse, error)
+	mustEmbedUnimplementedPaymentsServer()
+}
+
+// UnimplementedPaymentsServer must be embedded to have forward compatible implementations.
+type UnimplementedPaymentsServer struct {
+}
+
+func (UnimplementedPaymentsServer) CreateCheckout(context.Context, *CreateCheckoutRequest) (*CreateCheckoutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateCheckout not implemented")
+}
+func (UnimplementedPaymentsServer) GetPayment(context.Context, *GetPaymentRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPayment not implemented")
+}
+func (UnimplementedPaymentsServer) CreateRefund(context.Context, *CreateRefundRequest) (*Refund, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateRefund not implemented")
+}
+func (UnimplementedPaymentsServer) ListPayments(context.Context, *ListPaymentsRequest) (*ListPaymentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPayments not implemented")
+}
+func (UnimplementedPaymentsServer) mustEmbedUnimplementedPaymentsServer() {}
+
+// UnsafePaymentsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PaymentsServer will
+// result in compilation errors.
+type UnsafePaymentsServer interface {
+	mustEmbedUnimplementedPaymentsServer()
+}
+
+func RegisterPaymentsServer(s grpc.ServiceRegistrar, srv PaymentsServer) {
+	s.RegisterService(&Payments_ServiceDesc, srv)
+}
+
+func _Payments_CreateCheckout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCheckoutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentsServer).CreateCheckout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Payments_CreateCheckout_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentsServer).CreateCheckout(ctx, req.(*CreateCheckoutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Payments_GetPayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentsServer).GetPayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Payments_GetPayment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentsServer).GetPayment(ctx, req.(*GetPaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Payments_CreateRefund_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateRefundRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentsServer).CreateRefund(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Payments_CreateRefund_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentsServer).CreateRefund(ctx, req.(*CreateRefundRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Payments_ListPayments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPaymentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentsServer).ListPayments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Payments_ListPayments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentsServer).ListPayments(ctx, req.(*ListPaymentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Payments_ServiceDesc is the grpc.ServiceDesc for Payments service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Payments_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "payments.v1.Payments",
+	HandlerType: (*PaymentsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateCheckout",
+			Handler:    _Payments_CreateCheckout_Handler,
+		},
+		{
+			MethodName: "GetPayment",
+			Handler:    _Payments_GetPayment_Handler,
+		},
+		{
+			MethodName: "CreateRefund",
+			Handler:    _Payments_CreateRefund_Handler,
+		},
+		{
+			MethodName: "ListPayments",
+			Handler:    _Payments_ListPayments_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "paymentspb/payments.proto",
+}
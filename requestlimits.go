@@ -0,0 +1,22 @@
+package stripego
+
+import (
+	"net/http"
+
+	"stripe_go/internal/config"
+)
+
+// newHTTPServer returns a server for handler on addr with the
+// READ_HEADER_TIMEOUT, READ_TIMEOUT, WRITE_TIMEOUT and IDLE_TIMEOUT limits,
+// so clients that send or read slowly, or hold idle connections open,
+// can't tie up connections.
+func newHTTPServer(c *config.Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+	}
+}
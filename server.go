@@ -1,228 +1,234 @@
-package main
+// Package stripego runs the payments service: Stripe Checkout,
+// subscriptions, orders and their webhooks behind one HTTP API. cmd/server
+// runs it as a standalone server; other programs can mount it in their own
+// with NewServer. The service itself is in the internal packages, which
+// NewServer puts together.
+package stripego
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/checkout/session"
-	"github.com/stripe/stripe-go/v72/price"
-	"github.com/stripe/stripe-go/v72/webhook"
-)
-
-func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-	checkEnv()
-
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-
-	http.Handle("/", http.FileServer(http.Dir(os.Getenv("STATIC_DIR"))))
-	http.HandleFunc("/config", handleConfig)
-	http.HandleFunc("/checkout-session", handleCheckoutSession)
-	http.HandleFunc("/create-checkout-session", handleCreateCheckoutSession)
-	http.HandleFunc("/webhook", handleWebhook)
-	http.HandleFunc("/html/success.html", handleSuccessPage)
-
-	log.Println("server running at 0.0.0.0:4242")
-	http.ListenAndServe("0.0.0.0:4242", nil)
-}
-
-type ErrorResponseMessage struct {
-	Message string `json:"message"`
-}
-
-type ErrorResponse struct {
-	Error *ErrorResponseMessage `json:"error"`
-}
 
-func handleConfig(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
-		return
-	}
-	p, _ := price.Get(
-		os.Getenv("PRICE"),
-		nil,
-	)
-	writeJSON(w, struct {
-		PublicKey  string `json:"publicKey"`
-		UnitAmount int64  `json:"unitAmount"`
-		Currency   string `json:"currency"`
-	}{
-		PublicKey:  os.Getenv("STRIPE_SECRET_KEY"),
-		UnitAmount: p.UnitAmount,
-		Currency:   string(p.Currency),
-	})
-}
+	"stripe_go/internal/config"
+	"stripe_go/internal/httpapi"
+	"stripe_go/internal/service"
+	"stripe_go/internal/store"
+	"stripe_go/internal/webhookhandlers"
+)
 
-func handleCheckoutSession(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
-		return
+// Run runs the server as cmd/server does, with its command line arguments.
+// The poll-events subcommand also polls the Events API for webhooks; the
+// others are the admin commands in cliCommands.
+func Run(args []string) error {
+	pollEvents := false
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && args[0] == service.PollEventsCommand:
+		pollEvents = true
+	case cliCommands[args[0]].run != nil:
+		// The commands take their defaults from .env too.
+		if err := loadDotEnv(); err != nil {
+			return err
+		}
+		return runCommand(args[0], args[1:], os.Stdout)
+	default:
+		return fmt.Errorf("unknown arguments %q; the subcommands are %s", args, strings.Join(append(cliCommandNames(), service.PollEventsCommand), ", "))
 	}
-	sessionID := r.URL.Query().Get("sessionId")
-	s, _ := session.Get(sessionID, nil)
-	writeJSON(w, s)
-}
 
-func handleCreateCheckoutSession(w http.ResponseWriter, r *http.Request) {
-	r.ParseForm()
-	quantity, err := strconv.ParseInt(r.PostFormValue("quantity")[0:], 10, 64)
+	cfg, err := LoadConfig()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error parsing quantity %v", err.Error()), http.StatusInternalServerError)
-		return
-	}
-	domainURL := os.Getenv("DOMAIN")
-
-	params := &stripe.CheckoutSessionParams{
-		SuccessURL: stripe.String(domainURL + "/html/success.html?session_id={CHECKOUT_SESSION_ID}"),
-		CancelURL:  stripe.String(domainURL + "/canceled.html"),
-		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
-		LineItems: []*stripe.CheckoutSessionLineItemParams{
-			{
-				Quantity: stripe.Int64(quantity),
-				Price:    stripe.String(os.Getenv("PRICE")),
-			},
-		},
-	}
-	s, err := session.New(params)
+		return err
+	}
+	setupLogging(cfg)
+	shutdownTracing, err := setupTracing(context.Background(), cfg)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error while creating session %v", err.Error()), http.StatusInternalServerError)
-		return
+		return err
 	}
-
-	http.Redirect(w, r, s.URL, http.StatusSeeOther)
-}
-func handleWebhook(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
-		return
-	}
-	const MaxBodyBytes = int64(65536)
-	r.Body = http.MaxBytesReader(w, r.Body, MaxBodyBytes)
-	payload, err := ioutil.ReadAll(r.Body)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("flushing traces", "error", err)
+		}
+	}()
+	s, err := NewServer(cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading request body: %v\n", err)
-		w.WriteHeader(http.StatusServiceUnavailable)
-		return
+		return err
 	}
-
-	event := stripe.Event{}
-
-	if err := json.Unmarshal(payload, &event); err != nil {
-		fmt.Fprintf(os.Stderr, "⚠️  Webhook error while parsing basic request. %v\n", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	defer s.Close()
+	go s.svc.ReloadOnSIGHUP(s.ctx)
+	if pollEvents {
+		if err := s.svc.StartEventPolling(s.ctx); err != nil {
+			return err
+		}
 	}
 
-	signatureHeader := r.Header.Get("Stripe-Signature")
-	fmt.Println(signatureHeader)
-	fmt.Println(os.Getenv("STRIPE_WEBHOOK_SECRET"))
-
-	err = webhook.ValidatePayload(payload, signatureHeader, os.Getenv("STRIPE_WEBHOOK_SECRET"))
+	srv := newHTTPServer(cfg, net.JoinHostPort(cfg.Host, cfg.Port), s)
+	servers := []*http.Server{srv}
+	redirect, err := configureTLS(cfg, srv)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "⚠️  Webhook error while validating signature. %v\n", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		return err
 	}
-
-	event, err = webhook.ConstructEvent(payload, signatureHeader, os.Getenv("STRIPE_WEBHOOK_SECRET"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		log.Printf("webhook.ConstructEvent: %v", err)
-		return
+	if redirect != nil {
+		servers = append(servers, redirect)
 	}
-
-	if event.Type == "checkout.session.completed" {
-		fmt.Println("Checkout Session completed!")
-
-		var sessionObj stripe.CheckoutSession
-		if err := json.Unmarshal(event.Data.Raw, &sessionObj); err != nil {
-			fmt.Fprintln(os.Stderr, "Failed to parse session object:", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		fmt.Println("Payment Intent ID:", sessionObj.PaymentIntent.ID)
-		fmt.Println("Payment Status:", sessionObj.PaymentStatus)
-		fmt.Println("Payment Amount:", sessionObj.AmountTotal)
-		fmt.Println("Currency:", sessionObj.Currency)
-
-		confirmationEmailData := map[string]interface{}{
-			"paymentIntentID": sessionObj.PaymentIntent.ID,
-			"paymentStatus":   sessionObj.PaymentStatus,
-			"paymentAmount":   sessionObj.AmountTotal,
-			"currency":        sessionObj.Currency,
+	if cfg.GRPCPort != "" {
+		stopGRPC, err := s.startGRPC(net.JoinHostPort(cfg.Host, cfg.GRPCPort), cfg.ShutdownTimeout)
+		if err != nil {
+			return err
 		}
+		defer stopGRPC()
+	}
+	return serve(cfg.ShutdownTimeout, servers...)
+}
 
-		sendConfirmationEmail(confirmationEmailData)
-		updatePaymentStatus(confirmationEmailData)
+// Config is the configuration of a Server.
+type Config = config.Config
 
-		writeJSON(w, map[string]interface{}{
-			"success": true,
-			"message": "Payment success",
-		})
-	} else {
-		fmt.Printf("Received event of type: %s\n", event.Type)
+// LoadConfig reads the configuration from .env, the environment and
+// CONFIG_FILE, with the defaults filled in, as the server does on start.
+func LoadConfig() (*Config, error) {
+	if err := loadDotEnv(); err != nil {
+		return nil, err
+	}
+	c, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
 	}
+	return c, nil
 }
 
-func sendConfirmationEmail(sessionObject map[string]interface{}) {
-	fmt.Println("Sending confirmation email...")
+// Server is the service's HTTP API, with the stores, job queue and
+// background work behind it. Each Server has a Service of its own, so a
+// process can run several, e.g. one per Stripe account. The process-wide
+// logger and tracer provider are left to the program embedding it; Run sets
+// them up from the configuration.
+type Server struct {
+	svc     *service.Service
+	handler http.Handler
+	// ctx is cancelled by Close, stopping the background work.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func updatePaymentStatus(sessionObject map[string]interface{}) {
-	fmt.Println("Updating payment status...")
-}
+// NewServer sets the service up from cfg, which usually comes from
+// LoadConfig: it loads the catalog from Stripe, opens the payment store,
+// event store, cache and job queue, and starts the background work. The
+// Server serves every route, so it can be mounted under a prefix with
+// http.StripPrefix; webhooks then arrive at that prefix plus /webhook. Close
+// stops it.
+func NewServer(cfg *Config) (s *Server, err error) {
+	st, err := store.Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	svc := service.New(cfg, st, embeddedAssets())
+	s = &Server{svc: svc, ctx: ctx, cancel: cancel}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+	if err := svc.UseSettings(ctx, cfg); err != nil {
+		return nil, err
+	}
+	webhookhandlers.Register(svc)
+	if cfg.WebhookAutoRegister {
+		if err := svc.RegisterWebhookEndpoint(ctx); err != nil {
+			return nil, fmt.Errorf("Error registering webhook endpoint: %w", err)
+		}
+	}
+	if err := svc.SetUp(ctx); err != nil {
+		return nil, err
+	}
+	svc.Start(ctx)
 
-func writeJSON(w http.ResponseWriter, v interface{}) {
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(v); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Printf("json.NewEncoder.Encode: %v", err)
-		return
+	if cfg.DevReplayEnabled {
+		slog.Warn("serving /dev/replay-event: unsigned webhook events will be processed")
 	}
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := io.Copy(w, &buf); err != nil {
-		log.Printf("io.Copy: %v", err)
-		return
+	if cfg.EmailPreviewEnabled {
+		slog.Warn("serving /dev/email-preview")
 	}
+	s.handler = httpapi.New(svc)
+	return s, nil
 }
 
-func writeJSONError(w http.ResponseWriter, v interface{}, code int) {
-	w.WriteHeader(code)
-	writeJSON(w, v)
-	return
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
 }
 
-func writeJSONErrorMessage(w http.ResponseWriter, message string, code int) {
-	resp := &ErrorResponse{
-		Error: &ErrorResponseMessage{
-			Message: message,
-		},
+// Close stops the background work and closes the payment store. Requests
+// still being served may fail.
+func (s *Server) Close() error {
+	s.cancel()
+	if s.svc.Payments != nil {
+		return s.svc.Payments.Close()
 	}
-	writeJSONError(w, resp, code)
+	return nil
 }
 
-func checkEnv() {
-	price := os.Getenv("PRICE")
-	fmt.Println("price: " + price)
-	if price == "price_12345" || price == "" {
-		log.Fatal("You must set a Price ID from your Stripe account. See the README for instructions.")
+// loadDotEnv adds the settings in .env to the environment. It is optional;
+// settings can also come from the environment or CONFIG_FILE.
+func loadDotEnv() error {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Error loading .env file: %w", err)
 	}
+	return nil
 }
 
-func handleSuccessPage(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "html/success.html")
+// serve runs the servers until one fails or the process receives SIGINT or
+// SIGTERM, then gives in-flight requests up to drainTimeout to finish. The
+// contexts of requests still running after that are cancelled.
+func serve(drainTimeout time.Duration, servers ...*http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	errc := make(chan error, len(servers))
+	for _, srv := range servers {
+		srv := srv
+		srv.BaseContext = func(net.Listener) context.Context { return requestCtx }
+		go func() {
+			slog.Info("server running", "addr", srv.Addr, "tls", srv.TLSConfig != nil)
+			errc <- listenAndServe(srv)
+		}()
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-errc:
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutting down", "drain_timeout", drainTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			cancelRequests()
+			return fmt.Errorf("graceful shutdown: %w", err)
+		}
+	}
+	if serveErr != nil {
+		return serveErr
+	}
+	if err := <-errc; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
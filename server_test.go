@@ -0,0 +1,214 @@
+package stripego
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72/webhook"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/httpapi"
+	"stripe_go/internal/service"
+	"stripe_go/internal/servicetest"
+	"stripe_go/internal/webhookhandlers"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testEnv is a Service from servicetest behind the HTTP API, for the tests
+// of the CLI and the gRPC server, which talk to it as clients.
+type testEnv struct {
+	t       *testing.T
+	svc     *service.Service
+	stripe  *servicetest.Stripe
+	emails  *servicetest.EmailSender
+	handler http.Handler
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	svc, fake, emails := servicetest.New(t)
+	webhookhandlers.Register(svc)
+	return &testEnv{t: t, svc: svc, stripe: fake, emails: emails, handler: httpapi.New(svc)}
+}
+
+// do sends a request to the server, with body as JSON unless it is nil.
+func (e *testEnv) do(method, target string, body interface{}, header ...string) *httptest.ResponseRecorder {
+	e.t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	e.handler.ServeHTTP(w, req)
+	return w
+}
+
+// admin is do with the admin bearer token.
+func (e *testEnv) admin(method, target string, body interface{}) *httptest.ResponseRecorder {
+	e.t.Helper()
+	return e.do(method, target, body, "Authorization", "Bearer "+servicetest.AdminToken)
+}
+
+// runJobs runs every due job until the queue is empty.
+func (e *testEnv) runJobs() {
+	e.svc.Jobs.RunDue(context.Background(), 0)
+}
+
+// seedPayment stores a paid checkout of pi_test_seed.
+func (e *testEnv) seedPayment() {
+	e.t.Helper()
+	err := e.svc.Payments.SavePayment(context.Background(), &service.Payment{
+		SessionID:       "cs_test_seed",
+		PaymentIntentID: "pi_test_seed",
+		Amount:          3000,
+		Currency:        "usd",
+		Status:          "paid",
+	})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// paidCheckout creates a checkout of items and delivers the event of its
+// payment by jenny@example.com.
+func (e *testEnv) paidCheckout(items ...service.CheckoutItem) service.CreateCheckoutResponse {
+	e.t.Helper()
+	w := e.do("POST", "/create-checkout-session", map[string]interface{}{"items": items})
+	checkStatus(e.t, w, http.StatusOK)
+	var resp service.CreateCheckoutResponse
+	decodeBody(e.t, w, &resp)
+	payload := []byte(fmt.Sprintf(`{"id": "evt_%s", "object": "event", "type": "checkout.session.completed", "data": {"object": {
+		"id": %q, "object": "checkout.session", "payment_intent": "pi_%s", "payment_status": "paid",
+		"amount_total": 3000, "currency": "usd", "customer_details": {"email": "jenny@example.com"},
+		"metadata": {"order": %q}}}}`, resp.ID, resp.ID, resp.ID, resp.OrderID))
+	now := time.Now()
+	signature := fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(webhook.ComputeSignature(now, payload, servicetest.WebhookSecret)))
+	req := httptest.NewRequest("POST", "/webhook", bytes.NewReader(payload))
+	req.Header.Set("Stripe-Signature", signature)
+	w = httptest.NewRecorder()
+	e.handler.ServeHTTP(w, req)
+	checkStatus(e.t, w, http.StatusOK)
+	e.runJobs()
+	return resp
+}
+
+func (e *testEnv) order(id string) *service.Order {
+	e.t.Helper()
+	o, err := e.svc.Payments.GetOrder(context.Background(), id)
+	if err != nil {
+		e.t.Fatalf("GetOrder(%s): %v", id, err)
+	}
+	return o
+}
+
+func checkStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, want, w.Body)
+	}
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+}
+
+// TestNewServer mounts the server in another mux, as programs embedding it
+// do, running against the mock Stripe.
+func TestNewServer(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MODE", "mock")
+	t.Setenv("DATABASE_URL", filepath.Join(dir, "payments.db"))
+	t.Setenv("JOB_QUEUE_FILE", filepath.Join(dir, "jobs.json"))
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/payments/", http.StripPrefix("/payments", s))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/payments/config", nil))
+	checkStatus(t, w, http.StatusOK)
+	var got service.ConfigResponse
+	decodeBody(t, w, &got)
+	if got.Mode != "mock" || got.Price != config.MockPrice {
+		t.Errorf("config = %+v", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The payment store is closed with the server.
+	if err := s.svc.Payments.Ping(context.Background()); err == nil {
+		t.Error("payment store still open after Close")
+	}
+}
+
+// TestNewServerTwice runs two servers in one process, which keep their own
+// configuration and stores.
+func TestNewServerTwice(t *testing.T) {
+	t.Setenv("MODE", "mock")
+	servers := map[string]*Server{}
+	for _, key := range []string{"pk_test_first", "pk_test_second"} {
+		dir := t.TempDir()
+		t.Setenv("STRIPE_PUBLISHABLE_KEY", key)
+		t.Setenv("DATABASE_URL", filepath.Join(dir, "payments.db"))
+		t.Setenv("JOB_QUEUE_FILE", filepath.Join(dir, "jobs.json"))
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := NewServer(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		servers[key] = s
+	}
+	servers["pk_test_first"].Close()
+	for key, s := range servers {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/config", nil))
+		checkStatus(t, w, http.StatusOK)
+		var got service.ConfigResponse
+		decodeBody(t, w, &got)
+		if got.PublishableKey != key {
+			t.Errorf("publishable key = %s, want %s", got.PublishableKey, key)
+		}
+	}
+	if err := servers["pk_test_second"].svc.Payments.Ping(context.Background()); err != nil {
+		t.Errorf("closing one server closed the other's store: %v", err)
+	}
+}
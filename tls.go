@@ -0,0 +1,91 @@
+package stripego
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"stripe_go/internal/config"
+)
+
+// configureTLS sets up srv to serve HTTPS from either TLS_CERT_FILE and
+// TLS_KEY_FILE or a Let's Encrypt certificate for TLS_AUTOCERT_DOMAINS. It
+// returns the HTTP server for HTTP_REDIRECT_PORT, or nil when it isn't set.
+// Autocert also answers its HTTP-01 challenges on that server.
+func configureTLS(c *config.Config, srv *http.Server) (*http.Server, error) {
+	var challenge func(http.Handler) http.Handler
+	switch {
+	case len(c.TLSAutocertDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.TLSAutocertDomains...),
+			Cache:      autocert.DirCache(c.TLSAutocertCacheDir),
+		}
+		srv.TLSConfig = m.TLSConfig()
+		challenge = m.HTTPHandler
+	case c.TLSCertFile != "":
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	default:
+		return nil, nil
+	}
+	srv.Handler = withHSTS(c, srv.Handler)
+
+	if c.HTTPRedirectPort == "" {
+		return nil, nil
+	}
+	redirect := redirectToHTTPS(c.Port)
+	if challenge != nil {
+		redirect = challenge(redirect)
+	}
+	return newHTTPServer(c, net.JoinHostPort(c.Host, c.HTTPRedirectPort), redirect), nil
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the HTTPS
+// listener, which listens on port.
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+// withHSTS tells browsers to only use HTTPS for HSTSMaxAge.
+func withHSTS(c *config.Config, next http.Handler) http.Handler {
+	if c.HSTSMaxAge <= 0 {
+		return next
+	}
+	value := "max-age=" + strconv.Itoa(int(c.HSTSMaxAge.Seconds())) + "; includeSubDomains"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listenAndServe serves HTTPS when configureTLS gave srv a TLS config and
+// plain HTTP otherwise.
+func listenAndServe(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,46 @@
+package stripego
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"stripe_go/internal/config"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	for _, tt := range []struct {
+		port string
+		want string
+	}{
+		{"443", "https://shop.example.com/products?id=1"},
+		{"8443", "https://shop.example.com:8443/products?id=1"},
+	} {
+		w := httptest.NewRecorder()
+		redirectToHTTPS(tt.port).ServeHTTP(w, httptest.NewRequest("GET", "http://shop.example.com:80/products?id=1", nil))
+		checkStatus(t, w, http.StatusPermanentRedirect)
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("port %s: Location = %q, want %q", tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestWithHSTS(t *testing.T) {
+	h := withHSTS(&config.Config{HSTSMaxAge: 24 * time.Hour}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("HSTS sent over plain HTTP: %q", got)
+	}
+
+	r.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=86400; includeSubDomains" {
+		t.Errorf("Strict-Transport-Security = %q", got)
+	}
+}
@@ -0,0 +1,47 @@
+package stripego
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"stripe_go/internal/config"
+	"stripe_go/internal/service"
+)
+
+// setupTracing installs the global tracer provider. Without
+// OTLPTracesEndpoint nothing is recorded: spans are no-ops and only
+// incoming trace context is passed on. The returned function flushes
+// buffered spans and must be called before exiting.
+func setupTracing(ctx context.Context, c *config.Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(service.TracePropagator, propagation.Baggage{}))
+	if c.OTLPTracesEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(c.OTLPTracesEndpoint),
+		otlptracehttp.WithHeaders(c.OTLPHeaders))
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", c.ServiceName),
+		attribute.String("deployment.environment", c.Mode),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.TraceSampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}